@@ -0,0 +1,117 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package admin
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// API is the admin API of the provisioner, served as JSON over HTTP for
+// Client and over gRPC for GRPCClient.
+type API interface {
+	Volumes() ([]Volume, error)
+	Pools() ([]Pool, error)
+	Operations() ([]Operation, error)
+	GC(dryRun bool) (*GCResult, error)
+	Reconcile() (*ReconcileResult, error)
+	Seal(volume string) (*SealResult, error)
+}
+
+// Client talks to the admin API of the provisioner on this node.
+type Client struct {
+	http *http.Client
+}
+
+// NewClient returns a client for the admin API served on socket.
+func NewClient(socket string, timeout time.Duration) *Client {
+	return &Client{http: &http.Client{
+		Timeout: timeout,
+		Transport: &http.Transport{
+			DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+				var dialer net.Dialer
+				return dialer.DialContext(ctx, "unix", socket)
+			},
+		},
+	}}
+}
+
+// Volumes lists the volumes provisioned on the node.
+func (c *Client) Volumes() ([]Volume, error) {
+	volumes := []Volume{}
+	return volumes, c.do(http.MethodGet, VolumesPath, &volumes)
+}
+
+// Pools returns the capacity of the storage pools.
+func (c *Client) Pools() ([]Pool, error) {
+	pools := []Pool{}
+	return pools, c.do(http.MethodGet, PoolsPath, &pools)
+}
+
+// Operations returns the recent operations, newest first.
+func (c *Client) Operations() ([]Operation, error) {
+	operations := []Operation{}
+	return operations, c.do(http.MethodGet, OperationsPath, &operations)
+}
+
+// GC removes the orphaned backing directories, or only lists them if dryRun
+// is set.
+func (c *Client) GC(dryRun bool) (*GCResult, error) {
+	path := GCPath
+	if dryRun {
+		path += "?dryRun=true"
+	}
+	result := &GCResult{}
+	return result, c.do(http.MethodPost, path, result)
+}
+
+// Reconcile queues all claims and volumes for another pass.
+func (c *Client) Reconcile() (*ReconcileResult, error) {
+	result := &ReconcileResult{}
+	return result, c.do(http.MethodPost, ReconcilePath, result)
+}
+
+// Seal seals the image backed volume with dm-verity, making it read-only.
+func (c *Client) Seal(volume string) (*SealResult, error) {
+	result := &SealResult{}
+	return result, c.do(http.MethodPost, SealPath+"?volume="+url.QueryEscape(volume), result)
+}
+
+func (c *Client) do(method, path string, result interface{}) error {
+	// The host is ignored, the connection always goes to the socket
+	req, err := http.NewRequest(method, "http://provisioner"+path, nil)
+	if err != nil {
+		return err
+	}
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		body, _ := ioutil.ReadAll(resp.Body)
+		return fmt.Errorf("%s %s: %s: %s", method, path, resp.Status, strings.TrimSpace(string(body)))
+	}
+	return json.NewDecoder(resp.Body).Decode(result)
+}
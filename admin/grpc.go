@@ -0,0 +1,233 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package admin
+
+import (
+	"context"
+	"encoding/json"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/encoding"
+)
+
+// DefaultGRPCSocket is where the deployment places the gRPC admin socket on
+// the node.
+const DefaultGRPCSocket = "/var/run/hostpath-provisioner/admin-grpc.sock"
+
+// ServiceName is the name of the gRPC admin service.
+const ServiceName = "hostpathprovisioner.admin.v1.Admin"
+
+// CodecName is the content subtype of the gRPC admin API, application/grpc+json:
+// the messages are the types of this package encoded as JSON, not protobuf.
+const CodecName = "json"
+
+func init() {
+	encoding.RegisterCodec(jsonCodec{})
+}
+
+// jsonCodec encodes the messages of the gRPC admin API.
+type jsonCodec struct{}
+
+func (jsonCodec) Marshal(v interface{}) ([]byte, error) {
+	return json.Marshal(v)
+}
+
+func (jsonCodec) Unmarshal(data []byte, v interface{}) error {
+	return json.Unmarshal(data, v)
+}
+
+func (jsonCodec) Name() string {
+	return CodecName
+}
+
+// VolumesRequest lists the volumes provisioned on the node.
+type VolumesRequest struct{}
+
+// VolumesResponse are the volumes provisioned on the node.
+type VolumesResponse struct {
+	Volumes []Volume `json:"volumes"`
+}
+
+// PoolsRequest returns the capacity of the storage pools.
+type PoolsRequest struct{}
+
+// PoolsResponse is the capacity of the storage pools.
+type PoolsResponse struct {
+	Pools []Pool `json:"pools"`
+}
+
+// OperationsRequest returns the recent operations.
+type OperationsRequest struct{}
+
+// OperationsResponse are the recent operations, newest first.
+type OperationsResponse struct {
+	Operations []Operation `json:"operations"`
+}
+
+// GCRequest removes the orphaned backing directories, or only lists them if
+// DryRun is set.
+type GCRequest struct {
+	DryRun bool `json:"dryRun"`
+}
+
+// ReconcileRequest queues all claims and volumes for another pass.
+type ReconcileRequest struct{}
+
+// SealRequest seals an image backed volume with dm-verity.
+type SealRequest struct {
+	Volume string `json:"volume"`
+}
+
+// AdminServer is the gRPC admin service, see RegisterAdminServer.
+type AdminServer interface {
+	Volumes(context.Context, *VolumesRequest) (*VolumesResponse, error)
+	Pools(context.Context, *PoolsRequest) (*PoolsResponse, error)
+	Operations(context.Context, *OperationsRequest) (*OperationsResponse, error)
+	GC(context.Context, *GCRequest) (*GCResult, error)
+	Reconcile(context.Context, *ReconcileRequest) (*ReconcileResult, error)
+	Seal(context.Context, *SealRequest) (*SealResult, error)
+}
+
+// RegisterAdminServer registers the admin service with a gRPC server.
+func RegisterAdminServer(s grpc.ServiceRegistrar, srv AdminServer) {
+	s.RegisterService(&serviceDesc, srv)
+}
+
+var serviceDesc = grpc.ServiceDesc{
+	ServiceName: ServiceName,
+	HandlerType: (*AdminServer)(nil),
+	Methods: []grpc.MethodDesc{
+		unaryMethod("Volumes", func() interface{} { return &VolumesRequest{} }, func(ctx context.Context, srv AdminServer, req interface{}) (interface{}, error) {
+			return srv.Volumes(ctx, req.(*VolumesRequest))
+		}),
+		unaryMethod("Pools", func() interface{} { return &PoolsRequest{} }, func(ctx context.Context, srv AdminServer, req interface{}) (interface{}, error) {
+			return srv.Pools(ctx, req.(*PoolsRequest))
+		}),
+		unaryMethod("Operations", func() interface{} { return &OperationsRequest{} }, func(ctx context.Context, srv AdminServer, req interface{}) (interface{}, error) {
+			return srv.Operations(ctx, req.(*OperationsRequest))
+		}),
+		unaryMethod("GC", func() interface{} { return &GCRequest{} }, func(ctx context.Context, srv AdminServer, req interface{}) (interface{}, error) {
+			return srv.GC(ctx, req.(*GCRequest))
+		}),
+		unaryMethod("Reconcile", func() interface{} { return &ReconcileRequest{} }, func(ctx context.Context, srv AdminServer, req interface{}) (interface{}, error) {
+			return srv.Reconcile(ctx, req.(*ReconcileRequest))
+		}),
+		unaryMethod("Seal", func() interface{} { return &SealRequest{} }, func(ctx context.Context, srv AdminServer, req interface{}) (interface{}, error) {
+			return srv.Seal(ctx, req.(*SealRequest))
+		}),
+	},
+	Streams: []grpc.StreamDesc{},
+}
+
+// unaryMethod describes a method of the admin service, decoding the request
+// made by newRequest and passing it to call.
+func unaryMethod(name string, newRequest func() interface{}, call func(context.Context, AdminServer, interface{}) (interface{}, error)) grpc.MethodDesc {
+	return grpc.MethodDesc{
+		MethodName: name,
+		Handler: func(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+			req := newRequest()
+			if err := dec(req); err != nil {
+				return nil, err
+			}
+			if interceptor == nil {
+				return call(ctx, srv.(AdminServer), req)
+			}
+			info := &grpc.UnaryServerInfo{Server: srv, FullMethod: "/" + ServiceName + "/" + name}
+			return interceptor(ctx, req, info, func(ctx context.Context, req interface{}) (interface{}, error) {
+				return call(ctx, srv.(AdminServer), req)
+			})
+		},
+	}
+}
+
+// GRPCClient talks to the gRPC admin API of the provisioner on this node.
+type GRPCClient struct {
+	conn    *grpc.ClientConn
+	timeout time.Duration
+}
+
+// NewGRPCClient returns a client for the gRPC admin API served on socket.
+// The connection is made on the first call.
+func NewGRPCClient(socket string, timeout time.Duration) (*GRPCClient, error) {
+	conn, err := grpc.NewClient("unix://"+socket,
+		// The socket is local, the provisioner checks the peer credentials
+		grpc.WithTransportCredentials(insecure.NewCredentials()),
+		grpc.WithDefaultCallOptions(grpc.CallContentSubtype(CodecName)))
+	if err != nil {
+		return nil, err
+	}
+	return &GRPCClient{conn: conn, timeout: timeout}, nil
+}
+
+// Close closes the connection to the provisioner.
+func (c *GRPCClient) Close() error {
+	return c.conn.Close()
+}
+
+// Volumes lists the volumes provisioned on the node.
+func (c *GRPCClient) Volumes() ([]Volume, error) {
+	resp := &VolumesResponse{}
+	if err := c.invoke("Volumes", &VolumesRequest{}, resp); err != nil {
+		return nil, err
+	}
+	return resp.Volumes, nil
+}
+
+// Pools returns the capacity of the storage pools.
+func (c *GRPCClient) Pools() ([]Pool, error) {
+	resp := &PoolsResponse{}
+	if err := c.invoke("Pools", &PoolsRequest{}, resp); err != nil {
+		return nil, err
+	}
+	return resp.Pools, nil
+}
+
+// Operations returns the recent operations, newest first.
+func (c *GRPCClient) Operations() ([]Operation, error) {
+	resp := &OperationsResponse{}
+	if err := c.invoke("Operations", &OperationsRequest{}, resp); err != nil {
+		return nil, err
+	}
+	return resp.Operations, nil
+}
+
+// GC removes the orphaned backing directories, or only lists them if dryRun
+// is set.
+func (c *GRPCClient) GC(dryRun bool) (*GCResult, error) {
+	result := &GCResult{}
+	return result, c.invoke("GC", &GCRequest{DryRun: dryRun}, result)
+}
+
+// Reconcile queues all claims and volumes for another pass.
+func (c *GRPCClient) Reconcile() (*ReconcileResult, error) {
+	result := &ReconcileResult{}
+	return result, c.invoke("Reconcile", &ReconcileRequest{}, result)
+}
+
+// Seal seals the image backed volume with dm-verity, making it read-only.
+func (c *GRPCClient) Seal(volume string) (*SealResult, error) {
+	result := &SealResult{}
+	return result, c.invoke("Seal", &SealRequest{Volume: volume}, result)
+}
+
+func (c *GRPCClient) invoke(method string, req, resp interface{}) error {
+	ctx, cancel := context.WithTimeout(context.Background(), c.timeout)
+	defer cancel()
+	return c.conn.Invoke(ctx, "/"+ServiceName+"/"+method, req, resp)
+}
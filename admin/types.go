@@ -0,0 +1,107 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package admin
+
+import "time"
+
+// DefaultSocket is where the deployment places the admin socket on the node.
+const DefaultSocket = "/var/run/hostpath-provisioner/admin.sock"
+
+// Paths of the admin API endpoints.
+const (
+	VolumesPath    = "/v1/volumes"
+	PoolsPath      = "/v1/pools"
+	OperationsPath = "/v1/operations"
+	GCPath         = "/v1/gc"
+	ReconcilePath  = "/v1/reconcile"
+	SealPath       = "/v1/seal"
+)
+
+// Operation types.
+const (
+	OperationProvision = "provision"
+	OperationDelete    = "delete"
+)
+
+// Volume describes a volume provisioned on the node.
+type Volume struct {
+	Name       string `json:"name"`
+	Claim      string `json:"claim,omitempty"`
+	Namespace  string `json:"namespace,omitempty"`
+	Pool       string `json:"pool"`
+	Path       string `json:"path"`
+	UsedBytes  int64  `json:"usedBytes"`
+	UsedInodes int64  `json:"usedInodes"`
+	// UsageMeasured is when the usage was measured, unset if it hasn't been
+	UsageMeasured *time.Time `json:"usageMeasured,omitempty"`
+	// Health is the result of the last health check, if any
+	Health string `json:"health,omitempty"`
+}
+
+// Pool describes the capacity of a storage pool.
+type Pool struct {
+	Name          string `json:"name"`
+	Path          string `json:"path"`
+	Device        string `json:"device,omitempty"`
+	DeviceClass   string `json:"deviceClass,omitempty"`
+	CapacityBytes int64  `json:"capacityBytes"`
+	FreeBytes     int64  `json:"freeBytes"`
+	UsedBytes     int64  `json:"usedBytes"`
+	ReservedBytes int64  `json:"reservedBytes"`
+	Inodes        int64  `json:"inodes"`
+	InodesFree    int64  `json:"inodesFree"`
+	// Capabilities are those of the pool's filesystem, such as reflink or
+	// xattrs, empty until it was probed
+	Capabilities []string `json:"capabilities,omitempty"`
+	// Redundancy is the btrfs profile, e.g. "btrfs raid1", or md level of
+	// the filesystem, empty when it has none
+	Redundancy string `json:"redundancy,omitempty"`
+	Error      string `json:"error,omitempty"`
+}
+
+// Operation is a provision or delete operation handled by the provisioner.
+type Operation struct {
+	Type          string        `json:"type"`
+	CorrelationID string        `json:"correlationID,omitempty"`
+	PV            string        `json:"pv"`
+	Claim         string        `json:"claim,omitempty"`
+	Pool          string        `json:"pool,omitempty"`
+	Path          string        `json:"path,omitempty"`
+	Started       time.Time     `json:"started"`
+	Duration      time.Duration `json:"duration"`
+	Error         string        `json:"error,omitempty"`
+}
+
+// GCResult lists the orphaned backing directories found by garbage
+// collection, and the ones that could not be removed.
+type GCResult struct {
+	DryRun  bool     `json:"dryRun"`
+	Removed []string `json:"removed"`
+	Failed  []string `json:"failed,omitempty"`
+}
+
+// ReconcileResult is the number of claims and volumes queued for another pass.
+type ReconcileResult struct {
+	Claims  int `json:"claims"`
+	Volumes int `json:"volumes"`
+}
+
+// SealResult is the dm-verity root hash a volume was sealed with.
+type SealResult struct {
+	Volume   string `json:"volume"`
+	RootHash string `json:"rootHash"`
+}
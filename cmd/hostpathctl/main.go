@@ -0,0 +1,183 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// hostpathctl talks to the admin API of the hostpath provisioner running on
+// the same node.
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"text/tabwriter"
+	"time"
+
+	"kubevirt.io/hostpath-provisioner/admin"
+)
+
+const usage = `Usage: hostpathctl [flags] <command>
+
+Commands:
+  volumes     list the volumes on this node with their path and usage
+  pools       show the capacity of the storage pools
+  operations  show the recent provision and delete operations
+  orphans     list backing directories no persistent volume refers to
+  gc          remove the backing directories listed by orphans
+  reconcile   queue all claims and volumes for another pass
+  seal <pv>   seal an image backed volume with dm-verity, making it read-only
+
+Flags:
+`
+
+func main() {
+	if err := run(os.Args[1:], os.Stdout, os.Stderr); err != nil {
+		fmt.Fprintf(os.Stderr, "hostpathctl: %v\n", err)
+		os.Exit(1)
+	}
+}
+
+func run(args []string, stdout, stderr io.Writer) error {
+	flags := flag.NewFlagSet("hostpathctl", flag.ContinueOnError)
+	flags.SetOutput(stderr)
+	socket := flags.String("socket", admin.DefaultSocket, "Path of the provisioner's admin socket")
+	grpcSocket := flags.String("grpc-socket", "", "Path of the provisioner's gRPC admin socket, used instead of -socket when set")
+	timeout := flags.Duration("timeout", time.Minute, "How long to wait for the provisioner to answer")
+	output := flags.String("o", "table", "Output format, table or json")
+	flags.Usage = func() {
+		fmt.Fprint(stderr, usage)
+		flags.PrintDefaults()
+	}
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if flags.NArg() == 0 {
+		flags.Usage()
+		return fmt.Errorf("expected a command")
+	}
+	command, args := flags.Arg(0), flags.Args()[1:]
+	if command == "seal" && len(args) != 1 {
+		return fmt.Errorf("seal expects the name of a persistent volume")
+	}
+	if command != "seal" && len(args) > 0 {
+		return fmt.Errorf("%s takes no arguments", command)
+	}
+	if *output != "table" && *output != "json" {
+		return fmt.Errorf("invalid output format %q, expected table or json", *output)
+	}
+
+	var client admin.API = admin.NewClient(*socket, *timeout)
+	if *grpcSocket != "" {
+		grpcClient, err := admin.NewGRPCClient(*grpcSocket, *timeout)
+		if err != nil {
+			return err
+		}
+		defer grpcClient.Close()
+		client = grpcClient
+	}
+	var result interface{}
+	var err error
+	switch command {
+	case "volumes":
+		result, err = client.Volumes()
+	case "pools":
+		result, err = client.Pools()
+	case "operations":
+		result, err = client.Operations()
+	case "orphans":
+		result, err = client.GC(true)
+	case "gc":
+		result, err = client.GC(false)
+	case "reconcile":
+		result, err = client.Reconcile()
+	case "seal":
+		result, err = client.Seal(args[0])
+	default:
+		flags.Usage()
+		return fmt.Errorf("unknown command %q", command)
+	}
+	if err != nil {
+		return err
+	}
+	if *output == "json" {
+		encoder := json.NewEncoder(stdout)
+		encoder.SetIndent("", "  ")
+		return encoder.Encode(result)
+	}
+	w := tabwriter.NewWriter(stdout, 0, 8, 2, ' ', 0)
+	printTable(w, result)
+	return w.Flush()
+}
+
+func printTable(w io.Writer, result interface{}) {
+	switch result := result.(type) {
+	case []admin.Volume:
+		fmt.Fprintln(w, "NAME\tCLAIM\tPOOL\tPATH\tUSED\tINODES\tHEALTH")
+		for _, v := range result {
+			claim := ""
+			if v.Claim != "" {
+				claim = v.Namespace + "/" + v.Claim
+			}
+			fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t%d\t%s\n", v.Name, claim, v.Pool, v.Path, humanBytes(v.UsedBytes), v.UsedInodes, v.Health)
+		}
+	case []admin.Pool:
+		fmt.Fprintln(w, "NAME\tPATH\tCLASS\tCAPACITY\tUSED\tFREE\tRESERVED\tINODES FREE\tERROR")
+		for _, p := range result {
+			fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t%s\t%s\t%d\t%s\n", p.Name, p.Path, p.DeviceClass, humanBytes(p.CapacityBytes), humanBytes(p.UsedBytes),
+				humanBytes(p.FreeBytes), humanBytes(p.ReservedBytes), p.InodesFree, p.Error)
+		}
+	case []admin.Operation:
+		fmt.Fprintln(w, "STARTED\tTYPE\tPV\tCLAIM\tPOOL\tDURATION\tERROR")
+		for _, op := range result {
+			fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t%v\t%s\n", op.Started.Format(time.RFC3339), op.Type, op.PV, op.Claim, op.Pool,
+				op.Duration.Round(time.Millisecond), op.Error)
+		}
+	case *admin.GCResult:
+		verb := "removed"
+		if result.DryRun {
+			verb = "orphaned"
+		}
+		for _, dir := range result.Removed {
+			fmt.Fprintf(w, "%s\t%s\n", verb, dir)
+		}
+		for _, dir := range result.Failed {
+			fmt.Fprintf(w, "failed\t%s\n", dir)
+		}
+	case *admin.ReconcileResult:
+		fmt.Fprintf(w, "queued %d claims and %d volumes\n", result.Claims, result.Volumes)
+	case *admin.SealResult:
+		fmt.Fprintf(w, "sealed %s with root hash %s\n", result.Volume, result.RootHash)
+	}
+}
+
+// humanBytes formats a size with a binary unit, e.g. 1.5Gi.
+func humanBytes(bytes int64) string {
+	const unit = 1024
+	if bytes < unit {
+		return fmt.Sprintf("%d", bytes)
+	}
+	value := float64(bytes)
+	suffix := ""
+	for _, s := range []string{"Ki", "Mi", "Gi", "Ti", "Pi", "Ei"} {
+		if value < unit {
+			break
+		}
+		value /= unit
+		suffix = s
+	}
+	return fmt.Sprintf("%.1f%s", value, suffix)
+}
@@ -0,0 +1,152 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"google.golang.org/grpc"
+
+	"kubevirt.io/hostpath-provisioner/admin"
+)
+
+func Test_humanBytes(t *testing.T) {
+	tests := []struct {
+		bytes int64
+		want  string
+	}{
+		{bytes: 0, want: "0"},
+		{bytes: 1023, want: "1023"},
+		{bytes: 1024, want: "1.0Ki"},
+		{bytes: 1536 * 1024 * 1024, want: "1.5Gi"},
+		{bytes: 3 << 40, want: "3.0Ti"},
+	}
+	for _, tt := range tests {
+		if got := humanBytes(tt.bytes); got != tt.want {
+			t.Errorf("humanBytes(%d) = %s, want %s", tt.bytes, got, tt.want)
+		}
+	}
+}
+
+func Test_run(t *testing.T) {
+	dir, err := ioutil.TempDir("", "hostpathctl")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	socket := filepath.Join(dir, "admin.sock")
+	listener, err := net.Listen("unix", socket)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+
+	var gcQuery string
+	mux := http.NewServeMux()
+	mux.HandleFunc(admin.VolumesPath, func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode([]admin.Volume{{Name: "pvc-1", Claim: "data", Namespace: "ns", Pool: "ssd", Path: "/pools/ssd/pvc-1", UsedBytes: 2048}})
+	})
+	mux.HandleFunc(admin.GCPath, func(w http.ResponseWriter, r *http.Request) {
+		gcQuery = r.URL.RawQuery
+		json.NewEncoder(w).Encode(admin.GCResult{DryRun: true, Removed: []string{"/pools/ssd/pvc-2"}})
+	})
+	mux.HandleFunc(admin.SealPath, func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(admin.SealResult{Volume: r.URL.Query().Get("volume"), RootHash: "4392712b"})
+	})
+	go http.Serve(listener, mux)
+
+	tests := []struct {
+		name    string
+		args    []string
+		want    []string
+		wantErr bool
+	}{
+		{name: "volumes", args: []string{"volumes"}, want: []string{"pvc-1", "ns/data", "/pools/ssd/pvc-1", "2.0Ki"}},
+		{name: "json", args: []string{"-o", "json", "volumes"}, want: []string{`"usedBytes": 2048`}},
+		{name: "orphans", args: []string{"orphans"}, want: []string{"orphaned", "/pools/ssd/pvc-2"}},
+		{name: "server error", args: []string{"pools"}, want: []string{"404"}, wantErr: true},
+		{name: "seal", args: []string{"seal", "pvc-1"}, want: []string{"sealed pvc-1 with root hash 4392712b"}},
+		{name: "seal without volume", args: []string{"seal"}, wantErr: true},
+		{name: "extra argument", args: []string{"volumes", "pvc-1"}, wantErr: true},
+		{name: "unknown command", args: []string{"frobnicate"}, wantErr: true},
+		{name: "no command", args: []string{}, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			stdout, stderr := &bytes.Buffer{}, &bytes.Buffer{}
+			err := run(append([]string{"-socket", socket}, tt.args...), stdout, stderr)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("run() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			got := stdout.String()
+			if err != nil {
+				got = err.Error()
+			}
+			for _, want := range tt.want {
+				if !strings.Contains(got, want) {
+					t.Errorf("output %q does not contain %q", got, want)
+				}
+			}
+		})
+	}
+	if gcQuery != "dryRun=true" {
+		t.Errorf("orphans sent query %q, want dryRun=true", gcQuery)
+	}
+}
+
+// fakeAdmin answers the gRPC admin API, reconciling only.
+type fakeAdmin struct {
+	admin.AdminServer
+}
+
+func (fakeAdmin) Reconcile(context.Context, *admin.ReconcileRequest) (*admin.ReconcileResult, error) {
+	return &admin.ReconcileResult{Claims: 2, Volumes: 3}, nil
+}
+
+func Test_runGRPC(t *testing.T) {
+	dir, err := ioutil.TempDir("", "hostpathctl")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	socket := filepath.Join(dir, "admin-grpc.sock")
+	listener, err := net.Listen("unix", socket)
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := grpc.NewServer()
+	admin.RegisterAdminServer(server, fakeAdmin{})
+	go server.Serve(listener)
+	defer server.Stop()
+
+	stdout, stderr := &bytes.Buffer{}, &bytes.Buffer{}
+	if err := run([]string{"-grpc-socket", socket, "reconcile"}, stdout, stderr); err != nil {
+		t.Fatalf("run() error = %v", err)
+	}
+	if got, want := stdout.String(), "queued 2 claims and 3 volumes"; !strings.Contains(got, want) {
+		t.Errorf("output %q does not contain %q", got, want)
+	}
+}
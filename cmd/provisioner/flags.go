@@ -0,0 +1,245 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"flag"
+	"fmt"
+	"sort"
+	"strings"
+
+	"kubevirt.io/hostpath-provisioner/pkg/provisioner"
+)
+
+// registerFlags registers a flag for every setting of c on flags, with the
+// current value of the setting as its default. Setting the flags changes c.
+func registerFlags(flags *flag.FlagSet, c *provisioner.Config) {
+	flags.StringVar(&c.Backend, "backend", c.Backend, "Where volumes are provisioned: host, the filesystems of the node, or memory, pools of plain directories and their volumes simulated in memory without touching the node, for development and demos")
+	flags.StringVar(&c.MemoryPoolSize, "memory-pool-size", c.MemoryPoolSize, "The size of each pool simulated by the memory backend")
+	flags.BoolVar(&c.DryRun, "dry-run", c.DryRun, "Decide how claims would be provisioned and volumes deleted, and report it in the log and events, without touching the filesystem or creating volumes")
+	flags.Var(featureGates{&c.FeatureGates}, "feature-gates", "Comma separated list of Name=true|false pairs turning experimental features on or off. Features: "+provisioner.DescribeFeatureGates())
+	flags.StringVar(&c.LogFormat, "log-format", c.LogFormat, "Format of log messages, text or json")
+	flags.BoolVar(&c.Trace, "trace", c.Trace, "Log a span with its duration for every phase of provisioning and deleting volumes")
+	flags.StringVar(&c.OTLPEndpoint, "otlp-endpoint", c.OTLPEndpoint, "URL of an OpenTelemetry collector's OTLP/HTTP receiver, e.g. http://otel-collector:4318, the spans of provisioning and deleting volumes are exported to it")
+
+	flags.BoolVar(&c.AllowRootfs, "allow-rootfs", c.AllowRootfs, "Allow storage pools on the root filesystem of the node")
+	// When running in a container "/" is the container's own filesystem, the
+	// node's root filesystem has to be mounted into the container to detect
+	// pools sharing it.
+	flags.StringVar(&c.RootfsPath, "rootfs-path", c.RootfsPath, "Path at which the node's root filesystem is visible to the provisioner")
+	flags.BoolVar(&c.StrictMounts, "strict-mounts", c.StrictMounts, "Only provision into pools that are mount points, of their expected device when one is configured in POOL_DEVICES")
+	flags.StringVar(&c.AllowedNamespaces, "allowed-namespaces", c.AllowedNamespaces, "Comma separated list of namespaces that may receive volumes, shell patterns such as team-* are allowed. All namespaces are allowed when empty")
+	flags.StringVar(&c.DeniedNamespaces, "denied-namespaces", c.DeniedNamespaces, "Comma separated list of namespaces that may not receive volumes, shell patterns such as team-* are allowed. Takes precedence over --allowed-namespaces")
+	flags.StringVar(&c.AllowedSELinuxContexts, "allowed-selinux-contexts", c.AllowedSELinuxContexts, "Space separated list of SELinux contexts claims may ask for with the kubevirt.io/selinuxContext annotation, none when empty")
+	flags.IntVar(&c.MaxVolumeStripes, "max-volume-stripes", c.MaxVolumeStripes, "The most pools a volume spanning pools is striped across")
+	flags.BoolVar(&c.WipeDevices, "wipe-devices", c.WipeDevices, "Erase the filesystem and partition table signatures of the devices of device pools when their volume is deleted, so that the next claim gets a blank device")
+	flags.StringVar(&c.TmpfsBudget, "tmpfs-budget", c.TmpfsBudget, "Memory all tmpfs backed volumes on the node may use together, e.g. 16Gi. tmpfs backed volumes are refused when unset")
+	flags.BoolVar(&c.SymlinkFarm, "symlink-farm", c.SymlinkFarm, "Maintain <pool>/by-namespace/<namespace>/<claim> symlinks to the backing directories, so they can be found on the host by claim")
+	flags.BoolVar(&c.DetectTampering, "detect-tampering", c.DetectTampering, "Watch backing directories and report when they are removed or chowned behind the provisioner's back")
+	flags.BoolVar(&c.QuarantineUnmatched, "quarantine-unmatched", c.QuarantineUnmatched, "Move the backing directories no PV refers to into the quarantine directory of their pool when the allocation database is rebuilt")
+	flags.StringVar(&c.IntentFile, "intent-file", c.IntentFile, "File the provisioner records the backing directories it is creating and removing in, so that operations interrupted by a restart are cleaned up on start up, disabled when empty")
+	flags.StringVar(&c.AllocationDB, "allocation-db", c.AllocationDB, "File the provisioner keeps a database of the volumes on the node in, to account for the storage reserved in the pools and tell orphaned backing directories apart without the API server, disabled when empty")
+	flags.Float64Var(&c.ProvisionRate, "provision-rate", c.ProvisionRate, "Maximum number of volumes provisioned per second in each pool, no limit when 0")
+	flags.IntVar(&c.ProvisionBurst, "provision-burst", c.ProvisionBurst, "Maximum number of volumes provisioned at once in each pool when --provision-rate is set")
+	flags.DurationVar(&c.ProvisionTimeout.Duration, "provision-timeout", c.ProvisionTimeout.Duration, "How long preparing a backing directory may take before provisioning fails, no limit when 0")
+	flags.DurationVar(&c.DeleteTimeout.Duration, "delete-timeout", c.DeleteTimeout.Duration, "How long removing a backing directory may take before deletion fails, no limit when 0")
+	flags.DurationVar(&c.ShutdownTimeout.Duration, "shutdown-timeout", c.ShutdownTimeout.Duration, "How long to wait for the claims and volumes being processed when shutting down, keep it below the pod's termination grace period")
+
+	flags.DurationVar(&c.CacheCheckInterval.Duration, "cache-check-interval", c.CacheCheckInterval.Duration, "How often the SSD caches of LVM pools are checked")
+	flags.DurationVar(&c.DeviceHealthInterval.Duration, "device-health-interval", c.DeviceHealthInterval.Duration, "How often the SMART health of the pool devices is read")
+	flags.DurationVar(&c.LoopDeviceCheckInterval.Duration, "loop-device-check-interval", c.LoopDeviceCheckInterval.Duration, "How often the loop devices of image backed volumes are checked for leaks, disabled when 0")
+	flags.DurationVar(&c.NodeOverrideInterval.Duration, "node-override-interval", c.NodeOverrideInterval.Duration, "How often the HostPathNodeOverride object of the node is read, disabled when 0. Requires the HostPathNodeOverride CRD")
+	flags.DurationVar(&c.NodeStatusInterval.Duration, "node-status-interval", c.NodeStatusInterval.Duration, "How often the HostPathNodeStatus object of the node is updated, disabled when 0. Requires the HostPathNodeStatus CRD")
+	flags.DurationVar(&c.LatencyProbeInterval.Duration, "latency-probe-interval", c.LatencyProbeInterval.Duration, "How often the latency of statfs and a small write is measured for every pool, disabled when 0")
+	flags.DurationVar(&c.SlowDiskThreshold.Duration, "slow-disk-threshold", c.SlowDiskThreshold.Duration, "Probe latency above which a pool is considered slow")
+	flags.DurationVar(&c.VolumeHealthInterval.Duration, "volume-health-interval", c.VolumeHealthInterval.Duration, "How often the health of the volumes on this node is checked, disabled when 0")
+	flags.DurationVar(&c.VolumeStatsInterval.Duration, "volume-stats-interval", c.VolumeStatsInterval.Duration, "How often the stats of the volumes on this node are recorded in the annotations of their PVs, disabled when 0")
+	flags.DurationVar(&c.UsageScanInterval.Duration, "usage-scan-interval", c.UsageScanInterval.Duration, "How often the disk usage of every volume is measured")
+	flags.IntVar(&c.UsageScanWorkers, "usage-scan-workers", c.UsageScanWorkers, "How many volumes are measured at the same time")
+	flags.BoolVar(&c.PoolUsageCondition, "pool-usage-condition", c.PoolUsageCondition, "Set the HostpathPoolUsageHigh node condition while a pool is above a usage threshold")
+	flags.BoolVar(&c.EnforceIOLimits, "enforce-io-limits", c.EnforceIOLimits, "Enforce the I/O limits of volumes with the io.max of the cgroups of the pods using them, needs cgroup v2")
+	flags.DurationVar(&c.IOLimitInterval.Duration, "io-limit-interval", c.IOLimitInterval.Duration, "How often the I/O limits of the pods on the node are applied")
+	flags.StringVar(&c.CgroupRoot, "cgroup-root", c.CgroupRoot, "Where the node's cgroup v2 hierarchy is mounted")
+
+	flags.IntVar(&c.HealthPort, "health-port", c.HealthPort, "Port to serve the /healthz and /readyz endpoints on, disabled when 0")
+	flags.DurationVar(&c.WorkerDeadline.Duration, "worker-deadline", c.WorkerDeadline.Duration, "How long processing a single claim or volume may take before /healthz reports the provisioner as wedged")
+	flags.StringVar(&c.AdminSocket, "admin-socket", c.AdminSocket, "Path of the unix socket to serve the admin API on, disabled when empty")
+	flags.IntVar(&c.AdminPort, "admin-port", c.AdminPort, "Port on localhost to serve the read-only part of the admin API on, disabled when 0")
+	flags.StringVar(&c.AdminGRPCSocket, "admin-grpc-socket", c.AdminGRPCSocket, "Path of the unix socket to serve the gRPC admin API on, disabled when empty")
+	flags.StringVar(&c.CSIEndpoint, "csi-endpoint", c.CSIEndpoint, "Unix socket to serve the CSI Identity, Controller and Node services on, e.g. unix:///csi/csi.sock, disabled when empty")
+	flags.StringVar(&c.CSIDriverName, "csi-driver-name", c.CSIDriverName, "Name of the CSI driver, the provisioner of the storage classes whose volumes go through the CSI sidecars")
+	flags.IntVar(&c.PprofPort, "pprof-port", c.PprofPort, "Port on localhost to serve profiling data from net/http/pprof, and the log verbosity control, on. Disabled when 0")
+
+	flags.StringVar(&c.Commands.LVM, "lvm", c.Commands.LVM, "Path of the lvm binary, used for pools backed by LVM")
+	flags.StringVar(&c.Commands.QemuImg, "qemu-img", c.Commands.QemuImg, "Path of the qemu-img binary, used for qcow2 backed volumes")
+	flags.StringVar(&c.Commands.Duperemove, "duperemove", c.Commands.Duperemove, "duperemove binary pools with dedup: duperemove are deduplicated with")
+	flags.StringVar(&c.Commands.Smartctl, "smartctl", c.Commands.Smartctl, "Path of the smartctl binary used to read the SMART health of the pool devices, disabled when empty")
+
+	flags.StringVar(&c.NFS.Server, "nfs-server", c.NFS.Server, "Address pods reach the NFS server of this node at, usually the node's IP. Backing directories are not exported over NFS when unset")
+	flags.StringVar(&c.NFS.ExportsFile, "nfs-exports-file", c.NFS.ExportsFile, "File the provisioner keeps the NFS exports of backing directories in, read by the NFS server of the node")
+	flags.StringVar(&c.NFS.ExportOptions, "nfs-export-options", c.NFS.ExportOptions, "Options of the NFS exports of backing directories")
+	flags.StringVar(&c.NFS.Exportfs, "exportfs", c.NFS.Exportfs, "exportfs binary the NFS server is told about changed exports with")
+
+	flags.DurationVar(&c.DeviceInventory.Interval.Duration, "device-inventory-interval", c.DeviceInventory.Interval.Duration, "How often the block devices of the node are discovered and published in its DeviceInventory object, disabled when 0. Requires the DeviceInventory CRD")
+	flags.StringVar(&c.DeviceInventory.MinSize, "device-min-size", c.DeviceInventory.MinSize, "Smallest size of the devices offered in the device inventory, e.g. 100Gi")
+	flags.StringVar(&c.DeviceInventory.Model, "device-model", c.DeviceInventory.Model, "Regular expression the model of the devices offered in the device inventory has to match")
+	flags.StringVar(&c.DeviceInventory.Serial, "device-serial", c.DeviceInventory.Serial, "Regular expression the serial number of the devices offered in the device inventory has to match")
+	flags.StringVar(&c.DeviceInventory.ApprovedDevicesDir, "approved-devices-dir", c.DeviceInventory.ApprovedDevicesDir, "Directory kept holding links to the devices approved in the device inventory, to be used as the path of a device pool")
+
+	controller := &c.Controller
+	flags.IntVar(&controller.Workers, "workers", controller.Workers, "Number of claims provisioned and volumes deleted concurrently, each")
+	flags.DurationVar(&controller.ResyncPeriod.Duration, "resync-period", controller.ResyncPeriod.Duration, "How often all claims and volumes are processed again, even when they did not change")
+	flags.DurationVar(&controller.ProvisionTimeout.Duration, "controller-provision-timeout", controller.ProvisionTimeout.Duration, "Deadline of the context a claim is provisioned with by the controller, on top of --provision-timeout, no limit when 0")
+	flags.DurationVar(&controller.DeletionTimeout.Duration, "controller-deletion-timeout", controller.DeletionTimeout.Duration, "Deadline of the context a volume is deleted with by the controller, on top of --delete-timeout, no limit when 0")
+	flags.BoolVar(&controller.AddFinalizer, "add-finalizer", controller.AddFinalizer, "Add a finalizer to provisioned volumes, so that they are not removed before their backing storage is deleted")
+	flags.BoolVar(&controller.ExitOnPanic, "exit-on-panic", controller.ExitOnPanic, "Exit once the claims and volumes being processed are done after recovering from a panic, instead of carrying on")
+	flags.IntVar(&controller.MetricsPort, "metrics-port", controller.MetricsPort, "Port to serve Prometheus metrics on, metrics are disabled when 0")
+	flags.StringVar(&controller.MetricsAddress, "metrics-address", controller.MetricsAddress, "Address to serve Prometheus metrics on")
+	flags.StringVar(&controller.MetricsPath, "metrics-path", controller.MetricsPath, "Path Prometheus metrics are served at")
+	election := &controller.LeaderElection
+	flags.BoolVar(&election.Enabled, "leader-election", election.Enabled, "Run several replicas per node, of which only the one holding the node's leader election lock processes claims and volumes")
+	flags.StringVar(&election.Namespace, "leader-election-namespace", election.Namespace, "Namespace of the leader election leases, the provisioner's namespace when empty")
+	flags.DurationVar(&election.LeaseDuration.Duration, "leader-election-lease-duration", election.LeaseDuration.Duration, "How long replicas on standby wait before taking over the lock of a leader that stopped renewing it")
+	flags.DurationVar(&election.RenewDeadline.Duration, "leader-election-renew-deadline", election.RenewDeadline.Duration, "How long the leader keeps trying to renew its lock before it gives up leading")
+	flags.DurationVar(&election.RetryPeriod.Duration, "leader-election-retry-period", election.RetryPeriod.Duration, "How often the lock is tried to be acquired or renewed")
+	retry := &controller.Retry
+	flags.DurationVar(&retry.InitialDelay.Duration, "retry-initial-delay", retry.InitialDelay.Duration, "Delay before retrying a failed provisioning or deletion the first time")
+	flags.DurationVar(&retry.MaxDelay.Duration, "retry-max-delay", retry.MaxDelay.Duration, "Maximum delay between retries of a failed provisioning or deletion")
+	flags.Float64Var(&retry.Multiplier, "retry-multiplier", retry.Multiplier, "Factor the delay between retries grows by after every failure")
+	flags.IntVar(&retry.MaxAttempts, "retry-max-attempts", retry.MaxAttempts, "Number of retries of a failed provisioning or deletion before giving up until the next resync, 0 retries forever")
+	flags.IntVar(&retry.CreateVolumeAttempts, "create-volume-attempts", retry.CreateVolumeAttempts, "Number of attempts at storing a provisioned volume in the API server before its backing storage is deleted again")
+	flags.DurationVar(&retry.CreateVolumeInterval.Duration, "create-volume-interval", retry.CreateVolumeInterval.Duration, "Delay between the attempts at storing a provisioned volume")
+}
+
+// flagOverride returns a function applying the flags of registerFlags that
+// were given on the command line to a configuration, so that they take
+// precedence over the config file and the environment.
+func flagOverride(flags *flag.FlagSet) func(*provisioner.Config) error {
+	given := map[string]string{}
+	flags.Visit(func(f *flag.Flag) {
+		given[f.Name] = f.Value.String()
+	})
+	return func(c *provisioner.Config) error {
+		settings := flag.NewFlagSet("settings", flag.ContinueOnError)
+		registerFlags(settings, c)
+		var err error
+		settings.VisitAll(func(f *flag.Flag) {
+			if value, ok := given[f.Name]; ok && err == nil {
+				err = settings.Set(f.Name, value)
+			}
+		})
+		return err
+	}
+}
+
+// featureGates is the --feature-gates flag, Name=true|false pairs turning
+// features on or off on top of those of the config file.
+type featureGates struct {
+	gates *map[string]bool
+}
+
+func (f featureGates) Set(value string) error {
+	gates, err := provisioner.ParseFeatureGates(value)
+	if err != nil {
+		return err
+	}
+	if *f.gates == nil {
+		*f.gates = map[string]bool{}
+	}
+	for name, on := range gates {
+		(*f.gates)[name] = on
+	}
+	return nil
+}
+
+func (f featureGates) String() string {
+	if f.gates == nil {
+		return ""
+	}
+	var pairs []string
+	for name, on := range *f.gates {
+		pairs = append(pairs, fmt.Sprintf("%s=%t", name, on))
+	}
+	sort.Strings(pairs)
+	return strings.Join(pairs, ",")
+}
+
+// settingFlag is a command line flag overriding the environment variable of a
+// setting, so that the provisioner can be run outside of its deployment.
+type settingFlag struct {
+	env     string
+	flag    string
+	usage   string
+	boolean bool
+}
+
+var settingFlags = []settingFlag{
+	{env: "PROVISIONER_NAME", flag: "provisioner-name", usage: "Name of the provisioner, the provisioner field of the StorageClasses it serves"},
+	{env: "PROVISIONER_ALIASES", flag: "provisioner-aliases", usage: "Comma separated list of former provisioner names whose claims and volumes are still served"},
+	{env: "NODE_NAME", flag: "node-name", usage: "Node the provisioner runs on"},
+	{env: "PV_DIR", flag: "pv-dir", usage: "Directory volumes are placed in when no pools are configured"},
+	{env: "PV_POOLS", flag: "pools", usage: "Comma separated list of name=path storage pools"},
+	{env: "POOL_DEVICES", flag: "pool-devices", usage: "Comma separated list of pool=device pairs naming the device mounted at each pool"},
+	{env: "POOL_SELECTION_POLICY", flag: "pool-selection-policy", usage: "Pool selection policy for storage classes that do not choose one: most-free, round-robin or least-volumes"},
+	{env: "USE_NAMING_PREFIX", flag: "naming-prefix", usage: "Prefix backing directories with the claim name", boolean: true},
+	{env: "NAMING_MODE", flag: "naming-mode", usage: "How backing directories are named: pv, claim (<claim>-<pv>) or namespace (<namespace>_<claim>)"},
+	{env: "NAMING_TEMPLATE", flag: "naming-template", usage: "Go template naming backing directories, e.g. {{.PVCNamespace}}-{{.PVCName}}-{{.PVName}}"},
+	{env: "DIRECTORY_MODE", flag: "directory-mode", usage: "Permissions of backing directories in octal, for storage classes and claims that do not set them"},
+	{env: "SELINUX_CONTEXT", flag: "selinux-context", usage: "SELinux context backing directories are labeled with, e.g. system_u:object_r:container_file_t:s0, for storage classes and claims that do not set one"},
+	{env: "ALLOWED_UIDS", flag: "allowed-uids", usage: "User IDs claims may ask to own their backing directory with the kubevirt.io/ownerUID annotation, e.g. 107,1000-1999"},
+	{env: "ALLOWED_GIDS", flag: "allowed-gids", usage: "Group IDs claims may ask to own their backing directory with the kubevirt.io/ownerGID annotation, e.g. 107,1000-1999"},
+	{env: "DEFAULT_RECLAIM_POLICY", flag: "default-reclaim-policy", usage: "Reclaim policy of volumes whose storage class does not set one: Delete or Retain"},
+	{env: "CAPACITY_ROUNDING", flag: "capacity-rounding", usage: "Rounding of the capacity reported on PVs: up, down or none"},
+	{env: "CAPACITY_ROUNDING_UNIT", flag: "capacity-rounding-unit", usage: "Unit the capacity reported on PVs is rounded to, e.g. Gi, G or 100Mi"},
+	{env: "CLAIM_SELECTOR", flag: "claim-selector", usage: "Label selector limiting the claims the provisioner acts on"},
+	{env: "QUOTA_CONFIGMAP", flag: "quota-configmap", usage: "ConfigMap in the provisioner's namespace holding per-namespace limits"},
+	{env: "MOUNT_CHECK_INTERVAL", flag: "mount-check-interval", usage: "How often pools are checked for their filesystem disappearing"},
+	{env: "POOL_USAGE_THRESHOLDS", flag: "pool-usage-thresholds", usage: "Comma separated usage percentages, crossing them emits node events"},
+	{env: "POOL_USAGE_CHECK_INTERVAL", flag: "pool-usage-check-interval", usage: "How often pool usage is compared with the thresholds"},
+}
+
+// registerSettingFlags registers the flags of settingFlags on flags.
+func registerSettingFlags(flags *flag.FlagSet) {
+	for _, setting := range settingFlags {
+		usage := fmt.Sprintf("%s, overrides %s and the config file", setting.usage, setting.env)
+		if setting.boolean {
+			flags.Bool(setting.flag, false, usage)
+		} else {
+			flags.String(setting.flag, "", usage)
+		}
+	}
+}
+
+// settingLookup returns a function looking up the value of a setting by the
+// name of its environment variable. A flag given on the command line takes
+// precedence over the environment variable.
+func settingLookup(flags *flag.FlagSet, getenv func(string) string) func(string) string {
+	given := map[string]string{}
+	if flags != nil {
+		flags.Visit(func(f *flag.Flag) {
+			given[f.Name] = f.Value.String()
+		})
+	}
+	return func(env string) string {
+		for _, setting := range settingFlags {
+			if value, ok := given[setting.flag]; ok && setting.env == env {
+				return value
+			}
+		}
+		return getenv(env)
+	}
+}
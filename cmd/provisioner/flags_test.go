@@ -0,0 +1,63 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"flag"
+	"testing"
+	"time"
+
+	"kubevirt.io/hostpath-provisioner/pkg/provisioner"
+)
+
+func Test_flagOverride(t *testing.T) {
+	flags := flag.NewFlagSet("test", flag.ContinueOnError)
+	registerFlags(flags, provisioner.DefaultConfig())
+	flags.String("kubeconfig", "", "")
+	if err := flags.Parse([]string{"-strict-mounts", "-provision-timeout=5m", "-feature-gates=SELinuxRelabel=true", "-workers=8", "-kubeconfig=/tmp/config"}); err != nil {
+		t.Fatal(err)
+	}
+
+	// Settings of the config file not given on the command line are kept
+	c := provisioner.DefaultConfig()
+	c.NFS.Server = "10.0.0.1"
+	c.FeatureGates = map[string]bool{"VolumeOwnership": false}
+	if err := flagOverride(flags)(c); err != nil {
+		t.Fatal(err)
+	}
+	if !c.StrictMounts || c.ProvisionTimeout.Duration != 5*time.Minute || c.Controller.Workers != 8 || c.NFS.Server != "10.0.0.1" {
+		t.Errorf("unexpected config %+v", c)
+	}
+	if !c.FeatureGates["SELinuxRelabel"] || c.FeatureGates["VolumeOwnership"] {
+		t.Errorf("feature gates = %v, want those of the flag on top of the file", c.FeatureGates)
+	}
+}
+
+func Test_settingLookup(t *testing.T) {
+	flags := flag.NewFlagSet("test", flag.ContinueOnError)
+	registerSettingFlags(flags)
+	if err := flags.Parse([]string{"-pv-dir=/mnt/flag", "-naming-prefix=false"}); err != nil {
+		t.Fatal(err)
+	}
+	env := map[string]string{"PV_DIR": "/mnt/env", "NODE_NAME": "node01", "USE_NAMING_PREFIX": "true"}
+	lookup := settingLookup(flags, func(name string) string { return env[name] })
+	for name, want := range map[string]string{"PV_DIR": "/mnt/flag", "NODE_NAME": "node01", "USE_NAMING_PREFIX": "false", "CLAIM_SELECTOR": ""} {
+		if got := lookup(name); got != want {
+			t.Errorf("lookup(%s) = %q, want %q", name, got, want)
+		}
+	}
+}
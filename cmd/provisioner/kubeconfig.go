@@ -0,0 +1,55 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"fmt"
+
+	"k8s.io/klog/v2"
+
+	"k8s.io/client-go/rest"
+	"k8s.io/client-go/tools/clientcmd"
+	clientcmdapi "k8s.io/client-go/tools/clientcmd/api"
+)
+
+// clientConfig returns the configuration used to talk to the API server. The
+// in-cluster configuration is used unless a kubeconfig or master is given;
+// when not running in a pod the kubeconfig is looked up like kubectl does,
+// through $KUBECONFIG and ~/.kube/config.
+func clientConfig(kubeconfig, master string, inCluster func() (*rest.Config, error)) (*rest.Config, error) {
+	if kubeconfig == "" && master == "" {
+		config, err := inCluster()
+		if err == nil {
+			return config, nil
+		}
+		klog.Infof("not running in a cluster (%v), falling back to the kubeconfig", err)
+	}
+	rules := clientcmd.NewDefaultClientConfigLoadingRules()
+	rules.ExplicitPath = kubeconfig
+	overrides := &clientcmd.ConfigOverrides{ClusterInfo: clientcmdapi.Cluster{Server: master}}
+	return clientcmd.NewNonInteractiveDeferredLoadingClientConfig(rules, overrides).ClientConfig()
+}
+
+// setRateLimits limits the rate of queries config sends to the API server.
+func setRateLimits(config *rest.Config, qps float64, burst int) error {
+	if qps <= 0 || burst <= 0 {
+		return fmt.Errorf("invalid API server rate limit of %v queries per second with bursts of %d, both must be positive", qps, burst)
+	}
+	config.QPS = float32(qps)
+	config.Burst = burst
+	return nil
+}
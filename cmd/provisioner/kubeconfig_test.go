@@ -0,0 +1,97 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"k8s.io/client-go/rest"
+)
+
+const testKubeconfig = `apiVersion: v1
+kind: Config
+clusters:
+- name: test
+  cluster:
+    server: https://kubeconfig:6443
+contexts:
+- name: test
+  context:
+    cluster: test
+current-context: test
+`
+
+func Test_clientConfig(t *testing.T) {
+	dir, err := ioutil.TempDir("", "kubeconfig")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "config")
+	if err := ioutil.WriteFile(path, []byte(testKubeconfig), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	inCluster := func() (*rest.Config, error) { return &rest.Config{Host: "https://in-cluster:443"}, nil }
+	notInCluster := func() (*rest.Config, error) { return nil, fmt.Errorf("not in a pod") }
+	tests := []struct {
+		name       string
+		kubeconfig string
+		master     string
+		inCluster  func() (*rest.Config, error)
+		env        string
+		want       string
+	}{
+		{"in cluster", "", "", inCluster, "", "https://in-cluster:443"},
+		{"kubeconfig flag", path, "", inCluster, "", "https://kubeconfig:6443"},
+		{"master overrides kubeconfig", path, "https://master:6443", inCluster, "", "https://master:6443"},
+		{"master only", "", "https://master:6443", inCluster, filepath.Join(dir, "missing"), "https://master:6443"},
+		{"KUBECONFIG fallback", "", "", notInCluster, path, "https://kubeconfig:6443"},
+	}
+	defer os.Setenv("KUBECONFIG", os.Getenv("KUBECONFIG"))
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			os.Setenv("KUBECONFIG", tt.env)
+			config, err := clientConfig(tt.kubeconfig, tt.master, tt.inCluster)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if config.Host != tt.want {
+				t.Errorf("host = %s, want %s", config.Host, tt.want)
+			}
+		})
+	}
+}
+
+func Test_setRateLimits(t *testing.T) {
+	config := &rest.Config{}
+	if err := setRateLimits(config, 50, 100); err != nil {
+		t.Fatal(err)
+	}
+	if config.QPS != 50 || config.Burst != 100 {
+		t.Errorf("QPS = %v, burst = %d; want 50, 100", config.QPS, config.Burst)
+	}
+	for _, limits := range [][2]int{{0, 10}, {5, 0}, {-1, 10}} {
+		if err := setRateLimits(&rest.Config{}, float64(limits[0]), limits[1]); err == nil {
+			t.Errorf("setRateLimits(%d, %d) accepted an invalid limit", limits[0], limits[1])
+		}
+	}
+}
@@ -0,0 +1,99 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"k8s.io/klog/v2"
+	"kubevirt.io/hostpath-provisioner/pkg/provisioner"
+
+	"k8s.io/client-go/rest"
+)
+
+var (
+	configFile           = flag.String("config", "", "Path of a YAML file configuring the provisioner, environment variables and command line flags override its settings")
+	configMapName        = flag.String("config-configmap", "", "Name of a ConfigMap in the provisioner's namespace whose config.yaml key configures the provisioner, on top of --config")
+	configReloadInterval = flag.Duration("config-reload-interval", time.Minute, "How often the config file and ConfigMap are checked for changes, disabled when 0")
+
+	kubeconfig = flag.String("kubeconfig", "", "Path to a kubeconfig file, used instead of the in-cluster configuration to run the provisioner outside of the cluster")
+	master     = flag.String("master", "", "Address of the Kubernetes API server, overrides the server in the kubeconfig")
+	// The client-go defaults suit neither a handful of edge nodes nor large
+	// fleets, where every node runs a provisioner
+	kubeAPIQPS   = flag.Float64("kube-api-qps", float64(rest.DefaultQPS), "Maximum queries per second to the API server")
+	kubeAPIBurst = flag.Int("kube-api-burst", rest.DefaultBurst, "Maximum burst of queries to the API server")
+
+	printVersion = flag.Bool("version", false, "Print the version and exit")
+)
+
+func main() {
+	registerFlags(flag.CommandLine, provisioner.DefaultConfig())
+	registerSettingFlags(flag.CommandLine)
+	klog.InitFlags(flag.CommandLine)
+	flag.Parse()
+	if *printVersion {
+		fmt.Println(provisioner.Version())
+		os.Exit(0)
+	}
+
+	// Create a config, in-cluster unless a kubeconfig is given, and use it to
+	// create a client for the provisioner to use to communicate with Kubernetes
+	config, err := clientConfig(*kubeconfig, *master, rest.InClusterConfig)
+	if err != nil {
+		klog.Fatalf("Failed to create config: %v", err)
+	}
+	if err := setRateLimits(config, *kubeAPIQPS, *kubeAPIBurst); err != nil {
+		klog.Fatalf("Failed to create config: %v", err)
+	}
+	err = provisioner.Run(shutdownOnSignal(), config, provisioner.ConfigSources{
+		File:           *configFile,
+		ConfigMap:      *configMapName,
+		ReloadInterval: *configReloadInterval,
+		Getenv:         settingLookup(flag.CommandLine, os.Getenv),
+		Override:       flagOverride(flag.CommandLine),
+	})
+	if err != nil {
+		klog.Errorf("%v", err)
+		klog.Flush()
+		os.Exit(1)
+	}
+}
+
+// shutdownOnSignal returns a context canceled on SIGTERM and SIGINT, so that
+// the provisioner shuts down instead of being killed halfway through creating
+// or removing a backing directory. A second signal exits right away.
+func shutdownOnSignal() context.Context {
+	ctx, cancel := context.WithCancel(context.Background())
+	signals := make(chan os.Signal, 2)
+	signal.Notify(signals, syscall.SIGTERM, syscall.SIGINT)
+	go func() {
+		sig := <-signals
+		klog.Infof("Shutting down on %v", sig)
+		cancel()
+		<-signals
+		klog.Errorf("Exiting right away on second signal")
+		klog.Flush()
+		os.Exit(1)
+	}()
+	return ctx
+}
@@ -17,8 +17,8 @@
 # NOTE: Not using pipefail because gofmt returns 0 when it finds
 # suggestions and 1 when files are clean
 
-SOURCE_DIRS="controller cmd"
-LINTABLE=(cmd controller)
+SOURCE_DIRS="admin controller cmd pkg"
+LINTABLE=(admin cmd controller pkg)
 ec=0
 out="$(gofmt -l -s ${SOURCE_DIRS} | grep ".*\.go")"
 if [[ ${out} ]]; then
@@ -20,12 +20,12 @@ export KUBEVIRT_NUM_NODES=2
 export KUBEVIRT_PROVIDER=k8s-1.15.1
 make cluster-down
 make cluster-up
-wget https://dl.google.com/go/go1.13.3.linux-amd64.tar.gz
-tar -xzf go1.13.3.linux-amd64.tar.gz
+wget https://dl.google.com/go/go1.24.6.linux-amd64.tar.gz
+tar -xzf go1.24.6.linux-amd64.tar.gz
 export GOROOT=$PWD/go
 export PATH=$GOROOT/bin:$PATH
 echo $PATH
-go get gotest.tools/gotestsum
+go install gotest.tools/gotestsum@v0.4.0
 export UPGRADE_FROM=$(curl -s https://github.com/kubevirt/hostpath-provisioner-operator/releases/latest | grep -o "v[0-9]\.[0-9]*\.[0-9]*")
 echo "Upgrading from verions: $UPGRADE_FROM"
 
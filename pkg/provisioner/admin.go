@@ -0,0 +1,383 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package provisioner
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"time"
+
+	"k8s.io/klog/v2"
+
+	v1 "k8s.io/api/core/v1"
+	"kubevirt.io/hostpath-provisioner/admin"
+)
+
+// orphanGracePeriod is how old a backing directory without a PV has to be
+// before garbage collection removes it. Provision creates the directory before
+// the PV is stored in the API server.
+const orphanGracePeriod = 10 * time.Minute
+
+// backingDirName matches the names Provision gives to backing directories,
+// the PV name optionally prefixed with the claim name.
+var backingDirName = regexp.MustCompile(`(^|-)pvc-[0-9a-f]{8}-[0-9a-f]{4}-[0-9a-f]{4}-[0-9a-f]{4}-[0-9a-f]{12}$`)
+
+// adminServer serves the admin API, meant for node tooling and the operator.
+type adminServer struct {
+	p *HostPathProvisioner
+	// resync queues all claims and volumes and returns how many were queued
+	resync func() (int, int)
+}
+
+// handler returns the admin API, leaving out the endpoints that change
+// anything when readOnly is set.
+func (s *adminServer) handler(readOnly bool) http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc(admin.VolumesPath, s.method(http.MethodGet, func(*http.Request) (interface{}, error) {
+		return s.volumes()
+	}))
+	mux.HandleFunc(admin.PoolsPath, s.method(http.MethodGet, func(*http.Request) (interface{}, error) {
+		return s.pools()
+	}))
+	mux.HandleFunc(admin.OperationsPath, s.method(http.MethodGet, func(*http.Request) (interface{}, error) {
+		return s.operations(), nil
+	}))
+	if readOnly {
+		return mux
+	}
+	// With ?dryRun=true garbage collection only lists the orphans
+	mux.HandleFunc(admin.GCPath, s.method(http.MethodPost, func(r *http.Request) (interface{}, error) {
+		return s.gc(r.URL.Query().Get("dryRun") == "true")
+	}))
+	mux.HandleFunc(admin.ReconcilePath, s.method(http.MethodPost, func(*http.Request) (interface{}, error) {
+		return s.reconcile(), nil
+	}))
+	mux.HandleFunc(admin.SealPath, s.method(http.MethodPost, func(r *http.Request) (interface{}, error) {
+		return s.seal(r.URL.Query().Get("volume"))
+	}))
+	return mux
+}
+
+// method rejects requests not using the given method, and encodes what the
+// handler returns as JSON.
+func (s *adminServer) method(method string, handler func(r *http.Request) (interface{}, error)) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != method {
+			w.Header().Set("Allow", method)
+			http.Error(w, fmt.Sprintf("method %s not allowed", r.Method), http.StatusMethodNotAllowed)
+			return
+		}
+		result, err := handler(r)
+		if err != nil {
+			klog.ErrorS(err, "Admin request failed", "path", r.URL.Path)
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		if err := json.NewEncoder(w).Encode(result); err != nil {
+			klog.Warningf("unable to write admin response for %s: %v", r.URL.Path, err)
+		}
+	}
+}
+
+// ownVolumes returns the volumes this provisioner created on the node.
+func (s *adminServer) ownVolumes() ([]v1.PersistentVolume, error) {
+	pvs, err := s.p.pvCache.list(context.TODO(), s.p.client)
+	if err != nil {
+		return nil, fmt.Errorf("unable to list persistent volumes: %v", err)
+	}
+	var own []v1.PersistentVolume
+	for _, pv := range pvs.Items {
+		annotations := volumeAnnotations(&pv)
+		if annotations["hostPathProvisionerIdentity"] == s.p.identity && annotations["kubevirt.io/provisionOnNode"] == s.p.nodeName {
+			own = append(own, pv)
+		}
+	}
+	return own, nil
+}
+
+func (s *adminServer) volumes() ([]admin.Volume, error) {
+	pvs, err := s.ownVolumes()
+	if err != nil {
+		return nil, err
+	}
+	volumes := []admin.Volume{}
+	for _, pv := range pvs {
+		path := backingPath(&pv)
+		if path == "" {
+			continue
+		}
+		usage := newVolumeUsage(pv, 0, 0)
+		volume := admin.Volume{
+			Name:      usage.pv,
+			Claim:     usage.claim,
+			Namespace: usage.namespace,
+			Pool:      usage.pool,
+			Path:      path,
+			Health:    pv.Annotations[annVolumeHealth],
+		}
+		if s.p.usage != nil {
+			if measured, ok := s.p.usage.lookup(volume.Path); ok {
+				volume.UsedBytes, volume.UsedInodes = measured.bytes, measured.inodes
+				volume.UsageMeasured = &measured.scanned
+			}
+		}
+		volumes = append(volumes, volume)
+	}
+	return volumes, nil
+}
+
+func (s *adminServer) pools() ([]admin.Pool, error) {
+	reserved, err := s.reserved()
+	if err != nil {
+		return nil, err
+	}
+	pools := []admin.Pool{}
+	for _, pool := range s.p.currentPools() {
+		info := newPoolInfo(s.p.host, pool, reserved[pool.name])
+		info.Capabilities = s.p.capabilities.supported(pool)
+		info.Redundancy = poolRedundancy(pool, s.p.mountsPath)
+		pools = append(pools, info)
+	}
+	return pools, nil
+}
+
+// reserved returns the storage reserved in the pools, from the allocation
+// database when there is one.
+func (s *adminServer) reserved() (map[string]int64, error) {
+	if s.p.allocations != nil {
+		return s.p.allocations.reserved()
+	}
+	pvs, err := s.ownVolumes()
+	if err != nil {
+		return nil, err
+	}
+	return calculatePoolReserved(pvs, s.p.identity, s.p.nodeName), nil
+}
+
+func newPoolInfo(host backend, pool *storagePool, reserved int64) admin.Pool {
+	info := admin.Pool{Name: pool.name, Path: pool.path, Device: pool.device, DeviceClass: pool.deviceClass, ReservedBytes: reserved}
+	stats, err := host.Stat(pool.path)
+	if err != nil {
+		info.Error = err.Error()
+		return info
+	}
+	info.CapacityBytes = stats.capacity
+	info.FreeBytes = stats.available
+	info.UsedBytes = stats.used()
+	info.Inodes = stats.inodes
+	info.InodesFree = stats.inodesFree
+	return info
+}
+
+func (s *adminServer) operations() []admin.Operation {
+	if s.p.operations == nil {
+		return []admin.Operation{}
+	}
+	return s.p.operations.recent()
+}
+
+// gc removes the backing directories that no PV refers to. With dryRun set
+// the directories are only listed.
+func (s *adminServer) gc(dryRun bool) (admin.GCResult, error) {
+	pvs, err := s.p.pvCache.list(context.TODO(), s.p.client)
+	if err != nil {
+		return admin.GCResult{}, fmt.Errorf("unable to list persistent volumes: %v", err)
+	}
+	orphans, err := findOrphans(s.p.currentPools(), pvs.Items, time.Now().Add(-orphanGracePeriod))
+	if err != nil {
+		return admin.GCResult{}, err
+	}
+	result := admin.GCResult{DryRun: dryRun || s.p.dryRun, Removed: []string{}}
+	for _, dir := range orphans {
+		// The PV of a volume the database knows may not be created yet
+		if s.p.allocations.known(dir) {
+			continue
+		}
+		if result.DryRun {
+			result.Removed = append(result.Removed, dir)
+			continue
+		}
+		klog.InfoS("Removing orphaned backing directory", "node", s.p.nodeName, "path", dir)
+		if err := s.p.host.Destroy(dir); err != nil {
+			klog.ErrorS(err, "Failed to remove orphaned backing directory", "node", s.p.nodeName, "path", dir)
+			result.Failed = append(result.Failed, dir)
+			continue
+		}
+		if err := removeVolumeIdentity(dir); err != nil {
+			klog.Warningf("unable to remove the identity file of backing directory %s: %v", dir, err)
+		}
+		result.Removed = append(result.Removed, dir)
+	}
+	return result, nil
+}
+
+// findOrphans returns the backing directories in the pools that no PV refers
+// to and that were last modified before cutoff. PVs of every provisioner and
+// node are considered, so directories shared through a hostPath PV are kept.
+func findOrphans(pools []*storagePool, pvs []v1.PersistentVolume, cutoff time.Time) ([]string, error) {
+	referenced := make(map[string]bool)
+	for _, pv := range pvs {
+		if path := backingPath(&pv); path != "" {
+			referenced[filepath.Clean(path)] = true
+		}
+	}
+	var orphans []string
+	for _, pool := range pools {
+		entries, err := ioutil.ReadDir(pool.path)
+		if err != nil {
+			return nil, fmt.Errorf("unable to read pool %s: %v", pool.name, err)
+		}
+		for _, entry := range entries {
+			if !entry.IsDir() || !backingDirName.MatchString(entry.Name()) || entry.ModTime().After(cutoff) {
+				continue
+			}
+			dir := filepath.Join(pool.path, entry.Name())
+			if !referenced[dir] {
+				orphans = append(orphans, dir)
+			}
+		}
+	}
+	return orphans, nil
+}
+
+func (s *adminServer) reconcile() admin.ReconcileResult {
+	claims, volumes := s.resync()
+	klog.InfoS("Reconciliation requested through the admin API", "node", s.p.nodeName, "claims", claims, "volumes", volumes)
+	return admin.ReconcileResult{Claims: claims, Volumes: volumes}
+}
+
+// errNoVolume is returned when no volume to seal is given.
+var errNoVolume = errors.New("no volume to seal given")
+
+// seal seals the volume with dm-verity.
+func (s *adminServer) seal(volume string) (admin.SealResult, error) {
+	if volume == "" {
+		return admin.SealResult{}, errNoVolume
+	}
+	rootHash, err := s.p.sealVolume(volume)
+	if err != nil {
+		return admin.SealResult{}, err
+	}
+	return admin.SealResult{Volume: volume, RootHash: rootHash}, nil
+}
+
+// peerCredListener only accepts connections from processes running as root
+// or as the same user as the provisioner.
+type peerCredListener struct {
+	net.Listener
+}
+
+func (l peerCredListener) Accept() (net.Conn, error) {
+	for {
+		conn, err := l.Listener.Accept()
+		if err != nil {
+			return nil, err
+		}
+		uid, err := peerUID(conn)
+		if err == nil && peerAllowed(uid) {
+			return conn, nil
+		}
+		if err != nil {
+			klog.Warningf("rejecting admin connection, unable to read peer credentials: %v", err)
+		} else {
+			klog.Warningf("rejecting admin connection from uid %d", uid)
+		}
+		conn.Close()
+	}
+}
+
+// peerUID returns the user id of the process on the other end of a unix
+// socket connection.
+func peerUID(conn net.Conn) (uint32, error) {
+	unixConn, ok := conn.(*net.UnixConn)
+	if !ok {
+		return 0, fmt.Errorf("not a unix socket connection")
+	}
+	raw, err := unixConn.SyscallConn()
+	if err != nil {
+		return 0, err
+	}
+	var uid uint32
+	var credErr error
+	if err := raw.Control(func(fd uintptr) {
+		uid, credErr = peerCredentials(int(fd))
+	}); err != nil {
+		return 0, err
+	}
+	return uid, credErr
+}
+
+func peerAllowed(uid uint32) bool {
+	return uid == 0 || int(uid) == os.Getuid()
+}
+
+// listenAdmin creates the admin socket at path, replacing a stale socket left
+// behind by a previous run. Only the owner can connect to it.
+func listenAdmin(path string) (net.Listener, error) {
+	if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+	listener, err := net.Listen("unix", path)
+	if err != nil {
+		return nil, err
+	}
+	if err := os.Chmod(path, 0600); err != nil {
+		listener.Close()
+		return nil, err
+	}
+	return peerCredListener{listener}, nil
+}
+
+// serveAdmin serves the admin API on the unix socket at path.
+func serveAdmin(path string, p *HostPathProvisioner, resync func() (int, int)) error {
+	listener, err := listenAdmin(path)
+	if err != nil {
+		return fmt.Errorf("unable to listen on admin socket %s: %v", path, err)
+	}
+	server := &adminServer{p: p, resync: resync}
+	klog.Infof("Starting admin server at %s", path)
+	go func() {
+		if err := http.Serve(listener, server.handler(false)); err != nil {
+			klog.Errorf("Failed to serve admin API on %s: %v", path, err)
+		}
+	}()
+	return nil
+}
+
+// serveReadOnlyAdmin serves the read-only part of the admin API on localhost,
+// for support scripts that can't use the unix socket. It can be reached with
+// kubectl port-forward.
+func serveReadOnlyAdmin(port int, p *HostPathProvisioner) {
+	server := &adminServer{p: p}
+	address := net.JoinHostPort("127.0.0.1", strconv.Itoa(port))
+	klog.Infof("Starting read-only admin server at %s", address)
+	go func() {
+		if err := http.ListenAndServe(address, server.handler(true)); err != nil {
+			klog.Errorf("Failed to serve read-only admin API on %s: %v", address, err)
+		}
+	}()
+}
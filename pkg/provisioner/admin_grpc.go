@@ -0,0 +1,103 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package provisioner
+
+import (
+	"context"
+	"fmt"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"k8s.io/klog/v2"
+
+	"kubevirt.io/hostpath-provisioner/admin"
+)
+
+// adminGRPCServer serves the admin API over gRPC, answering like the HTTP
+// endpoints.
+type adminGRPCServer struct {
+	s *adminServer
+}
+
+func (g adminGRPCServer) Volumes(ctx context.Context, req *admin.VolumesRequest) (*admin.VolumesResponse, error) {
+	volumes, err := g.s.volumes()
+	if err != nil {
+		return nil, adminError("Volumes", err)
+	}
+	return &admin.VolumesResponse{Volumes: volumes}, nil
+}
+
+func (g adminGRPCServer) Pools(ctx context.Context, req *admin.PoolsRequest) (*admin.PoolsResponse, error) {
+	pools, err := g.s.pools()
+	if err != nil {
+		return nil, adminError("Pools", err)
+	}
+	return &admin.PoolsResponse{Pools: pools}, nil
+}
+
+func (g adminGRPCServer) Operations(ctx context.Context, req *admin.OperationsRequest) (*admin.OperationsResponse, error) {
+	return &admin.OperationsResponse{Operations: g.s.operations()}, nil
+}
+
+func (g adminGRPCServer) GC(ctx context.Context, req *admin.GCRequest) (*admin.GCResult, error) {
+	result, err := g.s.gc(req.DryRun)
+	if err != nil {
+		return nil, adminError("GC", err)
+	}
+	return &result, nil
+}
+
+func (g adminGRPCServer) Reconcile(ctx context.Context, req *admin.ReconcileRequest) (*admin.ReconcileResult, error) {
+	result := g.s.reconcile()
+	return &result, nil
+}
+
+func (g adminGRPCServer) Seal(ctx context.Context, req *admin.SealRequest) (*admin.SealResult, error) {
+	result, err := g.s.seal(req.Volume)
+	if err == errNoVolume {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+	if err != nil {
+		return nil, adminError("Seal", err)
+	}
+	return &result, nil
+}
+
+// adminError logs a failed gRPC admin request and returns its status.
+func adminError(method string, err error) error {
+	klog.ErrorS(err, "Admin request failed", "method", method)
+	return status.Error(codes.Internal, err.Error())
+}
+
+// serveAdminGRPC serves the gRPC admin API on the unix socket at path, which
+// like the HTTP one only root and the provisioner's user can connect to.
+func serveAdminGRPC(path string, p *HostPathProvisioner, resync func() (int, int)) error {
+	listener, err := listenAdmin(path)
+	if err != nil {
+		return fmt.Errorf("unable to listen on gRPC admin socket %s: %v", path, err)
+	}
+	server := grpc.NewServer()
+	admin.RegisterAdminServer(server, adminGRPCServer{s: &adminServer{p: p, resync: resync}})
+	klog.Infof("Starting gRPC admin server at %s", path)
+	go func() {
+		if err := server.Serve(listener); err != nil {
+			klog.Errorf("Failed to serve gRPC admin API on %s: %v", path, err)
+		}
+	}()
+	return nil
+}
@@ -0,0 +1,190 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package provisioner
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	v1 "k8s.io/api/core/v1"
+	"kubevirt.io/hostpath-provisioner/admin"
+)
+
+func Test_findOrphans(t *testing.T) {
+	dir, err := ioutil.TempDir("", "admin")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	const (
+		bound   = "pvc-11111111-2222-3333-4444-555555555555"
+		orphan  = "pvc-aaaaaaaa-bbbb-cccc-dddd-eeeeeeeeeeee"
+		named   = "data-pvc-aaaaaaaa-bbbb-cccc-dddd-ffffffffffff"
+		recent  = "pvc-99999999-bbbb-cccc-dddd-eeeeeeeeeeee"
+		foreign = "not-a-volume"
+		csi     = "pvc-66666666-2222-3333-4444-555555555555"
+	)
+	old := time.Now().Add(-time.Hour)
+	for _, name := range []string{bound, orphan, named, recent, foreign, csi} {
+		if err := os.Mkdir(filepath.Join(dir, name), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if name != recent {
+			os.Chtimes(filepath.Join(dir, name), old, old)
+		}
+	}
+	pvs := []v1.PersistentVolume{{
+		Spec: v1.PersistentVolumeSpec{PersistentVolumeSource: v1.PersistentVolumeSource{
+			HostPath: &v1.HostPathVolumeSource{Path: filepath.Join(dir, bound) + "/"},
+		}},
+	}}
+	// Volumes of the CSI driver only have their backing directory in their
+	// volume context
+	pvs = append(pvs, *csiVolume(defaultCSIDriverName, createPv("testId", "testNode", filepath.Join(dir, csi))))
+
+	got, err := findOrphans([]*storagePool{{name: "default", path: dir}}, pvs, time.Now().Add(-orphanGracePeriod))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{filepath.Join(dir, named), filepath.Join(dir, orphan)}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("findOrphans() = %v, want %v", got, want)
+	}
+}
+
+func Test_adminServer(t *testing.T) {
+	// The admin socket checks the peer credentials of connections
+	requireLinux(t)
+	dir, err := ioutil.TempDir("", "admin")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	socket := filepath.Join(dir, "admin.sock")
+	listener, err := listenAdmin(socket)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+	if info, err := os.Stat(socket); err != nil || info.Mode().Perm() != 0600 {
+		t.Errorf("admin socket mode = %v, %v, want 0600", info.Mode().Perm(), err)
+	}
+	server := &adminServer{
+		p:      &HostPathProvisioner{nodeName: "node"},
+		resync: func() (int, int) { return 2, 3 },
+	}
+	go http.Serve(listener, server.handler(false))
+
+	client := &http.Client{Transport: &http.Transport{
+		DialContext: func(context.Context, string, string) (net.Conn, error) {
+			return net.Dial("unix", socket)
+		},
+	}}
+	resp, err := client.Post("http://admin/v1/reconcile", "", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	result := admin.ReconcileResult{}
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		t.Fatal(err)
+	}
+	if result != (admin.ReconcileResult{Claims: 2, Volumes: 3}) {
+		t.Errorf("reconcile = %+v", result)
+	}
+
+	resp, err = client.Get("http://admin/v1/reconcile")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusMethodNotAllowed {
+		t.Errorf("GET /v1/reconcile status = %d, want %d", resp.StatusCode, http.StatusMethodNotAllowed)
+	}
+}
+
+func Test_serveAdminGRPC(t *testing.T) {
+	// The admin socket checks the peer credentials of connections
+	requireLinux(t)
+	dir, err := ioutil.TempDir("", "admin")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	socket := filepath.Join(dir, "admin-grpc.sock")
+	p := &HostPathProvisioner{nodeName: "node", operations: newOperationLog(1)}
+	if err := serveAdminGRPC(socket, p, func() (int, int) { return 2, 3 }); err != nil {
+		t.Fatal(err)
+	}
+	if info, err := os.Stat(socket); err != nil || info.Mode().Perm() != 0600 {
+		t.Errorf("gRPC admin socket mode = %v, %v, want 0600", info.Mode().Perm(), err)
+	}
+	client, err := admin.NewGRPCClient(socket, 10*time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+
+	result, err := client.Reconcile()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if *result != (admin.ReconcileResult{Claims: 2, Volumes: 3}) {
+		t.Errorf("Reconcile() = %+v", result)
+	}
+	operations, err := client.Operations()
+	if err != nil || len(operations) != 0 {
+		t.Errorf("Operations() = %v, %v, want none", operations, err)
+	}
+	if _, err := client.Seal(""); status.Code(err) != codes.InvalidArgument {
+		t.Errorf("Seal() without volume, error = %v, want InvalidArgument", err)
+	}
+}
+
+func Test_readOnlyAdminHandler(t *testing.T) {
+	server := &adminServer{p: &HostPathProvisioner{operations: newOperationLog(1)}}
+	handler := server.handler(true)
+	for path, want := range map[string]int{
+		"/v1/operations": http.StatusOK,
+		"/v1/gc":         http.StatusNotFound,
+		"/v1/reconcile":  http.StatusNotFound,
+	} {
+		method := http.MethodGet
+		if path != "/v1/operations" {
+			method = http.MethodPost
+		}
+		recorder := httptest.NewRecorder()
+		handler.ServeHTTP(recorder, httptest.NewRequest(method, path, nil))
+		if recorder.Code != want {
+			t.Errorf("%s %s status = %d, want %d", method, path, recorder.Code, want)
+		}
+	}
+}
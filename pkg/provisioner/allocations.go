@@ -0,0 +1,353 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package provisioner
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	"k8s.io/klog/v2"
+)
+
+// volumesBucket is the bucket of the allocation database holding the volumes,
+// by PV name.
+var volumesBucket = []byte("volumes")
+
+// allocationDBLockTimeout is how long a transaction waits for another process
+// holding the database.
+const allocationDBLockTimeout = 10 * time.Second
+
+// States of allocations
+const (
+	allocationActive   = "active"
+	allocationDeleting = "deleting"
+)
+
+// allocation is a volume on the node, as recorded in the allocation
+// database.
+type allocation struct {
+	PV        string `json:"pv"`
+	Namespace string `json:"namespace,omitempty"`
+	Claim     string `json:"claim,omitempty"`
+	Pool      string `json:"pool"`
+	// Path is the backing directory, or the device of volumes given whole
+	// devices
+	Path string `json:"path"`
+	// Size is the storage requested by the claim, in bytes
+	Size    int64     `json:"size"`
+	State   string    `json:"state"`
+	Created time.Time `json:"created"`
+}
+
+// allocationOf returns the allocation of the volume provisioned on this node.
+func allocationOf(pv *v1.PersistentVolume) allocation {
+	annotations := volumeAnnotations(pv)
+	a := allocation{
+		PV:      pv.Name,
+		Pool:    annotations[annStoragePool],
+		Path:    backingPath(pv),
+		State:   allocationActive,
+		Created: pv.CreationTimestamp.Time,
+	}
+	if a.Pool == "" {
+		a.Pool = defaultPoolName
+	}
+	if a.Path == "" {
+		a.Path = annotations[annDevice]
+	}
+	if a.Created.IsZero() {
+		a.Created = time.Now()
+	}
+	if pv.Spec.ClaimRef != nil {
+		a.Namespace, a.Claim = pv.Spec.ClaimRef.Namespace, pv.Spec.ClaimRef.Name
+	}
+	if requested, err := resource.ParseQuantity(annotations[annRequestedCapacity]); err == nil {
+		a.Size = requested.Value()
+	}
+	return a
+}
+
+// allocationDB keeps the volumes on the node in a BoltDB database, one
+// record per volume. A nil database records nothing.
+type allocationDB struct {
+	path string
+
+	// mutex serializes the transactions of the provisioner, the database
+	// file is locked against other processes while it is open
+	mutex sync.Mutex
+}
+
+// openAllocationDB opens the database in the file at path, creating it when
+// missing. It returns whether the file existed.
+func openAllocationDB(path string) (*allocationDB, bool, error) {
+	_, err := os.Stat(path)
+	existed := err == nil
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return nil, false, err
+	}
+	db := &allocationDB{path: path}
+	if err := db.update(func(*bolt.Bucket) error { return nil }); err != nil {
+		return nil, false, fmt.Errorf("unable to open allocation database %s: %v", path, err)
+	}
+	return db, existed, nil
+}
+
+// openAllocations opens the allocation database and reconciles it with the
+// PVs of the node. A new database is rebuilt from the PVs and the pools on
+// disk, an existing one is used as it is when the PVs can not be listed.
+func (p *HostPathProvisioner) openAllocations(path string) (*allocationDB, error) {
+	db, existed, err := openAllocationDB(path)
+	if err != nil {
+		return nil, err
+	}
+	pvs, err := p.pvCache.list(context.TODO(), p.client)
+	if err != nil && !existed {
+		return nil, fmt.Errorf("unable to list persistent volumes to create the allocation database: %v", err)
+	} else if err != nil {
+		klog.Warningf("unable to list persistent volumes, using the allocation database %s without reconciling it: %v", path, err)
+		return db, nil
+	}
+	if existed {
+		added, removed, err := db.reconcile(pvs.Items, p.identity, p.nodeName)
+		if err != nil {
+			return nil, fmt.Errorf("unable to reconcile allocation database %s: %v", path, err)
+		}
+		klog.InfoS("Reconciled allocation database", "node", p.nodeName, "path", path, "added", added, "removed", removed)
+		return db, nil
+	}
+	unmatched, err := rebuildAllocations(db, p.currentPools(), pvs.Items, p.identity, p.nodeName, p.quarantineUnmatched, time.Now().Add(-orphanGracePeriod))
+	if err != nil {
+		return nil, fmt.Errorf("unable to create allocation database %s: %v", path, err)
+	}
+	klog.InfoS("Rebuilt allocation database", "node", p.nodeName, "path", path, "unmatched", unmatched)
+	return db, nil
+}
+
+// reconcile makes the database match the volumes of this provisioner on the
+// node: volumes without a record are added, records of volumes whose PV is
+// gone are removed. It returns how many were added and removed.
+func (db *allocationDB) reconcile(pvs []v1.PersistentVolume, identity, nodeName string) (int, int, error) {
+	own := make(map[string]allocation)
+	for i := range pvs {
+		pv := &pvs[i]
+		annotations := volumeAnnotations(pv)
+		if annotations["hostPathProvisionerIdentity"] != identity || annotations["kubevirt.io/provisionOnNode"] != nodeName {
+			continue
+		}
+		own[pv.Name] = allocationOf(pv)
+	}
+	added, removed := 0, 0
+	err := db.update(func(bucket *bolt.Bucket) error {
+		var gone [][]byte
+		err := bucket.ForEach(func(name, value []byte) error {
+			a, ok := own[string(name)]
+			if !ok {
+				gone = append(gone, name)
+				return nil
+			}
+			var recorded allocation
+			if err := json.Unmarshal(value, &recorded); err == nil && recorded.State == allocationDeleting {
+				// The PV outlived an interrupted deletion
+				a.State = allocationDeleting
+				own[string(name)] = a
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+		for _, name := range gone {
+			klog.Warningf("volume %s is in the allocation database but has no PV, forgetting it", name)
+			if err := bucket.Delete(name); err != nil {
+				return err
+			}
+			removed++
+		}
+		for name, a := range own {
+			if bucket.Get([]byte(name)) == nil {
+				added++
+			}
+			if err := putAllocation(bucket, a); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	return added, removed, err
+}
+
+// add records the volume.
+func (db *allocationDB) add(a allocation) {
+	if db == nil {
+		return
+	}
+	if err := db.update(func(bucket *bolt.Bucket) error {
+		return putAllocation(bucket, a)
+	}); err != nil {
+		klog.Warningf("unable to record volume %s in the allocation database: %v", a.PV, err)
+	}
+}
+
+// setState records the state of the volume, if it is known.
+func (db *allocationDB) setState(pv, state string) {
+	if db == nil {
+		return
+	}
+	if err := db.update(func(bucket *bolt.Bucket) error {
+		value := bucket.Get([]byte(pv))
+		if value == nil {
+			return nil
+		}
+		var a allocation
+		if err := json.Unmarshal(value, &a); err != nil {
+			return err
+		}
+		if a.State == state {
+			return nil
+		}
+		a.State = state
+		return putAllocation(bucket, a)
+	}); err != nil {
+		klog.Warningf("unable to record volume %s as %s in the allocation database: %v", pv, state, err)
+	}
+}
+
+// remove forgets the volume.
+func (db *allocationDB) remove(pv string) {
+	if db == nil {
+		return
+	}
+	if err := db.update(func(bucket *bolt.Bucket) error {
+		return bucket.Delete([]byte(pv))
+	}); err != nil {
+		klog.Warningf("unable to remove volume %s from the allocation database: %v", pv, err)
+	}
+}
+
+// list returns the volumes, by name.
+func (db *allocationDB) list() ([]allocation, error) {
+	var allocations []allocation
+	err := db.view(func(bucket *bolt.Bucket) error {
+		return bucket.ForEach(func(name, value []byte) error {
+			var a allocation
+			if err := json.Unmarshal(value, &a); err != nil {
+				return fmt.Errorf("invalid record of volume %s: %v", name, err)
+			}
+			allocations = append(allocations, a)
+			return nil
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
+	sort.Slice(allocations, func(i, j int) bool {
+		return allocations[i].PV < allocations[j].PV
+	})
+	return allocations, nil
+}
+
+// reserved sums up, per pool, the storage requested by the claims of the
+// volumes, like calculatePoolReserved does from the PVs.
+func (db *allocationDB) reserved() (map[string]int64, error) {
+	allocations, err := db.list()
+	if err != nil {
+		return nil, err
+	}
+	reserved := make(map[string]int64)
+	for _, a := range allocations {
+		reserved[a.Pool] += a.Size
+	}
+	return reserved, nil
+}
+
+// known returns whether a volume is recorded with the backing directory. A
+// database that can not be read knows every directory, so that nothing is
+// removed on its account.
+func (db *allocationDB) known(path string) bool {
+	if db == nil {
+		return false
+	}
+	allocations, err := db.list()
+	if err != nil {
+		klog.Warningf("unable to read the allocation database: %v", err)
+		return true
+	}
+	path = filepath.Clean(path)
+	for _, a := range allocations {
+		if filepath.Clean(a.Path) == path {
+			return true
+		}
+	}
+	return false
+}
+
+// update runs fn in a read-write transaction on the volumes. The database is
+// opened for every transaction instead of being kept open, BoltDB locks the
+// file while it is open and the replicas on a node, or the old and the new
+// pod during an upgrade, share it.
+func (db *allocationDB) update(fn func(*bolt.Bucket) error) error {
+	db.mutex.Lock()
+	defer db.mutex.Unlock()
+	b, err := bolt.Open(db.path, 0600, &bolt.Options{Timeout: allocationDBLockTimeout})
+	if err != nil {
+		return err
+	}
+	defer b.Close()
+	return b.Update(func(tx *bolt.Tx) error {
+		bucket, err := tx.CreateBucketIfNotExists(volumesBucket)
+		if err != nil {
+			return err
+		}
+		return fn(bucket)
+	})
+}
+
+// view runs fn in a read-only transaction on the volumes.
+func (db *allocationDB) view(fn func(*bolt.Bucket) error) error {
+	db.mutex.Lock()
+	defer db.mutex.Unlock()
+	b, err := bolt.Open(db.path, 0600, &bolt.Options{Timeout: allocationDBLockTimeout, ReadOnly: true})
+	if err != nil {
+		return err
+	}
+	defer b.Close()
+	return b.View(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket(volumesBucket)
+		if bucket == nil {
+			return nil
+		}
+		return fn(bucket)
+	})
+}
+
+// putAllocation stores the record of the volume.
+func putAllocation(bucket *bolt.Bucket, a allocation) error {
+	value, err := json.Marshal(a)
+	if err != nil {
+		return err
+	}
+	return bucket.Put([]byte(a.PV), value)
+}
@@ -0,0 +1,169 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package provisioner
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func Test_allocationDB(t *testing.T) {
+	dir, err := ioutil.TempDir("", "allocations")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "state", "allocations.db")
+
+	db, existed, err := openAllocationDB(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if existed {
+		t.Error("openAllocationDB() of a missing file reported it existed")
+	}
+	db.add(allocation{PV: "pvc-1", Pool: "fast", Path: "/fast/pvc-1", Size: 1 << 30, State: allocationActive})
+	db.add(allocation{PV: "pvc-2", Pool: "fast", Path: "/fast/pvc-2", Size: 2 << 30, State: allocationActive})
+	db.add(allocation{PV: "pvc-3", Pool: defaultPoolName, Path: "/default/pvc-3", Size: 5 << 30, State: allocationActive})
+	db.setState("pvc-2", allocationDeleting)
+	db.remove("pvc-3")
+
+	// A restart finds the volumes again
+	reopened, existed, err := openAllocationDB(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !existed {
+		t.Error("openAllocationDB() of the database reported it missing")
+	}
+	list, err := reopened.list()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(list) != 2 || list[0].PV != "pvc-1" || list[1].PV != "pvc-2" || list[1].State != allocationDeleting {
+		t.Errorf("list() = %+v, want pvc-1 and pvc-2 being deleted", list)
+	}
+	if reserved, err := reopened.reserved(); err != nil || !reflect.DeepEqual(reserved, map[string]int64{"fast": 3 << 30}) {
+		t.Errorf("reserved() = %v, %v, want fast holding 3Gi", reserved, err)
+	}
+	if !reopened.known("/fast/pvc-1/") || reopened.known("/default/pvc-3") {
+		t.Error("known() does not match the recorded backing directories")
+	}
+
+	if err := ioutil.WriteFile(path, []byte("not a database"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := openAllocationDB(path); err == nil {
+		t.Error("openAllocationDB() of a corrupt file succeeded")
+	}
+
+	// Without a database nothing is recorded
+	var none *allocationDB
+	none.add(allocation{PV: "pvc-4"})
+	none.setState("pvc-4", allocationDeleting)
+	none.remove("pvc-4")
+	if none.known("/fast/pvc-4") {
+		t.Error("known() without a database = true")
+	}
+}
+
+func Test_allocationDBReconcile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "allocations")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	pv := func(name, identity, node string, annotations map[string]string) v1.PersistentVolume {
+		pv := v1.PersistentVolume{ObjectMeta: metav1.ObjectMeta{Name: name, Annotations: map[string]string{
+			"hostPathProvisionerIdentity": identity,
+			"kubevirt.io/provisionOnNode": node,
+		}}}
+		for k, v := range annotations {
+			pv.Annotations[k] = v
+		}
+		pv.Spec.HostPath = &v1.HostPathVolumeSource{Path: "/pool/" + name}
+		pv.Spec.ClaimRef = &v1.ObjectReference{Namespace: "ns", Name: "claim-" + name}
+		return pv
+	}
+	pvs := []v1.PersistentVolume{
+		pv("pvc-1", "id", "node1", map[string]string{annStoragePool: "fast", annRequestedCapacity: "1Gi"}),
+		pv("pvc-2", "id", "node1", map[string]string{annRequestedCapacity: "2Gi"}),
+		pv("pvc-3", "id", "node2", map[string]string{annRequestedCapacity: "4Gi"}),
+		pv("pvc-4", "other", "node1", map[string]string{annRequestedCapacity: "8Gi"}),
+	}
+
+	db, _, err := openAllocationDB(filepath.Join(dir, "allocations.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	// A volume whose PV is gone, and one whose deletion was interrupted
+	db.add(allocation{PV: "pvc-gone", Pool: "fast", Path: "/pool/pvc-gone", Size: 16 << 30, State: allocationActive})
+	db.add(allocation{PV: "pvc-2", Path: "/pool/pvc-2", State: allocationDeleting})
+	added, removed, err := db.reconcile(pvs, "id", "node1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if added != 1 || removed != 1 {
+		t.Errorf("reconcile() added %d and removed %d volumes, want 1 and 1", added, removed)
+	}
+	list, err := db.list()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(list) != 2 {
+		t.Fatalf("reconcile() recorded %+v, want pvc-1 and pvc-2", list)
+	}
+	if a := list[0]; a.Pool != "fast" || a.Path != "/pool/pvc-1" || a.Namespace != "ns" || a.Claim != "claim-pvc-1" || a.State != allocationActive {
+		t.Errorf("reconcile() recorded %+v for pvc-1", a)
+	}
+	if a := list[1]; a.State != allocationDeleting || a.Size != 2<<30 {
+		t.Errorf("reconcile() recorded %+v for pvc-2, want it still being deleted", a)
+	}
+	if reserved, err := db.reserved(); err != nil || !reflect.DeepEqual(reserved, calculatePoolReserved(pvs, "id", "node1")) {
+		t.Errorf("reserved() = %v, %v, want %v as from the PVs", reserved, err, calculatePoolReserved(pvs, "id", "node1"))
+	}
+}
+
+func Test_candidatePoolsReserved(t *testing.T) {
+	memory := newMemoryBackend(10 * GiB)
+	dir, err := ioutil.TempDir("", "allocations")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	p := simulatedProvisioner(memory, &storagePool{name: "a", path: "/simulated/a"}, &storagePool{name: "b", path: "/simulated/b"})
+	if p.allocations, _, err = openAllocationDB(filepath.Join(dir, "allocations.db")); err != nil {
+		t.Fatal(err)
+	}
+	p.allocations.add(allocation{PV: "pvc-1", Pool: "b", Path: "/simulated/b/pvc-1", Size: 6 * GiB, State: allocationActive})
+	candidates, err := p.candidatePools(resource.MustParse("5Gi"), p.currentRounding(), "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(candidates) != 1 || candidates[0].pool.name != "a" {
+		t.Errorf("candidatePools() = %+v, want only a, b has 6Gi of its 10Gi reserved", candidates)
+	}
+}
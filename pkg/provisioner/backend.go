@@ -0,0 +1,61 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package provisioner
+
+import (
+	"os"
+)
+
+// backend performs the filesystem operations volumes are provisioned,
+// measured and deleted with, so that the logic deciding on them can be
+// tested, and run, without touching the node's filesystems.
+//
+// It only covers plain backing directories. Logical volumes, images, mkfs,
+// mounts, ACLs, SELinux labels and identity xattrs are made with tools and
+// system calls working on the node, they are skipped or refused when the
+// provisioner is simulated, see simulatedVolume. There is no quota
+// operation: the size of plain directories is not enforced, and namespace
+// quotas are counted from the PVs, see quotaManager.
+type backend interface {
+	// Stat returns the size and usage of the filesystem containing path.
+	Stat(path string) (filesystemStats, error)
+	// Create creates the backing directory path with the mode and, unless
+	// they are -1, owned by uid and gid. An existing directory is updated.
+	Create(path string, mode os.FileMode, uid, gid int) error
+	// Destroy removes the backing directory path and everything in it.
+	Destroy(path string) error
+	// Usage returns the space allocated to, and the inodes used by, the
+	// tree at path.
+	Usage(path string) (int64, int64, error)
+}
+
+// filesystemStats are the size and usage of a filesystem. available is what
+// unprivileged users can still write, less than free when blocks are
+// reserved for root.
+type filesystemStats struct {
+	capacity   int64
+	free       int64
+	available  int64
+	inodes     int64
+	inodesFree int64
+	readOnly   bool
+}
+
+// used returns the bytes used in the filesystem.
+func (s filesystemStats) used() int64 {
+	return s.capacity - s.free
+}
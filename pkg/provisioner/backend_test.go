@@ -0,0 +1,60 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package provisioner
+
+import (
+	"fmt"
+	"runtime"
+	"testing"
+)
+
+// statBackend is the node's backend with the filesystem stats replaced.
+type statBackend struct {
+	backend
+	stats map[string]filesystemStats
+}
+
+func (b statBackend) Stat(path string) (filesystemStats, error) {
+	stats, ok := b.stats[path]
+	if !ok {
+		return filesystemStats{}, fmt.Errorf("no filesystem at %s", path)
+	}
+	return stats, nil
+}
+
+// requireLinux skips tests of what only Linux has, see platform_darwin.go.
+func requireLinux(t *testing.T) {
+	if runtime.GOOS != "linux" {
+		t.Skipf("needs Linux, running on %s", runtime.GOOS)
+	}
+}
+
+func Test_poolSpace(t *testing.T) {
+	host := statBackend{backend: hostBackend(), stats: map[string]filesystemStats{
+		"/var/hpvolumes": {capacity: 100*GiB + 300*MiB, free: 60 * GiB, available: 55 * GiB},
+	}}
+	capacity, err := calculateRoundedPvCapacity(host, "/var/hpvolumes", capacityRounding{})
+	if err != nil || capacity.String() != "100Gi" {
+		t.Errorf("calculateRoundedPvCapacity() = %v, %v, want 100Gi", capacity, err)
+	}
+	if free, err := calculatePoolFree(host, "/var/hpvolumes"); err != nil || free != 55*GiB {
+		t.Errorf("calculatePoolFree() = %d, %v, want %d", free, err, 55*GiB)
+	}
+	if _, err := calculatePoolFree(host, "/var/missing"); err == nil {
+		t.Errorf("calculatePoolFree() of a missing pool succeeded")
+	}
+}
@@ -0,0 +1,143 @@
+//go:build linux || darwin
+// +build linux darwin
+
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package provisioner
+
+import (
+	"os"
+	"path/filepath"
+	"syscall"
+
+	"golang.org/x/sys/unix"
+	"k8s.io/klog/v2"
+)
+
+// hostBackend returns the backend working with the node's filesystems.
+func hostBackend() backend {
+	return unixBackend{}
+}
+
+// unixBackend works with the filesystems of the node with the system calls
+// of Linux, or those of macOS for development.
+type unixBackend struct{}
+
+var _ backend = unixBackend{}
+
+// Stat implements backend, redundant btrfs filesystems report the data they
+// can hold, see statPool.
+func (unixBackend) Stat(path string) (filesystemStats, error) {
+	statfs, err := statPool(path)
+	if err != nil {
+		return filesystemStats{}, err
+	}
+	return statsOf(statfs), nil
+}
+
+// statsOf converts statfs to filesystemStats.
+func statsOf(statfs *unix.Statfs_t) filesystemStats {
+	return filesystemStats{
+		capacity:   int64(statfs.Blocks) * int64(statfs.Bsize),
+		free:       int64(statfs.Bfree) * int64(statfs.Bsize),
+		available:  int64(statfs.Bavail) * int64(statfs.Bsize),
+		inodes:     int64(statfs.Files),
+		inodesFree: int64(statfs.Ffree),
+		readOnly:   statfs.Flags&statfsReadOnly != 0,
+	}
+}
+
+// Create implements backend.
+func (unixBackend) Create(path string, mode os.FileMode, uid, gid int) error {
+	return createBackingDir(path, mode, uid, gid)
+}
+
+// Destroy implements backend.
+func (unixBackend) Destroy(path string) error {
+	return os.RemoveAll(path)
+}
+
+// Usage implements backend, like du.
+func (unixBackend) Usage(path string) (int64, int64, error) {
+	return diskUsage(path)
+}
+
+// statPool returns the statfs of the filesystem of a pool. statfs of btrfs
+// can not account for the copies of RAID1, RAID10 and the like, the sizes of
+// their devices can differ, so for redundant btrfs profiles the usage btrfs
+// estimates replaces the capacity and free space of statfs, in blocks of its
+// block size.
+func statPool(path string) (*unix.Statfs_t, error) {
+	statfs := &unix.Statfs_t{}
+	if err := unix.Statfs(path, statfs); err != nil {
+		return nil, err
+	}
+	if uint32(statfs.Type) != btrfsSuperMagic || statfs.Bsize <= 0 {
+		return statfs, nil
+	}
+	usage, err := btrfsUsages.get(path)
+	if err != nil {
+		klog.V(3).Infof("unable to read the btrfs usage of %s, using statfs: %v", path, err)
+		return statfs, nil
+	}
+	if usage.dataRatio <= 1 {
+		return statfs, nil
+	}
+	applyBtrfsUsage(statfs, usage)
+	return statfs, nil
+}
+
+// applyBtrfsUsage replaces the block counts of statfs with the usage.
+func applyBtrfsUsage(statfs *unix.Statfs_t, usage btrfsUsage) {
+	bsize := uint64(statfs.Bsize)
+	statfs.Blocks = uint64(usage.capacity()) / bsize
+	statfs.Bavail = uint64(usage.free) / bsize
+	statfs.Bfree = statfs.Bavail
+}
+
+// diskUsage returns the disk space allocated to, and the number of inodes
+// used by, the tree at path, like du. Hard linked files are counted once.
+func diskUsage(path string) (int64, int64, error) {
+	var bytes, inodes int64
+	seen := make(map[uint64]bool)
+	err := filepath.Walk(path, func(_ string, info os.FileInfo, err error) error {
+		if err != nil {
+			// Files can disappear while walking a volume in use
+			if os.IsNotExist(err) {
+				return nil
+			}
+			return err
+		}
+		stat, ok := info.Sys().(*syscall.Stat_t)
+		if !ok {
+			bytes += info.Size()
+			inodes++
+			return nil
+		}
+		if stat.Nlink > 1 {
+			if seen[stat.Ino] {
+				return nil
+			}
+			seen[stat.Ino] = true
+		}
+		// st_blocks is always in units of 512 bytes
+		bytes += stat.Blocks * 512
+		inodes++
+		return nil
+	})
+	return bytes, inodes, err
+}
@@ -0,0 +1,120 @@
+//go:build linux || darwin
+// +build linux darwin
+
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package provisioner
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"golang.org/x/sys/unix"
+)
+
+func Test_unixBackend(t *testing.T) {
+	dir, err := ioutil.TempDir("", "backend")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	b := unixBackend{}
+	path := filepath.Join(dir, "pvc-1")
+	if err := b.Create(path, 0750, -1, -1); err != nil {
+		t.Fatal(err)
+	}
+	if info, err := os.Stat(path); err != nil || info.Mode().Perm() != 0750 {
+		t.Fatalf("backing directory = %v, %v, want mode 0750", info, err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(path, "data"), make([]byte, 8192), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if bytes, inodes, err := b.Usage(path); err != nil || bytes < 8192 || inodes != 2 {
+		t.Errorf("Usage() = %d, %d, %v, want at least 8192 bytes in 2 inodes", bytes, inodes, err)
+	}
+	stats, err := b.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stats.capacity <= 0 || stats.available > stats.free || stats.free > stats.capacity || stats.used() < 0 {
+		t.Errorf("Stat() = %+v, inconsistent", stats)
+	}
+	if err := b.Destroy(path); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(path); !os.IsNotExist(err) {
+		t.Errorf("backing directory not removed: %v", err)
+	}
+	if _, err := b.Stat(path); err == nil {
+		t.Errorf("Stat() of a removed directory succeeded")
+	}
+}
+
+func Test_applyBtrfsUsage(t *testing.T) {
+	// statfs of the RAID1 filesystem above counts both devices
+	statfs := &unix.Statfs_t{Bsize: 4096, Blocks: 21474836480 / 4096, Bfree: 17179607040 / 4096, Bavail: 17179607040 / 4096}
+	usage, err := parseBtrfsUsage(btrfsRAID1Usage)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := usage.capacity(), int64(2147614720+9640488960); got != want {
+		t.Errorf("capacity() = %d, want %d", got, want)
+	}
+	applyBtrfsUsage(statfs, usage)
+	if got, want := int64(statfs.Blocks)*int64(statfs.Bsize), usage.capacity()/4096*4096; got != want {
+		t.Errorf("capacity = %d, want %d", got, want)
+	}
+	if got, want := int64(statfs.Bavail)*int64(statfs.Bsize), int64(9640488960); got != want {
+		t.Errorf("free = %d, want %d", got, want)
+	}
+	if got := usagePercent(statsOf(statfs)); got != 19 {
+		t.Errorf("usagePercent() = %d, want 19", got)
+	}
+}
+
+func Test_diskUsage(t *testing.T) {
+	dir, err := ioutil.TempDir("", "usage")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	data := make([]byte, 64*KiB)
+	if err := os.Mkdir(filepath.Join(dir, "sub"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(dir, "sub", "file"), data, 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Link(filepath.Join(dir, "sub", "file"), filepath.Join(dir, "link")); err != nil {
+		t.Fatal(err)
+	}
+
+	bytes, inodes, err := diskUsage(dir)
+	if err != nil {
+		t.Fatalf("diskUsage() error = %v", err)
+	}
+	// the directory, the subdirectory and the hard linked file
+	if inodes != 3 {
+		t.Errorf("diskUsage() inodes = %d, want 3", inodes)
+	}
+	if bytes < 64*KiB || bytes >= 2*64*KiB {
+		t.Errorf("diskUsage() bytes = %d, want the file counted once", bytes)
+	}
+}
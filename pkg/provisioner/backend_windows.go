@@ -0,0 +1,168 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package provisioner
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"golang.org/x/sys/windows"
+)
+
+// hostBackend returns the backend working with the node's filesystems.
+func hostBackend() backend {
+	return windowsBackend{}
+}
+
+// windowsBackend works with the volumes of a Windows node. Windows has
+// neither numeric owners nor inodes: the mode of backing directories is
+// translated to an ACL, the owner is left to the creator, and no inodes are
+// reported.
+type windowsBackend struct{}
+
+var _ backend = windowsBackend{}
+
+// Stat implements backend with GetDiskFreeSpaceEx, available is what is
+// left within the quota of the provisioner's user.
+func (windowsBackend) Stat(path string) (filesystemStats, error) {
+	name, err := windows.UTF16PtrFromString(path)
+	if err != nil {
+		return filesystemStats{}, err
+	}
+	var available, capacity, free uint64
+	if err := windows.GetDiskFreeSpaceEx(name, &available, &capacity, &free); err != nil {
+		return filesystemStats{}, &os.PathError{Op: "GetDiskFreeSpaceEx", Path: path, Err: err}
+	}
+	readOnly, err := readOnlyVolume(path)
+	if err != nil {
+		return filesystemStats{}, err
+	}
+	return filesystemStats{
+		capacity:  int64(capacity),
+		free:      int64(free),
+		available: int64(available),
+		readOnly:  readOnly,
+	}, nil
+}
+
+// readOnlyVolume returns whether the volume containing path is read-only.
+func readOnlyVolume(path string) (bool, error) {
+	name, err := windows.UTF16PtrFromString(path)
+	if err != nil {
+		return false, err
+	}
+	root := make([]uint16, windows.MAX_PATH+1)
+	if err := windows.GetVolumePathName(name, &root[0], uint32(len(root))); err != nil {
+		return false, &os.PathError{Op: "GetVolumePathName", Path: path, Err: err}
+	}
+	var flags uint32
+	if err := windows.GetVolumeInformation(&root[0], nil, 0, nil, nil, &flags, nil, 0); err != nil {
+		return false, &os.PathError{Op: "GetVolumeInformation", Path: path, Err: err}
+	}
+	return flags&windows.FILE_READ_ONLY_VOLUME != 0, nil
+}
+
+// Create implements backend, the mode is applied as the ACL of the
+// directory and uid and gid are ignored.
+func (windowsBackend) Create(path string, mode os.FileMode, uid, gid int) error {
+	if err := os.MkdirAll(path, 0777); err != nil {
+		return err
+	}
+	sd, err := windows.SecurityDescriptorFromString(directorySDDL(mode))
+	if err != nil {
+		return err
+	}
+	dacl, _, err := sd.DACL()
+	if err != nil {
+		return err
+	}
+	// The ACL is protected, nothing is inherited from the pool directory
+	info := windows.SECURITY_INFORMATION(windows.DACL_SECURITY_INFORMATION | windows.PROTECTED_DACL_SECURITY_INFORMATION)
+	if err := windows.SetNamedSecurityInfo(path, windows.SE_FILE_OBJECT, info, nil, nil, dacl, nil); err != nil {
+		return &os.PathError{Op: "SetNamedSecurityInfo", Path: path, Err: err}
+	}
+	return nil
+}
+
+// directorySDDL returns the security descriptor, in SDDL, of a backing
+// directory with the mode. The owner bits go to SYSTEM and the
+// administrators, which containers run as, the group bits to the users of the
+// node and the other bits to everyone. The entries are inherited by
+// everything created in the directory.
+func directorySDDL(mode os.FileMode) string {
+	perm := mode.Perm()
+	sddl := "D:P"
+	for _, entry := range []struct {
+		bits    os.FileMode
+		trustee string
+	}{
+		{bits: perm >> 6, trustee: "SY"},
+		{bits: perm >> 6, trustee: "BA"},
+		{bits: perm >> 3 & 07, trustee: "BU"},
+		{bits: perm & 07, trustee: "WD"},
+	} {
+		if rights := fileRights(entry.bits); rights != "" {
+			sddl += fmt.Sprintf("(A;OICI;%s;;;%s)", rights, entry.trustee)
+		}
+	}
+	return sddl
+}
+
+// fileRights returns the SDDL file rights of rwx permission bits.
+func fileRights(bits os.FileMode) string {
+	if bits&07 == 07 {
+		return "FA"
+	}
+	var rights []string
+	if bits&04 != 0 {
+		rights = append(rights, "FR")
+	}
+	if bits&02 != 0 {
+		rights = append(rights, "FW")
+	}
+	if bits&01 != 0 {
+		rights = append(rights, "FX")
+	}
+	return strings.Join(rights, "")
+}
+
+// Destroy implements backend.
+func (windowsBackend) Destroy(path string) error {
+	return os.RemoveAll(path)
+}
+
+// Usage implements backend, the size of the files in the tree is counted as
+// Windows does not report the space allocated to them, every file and
+// directory as an inode.
+func (windowsBackend) Usage(path string) (int64, int64, error) {
+	var bytes, inodes int64
+	err := filepath.Walk(path, func(_ string, info os.FileInfo, err error) error {
+		if err != nil {
+			// Files can disappear while walking a volume in use
+			if os.IsNotExist(err) {
+				return nil
+			}
+			return err
+		}
+		bytes += info.Size()
+		inodes++
+		return nil
+	})
+	return bytes, inodes, err
+}
@@ -0,0 +1,75 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package provisioner
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func Test_directorySDDL(t *testing.T) {
+	tests := []struct {
+		mode os.FileMode
+		want string
+	}{
+		{mode: 0777, want: "D:P(A;OICI;FA;;;SY)(A;OICI;FA;;;BA)(A;OICI;FA;;;BU)(A;OICI;FA;;;WD)"},
+		{mode: 0750, want: "D:P(A;OICI;FA;;;SY)(A;OICI;FA;;;BA)(A;OICI;FRFX;;;BU)"},
+		{mode: 0700, want: "D:P(A;OICI;FA;;;SY)(A;OICI;FA;;;BA)"},
+		{mode: 0764, want: "D:P(A;OICI;FA;;;SY)(A;OICI;FA;;;BA)(A;OICI;FRFW;;;BU)(A;OICI;FR;;;WD)"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.mode.String(), func(t *testing.T) {
+			if got := directorySDDL(tt.mode); got != tt.want {
+				t.Errorf("directorySDDL() = %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_windowsBackend(t *testing.T) {
+	dir, err := ioutil.TempDir("", "backend")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	b := windowsBackend{}
+	path := filepath.Join(dir, "pvc-1")
+	if err := b.Create(path, 0750, -1, -1); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(path, "data"), make([]byte, 8192), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if bytes, inodes, err := b.Usage(path); err != nil || bytes != 8192 || inodes != 2 {
+		t.Errorf("Usage() = %d, %d, %v, want 8192 bytes in 2 inodes", bytes, inodes, err)
+	}
+	stats, err := b.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stats.capacity <= 0 || stats.free > stats.capacity || stats.readOnly {
+		t.Errorf("Stat() = %+v, inconsistent", stats)
+	}
+	if err := b.Destroy(path); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(path); !os.IsNotExist(err) {
+		t.Errorf("backing directory not removed: %v", err)
+	}
+}
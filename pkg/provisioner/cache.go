@@ -0,0 +1,399 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package provisioner
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"k8s.io/klog/v2"
+
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/client-go/tools/record"
+)
+
+const (
+	// cacheWritethrough caches reads, writes go to the SSD and the pool's
+	// disks before they complete, losing the SSD loses no data.
+	cacheWritethrough = "writethrough"
+	// cacheWriteback completes writes once they are on the SSD, they are
+	// written to the pool's disks later.
+	cacheWriteback = "writeback"
+
+	// cacheSuffix is appended to the thin pool to name the cache volume the
+	// provisioner creates on the SSD, caches named otherwise are left alone.
+	cacheSuffix = "_hppcache"
+)
+
+// poolCache is the SSD caching the thin pool of an LVM pool with dm-cache.
+type poolCache struct {
+	// device is the SSD, or a partition of it, empty for pools that are not
+	// cached
+	device string
+	mode   string
+}
+
+// newPoolCache returns the cache of a pool, only thin pools can be cached.
+// The mode defaults to writethrough.
+func newPoolCache(device, mode string, lvm *lvmPool) (poolCache, error) {
+	if device == "" {
+		if mode != "" {
+			return poolCache{}, fmt.Errorf("cache mode %q set without a cache device", mode)
+		}
+		return poolCache{}, nil
+	}
+	if lvm == nil || lvm.thinPool == "" {
+		return poolCache{}, fmt.Errorf("only the thin pools of LVM pools can be cached")
+	}
+	switch mode {
+	case "":
+		mode = cacheWritethrough
+	case cacheWritethrough, cacheWriteback:
+	default:
+		return poolCache{}, fmt.Errorf("invalid cache mode %q, expected %s or %s", mode, cacheWritethrough, cacheWriteback)
+	}
+	return poolCache{device: device, mode: mode}, nil
+}
+
+// cacheVolume returns the name of the cache volume the provisioner creates
+// for the thin pool.
+func (l *lvmPool) cacheVolume() string {
+	return l.thinPool + cacheSuffix
+}
+
+// cachedBy returns the cache volume caching the data of the thin pool, empty
+// if it is not cached.
+func (l *lvmPool) cachedBy() (string, error) {
+	output, err := l.command.run("lvs", "-a", "--noheadings", "-o", "lv_name,segtype,pool_lv", l.volumeGroup)
+	if err != nil {
+		return "", err
+	}
+	return parseCachedBy(string(output), l.thinPool), nil
+}
+
+// parseCachedBy returns the cache volume of the data of the thin pool in the
+// lv_name,segtype,pool_lv listing of lvs -a, without the brackets of hidden
+// volumes and the suffix LVM gives to attached cache volumes.
+func parseCachedBy(output, thinPool string) string {
+	for _, line := range strings.Split(output, "\n") {
+		fields := strings.Fields(line)
+		if len(fields) != 3 || strings.Trim(fields[0], "[]") != thinPool+"_tdata" || fields[1] != "cache" {
+			continue
+		}
+		return strings.TrimSuffix(strings.Trim(fields[2], "[]"), "_cvol")
+	}
+	return ""
+}
+
+// cacheDevice returns the device the attached cache volume of the thin pool
+// lives on.
+func (l *lvmPool) cacheDevice() (string, error) {
+	output, err := l.command.run("lvs", "-a", "--noheadings", "-o", "devices", l.volumeGroup+"/"+l.cacheVolume()+"_cvol")
+	if err != nil {
+		return "", err
+	}
+	devices := strings.TrimSpace(string(output))
+	if i := strings.Index(devices, "("); i >= 0 {
+		devices = devices[:i]
+	}
+	if devices == "" {
+		return "", fmt.Errorf("no device found for cache volume %s", l.cacheVolume())
+	}
+	return devices, nil
+}
+
+// attachCache turns the device into a physical volume of the volume group,
+// unless it is one already, creates the cache volume on all of it and attaches
+// it to the thin pool. Devices holding a filesystem or belonging to another
+// volume group are refused.
+func (l *lvmPool) attachCache(cache poolCache) error {
+	volumeGroup := ""
+	if output, err := l.command.run("pvs", "--noheadings", "-o", "vg_name", cache.device); err == nil {
+		volumeGroup = strings.TrimSpace(string(output))
+	} else if _, err := l.command.run("pvcreate", cache.device); err != nil {
+		// pvcreate refuses devices holding a filesystem without --yes
+		return err
+	}
+	switch volumeGroup {
+	case l.volumeGroup:
+	case "":
+		if _, err := l.command.run("vgextend", l.volumeGroup, cache.device); err != nil {
+			return err
+		}
+	default:
+		return fmt.Errorf("cache device %s belongs to volume group %s, not %s", cache.device, volumeGroup, l.volumeGroup)
+	}
+	if _, err := l.command.run("lvs", l.volumeGroup+"/"+l.cacheVolume()); err != nil {
+		if _, err := l.command.run("lvcreate", "--yes", "-n", l.cacheVolume(), "-l", "100%PVS", l.volumeGroup, cache.device); err != nil {
+			return err
+		}
+	}
+	_, err := l.command.run("lvconvert", "--yes", "--type", "cache", "--cachevol", l.volumeGroup+"/"+l.cacheVolume(), "--cachemode", cache.mode, l.String())
+	return err
+}
+
+// detachCache writes the dirty blocks of the cache back to the pool's disks,
+// detaches and removes the cache volume, and takes its device out of the
+// volume group again.
+func (l *lvmPool) detachCache() error {
+	device, err := l.cacheDevice()
+	if err != nil {
+		return err
+	}
+	if _, err := l.command.run("lvconvert", "--yes", "--uncache", l.String()); err != nil {
+		return err
+	}
+	if _, err := l.command.run("vgreduce", l.volumeGroup, device); err != nil {
+		return err
+	}
+	_, err = l.command.run("pvremove", device)
+	return err
+}
+
+// setupCache attaches the configured cache to the thin pool of the pool, and
+// detaches the one the provisioner attached earlier when the pool no longer
+// asks for it or asks for another device. Caches attached by others are left
+// alone.
+func setupCache(pool *storagePool) error {
+	if pool.lvm == nil || pool.lvm.thinPool == "" {
+		return nil
+	}
+	cachedBy, err := pool.lvm.cachedBy()
+	if err != nil {
+		return err
+	}
+	if cachedBy != "" && cachedBy != pool.lvm.cacheVolume() {
+		if pool.cache.device != "" {
+			return fmt.Errorf("thin pool %s is already cached by %s", pool.lvm, cachedBy)
+		}
+		return nil
+	}
+	if cachedBy != "" {
+		if pool.cache.device != "" {
+			device, err := pool.lvm.cacheDevice()
+			if err != nil {
+				return err
+			}
+			if device == pool.cache.device {
+				return nil
+			}
+		}
+		klog.InfoS("Detaching pool cache", "pool", pool.name, "thinPool", pool.lvm.String())
+		if err := pool.lvm.detachCache(); err != nil {
+			return err
+		}
+	}
+	if pool.cache.device == "" {
+		return nil
+	}
+	klog.InfoS("Attaching pool cache", "pool", pool.name, "thinPool", pool.lvm.String(), "device", pool.cache.device, "mode", pool.cache.mode)
+	return pool.lvm.attachCache(pool.cache)
+}
+
+// cacheStats are the statistics of a dm-cache.
+type cacheStats struct {
+	totalBlocks, usedBlocks, dirtyBlocks int64
+	readHits, readMisses                 int64
+	// health is the health status LVM reports, empty when healthy
+	health string
+}
+
+// readCacheStats reads the statistics of the cache of the thin pool's data.
+func (l *lvmPool) readCacheStats() (cacheStats, error) {
+	output, err := l.command.run("lvs", "--noheadings", "--separator", ",", "-o", "cache_total_blocks,cache_used_blocks,cache_dirty_blocks,cache_read_hits,cache_read_misses,lv_health_status", l.String()+"_tdata")
+	if err != nil {
+		return cacheStats{}, err
+	}
+	return parseCacheStats(string(output))
+}
+
+// parseCacheStats parses the comma separated cache statistics read by
+// readCacheStats.
+func parseCacheStats(output string) (cacheStats, error) {
+	fields := strings.Split(strings.TrimSpace(output), ",")
+	if len(fields) != 6 {
+		return cacheStats{}, fmt.Errorf("unexpected cache statistics %q", output)
+	}
+	var values [5]int64
+	for i := range values {
+		value, err := strconv.ParseInt(strings.TrimSpace(fields[i]), 10, 64)
+		if err != nil {
+			return cacheStats{}, fmt.Errorf("unexpected cache statistics %q: %v", output, err)
+		}
+		values[i] = value
+	}
+	return cacheStats{
+		totalBlocks: values[0],
+		usedBlocks:  values[1],
+		dirtyBlocks: values[2],
+		readHits:    values[3],
+		readMisses:  values[4],
+		health:      strings.TrimSpace(fields[5]),
+	}, nil
+}
+
+// poolCacheMonitor sets up the caches of the pools at start up, checks their
+// health and stops placing new volumes in pools whose cache is unhealthy.
+type poolCacheMonitor struct {
+	nodeRef       *v1.ObjectReference
+	eventRecorder record.EventRecorder
+
+	usedRatio    *prometheus.GaugeVec
+	dirtyBlocks  *prometheus.GaugeVec
+	readHitRatio *prometheus.GaugeVec
+	healthy      *prometheus.GaugeVec
+
+	mutex sync.Mutex
+	// unhealthy holds the health status of the unhealthy caches, keyed by
+	// pool name
+	unhealthy map[string]string
+}
+
+var _ prometheus.Collector = &poolCacheMonitor{}
+
+func newPoolCacheMonitor(nodeName string, eventRecorder record.EventRecorder) *poolCacheMonitor {
+	return &poolCacheMonitor{
+		nodeRef: &v1.ObjectReference{
+			Kind: "Node",
+			Name: nodeName,
+			UID:  types.UID(nodeName),
+		},
+		eventRecorder: eventRecorder,
+		usedRatio: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Namespace: metricsNamespace,
+			Name:      "pool_cache_used_ratio",
+			Help:      "Share of the blocks of the pool's SSD cache in use.",
+		}, []string{"pool"}),
+		dirtyBlocks: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Namespace: metricsNamespace,
+			Name:      "pool_cache_dirty_blocks",
+			Help:      "Blocks of the pool's SSD cache not written back to its disks yet.",
+		}, []string{"pool"}),
+		readHitRatio: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Namespace: metricsNamespace,
+			Name:      "pool_cache_read_hit_ratio",
+			Help:      "Share of the reads of the pool served by its SSD cache.",
+		}, []string{"pool"}),
+		healthy: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Namespace: metricsNamespace,
+			Name:      "pool_cache_healthy",
+			Help:      "Whether LVM reports the pool's SSD cache as healthy.",
+		}, []string{"pool"}),
+		unhealthy: make(map[string]string),
+	}
+}
+
+// setup attaches and detaches the caches of the pools as configured. A pool
+// whose cache can not be set up is used without it.
+func (m *poolCacheMonitor) setup(pools []*storagePool) {
+	for _, pool := range pools {
+		if err := setupCache(pool); err != nil {
+			klog.ErrorS(err, "Failed to set up pool cache", "pool", pool.name, "device", pool.cache.device)
+			m.eventRecorder.Eventf(m.nodeRef, v1.EventTypeWarning, "PoolCacheSetupFailed", "Unable to set up the cache of hostpath pool %s: %v", pool.name, err)
+		}
+	}
+}
+
+// Run checks the caches of all pools every interval until stopCh is closed.
+func (m *poolCacheMonitor) Run(pools func() []*storagePool, interval time.Duration, stopCh <-chan struct{}) {
+	wait.Until(func() {
+		for _, pool := range pools() {
+			if pool.cache.device != "" {
+				m.check(pool)
+			}
+		}
+	}, interval, stopCh)
+}
+
+func (m *poolCacheMonitor) check(pool *storagePool) {
+	stats, err := pool.lvm.readCacheStats()
+	if err != nil {
+		klog.Warningf("unable to read the cache statistics of pool %s: %v", pool.name, err)
+		return
+	}
+	m.update(pool, stats)
+}
+
+// update records the statistics of the pool's cache, emitting an event when
+// the cache becomes unhealthy or recovers.
+func (m *poolCacheMonitor) update(pool *storagePool, stats cacheStats) {
+	if stats.totalBlocks > 0 {
+		m.usedRatio.WithLabelValues(pool.name).Set(float64(stats.usedBlocks) / float64(stats.totalBlocks))
+	}
+	m.dirtyBlocks.WithLabelValues(pool.name).Set(float64(stats.dirtyBlocks))
+	if reads := stats.readHits + stats.readMisses; reads > 0 {
+		m.readHitRatio.WithLabelValues(pool.name).Set(float64(stats.readHits) / float64(reads))
+	}
+	healthy := 1.0
+	if stats.health != "" {
+		healthy = 0
+	}
+	m.healthy.WithLabelValues(pool.name).Set(healthy)
+
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	_, wasUnhealthy := m.unhealthy[pool.name]
+	if stats.health != "" {
+		m.unhealthy[pool.name] = stats.health
+		if !wasUnhealthy {
+			klog.Errorf("cache of pool %s is unhealthy: %s, not placing new volumes in the pool", pool.name, stats.health)
+			m.eventRecorder.Eventf(m.nodeRef, v1.EventTypeWarning, "PoolCacheUnhealthy", "Cache %s of hostpath pool %s is unhealthy: %s, no new volumes are placed in the pool", pool.cache.device, pool.name, stats.health)
+		}
+		return
+	}
+	if wasUnhealthy {
+		delete(m.unhealthy, pool.name)
+		klog.Infof("cache of pool %s is healthy again", pool.name)
+		m.eventRecorder.Eventf(m.nodeRef, v1.EventTypeNormal, "PoolCacheHealthy", "Cache %s of hostpath pool %s is healthy again, new volumes are placed in the pool", pool.cache.device, pool.name)
+	}
+}
+
+// usable returns whether new volumes can be placed in the pool.
+func (m *poolCacheMonitor) usable(pool *storagePool) bool {
+	if m == nil {
+		return true
+	}
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	health, unhealthy := m.unhealthy[pool.name]
+	if unhealthy {
+		klog.V(3).Infof("skipping pool %s, its cache is unhealthy: %s", pool.name, health)
+	}
+	return !unhealthy
+}
+
+// Describe implements prometheus.Collector.
+func (m *poolCacheMonitor) Describe(ch chan<- *prometheus.Desc) {
+	m.usedRatio.Describe(ch)
+	m.dirtyBlocks.Describe(ch)
+	m.readHitRatio.Describe(ch)
+	m.healthy.Describe(ch)
+}
+
+// Collect implements prometheus.Collector.
+func (m *poolCacheMonitor) Collect(ch chan<- prometheus.Metric) {
+	m.usedRatio.Collect(ch)
+	m.dirtyBlocks.Collect(ch)
+	m.readHitRatio.Collect(ch)
+	m.healthy.Collect(ch)
+}
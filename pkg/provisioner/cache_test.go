@@ -0,0 +1,194 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package provisioner
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	"k8s.io/client-go/tools/record"
+)
+
+func Test_newPoolCache(t *testing.T) {
+	thin := &lvmPool{volumeGroup: "vg0", thinPool: "thin", fsType: "ext4"}
+	tests := []struct {
+		name         string
+		device, mode string
+		lvm          *lvmPool
+		want         poolCache
+		wantErr      bool
+	}{
+		{name: "none", lvm: thin},
+		{name: "default mode", device: "/dev/nvme0n1", lvm: thin, want: poolCache{device: "/dev/nvme0n1", mode: cacheWritethrough}},
+		{name: "writeback", device: "/dev/nvme0n1", mode: "writeback", lvm: thin, want: poolCache{device: "/dev/nvme0n1", mode: cacheWriteback}},
+		{name: "invalid mode", device: "/dev/nvme0n1", mode: "writearound", lvm: thin, wantErr: true},
+		{name: "mode without device", mode: "writeback", lvm: thin, wantErr: true},
+		{name: "directory pool", device: "/dev/nvme0n1", wantErr: true},
+		{name: "thick pool", device: "/dev/nvme0n1", lvm: &lvmPool{volumeGroup: "vg0", fsType: "ext4"}, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := newPoolCache(tt.device, tt.mode, tt.lvm)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("newPoolCache() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("newPoolCache() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_parseCachedBy(t *testing.T) {
+	const output = `  data                 Vwi-aotz--
+  [lvol0_pmspare]      linear
+  thin                 thin-pool
+  [thin_hppcache_cvol] linear
+  [thin_tdata]         cache     [thin_hppcache_cvol]
+  [thin_tmeta]         linear
+`
+	if got := parseCachedBy(output, "thin"); got != "thin_hppcache" {
+		t.Errorf("parseCachedBy() = %q, want thin_hppcache", got)
+	}
+	if got := parseCachedBy(output, "other"); got != "" {
+		t.Errorf("parseCachedBy() of an uncached thin pool = %q", got)
+	}
+}
+
+func Test_parseCacheStats(t *testing.T) {
+	got, err := parseCacheStats("  8192,2048,16,900,100,\n")
+	if err != nil {
+		t.Fatalf("parseCacheStats() failed: %v", err)
+	}
+	want := cacheStats{totalBlocks: 8192, usedBlocks: 2048, dirtyBlocks: 16, readHits: 900, readMisses: 100}
+	if got != want {
+		t.Errorf("parseCacheStats() = %+v, want %+v", got, want)
+	}
+	if got, _ := parseCacheStats("8192,2048,16,900,100,failed"); got.health != "failed" {
+		t.Errorf("parseCacheStats() health = %q, want failed", got.health)
+	}
+	if _, err := parseCacheStats("8192,2048"); err == nil {
+		t.Errorf("parseCacheStats() of truncated output succeeded")
+	}
+}
+
+func Test_setupCache(t *testing.T) {
+	const (
+		uncached = "  thin thin-pool\n  [thin_tdata] linear\n"
+		ours     = "  thin thin-pool\n  [thin_tdata] cache [thin_hppcache_cvol]\n"
+		foreign  = "  thin thin-pool\n  [thin_tdata] cache [fast_cvol]\n"
+		listing  = "lvm lvs -a --noheadings -o lv_name,segtype,pool_lv vg0"
+		devices  = "lvm lvs -a --noheadings -o devices vg0/thin_hppcache_cvol"
+	)
+	attach := []string{
+		listing,
+		"lvm pvs --noheadings -o vg_name /dev/nvme0n1",
+		"lvm pvcreate /dev/nvme0n1",
+		"lvm vgextend vg0 /dev/nvme0n1",
+		"lvm lvs vg0/thin_hppcache",
+		"lvm lvcreate --yes -n thin_hppcache -l 100%PVS vg0 /dev/nvme0n1",
+		"lvm lvconvert --yes --type cache --cachevol vg0/thin_hppcache --cachemode writethrough vg0/thin",
+	}
+	detach := []string{
+		listing,
+		devices,
+		"lvm lvconvert --yes --uncache vg0/thin",
+		"lvm vgreduce vg0 /dev/sdc",
+		"lvm pvremove /dev/sdc",
+	}
+	tests := []struct {
+		name    string
+		device  string
+		outputs map[string]string
+		want    []string
+		wantErr bool
+	}{
+		{name: "attach", device: "/dev/nvme0n1", outputs: map[string]string{listing: uncached}, want: attach},
+		{name: "attached", device: "/dev/nvme0n1", outputs: map[string]string{listing: ours, devices: "  /dev/nvme0n1(0)\n"}, want: []string{listing, devices}},
+		{name: "removed", outputs: map[string]string{listing: ours, devices: "  /dev/sdc(0)\n"}, want: detach},
+		{name: "not cached", outputs: map[string]string{listing: uncached}, want: []string{listing}},
+		{name: "foreign cache", outputs: map[string]string{listing: foreign}, want: []string{listing}},
+		{name: "foreign cache configured", device: "/dev/nvme0n1", outputs: map[string]string{listing: foreign}, want: []string{listing}, wantErr: true},
+		{name: "other volume group", device: "/dev/nvme0n1", outputs: map[string]string{listing: uncached, "lvm pvs --noheadings -o vg_name /dev/nvme0n1": "  vg1\n"},
+			want: []string{listing, "lvm pvs --noheadings -o vg_name /dev/nvme0n1"}, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			outputs := map[string]string{}
+			for _, command := range append(attach, detach...) {
+				outputs[command] = ""
+			}
+			delete(outputs, "lvm pvs --noheadings -o vg_name /dev/nvme0n1")
+			delete(outputs, "lvm lvs vg0/thin_hppcache")
+			for command, output := range tt.outputs {
+				outputs[command] = output
+			}
+			commands, restore := fakeCommands(outputs)
+			defer restore()
+			thin := &lvmPool{volumeGroup: "vg0", thinPool: "thin", fsType: "ext4"}
+			cache, err := newPoolCache(tt.device, "", thin)
+			if err != nil {
+				t.Fatal(err)
+			}
+			err = setupCache(&storagePool{name: "hdd", path: "/pools/hdd", lvm: thin, cache: cache})
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("setupCache() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !reflect.DeepEqual(*commands, tt.want) {
+				t.Errorf("commands = %v, want %v", *commands, tt.want)
+			}
+		})
+	}
+}
+
+func Test_poolCacheMonitorUpdate(t *testing.T) {
+	recorder := record.NewFakeRecorder(10)
+	m := newPoolCacheMonitor("test-node", recorder)
+	pool := &storagePool{name: "hdd", path: "/pools/hdd", cache: poolCache{device: "/dev/nvme0n1", mode: cacheWriteback}}
+
+	steps := []struct {
+		health     string
+		wantUsable bool
+		wantReason string
+	}{
+		{wantUsable: true},
+		{health: "failed", wantUsable: false, wantReason: "PoolCacheUnhealthy"},
+		{health: "failed", wantUsable: false},
+		{wantUsable: true, wantReason: "PoolCacheHealthy"},
+	}
+	for i, step := range steps {
+		m.update(pool, cacheStats{totalBlocks: 100, usedBlocks: 50, health: step.health})
+		if got := m.usable(pool); got != step.wantUsable {
+			t.Errorf("step %d: usable() = %v, want %v", i, got, step.wantUsable)
+		}
+		select {
+		case event := <-recorder.Events:
+			if step.wantReason == "" || !strings.Contains(event, step.wantReason) {
+				t.Errorf("step %d: unexpected event %q", i, event)
+			}
+		default:
+			if step.wantReason != "" {
+				t.Errorf("step %d: no %s event", i, step.wantReason)
+			}
+		}
+	}
+	var disabled *poolCacheMonitor
+	if !disabled.usable(pool) {
+		t.Error("usable() = false without pool caches")
+	}
+}
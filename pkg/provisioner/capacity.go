@@ -0,0 +1,157 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package provisioner
+
+import (
+	"fmt"
+	"strconv"
+
+	"k8s.io/apimachinery/pkg/api/resource"
+	"sigs.k8s.io/sig-storage-lib-external-provisioner/v13/controller"
+)
+
+const (
+	// annExactCapacity on a claim asks for the PV capacity to be reported
+	// without rounding.
+	annExactCapacity = "kubevirt.io/exactCapacity"
+	// exactCapacityParameter is the StorageClass parameter doing the same for
+	// all claims of the class.
+	exactCapacityParameter = "exactCapacity"
+)
+
+// roundingDirection determines how the capacity reported on PVs is rounded.
+type roundingDirection string
+
+const (
+	roundDown roundingDirection = "down"
+	roundUp   roundingDirection = "up"
+	roundNone roundingDirection = "none"
+)
+
+// capacityRounding describes how the raw capacity of a pool is turned into
+// the capacity reported on PVs.
+type capacityRounding struct {
+	direction roundingDirection
+	// unit is the multiple the capacity is rounded to, its format (binary or
+	// decimal SI) is also used for the reported capacity. When unit is nil the
+	// capacity is rounded to GiB or MiB, whichever gives at least 10 units.
+	unit *resource.Quantity
+}
+
+var defaultCapacityRounding = capacityRounding{direction: roundDown}
+
+// parseCapacityRounding parses a rounding direction (up, down or none) and a
+// unit such as Gi, G, 512Mi or "auto".
+func parseCapacityRounding(direction, unit string) (capacityRounding, error) {
+	rounding := defaultCapacityRounding
+	switch roundingDirection(direction) {
+	case "":
+	case roundDown, roundUp, roundNone:
+		rounding.direction = roundingDirection(direction)
+	default:
+		return rounding, fmt.Errorf("invalid rounding direction %q, expected up, down or none", direction)
+	}
+	if unit == "" || unit == "auto" {
+		return rounding, nil
+	}
+	// Allow bare suffixes like Gi or M as a shorthand for one unit
+	if unit[0] < '0' || unit[0] > '9' {
+		unit = "1" + unit
+	}
+	quantity, err := resource.ParseQuantity(unit)
+	if err != nil {
+		return rounding, fmt.Errorf("invalid rounding unit %q: %v", unit, err)
+	}
+	if quantity.Value() <= 0 {
+		return rounding, fmt.Errorf("invalid rounding unit %q, must be positive", unit)
+	}
+	rounding.unit = &quantity
+	return rounding, nil
+}
+
+// round converts a number of bytes into the quantity reported on PVs.
+func (r capacityRounding) round(capacityBytes int64) *resource.Quantity {
+	if r.direction == roundNone {
+		return resource.NewQuantity(capacityBytes, resource.BinarySI)
+	}
+	if r.unit == nil {
+		if r.direction == roundUp {
+			return resource.NewQuantity(roundUpCapacityPretty(capacityBytes), resource.BinarySI)
+		}
+		return resource.NewQuantity(roundDownCapacityPretty(capacityBytes), resource.BinarySI)
+	}
+	unit := r.unit.Value()
+	units := capacityBytes / unit
+	if r.direction == roundUp && capacityBytes%unit != 0 {
+		units++
+	}
+	return resource.NewQuantity(units*unit, r.unit.Format)
+}
+
+// roundingFor returns the rounding to use for the volume of a claim. The
+// claim's annotation takes precedence over the StorageClass parameter.
+func (p *HostPathProvisioner) roundingFor(options controller.ProvisionOptions) (capacityRounding, error) {
+	exact := ""
+	if options.StorageClass != nil {
+		exact = options.StorageClass.Parameters[exactCapacityParameter]
+	}
+	if options.PVC != nil {
+		if value, ok := options.PVC.Annotations[annExactCapacity]; ok {
+			exact = value
+		}
+	}
+	if exact == "" {
+		return p.currentRounding(), nil
+	}
+	enabled, err := strconv.ParseBool(exact)
+	if err != nil {
+		return p.currentRounding(), fmt.Errorf("invalid %s value %q: %v", exactCapacityParameter, exact, err)
+	}
+	if !enabled {
+		return p.currentRounding(), nil
+	}
+	return capacityRounding{direction: roundNone}, nil
+}
+
+// roundUpCapacityPretty is the counterpart of roundDownCapacityPretty, it
+// rounds up to the nearest easy to read unit such that there are at least 10
+// units at that size.
+func roundUpCapacityPretty(capacityBytes int64) int64 {
+	easyToReadUnitsBytes := []int64{GiB, MiB}
+
+	for _, easyToReadUnitBytes := range easyToReadUnitsBytes {
+		size := capacityBytes / easyToReadUnitBytes
+		if size >= 10 {
+			if capacityBytes%easyToReadUnitBytes != 0 {
+				size++
+			}
+			return size * easyToReadUnitBytes
+		}
+	}
+	return capacityBytes
+}
+
+// calculateRoundedPvCapacity returns the total size of the filesystem
+// containing path, the data it can hold with redundant btrfs profiles, rounded
+// as configured.
+func calculateRoundedPvCapacity(host backend, path string, rounding capacityRounding) (*resource.Quantity, error) {
+	stats, err := host.Stat(path)
+	if err != nil {
+		return nil, err
+	}
+	return rounding.round(stats.capacity), nil
+}
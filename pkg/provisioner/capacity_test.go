@@ -0,0 +1,135 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package provisioner
+
+import (
+	"testing"
+
+	v1 "k8s.io/api/core/v1"
+	storage "k8s.io/api/storage/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/sig-storage-lib-external-provisioner/v13/controller"
+)
+
+func Test_parseCapacityRounding(t *testing.T) {
+	tests := []struct {
+		name          string
+		direction     string
+		unit          string
+		wantDirection roundingDirection
+		wantUnit      string
+		wantErr       bool
+	}{
+		{name: "defaults", wantDirection: roundDown},
+		{name: "auto unit", direction: "up", unit: "auto", wantDirection: roundUp},
+		{name: "binary suffix", direction: "down", unit: "Gi", wantDirection: roundDown, wantUnit: "1Gi"},
+		{name: "decimal suffix", direction: "up", unit: "G", wantDirection: roundUp, wantUnit: "1G"},
+		{name: "quantity", direction: "none", unit: "100Mi", wantDirection: roundNone, wantUnit: "100Mi"},
+		{name: "invalid direction", direction: "sideways", wantErr: true},
+		{name: "invalid unit", unit: "lots", wantErr: true},
+		{name: "zero unit", unit: "0", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := parseCapacityRounding(tt.direction, tt.unit)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("parseCapacityRounding() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+			if got.direction != tt.wantDirection {
+				t.Errorf("parseCapacityRounding() direction = %s, want %s", got.direction, tt.wantDirection)
+			}
+			gotUnit := ""
+			if got.unit != nil {
+				gotUnit = got.unit.String()
+			}
+			if gotUnit != tt.wantUnit {
+				t.Errorf("parseCapacityRounding() unit = %q, want %q", gotUnit, tt.wantUnit)
+			}
+		})
+	}
+}
+
+func Test_capacityRoundingRound(t *testing.T) {
+	tests := []struct {
+		name      string
+		direction string
+		unit      string
+		size      int64
+		want      string
+	}{
+		{name: "default rounds down pretty", size: 20*GiB - 2, want: "19Gi"},
+		{name: "up rounds up pretty", direction: "up", size: 19*GiB + 2, want: "20Gi"},
+		{name: "up keeps exact size", direction: "up", size: 20 * GiB, want: "20Gi"},
+		{name: "up pretty falls back to MiB", direction: "up", size: 2*GiB - 2, want: "2Gi"},
+		{name: "none keeps bytes", direction: "none", size: 20*GiB - 2, want: "21474836478"},
+		{name: "down to decimal unit", direction: "down", unit: "G", size: 20 * GiB, want: "21G"},
+		{name: "up to decimal unit", direction: "up", unit: "G", size: 20 * GiB, want: "22G"},
+		{name: "down to binary multiple", direction: "down", unit: "512Mi", size: 20*GiB + 600*MiB, want: "20992Mi"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rounding, err := parseCapacityRounding(tt.direction, tt.unit)
+			if err != nil {
+				t.Fatalf("parseCapacityRounding() error = %v", err)
+			}
+			if got := rounding.round(tt.size); got.String() != tt.want {
+				t.Errorf("round() = %s, want %s", got.String(), tt.want)
+			}
+		})
+	}
+}
+
+func Test_roundingFor(t *testing.T) {
+	tests := []struct {
+		name       string
+		annotation string
+		parameter  string
+		want       roundingDirection
+		wantErr    bool
+	}{
+		{name: "provisioner default", want: roundUp},
+		{name: "class asks for exact capacity", parameter: "true", want: roundNone},
+		{name: "claim asks for exact capacity", annotation: "true", want: roundNone},
+		{name: "claim overrides class", annotation: "false", parameter: "true", want: roundUp},
+		{name: "invalid value", annotation: "maybe", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := &HostPathProvisioner{rounding: capacityRounding{direction: roundUp}}
+			options := controller.ProvisionOptions{
+				PVC:          &v1.PersistentVolumeClaim{ObjectMeta: metav1.ObjectMeta{Annotations: map[string]string{}}},
+				StorageClass: &storage.StorageClass{Parameters: map[string]string{}},
+			}
+			if tt.annotation != "" {
+				options.PVC.Annotations[annExactCapacity] = tt.annotation
+			}
+			if tt.parameter != "" {
+				options.StorageClass.Parameters[exactCapacityParameter] = tt.parameter
+			}
+			got, err := p.roundingFor(options)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("roundingFor() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !tt.wantErr && got.direction != tt.want {
+				t.Errorf("roundingFor() direction = %s, want %s", got.direction, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,326 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package provisioner
+
+import (
+	"sync"
+	"time"
+
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/meta"
+	"k8s.io/client-go/tools/cache"
+)
+
+const (
+	// handOverTimeout is how long a claim handed over to the controller is
+	// waited for to be taken by a worker, claims the controller drops without
+	// asking ShouldProvision, e.g. deleted ones, are not waited for forever
+	handOverTimeout = time.Minute
+	// claimQueueInterval is how often claims waiting for a stale hand over
+	// are handed over
+	claimQueueInterval = time.Second
+)
+
+// claimQueue sits between the claim informer and the provision controller.
+// It drops the claims the provisioner has nothing to do with, which on a large
+// cluster are most of them, before they reach the controller. The others are
+// handed over round-robin across namespaces instead of in the order they
+// arrive, so that a burst of claims in one namespace, e.g. a large
+// StatefulSet, does not hold up the claims of the others: the controller only
+// gets as many claims as it has workers, the rest wait in the claim queue.
+type claimQueue struct {
+	// interesting returns whether the provisioner may provision the claim
+	interesting func(*v1.PersistentVolumeClaim) bool
+	// limit is the number of claims handed over that no worker took yet
+	limit int
+
+	mutex sync.Mutex
+	// handler is the controller's handler of the claim informer, nil until
+	// the controller runs
+	handler cache.ResourceEventHandler
+	// waiting are the claims not handed over yet by UID, handed over in the
+	// order of order
+	waiting map[string]*v1.PersistentVolumeClaim
+	order   *fairQueue
+	// handedOver are the claims handed over that no worker took yet, with
+	// when they were handed over
+	handedOver map[string]time.Time
+	// pending are the claims queued that were not processed successfully
+	// yet
+	pending map[string]pendingClaim
+}
+
+// pendingClaim is a claim queued that was not processed successfully yet.
+type pendingClaim struct {
+	namespace, name string
+	queued          time.Time
+}
+
+var _ cache.ResourceEventHandler = &claimQueue{}
+
+func newClaimQueue(interesting func(*v1.PersistentVolumeClaim) bool, limit int) *claimQueue {
+	return &claimQueue{
+		interesting: interesting,
+		limit:       limit,
+		waiting:     make(map[string]*v1.PersistentVolumeClaim),
+		order:       newFairQueue(),
+		handedOver:  make(map[string]time.Time),
+		pending:     make(map[string]pendingClaim),
+	}
+}
+
+// claimInformer is the claim informer as the controller sees it, the handler
+// the controller adds gets the claims from the claim queue.
+type claimInformer struct {
+	cache.SharedIndexInformer
+	queue *claimQueue
+}
+
+// informer returns informer, the handler the controller adds to it getting
+// the claims from the queue.
+func (q *claimQueue) informer(informer cache.SharedIndexInformer) cache.SharedIndexInformer {
+	return &claimInformer{SharedIndexInformer: informer, queue: q}
+}
+
+// AddEventHandler implements cache.SharedInformer.
+func (i *claimInformer) AddEventHandler(handler cache.ResourceEventHandler) (cache.ResourceEventHandlerRegistration, error) {
+	i.queue.setHandler(handler)
+	return i.SharedIndexInformer.AddEventHandler(i.queue)
+}
+
+// AddEventHandlerWithResyncPeriod implements cache.SharedInformer.
+func (i *claimInformer) AddEventHandlerWithResyncPeriod(handler cache.ResourceEventHandler, resyncPeriod time.Duration) (cache.ResourceEventHandlerRegistration, error) {
+	i.queue.setHandler(handler)
+	return i.SharedIndexInformer.AddEventHandlerWithResyncPeriod(i.queue, resyncPeriod)
+}
+
+func (q *claimQueue) setHandler(handler cache.ResourceEventHandler) {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+	q.handler = handler
+}
+
+// OnAdd implements cache.ResourceEventHandler.
+func (q *claimQueue) OnAdd(obj interface{}, isInInitialList bool) {
+	if claim, ok := obj.(*v1.PersistentVolumeClaim); ok {
+		q.add(claim)
+	}
+}
+
+// OnUpdate implements cache.ResourceEventHandler.
+func (q *claimQueue) OnUpdate(oldObj, newObj interface{}) {
+	if claim, ok := newObj.(*v1.PersistentVolumeClaim); ok {
+		q.add(claim)
+	}
+}
+
+// OnDelete implements cache.ResourceEventHandler.
+func (q *claimQueue) OnDelete(obj interface{}) {
+	if tombstone, ok := obj.(cache.DeletedFinalStateUnknown); ok {
+		obj = tombstone.Obj
+	}
+	object, err := meta.Accessor(obj)
+	if err != nil {
+		return
+	}
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+	q.forgetLocked(string(object.GetUID()))
+	q.dispatchLocked()
+}
+
+// add queues the claim, or drops it when the provisioner has nothing to do
+// with it. A claim already handed over is handed over again, the controller
+// processes it once.
+func (q *claimQueue) add(claim *v1.PersistentVolumeClaim) bool {
+	uid := string(claim.UID)
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+	if !q.interesting(claim) {
+		q.forgetLocked(uid)
+		return false
+	}
+	if _, ok := q.pending[uid]; !ok {
+		q.pending[uid] = pendingClaim{namespace: claim.Namespace, name: claim.Name, queued: time.Now()}
+	}
+	if _, ok := q.handedOver[uid]; ok {
+		q.handler.OnUpdate(claim, claim)
+		return true
+	}
+	if _, ok := q.waiting[uid]; !ok {
+		q.order.push(uid, claim.Namespace)
+	}
+	q.waiting[uid] = claim
+	q.dispatchLocked()
+	return true
+}
+
+func (q *claimQueue) forgetLocked(uid string) {
+	delete(q.waiting, uid)
+	delete(q.handedOver, uid)
+	delete(q.pending, uid)
+}
+
+// dispatchLocked hands the waiting claims over while fewer than limit claims
+// handed over wait for a worker.
+func (q *claimQueue) dispatchLocked() {
+	now := time.Now()
+	for uid, handedOver := range q.handedOver {
+		if now.Sub(handedOver) > handOverTimeout {
+			delete(q.handedOver, uid)
+		}
+	}
+	for q.handler != nil && len(q.handedOver) < q.limit && q.order.len() > 0 {
+		uid := q.order.pop()
+		claim, ok := q.waiting[uid]
+		if !ok {
+			// Deleted or dropped since it was queued
+			continue
+		}
+		delete(q.waiting, uid)
+		q.handedOver[uid] = now
+		q.handler.OnAdd(claim, false)
+	}
+}
+
+// taken records that a worker took the claim.
+func (q *claimQueue) taken(uid string) {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+	delete(q.handedOver, uid)
+	q.dispatchLocked()
+}
+
+// done records that the claim was processed successfully.
+func (q *claimQueue) done(uid string) {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+	delete(q.pending, uid)
+}
+
+// run hands over the claims whose hand over went stale until stopCh is
+// closed.
+func (q *claimQueue) run(stopCh <-chan struct{}) {
+	ticker := time.NewTicker(claimQueueInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-stopCh:
+			return
+		case <-ticker.C:
+		}
+		q.mutex.Lock()
+		q.dispatchLocked()
+		q.mutex.Unlock()
+	}
+}
+
+// resync queues the claims again, as a periodic resync of the informer
+// would, and returns how many of them were queued.
+func (q *claimQueue) resync(claims []interface{}) int {
+	queued := 0
+	for _, obj := range claims {
+		if claim, ok := obj.(*v1.PersistentVolumeClaim); ok && q.add(claim) {
+			queued++
+		}
+	}
+	return queued
+}
+
+// depth returns the number of claims waiting to be handed over.
+func (q *claimQueue) depth() int {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+	return len(q.waiting)
+}
+
+// pendingClaims returns the claims not processed successfully yet, by UID.
+func (q *claimQueue) pendingClaims() map[string]pendingClaim {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+	pending := make(map[string]pendingClaim, len(q.pending))
+	for uid, claim := range q.pending {
+		pending[uid] = claim
+	}
+	return pending
+}
+
+// claimOfInterest returns whether the provisioner may provision the claim.
+// Claims that are bound, that the PV controller handed over to another
+// provisioner or that name another node to provision on are not. Claims
+// naming this node are, also before they are handed over, ShouldProvision
+// looks at their class.
+func (p *HostPathProvisioner) claimOfInterest(claim *v1.PersistentVolumeClaim) bool {
+	if claim.Spec.VolumeName != "" {
+		return false
+	}
+	if node, ok := claim.Annotations[annProvisionOnNode]; ok && node != p.nodeName {
+		return false
+	}
+	provisioner, found := claim.Annotations[annStorageProvisioner]
+	if !found {
+		provisioner, found = claim.Annotations[annStorageProvisionerGA]
+	}
+	if !found {
+		return claim.Annotations[annProvisionOnNode] == p.nodeName
+	}
+	return p.ownsIdentity(provisioner)
+}
+
+// fairQueue orders items round-robin across groups, e.g. namespaces, instead
+// of in FIFO order, so that a burst of items of one group can not starve the
+// others.
+type fairQueue struct {
+	// items waiting per group, and the order in which groups are served
+	groups map[string][]string
+	order  []string
+	count  int
+}
+
+func newFairQueue() *fairQueue {
+	return &fairQueue{groups: make(map[string][]string)}
+}
+
+// push appends the item to its group.
+func (q *fairQueue) push(item, group string) {
+	if len(q.groups[group]) == 0 {
+		q.order = append(q.order, group)
+	}
+	q.groups[group] = append(q.groups[group], item)
+	q.count++
+}
+
+// len returns the number of items waiting.
+func (q *fairQueue) len() int {
+	return q.count
+}
+
+// pop returns the next item of the group that waited the longest since it
+// was last served. It must only be called when len is not 0.
+func (q *fairQueue) pop() string {
+	group := q.order[0]
+	q.order = q.order[1:]
+	item := q.groups[group][0]
+	q.groups[group] = q.groups[group][1:]
+	if len(q.groups[group]) > 0 {
+		q.order = append(q.order, group)
+	} else {
+		delete(q.groups, group)
+	}
+	q.count--
+	return item
+}
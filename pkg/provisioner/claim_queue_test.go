@@ -0,0 +1,150 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package provisioner
+
+import (
+	"reflect"
+	"testing"
+
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/tools/cache"
+)
+
+// recordingHandler records the claims the claim queue hands over.
+type recordingHandler struct {
+	added, updated []string
+}
+
+func (h *recordingHandler) OnAdd(obj interface{}, isInInitialList bool) {
+	h.added = append(h.added, obj.(*v1.PersistentVolumeClaim).Name)
+}
+
+func (h *recordingHandler) OnUpdate(oldObj, newObj interface{}) {
+	h.updated = append(h.updated, newObj.(*v1.PersistentVolumeClaim).Name)
+}
+
+func (h *recordingHandler) OnDelete(obj interface{}) {}
+
+func queuedClaim(namespace, name string) *v1.PersistentVolumeClaim {
+	return &v1.PersistentVolumeClaim{
+		ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: namespace, UID: types.UID(namespace + "/" + name)},
+	}
+}
+
+func TestFairQueueRoundRobin(t *testing.T) {
+	q := newFairQueue()
+	for _, item := range []string{"a1", "a2", "a3"} {
+		q.push(item, "a")
+	}
+	q.push("b1", "b")
+	q.push("c1", "c")
+	q.push("b2", "b")
+
+	var got []string
+	for q.len() > 0 {
+		got = append(got, q.pop())
+	}
+	if want := []string{"a1", "b1", "c1", "a2", "b2", "a3"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("pop order = %v, want %v", got, want)
+	}
+}
+
+func TestClaimQueueHandsOverUpToTheLimit(t *testing.T) {
+	q := newClaimQueue(func(*v1.PersistentVolumeClaim) bool { return true }, 2)
+	for _, name := range []string{"a1", "a2", "a3"} {
+		q.OnAdd(queuedClaim("a", name), false)
+	}
+	q.OnAdd(queuedClaim("b", "b1"), false)
+	if q.depth() != 4 {
+		t.Errorf("depth() = %d before the controller runs, want 4", q.depth())
+	}
+
+	handler := &recordingHandler{}
+	q.setHandler(handler)
+	q.OnAdd(queuedClaim("b", "b2"), false)
+	if want := []string{"a1", "b1"}; !reflect.DeepEqual(handler.added, want) {
+		t.Errorf("handed over %v, want %v", handler.added, want)
+	}
+	if q.depth() != 3 {
+		t.Errorf("depth() = %d, want 3", q.depth())
+	}
+
+	// A claim handed over again is updated, not queued twice
+	q.OnUpdate(nil, queuedClaim("a", "a1"))
+	if want := []string{"a1"}; !reflect.DeepEqual(handler.updated, want) {
+		t.Errorf("updated %v, want %v", handler.updated, want)
+	}
+
+	q.taken("a/a1")
+	q.OnDelete(cache.DeletedFinalStateUnknown{Key: "b/b1", Obj: queuedClaim("b", "b1")})
+	if want := []string{"a1", "b1", "a2", "b2"}; !reflect.DeepEqual(handler.added, want) {
+		t.Errorf("handed over %v after a claim was taken and one deleted, want %v", handler.added, want)
+	}
+	if _, ok := q.pendingClaims()["b/b1"]; ok {
+		t.Error("deleted claim still pending")
+	}
+	q.done("a/a1")
+	if got := len(q.pendingClaims()); got != 3 {
+		t.Errorf("%d claims pending, want 3", got)
+	}
+}
+
+func TestClaimQueueResync(t *testing.T) {
+	q := newClaimQueue(func(claim *v1.PersistentVolumeClaim) bool { return claim.Namespace == "ours" }, 1)
+	handler := &recordingHandler{}
+	q.setHandler(handler)
+	claims := []interface{}{queuedClaim("ours", "a"), queuedClaim("other", "b"), queuedClaim("ours", "c")}
+	if got := q.resync(claims); got != 2 {
+		t.Errorf("resync() = %d, want 2", got)
+	}
+	if len(handler.added) != 1 || q.depth() != 1 {
+		t.Errorf("handed over %v with %d waiting, want 1 claim handed over and 1 waiting", handler.added, q.depth())
+	}
+}
+
+func TestClaimOfInterest(t *testing.T) {
+	claim := func(annotations map[string]string, volumeName string) *v1.PersistentVolumeClaim {
+		return &v1.PersistentVolumeClaim{
+			ObjectMeta: metav1.ObjectMeta{Name: "claim", Namespace: "default", Annotations: annotations},
+			Spec:       v1.PersistentVolumeClaimSpec{VolumeName: volumeName},
+		}
+	}
+	tests := []struct {
+		name  string
+		claim *v1.PersistentVolumeClaim
+		want  bool
+	}{
+		{name: "annotated for us", claim: claim(map[string]string{annStorageProvisionerGA: "kubevirt.io/hostpath-provisioner"}, ""), want: true},
+		{name: "annotated for us with the beta annotation", claim: claim(map[string]string{annStorageProvisioner: "kubevirt.io/hostpath-provisioner"}, ""), want: true},
+		{name: "annotated for an alias", claim: claim(map[string]string{annStorageProvisionerGA: "hostpath-old"}, ""), want: true},
+		{name: "annotated for another provisioner", claim: claim(map[string]string{annStorageProvisionerGA: "example.com/other"}, ""), want: false},
+		{name: "not annotated yet", claim: claim(map[string]string{}, ""), want: false},
+		{name: "naming this node", claim: claim(map[string]string{annProvisionOnNode: "node01"}, ""), want: true},
+		{name: "naming another node", claim: claim(map[string]string{annProvisionOnNode: "node02", annStorageProvisionerGA: "kubevirt.io/hostpath-provisioner"}, ""), want: false},
+		{name: "already bound", claim: claim(map[string]string{annStorageProvisionerGA: "kubevirt.io/hostpath-provisioner"}, "pv"), want: false},
+	}
+	p := &HostPathProvisioner{nodeName: "node01", identity: "kubevirt.io/hostpath-provisioner", aliases: []string{"hostpath-old"}}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := p.claimOfInterest(tt.claim); got != tt.want {
+				t.Errorf("claimOfInterest() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,142 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package provisioner
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"k8s.io/klog/v2"
+
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// policyClone is recorded as the pool selection policy of clones, which are
+// placed in the pool of their source.
+const policyClone = "clone"
+
+// cloneSource returns the volume of the claim the claim is to be cloned from,
+// nil when it has no data source.
+func (p *HostPathProvisioner) cloneSource(pvc *v1.PersistentVolumeClaim) (*v1.PersistentVolume, error) {
+	source := pvc.Spec.DataSource
+	if source == nil {
+		return nil, nil
+	}
+	if source.Kind != "PersistentVolumeClaim" || (source.APIGroup != nil && *source.APIGroup != "") {
+		return nil, fmt.Errorf("unsupported data source %s %s, only claims can be cloned", source.Kind, source.Name)
+	}
+	sourceClaim, err := p.client.CoreV1().PersistentVolumeClaims(pvc.Namespace).Get(context.TODO(), source.Name, metav1.GetOptions{})
+	if err != nil {
+		return nil, fmt.Errorf("unable to get source claim %s/%s: %v", pvc.Namespace, source.Name, err)
+	}
+	if sourceClaim.Spec.VolumeName == "" {
+		return nil, fmt.Errorf("source claim %s/%s is not bound", pvc.Namespace, source.Name)
+	}
+	return p.client.CoreV1().PersistentVolumes().Get(context.TODO(), sourceClaim.Spec.VolumeName, metav1.GetOptions{})
+}
+
+// clonePool returns the pool a clone of the source volume is created in, the
+// pool of the source: its logical volume is snapshotted in its thin pool, or
+// the clone is an overlay of the source if it is a template.
+func (p *HostPathProvisioner) clonePool(source *v1.PersistentVolume) (*storagePool, error) {
+	if !p.ownsIdentity(source.Annotations["hostPathProvisionerIdentity"]) || source.Annotations["kubevirt.io/provisionOnNode"] != p.nodeName {
+		return nil, fmt.Errorf("source volume %s is not on node %s, clones are created next to their source", source.Name, p.nodeName)
+	}
+	if source.Annotations[annLVMVolume] == "" {
+		if isTemplate(source) {
+			return p.overlayPool(source)
+		}
+		return nil, fmt.Errorf("source volume %s is neither a logical volume nor a template, only volumes in LVM pools and templates can be cloned", source.Name)
+	}
+	pool := findPool(p.currentPools(), source.Annotations[annStoragePool])
+	if pool == nil || pool.lvm == nil || pool.lvm.thinPool == "" {
+		return nil, fmt.Errorf("pool %q of source volume %s has no thin pool, only thin volumes can be cloned", source.Annotations[annStoragePool], source.Name)
+	}
+	return pool, nil
+}
+
+// cloneVolume creates the logical volume name as a thin snapshot of source,
+// grows it to size bytes if it is larger than the source, and mounts it at
+// path. The snapshot shares the blocks of its source, creating it is instant
+// and takes no space until either is written to. A clone left behind by an
+// earlier attempt is reused.
+func (l *lvmPool) cloneVolume(source, name string, size int64, path string) (string, error) {
+	lv := l.volumeGroup + "/" + name
+	if !l.command.exists(lv) {
+		if segment, err := l.command.field(source, "segtype"); err != nil {
+			return "", err
+		} else if segment != "thin" {
+			return "", fmt.Errorf("source logical volume %s is %s, only thin volumes can be cloned", source, segment)
+		}
+		if _, err := l.command.run("lvcreate", "--snapshot", "--setactivationskip", "n", "--name", name, source); err != nil {
+			return "", err
+		}
+	}
+	err := l.growAndMount(lv, size, path)
+	if err != nil {
+		if _, removeErr := l.command.run("lvremove", "--yes", lv); removeErr != nil {
+			klog.Warningf("unable to remove clone %s after failing to mount it: %v", lv, removeErr)
+		}
+		return "", err
+	}
+	return lv, nil
+}
+
+// growAndMount mounts the clone lv at path, growing it and its filesystem to
+// size bytes when it is smaller. Clones cannot be smaller than their source.
+func (l *lvmPool) growAndMount(lv string, size int64, path string) error {
+	field, err := l.command.field(lv, "lv_size")
+	if err != nil {
+		return err
+	}
+	current, err := strconv.ParseInt(field, 10, 64)
+	if err != nil {
+		return fmt.Errorf("unable to parse the size of %s: %v", lv, err)
+	}
+	if current > size {
+		return fmt.Errorf("clone %s of %d bytes is smaller than its source, %d bytes", lv, size, current)
+	}
+	if current < size {
+		if _, err := l.command.run("lvextend", "--size", fmt.Sprintf("%db", size), lv); err != nil {
+			return err
+		}
+	}
+	if err := mountLogicalVolume(lv, l.fsType, path, false); err != nil {
+		return err
+	}
+	if current == size {
+		return nil
+	}
+	if l.fsType == "xfs" {
+		_, err = runCommand("xfs_growfs", path)
+	} else {
+		_, err = runCommand("resize2fs", "/dev/"+lv)
+	}
+	return err
+}
+
+// field returns a field reported by lvs for lv, sizes in bytes.
+func (c lvmCommand) field(lv, field string) (string, error) {
+	output, err := c.run("lvs", "--noheadings", "--nosuffix", "--units", "b", "-o", field, lv)
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(string(output)), nil
+}
@@ -0,0 +1,194 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package provisioner
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func Test_clonePool(t *testing.T) {
+	thin := &storagePool{name: "thin", lvm: &lvmPool{volumeGroup: "vg0", thinPool: "thin", fsType: "ext4"}}
+	thick := &storagePool{name: "thick", lvm: &lvmPool{volumeGroup: "vg1", fsType: "ext4"}}
+	plain := &storagePool{name: "plain", path: "/var/hpvolumes"}
+	tmpfs := &storagePool{name: "tmpfs", path: "/var/hpvolumes/tmpfs"}
+	capabilities := newCapabilityProber("", "node01", nil)
+	capabilities.probed["plain"] = map[string]bool{capabilityXattrs: true}
+	capabilities.probed["tmpfs"] = map[string]bool{capabilityXattrs: false}
+	p := &HostPathProvisioner{nodeName: "node01", identity: "id", pools: []*storagePool{thin, thick, plain, tmpfs}, capabilities: capabilities}
+	source := func(identity, node, pool, lv string) *v1.PersistentVolume {
+		annotations := map[string]string{
+			"hostPathProvisionerIdentity": identity,
+			"kubevirt.io/provisionOnNode": node,
+			annStoragePool:                pool,
+		}
+		if lv != "" {
+			annotations[annLVMVolume] = lv
+		}
+		return &v1.PersistentVolume{ObjectMeta: metav1.ObjectMeta{Name: "pvc-source", Annotations: annotations}}
+	}
+	template := func(pool string) *v1.PersistentVolume {
+		pv := source("id", "node01", pool, "")
+		pv.Annotations[annTemplate] = "true"
+		pv.Spec.HostPath = &v1.HostPathVolumeSource{Path: "/var/hpvolumes/pvc-source"}
+		return pv
+	}
+	tmpfsTemplate := template("plain")
+	tmpfsTemplate.Annotations[annTmpfsSize] = "1Gi"
+	tests := []struct {
+		name    string
+		source  *v1.PersistentVolume
+		want    *storagePool
+		wantErr bool
+	}{
+		{name: "thin volume", source: source("id", "node01", "thin", "vg0/pvc-source"), want: thin},
+		{name: "other provisioner", source: source("other", "node01", "thin", "vg0/pvc-source"), wantErr: true},
+		{name: "other node", source: source("id", "node02", "thin", "vg0/pvc-source"), wantErr: true},
+		{name: "directory", source: source("id", "node01", "plain", ""), wantErr: true},
+		{name: "thick pool", source: source("id", "node01", "thick", "vg1/pvc-source"), wantErr: true},
+		{name: "unknown pool", source: source("id", "node01", "gone", "vg0/pvc-source"), wantErr: true},
+		{name: "template", source: template("plain"), want: plain},
+		{name: "template in lvm pool", source: template("thick"), wantErr: true},
+		{name: "template in pool without xattrs", source: template("tmpfs"), wantErr: true},
+		{name: "tmpfs template", source: tmpfsTemplate, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := p.clonePool(tt.source)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("clonePool() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("clonePool() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_lvmPoolCloneVolume(t *testing.T) {
+	dir, err := ioutil.TempDir("", "clone")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "pvc-1")
+
+	tests := []struct {
+		name    string
+		fsType  string
+		size    int64
+		outputs map[string]string
+		want    []string
+		wantErr bool
+	}{
+		{
+			name:   "same size",
+			fsType: "ext4",
+			size:   1073741824,
+			outputs: map[string]string{
+				"lvm lvs --noheadings --nosuffix --units b -o segtype vg0/pvc-source":       "  thin\n",
+				"lvm lvcreate --snapshot --setactivationskip n --name pvc-1 vg0/pvc-source": "",
+				"lvm lvs --noheadings --nosuffix --units b -o lv_size vg0/pvc-1":            "  1073741824\n",
+				"mount -t ext4 /dev/vg0/pvc-1 " + path:                                      "",
+			},
+			want: []string{
+				"lvm lvs vg0/pvc-1",
+				"lvm lvs --noheadings --nosuffix --units b -o segtype vg0/pvc-source",
+				"lvm lvcreate --snapshot --setactivationskip n --name pvc-1 vg0/pvc-source",
+				"lvm lvs --noheadings --nosuffix --units b -o lv_size vg0/pvc-1",
+				"mount -t ext4 /dev/vg0/pvc-1 " + path,
+			},
+		},
+		{
+			name:   "grown xfs",
+			fsType: "xfs",
+			size:   2147483648,
+			outputs: map[string]string{
+				"lvm lvs --noheadings --nosuffix --units b -o segtype vg0/pvc-source":       "  thin\n",
+				"lvm lvcreate --snapshot --setactivationskip n --name pvc-1 vg0/pvc-source": "",
+				"lvm lvs --noheadings --nosuffix --units b -o lv_size vg0/pvc-1":            "  1073741824\n",
+				"lvm lvextend --size 2147483648b vg0/pvc-1":                                 "",
+				"mount -t xfs -o nouuid /dev/vg0/pvc-1 " + path:                             "",
+				"xfs_growfs " + path: "",
+			},
+			want: []string{
+				"lvm lvs vg0/pvc-1",
+				"lvm lvs --noheadings --nosuffix --units b -o segtype vg0/pvc-source",
+				"lvm lvcreate --snapshot --setactivationskip n --name pvc-1 vg0/pvc-source",
+				"lvm lvs --noheadings --nosuffix --units b -o lv_size vg0/pvc-1",
+				"lvm lvextend --size 2147483648b vg0/pvc-1",
+				"mount -t xfs -o nouuid /dev/vg0/pvc-1 " + path,
+				"xfs_growfs " + path,
+			},
+		},
+		{
+			name:   "thick source",
+			fsType: "ext4",
+			size:   1073741824,
+			outputs: map[string]string{
+				"lvm lvs --noheadings --nosuffix --units b -o segtype vg0/pvc-source": "  linear\n",
+			},
+			want: []string{
+				"lvm lvs vg0/pvc-1",
+				"lvm lvs --noheadings --nosuffix --units b -o segtype vg0/pvc-source",
+			},
+			wantErr: true,
+		},
+		{
+			name:   "smaller than source",
+			fsType: "ext4",
+			size:   536870912,
+			outputs: map[string]string{
+				"lvm lvs --noheadings --nosuffix --units b -o segtype vg0/pvc-source":       "  thin\n",
+				"lvm lvcreate --snapshot --setactivationskip n --name pvc-1 vg0/pvc-source": "",
+				"lvm lvs --noheadings --nosuffix --units b -o lv_size vg0/pvc-1":            "  1073741824\n",
+				"lvm lvremove --yes vg0/pvc-1":                                              "",
+			},
+			want: []string{
+				"lvm lvs vg0/pvc-1",
+				"lvm lvs --noheadings --nosuffix --units b -o segtype vg0/pvc-source",
+				"lvm lvcreate --snapshot --setactivationskip n --name pvc-1 vg0/pvc-source",
+				"lvm lvs --noheadings --nosuffix --units b -o lv_size vg0/pvc-1",
+				"lvm lvremove --yes vg0/pvc-1",
+			},
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			commands, restore := fakeCommands(tt.outputs)
+			defer restore()
+			pool := &lvmPool{volumeGroup: "vg0", thinPool: "thin", fsType: tt.fsType}
+			lv, err := pool.cloneVolume("vg0/pvc-source", "pvc-1", tt.size, path)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("cloneVolume() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !tt.wantErr && lv != "vg0/pvc-1" {
+				t.Errorf("cloneVolume() = %s, want vg0/pvc-1", lv)
+			}
+			if !reflect.DeepEqual(*commands, tt.want) {
+				t.Errorf("commands = %q, want %q", *commands, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,106 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package provisioner
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"sigs.k8s.io/sig-storage-lib-external-provisioner/v13/controller"
+)
+
+const (
+	// compressionParameter is the StorageClass parameter asking btrfs to
+	// compress volumes, an algorithm with an optional level, e.g. "zstd:3".
+	compressionParameter = "compression"
+
+	// annCompression records the compression of a volume.
+	annCompression = "kubevirt.io/compression"
+)
+
+// compressionLevels are the compression algorithms of btrfs and their highest
+// level, 0 for algorithms without levels.
+var compressionLevels = map[string]int{"zstd": 15, "zlib": 9, "lzo": 0}
+
+// compressionFor returns the compression the StorageClass asks for, empty for
+// none. Backing directories are compressed through a btrfs property, which
+// takes no level, image files formatted with btrfs through a mount option.
+func compressionFor(options controller.ProvisionOptions, backing string, fs imageFilesystem) (string, error) {
+	if options.StorageClass == nil {
+		return "", nil
+	}
+	compression := options.StorageClass.Parameters[compressionParameter]
+	if compression == "" {
+		return "", nil
+	}
+	algorithm, level := compression, ""
+	if i := strings.Index(compression, ":"); i != -1 {
+		algorithm, level = compression[:i], compression[i+1:]
+	}
+	maxLevel, ok := compressionLevels[algorithm]
+	if !ok {
+		return "", fmt.Errorf("invalid %s %q in storage class %s, expected zstd, zlib or lzo", compressionParameter, compression, options.StorageClass.Name)
+	}
+	if level != "" {
+		if n, err := strconv.Atoi(level); err != nil || n < 1 || n > maxLevel {
+			return "", fmt.Errorf("invalid %s level %q in storage class %s, %s takes levels 1 to %d", compressionParameter, level, options.StorageClass.Name, algorithm, maxLevel)
+		}
+	}
+	switch backing {
+	case backingImage:
+		if fs.fsType != "btrfs" {
+			return "", fmt.Errorf("storage class %s sets %s, image backed volumes are only compressed with %s: btrfs", options.StorageClass.Name, compressionParameter, fsTypeParameter)
+		}
+	case backingDirectory, backingQcow2:
+		if level != "" {
+			return "", fmt.Errorf("storage class %s sets a %s level, backing directories only take the algorithm, the level is that of the pool's compress mount option", options.StorageClass.Name, compressionParameter)
+		}
+	default:
+		return "", fmt.Errorf("storage class %s sets %s, which does not apply to %s backed volumes", options.StorageClass.Name, compressionParameter, backing)
+	}
+	return compression, nil
+}
+
+// compressionMountOptions returns the btrfs mount options compressing an
+// image backed volume.
+func compressionMountOptions(compression string) []string {
+	return []string{"compress=" + compression}
+}
+
+// compressDirectory has btrfs compress the files written to the backing
+// directory. Pools on other filesystems can not compress single directories,
+// ZFS compresses whole datasets, set the compression of the pool's dataset
+// instead.
+func compressDirectory(path, compression string) error {
+	mounts, err := readMounts(procMountsPath)
+	if err != nil {
+		return err
+	}
+	mount := findMount(mounts, path)
+	if mount == nil {
+		return fmt.Errorf("unable to find the filesystem of %s", path)
+	}
+	switch mount.fsType {
+	case "btrfs":
+		_, err = runCommand("btrfs", "property", "set", path, "compression", compression)
+		return err
+	case "zfs":
+		return fmt.Errorf("%s is on ZFS, which compresses whole datasets, set the compression property of the pool's dataset instead", path)
+	}
+	return fmt.Errorf("%s is on %s, only directories on btrfs can be compressed", path, mount.fsType)
+}
@@ -0,0 +1,88 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package provisioner
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	storagev1 "k8s.io/api/storage/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/sig-storage-lib-external-provisioner/v13/controller"
+)
+
+func Test_compressionFor(t *testing.T) {
+	class := func(compression string) *storagev1.StorageClass {
+		return &storagev1.StorageClass{ObjectMeta: metav1.ObjectMeta{Name: "hostpath"}, Parameters: map[string]string{compressionParameter: compression}}
+	}
+	btrfs, ext4 := imageFilesystem{fsType: "btrfs"}, imageFilesystem{fsType: "ext4"}
+	tests := []struct {
+		name    string
+		class   *storagev1.StorageClass
+		backing string
+		fs      imageFilesystem
+		want    string
+		wantErr bool
+	}{
+		{name: "no class", backing: backingDirectory},
+		{name: "not set", class: &storagev1.StorageClass{}, backing: backingDirectory},
+		{name: "directory", class: class("zstd"), backing: backingDirectory, want: "zstd"},
+		{name: "directory with level", class: class("zstd:3"), backing: backingDirectory, wantErr: true},
+		{name: "qcow2", class: class("lzo"), backing: backingQcow2, want: "lzo"},
+		{name: "btrfs image", class: class("zstd:3"), backing: backingImage, fs: btrfs, want: "zstd:3"},
+		{name: "zlib level", class: class("zlib:9"), backing: backingImage, fs: btrfs, want: "zlib:9"},
+		{name: "level too high", class: class("zstd:16"), backing: backingImage, fs: btrfs, wantErr: true},
+		{name: "lzo level", class: class("lzo:1"), backing: backingImage, fs: btrfs, wantErr: true},
+		{name: "ext4 image", class: class("zstd"), backing: backingImage, fs: ext4, wantErr: true},
+		{name: "tmpfs", class: class("zstd"), backing: backingTmpfs, wantErr: true},
+		{name: "invalid", class: class("lz4"), backing: backingDirectory, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := compressionFor(controller.ProvisionOptions{StorageClass: tt.class}, tt.backing, tt.fs)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("compressionFor() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("compressionFor() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_mountDeviceCompressed(t *testing.T) {
+	dir, err := ioutil.TempDir("", "compression")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "pvc-1")
+	mount := "mount -t btrfs -o compress=zstd:3 /dev/loop3 " + path
+	commands, restore := fakeCommands(map[string]string{mount: ""})
+	defer restore()
+
+	fs := imageFilesystem{fsType: "btrfs", mountOptions: compressionMountOptions("zstd:3")}
+	if err := mountDevice("/dev/loop3", fs, path, false); err != nil {
+		t.Fatalf("mountDevice() failed: %v", err)
+	}
+	if !reflect.DeepEqual(*commands, []string{mount}) {
+		t.Errorf("commands = %v, want %v", *commands, []string{mount})
+	}
+}
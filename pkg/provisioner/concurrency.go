@@ -0,0 +1,86 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package provisioner
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"golang.org/x/time/rate"
+	"sigs.k8s.io/sig-storage-lib-external-provisioner/v13/controller"
+)
+
+// workerOptions returns the controller options running the given number of
+// claim and volume workers.
+func workerOptions(workers int) ([]func(*controller.ProvisionController) error, error) {
+	if workers < 1 {
+		return nil, fmt.Errorf("invalid number of workers %d, at least one is needed", workers)
+	}
+	return []func(*controller.ProvisionController) error{controller.Threadiness(workers)}, nil
+}
+
+// poolRateLimiter limits the rate volumes are provisioned in each pool with a
+// token bucket, so that a storm of claims does not starve running workloads
+// of IO.
+type poolRateLimiter struct {
+	limit rate.Limit
+	burst int
+
+	mutex    sync.Mutex
+	limiters map[string]*rate.Limiter
+}
+
+// newPoolRateLimiter returns a limiter allowing perSecond volumes per second
+// with bursts of burst volumes in each pool. There is no limit, and no
+// limiter, when perSecond is 0.
+func newPoolRateLimiter(perSecond float64, burst int) (*poolRateLimiter, error) {
+	if perSecond == 0 {
+		return nil, nil
+	}
+	if perSecond < 0 || burst < 1 {
+		return nil, fmt.Errorf("invalid provisioning rate limit of %v volumes per second with bursts of %d", perSecond, burst)
+	}
+	return &poolRateLimiter{limit: rate.Limit(perSecond), burst: burst, limiters: map[string]*rate.Limiter{}}, nil
+}
+
+// wait blocks until a volume may be provisioned in pool. It fails right away
+// when that is further away than timeout or ctx is canceled, the claim is then retried later
+// instead of occupying a worker.
+func (l *poolRateLimiter) wait(ctx context.Context, pool string, timeout time.Duration) error {
+	if l == nil {
+		return nil
+	}
+	l.mutex.Lock()
+	limiter, ok := l.limiters[pool]
+	if !ok {
+		limiter = rate.NewLimiter(l.limit, l.burst)
+		l.limiters[pool] = limiter
+	}
+	l.mutex.Unlock()
+
+	if timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+	}
+	if err := limiter.Wait(ctx); err != nil {
+		return fmt.Errorf("provisioning rate limit of pool %s reached: %v", pool, err)
+	}
+	return nil
+}
@@ -0,0 +1,73 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package provisioner
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func Test_workerOptions(t *testing.T) {
+	for _, tc := range []struct {
+		workers int
+		valid   bool
+	}{{1, true}, {32, true}, {0, false}, {-1, false}} {
+		options, err := workerOptions(tc.workers)
+		if tc.valid && (err != nil || len(options) != 1) {
+			t.Errorf("workerOptions(%d) = %d options, %v", tc.workers, len(options), err)
+		}
+		if !tc.valid && err == nil {
+			t.Errorf("workerOptions(%d) accepted an invalid number of workers", tc.workers)
+		}
+	}
+}
+
+func Test_poolRateLimiter(t *testing.T) {
+	limiter, err := newPoolRateLimiter(0, 1)
+	if err != nil || limiter != nil {
+		t.Fatalf("newPoolRateLimiter(0, 1) = %v, %v, want no limiter", limiter, err)
+	}
+	if err := limiter.wait(context.Background(), "default", time.Millisecond); err != nil {
+		t.Errorf("wait() without a limit = %v", err)
+	}
+	for _, invalid := range []struct {
+		perSecond float64
+		burst     int
+	}{{-1, 1}, {1, 0}} {
+		if _, err := newPoolRateLimiter(invalid.perSecond, invalid.burst); err == nil {
+			t.Errorf("newPoolRateLimiter(%v, %d) accepted an invalid limit", invalid.perSecond, invalid.burst)
+		}
+	}
+
+	limiter, err = newPoolRateLimiter(0.1, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 2; i++ {
+		if err := limiter.wait(context.Background(), "default", time.Millisecond); err != nil {
+			t.Errorf("wait() %d within the burst = %v", i, err)
+		}
+	}
+	if err := limiter.wait(context.Background(), "default", time.Millisecond); err == nil {
+		t.Errorf("wait() beyond the burst did not fail")
+	}
+	// Every pool has a bucket of its own
+	if err := limiter.wait(context.Background(), "fast", time.Millisecond); err != nil {
+		t.Errorf("wait() in another pool = %v", err)
+	}
+}
@@ -0,0 +1,701 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package provisioner
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"sort"
+	"strings"
+	"text/template"
+	"time"
+
+	apierrs "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/util/validation"
+	"k8s.io/apimachinery/pkg/util/yaml"
+	"k8s.io/client-go/kubernetes"
+)
+
+// configMapKey is the key of the configuration in the ConfigMap.
+const configMapKey = "config.yaml"
+
+// Config is the configuration of the provisioner. It is read from the config
+// file and ConfigMap of ConfigSources, the settings whose comment names an
+// environment variable can be overridden by it. The provisioner command has a
+// flag for every setting, named in the comments of the others.
+type Config struct {
+	// PROVISIONER_NAME, the name StorageClasses refer to the provisioner by,
+	// it also tags the volumes it creates
+	ProvisionerName string `json:"provisionerName,omitempty"`
+	// PROVISIONER_ALIASES, names the provisioner went by before, claims and
+	// volumes of these names are served as if they were its own
+	ProvisionerAliases []string `json:"provisionerAliases,omitempty"`
+	// NODE_NAME, the node the provisioner runs on
+	NodeName string `json:"nodeName,omitempty"`
+	// PV_DIR, the directory volumes are placed in when no pools are configured
+	PVDir string `json:"pvDir,omitempty"`
+	// PV_POOLS and POOL_DEVICES, the storage pools volumes are spread over
+	Pools []PoolConfig `json:"pools,omitempty"`
+	// POOL_SELECTION_POLICY, used for storage classes that do not set the
+	// poolSelectionPolicy parameter
+	PoolSelectionPolicy string `json:"poolSelectionPolicy,omitempty"`
+	// USE_NAMING_PREFIX, whether backing directories are prefixed with the
+	// claim name
+	UseNamingPrefix bool `json:"useNamingPrefix,omitempty"`
+	// NAMING_MODE, pv, claim or namespace, it takes precedence over
+	// useNamingPrefix
+	NamingMode string `json:"namingMode,omitempty"`
+	// NAMING_TEMPLATE, a Go template naming backing directories, it takes
+	// precedence over namingMode
+	NamingTemplate string `json:"namingTemplate,omitempty"`
+	// DIRECTORY_MODE, the permissions of backing directories in octal
+	DirectoryMode string `json:"directoryMode,omitempty"`
+	// SELINUX_CONTEXT, the SELinux context backing directories are labeled
+	// with, they keep the context they inherit when unset
+	SELinuxContext string `json:"selinuxContext,omitempty"`
+	// ALLOWED_UIDS and ALLOWED_GIDS, the owners claims may ask for, e.g.
+	// 107,1000-1999
+	AllowedUIDs string `json:"allowedUIDs,omitempty"`
+	AllowedGIDs string `json:"allowedGIDs,omitempty"`
+	// DEFAULT_RECLAIM_POLICY, Delete or Retain, used for storage classes that
+	// do not set reclaimPolicy
+	DefaultReclaimPolicy string `json:"defaultReclaimPolicy,omitempty"`
+	// CAPACITY_ROUNDING and CAPACITY_ROUNDING_UNIT
+	CapacityRounding     string `json:"capacityRounding,omitempty"`
+	CapacityRoundingUnit string `json:"capacityRoundingUnit,omitempty"`
+	// CLAIM_SELECTOR, a label selector limiting the claims acted on
+	ClaimSelector string `json:"claimSelector,omitempty"`
+	// QUOTA_CONFIGMAP, the ConfigMap holding per-namespace limits
+	QuotaConfigMap string `json:"quotaConfigMap,omitempty"`
+	// MOUNT_CHECK_INTERVAL
+	MountCheckInterval metav1.Duration `json:"mountCheckInterval,omitempty"`
+	// POOL_USAGE_THRESHOLDS and POOL_USAGE_CHECK_INTERVAL
+	PoolUsageThresholds    []int           `json:"poolUsageThresholds,omitempty"`
+	PoolUsageCheckInterval metav1.Duration `json:"poolUsageCheckInterval,omitempty"`
+	// QoSTiers are the tiers StorageClasses ask for with the qosTier
+	// parameter, they can only be set in the config file
+	QoSTiers []QoSTierConfig `json:"qosTiers,omitempty"`
+	// Controller configures the provision controller
+	Controller ControllerConfig `json:"controller,omitempty"`
+
+	// --backend, where volumes are provisioned: host, the filesystems of the
+	// node, or memory, pools of plain directories simulated in memory, each
+	// --memory-pool-size large
+	Backend        string `json:"backend,omitempty"`
+	MemoryPoolSize string `json:"memoryPoolSize,omitempty"`
+	// --dry-run, decide how claims would be provisioned and volumes deleted
+	// and report it, without touching the node
+	DryRun bool `json:"dryRun,omitempty"`
+	// --feature-gates, experimental features turned on or off by name
+	FeatureGates map[string]bool `json:"featureGates,omitempty"`
+	// --log-format, the format of log messages, text or json
+	LogFormat string `json:"logFormat,omitempty"`
+	// --trace, log a span for every phase of provisioning and deleting
+	// volumes, and --otlp-endpoint, the OTLP/HTTP receiver they are
+	// exported to
+	Trace        bool   `json:"trace,omitempty"`
+	OTLPEndpoint string `json:"otlpEndpoint,omitempty"`
+	// --allow-rootfs, allow pools on the root filesystem of the node, which
+	// is visible to the provisioner at --rootfs-path
+	AllowRootfs bool   `json:"allowRootfs,omitempty"`
+	RootfsPath  string `json:"rootfsPath,omitempty"`
+	// --strict-mounts, only provision into pools that are mount points
+	StrictMounts bool `json:"strictMounts,omitempty"`
+	// --allowed-namespaces and --denied-namespaces, comma separated shell
+	// patterns of the namespaces that may and may not receive volumes
+	AllowedNamespaces string `json:"allowedNamespaces,omitempty"`
+	DeniedNamespaces  string `json:"deniedNamespaces,omitempty"`
+	// --allowed-selinux-contexts, space separated SELinux contexts claims
+	// may ask for
+	AllowedSELinuxContexts string `json:"allowedSELinuxContexts,omitempty"`
+	// --max-volume-stripes, the most pools a volume is striped across
+	MaxVolumeStripes int `json:"maxVolumeStripes,omitempty"`
+	// --wipe-devices, erase the signatures of the devices of device pools
+	// when their volume is deleted
+	WipeDevices bool `json:"wipeDevices,omitempty"`
+	// --tmpfs-budget, the memory all tmpfs backed volumes may use together,
+	// e.g. 16Gi, they are refused when unset
+	TmpfsBudget string `json:"tmpfsBudget,omitempty"`
+	// --symlink-farm, --detect-tampering and --quarantine-unmatched
+	SymlinkFarm         bool `json:"symlinkFarm,omitempty"`
+	DetectTampering     bool `json:"detectTampering,omitempty"`
+	QuarantineUnmatched bool `json:"quarantineUnmatched,omitempty"`
+	// --intent-file and --allocation-db, the files interrupted operations
+	// and the volumes of the node are kept in, disabled when empty
+	IntentFile   string `json:"intentFile,omitempty"`
+	AllocationDB string `json:"allocationDB,omitempty"`
+	// --provision-rate and --provision-burst, the volumes provisioned per
+	// second in each pool, no limit when 0
+	ProvisionRate  float64 `json:"provisionRate,omitempty"`
+	ProvisionBurst int     `json:"provisionBurst,omitempty"`
+	// --provision-timeout, --delete-timeout and --shutdown-timeout, how long
+	// preparing and removing a backing directory and shutting down may take
+	ProvisionTimeout metav1.Duration `json:"provisionTimeout,omitempty"`
+	DeleteTimeout    metav1.Duration `json:"deleteTimeout,omitempty"`
+	ShutdownTimeout  metav1.Duration `json:"shutdownTimeout,omitempty"`
+	// --cache-check-interval, --device-health-interval,
+	// --loop-device-check-interval, --node-override-interval,
+	// --node-status-interval, --latency-probe-interval,
+	// --volume-health-interval and --volume-stats-interval, how often the
+	// background loops run, those that can be are disabled when 0
+	CacheCheckInterval      metav1.Duration `json:"cacheCheckInterval,omitempty"`
+	DeviceHealthInterval    metav1.Duration `json:"deviceHealthInterval,omitempty"`
+	LoopDeviceCheckInterval metav1.Duration `json:"loopDeviceCheckInterval,omitempty"`
+	NodeOverrideInterval    metav1.Duration `json:"nodeOverrideInterval,omitempty"`
+	NodeStatusInterval      metav1.Duration `json:"nodeStatusInterval,omitempty"`
+	LatencyProbeInterval    metav1.Duration `json:"latencyProbeInterval,omitempty"`
+	VolumeHealthInterval    metav1.Duration `json:"volumeHealthInterval,omitempty"`
+	VolumeStatsInterval     metav1.Duration `json:"volumeStatsInterval,omitempty"`
+	// --slow-disk-threshold, the probe latency above which a pool is slow
+	SlowDiskThreshold metav1.Duration `json:"slowDiskThreshold,omitempty"`
+	// --usage-scan-interval and --usage-scan-workers, how often and by how
+	// many workers the disk usage of every volume is measured
+	UsageScanInterval metav1.Duration `json:"usageScanInterval,omitempty"`
+	UsageScanWorkers  int             `json:"usageScanWorkers,omitempty"`
+	// --pool-usage-condition, set the HostpathPoolUsageHigh node condition
+	// while a pool is above its highest usage threshold
+	PoolUsageCondition bool `json:"poolUsageCondition,omitempty"`
+	// --enforce-io-limits, apply the I/O limits of volumes to the cgroups
+	// below --cgroup-root every --io-limit-interval
+	EnforceIOLimits bool            `json:"enforceIOLimits,omitempty"`
+	CgroupRoot      string          `json:"cgroupRoot,omitempty"`
+	IOLimitInterval metav1.Duration `json:"ioLimitInterval,omitempty"`
+	// --health-port, serving /healthz and /readyz, failing once a claim or
+	// volume takes longer than --worker-deadline, disabled when 0
+	HealthPort     int             `json:"healthPort,omitempty"`
+	WorkerDeadline metav1.Duration `json:"workerDeadline,omitempty"`
+	// --admin-socket and --admin-port, the unix socket and the port on
+	// localhost of the admin API, and --admin-grpc-socket, the unix socket
+	// of the gRPC admin API, disabled when unset
+	AdminSocket     string `json:"adminSocket,omitempty"`
+	AdminPort       int    `json:"adminPort,omitempty"`
+	AdminGRPCSocket string `json:"adminGRPCSocket,omitempty"`
+	// --csi-endpoint and --csi-driver-name, the unix socket the CSI
+	// services are served on for the sidecars, disabled when unset, and the
+	// name of the driver
+	CSIEndpoint   string `json:"csiEndpoint,omitempty"`
+	CSIDriverName string `json:"csiDriverName,omitempty"`
+	// --pprof-port, the port on localhost profiling data is served on,
+	// disabled when 0
+	PprofPort int `json:"pprofPort,omitempty"`
+	// Commands are the binaries the provisioner runs
+	Commands CommandsConfig `json:"commands,omitempty"`
+	// NFS configures the NFS exports of backing directories
+	NFS NFSConfig `json:"nfs,omitempty"`
+	// DeviceInventory configures the inventory of the node's block devices
+	DeviceInventory DeviceInventoryConfig `json:"deviceInventory,omitempty"`
+}
+
+// CommandsConfig are the binaries the provisioner runs, looked up in PATH
+// unless they are paths.
+type CommandsConfig struct {
+	// --lvm, used for pools backed by LVM
+	LVM string `json:"lvm,omitempty"`
+	// --qemu-img, used for qcow2 backed volumes
+	QemuImg string `json:"qemuImg,omitempty"`
+	// --duperemove, pools with dedup: duperemove are deduplicated with
+	Duperemove string `json:"duperemove,omitempty"`
+	// --smartctl, reading the SMART health of the pool devices, which is not
+	// monitored when empty
+	Smartctl string `json:"smartctl,omitempty"`
+}
+
+// defaultCommands are looked up in PATH.
+var defaultCommands = CommandsConfig{
+	LVM:        "lvm",
+	QemuImg:    "qemu-img",
+	Duperemove: "duperemove",
+}
+
+// PoolConfig is a storage pool in the config file.
+type PoolConfig struct {
+	Name   string `json:"name"`
+	Path   string `json:"path"`
+	Device string `json:"device,omitempty"`
+	// LVMVolumeGroup and LVMThinPool name the thin pool volumes are
+	// created in as logical volumes, formatted with Filesystem
+	LVMVolumeGroup string `json:"lvmVolumeGroup,omitempty"`
+	LVMThinPool    string `json:"lvmThinPool,omitempty"`
+	Filesystem     string `json:"filesystem,omitempty"`
+	// Devices is the pattern of the block devices in Path handed out whole
+	// to block claims, e.g. "*" or "nvme-*"
+	Devices string `json:"devices,omitempty"`
+	// Dedup deduplicates the pool, zfs or duperemove, every DedupInterval
+	Dedup         string           `json:"dedup,omitempty"`
+	DedupInterval *metav1.Duration `json:"dedupInterval,omitempty"`
+	// CacheDevice is an SSD caching the thin pool with dm-cache, in
+	// CacheMode, writethrough or writeback
+	CacheDevice string `json:"cacheDevice,omitempty"`
+	CacheMode   string `json:"cacheMode,omitempty"`
+	// DeviceClass is the kind of disk behind the pool, nvme, ssd or hdd,
+	// storage classes ask for classes rather than pools
+	DeviceClass string `json:"deviceClass,omitempty"`
+}
+
+// QoSTierConfig is a QoS tier in the config file.
+type QoSTierConfig struct {
+	Name string `json:"name"`
+	// The I/O limits of the volumes of the tier, bandwidths in bytes per
+	// second
+	ReadIOPS       int64              `json:"readIOPS,omitempty"`
+	WriteIOPS      int64              `json:"writeIOPS,omitempty"`
+	ReadBandwidth  *resource.Quantity `json:"readBandwidth,omitempty"`
+	WriteBandwidth *resource.Quantity `json:"writeBandwidth,omitempty"`
+	// MaxLatency is the highest probed latency of the pools the volumes
+	// are placed in
+	MaxLatency *metav1.Duration `json:"maxLatency,omitempty"`
+	// DeviceClasses are the device classes of the pools the volumes are
+	// placed in
+	DeviceClasses []string `json:"deviceClasses,omitempty"`
+}
+
+// DefaultConfig returns the configuration of a provisioner nothing was
+// configured for, it has no storage pools.
+func DefaultConfig() *Config {
+	return &Config{
+		ProvisionerName:        defaultProvisionerName,
+		CSIDriverName:          defaultCSIDriverName,
+		PoolSelectionPolicy:    defaultPoolPolicy,
+		DefaultReclaimPolicy:   string(defaultReclaimPolicy),
+		MountCheckInterval:     metav1.Duration{Duration: defaultMountCheckInterval},
+		PoolUsageCheckInterval: metav1.Duration{Duration: time.Minute},
+		Controller:             defaultControllerConfig(),
+
+		Backend:                 backendHost,
+		MemoryPoolSize:          "100Gi",
+		LogFormat:               logFormatText,
+		RootfsPath:              "/",
+		MaxVolumeStripes:        4,
+		WipeDevices:             true,
+		ProvisionBurst:          1,
+		ProvisionTimeout:        metav1.Duration{Duration: 2 * time.Minute},
+		DeleteTimeout:           metav1.Duration{Duration: 10 * time.Minute},
+		ShutdownTimeout:         metav1.Duration{Duration: 20 * time.Second},
+		CacheCheckInterval:      metav1.Duration{Duration: 5 * time.Minute},
+		DeviceHealthInterval:    metav1.Duration{Duration: 10 * time.Minute},
+		LoopDeviceCheckInterval: metav1.Duration{Duration: 10 * time.Minute},
+		LatencyProbeInterval:    metav1.Duration{Duration: 30 * time.Second},
+		VolumeHealthInterval:    metav1.Duration{Duration: 5 * time.Minute},
+		VolumeStatsInterval:     metav1.Duration{Duration: 10 * time.Minute},
+		SlowDiskThreshold:       metav1.Duration{Duration: time.Second},
+		UsageScanInterval:       metav1.Duration{Duration: time.Minute},
+		UsageScanWorkers:        1,
+		CgroupRoot:              "/sys/fs/cgroup",
+		IOLimitInterval:         metav1.Duration{Duration: 30 * time.Second},
+		WorkerDeadline:          metav1.Duration{Duration: 5 * time.Minute},
+		Commands:                defaultCommands,
+		NFS: NFSConfig{
+			ExportsFile:   "/etc/exports.d/hostpath-provisioner.exports",
+			ExportOptions: "rw,sync,no_subtree_check,no_root_squash",
+			Exportfs:      "exportfs",
+		},
+	}
+}
+
+// ConfigSources are where Run reads the configuration from, later ones
+// taking precedence: the defaults, File, ConfigMap, the environment and
+// Override.
+type ConfigSources struct {
+	// File is the path of a YAML config file, none when empty
+	File string
+	// ConfigMap names a ConfigMap in the provisioner's namespace whose
+	// config.yaml key configures the provisioner on top of File, none when
+	// empty
+	ConfigMap string
+	// ReloadInterval is how often File and ConfigMap are checked for
+	// changes, only on SIGHUP when 0
+	ReloadInterval time.Duration
+	// Getenv looks up the environment variables overriding settings,
+	// os.Getenv when nil
+	Getenv func(string) string
+	// Override changes the configuration last, e.g. to apply command line
+	// flags, every time it is loaded
+	Override func(*Config) error
+}
+
+// configLoader reads the configuration from its sources, later ones taking
+// precedence: the defaults, the config file, the ConfigMap, the environment
+// and override.
+type configLoader struct {
+	path string
+	// client reads the ConfigMap named configMap in namespace, if any
+	client    kubernetes.Interface
+	namespace string
+	configMap string
+	getenv    func(string) string
+	// override, when set, changes the configuration last
+	override func(*Config) error
+}
+
+// load reads the configuration and validates it.
+func (l *configLoader) load() (*Config, error) {
+	c := DefaultConfig()
+	if l.path != "" {
+		data, err := ioutil.ReadFile(l.path)
+		if err != nil {
+			return nil, fmt.Errorf("unable to read config file: %v", err)
+		}
+		if err := decodeConfig(data, c); err != nil {
+			return nil, fmt.Errorf("invalid config file %s: %v", l.path, err)
+		}
+	}
+	if l.configMap != "" {
+		// A missing ConfigMap configures nothing, so that it can be created
+		// once there is something to tune
+		configMap, err := l.client.CoreV1().ConfigMaps(l.namespace).Get(context.TODO(), l.configMap, metav1.GetOptions{})
+		if err != nil && !apierrs.IsNotFound(err) {
+			return nil, fmt.Errorf("unable to read configmap %s/%s: %v", l.namespace, l.configMap, err)
+		}
+		if err == nil {
+			if err := decodeConfig([]byte(configMap.Data[configMapKey]), c); err != nil {
+				return nil, fmt.Errorf("invalid configmap %s/%s: %v", l.namespace, l.configMap, err)
+			}
+		}
+	}
+	if err := c.applyEnv(l.getenv); err != nil {
+		return nil, err
+	}
+	if l.override != nil {
+		if err := l.override(c); err != nil {
+			return nil, err
+		}
+	}
+	if err := c.Validate(); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// decodeConfig decodes a YAML or JSON config into c, rejecting unknown
+// settings so that typos do not go unnoticed.
+func decodeConfig(data []byte, c *Config) error {
+	data, err := yaml.ToJSON(data)
+	if err != nil {
+		return err
+	}
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.DisallowUnknownFields()
+	return decoder.Decode(c)
+}
+
+// applyEnv overrides the settings whose environment variable is set.
+func (c *Config) applyEnv(getenv func(string) string) error {
+	setString := func(name string, value *string) {
+		if env := getenv(name); env != "" {
+			*value = env
+		}
+	}
+	setDuration := func(name string, value *metav1.Duration) error {
+		env := getenv(name)
+		if env == "" {
+			return nil
+		}
+		duration, err := time.ParseDuration(env)
+		if err != nil {
+			return fmt.Errorf("invalid %s: %v", name, err)
+		}
+		value.Duration = duration
+		return nil
+	}
+
+	setString("PROVISIONER_NAME", &c.ProvisionerName)
+	setString("NODE_NAME", &c.NodeName)
+	if env := getenv("PROVISIONER_ALIASES"); env != "" {
+		c.ProvisionerAliases = nil
+		for _, alias := range strings.Split(env, ",") {
+			if alias = strings.TrimSpace(alias); alias != "" {
+				c.ProvisionerAliases = append(c.ProvisionerAliases, alias)
+			}
+		}
+	}
+	setString("PV_DIR", &c.PVDir)
+	setString("POOL_SELECTION_POLICY", &c.PoolSelectionPolicy)
+	setString("NAMING_MODE", &c.NamingMode)
+	setString("NAMING_TEMPLATE", &c.NamingTemplate)
+	setString("DIRECTORY_MODE", &c.DirectoryMode)
+	setString("SELINUX_CONTEXT", &c.SELinuxContext)
+	setString("ALLOWED_UIDS", &c.AllowedUIDs)
+	setString("ALLOWED_GIDS", &c.AllowedGIDs)
+	setString("DEFAULT_RECLAIM_POLICY", &c.DefaultReclaimPolicy)
+	setString("CAPACITY_ROUNDING", &c.CapacityRounding)
+	setString("CAPACITY_ROUNDING_UNIT", &c.CapacityRoundingUnit)
+	setString("CLAIM_SELECTOR", &c.ClaimSelector)
+	setString("QUOTA_CONFIGMAP", &c.QuotaConfigMap)
+	if env := getenv("USE_NAMING_PREFIX"); env != "" {
+		c.UseNamingPrefix = strings.ToLower(env) == "true"
+	}
+	if env := getenv("PV_POOLS"); env != "" {
+		pools, err := parsePools("", env)
+		if err != nil {
+			return fmt.Errorf("invalid %s: %v", "PV_POOLS", err)
+		}
+		c.Pools = nil
+		for _, pool := range pools {
+			c.Pools = append(c.Pools, PoolConfig{Name: pool.name, Path: pool.path})
+		}
+	}
+	if env := getenv("POOL_DEVICES"); env != "" {
+		pools, err := c.storagePools()
+		if err != nil {
+			return err
+		}
+		if err := parsePoolDevices(pools, env); err != nil {
+			return fmt.Errorf("invalid %s: %v", "POOL_DEVICES", err)
+		}
+		// The default pool at PV_DIR becomes a configured pool
+		if len(c.Pools) == 0 {
+			c.Pools = []PoolConfig{{Name: pools[0].name, Path: pools[0].path}}
+		}
+		for i, pool := range pools {
+			c.Pools[i].Device = pool.device
+		}
+	}
+	if env := getenv("POOL_USAGE_THRESHOLDS"); env != "" {
+		thresholds, err := parseUsageThresholds(env)
+		if err != nil {
+			return fmt.Errorf("invalid %s: %v", "POOL_USAGE_THRESHOLDS", err)
+		}
+		c.PoolUsageThresholds = thresholds
+	}
+	if err := setDuration("MOUNT_CHECK_INTERVAL", &c.MountCheckInterval); err != nil {
+		return err
+	}
+	return setDuration("POOL_USAGE_CHECK_INTERVAL", &c.PoolUsageCheckInterval)
+}
+
+// Validate checks that the configuration is complete and consistent.
+func (c *Config) Validate() error {
+	if c.NodeName == "" {
+		return fmt.Errorf("the node name must be set, with --node-name, NODE_NAME or nodeName in the config file, so that this provisioner can identify itself")
+	}
+	// Names such as kubevirt.io/hostpath-provisioner, the provisioner name
+	// ends up in the annotations of claims and volumes
+	if errs := validation.IsQualifiedName(c.ProvisionerName); len(errs) > 0 {
+		return fmt.Errorf("invalid provisioner name %q: %s", c.ProvisionerName, strings.Join(errs, ", "))
+	}
+	for _, alias := range c.ProvisionerAliases {
+		if errs := validation.IsQualifiedName(alias); len(errs) > 0 {
+			return fmt.Errorf("invalid provisioner alias %q: %s", alias, strings.Join(errs, ", "))
+		}
+	}
+	if c.CSIEndpoint != "" {
+		if _, err := csiSocketPath(c.CSIEndpoint); err != nil {
+			return err
+		}
+		// CSI driver names are DNS names, e.g. hostpath.csi.kubevirt.io
+		if errs := validation.IsDNS1123Subdomain(c.CSIDriverName); len(errs) > 0 {
+			return fmt.Errorf("invalid CSI driver name %q: %s", c.CSIDriverName, strings.Join(errs, ", "))
+		}
+	}
+	if _, err := c.storagePools(); err != nil {
+		return err
+	}
+	if _, err := lookupPoolPolicy(c.PoolSelectionPolicy); err != nil {
+		return err
+	}
+	if _, err := c.qosTiers(); err != nil {
+		return err
+	}
+	if err := validateReclaimPolicy(c.DefaultReclaimPolicy); err != nil {
+		return err
+	}
+	if err := validateNamingMode(c.NamingMode); err != nil {
+		return err
+	}
+	if _, err := c.namingTemplate(); err != nil {
+		return err
+	}
+	if _, err := c.directoryMode(); err != nil {
+		return err
+	}
+	if c.SELinuxContext != "" {
+		if err := validateSELinuxContext(c.SELinuxContext); err != nil {
+			return err
+		}
+	}
+	if _, _, err := c.allowedOwners(); err != nil {
+		return err
+	}
+	if _, err := c.capacityRounding(); err != nil {
+		return err
+	}
+	if _, err := labels.Parse(c.ClaimSelector); err != nil {
+		return fmt.Errorf("invalid claim selector: %v", err)
+	}
+	for _, threshold := range c.PoolUsageThresholds {
+		if threshold <= 0 || threshold > 100 {
+			return fmt.Errorf("invalid usage threshold %d, expected a percentage between 1 and 100", threshold)
+		}
+	}
+	sort.Ints(c.PoolUsageThresholds)
+	if _, err := c.Controller.options(); err != nil {
+		return fmt.Errorf("invalid controller configuration: %v", err)
+	}
+	if election := c.Controller.LeaderElection; election.Enabled {
+		if err := checkLeaderElectionTiming(election.LeaseDuration.Duration, election.RenewDeadline.Duration, election.RetryPeriod.Duration); err != nil {
+			return fmt.Errorf("invalid controller configuration: %v", err)
+		}
+	}
+	return c.validateSettings()
+}
+
+// validateSettings checks the settings of the provisioner itself, those
+// that are not about provisioning volumes.
+func (c *Config) validateSettings() error {
+	if _, err := newBackend(c.Backend, c.MemoryPoolSize); err != nil {
+		return err
+	}
+	if c.LogFormat != logFormatText && c.LogFormat != logFormatJSON {
+		return fmt.Errorf("invalid log format %q, expected %s or %s", c.LogFormat, logFormatText, logFormatJSON)
+	}
+	if err := newFeatureGate(knownFeatures).set(c.FeatureGates); err != nil {
+		return err
+	}
+	for _, context := range strings.Fields(c.AllowedSELinuxContexts) {
+		if err := validateSELinuxContext(context); err != nil {
+			return err
+		}
+	}
+	if _, err := parseTmpfsBudget(c.TmpfsBudget); err != nil {
+		return err
+	}
+	if _, err := newNamespaceFilter(c.AllowedNamespaces, c.DeniedNamespaces); err != nil {
+		return fmt.Errorf("invalid namespace filter: %v", err)
+	}
+	if _, err := newPoolRateLimiter(c.ProvisionRate, c.ProvisionBurst); err != nil {
+		return err
+	}
+	if c.DeviceInventory.Interval.Duration > 0 {
+		inventory := c.DeviceInventory
+		if _, err := newDeviceFilter(inventory.MinSize, inventory.Model, inventory.Serial); err != nil {
+			return fmt.Errorf("invalid device inventory filter: %v", err)
+		}
+	}
+	return nil
+}
+
+// storagePools returns the configured pools, or a single pool at PVDir when
+// none are configured.
+func (c *Config) storagePools() ([]*storagePool, error) {
+	if len(c.Pools) == 0 {
+		if c.PVDir == "" {
+			return nil, fmt.Errorf("the volume directory must be set, with --pv-dir, --pools, PV_DIR, PV_POOLS or the config file, so that this provisioner knows where to place its data")
+		}
+		return parsePools(c.PVDir, "")
+	}
+	pools := []*storagePool{}
+	for _, pool := range c.Pools {
+		if pool.Name == "" || pool.Path == "" {
+			return nil, fmt.Errorf("invalid storage pool %q at %q, name and path are required", pool.Name, pool.Path)
+		}
+		if findPool(pools, pool.Name) != nil {
+			return nil, fmt.Errorf("duplicate storage pool name %q", pool.Name)
+		}
+		lvm, err := newLVMPool(pool.LVMVolumeGroup, pool.LVMThinPool, pool.Filesystem)
+		if err != nil {
+			return nil, fmt.Errorf("invalid storage pool %q: %v", pool.Name, err)
+		}
+		if lvm != nil {
+			lvm.command = lvmCommand(c.Commands.LVM)
+		}
+		devices, err := newDevicePool(pool.Devices)
+		if err != nil {
+			return nil, fmt.Errorf("invalid storage pool %q: %v", pool.Name, err)
+		}
+		if lvm != nil && devices != nil {
+			return nil, fmt.Errorf("invalid storage pool %q: a pool can not hold both logical volumes and devices", pool.Name)
+		}
+		var interval time.Duration
+		if pool.DedupInterval != nil {
+			interval = pool.DedupInterval.Duration
+		}
+		dedup, err := newPoolDedup(pool.Dedup, interval)
+		if err != nil {
+			return nil, fmt.Errorf("invalid storage pool %q: %v", pool.Name, err)
+		}
+		if dedup.mode != "" && (lvm != nil || devices != nil) {
+			return nil, fmt.Errorf("invalid storage pool %q: only pools of plain directories can be deduplicated", pool.Name)
+		}
+		cache, err := newPoolCache(pool.CacheDevice, pool.CacheMode, lvm)
+		if err != nil {
+			return nil, fmt.Errorf("invalid storage pool %q: %v", pool.Name, err)
+		}
+		if pool.DeviceClass != "" {
+			if err := validDeviceClass(pool.DeviceClass); err != nil {
+				return nil, fmt.Errorf("invalid storage pool %q: %v", pool.Name, err)
+			}
+		}
+		pools = append(pools, &storagePool{name: pool.Name, path: pool.Path, device: pool.Device, deviceClass: pool.DeviceClass, lvm: lvm, devices: devices, dedup: dedup, cache: cache})
+	}
+	return pools, nil
+}
+
+// qosTiers returns the configured QoS tiers by name.
+func (c *Config) qosTiers() (map[string]*qosTier, error) {
+	tiers := map[string]*qosTier{}
+	for _, tierConfig := range c.QoSTiers {
+		tier, err := newQoSTier(tierConfig)
+		if err != nil {
+			return nil, err
+		}
+		if tiers[tier.name] != nil {
+			return nil, fmt.Errorf("duplicate QoS tier name %q", tier.name)
+		}
+		tiers[tier.name] = tier
+	}
+	return tiers, nil
+}
+
+func (c *Config) capacityRounding() (capacityRounding, error) {
+	return parseCapacityRounding(c.CapacityRounding, c.CapacityRoundingUnit)
+}
+
+// directoryMode returns the permissions of backing directories.
+func (c *Config) directoryMode() (os.FileMode, error) {
+	if c.DirectoryMode == "" {
+		return defaultDirectoryMode, nil
+	}
+	return parseDirectoryMode(c.DirectoryMode)
+}
+
+// allowedOwners returns the user and group IDs claims may ask for.
+func (c *Config) allowedOwners() (idRanges, idRanges, error) {
+	uids, err := parseIDRanges(c.AllowedUIDs)
+	if err != nil {
+		return nil, nil, fmt.Errorf("invalid allowed UIDs: %v", err)
+	}
+	gids, err := parseIDRanges(c.AllowedGIDs)
+	if err != nil {
+		return nil, nil, fmt.Errorf("invalid allowed GIDs: %v", err)
+	}
+	return uids, gids, nil
+}
+
+// namingTemplate returns the parsed naming template, nil when none is set.
+func (c *Config) namingTemplate() (*template.Template, error) {
+	if c.NamingTemplate == "" {
+		return nil, nil
+	}
+	return parseNamingTemplate(c.NamingTemplate)
+}
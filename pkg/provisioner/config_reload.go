@@ -0,0 +1,387 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package provisioner
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/signal"
+	"reflect"
+	"sort"
+	"sync"
+	"syscall"
+	"text/template"
+	"time"
+
+	"k8s.io/klog/v2"
+
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/util/wait"
+)
+
+// configReloader reloads the configuration from the config file and the
+// ConfigMap and applies the changes that are safe to make while volumes are
+// being provisioned: the pool selection policy, naming, capacity rounding, the
+// claim selector, the usage thresholds, the QoS tiers and new pools. Other
+// changes take
+// effect when the provisioner is restarted.
+type configReloader struct {
+	p      *HostPathProvisioner
+	loader *configLoader
+
+	mutex   sync.Mutex
+	current *Config
+}
+
+func newConfigReloader(p *HostPathProvisioner, loader *configLoader, current *Config) *configReloader {
+	return &configReloader{p: p, loader: loader, current: current}
+}
+
+// Run reloads the configuration every interval until stopCh is closed.
+func (r *configReloader) Run(interval time.Duration, stopCh <-chan struct{}) {
+	wait.Until(func() {
+		r.reload()
+	}, interval, stopCh)
+}
+
+// handleSignals reloads the configuration on SIGHUP.
+func (r *configReloader) handleSignals() {
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGHUP)
+	go func() {
+		for range signals {
+			klog.Infof("reloading the configuration on SIGHUP")
+			if r.reload() == nil {
+				klog.Infof("configuration reloaded")
+			}
+		}
+	}()
+}
+
+// reload loads the configuration and applies it if it changed. An invalid
+// configuration is rejected, the provisioner keeps the one it has.
+func (r *configReloader) reload() error {
+	cfg, err := r.loader.load()
+	if err != nil {
+		klog.Errorf("Ignoring invalid configuration, keeping the current one: %v", err)
+		return err
+	}
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	if reflect.DeepEqual(cfg, r.current) {
+		return nil
+	}
+	for _, change := range configDiff(r.current, cfg) {
+		klog.InfoS("Configuration changed", "node", r.p.nodeName, "setting", change.setting, "old", change.old, "new", change.new)
+	}
+	r.p.applyConfig(r.current, cfg)
+	r.current = cfg
+	return nil
+}
+
+// applyConfig applies the differences between the old and the new
+// configuration that are safe to make at runtime, and warns about the ones
+// that require a restart. Both have been validated.
+func (p *HostPathProvisioner) applyConfig(old, cfg *Config) {
+	restart := func(setting string) {
+		klog.Warningf("the %s setting changed, restart the provisioner to apply it", setting)
+	}
+
+	if cfg.PoolSelectionPolicy != old.PoolSelectionPolicy {
+		p.mutex.Lock()
+		p.poolPolicy = cfg.PoolSelectionPolicy
+		p.mutex.Unlock()
+	}
+	if cfg.DefaultReclaimPolicy != old.DefaultReclaimPolicy {
+		p.mutex.Lock()
+		p.reclaimPolicy = v1.PersistentVolumeReclaimPolicy(cfg.DefaultReclaimPolicy)
+		p.mutex.Unlock()
+	}
+	if cfg.UseNamingPrefix != old.UseNamingPrefix || cfg.NamingMode != old.NamingMode {
+		p.mutex.Lock()
+		p.useNamingPrefix = cfg.UseNamingPrefix
+		p.namingMode = cfg.NamingMode
+		p.mutex.Unlock()
+	}
+	if cfg.NamingTemplate != old.NamingTemplate {
+		tmpl, _ := cfg.namingTemplate()
+		p.mutex.Lock()
+		p.namingTemplate = tmpl
+		p.mutex.Unlock()
+	}
+	if cfg.DirectoryMode != old.DirectoryMode {
+		mode, _ := cfg.directoryMode()
+		p.mutex.Lock()
+		p.directoryMode = mode
+		p.mutex.Unlock()
+	}
+	if cfg.SELinuxContext != old.SELinuxContext {
+		p.mutex.Lock()
+		p.selinuxContext = cfg.SELinuxContext
+		p.mutex.Unlock()
+	}
+	if cfg.AllowedUIDs != old.AllowedUIDs || cfg.AllowedGIDs != old.AllowedGIDs {
+		uids, gids, _ := cfg.allowedOwners()
+		p.mutex.Lock()
+		p.allowedUIDs, p.allowedGIDs = uids, gids
+		p.mutex.Unlock()
+	}
+	if cfg.CapacityRounding != old.CapacityRounding || cfg.CapacityRoundingUnit != old.CapacityRoundingUnit {
+		rounding, _ := cfg.capacityRounding()
+		p.mutex.Lock()
+		p.rounding = rounding
+		p.mutex.Unlock()
+	}
+	if cfg.ClaimSelector != old.ClaimSelector {
+		selector, _ := labels.Parse(cfg.ClaimSelector)
+		p.mutex.Lock()
+		p.claimSelector = selector
+		p.mutex.Unlock()
+	}
+	if !reflect.DeepEqual(cfg.QoSTiers, old.QoSTiers) {
+		tiers, _ := cfg.qosTiers()
+		p.mutex.Lock()
+		p.qosTiers = tiers
+		p.mutex.Unlock()
+	}
+	if !reflect.DeepEqual(cfg.PoolUsageThresholds, old.PoolUsageThresholds) && p.usageWatcher != nil {
+		p.usageWatcher.setThresholds(cfg.PoolUsageThresholds)
+	}
+	p.applyPools(old, cfg)
+
+	for _, change := range configDiff(old, cfg) {
+		if !reloadableSettings[change.setting] {
+			restart(change.setting)
+		}
+	}
+}
+
+// reloadableSettings are the settings applyConfig changes at runtime, the
+// others take effect once the provisioner is restarted.
+var reloadableSettings = map[string]bool{
+	"pvDir":                true,
+	"pools":                true,
+	"poolSelectionPolicy":  true,
+	"useNamingPrefix":      true,
+	"namingMode":           true,
+	"namingTemplate":       true,
+	"directoryMode":        true,
+	"selinuxContext":       true,
+	"allowedUIDs":          true,
+	"allowedGIDs":          true,
+	"defaultReclaimPolicy": true,
+	"capacityRounding":     true,
+	"capacityRoundingUnit": true,
+	"claimSelector":        true,
+	"poolUsageThresholds":  true,
+	"qosTiers":             true,
+}
+
+// configChange is a setting that differs between two configurations, with
+// its old and new value in JSON.
+type configChange struct {
+	setting string
+	old     string
+	new     string
+}
+
+// configDiff returns the settings that differ between the old and the new
+// configuration, sorted by name.
+func configDiff(old, cfg *Config) []configChange {
+	settings := func(c *Config) map[string]json.RawMessage {
+		data, _ := json.Marshal(c)
+		values := map[string]json.RawMessage{}
+		json.Unmarshal(data, &values)
+		return values
+	}
+	oldSettings, newSettings := settings(old), settings(cfg)
+	names := map[string]bool{}
+	for name := range oldSettings {
+		names[name] = true
+	}
+	for name := range newSettings {
+		names[name] = true
+	}
+	var changes []configChange
+	for name := range names {
+		if !bytes.Equal(oldSettings[name], newSettings[name]) {
+			changes = append(changes, configChange{setting: name, old: settingValue(oldSettings[name]), new: settingValue(newSettings[name])})
+		}
+	}
+	sort.Slice(changes, func(i, j int) bool {
+		return changes[i].setting < changes[j].setting
+	})
+	return changes
+}
+
+func settingValue(value json.RawMessage) string {
+	if value == nil {
+		return "<unset>"
+	}
+	return string(value)
+}
+
+// applyPools adds the pools that are new in cfg. Pools that were removed or
+// changed stay as they are until the provisioner is restarted, volumes may
+// still live in them.
+func (p *HostPathProvisioner) applyPools(old, cfg *Config) {
+	oldPools, _ := old.storagePools()
+	newPools, _ := cfg.storagePools()
+	for _, pool := range oldPools {
+		current := findPool(newPools, pool.name)
+		if current == nil {
+			klog.Warningf("pool %s was removed from the configuration, restart the provisioner to stop using it", pool.name)
+		} else if *current != *pool {
+			klog.Warningf("pool %s changed, restart the provisioner to apply the change", pool.name)
+		}
+	}
+	for _, pool := range newPools {
+		if findPool(oldPools, pool.name) != nil {
+			continue
+		}
+		if err := p.addPool(pool); err != nil {
+			klog.ErrorS(err, "Unable to add pool", "node", p.nodeName, "pool", pool.name, "path", pool.path)
+			continue
+		}
+		klog.InfoS("Added pool", "node", p.nodeName, "pool", pool.name, "path", pool.path)
+	}
+}
+
+// addPool starts placing volumes in a pool added after start up, after the
+// same checks as the pools configured at start up.
+func (p *HostPathProvisioner) addPool(pool *storagePool) error {
+	if findPool(p.currentPools(), pool.name) != nil {
+		return fmt.Errorf("pool %s already exists", pool.name)
+	}
+	if p.simulated {
+		if err := simulatedPool(pool); err != nil {
+			return err
+		}
+	}
+	if !p.checkRootfs(pool) {
+		return fmt.Errorf("pool %s at %s can't be used", pool.name, pool.path)
+	}
+	if p.monitor != nil {
+		if err := p.monitor.add(pool); err != nil {
+			return err
+		}
+	}
+	if p.tamper != nil {
+		p.tamper.watchPools([]*storagePool{pool})
+	}
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	// The slice is replaced rather than appended to, readers may be
+	// iterating over the old one
+	pools := make([]*storagePool, 0, len(p.pools)+1)
+	p.pools = append(append(pools, p.pools...), pool)
+	return nil
+}
+
+// currentPools returns the pools volumes can be placed in.
+func (p *HostPathProvisioner) currentPools() []*storagePool {
+	p.mutex.RLock()
+	defer p.mutex.RUnlock()
+	return p.pools
+}
+
+// defaultPoolPolicy returns the policy used for storage classes that do not
+// choose one.
+func (p *HostPathProvisioner) defaultPoolPolicy() string {
+	p.mutex.RLock()
+	defer p.mutex.RUnlock()
+	return p.poolPolicy
+}
+
+// currentNamingMode returns how backing directories are named when there is
+// no naming template. useNamingPrefix selects the claim mode when no mode is
+// configured.
+func (p *HostPathProvisioner) currentNamingMode() string {
+	p.mutex.RLock()
+	defer p.mutex.RUnlock()
+	if p.namingMode != "" {
+		return p.namingMode
+	}
+	if p.useNamingPrefix {
+		return namingClaim
+	}
+	return namingPV
+}
+
+// currentReclaimPolicy returns the reclaim policy of volumes whose storage
+// class does not set one.
+func (p *HostPathProvisioner) currentReclaimPolicy() v1.PersistentVolumeReclaimPolicy {
+	p.mutex.RLock()
+	defer p.mutex.RUnlock()
+	return p.reclaimPolicy
+}
+
+// currentNamingTemplate returns the template backing directories are named
+// with, nil when they are named after the PV.
+func (p *HostPathProvisioner) currentNamingTemplate() *template.Template {
+	p.mutex.RLock()
+	defer p.mutex.RUnlock()
+	return p.namingTemplate
+}
+
+// currentDirectoryMode returns the permissions of backing directories for
+// storage classes and claims that do not set them.
+func (p *HostPathProvisioner) currentDirectoryMode() os.FileMode {
+	p.mutex.RLock()
+	defer p.mutex.RUnlock()
+	return p.directoryMode
+}
+
+// currentSELinuxContext returns the context backing directories are labeled
+// with for storage classes and claims that do not set one.
+func (p *HostPathProvisioner) currentSELinuxContext() string {
+	p.mutex.RLock()
+	defer p.mutex.RUnlock()
+	return p.selinuxContext
+}
+
+// currentAllowedOwners returns the user and group IDs claims may ask for.
+func (p *HostPathProvisioner) currentAllowedOwners() (idRanges, idRanges) {
+	p.mutex.RLock()
+	defer p.mutex.RUnlock()
+	return p.allowedUIDs, p.allowedGIDs
+}
+
+// currentRounding returns how the capacity reported on PVs is rounded.
+func (p *HostPathProvisioner) currentRounding() capacityRounding {
+	p.mutex.RLock()
+	defer p.mutex.RUnlock()
+	return p.rounding
+}
+
+// currentClaimSelector returns the selector limiting the claims acted on, nil
+// when all claims are.
+func (p *HostPathProvisioner) currentClaimSelector() labels.Selector {
+	p.mutex.RLock()
+	defer p.mutex.RUnlock()
+	return p.claimSelector
+}
+
+// currentQoSTier returns the QoS tier of the name, nil when there is none.
+func (p *HostPathProvisioner) currentQoSTier(name string) *qosTier {
+	p.mutex.RLock()
+	defer p.mutex.RUnlock()
+	return p.qosTiers[name]
+}
@@ -0,0 +1,103 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package provisioner
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func Test_configReloader(t *testing.T) {
+	dir, err := ioutil.TempDir("", "reload")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "config.yaml")
+	write := func(content string) {
+		if err := ioutil.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	write("nodeName: node01\npools:\n- {name: ssd, path: /mnt/ssd}\n")
+	loader := &configLoader{path: path, getenv: func(string) string { return "" }}
+	cfg, err := loader.load()
+	if err != nil {
+		t.Fatal(err)
+	}
+	pools, _ := cfg.storagePools()
+	watcher := newPoolUsageWatcher(nil, hostBackend(), "node01", nil, nil, false)
+	p := &HostPathProvisioner{nodeName: "node01", allowRootfs: true, pools: pools, poolPolicy: cfg.PoolSelectionPolicy, usageWatcher: watcher}
+	r := newConfigReloader(p, loader, cfg)
+
+	write("nodeName: node01\npools:\n- {name: ssd, path: /mnt/ssd}\n- {name: hdd, path: /mnt/hdd}\npoolSelectionPolicy: round-robin\nuseNamingPrefix: true\nclaimSelector: storage=hostpath\npoolUsageThresholds: [90]\nqosTiers:\n- {name: gold, readIOPS: 1000}\n")
+	if err := r.reload(); err != nil {
+		t.Fatalf("reload() = %v", err)
+	}
+	var names []string
+	for _, pool := range p.currentPools() {
+		names = append(names, pool.name)
+	}
+	if !reflect.DeepEqual(names, []string{"ssd", "hdd"}) {
+		t.Errorf("pools = %v, want the new pool added", names)
+	}
+	if p.defaultPoolPolicy() != policyRoundRobin || p.currentNamingMode() != namingClaim || p.currentClaimSelector().String() != "storage=hostpath" {
+		t.Errorf("settings not applied: policy %s, naming %s, selector %v", p.defaultPoolPolicy(), p.currentNamingMode(), p.currentClaimSelector())
+	}
+	if !reflect.DeepEqual(watcher.thresholds, []int{90}) {
+		t.Errorf("thresholds = %v, want [90]", watcher.thresholds)
+	}
+	if tier := p.currentQoSTier("gold"); tier == nil || tier.limits.riops != 1000 {
+		t.Errorf("QoS tier gold = %+v, want the new tier", tier)
+	}
+
+	// Removing a pool needs a restart, the pool stays
+	write("nodeName: node01\npools:\n- {name: hdd, path: /mnt/hdd}\npoolSelectionPolicy: round-robin\n")
+	if err := r.reload(); err != nil {
+		t.Fatalf("reload() = %v", err)
+	}
+	if len(p.currentPools()) != 2 {
+		t.Errorf("pools = %v, removed pools must stay until a restart", p.currentPools())
+	}
+
+	write("nodeName: node01\npools:\n- {name: hdd, path: /mnt/hdd}\npoolSelectionPolicy: random\n")
+	if err := r.reload(); err == nil {
+		t.Error("reload() accepted an invalid configuration")
+	}
+	if p.defaultPoolPolicy() != policyRoundRobin {
+		t.Errorf("policy = %s, an invalid configuration must not be applied", p.defaultPoolPolicy())
+	}
+}
+
+func Test_configDiff(t *testing.T) {
+	old := &Config{NodeName: "node01", PVDir: "/var/hpvolumes", PoolSelectionPolicy: policyMostFree}
+	cfg := &Config{NodeName: "node01", PoolSelectionPolicy: policyRoundRobin, Pools: []PoolConfig{{Name: "ssd", Path: "/mnt/ssd"}}}
+	want := []configChange{
+		{setting: "poolSelectionPolicy", old: `"most-free"`, new: `"round-robin"`},
+		{setting: "pools", old: "<unset>", new: `[{"name":"ssd","path":"/mnt/ssd"}]`},
+		{setting: "pvDir", old: `"/var/hpvolumes"`, new: "<unset>"},
+	}
+	if got := configDiff(old, cfg); !reflect.DeepEqual(got, want) {
+		t.Errorf("configDiff() = %+v, want %+v", got, want)
+	}
+	if got := configDiff(old, old); len(got) != 0 {
+		t.Errorf("configDiff() = %+v for the same configuration", got)
+	}
+}
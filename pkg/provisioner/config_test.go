@@ -0,0 +1,226 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package provisioner
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func Test_configLoader(t *testing.T) {
+	dir, err := ioutil.TempDir("", "config")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	write := func(content string) string {
+		path := filepath.Join(dir, "config.yaml")
+		if err := ioutil.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+		return path
+	}
+	file := `
+nodeName: node01
+pools:
+- name: ssd
+  path: /var/hpvolumes/ssd
+  device: /dev/sdb
+- name: hdd
+  path: /var/hpvolumes/hdd
+poolSelectionPolicy: round-robin
+useNamingPrefix: true
+poolUsageThresholds: [95, 80]
+mountCheckInterval: 30s
+strictMounts: true
+nfs:
+  server: 10.0.0.1
+`
+
+	tests := []struct {
+		name    string
+		file    string
+		env     map[string]string
+		check   func(t *testing.T, c *Config)
+		wantErr string
+	}{
+		{
+			name: "environment only",
+			env:  map[string]string{"NODE_NAME": "node01", "PV_DIR": "/var/hpvolumes", "USE_NAMING_PREFIX": "true"},
+			check: func(t *testing.T, c *Config) {
+				if !reflect.DeepEqual(c.Pools, []PoolConfig(nil)) || c.PVDir != "/var/hpvolumes" || !c.UseNamingPrefix {
+					t.Errorf("unexpected config %+v", c)
+				}
+				if c.ProvisionerName != defaultProvisionerName || c.PoolSelectionPolicy != defaultPoolPolicy || c.MountCheckInterval.Duration != defaultMountCheckInterval {
+					t.Errorf("defaults not applied: %+v", c)
+				}
+			},
+		},
+		{
+			name: "file",
+			file: file,
+			check: func(t *testing.T, c *Config) {
+				want := []PoolConfig{{Name: "ssd", Path: "/var/hpvolumes/ssd", Device: "/dev/sdb"}, {Name: "hdd", Path: "/var/hpvolumes/hdd"}}
+				if !reflect.DeepEqual(c.Pools, want) {
+					t.Errorf("pools = %+v, want %+v", c.Pools, want)
+				}
+				if c.NodeName != "node01" || c.PoolSelectionPolicy != policyRoundRobin || !c.UseNamingPrefix || c.MountCheckInterval.Duration != 30*time.Second {
+					t.Errorf("unexpected config %+v", c)
+				}
+				if !reflect.DeepEqual(c.PoolUsageThresholds, []int{80, 95}) || !c.StrictMounts || c.NFS.Server != "10.0.0.1" || c.NFS.Exportfs != "exportfs" {
+					t.Errorf("unexpected config %+v", c)
+				}
+			},
+		},
+		{
+			name: "environment overrides file",
+			file: file,
+			env:  map[string]string{"NODE_NAME": "node02", "PV_POOLS": "fast=/mnt/fast", "POOL_DEVICES": "fast=/dev/nvme0n1", "USE_NAMING_PREFIX": "false"},
+			check: func(t *testing.T, c *Config) {
+				want := []PoolConfig{{Name: "fast", Path: "/mnt/fast", Device: "/dev/nvme0n1"}}
+				if !reflect.DeepEqual(c.Pools, want) {
+					t.Errorf("pools = %+v, want %+v", c.Pools, want)
+				}
+				if c.NodeName != "node02" || c.UseNamingPrefix {
+					t.Errorf("unexpected config %+v", c)
+				}
+			},
+		},
+		{name: "no node name", env: map[string]string{"PV_DIR": "/var/hpvolumes"}, wantErr: "node name"},
+		{name: "no pools", env: map[string]string{"NODE_NAME": "node01"}, wantErr: "volume directory"},
+		{name: "unknown setting", file: "nodeName: node01\npvDir: /var/hpvolumes\npvDirectory: /tmp\n", wantErr: "unknown field"},
+		{name: "unknown feature gate", file: "nodeName: node01\npvDir: /var/hpvolumes\nfeatureGates: {NoSuchFeature: true}\n", wantErr: "unknown feature gate"},
+		{name: "invalid log format", file: "nodeName: node01\npvDir: /var/hpvolumes\nlogFormat: xml\n", wantErr: "invalid log format"},
+		{name: "invalid backend", file: "nodeName: node01\npvDir: /var/hpvolumes\nbackend: cloud\n", wantErr: "invalid backend"},
+		{name: "duplicate pool", file: "nodeName: node01\npools:\n- {name: a, path: /a}\n- {name: a, path: /b}\n", wantErr: "duplicate"},
+		{name: "invalid device class", file: "nodeName: node01\npools:\n- {name: a, path: /a, deviceClass: tape}\n", wantErr: "invalid device class"},
+		{
+			name: "qos tiers",
+			file: "nodeName: node01\npvDir: /v\nqosTiers:\n- {name: gold, readIOPS: 5000, writeBandwidth: 200Mi, maxLatency: 5ms, deviceClasses: [nvme]}\n- {name: bronze}\n",
+			check: func(t *testing.T, c *Config) {
+				tiers, err := c.qosTiers()
+				if err != nil {
+					t.Fatal(err)
+				}
+				want := map[string]*qosTier{
+					"gold":   {name: "gold", limits: ioLimits{riops: 5000, wbps: 200 << 20}, maxLatency: 5 * time.Millisecond, deviceClasses: []string{deviceClassNVMe}},
+					"bronze": {name: "bronze"},
+				}
+				if !reflect.DeepEqual(tiers, want) {
+					t.Errorf("tiers = %+v, want %+v", tiers, want)
+				}
+			},
+		},
+		{name: "duplicate qos tier", file: "nodeName: node01\npvDir: /v\nqosTiers:\n- {name: gold}\n- {name: gold}\n", wantErr: "duplicate QoS tier"},
+		{name: "invalid qos tier", file: "nodeName: node01\npvDir: /v\nqosTiers:\n- {name: gold, writeIOPS: -1}\n", wantErr: "can not be negative"},
+		{name: "invalid qos tier device class", file: "nodeName: node01\npvDir: /v\nqosTiers:\n- {name: gold, deviceClasses: [tape]}\n", wantErr: "invalid device class"},
+		{name: "invalid policy", env: map[string]string{"NODE_NAME": "node01", "PV_DIR": "/v", "POOL_SELECTION_POLICY": "random"}, wantErr: "unknown pool selection policy"},
+		{name: "invalid naming mode", env: map[string]string{"NODE_NAME": "node01", "PV_DIR": "/v", "NAMING_MODE": "tenant"}, wantErr: "unknown naming mode"},
+		{name: "invalid threshold", file: "nodeName: node01\npvDir: /v\npoolUsageThresholds: [120]\n", wantErr: "invalid usage threshold"},
+		{
+			name: "provisioner name",
+			file: "provisionerName: example.com/team-a\nnodeName: node01\npvDir: /v\n",
+			env:  map[string]string{"PROVISIONER_NAME": "example.com/team-b"},
+			check: func(t *testing.T, c *Config) {
+				if c.ProvisionerName != "example.com/team-b" {
+					t.Errorf("provisioner name = %s, want example.com/team-b", c.ProvisionerName)
+				}
+			},
+		},
+		{
+			name: "provisioner aliases",
+			env:  map[string]string{"NODE_NAME": "node01", "PV_DIR": "/v", "PROVISIONER_ALIASES": "example.com/old-hostpath, example.com/older-hostpath"},
+			check: func(t *testing.T, c *Config) {
+				want := []string{"example.com/old-hostpath", "example.com/older-hostpath"}
+				if !reflect.DeepEqual(c.ProvisionerAliases, want) {
+					t.Errorf("aliases = %v, want %v", c.ProvisionerAliases, want)
+				}
+			},
+		},
+		{name: "invalid provisioner alias", file: "nodeName: node01\npvDir: /v\nprovisionerAliases: [\"not/a/name\"]\n", wantErr: "invalid provisioner alias"},
+		{name: "invalid provisioner name", env: map[string]string{"NODE_NAME": "node01", "PV_DIR": "/v", "PROVISIONER_NAME": "example.com/team a"}, wantErr: "invalid provisioner name"},
+		{name: "invalid interval", env: map[string]string{"NODE_NAME": "node01", "PV_DIR": "/v", "MOUNT_CHECK_INTERVAL": "often"}, wantErr: "MOUNT_CHECK_INTERVAL"},
+		{
+			name: "controller",
+			file: "nodeName: node01\npvDir: /v\ncontroller:\n  workers: 8\n  leaderElection: {enabled: true, leaseDuration: 30s}\n  retry: {maxDelay: 5m, maxAttempts: 0}\n",
+			check: func(t *testing.T, c *Config) {
+				want := defaultControllerConfig()
+				want.Workers = 8
+				want.LeaderElection.Enabled = true
+				want.LeaderElection.LeaseDuration.Duration = 30 * time.Second
+				want.Retry.MaxDelay.Duration = 5 * time.Minute
+				want.Retry.MaxAttempts = 0
+				if c.Controller != want {
+					t.Errorf("controller = %+v, want %+v", c.Controller, want)
+				}
+			},
+		},
+		{name: "invalid workers", file: "nodeName: node01\npvDir: /v\ncontroller: {workers: 0}\n", wantErr: "invalid number of workers"},
+		{name: "invalid leader election", file: "nodeName: node01\npvDir: /v\ncontroller:\n  leaderElection: {enabled: true, leaseDuration: 5s}\n", wantErr: "invalid leader election timing"},
+		{name: "invalid retry", file: "nodeName: node01\npvDir: /v\ncontroller:\n  retry: {multiplier: 0.5}\n", wantErr: "invalid retry multiplier"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := ""
+			if tt.file != "" {
+				path = write(tt.file)
+			}
+			c, err := (&configLoader{path: path, getenv: func(name string) string { return tt.env[name] }}).load()
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Errorf("loadConfig() error = %v, want %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("loadConfig() error = %v", err)
+			}
+			tt.check(t, c)
+		})
+	}
+}
+
+func Test_configLoaderOverride(t *testing.T) {
+	env := map[string]string{"PV_DIR": "/mnt/env", "NODE_NAME": "node01"}
+	loader := &configLoader{
+		getenv: func(name string) string { return env[name] },
+		override: func(c *Config) error {
+			c.PVDir = "/mnt/override"
+			return nil
+		},
+	}
+	c, err := loader.load()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c.PVDir != "/mnt/override" || c.NodeName != "node01" {
+		t.Errorf("unexpected config %+v", c)
+	}
+
+	loader.override = func(c *Config) error {
+		c.LogFormat = "xml"
+		return nil
+	}
+	if _, err := loader.load(); err == nil {
+		t.Error("load() accepted an invalid override")
+	}
+}
@@ -0,0 +1,133 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package provisioner
+
+import (
+	"fmt"
+
+	"sigs.k8s.io/sig-storage-lib-external-provisioner/v13/controller"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// ControllerConfig configures the provision controller, it is the controller
+// section of the config file. The provisioner command has a flag for every
+// setting, named in its comment. Changes take effect when the provisioner is
+// restarted.
+type ControllerConfig struct {
+	// --workers, the number of claims provisioned and of volumes deleted
+	// concurrently
+	Workers int `json:"workers,omitempty"`
+	// --resync-period, how often the informers hand all claims and volumes
+	// to the controller again, never when 0
+	ResyncPeriod metav1.Duration `json:"resyncPeriod,omitempty"`
+	// --controller-provision-timeout and --controller-deletion-timeout, the
+	// deadlines of the contexts claims are provisioned and volumes deleted
+	// with, no limit when 0
+	ProvisionTimeout metav1.Duration `json:"provisionTimeout,omitempty"`
+	DeletionTimeout  metav1.Duration `json:"deletionTimeout,omitempty"`
+	// --add-finalizer, whether provisioned volumes get a finalizer
+	AddFinalizer bool `json:"addFinalizer,omitempty"`
+	// --exit-on-panic
+	ExitOnPanic bool `json:"exitOnPanic,omitempty"`
+	// --metrics-port, --metrics-address and --metrics-path, where the
+	// metrics are served, disabled when the port is 0
+	MetricsPort    int    `json:"metricsPort,omitempty"`
+	MetricsAddress string `json:"metricsAddress,omitempty"`
+	MetricsPath    string `json:"metricsPath,omitempty"`
+	// LeaderElection configures the election among the replicas of a node
+	LeaderElection LeaderElectionConfig `json:"leaderElection,omitempty"`
+	// Retry is the policy failed provisionings and deletions are retried by
+	Retry RetryConfig `json:"retry,omitempty"`
+}
+
+// LeaderElectionConfig configures the leader election among the replicas of a
+// node.
+type LeaderElectionConfig struct {
+	// --leader-election
+	Enabled bool `json:"enabled,omitempty"`
+	// --leader-election-namespace, the provisioner's namespace when empty
+	Namespace string `json:"namespace,omitempty"`
+	// --leader-election-lease-duration, --leader-election-renew-deadline and
+	// --leader-election-retry-period
+	LeaseDuration metav1.Duration `json:"leaseDuration,omitempty"`
+	RenewDeadline metav1.Duration `json:"renewDeadline,omitempty"`
+	RetryPeriod   metav1.Duration `json:"retryPeriod,omitempty"`
+}
+
+// RetryConfig is the rate limiting of retries.
+type RetryConfig struct {
+	// --retry-initial-delay, --retry-max-delay and --retry-multiplier
+	InitialDelay metav1.Duration `json:"initialDelay,omitempty"`
+	MaxDelay     metav1.Duration `json:"maxDelay,omitempty"`
+	Multiplier   float64         `json:"multiplier,omitempty"`
+	// --retry-max-attempts, 0 retries forever
+	MaxAttempts int `json:"maxAttempts,omitempty"`
+	// --create-volume-attempts and --create-volume-interval, how often
+	// storing a provisioned volume is attempted
+	CreateVolumeAttempts int             `json:"createVolumeAttempts,omitempty"`
+	CreateVolumeInterval metav1.Duration `json:"createVolumeInterval,omitempty"`
+}
+
+// defaultControllerConfig returns the defaults of the controller settings,
+// those of their flags.
+func defaultControllerConfig() ControllerConfig {
+	return ControllerConfig{
+		Workers:        controller.DefaultThreadiness,
+		ResyncPeriod:   metav1.Duration{Duration: controller.DefaultResyncPeriod},
+		MetricsAddress: controller.DefaultMetricsAddress,
+		MetricsPath:    controller.DefaultMetricsPath,
+		LeaderElection: LeaderElectionConfig{
+			LeaseDuration: metav1.Duration{Duration: controller.DefaultLeaseDuration},
+			RenewDeadline: metav1.Duration{Duration: controller.DefaultRenewDeadline},
+			RetryPeriod:   metav1.Duration{Duration: controller.DefaultRetryPeriod},
+		},
+		Retry: RetryConfig{
+			InitialDelay:         metav1.Duration{Duration: defaultRetryInitialDelay},
+			MaxDelay:             metav1.Duration{Duration: defaultRetryMaxDelay},
+			Multiplier:           2,
+			MaxAttempts:          controller.DefaultFailedProvisionThreshold,
+			CreateVolumeAttempts: controller.DefaultCreateProvisionedPVRetryCount,
+			CreateVolumeInterval: metav1.Duration{Duration: controller.DefaultCreateProvisionedPVInterval},
+		},
+	}
+}
+
+// options returns the options of the provision controller. The leader
+// election, the metrics server and recovering from panics are left to the
+// provisionController.
+func (c *ControllerConfig) options() ([]func(*controller.ProvisionController) error, error) {
+	if c.ResyncPeriod.Duration < 0 || c.ProvisionTimeout.Duration < 0 || c.DeletionTimeout.Duration < 0 {
+		return nil, fmt.Errorf("invalid resync period %v or timeouts, provisioning %v and deletion %v, none may be negative", c.ResyncPeriod.Duration, c.ProvisionTimeout.Duration, c.DeletionTimeout.Duration)
+	}
+	options := []func(*controller.ProvisionController) error{
+		controller.ResyncPeriod(c.ResyncPeriod.Duration),
+		controller.ProvisionTimeout(c.ProvisionTimeout.Duration),
+		controller.DeletionTimeout(c.DeletionTimeout.Duration),
+		controller.AddFinalizer(c.AddFinalizer),
+	}
+	concurrency, err := workerOptions(c.Workers)
+	if err != nil {
+		return nil, err
+	}
+	options = append(options, concurrency...)
+	retry, err := retryOptions(c.Retry)
+	if err != nil {
+		return nil, err
+	}
+	return append(options, retry...), nil
+}
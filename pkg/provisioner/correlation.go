@@ -0,0 +1,90 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package provisioner
+
+import (
+	"fmt"
+	"sync"
+
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/sig-storage-lib-external-provisioner/v13/controller"
+)
+
+// annCorrelationID records the correlation ID of the operation that
+// provisioned a volume.
+const annCorrelationID = "kubevirt.io/correlationID"
+
+// attemptCounter hands out correlation IDs, which tie together the log
+// messages, events and PV of an attempt at provisioning or deleting the
+// volume of a claim. An ID is made of the claim's UID, the operation and the
+// number of the attempt, e.g. 5f3c...-41d2/provision/2, so that grepping the
+// logs of all nodes for the UID finds every attempt.
+type attemptCounter struct {
+	mutex    sync.Mutex
+	attempts map[string]int
+}
+
+func newAttemptCounter() *attemptCounter {
+	return &attemptCounter{attempts: make(map[string]int)}
+}
+
+// correlationID counts another attempt at op for the claim and returns its ID.
+func (c *attemptCounter) correlationID(op string, uid types.UID) string {
+	attempt := 1
+	if c != nil {
+		c.mutex.Lock()
+		c.attempts[op+"/"+string(uid)]++
+		attempt = c.attempts[op+"/"+string(uid)]
+		c.mutex.Unlock()
+	}
+	return fmt.Sprintf("%s/%s/%d", uid, op, attempt)
+}
+
+// forget drops the count of attempts at op once it has succeeded.
+func (c *attemptCounter) forget(op string, uid types.UID) {
+	if c == nil {
+		return
+	}
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	delete(c.attempts, op+"/"+string(uid))
+}
+
+// count returns the number of attempts at op for the claim since it last
+// succeeded.
+func (c *attemptCounter) count(op string, uid types.UID) int {
+	if c == nil {
+		return 0
+	}
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	return c.attempts[op+"/"+string(uid)]
+}
+
+// withCorrelationID adds the correlation ID to an error returned to the
+// controller, which puts it in the ProvisioningFailed or VolumeFailedDelete
+// event. Ignored errors are returned as they are, the controller checks their
+// type.
+func withCorrelationID(err error, id string) error {
+	if err == nil {
+		return nil
+	}
+	if _, ok := err.(*controller.IgnoredError); ok {
+		return err
+	}
+	return fmt.Errorf("%v (correlation ID %s)", err, id)
+}
@@ -0,0 +1,71 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package provisioner
+
+import (
+	"errors"
+	"testing"
+
+	"k8s.io/apimachinery/pkg/types"
+	"kubevirt.io/hostpath-provisioner/admin"
+	"sigs.k8s.io/sig-storage-lib-external-provisioner/v13/controller"
+)
+
+func Test_attemptCounter(t *testing.T) {
+	c := newAttemptCounter()
+	steps := []struct {
+		op     string
+		uid    string
+		forget bool
+		want   string
+	}{
+		{op: admin.OperationProvision, uid: "uid-1", want: "uid-1/provision/1"},
+		{op: admin.OperationProvision, uid: "uid-1", want: "uid-1/provision/2"},
+		{op: admin.OperationProvision, uid: "uid-2", want: "uid-2/provision/1"},
+		{op: admin.OperationDelete, uid: "uid-1", want: "uid-1/delete/1"},
+		{op: admin.OperationProvision, uid: "uid-1", forget: true},
+		{op: admin.OperationProvision, uid: "uid-1", want: "uid-1/provision/1"},
+	}
+	for _, step := range steps {
+		if step.forget {
+			c.forget(step.op, types.UID(step.uid))
+			continue
+		}
+		if got := c.correlationID(step.op, types.UID(step.uid)); got != step.want {
+			t.Errorf("correlationID(%s, %s) = %v, want %v", step.op, step.uid, got, step.want)
+		}
+	}
+
+	var unset *attemptCounter
+	if got := unset.correlationID(admin.OperationDelete, "uid-3"); got != "uid-3/delete/1" {
+		t.Errorf("correlationID() = %v on a nil counter", got)
+	}
+	unset.forget(admin.OperationDelete, "uid-3")
+}
+
+func Test_withCorrelationID(t *testing.T) {
+	if err := withCorrelationID(nil, "id"); err != nil {
+		t.Errorf("withCorrelationID(nil) = %v", err)
+	}
+	if err := withCorrelationID(errors.New("no space"), "uid-1/provision/1"); err == nil || err.Error() != "no space (correlation ID uid-1/provision/1)" {
+		t.Errorf("withCorrelationID() = %v", err)
+	}
+	ignored := &controller.IgnoredError{Reason: "not ours"}
+	if err := withCorrelationID(ignored, "id"); err != ignored {
+		t.Errorf("withCorrelationID() = %v, ignored errors must be returned as they are", err)
+	}
+}
@@ -0,0 +1,590 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package provisioner
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"os"
+	"strings"
+	"sync"
+
+	"github.com/container-storage-interface/spec/lib/go/csi"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/wrapperspb"
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/klog/v2"
+
+	"sigs.k8s.io/sig-storage-lib-external-provisioner/v13/controller"
+)
+
+const (
+	defaultCSIDriverName = "hostpath.csi.kubevirt.io"
+	// csiTopologyKey is the topology segment of the node a volume is on
+	csiTopologyKey = "topology.hostpath.kubevirt.io/node"
+	// csiPathKey is the volume context key of the backing directory, the
+	// other keys are the annotations a legacy PV of the volume would have
+	csiPathKey = "path"
+	// The parameters added by the external-provisioner with
+	// --extra-create-metadata
+	csiClaimNameKey      = "csi.storage.k8s.io/pvc/name"
+	csiClaimNamespaceKey = "csi.storage.k8s.io/pvc/namespace"
+)
+
+// csiDriver serves the CSI Identity, Controller and Node services for the
+// volumes of this node. Volumes are provisioned and deleted like those of the
+// legacy controller, their PVs are created by the external-provisioner
+// sidecar running with --node-deployment next to it.
+type csiDriver struct {
+	csi.UnimplementedIdentityServer
+	csi.UnimplementedControllerServer
+	csi.UnimplementedNodeServer
+
+	p    *HostPathProvisioner
+	name string
+
+	mutex sync.Mutex
+	// created are the volumes created so far by name, a retried CreateVolume
+	// gets the same volume
+	created map[string]*csi.Volume
+}
+
+func newCSIDriver(p *HostPathProvisioner, name string) *csiDriver {
+	return &csiDriver{p: p, name: name, created: make(map[string]*csi.Volume)}
+}
+
+// csiSocketPath returns the path of the unix socket of a CSI endpoint, e.g.
+// unix:///csi/csi.sock, only unix sockets are served.
+func csiSocketPath(endpoint string) (string, error) {
+	path := endpoint
+	if scheme, rest, ok := strings.Cut(endpoint, "://"); ok {
+		if scheme != "unix" {
+			return "", fmt.Errorf("unsupported CSI endpoint %q, expected a unix socket", endpoint)
+		}
+		path = rest
+	}
+	if !strings.HasPrefix(path, "/") {
+		return "", fmt.Errorf("invalid CSI endpoint %q, expected an absolute path", endpoint)
+	}
+	return path, nil
+}
+
+// serveCSI serves the CSI services of the driver on endpoint until stopCh is
+// closed.
+func serveCSI(endpoint string, driver *csiDriver, stopCh <-chan struct{}) error {
+	path, err := csiSocketPath(endpoint)
+	if err != nil {
+		return err
+	}
+	if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	listener, err := net.Listen("unix", path)
+	if err != nil {
+		return fmt.Errorf("unable to listen on CSI endpoint %s: %v", endpoint, err)
+	}
+	server := grpc.NewServer()
+	csi.RegisterIdentityServer(server, driver)
+	csi.RegisterControllerServer(server, driver)
+	csi.RegisterNodeServer(server, driver)
+	go func() {
+		<-stopCh
+		server.GracefulStop()
+	}()
+	klog.InfoS("Serving CSI driver", "driver", driver.name, "endpoint", endpoint, "node", driver.p.nodeName)
+	return server.Serve(listener)
+}
+
+// topology is the topology of the volumes of this node.
+func (d *csiDriver) topology() *csi.Topology {
+	return &csi.Topology{Segments: map[string]string{csiTopologyKey: d.p.nodeName}}
+}
+
+// accessibleFrom returns whether the volumes of the node satisfy the
+// topology requirement, the requisite topologies when there are any.
+func accessibleFrom(requirement *csi.TopologyRequirement, nodeName string) bool {
+	if requirement == nil {
+		return true
+	}
+	topologies := requirement.GetRequisite()
+	if len(topologies) == 0 {
+		topologies = requirement.GetPreferred()
+	}
+	if len(topologies) == 0 {
+		return true
+	}
+	for _, topology := range topologies {
+		if node, ok := topology.GetSegments()[csiTopologyKey]; ok && node == nodeName {
+			return true
+		}
+	}
+	return false
+}
+
+// checkCapabilities returns why the volumes can't be used with the
+// capabilities: they are directories on this node, mounted by a single node.
+func checkCapabilities(capabilities []*csi.VolumeCapability) error {
+	if len(capabilities) == 0 {
+		return fmt.Errorf("volume capabilities missing")
+	}
+	for _, capability := range capabilities {
+		if capability.GetBlock() != nil {
+			return fmt.Errorf("block volumes are not supported")
+		}
+		if capability.GetMount() == nil {
+			return fmt.Errorf("access type missing")
+		}
+		switch capability.GetAccessMode().GetMode() {
+		case csi.VolumeCapability_AccessMode_SINGLE_NODE_WRITER,
+			csi.VolumeCapability_AccessMode_SINGLE_NODE_READER_ONLY,
+			csi.VolumeCapability_AccessMode_SINGLE_NODE_SINGLE_WRITER,
+			csi.VolumeCapability_AccessMode_SINGLE_NODE_MULTI_WRITER:
+		default:
+			return fmt.Errorf("access mode %s is not supported, volumes are on a single node", capability.GetAccessMode().GetMode())
+		}
+	}
+	return nil
+}
+
+// volumeContext returns the volume context of the volume of a legacy PV: its
+// annotations and backing directory.
+func volumeContext(pv *v1.PersistentVolume) map[string]string {
+	context := make(map[string]string, len(pv.Annotations)+1)
+	for k, v := range pv.Annotations {
+		context[k] = v
+	}
+	context[csiPathKey] = backingPath(pv)
+	return context
+}
+
+// legacyVolume returns the PV the legacy controller would have created for
+// the volume of a CSI PV, which Delete works with.
+func legacyVolume(pv *v1.PersistentVolume) *v1.PersistentVolume {
+	attributes := pv.Spec.CSI.VolumeAttributes
+	legacy := &v1.PersistentVolume{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:              pv.Spec.CSI.VolumeHandle,
+			UID:               pv.UID,
+			CreationTimestamp: pv.CreationTimestamp,
+			Annotations:       make(map[string]string, len(attributes)),
+		},
+		Spec: v1.PersistentVolumeSpec{
+			Capacity:                      pv.Spec.Capacity,
+			AccessModes:                   pv.Spec.AccessModes,
+			ClaimRef:                      pv.Spec.ClaimRef,
+			PersistentVolumeReclaimPolicy: pv.Spec.PersistentVolumeReclaimPolicy,
+			PersistentVolumeSource: v1.PersistentVolumeSource{
+				HostPath: &v1.HostPathVolumeSource{Path: attributes[csiPathKey]},
+			},
+		},
+	}
+	for k, v := range attributes {
+		if k != csiPathKey {
+			legacy.Annotations[k] = v
+		}
+	}
+	return legacy
+}
+
+// findVolume returns the legacy PV of the CSI volume with the ID, nil if
+// there is no PV of the driver with it.
+func (d *csiDriver) findVolume(ctx context.Context, id string) (*v1.PersistentVolume, error) {
+	pvs, err := d.p.pvCache.list(ctx, d.p.client)
+	if err != nil {
+		return nil, err
+	}
+	for i := range pvs.Items {
+		source := pvs.Items[i].Spec.CSI
+		if source != nil && source.Driver == d.name && source.VolumeHandle == id {
+			return legacyVolume(&pvs.Items[i]), nil
+		}
+	}
+	return nil, nil
+}
+
+func (d *csiDriver) GetPluginInfo(ctx context.Context, req *csi.GetPluginInfoRequest) (*csi.GetPluginInfoResponse, error) {
+	return &csi.GetPluginInfoResponse{Name: d.name, VendorVersion: Version()}, nil
+}
+
+func (d *csiDriver) GetPluginCapabilities(ctx context.Context, req *csi.GetPluginCapabilitiesRequest) (*csi.GetPluginCapabilitiesResponse, error) {
+	service := func(t csi.PluginCapability_Service_Type) *csi.PluginCapability {
+		return &csi.PluginCapability{Type: &csi.PluginCapability_Service_{Service: &csi.PluginCapability_Service{Type: t}}}
+	}
+	return &csi.GetPluginCapabilitiesResponse{Capabilities: []*csi.PluginCapability{
+		service(csi.PluginCapability_Service_CONTROLLER_SERVICE),
+		service(csi.PluginCapability_Service_VOLUME_ACCESSIBILITY_CONSTRAINTS),
+		{Type: &csi.PluginCapability_VolumeExpansion_{VolumeExpansion: &csi.PluginCapability_VolumeExpansion{Type: csi.PluginCapability_VolumeExpansion_OFFLINE}}},
+	}}, nil
+}
+
+func (d *csiDriver) Probe(ctx context.Context, req *csi.ProbeRequest) (*csi.ProbeResponse, error) {
+	if err := d.p.ready(); err != nil {
+		klog.Warningf("CSI probe failed: %v", err)
+		return &csi.ProbeResponse{Ready: wrapperspb.Bool(false)}, nil
+	}
+	return &csi.ProbeResponse{Ready: wrapperspb.Bool(true)}, nil
+}
+
+// CreateVolume provisions a volume for the claim named in the parameters
+// added by the external-provisioner, like the legacy controller does.
+func (d *csiDriver) CreateVolume(ctx context.Context, req *csi.CreateVolumeRequest) (*csi.CreateVolumeResponse, error) {
+	if req.GetName() == "" {
+		return nil, status.Error(codes.InvalidArgument, "volume name missing")
+	}
+	if err := checkCapabilities(req.GetVolumeCapabilities()); err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+	if req.GetVolumeContentSource() != nil {
+		return nil, status.Error(codes.InvalidArgument, "volume content sources are not supported, clone with the kubevirt.io/cloneSource annotation of the claim")
+	}
+	if !d.p.isLeading() {
+		return nil, status.Errorf(codes.Unavailable, "the provisioner on node %s is not the leader", d.p.nodeName)
+	}
+	if !accessibleFrom(req.GetAccessibilityRequirements(), d.p.nodeName) {
+		return nil, status.Errorf(codes.ResourceExhausted, "volumes on node %s do not satisfy the topology requirement", d.p.nodeName)
+	}
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+	if volume, ok := d.created[req.GetName()]; ok {
+		return &csi.CreateVolumeResponse{Volume: volume}, nil
+	}
+
+	name, namespace := req.GetParameters()[csiClaimNameKey], req.GetParameters()[csiClaimNamespaceKey]
+	if name == "" || namespace == "" {
+		return nil, status.Errorf(codes.InvalidArgument, "parameters %s and %s missing, run the external-provisioner with --extra-create-metadata", csiClaimNameKey, csiClaimNamespaceKey)
+	}
+	pvc, err := d.p.client.CoreV1().PersistentVolumeClaims(namespace).Get(ctx, name, metav1.GetOptions{})
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "unable to get claim %s/%s: %v", namespace, name, err)
+	}
+	if isBlockClaim(pvc) {
+		return nil, status.Error(codes.InvalidArgument, "block volumes are not supported")
+	}
+	if pvc.Spec.StorageClassName == nil {
+		return nil, status.Errorf(codes.InvalidArgument, "claim %s/%s has no storage class", namespace, name)
+	}
+	class, err := d.p.client.StorageV1().StorageClasses().Get(ctx, *pvc.Spec.StorageClassName, metav1.GetOptions{})
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "unable to get storage class %s: %v", *pvc.Spec.StorageClassName, err)
+	}
+	if !d.p.shouldProvision(ctx, pvc, class.VolumeBindingMode) {
+		return nil, status.Errorf(codes.ResourceExhausted, "claim %s/%s can't be provisioned on node %s", namespace, name, d.p.nodeName)
+	}
+
+	options := controller.ProvisionOptions{
+		StorageClass:     class,
+		PVName:           req.GetName(),
+		PVC:              pvc,
+		SelectedNodeName: d.p.nodeName,
+	}
+	pv, _, err := d.p.Provision(ctx, options)
+	if err != nil {
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+	if pv.Spec.HostPath == nil {
+		// Device pools give whole devices, which are block volumes
+		err := fmt.Errorf("claim %s/%s was given a device, only directories are supported", namespace, name)
+		d.p.removeUnusedVolume(ctx, options, pv, "", err)
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+	capacity := pv.Spec.Capacity[v1.ResourceStorage]
+	volume := &csi.Volume{
+		VolumeId:           req.GetName(),
+		CapacityBytes:      capacity.Value(),
+		VolumeContext:      volumeContext(pv),
+		AccessibleTopology: []*csi.Topology{d.topology()},
+	}
+	// The external-provisioner deletes the volume when its PV can't be
+	// created, the volume is recorded as stored right away
+	d.p.VolumeStored(pv)
+	d.created[req.GetName()] = volume
+	return &csi.CreateVolumeResponse{Volume: volume}, nil
+}
+
+// DeleteVolume removes the backing directory of a volume, volumes without a
+// PV are already deleted.
+func (d *csiDriver) DeleteVolume(ctx context.Context, req *csi.DeleteVolumeRequest) (*csi.DeleteVolumeResponse, error) {
+	if req.GetVolumeId() == "" {
+		return nil, status.Error(codes.InvalidArgument, "volume ID missing")
+	}
+	if !d.p.isLeading() {
+		return nil, status.Errorf(codes.Unavailable, "the provisioner on node %s is not the leader", d.p.nodeName)
+	}
+	pv, err := d.findVolume(ctx, req.GetVolumeId())
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "unable to list persistent volumes: %v", err)
+	}
+	if pv == nil {
+		klog.InfoS("Volume to delete has no persistent volume, nothing to do", "volume", req.GetVolumeId(), "node", d.p.nodeName)
+		return &csi.DeleteVolumeResponse{}, nil
+	}
+	if err := d.p.Delete(ctx, pv); err != nil {
+		if _, ok := err.(*controller.IgnoredError); ok {
+			return nil, status.Error(codes.FailedPrecondition, err.Error())
+		}
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+	d.mutex.Lock()
+	delete(d.created, req.GetVolumeId())
+	d.mutex.Unlock()
+	return &csi.DeleteVolumeResponse{}, nil
+}
+
+// expandable returns whether the capacity of the volume can be changed:
+// that of plain directories is not enforced, only recorded.
+func expandable(pv *v1.PersistentVolume) bool {
+	for _, annotation := range []string{annLVMVolume, annImageFile, annStripes, annTmpfsSize, annPreallocation, annDevice} {
+		if pv.Annotations[annotation] != "" {
+			return false
+		}
+	}
+	return true
+}
+
+// ControllerExpandVolume records the new capacity of a plain directory
+// volume, if its pool can hold it.
+func (d *csiDriver) ControllerExpandVolume(ctx context.Context, req *csi.ControllerExpandVolumeRequest) (*csi.ControllerExpandVolumeResponse, error) {
+	if req.GetVolumeId() == "" {
+		return nil, status.Error(codes.InvalidArgument, "volume ID missing")
+	}
+	pv, err := d.findVolume(ctx, req.GetVolumeId())
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "unable to list persistent volumes: %v", err)
+	}
+	if pv == nil {
+		return nil, status.Errorf(codes.NotFound, "volume %s not found", req.GetVolumeId())
+	}
+	if !expandable(pv) {
+		return nil, status.Errorf(codes.InvalidArgument, "volume %s has a fixed size, only plain directories can be expanded", req.GetVolumeId())
+	}
+	size := req.GetCapacityRange().GetRequiredBytes()
+	current := pv.Spec.Capacity[v1.ResourceStorage]
+	if growth := size - current.Value(); growth > 0 {
+		if pool := d.p.poolNamed(pv.Annotations[annStoragePool]); pool != nil {
+			free, err := poolFree(d.p.host, pool)
+			if err != nil {
+				return nil, status.Errorf(codes.Internal, "unable to determine the free space of pool %s: %v", pool.name, err)
+			}
+			if free < growth {
+				return nil, status.Errorf(codes.OutOfRange, "pool %s has %d bytes free, the volume grows by %d", pool.name, free, growth)
+			}
+		}
+	}
+	pv.Annotations[annRequestedCapacity] = resource.NewQuantity(size, resource.BinarySI).String()
+	d.p.allocations.add(allocationOf(pv))
+	return &csi.ControllerExpandVolumeResponse{CapacityBytes: size}, nil
+}
+
+// poolNamed returns the storage pool with the name, nil if there is none.
+func (p *HostPathProvisioner) poolNamed(name string) *storagePool {
+	if name == "" {
+		name = defaultPoolName
+	}
+	for _, pool := range p.currentPools() {
+		if pool.name == name {
+			return pool
+		}
+	}
+	return nil
+}
+
+// GetCapacity returns the space free in the directory pools of the node.
+func (d *csiDriver) GetCapacity(ctx context.Context, req *csi.GetCapacityRequest) (*csi.GetCapacityResponse, error) {
+	if topology := req.GetAccessibleTopology(); topology != nil {
+		if node, ok := topology.GetSegments()[csiTopologyKey]; ok && node != d.p.nodeName {
+			return &csi.GetCapacityResponse{}, nil
+		}
+	}
+	var available int64
+	for _, pool := range d.p.currentPools() {
+		if pool.devices != nil {
+			continue
+		}
+		free, err := poolFree(d.p.host, pool)
+		if err != nil {
+			return nil, status.Errorf(codes.Internal, "unable to determine the free space of pool %s: %v", pool.name, err)
+		}
+		available += free
+	}
+	return &csi.GetCapacityResponse{AvailableCapacity: available}, nil
+}
+
+func (d *csiDriver) ValidateVolumeCapabilities(ctx context.Context, req *csi.ValidateVolumeCapabilitiesRequest) (*csi.ValidateVolumeCapabilitiesResponse, error) {
+	if req.GetVolumeId() == "" {
+		return nil, status.Error(codes.InvalidArgument, "volume ID missing")
+	}
+	pv, err := d.findVolume(ctx, req.GetVolumeId())
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "unable to list persistent volumes: %v", err)
+	}
+	if pv == nil {
+		return nil, status.Errorf(codes.NotFound, "volume %s not found", req.GetVolumeId())
+	}
+	if err := checkCapabilities(req.GetVolumeCapabilities()); err != nil {
+		return &csi.ValidateVolumeCapabilitiesResponse{Message: err.Error()}, nil
+	}
+	return &csi.ValidateVolumeCapabilitiesResponse{Confirmed: &csi.ValidateVolumeCapabilitiesResponse_Confirmed{
+		VolumeContext:      req.GetVolumeContext(),
+		VolumeCapabilities: req.GetVolumeCapabilities(),
+		Parameters:         req.GetParameters(),
+	}}, nil
+}
+
+func (d *csiDriver) ControllerGetCapabilities(ctx context.Context, req *csi.ControllerGetCapabilitiesRequest) (*csi.ControllerGetCapabilitiesResponse, error) {
+	var capabilities []*csi.ControllerServiceCapability
+	for _, t := range []csi.ControllerServiceCapability_RPC_Type{
+		csi.ControllerServiceCapability_RPC_CREATE_DELETE_VOLUME,
+		csi.ControllerServiceCapability_RPC_GET_CAPACITY,
+		csi.ControllerServiceCapability_RPC_EXPAND_VOLUME,
+	} {
+		capabilities = append(capabilities, &csi.ControllerServiceCapability{
+			Type: &csi.ControllerServiceCapability_Rpc{Rpc: &csi.ControllerServiceCapability_RPC{Type: t}},
+		})
+	}
+	return &csi.ControllerGetCapabilitiesResponse{Capabilities: capabilities}, nil
+}
+
+func (d *csiDriver) NodeGetInfo(ctx context.Context, req *csi.NodeGetInfoRequest) (*csi.NodeGetInfoResponse, error) {
+	return &csi.NodeGetInfoResponse{NodeId: d.p.nodeName, AccessibleTopology: d.topology()}, nil
+}
+
+func (d *csiDriver) NodeGetCapabilities(ctx context.Context, req *csi.NodeGetCapabilitiesRequest) (*csi.NodeGetCapabilitiesResponse, error) {
+	var capabilities []*csi.NodeServiceCapability
+	for _, t := range []csi.NodeServiceCapability_RPC_Type{
+		csi.NodeServiceCapability_RPC_STAGE_UNSTAGE_VOLUME,
+		csi.NodeServiceCapability_RPC_GET_VOLUME_STATS,
+	} {
+		capabilities = append(capabilities, &csi.NodeServiceCapability{
+			Type: &csi.NodeServiceCapability_Rpc{Rpc: &csi.NodeServiceCapability_RPC{Type: t}},
+		})
+	}
+	return &csi.NodeGetCapabilitiesResponse{Capabilities: capabilities}, nil
+}
+
+// NodeStageVolume bind mounts the backing directory at the staging path, the
+// pods of the node using the volume share it.
+func (d *csiDriver) NodeStageVolume(ctx context.Context, req *csi.NodeStageVolumeRequest) (*csi.NodeStageVolumeResponse, error) {
+	path := req.GetVolumeContext()[csiPathKey]
+	if req.GetVolumeId() == "" || req.GetStagingTargetPath() == "" || path == "" {
+		return nil, status.Error(codes.InvalidArgument, "volume ID, staging path or backing directory missing")
+	}
+	if err := checkCapabilities([]*csi.VolumeCapability{req.GetVolumeCapability()}); err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+	if _, err := os.Stat(path); os.IsNotExist(err) {
+		return nil, status.Errorf(codes.NotFound, "backing directory %s of volume %s not found", path, req.GetVolumeId())
+	}
+	if err := bindMount(path, req.GetStagingTargetPath(), false); err != nil {
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+	return &csi.NodeStageVolumeResponse{}, nil
+}
+
+func (d *csiDriver) NodeUnstageVolume(ctx context.Context, req *csi.NodeUnstageVolumeRequest) (*csi.NodeUnstageVolumeResponse, error) {
+	if req.GetVolumeId() == "" || req.GetStagingTargetPath() == "" {
+		return nil, status.Error(codes.InvalidArgument, "volume ID or staging path missing")
+	}
+	if err := unmount(req.GetStagingTargetPath()); err != nil {
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+	return &csi.NodeUnstageVolumeResponse{}, nil
+}
+
+// NodePublishVolume bind mounts the staged volume at the target path of a
+// pod, read-only if requested.
+func (d *csiDriver) NodePublishVolume(ctx context.Context, req *csi.NodePublishVolumeRequest) (*csi.NodePublishVolumeResponse, error) {
+	if req.GetVolumeId() == "" || req.GetStagingTargetPath() == "" || req.GetTargetPath() == "" {
+		return nil, status.Error(codes.InvalidArgument, "volume ID, staging path or target path missing")
+	}
+	if err := checkCapabilities([]*csi.VolumeCapability{req.GetVolumeCapability()}); err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+	if !isMountPoint(req.GetStagingTargetPath()) {
+		return nil, status.Errorf(codes.FailedPrecondition, "volume %s is not staged at %s", req.GetVolumeId(), req.GetStagingTargetPath())
+	}
+	if err := bindMount(req.GetStagingTargetPath(), req.GetTargetPath(), req.GetReadonly()); err != nil {
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+	return &csi.NodePublishVolumeResponse{}, nil
+}
+
+func (d *csiDriver) NodeUnpublishVolume(ctx context.Context, req *csi.NodeUnpublishVolumeRequest) (*csi.NodeUnpublishVolumeResponse, error) {
+	if req.GetVolumeId() == "" || req.GetTargetPath() == "" {
+		return nil, status.Error(codes.InvalidArgument, "volume ID or target path missing")
+	}
+	if err := unmount(req.GetTargetPath()); err != nil {
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+	if err := os.Remove(req.GetTargetPath()); err != nil && !os.IsNotExist(err) {
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+	return &csi.NodeUnpublishVolumeResponse{}, nil
+}
+
+// NodeGetVolumeStats returns the stats of the filesystem the volume is on,
+// the space of a plain directory is shared with its pool.
+func (d *csiDriver) NodeGetVolumeStats(ctx context.Context, req *csi.NodeGetVolumeStatsRequest) (*csi.NodeGetVolumeStatsResponse, error) {
+	if req.GetVolumeId() == "" || req.GetVolumePath() == "" {
+		return nil, status.Error(codes.InvalidArgument, "volume ID or volume path missing")
+	}
+	if _, err := os.Stat(req.GetVolumePath()); os.IsNotExist(err) {
+		return nil, status.Errorf(codes.NotFound, "volume path %s not found", req.GetVolumePath())
+	}
+	stats, err := d.p.host.Stat(req.GetVolumePath())
+	if err != nil {
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+	return &csi.NodeGetVolumeStatsResponse{Usage: []*csi.VolumeUsage{
+		{Unit: csi.VolumeUsage_BYTES, Total: stats.capacity, Available: stats.available, Used: stats.capacity - stats.free},
+		{Unit: csi.VolumeUsage_INODES, Total: stats.inodes, Available: stats.inodesFree, Used: stats.inodes - stats.inodesFree},
+	}}, nil
+}
+
+// bindMount bind mounts source at target, creating target, unless something
+// is mounted there already.
+func bindMount(source, target string, readOnly bool) error {
+	if err := os.MkdirAll(target, 0750); err != nil {
+		return err
+	}
+	if isMountPoint(target) {
+		return nil
+	}
+	if _, err := runCommand("mount", "--bind", source, target); err != nil {
+		return err
+	}
+	if readOnly {
+		if _, err := runCommand("mount", "-o", "remount,bind,ro", target); err != nil {
+			runCommand("umount", target)
+			return err
+		}
+	}
+	return nil
+}
+
+// unmount unmounts target if something is mounted there.
+func unmount(target string) error {
+	if !isMountPoint(target) {
+		return nil
+	}
+	_, err := runCommand("umount", target)
+	return err
+}
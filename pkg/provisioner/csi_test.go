@@ -0,0 +1,263 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package provisioner
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/container-storage-interface/spec/lib/go/csi"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func Test_csiSocketPath(t *testing.T) {
+	tests := []struct {
+		endpoint string
+		want     string
+		wantErr  bool
+	}{
+		{endpoint: "unix:///csi/csi.sock", want: "/csi/csi.sock"},
+		{endpoint: "/csi/csi.sock", want: "/csi/csi.sock"},
+		{endpoint: "tcp://127.0.0.1:10000", wantErr: true},
+		{endpoint: "unix://csi.sock", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.endpoint, func(t *testing.T) {
+			got, err := csiSocketPath(tt.endpoint)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("csiSocketPath() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("csiSocketPath() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_accessibleFrom(t *testing.T) {
+	node := func(name string) *csi.Topology {
+		return &csi.Topology{Segments: map[string]string{csiTopologyKey: name}}
+	}
+	tests := []struct {
+		name        string
+		requirement *csi.TopologyRequirement
+		want        bool
+	}{
+		{name: "no requirement", want: true},
+		{name: "requisite node", requirement: &csi.TopologyRequirement{Requisite: []*csi.Topology{node("node02"), node("node01")}}, want: true},
+		{name: "other requisite node", requirement: &csi.TopologyRequirement{Requisite: []*csi.Topology{node("node02")}, Preferred: []*csi.Topology{node("node01")}}},
+		{name: "preferred node", requirement: &csi.TopologyRequirement{Preferred: []*csi.Topology{node("node01")}}, want: true},
+		{name: "other segment", requirement: &csi.TopologyRequirement{Requisite: []*csi.Topology{{Segments: map[string]string{"zone": "a"}}}}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := accessibleFrom(tt.requirement, "node01"); got != tt.want {
+				t.Errorf("accessibleFrom() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_checkCapabilities(t *testing.T) {
+	capability := func(mode csi.VolumeCapability_AccessMode_Mode, block bool) *csi.VolumeCapability {
+		c := &csi.VolumeCapability{AccessMode: &csi.VolumeCapability_AccessMode{Mode: mode}}
+		if block {
+			c.AccessType = &csi.VolumeCapability_Block{Block: &csi.VolumeCapability_BlockVolume{}}
+		} else {
+			c.AccessType = &csi.VolumeCapability_Mount{Mount: &csi.VolumeCapability_MountVolume{}}
+		}
+		return c
+	}
+	tests := []struct {
+		name         string
+		capabilities []*csi.VolumeCapability
+		wantErr      bool
+	}{
+		{name: "single node writer", capabilities: []*csi.VolumeCapability{capability(csi.VolumeCapability_AccessMode_SINGLE_NODE_WRITER, false)}},
+		{name: "single node reader", capabilities: []*csi.VolumeCapability{capability(csi.VolumeCapability_AccessMode_SINGLE_NODE_READER_ONLY, false)}},
+		{name: "multi node", capabilities: []*csi.VolumeCapability{capability(csi.VolumeCapability_AccessMode_MULTI_NODE_MULTI_WRITER, false)}, wantErr: true},
+		{name: "block", capabilities: []*csi.VolumeCapability{capability(csi.VolumeCapability_AccessMode_SINGLE_NODE_WRITER, true)}, wantErr: true},
+		{name: "none", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := checkCapabilities(tt.capabilities); (err != nil) != tt.wantErr {
+				t.Errorf("checkCapabilities() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+// csiVolume returns the PV the external-provisioner creates for the volume
+// of the legacy PV.
+func csiVolume(driver string, pv *v1.PersistentVolume) *v1.PersistentVolume {
+	return &v1.PersistentVolume{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:              pv.Name,
+			UID:               pv.UID,
+			CreationTimestamp: pv.CreationTimestamp,
+			Annotations:       map[string]string{"pv.kubernetes.io/provisioned-by": driver},
+		},
+		Spec: v1.PersistentVolumeSpec{
+			Capacity:                      pv.Spec.Capacity,
+			AccessModes:                   pv.Spec.AccessModes,
+			PersistentVolumeReclaimPolicy: pv.Spec.PersistentVolumeReclaimPolicy,
+			ClaimRef:                      pv.Spec.ClaimRef,
+			PersistentVolumeSource: v1.PersistentVolumeSource{
+				CSI: &v1.CSIPersistentVolumeSource{
+					Driver:           driver,
+					VolumeHandle:     pv.Name,
+					VolumeAttributes: volumeContext(pv),
+				},
+			},
+		},
+	}
+}
+
+func Test_legacyVolume(t *testing.T) {
+	pv := createPv("testId", "testNode", "/var/hpvolumes/pv")
+	pv.Annotations[annStoragePool] = "ssd"
+	legacy := legacyVolume(csiVolume(defaultCSIDriverName, pv))
+	if legacy.Name != pv.Name {
+		t.Errorf("legacyVolume() name = %q, want %q", legacy.Name, pv.Name)
+	}
+	if !reflect.DeepEqual(legacy.Annotations, pv.Annotations) {
+		t.Errorf("legacyVolume() annotations = %v, want %v", legacy.Annotations, pv.Annotations)
+	}
+	if got := backingPath(legacy); got != "/var/hpvolumes/pv" {
+		t.Errorf("legacyVolume() backing path = %q, want /var/hpvolumes/pv", got)
+	}
+	if !expandable(legacy) {
+		t.Errorf("expandable() = false for a plain directory")
+	}
+	legacy.Annotations[annImageFile] = "/var/hpvolumes/pv.img"
+	if expandable(legacy) {
+		t.Errorf("expandable() = true for an image backed volume")
+	}
+}
+
+func Test_csiDriver_DeleteVolume(t *testing.T) {
+	dir, err := os.MkdirTemp("", "csi")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "pv")
+	if err := os.Mkdir(path, 0750); err != nil {
+		t.Fatal(err)
+	}
+	// A volume of another driver with the same handle
+	other := csiVolume("other.csi.k8s.io", createPv("testId", "testNode", dir))
+	other.Name = "other"
+	client := fake.NewSimpleClientset(csiVolume(defaultCSIDriverName, createPv("testId", "testNode", path)), other)
+	p := &HostPathProvisioner{client: client, host: hostBackend(), nodeName: "testNode", identity: "testId", leading: make(chan struct{})}
+	driver := newCSIDriver(p, defaultCSIDriverName)
+
+	// Standby replicas leave the volume to the leader
+	_, err = driver.DeleteVolume(context.TODO(), &csi.DeleteVolumeRequest{VolumeId: "pv"})
+	if status.Code(err) != codes.Unavailable {
+		t.Errorf("DeleteVolume() before taking over, error = %v, want Unavailable", err)
+	}
+	if _, err := os.Stat(path); err != nil {
+		t.Errorf("backing directory removed before taking over: %v", err)
+	}
+	close(p.leading)
+	if _, err := driver.DeleteVolume(context.TODO(), &csi.DeleteVolumeRequest{VolumeId: "pv"}); err != nil {
+		t.Fatalf("DeleteVolume() error = %v", err)
+	}
+	if _, err := os.Stat(path); !os.IsNotExist(err) {
+		t.Errorf("backing directory not removed, stat error = %v", err)
+	}
+	if _, err := os.Stat(dir); err != nil {
+		t.Errorf("directory of the other driver's volume removed: %v", err)
+	}
+	if _, err := driver.DeleteVolume(context.TODO(), &csi.DeleteVolumeRequest{VolumeId: "unknown"}); err != nil {
+		t.Errorf("DeleteVolume() of a volume without PV, error = %v", err)
+	}
+	_, err = driver.DeleteVolume(context.TODO(), &csi.DeleteVolumeRequest{})
+	if status.Code(err) != codes.InvalidArgument {
+		t.Errorf("DeleteVolume() without ID, error = %v, want InvalidArgument", err)
+	}
+}
+
+func Test_csiDriver_publish(t *testing.T) {
+	dir, err := os.MkdirTemp("", "csi")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	backing, staging, target := filepath.Join(dir, "pv"), filepath.Join(dir, "staging"), filepath.Join(dir, "target")
+	if err := os.Mkdir(backing, 0750); err != nil {
+		t.Fatal(err)
+	}
+	capability := &csi.VolumeCapability{
+		AccessType: &csi.VolumeCapability_Mount{Mount: &csi.VolumeCapability_MountVolume{}},
+		AccessMode: &csi.VolumeCapability_AccessMode{Mode: csi.VolumeCapability_AccessMode_SINGLE_NODE_WRITER},
+	}
+	commands, restore := fakeCommands(map[string]string{
+		"mount --bind " + backing + " " + staging: "",
+	})
+	defer restore()
+	driver := newCSIDriver(&HostPathProvisioner{nodeName: "testNode"}, defaultCSIDriverName)
+
+	_, err = driver.NodeStageVolume(context.TODO(), &csi.NodeStageVolumeRequest{
+		VolumeId:          "pv",
+		StagingTargetPath: staging,
+		VolumeCapability:  capability,
+		VolumeContext:     map[string]string{csiPathKey: backing},
+	})
+	if err != nil {
+		t.Fatalf("NodeStageVolume() error = %v", err)
+	}
+	if want := []string{"mount --bind " + backing + " " + staging}; !reflect.DeepEqual(*commands, want) {
+		t.Errorf("NodeStageVolume() ran %v, want %v", *commands, want)
+	}
+	if _, err := os.Stat(staging); err != nil {
+		t.Errorf("staging path not created: %v", err)
+	}
+	// Nothing is mounted at the staging path in the test
+	_, err = driver.NodePublishVolume(context.TODO(), &csi.NodePublishVolumeRequest{
+		VolumeId:          "pv",
+		StagingTargetPath: staging,
+		TargetPath:        target,
+		VolumeCapability:  capability,
+	})
+	if status.Code(err) != codes.FailedPrecondition {
+		t.Errorf("NodePublishVolume() of an unstaged volume, error = %v, want FailedPrecondition", err)
+	}
+	if _, err := driver.NodeUnpublishVolume(context.TODO(), &csi.NodeUnpublishVolumeRequest{VolumeId: "pv", TargetPath: target}); err != nil {
+		t.Errorf("NodeUnpublishVolume() of an unpublished volume, error = %v", err)
+	}
+}
+
+func Test_csiDriver_NodeGetInfo(t *testing.T) {
+	driver := newCSIDriver(&HostPathProvisioner{nodeName: "testNode"}, defaultCSIDriverName)
+	info, err := driver.NodeGetInfo(context.TODO(), &csi.NodeGetInfoRequest{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.NodeId != "testNode" || info.AccessibleTopology.Segments[csiTopologyKey] != "testNode" {
+		t.Errorf("NodeGetInfo() = %v, want node testNode", info)
+	}
+}
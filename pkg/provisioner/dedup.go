@@ -0,0 +1,262 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package provisioner
+
+import (
+	"fmt"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"k8s.io/klog/v2"
+
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/client-go/tools/record"
+)
+
+const (
+	// dedupZFS turns on the deduplication of the ZFS dataset of a pool.
+	dedupZFS = "zfs"
+	// dedupDuperemove runs duperemove over a pool on btrfs or XFS, sharing
+	// the identical extents of the files in it.
+	dedupDuperemove = "duperemove"
+
+	defaultDedupInterval = 24 * time.Hour
+	// dedupCheckInterval is how often pools are checked for being due
+	dedupCheckInterval = time.Minute
+	// dedupHashFile is the file in the pool duperemove keeps the hashes of
+	// extents in, so that later runs only read what changed
+	dedupHashFile = ".duperemove.hash"
+)
+
+// poolDedup is how, and how often, a pool is deduplicated.
+type poolDedup struct {
+	// mode is dedupZFS or dedupDuperemove, empty for pools that are not
+	// deduplicated
+	mode     string
+	interval time.Duration
+}
+
+// newPoolDedup returns the deduplication of a pool, the interval defaults to
+// a day.
+func newPoolDedup(mode string, interval time.Duration) (poolDedup, error) {
+	switch mode {
+	case "":
+		return poolDedup{}, nil
+	case dedupZFS, dedupDuperemove:
+	default:
+		return poolDedup{}, fmt.Errorf("invalid dedup %q, expected %s or %s", mode, dedupZFS, dedupDuperemove)
+	}
+	if interval < 0 {
+		return poolDedup{}, fmt.Errorf("invalid dedup interval %v", interval)
+	}
+	if interval == 0 {
+		interval = defaultDedupInterval
+	}
+	return poolDedup{mode: mode, interval: interval}, nil
+}
+
+// dedupRunner deduplicates the pools asking for it, every interval of the
+// pool, one run per pool at a time, and reports the runs as node events and
+// metrics.
+type dedupRunner struct {
+	mountsPath string
+	// duperemove is the duperemove binary of the configuration
+	duperemove    string
+	nodeRef       *v1.ObjectReference
+	eventRecorder record.EventRecorder
+
+	runs     *prometheus.CounterVec
+	lastRun  *prometheus.GaugeVec
+	duration *prometheus.GaugeVec
+	ratio    *prometheus.GaugeVec
+
+	mutex sync.Mutex
+	// last is when the last run of a pool started, the first run is one
+	// interval after the pool was first seen, not at every start up
+	last    map[string]time.Time
+	running map[string]bool
+	// capabilities tells whether the filesystems of pools support reflinks
+	capabilities *capabilityProber
+}
+
+var _ prometheus.Collector = &dedupRunner{}
+
+func newDedupRunner(mountsPath, nodeName, duperemove string, eventRecorder record.EventRecorder, capabilities *capabilityProber) *dedupRunner {
+	return &dedupRunner{
+		mountsPath:   mountsPath,
+		duperemove:   duperemove,
+		capabilities: capabilities,
+		nodeRef: &v1.ObjectReference{
+			Kind: "Node",
+			Name: nodeName,
+			UID:  types.UID(nodeName),
+		},
+		eventRecorder: eventRecorder,
+		runs: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: metricsNamespace,
+			Name:      "pool_dedup_runs_total",
+			Help:      "Deduplication runs of the pool by result.",
+		}, []string{"pool", "result"}),
+		lastRun: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Namespace: metricsNamespace,
+			Name:      "pool_dedup_last_success_timestamp_seconds",
+			Help:      "When the last successful deduplication run of the pool finished.",
+		}, []string{"pool"}),
+		duration: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Namespace: metricsNamespace,
+			Name:      "pool_dedup_last_duration_seconds",
+			Help:      "How long the last deduplication run of the pool took.",
+		}, []string{"pool"}),
+		ratio: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Namespace: metricsNamespace,
+			Name:      "pool_dedup_ratio",
+			Help:      "Deduplication ratio of the ZFS pool holding the pool.",
+		}, []string{"pool"}),
+		last:    make(map[string]time.Time),
+		running: make(map[string]bool),
+	}
+}
+
+// Describe implements prometheus.Collector.
+func (r *dedupRunner) Describe(ch chan<- *prometheus.Desc) {
+	r.runs.Describe(ch)
+	r.lastRun.Describe(ch)
+	r.duration.Describe(ch)
+	r.ratio.Describe(ch)
+}
+
+// Collect implements prometheus.Collector.
+func (r *dedupRunner) Collect(ch chan<- prometheus.Metric) {
+	r.runs.Collect(ch)
+	r.lastRun.Collect(ch)
+	r.duration.Collect(ch)
+	r.ratio.Collect(ch)
+}
+
+// Run starts the runs of the pools that are due until stopCh is closed.
+func (r *dedupRunner) Run(pools func() []*storagePool, stopCh <-chan struct{}) {
+	wait.Until(func() {
+		for _, pool := range pools() {
+			if r.due(pool, time.Now()) {
+				go r.run(pool)
+			}
+		}
+	}, dedupCheckInterval, stopCh)
+}
+
+// due returns whether the pool is to be deduplicated now, and marks it as
+// running if so.
+func (r *dedupRunner) due(pool *storagePool, now time.Time) bool {
+	if pool.dedup.mode == "" {
+		return false
+	}
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	last, ok := r.last[pool.name]
+	if !ok {
+		r.last[pool.name] = now
+		return false
+	}
+	if r.running[pool.name] || now.Sub(last) < pool.dedup.interval {
+		return false
+	}
+	r.last[pool.name] = now
+	r.running[pool.name] = true
+	return true
+}
+
+func (r *dedupRunner) run(pool *storagePool) {
+	defer func() {
+		r.mutex.Lock()
+		defer r.mutex.Unlock()
+		delete(r.running, pool.name)
+	}()
+	klog.InfoS("Deduplicating pool", "node", r.nodeRef.Name, "pool", pool.name, "path", pool.path, "mode", pool.dedup.mode)
+	start := time.Now()
+	ratio, err := r.dedupe(pool)
+	duration := time.Since(start)
+	r.duration.WithLabelValues(pool.name).Set(duration.Seconds())
+	if err != nil {
+		klog.ErrorS(err, "Failed to deduplicate pool", "node", r.nodeRef.Name, "pool", pool.name, "path", pool.path)
+		r.runs.WithLabelValues(pool.name, "failure").Inc()
+		r.eventRecorder.Eventf(r.nodeRef, v1.EventTypeWarning, "PoolDedupFailed", "Deduplication of hostpath pool %s failed: %v", pool.name, err)
+		return
+	}
+	r.runs.WithLabelValues(pool.name, "success").Inc()
+	r.lastRun.WithLabelValues(pool.name).Set(float64(time.Now().Unix()))
+	message := fmt.Sprintf("Deduplicated hostpath pool %s in %v", pool.name, duration.Round(time.Second))
+	if ratio > 0 {
+		r.ratio.WithLabelValues(pool.name).Set(ratio)
+		message += fmt.Sprintf(", the deduplication ratio is %.2f", ratio)
+	}
+	klog.InfoS("Deduplicated pool", "node", r.nodeRef.Name, "pool", pool.name, "duration", duration, "ratio", ratio)
+	r.eventRecorder.Event(r.nodeRef, v1.EventTypeNormal, "PoolDeduplicated", message)
+}
+
+// dedupe deduplicates the pool and returns the deduplication ratio of ZFS.
+// ZFS deduplicates as data is written once dedup is on, the run turns it on,
+// in case the dataset changed, and reads the ratio.
+func (r *dedupRunner) dedupe(pool *storagePool) (float64, error) {
+	mounts, err := readMounts(r.mountsPath)
+	if err != nil {
+		return 0, err
+	}
+	mount := findMount(mounts, filepath.Clean(pool.path))
+	if mount == nil {
+		return 0, fmt.Errorf("unable to find the mount of pool %s", pool.name)
+	}
+	switch pool.dedup.mode {
+	case dedupZFS:
+		if mount.fsType != "zfs" {
+			return 0, fmt.Errorf("pool %s is on %s, not ZFS", pool.name, mount.fsType)
+		}
+		if _, err := runCommand("zfs", "set", "dedup=on", mount.device); err != nil {
+			return 0, err
+		}
+		zpool := strings.SplitN(mount.device, "/", 2)[0]
+		output, err := runCommand("zpool", "get", "-Hp", "-o", "value", "dedupratio", zpool)
+		if err != nil {
+			return 0, err
+		}
+		return parseDedupRatio(string(output))
+	default:
+		if mount.fsType != "btrfs" && mount.fsType != "xfs" {
+			return 0, fmt.Errorf("pool %s is on %s, duperemove needs btrfs or XFS", pool.name, mount.fsType)
+		}
+		if err := r.capabilities.require(pool, capabilityReflink, "deduplication with duperemove"); err != nil {
+			return 0, err
+		}
+		_, err := runCommand(r.duperemove, "-dr", "--hashfile="+filepath.Join(pool.path, dedupHashFile), pool.path)
+		return 0, err
+	}
+}
+
+// parseDedupRatio parses the dedupratio property of a ZFS pool, e.g. 1.52x.
+func parseDedupRatio(value string) (float64, error) {
+	ratio, err := strconv.ParseFloat(strings.TrimSuffix(strings.TrimSpace(value), "x"), 64)
+	if err != nil {
+		klog.V(3).Infof("unexpected dedupratio %q", value)
+		return 0, fmt.Errorf("unable to parse the dedup ratio %q: %v", value, err)
+	}
+	return ratio, nil
+}
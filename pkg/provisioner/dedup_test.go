@@ -0,0 +1,158 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package provisioner
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"k8s.io/client-go/tools/record"
+)
+
+func Test_newPoolDedup(t *testing.T) {
+	tests := []struct {
+		name     string
+		mode     string
+		interval time.Duration
+		want     poolDedup
+		wantErr  bool
+	}{
+		{name: "off"},
+		{name: "zfs", mode: "zfs", want: poolDedup{mode: dedupZFS, interval: defaultDedupInterval}},
+		{name: "duperemove weekly", mode: "duperemove", interval: 7 * 24 * time.Hour, want: poolDedup{mode: dedupDuperemove, interval: 7 * 24 * time.Hour}},
+		{name: "negative interval", mode: "zfs", interval: -time.Hour, wantErr: true},
+		{name: "invalid", mode: "vdo", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := newPoolDedup(tt.mode, tt.interval)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("newPoolDedup() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("newPoolDedup() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_dedupRunnerDue(t *testing.T) {
+	r := newDedupRunner("", "node01", "duperemove", record.NewFakeRecorder(10), nil)
+	pool := &storagePool{name: "vms", path: "/var/hpvolumes", dedup: poolDedup{mode: dedupDuperemove, interval: time.Hour}}
+	plain := &storagePool{name: "plain", path: "/var/other"}
+	start := time.Now()
+	if r.due(pool, start) {
+		t.Errorf("due() right after start up, want one interval later")
+	}
+	if r.due(pool, start.Add(30*time.Minute)) {
+		t.Errorf("due() before the interval passed")
+	}
+	if !r.due(pool, start.Add(time.Hour)) {
+		t.Errorf("not due() after the interval")
+	}
+	if r.due(pool, start.Add(3*time.Hour)) {
+		t.Errorf("due() while the last run is still running")
+	}
+	delete(r.running, pool.name)
+	if !r.due(pool, start.Add(3*time.Hour)) {
+		t.Errorf("not due() after the last run finished")
+	}
+	if r.due(plain, start) || r.due(plain, start.Add(48*time.Hour)) {
+		t.Errorf("due() for a pool without dedup")
+	}
+}
+
+func Test_dedupRunnerDedupe(t *testing.T) {
+	dir, err := ioutil.TempDir("", "dedup")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	mountsFile := filepath.Join(dir, "mounts")
+	mounts := strings.Join([]string{
+		"tank/hpvolumes /var/hpvolumes/zfs zfs rw 0 0",
+		"/dev/sdb1 /var/hpvolumes/btrfs btrfs rw 0 0",
+		"/dev/sdc1 /var/hpvolumes/ext4 ext4 rw 0 0",
+	}, "\n") + "\n"
+	if err := ioutil.WriteFile(mountsFile, []byte(mounts), 0644); err != nil {
+		t.Fatal(err)
+	}
+	zfs := "zfs set dedup=on tank/hpvolumes"
+	zpool := "zpool get -Hp -o value dedupratio tank"
+	duperemove := "duperemove -dr --hashfile=/var/hpvolumes/btrfs/.duperemove.hash /var/hpvolumes/btrfs"
+	tests := []struct {
+		name         string
+		pool         *storagePool
+		want         float64
+		wantCommands []string
+		wantErr      bool
+	}{
+		{name: "zfs", pool: &storagePool{name: "zfs", path: "/var/hpvolumes/zfs", dedup: poolDedup{mode: dedupZFS}}, want: 1.52, wantCommands: []string{zfs, zpool}},
+		{name: "duperemove", pool: &storagePool{name: "btrfs", path: "/var/hpvolumes/btrfs", dedup: poolDedup{mode: dedupDuperemove}}, wantCommands: []string{duperemove}},
+		{name: "duperemove on ext4", pool: &storagePool{name: "ext4", path: "/var/hpvolumes/ext4", dedup: poolDedup{mode: dedupDuperemove}}, wantErr: true},
+		{name: "zfs on btrfs", pool: &storagePool{name: "btrfs", path: "/var/hpvolumes/btrfs", dedup: poolDedup{mode: dedupZFS}}, wantErr: true},
+		{name: "duperemove without reflinks", pool: &storagePool{name: "noreflink", path: "/var/hpvolumes/btrfs", dedup: poolDedup{mode: dedupDuperemove}}, wantErr: true},
+	}
+	capabilities := newCapabilityProber(mountsFile, "node01", nil)
+	capabilities.probed["btrfs"] = map[string]bool{capabilityReflink: true}
+	capabilities.probed["noreflink"] = map[string]bool{capabilityReflink: false}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			commands, restore := fakeCommands(map[string]string{zfs: "", zpool: "1.52x\n", duperemove: ""})
+			defer restore()
+			r := newDedupRunner(mountsFile, "node01", "duperemove", record.NewFakeRecorder(10), capabilities)
+			got, err := r.dedupe(tt.pool)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("dedupe() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("dedupe() = %v, want %v", got, tt.want)
+			}
+			if !reflect.DeepEqual(*commands, tt.wantCommands) {
+				t.Errorf("commands = %v, want %v", *commands, tt.wantCommands)
+			}
+		})
+	}
+}
+
+func Test_parseDedupRatio(t *testing.T) {
+	tests := []struct {
+		value   string
+		want    float64
+		wantErr bool
+	}{
+		{value: "1.00x\n", want: 1},
+		{value: "2.35", want: 2.35},
+		{value: "-", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.value, func(t *testing.T) {
+			got, err := parseDedupRatio(tt.value)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("parseDedupRatio() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("parseDedupRatio() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
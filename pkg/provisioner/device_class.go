@@ -0,0 +1,81 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package provisioner
+
+import (
+	"fmt"
+	"strings"
+
+	"sigs.k8s.io/sig-storage-lib-external-provisioner/v13/controller"
+)
+
+const (
+	// deviceClassParameter is the StorageClass parameter restricting the
+	// volumes of the class to pools of the listed device classes.
+	deviceClassParameter = "deviceClass"
+	// annDeviceClass records the device class of the pool a volume was
+	// placed in.
+	annDeviceClass = "kubevirt.io/deviceClass"
+
+	deviceClassNVMe = "nvme"
+	deviceClassSSD  = "ssd"
+	deviceClassHDD  = "hdd"
+)
+
+// validDeviceClass returns an error unless class is a known device class.
+func validDeviceClass(class string) error {
+	switch class {
+	case deviceClassNVMe, deviceClassSSD, deviceClassHDD:
+		return nil
+	}
+	return fmt.Errorf("invalid device class %q, expected %s, %s or %s", class, deviceClassNVMe, deviceClassSSD, deviceClassHDD)
+}
+
+// deviceClassesFor returns the device classes the StorageClass restricts its
+// volumes to, a comma separated list such as "nvme,ssd", nil when it does not.
+func deviceClassesFor(options controller.ProvisionOptions) ([]string, error) {
+	if options.StorageClass == nil || options.StorageClass.Parameters[deviceClassParameter] == "" {
+		return nil, nil
+	}
+	var classes []string
+	for _, class := range strings.Split(options.StorageClass.Parameters[deviceClassParameter], ",") {
+		class = strings.TrimSpace(class)
+		if err := validDeviceClass(class); err != nil {
+			return nil, fmt.Errorf("invalid %s in storage class %s: %v", deviceClassParameter, options.StorageClass.Name, err)
+		}
+		classes = append(classes, class)
+	}
+	return classes, nil
+}
+
+// classCandidates returns the candidates whose pool is of one of the device
+// classes, all of them when classes is empty.
+func classCandidates(candidates []poolCandidate, classes []string) []poolCandidate {
+	if len(classes) == 0 {
+		return candidates
+	}
+	var result []poolCandidate
+	for _, candidate := range candidates {
+		for _, class := range classes {
+			if candidate.pool.deviceClass == class {
+				result = append(result, candidate)
+				break
+			}
+		}
+	}
+	return result
+}
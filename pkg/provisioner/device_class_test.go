@@ -0,0 +1,81 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package provisioner
+
+import (
+	"reflect"
+	"testing"
+
+	storagev1 "k8s.io/api/storage/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/sig-storage-lib-external-provisioner/v13/controller"
+)
+
+func Test_deviceClassesFor(t *testing.T) {
+	class := func(deviceClass string) *storagev1.StorageClass {
+		return &storagev1.StorageClass{ObjectMeta: metav1.ObjectMeta{Name: "hostpath"}, Parameters: map[string]string{deviceClassParameter: deviceClass}}
+	}
+	tests := []struct {
+		name    string
+		class   *storagev1.StorageClass
+		want    []string
+		wantErr bool
+	}{
+		{name: "no class"},
+		{name: "not set", class: &storagev1.StorageClass{}},
+		{name: "single", class: class("ssd"), want: []string{"ssd"}},
+		{name: "list", class: class("nvme, ssd"), want: []string{"nvme", "ssd"}},
+		{name: "invalid", class: class("ssd,tape"), wantErr: true},
+		{name: "empty entry", class: class("ssd,"), wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := deviceClassesFor(controller.ProvisionOptions{StorageClass: tt.class})
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("deviceClassesFor() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("deviceClassesFor() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_classCandidates(t *testing.T) {
+	nvme := poolCandidate{pool: &storagePool{name: "nvme", deviceClass: deviceClassNVMe}}
+	ssd := poolCandidate{pool: &storagePool{name: "ssd", deviceClass: deviceClassSSD}}
+	unclassified := poolCandidate{pool: &storagePool{name: "default"}}
+	candidates := []poolCandidate{nvme, ssd, unclassified}
+
+	tests := []struct {
+		name    string
+		classes []string
+		want    []poolCandidate
+	}{
+		{name: "no classes", want: candidates},
+		{name: "ssd", classes: []string{"ssd"}, want: []poolCandidate{ssd}},
+		{name: "nvme or ssd", classes: []string{"ssd", "nvme"}, want: []poolCandidate{nvme, ssd}},
+		{name: "hdd", classes: []string{"hdd"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := classCandidates(candidates, tt.classes); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("classCandidates() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,253 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package provisioner
+
+import (
+	"encoding/json"
+	"fmt"
+	"os/exec"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"k8s.io/klog/v2"
+
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/client-go/tools/record"
+)
+
+// smartAttributes are the ATA SMART attributes exported as metrics, keyed by
+// their id. Rising raw values of these are the usual signs of a dying disk.
+var smartAttributes = map[int]string{
+	5:   "reallocated_sectors",
+	187: "reported_uncorrectable",
+	197: "pending_sectors",
+	198: "offline_uncorrectable",
+}
+
+// smartReport is the part of the output of smartctl --json we look at.
+type smartReport struct {
+	SmartStatus *struct {
+		Passed bool `json:"passed"`
+	} `json:"smart_status"`
+	ATASmartAttributes struct {
+		Table []struct {
+			ID  int `json:"id"`
+			Raw struct {
+				Value int64 `json:"value"`
+			} `json:"raw"`
+		} `json:"table"`
+	} `json:"ata_smart_attributes"`
+	NVMeHealth *struct {
+		CriticalWarning int   `json:"critical_warning"`
+		MediaErrors     int64 `json:"media_errors"`
+	} `json:"nvme_smart_health_information_log"`
+}
+
+// deviceHealth is the health of a pool's device according to SMART.
+type deviceHealth struct {
+	failing bool
+	reason  string
+	// raw values of the interesting attributes, keyed by metric label
+	attributes map[string]int64
+}
+
+// parseSmartReport extracts the health of a device from smartctl --json output.
+func parseSmartReport(output []byte) (deviceHealth, error) {
+	report := smartReport{}
+	if err := json.Unmarshal(output, &report); err != nil {
+		return deviceHealth{}, fmt.Errorf("unable to parse smartctl output: %v", err)
+	}
+	if report.SmartStatus == nil {
+		return deviceHealth{}, fmt.Errorf("smartctl did not report the SMART status")
+	}
+	health := deviceHealth{attributes: make(map[string]int64)}
+	if !report.SmartStatus.Passed {
+		health.failing = true
+		health.reason = "SMART overall health self-assessment failed"
+	}
+	for _, attribute := range report.ATASmartAttributes.Table {
+		if name, ok := smartAttributes[attribute.ID]; ok {
+			health.attributes[name] = attribute.Raw.Value
+		}
+	}
+	if report.NVMeHealth != nil {
+		health.attributes["media_errors"] = report.NVMeHealth.MediaErrors
+		if report.NVMeHealth.CriticalWarning != 0 && !health.failing {
+			health.failing = true
+			health.reason = fmt.Sprintf("NVMe critical warning 0x%x", report.NVMeHealth.CriticalWarning)
+		}
+	}
+	return health, nil
+}
+
+// deviceHealthMonitor reads the SMART health of the devices backing the pools
+// and stops placing new volumes in pools whose device is failing.
+type deviceHealthMonitor struct {
+	mountsPath    string
+	nodeRef       *v1.ObjectReference
+	eventRecorder record.EventRecorder
+	// smartctl runs smartctl for the device and returns its JSON output
+	smartctl func(device string) ([]byte, error)
+
+	failingDevice *prometheus.GaugeVec
+	attribute     *prometheus.GaugeVec
+
+	mutex sync.Mutex
+	// reasons pools are considered failing for, keyed by pool name
+	failing map[string]string
+}
+
+var _ prometheus.Collector = &deviceHealthMonitor{}
+
+func newDeviceHealthMonitor(smartctlPath, mountsPath, nodeName string, eventRecorder record.EventRecorder) *deviceHealthMonitor {
+	return &deviceHealthMonitor{
+		mountsPath: mountsPath,
+		nodeRef: &v1.ObjectReference{
+			Kind: "Node",
+			Name: nodeName,
+			UID:  types.UID(nodeName),
+		},
+		eventRecorder: eventRecorder,
+		smartctl: func(device string) ([]byte, error) {
+			output, err := exec.Command(smartctlPath, "--json", "--health", "--attributes", device).Output()
+			// smartctl reports problems in its exit status, the output is
+			// still valid then
+			if _, ok := err.(*exec.ExitError); ok && len(output) > 0 {
+				err = nil
+			}
+			return output, err
+		},
+		failingDevice: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Namespace: metricsNamespace,
+			Name:      "pool_device_failing",
+			Help:      "Whether SMART reports the device backing the pool as failing.",
+		}, []string{"pool", "device"}),
+		attribute: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Namespace: metricsNamespace,
+			Name:      "pool_device_smart_raw_value",
+			Help:      "Raw value of a SMART attribute of the device backing the pool.",
+		}, []string{"pool", "device", "attribute"}),
+		failing: make(map[string]string),
+	}
+}
+
+// Run checks the devices of all pools every interval until stopCh is closed.
+func (m *deviceHealthMonitor) Run(pools func() []*storagePool, interval time.Duration, stopCh <-chan struct{}) {
+	wait.Until(func() {
+		for _, pool := range pools() {
+			m.check(pool)
+		}
+	}, interval, stopCh)
+}
+
+// poolDevice returns the device configured for the pool in POOL_DEVICES, or
+// the device mounted at the pool.
+func (m *deviceHealthMonitor) poolDevice(pool *storagePool) (string, error) {
+	if pool.device != "" {
+		return pool.device, nil
+	}
+	mounts, err := readMounts(m.mountsPath)
+	if err != nil {
+		return "", err
+	}
+	mount := findMount(mounts, filepath.Clean(pool.path))
+	if mount == nil {
+		return "", fmt.Errorf("unable to find the mount of pool %s", pool.name)
+	}
+	return mount.device, nil
+}
+
+func (m *deviceHealthMonitor) check(pool *storagePool) {
+	device, err := m.poolDevice(pool)
+	if err != nil {
+		klog.Warningf("unable to determine the device of pool %s for health checks: %v", pool.name, err)
+		return
+	}
+	output, err := m.smartctl(device)
+	if err != nil {
+		klog.V(3).Infof("unable to read SMART data of %s for pool %s: %v", device, pool.name, err)
+		return
+	}
+	health, err := parseSmartReport(output)
+	if err != nil {
+		// Devices without SMART support, e.g. virtual disks or device
+		// mapper volumes, end up here and are assumed healthy
+		klog.V(3).Infof("no SMART health for %s of pool %s: %v", device, pool.name, err)
+		return
+	}
+	m.update(pool, device, health)
+}
+
+// update records the health of the pool's device, emitting an event when the
+// device starts or stops failing.
+func (m *deviceHealthMonitor) update(pool *storagePool, device string, health deviceHealth) {
+	for name, value := range health.attributes {
+		m.attribute.WithLabelValues(pool.name, device, name).Set(float64(value))
+	}
+	failing := 0.0
+	if health.failing {
+		failing = 1
+	}
+	m.failingDevice.WithLabelValues(pool.name, device).Set(failing)
+
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	_, wasFailing := m.failing[pool.name]
+	if health.failing {
+		m.failing[pool.name] = device + ": " + health.reason
+		if !wasFailing {
+			klog.Errorf("device %s of pool %s is failing: %s, not placing new volumes in the pool", device, pool.name, health.reason)
+			m.eventRecorder.Eventf(m.nodeRef, v1.EventTypeWarning, "PoolDeviceFailing", "Device %s of hostpath pool %s is failing: %s, no new volumes are placed in the pool", device, pool.name, health.reason)
+		}
+		return
+	}
+	if wasFailing {
+		delete(m.failing, pool.name)
+		klog.Infof("device %s of pool %s is healthy again", device, pool.name)
+		m.eventRecorder.Eventf(m.nodeRef, v1.EventTypeNormal, "PoolDeviceHealthy", "Device %s of hostpath pool %s is healthy again, new volumes are placed in the pool", device, pool.name)
+	}
+}
+
+// usable returns whether new volumes can be placed in the pool.
+func (m *deviceHealthMonitor) usable(pool *storagePool) bool {
+	if m == nil {
+		return true
+	}
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	reason, failing := m.failing[pool.name]
+	if failing {
+		klog.V(3).Infof("skipping pool %s, its device is failing: %s", pool.name, reason)
+	}
+	return !failing
+}
+
+// Describe implements prometheus.Collector.
+func (m *deviceHealthMonitor) Describe(ch chan<- *prometheus.Desc) {
+	m.failingDevice.Describe(ch)
+	m.attribute.Describe(ch)
+}
+
+// Collect implements prometheus.Collector.
+func (m *deviceHealthMonitor) Collect(ch chan<- prometheus.Metric) {
+	m.failingDevice.Collect(ch)
+	m.attribute.Collect(ch)
+}
@@ -0,0 +1,114 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package provisioner
+
+import (
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+
+	"k8s.io/client-go/tools/record"
+)
+
+const (
+	healthyATAReport = `{"smart_status":{"passed":true},"ata_smart_attributes":{"table":[
+		{"id":5,"name":"Reallocated_Sector_Ct","raw":{"value":8}},
+		{"id":9,"name":"Power_On_Hours","raw":{"value":12000}},
+		{"id":197,"name":"Current_Pending_Sector","raw":{"value":0}}]}}`
+	failingATAReport  = `{"smart_status":{"passed":false},"ata_smart_attributes":{"table":[{"id":5,"raw":{"value":4000}}]}}`
+	warningNVMeReport = `{"smart_status":{"passed":true},"nvme_smart_health_information_log":{"critical_warning":4,"media_errors":3}}`
+)
+
+func Test_parseSmartReport(t *testing.T) {
+	tests := []struct {
+		name           string
+		output         string
+		wantFailing    bool
+		wantAttributes map[string]int64
+		wantErr        bool
+	}{
+		{name: "healthy ATA", output: healthyATAReport, wantAttributes: map[string]int64{"reallocated_sectors": 8, "pending_sectors": 0}},
+		{name: "failing ATA", output: failingATAReport, wantFailing: true, wantAttributes: map[string]int64{"reallocated_sectors": 4000}},
+		{name: "NVMe critical warning", output: warningNVMeReport, wantFailing: true, wantAttributes: map[string]int64{"media_errors": 3}},
+		{name: "no SMART support", output: `{"smartctl":{"exit_status":4}}`, wantErr: true},
+		{name: "garbage", output: "Permission denied", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := parseSmartReport([]byte(tt.output))
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("parseSmartReport() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err != nil {
+				return
+			}
+			if got.failing != tt.wantFailing || (got.failing && got.reason == "") {
+				t.Errorf("parseSmartReport() failing = %v (%q), want %v", got.failing, got.reason, tt.wantFailing)
+			}
+			if !reflect.DeepEqual(got.attributes, tt.wantAttributes) {
+				t.Errorf("parseSmartReport() attributes = %v, want %v", got.attributes, tt.wantAttributes)
+			}
+		})
+	}
+}
+
+func Test_deviceHealthMonitorCheck(t *testing.T) {
+	recorder := record.NewFakeRecorder(10)
+	m := newDeviceHealthMonitor("smartctl", "/nonexistent", "test-node", recorder)
+	pool := &storagePool{name: "ssd", path: "/pools/ssd", device: "/dev/sdb"}
+
+	steps := []struct {
+		output     string
+		err        error
+		wantUsable bool
+		wantReason string
+	}{
+		{output: healthyATAReport, wantUsable: true},
+		{output: failingATAReport, wantUsable: false, wantReason: "PoolDeviceFailing"},
+		{output: failingATAReport, wantUsable: false},
+		// unreadable SMART data keeps the previous state
+		{err: errors.New("exec: not found"), wantUsable: false},
+		{output: healthyATAReport, wantUsable: true, wantReason: "PoolDeviceHealthy"},
+	}
+	for i, step := range steps {
+		m.smartctl = func(device string) ([]byte, error) {
+			if device != pool.device {
+				t.Errorf("smartctl called for %s, want %s", device, pool.device)
+			}
+			return []byte(step.output), step.err
+		}
+		m.check(pool)
+		if got := m.usable(pool); got != step.wantUsable {
+			t.Errorf("step %d: usable() = %v, want %v", i, got, step.wantUsable)
+		}
+		select {
+		case event := <-recorder.Events:
+			if step.wantReason == "" || !strings.Contains(event, step.wantReason) {
+				t.Errorf("step %d: unexpected event %q", i, event)
+			}
+		default:
+			if step.wantReason != "" {
+				t.Errorf("step %d: no %s event", i, step.wantReason)
+			}
+		}
+	}
+	var disabled *deviceHealthMonitor
+	if !disabled.usable(pool) {
+		t.Error("usable() = false without device health checks")
+	}
+}
@@ -0,0 +1,388 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package provisioner
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"k8s.io/klog/v2"
+
+	apierrs "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/client-go/rest"
+)
+
+const (
+	deviceInventoryKind     = "DeviceInventory"
+	deviceInventoryResource = "deviceinventories"
+
+	sysBlockPath = "/sys/block"
+	byIDPath     = "/dev/disk/by-id"
+)
+
+// deviceInventory is the DeviceInventory object of a node, listing the block
+// devices of the node. Admins approve devices for device pools by listing
+// their IDs in the spec. It is cluster scoped and named after the node.
+type deviceInventory struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+	Spec              deviceInventorySpec   `json:"spec"`
+	Status            deviceInventoryStatus `json:"status"`
+}
+
+type deviceInventorySpec struct {
+	// Approved are the IDs, the names in /dev/disk/by-id, of the devices
+	// that may be handed out
+	Approved []string `json:"approved,omitempty"`
+}
+
+type deviceInventoryStatus struct {
+	Devices        []inventoryDevice `json:"devices"`
+	LastUpdateTime metav1.Time       `json:"lastUpdateTime"`
+}
+
+// inventoryDevice is a block device of the node.
+type inventoryDevice struct {
+	// Name is the kernel name, e.g. sdb
+	Name string `json:"name"`
+	// ID is the name of the device in /dev/disk/by-id
+	ID         string `json:"id,omitempty"`
+	SizeBytes  int64  `json:"sizeBytes"`
+	Model      string `json:"model,omitempty"`
+	Serial     string `json:"serial,omitempty"`
+	Rotational bool   `json:"rotational"`
+	// Available is set for unused devices matching the filters, Reason
+	// tells why other devices are not
+	Available bool   `json:"available"`
+	Reason    string `json:"reason,omitempty"`
+	Approved  bool   `json:"approved"`
+	// Volume is the volume the device is handed out to
+	Volume string `json:"volume,omitempty"`
+}
+
+// DeviceInventoryConfig configures the inventory of the block devices of the
+// node, it is the deviceInventory section of the config file.
+type DeviceInventoryConfig struct {
+	// --device-inventory-interval, how often the devices are discovered and
+	// published, disabled when 0
+	Interval metav1.Duration `json:"interval,omitempty"`
+	// --device-min-size, --device-model and --device-serial, the smallest
+	// size and the expressions the model and serial number of the devices
+	// offered have to match
+	MinSize string `json:"minSize,omitempty"`
+	Model   string `json:"model,omitempty"`
+	Serial  string `json:"serial,omitempty"`
+	// --approved-devices-dir, the directory of links to the approved
+	// devices, none when empty
+	ApprovedDevicesDir string `json:"approvedDevicesDir,omitempty"`
+}
+
+// deviceFilter selects the devices offered in the inventory.
+type deviceFilter struct {
+	minSize int64
+	model   *regexp.Regexp
+	serial  *regexp.Regexp
+}
+
+func newDeviceFilter(minSize, model, serial string) (*deviceFilter, error) {
+	filter := &deviceFilter{}
+	if minSize != "" {
+		quantity, err := resource.ParseQuantity(minSize)
+		if err != nil {
+			return nil, fmt.Errorf("invalid minimum device size %q: %v", minSize, err)
+		}
+		filter.minSize = quantity.Value()
+	}
+	var err error
+	if model != "" {
+		if filter.model, err = regexp.Compile(model); err != nil {
+			return nil, fmt.Errorf("invalid device model expression %q: %v", model, err)
+		}
+	}
+	if serial != "" {
+		if filter.serial, err = regexp.Compile(serial); err != nil {
+			return nil, fmt.Errorf("invalid device serial expression %q: %v", serial, err)
+		}
+	}
+	return filter, nil
+}
+
+// reject returns why the device does not match the filter, empty when it does.
+func (f *deviceFilter) reject(device inventoryDevice) string {
+	switch {
+	case device.SizeBytes < f.minSize:
+		return "smaller than the minimum size"
+	case f.model != nil && !f.model.MatchString(device.Model):
+		return "model does not match"
+	case f.serial != nil && !f.serial.MatchString(device.Serial):
+		return "serial number does not match"
+	}
+	return ""
+}
+
+// virtualDevice matches the kernel names of devices that are never offered.
+var virtualDevice = regexp.MustCompile(`^(loop|ram|zram|dm-|md|sr|nbd)`)
+
+// discoverDevices lists the block devices in sysRoot, /sys/block, with their
+// IDs from the links in byID, /dev/disk/by-id. Devices that are partitioned,
+// held by another device, e.g. LVM, or mounted are not available.
+func discoverDevices(sysRoot, byID string, mounts []mountInfo) ([]inventoryDevice, error) {
+	entries, err := ioutil.ReadDir(sysRoot)
+	if err != nil {
+		return nil, err
+	}
+	ids := deviceIDs(byID)
+	var devices []inventoryDevice
+	for _, entry := range entries {
+		name := entry.Name()
+		if virtualDevice.MatchString(name) {
+			continue
+		}
+		dir := filepath.Join(sysRoot, name)
+		device := inventoryDevice{
+			Name:       name,
+			ID:         ids[name],
+			Model:      readSysfs(dir, "device/model"),
+			Serial:     readSysfs(dir, "device/serial"),
+			Rotational: readSysfs(dir, "queue/rotational") == "1",
+		}
+		if sectors, err := strconv.ParseInt(readSysfs(dir, "size"), 10, 64); err == nil {
+			device.SizeBytes = sectors * 512
+		}
+		device.Reason = deviceInUse(dir, name, mounts)
+		devices = append(devices, device)
+	}
+	return devices, nil
+}
+
+// deviceIDs maps kernel names to the preferred name of their links in byID:
+// the first wwn- link, or else the first link, of whole devices.
+func deviceIDs(byID string) map[string]string {
+	ids := make(map[string]string)
+	entries, err := ioutil.ReadDir(byID)
+	if err != nil {
+		return ids
+	}
+	names := make([]string, 0, len(entries))
+	for _, entry := range entries {
+		names = append(names, entry.Name())
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		if strings.Contains(name, "-part") {
+			continue
+		}
+		target, err := os.Readlink(filepath.Join(byID, name))
+		if err != nil {
+			continue
+		}
+		device := filepath.Base(target)
+		if current, ok := ids[device]; !ok || (!strings.HasPrefix(current, "wwn-") && strings.HasPrefix(name, "wwn-")) {
+			ids[device] = name
+		}
+	}
+	return ids
+}
+
+// deviceInUse returns why the device is in use, empty when it is not.
+func deviceInUse(dir, name string, mounts []mountInfo) string {
+	if readSysfs(dir, "removable") == "1" {
+		return "removable"
+	}
+	if holders, _ := ioutil.ReadDir(filepath.Join(dir, "holders")); len(holders) > 0 {
+		return "held by " + holders[0].Name()
+	}
+	entries, _ := ioutil.ReadDir(dir)
+	for _, entry := range entries {
+		if strings.HasPrefix(entry.Name(), name) {
+			return "partitioned"
+		}
+	}
+	for _, mount := range mounts {
+		if mount.device == "/dev/"+name {
+			return "mounted at " + mount.mountPoint
+		}
+	}
+	return ""
+}
+
+func readSysfs(dir, name string) string {
+	data, err := ioutil.ReadFile(filepath.Join(dir, name))
+	if err != nil {
+		return ""
+	}
+	return strings.TrimSpace(string(data))
+}
+
+// applyInventory marks the devices that match the filter, are approved and are
+// handed out to volumes, linked from linkDir and recorded in claims.
+func applyInventory(devices []inventoryDevice, filter *deviceFilter, approved []string, linkDir string, claims *deviceClaims) {
+	approvedIDs := make(map[string]bool)
+	for _, id := range approved {
+		approvedIDs[id] = true
+	}
+	for i := range devices {
+		device := &devices[i]
+		if device.Reason == "" {
+			device.Reason = filter.reject(*device)
+		}
+		if device.Reason == "" && device.ID == "" {
+			device.Reason = "no stable ID"
+		}
+		device.Available = device.Reason == ""
+		device.Approved = device.ID != "" && approvedIDs[device.ID]
+		if linkDir != "" && device.ID != "" {
+			device.Volume = claims.claimedBy(filepath.Join(linkDir, device.ID))
+		}
+	}
+}
+
+// syncApprovedLinks keeps a link in dir to every available and approved
+// device, named after its ID. Links to devices handed out to volumes are kept
+// even when the device is no longer approved or available, e.g. because the
+// volume's consumer partitioned it, claims records which are.
+func syncApprovedLinks(dir, byID string, devices []inventoryDevice, claims *deviceClaims) error {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+	wanted := make(map[string]bool)
+	for _, device := range devices {
+		if device.Volume != "" || (device.Available && device.Approved) {
+			wanted[device.ID] = true
+		}
+	}
+	entries, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return err
+	}
+	for _, entry := range entries {
+		if wanted[entry.Name()] {
+			delete(wanted, entry.Name())
+			continue
+		}
+		link := filepath.Join(dir, entry.Name())
+		if claims.claimedBy(link) != "" {
+			continue
+		}
+		klog.InfoS("Removing link to device that is no longer approved", "link", link)
+		if err := os.Remove(link); err != nil {
+			return err
+		}
+	}
+	for id := range wanted {
+		link := filepath.Join(dir, id)
+		klog.InfoS("Linking approved device", "link", link)
+		if err := os.Symlink(filepath.Join(byID, id), link); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// deviceInventoryPublisher maintains the DeviceInventory object of the node
+// and the links to its approved devices.
+type deviceInventoryPublisher struct {
+	p          *HostPathProvisioner
+	leadership leadership
+	client     rest.Interface
+	filter     *deviceFilter
+	linkDir    string
+}
+
+func newDeviceInventoryPublisher(p *HostPathProvisioner, leadership leadership, filter *deviceFilter, linkDir string) *deviceInventoryPublisher {
+	return &deviceInventoryPublisher{p: p, leadership: leadership, client: p.client.Discovery().RESTClient(), filter: filter, linkDir: linkDir}
+}
+
+// Run discovers and publishes the devices every interval until stopCh is
+// closed.
+func (d *deviceInventoryPublisher) Run(interval time.Duration, stopCh <-chan struct{}) {
+	wait.Until(func() {
+		// Only the leader hands out devices
+		if leader := d.leadership.Leader(); leader != "" && leader != d.leadership.Identity() {
+			return
+		}
+		if err := d.sync(); err != nil {
+			klog.Errorf("unable to update the device inventory of node %s: %v", d.p.nodeName, err)
+		}
+	}, interval, stopCh)
+}
+
+func (d *deviceInventoryPublisher) path() string {
+	return "/apis/" + nodeStatusGroup + "/" + nodeStatusVersion + "/" + deviceInventoryResource
+}
+
+// sync discovers the devices, links the approved ones and creates or updates
+// the DeviceInventory object of the node, keeping its spec.
+func (d *deviceInventoryPublisher) sync() error {
+	inventory := &deviceInventory{
+		TypeMeta:   metav1.TypeMeta{APIVersion: nodeStatusGroup + "/" + nodeStatusVersion, Kind: deviceInventoryKind},
+		ObjectMeta: metav1.ObjectMeta{Name: d.p.nodeName},
+	}
+	raw, err := d.client.Get().AbsPath(d.path(), d.p.nodeName).Do(context.TODO()).Raw()
+	exists := !apierrs.IsNotFound(err)
+	if err != nil && exists {
+		return err
+	}
+	if exists {
+		if err := json.Unmarshal(raw, inventory); err != nil {
+			return err
+		}
+	}
+
+	mounts, err := readMounts(d.p.mountsPath)
+	if err != nil {
+		return err
+	}
+	devices, err := discoverDevices(sysBlockPath, byIDPath, mounts)
+	if err != nil {
+		return err
+	}
+	if d.linkDir != "" {
+		if err := d.p.claimedDevices.load(d.p); err != nil {
+			return err
+		}
+	}
+	applyInventory(devices, d.filter, inventory.Spec.Approved, d.linkDir, d.p.claimedDevices)
+	if d.linkDir != "" {
+		if err := syncApprovedLinks(d.linkDir, byIDPath, devices, d.p.claimedDevices); err != nil {
+			return err
+		}
+	}
+	inventory.Status = deviceInventoryStatus{Devices: devices, LastUpdateTime: metav1.Now()}
+
+	body, err := json.Marshal(inventory)
+	if err != nil {
+		return err
+	}
+	req := d.client.Post().AbsPath(d.path())
+	if exists {
+		req = d.client.Put().AbsPath(d.path(), d.p.nodeName)
+	}
+	return req.SetHeader("Content-Type", "application/json").Body(body).Do(context.TODO()).Error()
+}
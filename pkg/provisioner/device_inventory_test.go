@@ -0,0 +1,172 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package provisioner
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"testing"
+)
+
+// fakeSysBlock creates a /sys/block and a /dev/disk/by-id in dir.
+func fakeSysBlock(t *testing.T, dir string, files map[string]string, links map[string]string) (string, string) {
+	sysRoot, byID := filepath.Join(dir, "sys"), filepath.Join(dir, "by-id")
+	for name, content := range files {
+		path := filepath.Join(sysRoot, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if content == "/" {
+			if err := os.MkdirAll(path, 0755); err != nil {
+				t.Fatal(err)
+			}
+			continue
+		}
+		if err := ioutil.WriteFile(path, []byte(content+"\n"), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := os.MkdirAll(byID, 0755); err != nil {
+		t.Fatal(err)
+	}
+	for name, target := range links {
+		if err := os.Symlink(target, filepath.Join(byID, name)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return sysRoot, byID
+}
+
+func Test_discoverDevices(t *testing.T) {
+	dir, err := ioutil.TempDir("", "inventory")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	sysRoot, byID := fakeSysBlock(t, dir, map[string]string{
+		"sda/size":             "2097152",
+		"sda/device/model":     "SSD 860",
+		"sda/device/serial":    "S3Z1",
+		"sda/queue/rotational": "0",
+		"sdb/size":             "4194304",
+		"sdb/queue/rotational": "1",
+		"sdb/sdb1":             "/",
+		"sdc/size":             "2097152",
+		"sdc/holders/dm-0":     "/",
+		"sdd/size":             "2097152",
+		"loop0/size":           "2048",
+	}, map[string]string{
+		"ata-SSD_860_S3Z1":      "../../sda",
+		"wwn-0x5002538e":        "../../sda",
+		"ata-HDD_1":             "../../sdb",
+		"ata-HDD_1-part1":       "../../sdb1",
+		"scsi-0QEMU_HARDDISK_2": "../../sdc",
+	})
+	mounts := []mountInfo{{device: "/dev/sdd", mountPoint: "/var/hpvolumes"}}
+	devices, err := discoverDevices(sysRoot, byID, mounts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sort.Slice(devices, func(i, j int) bool { return devices[i].Name < devices[j].Name })
+	want := []inventoryDevice{
+		{Name: "sda", ID: "wwn-0x5002538e", SizeBytes: 1073741824, Model: "SSD 860", Serial: "S3Z1"},
+		{Name: "sdb", ID: "ata-HDD_1", SizeBytes: 2147483648, Rotational: true, Reason: "partitioned"},
+		{Name: "sdc", ID: "scsi-0QEMU_HARDDISK_2", SizeBytes: 1073741824, Reason: "held by dm-0"},
+		{Name: "sdd", SizeBytes: 1073741824, Reason: "mounted at /var/hpvolumes"},
+	}
+	if !reflect.DeepEqual(devices, want) {
+		t.Errorf("discoverDevices() = %+v, want %+v", devices, want)
+	}
+}
+
+func Test_applyInventory(t *testing.T) {
+	filter, err := newDeviceFilter("1Gi", "^SSD", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	devices := []inventoryDevice{
+		{Name: "sda", ID: "wwn-a", SizeBytes: GiB, Model: "SSD 860"},
+		{Name: "sdb", ID: "wwn-b", SizeBytes: GiB, Model: "HDD"},
+		{Name: "sdc", ID: "wwn-c", SizeBytes: MiB, Model: "SSD 860"},
+		{Name: "sdd", SizeBytes: GiB, Model: "SSD 860"},
+		{Name: "sde", ID: "wwn-e", SizeBytes: GiB, Model: "SSD 860", Reason: "partitioned"},
+	}
+	applyInventory(devices, filter, []string{"wwn-a", "wwn-b"}, "", nil)
+	want := []inventoryDevice{
+		{Name: "sda", ID: "wwn-a", SizeBytes: GiB, Model: "SSD 860", Available: true, Approved: true},
+		{Name: "sdb", ID: "wwn-b", SizeBytes: GiB, Model: "HDD", Reason: "model does not match", Approved: true},
+		{Name: "sdc", ID: "wwn-c", SizeBytes: MiB, Model: "SSD 860", Reason: "smaller than the minimum size"},
+		{Name: "sdd", SizeBytes: GiB, Model: "SSD 860", Reason: "no stable ID"},
+		{Name: "sde", ID: "wwn-e", SizeBytes: GiB, Model: "SSD 860", Reason: "partitioned"},
+	}
+	if !reflect.DeepEqual(devices, want) {
+		t.Errorf("applyInventory() = %+v, want %+v", devices, want)
+	}
+}
+
+func Test_newDeviceFilter(t *testing.T) {
+	for _, args := range [][3]string{{"large", "", ""}, {"", "[", ""}, {"", "", "("}} {
+		if _, err := newDeviceFilter(args[0], args[1], args[2]); err == nil {
+			t.Errorf("newDeviceFilter(%q) accepted invalid arguments", args)
+		}
+	}
+}
+
+func Test_syncApprovedLinks(t *testing.T) {
+	dir, err := ioutil.TempDir("", "approved")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	links := filepath.Join(dir, "approved")
+	if err := os.MkdirAll(links, 0755); err != nil {
+		t.Fatal(err)
+	}
+	for _, id := range []string{"wwn-revoked", "wwn-claimed"} {
+		if err := os.Symlink("/dev/disk/by-id/"+id, filepath.Join(links, id)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	claims := &deviceClaims{loaded: true, claims: map[string]string{filepath.Join(links, "wwn-claimed"): "pvc-1"}}
+
+	devices := []inventoryDevice{
+		{Name: "sda", ID: "wwn-new", Available: true, Approved: true},
+		{Name: "sdb", ID: "wwn-unapproved", Available: true},
+		{Name: "sdc", ID: "wwn-revoked", Available: true},
+		{Name: "sdd", ID: "wwn-claimed", Reason: "partitioned", Volume: "pvc-1"},
+	}
+	if err := syncApprovedLinks(links, "/dev/disk/by-id", devices, claims); err != nil {
+		t.Fatal(err)
+	}
+	entries, err := ioutil.ReadDir(links)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var names []string
+	for _, entry := range entries {
+		names = append(names, entry.Name())
+	}
+	if want := []string{"wwn-claimed", "wwn-new"}; !reflect.DeepEqual(names, want) {
+		t.Errorf("links = %v, want %v", names, want)
+	}
+	if target, err := os.Readlink(filepath.Join(links, "wwn-new")); err != nil || target != "/dev/disk/by-id/wwn-new" {
+		t.Errorf("link target = %s, %v", target, err)
+	}
+}
@@ -0,0 +1,395 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package provisioner
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/klog/v2"
+	"kubevirt.io/hostpath-provisioner/admin"
+	"sigs.k8s.io/sig-storage-lib-external-provisioner/v13/controller"
+)
+
+const (
+	// annDevice records the block device handed out as a volume of a device
+	// pool.
+	annDevice = "kubevirt.io/device"
+
+	// allocationDevice is the allocation of block claims, which are given a
+	// whole device of a device pool.
+	allocationDevice = "device"
+
+	eventReasonDeviceAssigned = "DeviceAssigned"
+)
+
+// devicePool is the set of dedicated block devices of a storage pool, the
+// entries of the pool directory matching pattern. Every block claim placed in
+// the pool is given a whole device, e.g. for Ceph OSDs or VMs wanting raw
+// disks.
+type devicePool struct {
+	pattern string
+}
+
+// newDevicePool returns the device pool of the entries matching pattern, nil
+// when pattern is empty.
+func newDevicePool(pattern string) (*devicePool, error) {
+	if pattern == "" {
+		return nil, nil
+	}
+	if _, err := filepath.Match(pattern, ""); err != nil {
+		return nil, fmt.Errorf("invalid device pattern %q: %v", pattern, err)
+	}
+	return &devicePool{pattern: pattern}, nil
+}
+
+// hasDevicePool returns whether one of the pools hands out devices.
+func hasDevicePool(pools []*storagePool) bool {
+	for _, pool := range pools {
+		if pool.devices != nil {
+			return true
+		}
+	}
+	return false
+}
+
+// isBlockClaim returns whether the claim asks for a raw block volume.
+func isBlockClaim(pvc *v1.PersistentVolumeClaim) bool {
+	return pvc.Spec.VolumeMode != nil && *pvc.Spec.VolumeMode == v1.PersistentVolumeBlock
+}
+
+// poolDevice is a block device of a device pool.
+type poolDevice struct {
+	path string
+	size int64
+}
+
+// blockDeviceSize returns the size of the block device at path. It is
+// replaced in tests.
+var blockDeviceSize = func(path string) (int64, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return 0, err
+	}
+	if info.Mode()&os.ModeDevice == 0 || info.Mode()&os.ModeCharDevice != 0 {
+		return 0, fmt.Errorf("%s is not a block device", path)
+	}
+	file, err := os.Open(path)
+	if err != nil {
+		return 0, err
+	}
+	defer file.Close()
+	return file.Seek(0, io.SeekEnd)
+}
+
+// listDevices returns the block devices of the pool, entries that are not
+// block devices are skipped.
+func (pool *storagePool) listDevices() ([]poolDevice, error) {
+	paths, err := filepath.Glob(filepath.Join(pool.path, pool.devices.pattern))
+	if err != nil {
+		return nil, err
+	}
+	var devices []poolDevice
+	for _, path := range paths {
+		size, err := blockDeviceSize(path)
+		if err != nil {
+			klog.V(3).InfoS("Skipping device pool entry", "pool", pool.name, "path", path, "reason", err.Error())
+			continue
+		}
+		devices = append(devices, poolDevice{path: path, size: size})
+	}
+	return devices, nil
+}
+
+// chooseDevice returns the smallest device that is not claimed and holds the
+// requested size, so that large devices are left for large claims.
+func chooseDevice(devices []poolDevice, claims map[string]string, requested int64) (poolDevice, bool) {
+	sorted := append([]poolDevice(nil), devices...)
+	sort.SliceStable(sorted, func(i, j int) bool { return sorted[i].size < sorted[j].size })
+	for _, device := range sorted {
+		if _, claimed := claims[device.path]; !claimed && device.size >= requested {
+			return device, true
+		}
+	}
+	return poolDevice{}, false
+}
+
+// deviceClaims tracks which volume every device of the device pools is handed
+// out to. The claims are read from the volumes of the node when the first
+// device is handed out, and updated as devices are handed out and released.
+type deviceClaims struct {
+	mutex  sync.Mutex
+	loaded bool
+	// claims maps device paths to the names of their volumes
+	claims map[string]string
+}
+
+// load reads the devices handed out to the volumes of this node, unless they
+// were read already.
+func (d *deviceClaims) load(p *HostPathProvisioner) error {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+	return d.loadLocked(p)
+}
+
+func (d *deviceClaims) loadLocked(p *HostPathProvisioner) error {
+	if d.loaded {
+		return nil
+	}
+	pvs, err := p.pvCache.list(context.TODO(), p.client)
+	if err != nil {
+		return fmt.Errorf("unable to list persistent volumes to find the devices in use: %v", err)
+	}
+	for _, pv := range pvs.Items {
+		device := pv.Annotations[annDevice]
+		if device != "" && p.ownsIdentity(pv.Annotations["hostPathProvisionerIdentity"]) && pv.Annotations["kubevirt.io/provisionOnNode"] == p.nodeName {
+			d.claims[device] = pv.Name
+		}
+	}
+	d.loaded = true
+	return nil
+}
+
+// claim hands out a device of the pool holding the requested size to the
+// volume. The device already handed out to the volume by an earlier attempt
+// is returned again.
+func (d *deviceClaims) claim(p *HostPathProvisioner, pool *storagePool, requested int64, volume string) (poolDevice, error) {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+	if err := d.loadLocked(p); err != nil {
+		return poolDevice{}, err
+	}
+	devices, err := pool.listDevices()
+	if err != nil {
+		return poolDevice{}, err
+	}
+	for _, device := range devices {
+		if d.claims[device.path] == volume {
+			return device, nil
+		}
+	}
+	device, ok := chooseDevice(devices, d.claims, requested)
+	if !ok {
+		return poolDevice{}, fmt.Errorf("no free device in pool %s on node %s holds %d bytes", pool.name, p.nodeName, requested)
+	}
+	d.claims[device.path] = volume
+	return device, nil
+}
+
+// release returns the device to its pool.
+func (d *deviceClaims) release(device string) {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+	delete(d.claims, device)
+}
+
+// claimedBy returns the volume the device is handed out to, empty when it is
+// free.
+func (d *deviceClaims) claimedBy(device string) string {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+	return d.claims[device]
+}
+
+// largestFree returns the size of the largest device of the pool that is not
+// handed out.
+func (d *deviceClaims) largestFree(pool *storagePool) (int64, error) {
+	devices, err := pool.listDevices()
+	if err != nil {
+		return 0, err
+	}
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+	var largest int64
+	for _, device := range devices {
+		if _, claimed := d.claims[device.path]; !claimed && device.size > largest {
+			largest = device.size
+		}
+	}
+	return largest, nil
+}
+
+// provisionDevice hands out a whole device of the pool to the block claim.
+// The volume is a local volume of the device, as large as the device.
+func (p *HostPathProvisioner) provisionDevice(ctx context.Context, options controller.ProvisionOptions, pool *storagePool, poolPolicy, id string, start time.Time, trace *span) (*v1.PersistentVolume, error) {
+	pvc := options.PVC.Namespace + "/" + options.PVC.Name
+	requested := options.PVC.Spec.Resources.Requests[v1.ResourceName(v1.ResourceStorage)]
+	if p.dryRun {
+		klog.InfoS("Dry run, not handing out a device", "correlationID", id, "pvc", pvc, "node", p.nodeName, "pool", pool.name)
+		p.claimEvent(options.PVC, v1.EventTypeNormal, eventReasonDryRun, "Would hand out a device of pool %s on node %s", pool.name, p.nodeName)
+		return nil, errDryRun
+	}
+
+	span := trace.child("WaitForRateLimit")
+	waitCtx, stopWaiting := p.waitContext(ctx)
+	err := p.rateLimiter.wait(waitCtx, pool.name, p.provisionTimeout)
+	stopWaiting()
+	span.end(err)
+	if err != nil {
+		return nil, err
+	}
+	if p.quota != nil {
+		span = trace.child("ReserveQuota")
+		err := p.quota.reserve(options.PVC, options.PVName, pool.name)
+		span.end(err)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	encryptionSecret, err := encryptionSecretFor(options, "")
+	var tier *qosTier
+	if err == nil {
+		tier, err = p.qosTierFor(options)
+	}
+	var limits ioLimits
+	if err == nil {
+		limits, err = ioLimitsFor(options, "", tier)
+	}
+	var luks *luksVolume
+	if err == nil && encryptionSecret != "" {
+		luks = &luksVolume{name: luksName(options.PVName)}
+		luks.key, err = p.encryptionKey(encryptionSecret)
+	}
+	if err != nil {
+		if p.quota != nil {
+			p.quota.release(options.PVName)
+		}
+		return nil, err
+	}
+
+	span = trace.child("ClaimDevice")
+	device, err := p.claimedDevices.claim(p, pool, requested.Value(), options.PVName)
+	span.end(err)
+	if err != nil {
+		if p.quota != nil {
+			p.quota.release(options.PVName)
+		}
+		return nil, err
+	}
+	// Encrypted devices are used through their opened LUKS container
+	path, size := device.path, device.size
+	if luks != nil {
+		span = trace.child("EncryptDevice")
+		path, err = luksOpen(device.path, luks, true)
+		span.end(err)
+		if err != nil {
+			p.claimedDevices.release(device.path)
+			if p.quota != nil {
+				p.quota.release(options.PVName)
+			}
+			return nil, err
+		}
+		size -= luksHeaderSize
+	}
+	klog.InfoS("Handing out device", "correlationID", id, "pvc", pvc, "pv", options.PVName, "node", p.nodeName, "pool", pool.name, "device", device.path)
+	p.claimEvent(options.PVC, v1.EventTypeNormal, eventReasonDeviceAssigned, "Assigned device %s of pool %s on node %s (correlation ID %s)", device.path, pool.name, p.nodeName, id)
+
+	block := v1.PersistentVolumeBlock
+	pv := &v1.PersistentVolume{
+		ObjectMeta: metav1.ObjectMeta{
+			Name: options.PVName,
+			Annotations: map[string]string{
+				"hostPathProvisionerIdentity": p.identity,
+				"kubevirt.io/provisionOnNode": p.nodeName,
+				annRequestedCapacity:          requested.String(),
+				annStoragePool:                pool.name,
+				annPoolSelectionPolicy:        poolPolicy,
+				annCorrelationID:              id,
+				annDevice:                     device.path,
+			},
+		},
+		Spec: v1.PersistentVolumeSpec{
+			PersistentVolumeReclaimPolicy: p.reclaimPolicyFor(options),
+			AccessModes:                   options.PVC.Spec.AccessModes,
+			VolumeMode:                    &block,
+			Capacity: v1.ResourceList{
+				v1.ResourceName(v1.ResourceStorage): *resource.NewQuantity(size, resource.BinarySI),
+			},
+			PersistentVolumeSource: v1.PersistentVolumeSource{
+				Local: &v1.LocalVolumeSource{
+					Path: path,
+				},
+			},
+			NodeAffinity: nodeAffinity(p.nodeName),
+		},
+	}
+	if pool.deviceClass != "" {
+		pv.Annotations[annDeviceClass] = pool.deviceClass
+	}
+	if limits != (ioLimits{}) {
+		pv.Annotations[annIOLimits] = limits.String()
+	}
+	if tier != nil {
+		pv.Annotations[annQoSTier] = tier.name
+	}
+	if luks != nil {
+		pv.Annotations[annEncryptionSecret] = encryptionSecret
+	}
+	klog.InfoS("Provisioned volume", "correlationID", id, "pvc", pvc, "pv", options.PVName, "node", p.nodeName, "pool", pool.name, "duration", time.Since(start))
+	return pv, nil
+}
+
+// deleteDevice returns the device of the volume to its pool, erasing the
+// signatures on it first.
+func (p *HostPathProvisioner) deleteDevice(ctx context.Context, volume *v1.PersistentVolume, device, id string, start time.Time, trace *span) error {
+	klog.InfoS("Releasing device", "correlationID", id, "pv", volume.Name, "node", p.nodeName, "pool", volume.Annotations[annStoragePool], "device", device)
+	if p.runContext().Err() != nil {
+		return errShuttingDown
+	}
+	if err := ctx.Err(); err != nil {
+		return withCorrelationID(err, id)
+	}
+	if p.dryRun {
+		p.volumeEvent(volume, v1.EventTypeNormal, eventReasonDryRun, "Would release device %s on node %s", device, p.nodeName)
+		return errDryRun
+	}
+	p.allocations.setState(volume.Name, allocationDeleting)
+	var err error
+	if volume.Annotations[annEncryptionSecret] != "" {
+		span := trace.child("CloseDevice")
+		err = luksClose(luksName(volume.Name))
+		span.end(err)
+	}
+	if err == nil && p.wipeDevices {
+		span := trace.child("WipeDevice")
+		_, err = runCommand("wipefs", "--all", device)
+		span.end(err)
+	}
+	op := admin.Operation{Type: admin.OperationDelete, CorrelationID: id, PV: volume.Name, Pool: volume.Annotations[annStoragePool], Path: device}
+	if volume.Spec.ClaimRef != nil {
+		op.Claim = volume.Spec.ClaimRef.Namespace + "/" + volume.Spec.ClaimRef.Name
+	}
+	p.operations.record(op, start, err)
+	if err != nil {
+		klog.ErrorS(err, "Failed to wipe device", "correlationID", id, "pv", volume.Name, "node", p.nodeName, "device", device)
+		return withCorrelationID(err, id)
+	}
+	p.claimedDevices.release(device)
+	p.allocations.remove(volume.Name)
+	klog.InfoS("Deleted volume", "correlationID", id, "pv", volume.Name, "node", p.nodeName, "duration", time.Since(start))
+	return nil
+}
@@ -0,0 +1,116 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package provisioner
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func Test_newDevicePool(t *testing.T) {
+	if pool, err := newDevicePool(""); pool != nil || err != nil {
+		t.Errorf("newDevicePool(\"\") = %v, %v, want no pool", pool, err)
+	}
+	if pool, err := newDevicePool("nvme-*"); err != nil || pool.pattern != "nvme-*" {
+		t.Errorf("newDevicePool(nvme-*) = %v, %v", pool, err)
+	}
+	if _, err := newDevicePool("[nvme"); err == nil {
+		t.Error("newDevicePool() accepted an invalid pattern")
+	}
+}
+
+func Test_chooseDevice(t *testing.T) {
+	devices := []poolDevice{
+		{path: "/dev/hpp/large", size: 4 * GiB},
+		{path: "/dev/hpp/small", size: 1 * GiB},
+		{path: "/dev/hpp/medium", size: 2 * GiB},
+	}
+	tests := []struct {
+		name      string
+		claims    map[string]string
+		requested int64
+		want      string
+	}{
+		{name: "smallest fitting", claims: map[string]string{}, requested: GiB, want: "/dev/hpp/small"},
+		{name: "larger request", claims: map[string]string{}, requested: 3 * GiB, want: "/dev/hpp/large"},
+		{name: "smallest claimed", claims: map[string]string{"/dev/hpp/small": "pvc-1"}, requested: GiB, want: "/dev/hpp/medium"},
+		{name: "too large", claims: map[string]string{}, requested: 8 * GiB},
+		{name: "all claimed", claims: map[string]string{"/dev/hpp/large": "pvc-1"}, requested: 3 * GiB},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, ok := chooseDevice(devices, tt.claims, tt.requested)
+			if ok != (tt.want != "") || got.path != tt.want {
+				t.Errorf("chooseDevice() = %v, %t, want %s", got, ok, tt.want)
+			}
+		})
+	}
+}
+
+func Test_deviceClaims(t *testing.T) {
+	dir, err := ioutil.TempDir("", "devices")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	sizes := map[string]int64{"disk-a": 2 * GiB, "disk-b": 1 * GiB, "other": 8 * GiB}
+	for name := range sizes {
+		if err := ioutil.WriteFile(filepath.Join(dir, name), nil, 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	original := blockDeviceSize
+	defer func() { blockDeviceSize = original }()
+	blockDeviceSize = func(path string) (int64, error) {
+		if size, ok := sizes[filepath.Base(path)]; ok {
+			return size, nil
+		}
+		return 0, fmt.Errorf("%s is not a block device", path)
+	}
+
+	p := &HostPathProvisioner{nodeName: "node01"}
+	pool := &storagePool{name: "disks", path: dir, devices: &devicePool{pattern: "disk-*"}}
+	claims := &deviceClaims{loaded: true, claims: map[string]string{}}
+	if free, err := claims.largestFree(pool); err != nil || free != 2*GiB {
+		t.Errorf("largestFree() = %d, %v, want %d", free, err, 2*GiB)
+	}
+	first, err := claims.claim(p, pool, GiB, "pvc-1")
+	if err != nil || first.path != filepath.Join(dir, "disk-b") {
+		t.Fatalf("claim() = %v, %v, want disk-b", first, err)
+	}
+	// A retry gets the same device
+	if again, err := claims.claim(p, pool, GiB, "pvc-1"); err != nil || again != first {
+		t.Errorf("claim() again = %v, %v, want %v", again, err, first)
+	}
+	second, err := claims.claim(p, pool, GiB, "pvc-2")
+	if err != nil || second.path != filepath.Join(dir, "disk-a") {
+		t.Fatalf("claim() = %v, %v, want disk-a", second, err)
+	}
+	if _, err := claims.claim(p, pool, GiB, "pvc-3"); err == nil {
+		t.Error("claim() succeeded without free devices")
+	}
+	if free, err := claims.largestFree(pool); err != nil || free != 0 {
+		t.Errorf("largestFree() = %d, %v, want 0", free, err)
+	}
+	claims.release(first.path)
+	if third, err := claims.claim(p, pool, GiB, "pvc-3"); err != nil || third.path != first.path {
+		t.Errorf("claim() after release = %v, %v, want %s", third, err, first.path)
+	}
+}
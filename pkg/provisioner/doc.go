@@ -0,0 +1,31 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package provisioner is the hostpath provisioner: it provisions hostPath
+// volumes in the storage pools of the node it runs on. Run reads the
+// configuration from the config file, ConfigMap and environment of its
+// ConfigSources and provisions volumes until its context is canceled. The
+// package registers no command line flags, the command in cmd/provisioner
+// defines them and applies them to the Config with ConfigSources.Override.
+//
+// To embed the provisioner, create one with NewHostPathProvisioner from a
+// Config, starting with DefaultConfig and checked with Config.Validate, and
+// run it with a controller.ProvisionController of the name in the Config.
+// Its background loops only run under the controller-runtime manager of Run.
+//
+// Builds adding their own logic around provisioning and deleting volumes
+// register a Hook with RegisterHook before the provisioner is created.
+package provisioner // import "kubevirt.io/hostpath-provisioner/pkg/provisioner"
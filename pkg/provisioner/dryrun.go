@@ -0,0 +1,45 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package provisioner
+
+import (
+	"os"
+
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/klog/v2"
+	"sigs.k8s.io/sig-storage-lib-external-provisioner/v13/controller"
+)
+
+// errDryRun makes the controller neither create a volume for the claim nor
+// retry it; the claim is looked at again on the next resync.
+var errDryRun = &controller.IgnoredError{Reason: "dry run"}
+
+// dryRunProvision reports the backing directory that provisioning the claim
+// would create.
+func (p *HostPathProvisioner) dryRunProvision(pvc *v1.PersistentVolumeClaim, pool *storagePool, path string, mode os.FileMode, uid, gid int, id string) error {
+	klog.InfoS("Dry run, not creating backing directory", "correlationID", id, "pvc", pvc.Namespace+"/"+pvc.Name, "node", p.nodeName, "pool", pool.name, "path", path, "mode", mode.String(), "uid", uid, "gid", gid)
+	p.claimEvent(pvc, v1.EventTypeNormal, eventReasonDryRun, "Would create backing directory %s in pool %s on node %s", path, pool.name, p.nodeName)
+	return errDryRun
+}
+
+// dryRunDelete reports the backing directory that deleting the volume would
+// remove.
+func (p *HostPathProvisioner) dryRunDelete(volume *v1.PersistentVolume, path, id string) error {
+	klog.InfoS("Dry run, not removing backing directory", "correlationID", id, "pv", volume.Name, "node", p.nodeName, "pool", volume.Annotations[annStoragePool], "path", path)
+	p.volumeEvent(volume, v1.EventTypeNormal, eventReasonDryRun, "Would remove backing directory %s on node %s", path, p.nodeName)
+	return errDryRun
+}
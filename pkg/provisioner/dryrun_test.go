@@ -0,0 +1,40 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package provisioner
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"testing"
+)
+
+func Test_dryRunDelete(t *testing.T) {
+	dir, err := ioutil.TempDir("", "dryrun")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	p := &HostPathProvisioner{nodeName: "testNode", identity: "testId", dryRun: true}
+	if err := p.Delete(context.TODO(), createPv("testId", "testNode", dir)); err != errDryRun {
+		t.Errorf("Delete() in a dry run = %v, want %v", err, errDryRun)
+	}
+	if _, err := os.Stat(dir); err != nil {
+		t.Errorf("backing directory removed in a dry run: %v", err)
+	}
+}
@@ -0,0 +1,142 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package provisioner
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"k8s.io/klog/v2"
+
+	v1 "k8s.io/api/core/v1"
+	storage "k8s.io/api/storage/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/fields"
+	"k8s.io/apimachinery/pkg/types"
+)
+
+// claimEventInterval is how often the same decision is reported on a claim.
+// ShouldProvision is called for every pending claim on every resync.
+const claimEventInterval = 10 * time.Minute
+
+// Reasons of the events added to claims. Provisioning failures are reported
+// by the controller as ProvisioningFailed with the error returned by
+// Provision, the events below explain what the provisioner decided before and
+// after.
+const (
+	eventReasonNodeAnnotationMissing = "NodeAnnotationMissing"
+	eventReasonNodeDisabled          = "NodeDisabled"
+	eventReasonCapacityUnknown       = "CapacityUnknown"
+	eventReasonInsufficientCapacity  = "InsufficientCapacity"
+	eventReasonPoolOnRootfs          = "PoolOnRootFilesystem"
+	eventReasonDirectoryCreated      = "BackingDirectoryCreated"
+	eventReasonDryRun                = "DryRun"
+	// eventReasonOperationTimedOut is also added to volumes whose backing
+	// directory could not be removed in time
+	eventReasonOperationTimedOut = "FilesystemOperationTimedOut"
+)
+
+// claimEvent records an event on the claim.
+func (p *HostPathProvisioner) claimEvent(pvc *v1.PersistentVolumeClaim, eventtype, reason, messageFmt string, args ...interface{}) {
+	if p.eventRecorder == nil {
+		return
+	}
+	p.eventRecorder.Eventf(pvc, eventtype, reason, messageFmt, args...)
+}
+
+// decisionEvent records an event explaining why the claim is not provisioned
+// on this node, unless it was recorded within claimEventInterval.
+func (p *HostPathProvisioner) decisionEvent(pvc *v1.PersistentVolumeClaim, eventtype, reason, messageFmt string, args ...interface{}) {
+	if !p.claimEvents.allow(pvc.UID, reason) {
+		return
+	}
+	p.claimEvent(pvc, eventtype, reason, messageFmt, args...)
+}
+
+// clusterEvent records an event about a claim that the provisioners of all
+// nodes see the same, unless one of them already recorded it.
+func (p *HostPathProvisioner) clusterEvent(pvc *v1.PersistentVolumeClaim, eventtype, reason, messageFmt string, args ...interface{}) {
+	if !p.claimEvents.allow(pvc.UID, reason) {
+		return
+	}
+	if p.client != nil {
+		selector := fields.Set{"involvedObject.uid": string(pvc.UID), "reason": reason}.AsSelector().String()
+		events, err := p.client.CoreV1().Events(pvc.Namespace).List(context.TODO(), metav1.ListOptions{FieldSelector: selector})
+		if err != nil {
+			klog.Warningf("unable to list the events of claim %s/%s: %v", pvc.Namespace, pvc.Name, err)
+			return
+		}
+		if len(events.Items) > 0 {
+			return
+		}
+	}
+	p.claimEvent(pvc, eventtype, reason, messageFmt, args...)
+}
+
+// eventLimiter remembers when decisions were last reported on claims. A nil
+// eventLimiter lets every event through.
+type eventLimiter struct {
+	mutex sync.Mutex
+	last  map[string]time.Time
+	now   func() time.Time
+}
+
+func newEventLimiter() *eventLimiter {
+	return &eventLimiter{last: make(map[string]time.Time), now: time.Now}
+}
+
+// allow returns whether the event of reason may be recorded on the claim now,
+// and if so remembers that it was.
+func (l *eventLimiter) allow(uid types.UID, reason string) bool {
+	if l == nil {
+		return true
+	}
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	now := l.now()
+	key := string(uid) + "/" + reason
+	if last, ok := l.last[key]; ok && now.Sub(last) < claimEventInterval {
+		return false
+	}
+	// Claims are forgotten once their events may be recorded again
+	for k, last := range l.last {
+		if now.Sub(last) >= claimEventInterval {
+			delete(l.last, k)
+		}
+	}
+	l.last[key] = now
+	return true
+}
+
+// volumeEvent records an event on the volume.
+func (p *HostPathProvisioner) volumeEvent(pv *v1.PersistentVolume, eventtype, reason, messageFmt string, args ...interface{}) {
+	if p.eventRecorder == nil {
+		return
+	}
+	p.eventRecorder.Eventf(pv, eventtype, reason, messageFmt, args...)
+}
+
+// missingNodeAnnotation returns whether a claim can never be provisioned
+// because no node is named for it. Claims naming another node are not
+// reported, every provisioner but one sees those.
+func missingNodeAnnotation(annotations map[string]string, bindingMode storage.VolumeBindingMode) bool {
+	if _, ok := annotations["kubevirt.io/provisionOnNode"]; ok {
+		return false
+	}
+	return bindingMode != storage.VolumeBindingWaitForFirstConsumer
+}
@@ -0,0 +1,146 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package provisioner
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	v1 "k8s.io/api/core/v1"
+	storage "k8s.io/api/storage/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/tools/record"
+)
+
+func Test_missingNodeAnnotation(t *testing.T) {
+	tests := []struct {
+		name        string
+		annotations map[string]string
+		bindingMode storage.VolumeBindingMode
+		want        bool
+	}{
+		{name: "immediate without annotation", annotations: map[string]string{}, bindingMode: storage.VolumeBindingImmediate, want: true},
+		{name: "immediate with annotation", annotations: getKubevirtNodeAnnotation("other-node"), bindingMode: storage.VolumeBindingImmediate, want: false},
+		{name: "wait for first consumer", annotations: map[string]string{}, bindingMode: storage.VolumeBindingWaitForFirstConsumer, want: false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := missingNodeAnnotation(tt.annotations, tt.bindingMode); got != tt.want {
+				t.Errorf("missingNodeAnnotation() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_ShouldProvisionEvents(t *testing.T) {
+	dir, err := ioutil.TempDir("", "events")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	tests := []struct {
+		name        string
+		annotations map[string]string
+		requested   string
+		onRootfs    bool
+		wantReason  string
+	}{
+		{name: "no node annotation", annotations: map[string]string{}, requested: "1Gi", wantReason: eventReasonNodeAnnotationMissing},
+		{name: "other node", annotations: getKubevirtNodeAnnotation("other-node"), requested: "1Gi"},
+		{name: "too large", annotations: getKubevirtNodeAnnotation("test-node"), requested: "1Ei", wantReason: eventReasonInsufficientCapacity},
+		{name: "fits", annotations: getKubevirtNodeAnnotation("test-node"), requested: "1Ki"},
+		{name: "pool on root filesystem", annotations: getKubevirtNodeAnnotation("test-node"), requested: "1Ki", onRootfs: true, wantReason: eventReasonPoolOnRootfs},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			recorder := record.NewFakeRecorder(10)
+			p := &HostPathProvisioner{
+				host:          hostBackend(),
+				pools:         []*storagePool{{name: defaultPoolName, path: dir}},
+				nodeName:      "test-node",
+				allowRootfs:   !tt.onRootfs,
+				rootfsPath:    dir,
+				rounding:      defaultCapacityRounding,
+				eventRecorder: recorder,
+				claimEvents:   newEventLimiter(),
+			}
+			pvc := &v1.PersistentVolumeClaim{
+				ObjectMeta: metav1.ObjectMeta{Name: "claim", Namespace: "default", Annotations: tt.annotations},
+				Spec: v1.PersistentVolumeClaimSpec{
+					Resources: v1.VolumeResourceRequirements{
+						Requests: v1.ResourceList{v1.ResourceStorage: resource.MustParse(tt.requested)},
+					},
+				},
+			}
+			bindingMode := storage.VolumeBindingImmediate
+			p.shouldProvision(context.TODO(), pvc, &bindingMode)
+
+			select {
+			case event := <-recorder.Events:
+				if tt.wantReason == "" || !strings.Contains(event, tt.wantReason) {
+					t.Errorf("unexpected event %q, want reason %q", event, tt.wantReason)
+				}
+			default:
+				if tt.wantReason != "" {
+					t.Errorf("no event, want reason %q", tt.wantReason)
+				}
+			}
+
+			// The next resync does not report the same decision again
+			p.shouldProvision(context.TODO(), pvc, &bindingMode)
+			select {
+			case event := <-recorder.Events:
+				t.Errorf("event %q recorded again on the next resync", event)
+			default:
+			}
+		})
+	}
+}
+
+func Test_eventLimiter(t *testing.T) {
+	now := time.Now()
+	l := newEventLimiter()
+	l.now = func() time.Time { return now }
+
+	if !l.allow("uid-1", eventReasonInsufficientCapacity) {
+		t.Error("allow() of the first event = false")
+	}
+	if l.allow("uid-1", eventReasonInsufficientCapacity) {
+		t.Error("allow() of the same event on the next resync = true")
+	}
+	if !l.allow("uid-1", eventReasonNodeDisabled) || !l.allow("uid-2", eventReasonInsufficientCapacity) {
+		t.Error("allow() of another reason or claim = false")
+	}
+	now = now.Add(claimEventInterval)
+	if !l.allow("uid-1", eventReasonInsufficientCapacity) {
+		t.Error("allow() after the interval = false")
+	}
+	if len(l.last) != 1 {
+		t.Errorf("limiter remembers %d events, want only the one just allowed", len(l.last))
+	}
+
+	var none *eventLimiter
+	if !none.allow("uid-1", eventReasonInsufficientCapacity) || !none.allow("uid-1", eventReasonInsufficientCapacity) {
+		t.Error("allow() without a limiter = false")
+	}
+}
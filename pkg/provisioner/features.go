@@ -0,0 +1,189 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package provisioner
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"k8s.io/klog/v2"
+)
+
+// featureStage tells how mature a feature is. Alpha features are off by
+// default and may change or go away, beta features are on by default.
+type featureStage string
+
+const (
+	alpha featureStage = "ALPHA"
+	beta  featureStage = "BETA"
+)
+
+// Features that can be turned on and off with --feature-gates.
+const (
+	// featureVolumeOwnership lets claims choose the owner and fsGroup of
+	// their backing directory with annotations
+	featureVolumeOwnership = "VolumeOwnership"
+	// featureSELinuxRelabel lets the volume health checks restore the
+	// SELinux context of backing directories
+	featureSELinuxRelabel = "SELinuxRelabel"
+)
+
+type featureSpec struct {
+	enabled bool
+	stage   featureStage
+}
+
+var knownFeatures = map[string]featureSpec{
+	featureVolumeOwnership: {enabled: true, stage: beta},
+	featureSELinuxRelabel:  {enabled: false, stage: alpha},
+}
+
+// featureGate records which features are enabled. It is set from the feature
+// gates of the configuration, or a list such as Name=true,Other=false.
+type featureGate struct {
+	mutex   sync.RWMutex
+	known   map[string]featureSpec
+	enabled map[string]bool
+}
+
+func newFeatureGate(known map[string]featureSpec) *featureGate {
+	return &featureGate{known: known, enabled: map[string]bool{}}
+}
+
+// ParseFeatureGates parses a comma separated list of Name=true|false pairs,
+// the features of the provisioner turned on or off, e.g. for the
+// FeatureGates of Config. Unknown features are rejected so that typos do not
+// go unnoticed.
+func ParseFeatureGates(value string) (map[string]bool, error) {
+	return newFeatureGate(knownFeatures).parse(value)
+}
+
+// DescribeFeatureGates lists the features of the provisioner with their
+// stage and default.
+func DescribeFeatureGates() string {
+	return newFeatureGate(knownFeatures).describe()
+}
+
+// Set turns on and off the features of a comma separated list of
+// Name=true|false pairs.
+func (f *featureGate) Set(value string) error {
+	enabled, err := f.parse(value)
+	if err != nil {
+		return err
+	}
+	return f.set(enabled)
+}
+
+// parse parses a comma separated list of Name=true|false pairs.
+func (f *featureGate) parse(value string) (map[string]bool, error) {
+	enabled := map[string]bool{}
+	for _, entry := range strings.Split(value, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		parts := strings.SplitN(entry, "=", 2)
+		if len(parts) != 2 {
+			return nil, fmt.Errorf("invalid feature gate %q, expected Name=true|false", entry)
+		}
+		name := strings.TrimSpace(parts[0])
+		if err := f.validate(name); err != nil {
+			return nil, err
+		}
+		on, err := strconv.ParseBool(strings.TrimSpace(parts[1]))
+		if err != nil {
+			return nil, fmt.Errorf("invalid value %q for feature gate %s", parts[1], name)
+		}
+		enabled[name] = on
+	}
+	return enabled, nil
+}
+
+// validate returns an error unless the feature is known.
+func (f *featureGate) validate(name string) error {
+	if _, ok := f.known[name]; !ok {
+		return fmt.Errorf("unknown feature gate %q", name)
+	}
+	return nil
+}
+
+// set turns the features on or off, the others keep their state.
+func (f *featureGate) set(enabled map[string]bool) error {
+	for name := range enabled {
+		if err := f.validate(name); err != nil {
+			return err
+		}
+	}
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	for name, on := range enabled {
+		f.enabled[name] = on
+	}
+	return nil
+}
+
+// String returns the features set explicitly, in the format Set accepts.
+func (f *featureGate) String() string {
+	if f == nil {
+		return ""
+	}
+	f.mutex.RLock()
+	defer f.mutex.RUnlock()
+	var pairs []string
+	for name, on := range f.enabled {
+		pairs = append(pairs, fmt.Sprintf("%s=%t", name, on))
+	}
+	sort.Strings(pairs)
+	return strings.Join(pairs, ",")
+}
+
+// Enabled returns whether the feature is on, explicitly or by default. All
+// features have their default in a nil gate.
+func (f *featureGate) Enabled(name string) bool {
+	if f == nil {
+		return knownFeatures[name].enabled
+	}
+	f.mutex.RLock()
+	defer f.mutex.RUnlock()
+	if on, ok := f.enabled[name]; ok {
+		return on
+	}
+	return f.known[name].enabled
+}
+
+// describe lists the known features with their stage and default.
+func (f *featureGate) describe() string {
+	var descriptions []string
+	for name, spec := range f.known {
+		descriptions = append(descriptions, fmt.Sprintf("%s=true|false (%s - default=%t)", name, spec.stage, spec.enabled))
+	}
+	sort.Strings(descriptions)
+	return strings.Join(descriptions, ", ")
+}
+
+// logEnabled logs the alpha features that are turned on, they are not meant
+// for production use.
+func (f *featureGate) logEnabled() {
+	for name, spec := range f.known {
+		if spec.stage == alpha && f.Enabled(name) {
+			klog.InfoS("Alpha feature enabled", "feature", name)
+		}
+	}
+}
@@ -0,0 +1,50 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package provisioner
+
+import (
+	"testing"
+)
+
+func Test_featureGate(t *testing.T) {
+	gate := newFeatureGate(map[string]featureSpec{
+		"Stable":       {enabled: true, stage: beta},
+		"Experimental": {enabled: false, stage: alpha},
+	})
+	if !gate.Enabled("Stable") || gate.Enabled("Experimental") || gate.Enabled("Unknown") {
+		t.Errorf("defaults not applied: %s", gate)
+	}
+	if err := gate.Set("Experimental=true, Stable=false"); err != nil {
+		t.Fatal(err)
+	}
+	if gate.Enabled("Stable") || !gate.Enabled("Experimental") {
+		t.Errorf("gates not set: %s", gate)
+	}
+	if got := gate.String(); got != "Experimental=true,Stable=false" {
+		t.Errorf("String() = %s", got)
+	}
+
+	for _, value := range []string{"Unknown=true", "Experimental", "Experimental=maybe"} {
+		if err := gate.Set(value); err == nil {
+			t.Errorf("Set(%q) accepted an invalid value", value)
+		}
+	}
+	// A rejected value changes nothing
+	if err := gate.Set("Stable=true,Unknown=true"); err == nil || gate.Enabled("Stable") {
+		t.Errorf("Set() applied part of an invalid value, error = %v", err)
+	}
+}
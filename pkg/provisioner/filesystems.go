@@ -0,0 +1,133 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package provisioner
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strings"
+	"sync"
+
+	"sigs.k8s.io/sig-storage-lib-external-provisioner/v13/controller"
+)
+
+const (
+	// annImageFilesystem records the filesystem of an image backed volume.
+	annImageFilesystem = "kubevirt.io/imageFilesystem"
+
+	// fsTypeParameter is the StorageClass parameter choosing the filesystem
+	// image backed volumes are formatted with.
+	fsTypeParameter = "fsType"
+	// mkfsOptionsParameter is the StorageClass parameter holding extra mkfs
+	// options for image backed volumes, e.g. "-m reflink=1,bigtime=1".
+	mkfsOptionsParameter = "mkfsOptions"
+
+	defaultImageFilesystem = "ext4"
+
+	// mkfsProbeSize is the size of the sparse file mkfs options are tried on,
+	// large enough for the minimum size of every filesystem.
+	mkfsProbeSize = 512 * MiB
+)
+
+// imageFilesystems are the filesystems image files can be formatted with.
+var imageFilesystems = map[string]bool{"ext4": true, "xfs": true, "btrfs": true}
+
+// imageFilesystem is the filesystem, and the options to create and mount it
+// with, of image backed volumes.
+type imageFilesystem struct {
+	fsType       string
+	mkfsOptions  []string
+	mountOptions []string
+}
+
+func (fs imageFilesystem) String() string {
+	return strings.Join(append([]string{fs.fsType}, fs.mkfsOptions...), " ")
+}
+
+// imageFilesystemFor returns the filesystem the StorageClass asks image backed
+// volumes to be formatted with. Classes of other volumes can not choose one.
+func imageFilesystemFor(options controller.ProvisionOptions, backing string) (imageFilesystem, error) {
+	fs := imageFilesystem{fsType: defaultImageFilesystem}
+	if options.StorageClass == nil {
+		return fs, nil
+	}
+	fsType, hasFSType := options.StorageClass.Parameters[fsTypeParameter]
+	mkfsOptions, hasOptions := options.StorageClass.Parameters[mkfsOptionsParameter]
+	if (hasFSType || hasOptions) && backing != backingImage {
+		return fs, fmt.Errorf("storage class %s sets %s or %s, which only apply to image backed volumes", options.StorageClass.Name, fsTypeParameter, mkfsOptionsParameter)
+	}
+	if fsType != "" {
+		if !imageFilesystems[fsType] {
+			return fs, fmt.Errorf("unsupported %s %q in storage class %s, expected ext4, xfs or btrfs", fsTypeParameter, fsType, options.StorageClass.Name)
+		}
+		fs.fsType = fsType
+	}
+	if options := strings.Fields(mkfsOptions); len(options) > 0 {
+		fs.mkfsOptions = options
+	}
+	return fs, nil
+}
+
+// mkfsProbe remembers which filesystems and options the node's mkfs accepts,
+// they are tried once on a scratch file before the first volume is created
+// with them, so that a class asking for a feature the tools do not know, e.g.
+// an old mkfs.xfs without bigtime, fails clearly instead of leaving images
+// behind.
+type mkfsProbe struct {
+	mutex   sync.Mutex
+	results map[string]error
+}
+
+// mkfsProbes are shared by the provisioners of the process, what mkfs
+// supports is the same for all of them.
+var mkfsProbes = &mkfsProbe{results: map[string]error{}}
+
+// check returns whether mkfs can create the filesystem on this node.
+func (m *mkfsProbe) check(fs imageFilesystem) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	key := fs.String()
+	if err, ok := m.results[key]; ok {
+		return err
+	}
+	err := probeMkfs(fs)
+	if err != nil {
+		err = fmt.Errorf("mkfs on node does not support %s: %v", key, err)
+	}
+	m.results[key] = err
+	return err
+}
+
+// probeMkfs formats a sparse scratch file with the filesystem.
+func probeMkfs(fs imageFilesystem) error {
+	file, err := ioutil.TempFile("", "mkfs-probe")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(file.Name())
+	err = file.Truncate(mkfsProbeSize)
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return err
+	}
+	args := append(append([]string{"-t", fs.fsType}, fs.mkfsOptions...), file.Name())
+	_, err = runCommand("mkfs", args...)
+	return err
+}
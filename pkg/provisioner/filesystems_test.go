@@ -0,0 +1,90 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package provisioner
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+
+	storagev1 "k8s.io/api/storage/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/sig-storage-lib-external-provisioner/v13/controller"
+)
+
+func Test_imageFilesystemFor(t *testing.T) {
+	class := func(parameters map[string]string) *storagev1.StorageClass {
+		return &storagev1.StorageClass{ObjectMeta: metav1.ObjectMeta{Name: "hostpath"}, Parameters: parameters}
+	}
+	tests := []struct {
+		name    string
+		class   *storagev1.StorageClass
+		backing string
+		want    imageFilesystem
+		wantErr bool
+	}{
+		{name: "no class", backing: backingImage, want: imageFilesystem{fsType: "ext4"}},
+		{name: "default", class: class(nil), backing: backingImage, want: imageFilesystem{fsType: "ext4"}},
+		{name: "xfs with options", class: class(map[string]string{fsTypeParameter: "xfs", mkfsOptionsParameter: " -m reflink=1,bigtime=1 "}), backing: backingImage, want: imageFilesystem{fsType: "xfs", mkfsOptions: []string{"-m", "reflink=1,bigtime=1"}}},
+		{name: "btrfs", class: class(map[string]string{fsTypeParameter: "btrfs"}), backing: backingImage, want: imageFilesystem{fsType: "btrfs"}},
+		{name: "options only", class: class(map[string]string{mkfsOptionsParameter: "-O ^has_journal"}), backing: backingImage, want: imageFilesystem{fsType: "ext4", mkfsOptions: []string{"-O", "^has_journal"}}},
+		{name: "unsupported", class: class(map[string]string{fsTypeParameter: "vfat"}), backing: backingImage, wantErr: true},
+		{name: "directories", class: class(map[string]string{fsTypeParameter: "xfs"}), backing: backingDirectory, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := imageFilesystemFor(controller.ProvisionOptions{StorageClass: tt.class}, tt.backing)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("imageFilesystemFor() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !tt.wantErr && !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("imageFilesystemFor() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_mkfsProbe(t *testing.T) {
+	var commands []string
+	original := runCommand
+	defer func() { runCommand = original }()
+	runCommand = func(name string, args ...string) ([]byte, error) {
+		// The scratch file is the last argument
+		commands = append(commands, strings.Join(append([]string{name}, args[:len(args)-1]...), " "))
+		if args[1] == "btrfs" {
+			return nil, fmt.Errorf("mkfs.btrfs not found")
+		}
+		return nil, nil
+	}
+	probes := &mkfsProbe{results: map[string]error{}}
+	xfs := imageFilesystem{fsType: "xfs", mkfsOptions: []string{"-m", "bigtime=1"}}
+	btrfs := imageFilesystem{fsType: "btrfs"}
+	for i := 0; i < 2; i++ {
+		if err := probes.check(xfs); err != nil {
+			t.Errorf("check(xfs) = %v, want supported", err)
+		}
+		if err := probes.check(btrfs); err == nil {
+			t.Error("check(btrfs) succeeded with failing mkfs")
+		}
+	}
+	// The results are remembered
+	want := []string{"mkfs -t xfs -m bigtime=1", "mkfs -t btrfs"}
+	if !reflect.DeepEqual(commands, want) {
+		t.Errorf("commands = %q, want %q", commands, want)
+	}
+}
@@ -0,0 +1,222 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package provisioner
+
+import (
+	"context"
+	"crypto/sha512"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"path/filepath"
+	"syscall"
+	"unsafe"
+
+	"k8s.io/klog/v2"
+
+	v1 "k8s.io/api/core/v1"
+)
+
+// annFscryptKey records the identifier of the fscrypt key, hex encoded, the
+// backing directory of an encrypted directory volume is encrypted with.
+const annFscryptKey = "kubevirt.io/fscryptKey"
+
+// The fscrypt v2 interface of the kernel, see linux/fscrypt.h. Keys are added
+// to the filesystem, not to the keyring of a process, so that the pods using
+// the directories can read them.
+const (
+	fsIocSetEncryptionPolicy = 0x800c6613
+	fsIocAddEncryptionKey    = 0xc0506617
+	fsIocRemoveEncryptionKey = 0xc0406618
+
+	fscryptKeySpecTypeIdentifier = 2
+	fscryptKeyIdentifierSize     = 16
+	// fscryptKeySpecifierSize is the size of struct fscrypt_key_specifier
+	fscryptKeySpecifierSize = 40
+	// fscryptAddKeyArgSize is the size of struct fscrypt_add_key_arg without
+	// the raw key following it
+	fscryptAddKeyArgSize = 80
+	// fscryptRemoveKeyArgSize is the size of struct fscrypt_remove_key_arg
+	fscryptRemoveKeyArgSize = 64
+
+	fscryptPolicyV2         = 2
+	fscryptModeAES256XTS    = 1
+	fscryptModeAES256CTS    = 4
+	fscryptPolicyFlagsPad32 = 0x03
+	fscryptPolicyV2Size     = 24
+	fscryptMasterKeySize    = sha512.Size
+)
+
+// fscryptIoctl issues an fscrypt ioctl on the open file, arg is its argument.
+var fscryptIoctl = func(file *os.File, request uintptr, arg []byte) error {
+	return ioctl(file.Fd(), request, uintptr(unsafe.Pointer(&arg[0])))
+}
+
+// fscryptMasterKey derives the master key, long enough for AES-256-XTS, from
+// the key in the Secret.
+func fscryptMasterKey(key []byte) []byte {
+	sum := sha512.Sum512(key)
+	return sum[:]
+}
+
+// addKeyArg returns the struct fscrypt_add_key_arg adding the master key.
+func addKeyArg(masterKey []byte) []byte {
+	arg := make([]byte, fscryptAddKeyArgSize+len(masterKey))
+	binary.LittleEndian.PutUint32(arg[0:], fscryptKeySpecTypeIdentifier)
+	binary.LittleEndian.PutUint32(arg[fscryptKeySpecifierSize:], uint32(len(masterKey)))
+	copy(arg[fscryptAddKeyArgSize:], masterKey)
+	return arg
+}
+
+// removeKeyArg returns the struct fscrypt_remove_key_arg removing the key.
+func removeKeyArg(identifier []byte) []byte {
+	arg := make([]byte, fscryptRemoveKeyArgSize)
+	binary.LittleEndian.PutUint32(arg[0:], fscryptKeySpecTypeIdentifier)
+	copy(arg[8:], identifier)
+	return arg
+}
+
+// policyV2 returns the struct fscrypt_policy_v2 encrypting with the key, with
+// the modes the fscrypt tool uses by default.
+func policyV2(identifier []byte) []byte {
+	policy := make([]byte, fscryptPolicyV2Size)
+	policy[0] = fscryptPolicyV2
+	policy[1] = fscryptModeAES256XTS
+	policy[2] = fscryptModeAES256CTS
+	policy[3] = fscryptPolicyFlagsPad32
+	copy(policy[8:], identifier)
+	return policy
+}
+
+// addFscryptKey adds the master key derived from key to the filesystem
+// holding dir and returns its identifier. Adding a key again is harmless.
+func addFscryptKey(dir string, key []byte) ([]byte, error) {
+	file, err := os.Open(dir)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	arg := addKeyArg(fscryptMasterKey(key))
+	if err := fscryptIoctl(file, fsIocAddEncryptionKey, arg); err != nil {
+		return nil, fmt.Errorf("unable to add encryption key to the filesystem of %s: %v", dir, err)
+	}
+	// The kernel returns the identifier in the key specifier
+	identifier := make([]byte, fscryptKeyIdentifierSize)
+	copy(identifier, arg[8:8+fscryptKeyIdentifierSize])
+	return identifier, nil
+}
+
+// removeFscryptKey removes the key from the filesystem holding dir, the
+// directories encrypted with it can no longer be read.
+func removeFscryptKey(dir string, identifier []byte) error {
+	file, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	if err := fscryptIoctl(file, fsIocRemoveEncryptionKey, removeKeyArg(identifier)); err != nil && err != errNoKey {
+		return fmt.Errorf("unable to remove encryption key from the filesystem of %s: %v", dir, err)
+	}
+	return nil
+}
+
+// encryptDirectory has the empty directory encrypted with the key. A directory
+// encrypted with the key by an earlier attempt is left alone.
+func encryptDirectory(dir string, identifier []byte) error {
+	file, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	if err := fscryptIoctl(file, fsIocSetEncryptionPolicy, policyV2(identifier)); err != nil {
+		if err == syscall.EOPNOTSUPP || err == syscall.ENOTTY {
+			return fmt.Errorf("unable to encrypt %s, its filesystem does not support encryption, e.g. an ext4 filesystem without the encrypt feature: %v", dir, err)
+		}
+		return fmt.Errorf("unable to encrypt %s: %v", dir, err)
+	}
+	return nil
+}
+
+// encryptBackingDir encrypts the backing directory with the key, added to
+// the filesystem of the pool, and returns the identifier of the key.
+func encryptBackingDir(path string, key []byte) (string, error) {
+	identifier, err := addFscryptKey(filepath.Dir(path), key)
+	if err != nil {
+		return "", err
+	}
+	if err := encryptDirectory(path, identifier); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(identifier), nil
+}
+
+// addVolumeFscryptKey adds the key of an encrypted directory volume of this
+// node to its filesystem, e.g. after a reboot. Without the key the directory
+// stays encrypted, its names are scrambled and nothing can be written to it.
+func (p *HostPathProvisioner) addVolumeFscryptKey(pv v1.PersistentVolume) error {
+	secret, path := pv.Annotations[annEncryptionSecret], backingPath(&pv)
+	if pv.Annotations[annFscryptKey] == "" || secret == "" || path == "" || !p.ownsIdentity(pv.Annotations["hostPathProvisionerIdentity"]) || pv.Annotations["kubevirt.io/provisionOnNode"] != p.nodeName {
+		return nil
+	}
+	key, err := p.encryptionKey(secret)
+	if err != nil {
+		p.locked.set(pv.Name, true)
+		p.volumeEvent(&pv, v1.EventTypeWarning, eventReasonEncryptionKeyUnavailable, "Backing directory %s on node %s stays encrypted: %v", path, p.nodeName, err)
+		return err
+	}
+	if _, err := addFscryptKey(filepath.Dir(path), key); err != nil {
+		return err
+	}
+	p.locked.set(pv.Name, false)
+	return nil
+}
+
+// addFscryptKeys adds the keys of the encrypted directory volumes of this
+// node to their filesystems, the kernel forgets them on reboot.
+func (p *HostPathProvisioner) addFscryptKeys() {
+	pvs, err := p.pvCache.list(context.TODO(), p.client)
+	if err != nil {
+		klog.Warningf("unable to list persistent volumes, not adding encryption keys: %v", err)
+		return
+	}
+	for _, pv := range pvs.Items {
+		if err := p.addVolumeFscryptKey(pv); err != nil {
+			klog.ErrorS(err, "Failed to add encryption key", "node", p.nodeName, "pv", pv.Name, "path", backingPath(&pv))
+		}
+	}
+}
+
+// releaseFscryptKey removes the key of the deleted volume from the filesystem
+// of its pool, unless other volumes of the node are encrypted with it too.
+func (p *HostPathProvisioner) releaseFscryptKey(volume *v1.PersistentVolume, path string) error {
+	id := volume.Annotations[annFscryptKey]
+	pvs, err := p.pvCache.list(context.TODO(), p.client)
+	if err != nil {
+		return fmt.Errorf("unable to list persistent volumes to find the users of encryption key %s: %v", id, err)
+	}
+	for _, pv := range pvs.Items {
+		if pv.Name != volume.Name && pv.Annotations[annFscryptKey] == id && pv.Annotations["kubevirt.io/provisionOnNode"] == p.nodeName {
+			return nil
+		}
+	}
+	identifier, err := hex.DecodeString(id)
+	if err != nil || len(identifier) != fscryptKeyIdentifierSize {
+		return fmt.Errorf("invalid encryption key identifier %q", id)
+	}
+	return removeFscryptKey(filepath.Dir(path), identifier)
+}
@@ -0,0 +1,95 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package provisioner
+
+import (
+	"bytes"
+	"encoding/hex"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"syscall"
+	"testing"
+)
+
+func Test_fscryptStructs(t *testing.T) {
+	identifier := bytes.Repeat([]byte{0xab}, fscryptKeyIdentifierSize)
+	masterKey := fscryptMasterKey([]byte("secret"))
+	if len(masterKey) != 64 {
+		t.Fatalf("master key of %d bytes, AES-256-XTS needs 64", len(masterKey))
+	}
+	add := addKeyArg(masterKey)
+	if len(add) != 80+64 || add[0] != fscryptKeySpecTypeIdentifier || add[40] != 64 || !bytes.Equal(add[80:], masterKey) {
+		t.Errorf("addKeyArg() = %x", add)
+	}
+	remove := removeKeyArg(identifier)
+	if len(remove) != 64 || remove[0] != fscryptKeySpecTypeIdentifier || !bytes.Equal(remove[8:24], identifier) {
+		t.Errorf("removeKeyArg() = %x", remove)
+	}
+	want := append([]byte{2, 1, 4, 3, 0, 0, 0, 0}, identifier...)
+	if policy := policyV2(identifier); !bytes.Equal(policy, want) {
+		t.Errorf("policyV2() = %x, want %x", policy, want)
+	}
+}
+
+func Test_encryptBackingDir(t *testing.T) {
+	dir, err := ioutil.TempDir("", "fscrypt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "pvc-1")
+	if err := os.Mkdir(path, 0777); err != nil {
+		t.Fatal(err)
+	}
+	identifier := bytes.Repeat([]byte{0x42}, fscryptKeyIdentifierSize)
+	var requests []uintptr
+	original := fscryptIoctl
+	defer func() { fscryptIoctl = original }()
+	supported := true
+	fscryptIoctl = func(file *os.File, request uintptr, arg []byte) error {
+		requests = append(requests, request)
+		switch request {
+		case fsIocAddEncryptionKey:
+			// The kernel fills in the identifier of the key
+			copy(arg[8:], identifier)
+		case fsIocSetEncryptionPolicy:
+			if !supported {
+				return syscall.EOPNOTSUPP
+			}
+			if file.Name() != path || !bytes.Equal(arg[8:], identifier) {
+				t.Errorf("policy %x set on %s", arg, file.Name())
+			}
+		}
+		return nil
+	}
+
+	got, err := encryptBackingDir(path, []byte("secret"))
+	if err != nil {
+		t.Fatalf("encryptBackingDir() failed: %v", err)
+	}
+	if got != hex.EncodeToString(identifier) {
+		t.Errorf("encryptBackingDir() = %s, want %x", got, identifier)
+	}
+	if len(requests) != 2 || requests[0] != fsIocAddEncryptionKey || requests[1] != fsIocSetEncryptionPolicy {
+		t.Errorf("ioctls = %x", requests)
+	}
+	supported = false
+	if _, err := encryptBackingDir(path, []byte("secret")); err == nil {
+		t.Errorf("encryptBackingDir() succeeded on a filesystem without encryption")
+	}
+}
@@ -0,0 +1,122 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package provisioner
+
+import (
+	"encoding/binary"
+	"fmt"
+	"os"
+	"strconv"
+	"syscall"
+
+	"k8s.io/klog/v2"
+	"sigs.k8s.io/sig-storage-lib-external-provisioner/v13/controller"
+)
+
+const (
+	// fsGroupParameter is the StorageClass parameter naming the group the
+	// pods using its volumes run with, their securityContext.fsGroup.
+	fsGroupParameter = "fsGroup"
+	// annFSGroup on a claim does the same for a single claim, the group must
+	// be one of the allowed GIDs.
+	annFSGroup = "kubevirt.io/fsGroup"
+
+	// aclDefaultXattr holds the default POSIX ACL of a directory.
+	aclDefaultXattr = "system.posix_acl_default"
+)
+
+// POSIX ACL extended attribute format, see include/uapi/linux/posix_acl_xattr.h
+const (
+	aclVersion   = 2
+	aclUserObj   = 0x01
+	aclGroupObj  = 0x04
+	aclGroup     = 0x08
+	aclMask      = 0x10
+	aclOther     = 0x20
+	aclUndefined = 0xffffffff
+)
+
+// fsGroupFor returns the fsGroup the backing directory of a claim is prepared
+// for, -1 when there is none. The claim's annotation takes precedence over the
+// StorageClass parameter.
+//
+// Kubelet does not apply fsGroup to hostPath volumes, so the provisioner does
+// what it would have done: the directory is owned by the group, has the setgid
+// bit so that files created in it inherit the group, and has a default ACL
+// giving the group access to them.
+func (p *HostPathProvisioner) fsGroupFor(options controller.ProvisionOptions) (int, error) {
+	if options.PVC != nil {
+		if _, ok := options.PVC.Annotations[annFSGroup]; ok {
+			_, allowedGIDs := p.currentAllowedOwners()
+			return p.requestedID(options.PVC, annFSGroup, allowedGIDs)
+		}
+	}
+	if options.StorageClass == nil {
+		return -1, nil
+	}
+	value, ok := options.StorageClass.Parameters[fsGroupParameter]
+	if !ok {
+		return -1, nil
+	}
+	gid, err := strconv.Atoi(value)
+	if err != nil || gid < 0 {
+		return -1, fmt.Errorf("invalid %s parameter %q, expected a numeric group ID", fsGroupParameter, value)
+	}
+	return gid, nil
+}
+
+// fsGroupMode returns mode with the group permissions and the setgid bit an
+// fsGroup volume needs.
+func fsGroupMode(mode os.FileMode) os.FileMode {
+	return mode | 0070 | os.ModeSetgid
+}
+
+// defaultGroupACL encodes a default ACL giving gid full access to everything
+// created in a directory, the other entries follow mode.
+func defaultGroupACL(gid int, mode os.FileMode) []byte {
+	entries := []struct {
+		tag  uint16
+		perm uint16
+		id   uint32
+	}{
+		{aclUserObj, uint16(mode>>6) & 07, aclUndefined},
+		{aclGroupObj, uint16(mode>>3) & 07, aclUndefined},
+		{aclGroup, 07, uint32(gid)},
+		{aclMask, 07, aclUndefined},
+		{aclOther, uint16(mode) & 07, aclUndefined},
+	}
+	buf := make([]byte, 4+8*len(entries))
+	binary.LittleEndian.PutUint32(buf, aclVersion)
+	for i, entry := range entries {
+		offset := 4 + 8*i
+		binary.LittleEndian.PutUint16(buf[offset:], entry.tag)
+		binary.LittleEndian.PutUint16(buf[offset+2:], entry.perm)
+		binary.LittleEndian.PutUint32(buf[offset+4:], entry.id)
+	}
+	return buf
+}
+
+// setDefaultGroupACL gives gid access to the files created in dir. Filesystems
+// without ACL support are not an error, the setgid bit still applies.
+func setDefaultGroupACL(dir string, gid int, mode os.FileMode) error {
+	err := setxattr(dir, aclDefaultXattr, defaultGroupACL(gid, mode), 0)
+	if err == syscall.ENOTSUP || err == syscall.EOPNOTSUPP {
+		klog.V(2).InfoS("Filesystem does not support ACLs, not setting a default ACL", "path", dir)
+		return nil
+	}
+	return err
+}
@@ -0,0 +1,88 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package provisioner
+
+import (
+	"bytes"
+	"os"
+	"syscall"
+	"testing"
+
+	"sigs.k8s.io/sig-storage-lib-external-provisioner/v13/controller"
+
+	v1 "k8s.io/api/core/v1"
+	storage "k8s.io/api/storage/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func Test_fsGroupFor(t *testing.T) {
+	class := &storage.StorageClass{Parameters: map[string]string{fsGroupParameter: "2000"}}
+	claim := func(annotations map[string]string) *v1.PersistentVolumeClaim {
+		return &v1.PersistentVolumeClaim{ObjectMeta: metav1.ObjectMeta{Annotations: annotations}}
+	}
+	tests := []struct {
+		name    string
+		options controller.ProvisionOptions
+		want    int
+		wantErr bool
+	}{
+		{name: "none", options: controller.ProvisionOptions{PVC: claim(nil)}, want: -1},
+		{name: "storage class", options: controller.ProvisionOptions{PVC: claim(nil), StorageClass: class}, want: 2000},
+		{name: "annotation wins", options: controller.ProvisionOptions{PVC: claim(map[string]string{annFSGroup: "107"}), StorageClass: class}, want: 107},
+		{name: "annotation not allowed", options: controller.ProvisionOptions{PVC: claim(map[string]string{annFSGroup: "0"})}, wantErr: true},
+		{name: "invalid parameter", options: controller.ProvisionOptions{PVC: claim(nil), StorageClass: &storage.StorageClass{Parameters: map[string]string{fsGroupParameter: "users"}}}, wantErr: true},
+	}
+	p := &HostPathProvisioner{allowedGIDs: idRanges{{min: 107, max: 107}}}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := p.fsGroupFor(tt.options)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("fsGroupFor() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !tt.wantErr && got != tt.want {
+				t.Errorf("fsGroupFor() = %d, want %d", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_defaultGroupACL(t *testing.T) {
+	if got := fsGroupMode(0750); got != 0770|os.ModeSetgid {
+		t.Errorf("fsGroupMode(0750) = %v", got)
+	}
+	// What setfacl -d -m g:2000:rwx writes for a 2775 directory
+	want := []byte{
+		0x02, 0x00, 0x00, 0x00,
+		0x01, 0x00, 0x07, 0x00, 0xff, 0xff, 0xff, 0xff,
+		0x04, 0x00, 0x07, 0x00, 0xff, 0xff, 0xff, 0xff,
+		0x08, 0x00, 0x07, 0x00, 0xd0, 0x07, 0x00, 0x00,
+		0x10, 0x00, 0x07, 0x00, 0xff, 0xff, 0xff, 0xff,
+		0x20, 0x00, 0x05, 0x00, 0xff, 0xff, 0xff, 0xff,
+	}
+	if got := defaultGroupACL(2000, 0775|os.ModeSetgid); !bytes.Equal(got, want) {
+		t.Errorf("defaultGroupACL() = %x, want %x", got, want)
+	}
+
+	original := setxattr
+	defer func() { setxattr = original }()
+	setxattr = func(path, name string, value []byte, flags int) error {
+		return syscall.EOPNOTSUPP
+	}
+	if err := setDefaultGroupACL("/nonexistent", 2000, 0770); err != nil {
+		t.Errorf("setDefaultGroupACL() without ACL support error = %v", err)
+	}
+}
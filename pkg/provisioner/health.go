@@ -0,0 +1,89 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package provisioner
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"time"
+
+	"k8s.io/klog/v2"
+
+	"sigs.k8s.io/controller-runtime/pkg/healthz"
+	"sigs.k8s.io/controller-runtime/pkg/manager"
+)
+
+// checkWritable verifies that files can be created in dir.
+func checkWritable(dir string) error {
+	file, err := ioutil.TempFile(dir, ".readyz")
+	if err != nil {
+		return err
+	}
+	file.Close()
+	return os.Remove(file.Name())
+}
+
+// ready returns why the provisioner can't provision volumes, or nil if it can:
+// the API server has to be reachable and every pool has to be writable.
+func (p *HostPathProvisioner) ready() error {
+	if _, err := p.client.Discovery().ServerVersion(); err != nil {
+		return fmt.Errorf("unable to reach the API server: %v", err)
+	}
+	for _, pool := range p.currentPools() {
+		if pool.devices != nil {
+			continue
+		}
+		if err := checkWritable(pool.path); err != nil {
+			return fmt.Errorf("pool %s is not writable: %v", pool.name, err)
+		}
+	}
+	if p.monitor != nil && !p.monitor.ready() {
+		return fmt.Errorf("provisioning is paused for at least one pool")
+	}
+	return nil
+}
+
+// checker returns the health check running check, logging why it fails:
+// the probes only see the status.
+func checker(name string, check func() error) healthz.Checker {
+	return func(*http.Request) error {
+		err := check()
+		if err != nil {
+			klog.Warningf("%s check failed: %v", name, err)
+		}
+		return err
+	}
+}
+
+// addHealthChecks adds the liveness and readiness checks to the manager,
+// which serves them as /healthz and /readyz. /healthz fails when a controller
+// worker is wedged or a background loop panicked, /readyz when the
+// provisioner can't provision volumes.
+func addHealthChecks(mgr manager.Manager, p *HostPathProvisioner, pc *provisionController, workerDeadline time.Duration) error {
+	if err := mgr.AddHealthzCheck("loops", checker("loops", p.loops.check)); err != nil {
+		return err
+	}
+	err := mgr.AddHealthzCheck("controller", checker("controller", func() error {
+		return pc.CheckHealth(workerDeadline)
+	}))
+	if err != nil {
+		return err
+	}
+	return mgr.AddReadyzCheck("provisioner", checker("provisioner", p.ready))
+}
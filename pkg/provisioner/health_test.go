@@ -0,0 +1,63 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package provisioner
+
+import (
+	"errors"
+	"io/ioutil"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func Test_checkWritable(t *testing.T) {
+	dir, err := ioutil.TempDir("", "readyz")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	if err := checkWritable(dir); err != nil {
+		t.Errorf("checkWritable() = %v for a writable directory", err)
+	}
+	files, _ := ioutil.ReadDir(dir)
+	if len(files) != 0 {
+		t.Errorf("checkWritable() left %d files behind", len(files))
+	}
+	if err := checkWritable(filepath.Join(dir, "missing")); err == nil {
+		t.Error("checkWritable() = nil for a missing directory")
+	}
+}
+
+func Test_checker(t *testing.T) {
+	tests := []struct {
+		name string
+		err  error
+	}{
+		{name: "healthy"},
+		{name: "unhealthy", err: errors.New("broken")},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			check := checker("test", func() error { return tt.err })
+			if err := check(httptest.NewRequest("GET", "/readyz", nil)); err != tt.err {
+				t.Errorf("checker() = %v, want %v", err, tt.err)
+			}
+		})
+	}
+}
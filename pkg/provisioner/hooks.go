@@ -0,0 +1,136 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package provisioner
+
+import (
+	"fmt"
+	"sync"
+
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/klog/v2"
+	"sigs.k8s.io/sig-storage-lib-external-provisioner/v13/controller"
+)
+
+// Hook is custom logic run around provisioning and deleting volumes, for
+// builds of the provisioner that add their own, e.g. labeling volumes or
+// registering them with an inventory. Implementations embed NopHook and
+// override what they need. Hooks are called concurrently for different
+// volumes.
+type Hook interface {
+	// BeforeProvision is called before a volume is provisioned for the
+	// claim, an error fails the attempt and the controller retries it.
+	BeforeProvision(options controller.ProvisionOptions) error
+	// AfterProvision is called with the volume provisioned for the claim
+	// before it is created, and may change its labels, annotations and
+	// the like. An error removes the volume again and fails the attempt.
+	AfterProvision(options controller.ProvisionOptions, pv *v1.PersistentVolume) error
+	// BeforeDelete is called before the volume is deleted, an error fails
+	// the attempt and the controller retries it.
+	BeforeDelete(pv *v1.PersistentVolume) error
+}
+
+// NopHook is a Hook doing nothing, for hooks to embed.
+type NopHook struct{}
+
+var _ Hook = NopHook{}
+
+// BeforeProvision implements Hook.
+func (NopHook) BeforeProvision(controller.ProvisionOptions) error { return nil }
+
+// AfterProvision implements Hook.
+func (NopHook) AfterProvision(controller.ProvisionOptions, *v1.PersistentVolume) error { return nil }
+
+// BeforeDelete implements Hook.
+func (NopHook) BeforeDelete(*v1.PersistentVolume) error { return nil }
+
+// namedHook is a registered hook.
+type namedHook struct {
+	name string
+	hook Hook
+}
+
+var hooks struct {
+	mutex      sync.Mutex
+	registered []namedHook
+}
+
+// RegisterHook registers the hook under name, usually from the init function
+// of the package implementing it. Provisioners created afterwards call the
+// hooks in the order they were registered. It panics when the name is taken.
+func RegisterHook(name string, hook Hook) {
+	if hook == nil {
+		panic("provisioner: RegisterHook hook is nil")
+	}
+	hooks.mutex.Lock()
+	defer hooks.mutex.Unlock()
+	for _, registered := range hooks.registered {
+		if registered.name == name {
+			panic(fmt.Sprintf("provisioner: RegisterHook called twice for hook %s", name))
+		}
+	}
+	hooks.registered = append(hooks.registered, namedHook{name: name, hook: hook})
+}
+
+// registeredHooks returns the hooks registered so far.
+func registeredHooks() []namedHook {
+	hooks.mutex.Lock()
+	defer hooks.mutex.Unlock()
+	return append([]namedHook(nil), hooks.registered...)
+}
+
+// hookError is the failure of a hook.
+type hookError struct {
+	hook string
+	err  error
+}
+
+func (e *hookError) Error() string {
+	return fmt.Sprintf("hook %s failed: %v", e.hook, e.err)
+}
+
+// beforeProvision calls the BeforeProvision hooks until one fails.
+func (p *HostPathProvisioner) beforeProvision(options controller.ProvisionOptions) error {
+	for _, h := range p.hooks {
+		klog.V(3).InfoS("Calling hook", "hook", h.name, "call", "BeforeProvision", "pvc", options.PVC.Namespace+"/"+options.PVC.Name)
+		if err := h.hook.BeforeProvision(options); err != nil {
+			return &hookError{hook: h.name, err: err}
+		}
+	}
+	return nil
+}
+
+// afterProvision calls the AfterProvision hooks until one fails.
+func (p *HostPathProvisioner) afterProvision(options controller.ProvisionOptions, pv *v1.PersistentVolume) error {
+	for _, h := range p.hooks {
+		klog.V(3).InfoS("Calling hook", "hook", h.name, "call", "AfterProvision", "pvc", options.PVC.Namespace+"/"+options.PVC.Name, "pv", pv.Name)
+		if err := h.hook.AfterProvision(options, pv); err != nil {
+			return &hookError{hook: h.name, err: err}
+		}
+	}
+	return nil
+}
+
+// beforeDelete calls the BeforeDelete hooks until one fails.
+func (p *HostPathProvisioner) beforeDelete(pv *v1.PersistentVolume) error {
+	for _, h := range p.hooks {
+		klog.V(3).InfoS("Calling hook", "hook", h.name, "call", "BeforeDelete", "pv", pv.Name)
+		if err := h.hook.BeforeDelete(pv); err != nil {
+			return &hookError{hook: h.name, err: err}
+		}
+	}
+	return nil
+}
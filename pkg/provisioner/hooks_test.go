@@ -0,0 +1,151 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package provisioner
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"testing"
+
+	v1 "k8s.io/api/core/v1"
+	"sigs.k8s.io/sig-storage-lib-external-provisioner/v13/controller"
+)
+
+// recordingHook records its calls and fails those in fail.
+type recordingHook struct {
+	NopHook
+	name  string
+	calls *[]string
+	fail  map[string]bool
+}
+
+func (h recordingHook) call(name string) error {
+	*h.calls = append(*h.calls, h.name+"."+name)
+	if h.fail[name] {
+		return errors.New("failed")
+	}
+	return nil
+}
+
+func (h recordingHook) BeforeProvision(controller.ProvisionOptions) error {
+	return h.call("BeforeProvision")
+}
+
+func (h recordingHook) AfterProvision(_ controller.ProvisionOptions, pv *v1.PersistentVolume) error {
+	if pv.Labels == nil {
+		pv.Labels = map[string]string{}
+	}
+	pv.Labels[h.name] = "true"
+	return h.call("AfterProvision")
+}
+
+func (h recordingHook) BeforeDelete(*v1.PersistentVolume) error {
+	return h.call("BeforeDelete")
+}
+
+func Test_RegisterHook(t *testing.T) {
+	defer func(registered []namedHook) { hooks.registered = registered }(hooks.registered)
+	hooks.registered = nil
+
+	RegisterHook("labels", NopHook{})
+	RegisterHook("inventory", NopHook{})
+	var names []string
+	for _, h := range registeredHooks() {
+		names = append(names, h.name)
+	}
+	if want := []string{"labels", "inventory"}; !reflect.DeepEqual(names, want) {
+		t.Errorf("registered hooks = %v, want %v", names, want)
+	}
+	func() {
+		defer func() {
+			if recover() == nil {
+				t.Error("registering a hook twice did not panic")
+			}
+		}()
+		RegisterHook("labels", NopHook{})
+	}()
+}
+
+func Test_hooks(t *testing.T) {
+	tests := []struct {
+		name      string
+		fail      map[string]bool
+		wantCalls []string
+		wantErr   bool
+		wantDirs  int
+		wantPV    bool
+	}{
+		{
+			name:      "all succeed",
+			wantCalls: []string{"a.BeforeProvision", "b.BeforeProvision", "a.AfterProvision", "b.AfterProvision", "a.BeforeDelete", "b.BeforeDelete"},
+			wantPV:    true,
+		},
+		{
+			name:      "before provision fails",
+			fail:      map[string]bool{"BeforeProvision": true},
+			wantCalls: []string{"a.BeforeProvision"},
+			wantErr:   true,
+		},
+		{
+			name:      "after provision fails",
+			fail:      map[string]bool{"AfterProvision": true},
+			wantCalls: []string{"a.BeforeProvision", "b.BeforeProvision", "a.AfterProvision", "a.BeforeDelete", "b.BeforeDelete"},
+			wantErr:   true,
+		},
+		{
+			name:      "before delete fails",
+			fail:      map[string]bool{"BeforeDelete": true},
+			wantCalls: []string{"a.BeforeProvision", "b.BeforeProvision", "a.AfterProvision", "b.AfterProvision", "a.BeforeDelete"},
+			wantPV:    true,
+			wantDirs:  1,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			memory := newMemoryBackend(10 * GiB)
+			p := simulatedProvisioner(memory, &storagePool{name: "a", path: "/simulated/a"})
+			var calls []string
+			p.hooks = []namedHook{
+				{name: "a", hook: recordingHook{name: "a", calls: &calls, fail: tt.fail}},
+				{name: "b", hook: recordingHook{name: "b", calls: &calls}},
+			}
+
+			pv, _, err := p.Provision(context.TODO(), simulatedClaim("pvc-1", nil))
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Provision() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if (pv != nil) != tt.wantPV {
+				t.Fatalf("Provision() = %v, want a PV %v", pv, tt.wantPV)
+			}
+			if pv != nil {
+				if want := map[string]string{"a": "true", "b": "true"}; !reflect.DeepEqual(pv.Labels, want) {
+					t.Errorf("labels = %v, want %v", pv.Labels, want)
+				}
+				if err := p.Delete(context.TODO(), pv); (err != nil) != tt.fail["BeforeDelete"] {
+					t.Errorf("Delete() error = %v", err)
+				}
+			}
+			if !reflect.DeepEqual(calls, tt.wantCalls) {
+				t.Errorf("calls = %v, want %v", calls, tt.wantCalls)
+			}
+			if len(memory.dirs) != tt.wantDirs {
+				t.Errorf("directories left = %v, want %d", memory.dirs, tt.wantDirs)
+			}
+		})
+	}
+}
@@ -0,0 +1,1494 @@
+/*
+Copyright 2018 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package provisioner
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strings"
+	"sync"
+	"text/template"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"k8s.io/klog/v2"
+	"kubevirt.io/hostpath-provisioner/admin"
+	"sigs.k8s.io/sig-storage-lib-external-provisioner/v13/controller"
+	"sigs.k8s.io/sig-storage-lib-external-provisioner/v13/util"
+
+	v1 "k8s.io/api/core/v1"
+	storage "k8s.io/api/storage/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/kubernetes/scheme"
+	corev1 "k8s.io/client-go/kubernetes/typed/core/v1"
+	"k8s.io/client-go/rest"
+	"k8s.io/client-go/tools/record"
+)
+
+const (
+	defaultProvisionerName = "kubevirt.io/hostpath-provisioner"
+	annStorageProvisioner  = "volume.beta.kubernetes.io/storage-provisioner"
+	// annStorageProvisionerGA is the GA name of annStorageProvisioner, the
+	// PV controller sets both
+	annStorageProvisionerGA = "volume.kubernetes.io/storage-provisioner"
+	// annProvisionOnNode names the node to provision a claim on
+	annProvisionOnNode = "kubevirt.io/provisionOnNode"
+	// annSelectedNode is set by the scheduler on claims of
+	// WaitForFirstConsumer classes, naming the node of their first consumer
+	annSelectedNode = "volume.kubernetes.io/selected-node"
+)
+
+// HostPathProvisioner provisions hostPath volumes in the storage pools of the
+// node it runs on. It implements the controller's Provisioner interface.
+type HostPathProvisioner struct {
+	client       kubernetes.Interface
+	identity     string
+	aliases      []string
+	nodeName     string
+	allowRootfs  bool
+	rootfsPath   string
+	strictMounts bool
+	mountsPath   string
+	// simulated is set when the memory backend simulates the pools, the
+	// checks and monitors looking at the node are skipped
+	simulated bool
+	// dryRun decides how claims would be provisioned and volumes deleted
+	// without touching the node
+	dryRun bool
+	// provisionTimeout and deleteTimeout limit how long preparing and
+	// removing a backing directory may take, no limit when 0
+	provisionTimeout       time.Duration
+	deleteTimeout          time.Duration
+	maxStripes             int
+	allowedSELinuxContexts []string
+	wipeDevices            bool
+	quarantineUnmatched    bool
+	// nfsServer is the address pods reach the NFS server of the node at
+	nfsServer string
+
+	// mutex guards the settings that can be changed by reloading the
+	// configuration, read them with the accessors in config_reload.go
+	mutex           sync.RWMutex
+	pools           []*storagePool
+	useNamingPrefix bool
+	namingMode      string
+	namingTemplate  *template.Template
+	directoryMode   os.FileMode
+	selinuxContext  string
+	allowedUIDs     idRanges
+	allowedGIDs     idRanges
+	poolPolicy      string
+	reclaimPolicy   v1.PersistentVolumeReclaimPolicy
+	rounding        capacityRounding
+	claimSelector   labels.Selector
+	qosTiers        map[string]*qosTier
+
+	namespaces    *namespaceFilter
+	quota         *quotaManager
+	monitor       *poolMonitor
+	deviceHealth  *deviceHealthMonitor
+	caches        *poolCacheMonitor
+	latency       *latencyProber
+	capabilities  *capabilityProber
+	tamper        *tamperWatcher
+	symlinks      *symlinkTree
+	usageWatcher  *poolUsageWatcher
+	usage         *usageScanner
+	eventRecorder record.EventRecorder
+	claimEvents   *eventLimiter
+	operations    *operationLog
+	attempts      *attemptCounter
+	fsOps         *fsOperations
+	rateLimiter   *poolRateLimiter
+	hooks         []namedHook
+	intents       *intentJournal
+	tracer        tracer
+	// host is the backend the provisioner works with, the node's
+	// filesystems unless they are simulated, see newBackend
+	host           backend
+	features       *featureGate
+	commands       CommandsConfig
+	tmpfsVolumes   *tmpfsBudget
+	nfsExports     *nfsExportTable
+	loopDevices    *loopDevices
+	claimedDevices *deviceClaims
+	locked         *lockedVolumes
+	// intentFile is the intent journal takeOver opens
+	intentFile string
+	// leading is closed once the replica took over the node
+	leading     chan struct{}
+	allocations *allocationDB
+	overrides   *nodeOverrides
+	loops       *loopManager
+	// pvCache lists the PVs from the shared cache of the manager
+	pvCache *volumeCache
+
+	// ctx is canceled once the provisioner starts shutting down
+	ctx              context.Context
+	cancel           context.CancelFunc
+	eventBroadcaster record.EventBroadcaster
+}
+
+// Common allocation units
+const (
+	KiB int64 = 1024
+	MiB int64 = 1024 * KiB
+	GiB int64 = 1024 * MiB
+	TiB int64 = 1024 * GiB
+)
+
+// NewHostPathProvisioner creates a new hostpath provisioner with the
+// configuration, which is expected to be valid, see Config.Validate. It is
+// registered with the controller by the name cfg.ProvisionerName, several
+// deployments with different names can coexist.
+func NewHostPathProvisioner(client kubernetes.Interface, cfg *Config) (*HostPathProvisioner, error) {
+	nodeName := cfg.NodeName
+	// note that the pool paths inform us *where* the provisioner should be writing backing files to
+	// they need to match the paths specified in the volumes.hostPath spec of the deployment
+	pools, err := cfg.storagePools()
+	if err != nil {
+		return nil, fmt.Errorf("invalid storage pool configuration: %v", err)
+	}
+	tmpfsLimit, err := parseTmpfsBudget(cfg.TmpfsBudget)
+	if err != nil {
+		return nil, err
+	}
+	features := newFeatureGate(knownFeatures)
+	if err := features.set(cfg.FeatureGates); err != nil {
+		return nil, err
+	}
+	features.logEnabled()
+	nfsExports := &nfsExportTable{}
+	nfsExports.configure(cfg.NFS)
+	klog.Infof("initiating kubevirt/hostpath-provisioner on node: %s\n", nodeName)
+	p := &HostPathProvisioner{
+		client:                 client,
+		pvCache:                &volumeCache{},
+		features:               features,
+		commands:               cfg.Commands,
+		tmpfsVolumes:           &tmpfsBudget{limit: tmpfsLimit, volumes: map[string]int64{}},
+		nfsExports:             nfsExports,
+		loopDevices:            &loopDevices{},
+		claimedDevices:         &deviceClaims{claims: map[string]string{}},
+		locked:                 &lockedVolumes{volumes: map[string]bool{}},
+		pools:                  pools,
+		identity:               cfg.ProvisionerName,
+		aliases:                cfg.ProvisionerAliases,
+		nodeName:               nodeName,
+		useNamingPrefix:        cfg.UseNamingPrefix,
+		namingMode:             cfg.NamingMode,
+		selinuxContext:         cfg.SELinuxContext,
+		poolPolicy:             cfg.PoolSelectionPolicy,
+		reclaimPolicy:          v1.PersistentVolumeReclaimPolicy(cfg.DefaultReclaimPolicy),
+		allowRootfs:            cfg.AllowRootfs,
+		rootfsPath:             cfg.RootfsPath,
+		strictMounts:           cfg.StrictMounts,
+		mountsPath:             procMountsPath,
+		dryRun:                 cfg.DryRun,
+		provisionTimeout:       cfg.ProvisionTimeout.Duration,
+		deleteTimeout:          cfg.DeleteTimeout.Duration,
+		maxStripes:             cfg.MaxVolumeStripes,
+		allowedSELinuxContexts: strings.Fields(cfg.AllowedSELinuxContexts),
+		wipeDevices:            cfg.WipeDevices,
+		quarantineUnmatched:    cfg.QuarantineUnmatched,
+		nfsServer:              cfg.NFS.Server,
+		operations:             newOperationLog(recentOperationsSize),
+		attempts:               newAttemptCounter(),
+		fsOps:                  newFSOperations(),
+		simulated:              cfg.Backend == backendMemory,
+		hooks:                  registeredHooks(),
+	}
+	for _, h := range p.hooks {
+		klog.Infof("calling hook %s around provisioning and deleting volumes", h.name)
+	}
+	if p.host, err = newBackend(cfg.Backend, cfg.MemoryPoolSize); err != nil {
+		return nil, err
+	}
+	for _, pool := range pools {
+		if p.simulated {
+			if err := simulatedPool(pool); err != nil {
+				return nil, fmt.Errorf("refusing to start with storage pool %s: %v", pool.name, err)
+			}
+		}
+		// Pools on the root filesystem are refused when provisioning, with an
+		// event on the claim, rather than crash looping the provisioner
+		if !p.checkRootfs(pool) {
+			klog.Warningf("no volumes are placed in storage pool %s at %s", pool.name, pool.path)
+		}
+	}
+	if p.simulated {
+		klog.Warningf("simulating the storage pools in memory, no volumes are created on node %s", nodeName)
+	} else {
+		p.warnUnmountedPools()
+	}
+
+	// The capacity rounding (up, down or none) and its unit (e.g. Gi, G or
+	// 100Mi) control how the pool capacity reported on PVs is rounded
+	if p.rounding, err = cfg.capacityRounding(); err != nil {
+		return nil, fmt.Errorf("invalid capacity rounding configuration: %v", err)
+	}
+
+	// The naming template, e.g. {{.PVCNamespace}}-{{.PVCName}}-{{.PVName}},
+	// names backing directories, they are named after the PV when unset
+	if p.namingTemplate, err = cfg.namingTemplate(); err != nil {
+		return nil, fmt.Errorf("invalid naming template: %v", err)
+	}
+
+	// The directory mode, e.g. 0750, is applied to new backing directories
+	// regardless of the umask
+	if p.directoryMode, err = cfg.directoryMode(); err != nil {
+		return nil, fmt.Errorf("invalid directory mode: %v", err)
+	}
+
+	// The allowed UIDs and GIDs, e.g. 107,1000-1999, limit the owners claims
+	// can ask for, claims can not choose an owner when they are unset
+	if p.allowedUIDs, p.allowedGIDs, err = cfg.allowedOwners(); err != nil {
+		return nil, fmt.Errorf("invalid owner configuration: %v", err)
+	}
+
+	// The claim selector is a label selector, e.g. storage=hostpath, limiting
+	// the claims the provisioner acts on. All claims are processed when unset
+	if p.claimSelector, err = labels.Parse(cfg.ClaimSelector); err != nil {
+		return nil, fmt.Errorf("invalid claim selector: %v", err)
+	}
+
+	// The QoS tiers, e.g. gold, silver and bronze, are named I/O limits and
+	// pool requirements StorageClasses ask for with the qosTier parameter
+	if p.qosTiers, err = cfg.qosTiers(); err != nil {
+		return nil, fmt.Errorf("invalid QoS tiers: %v", err)
+	}
+
+	if p.namespaces, err = newNamespaceFilter(cfg.AllowedNamespaces, cfg.DeniedNamespaces); err != nil {
+		return nil, fmt.Errorf("invalid namespace filter: %v", err)
+	}
+
+	if p.rateLimiter, err = newPoolRateLimiter(cfg.ProvisionRate, cfg.ProvisionBurst); err != nil {
+		return nil, err
+	}
+
+	// Operations a restart interrupted are cleaned up before new ones start,
+	// by the replica elected leader, see takeOver
+	if !p.dryRun && !p.simulated {
+		p.intentFile = cfg.IntentFile
+	}
+	p.leading = make(chan struct{})
+	if cfg.AllocationDB != "" && !p.dryRun && !p.simulated {
+		if p.allocations, err = p.openAllocations(cfg.AllocationDB); err != nil {
+			return nil, err
+		}
+	}
+
+	p.ctx, p.cancel = context.WithCancel(context.Background())
+	// The background loops are run by the manager Run creates
+	p.loops = newLoopManager()
+
+	p.eventBroadcaster = record.NewBroadcaster()
+	p.eventBroadcaster.StartRecordingToSink(&corev1.EventSinkImpl{Interface: client.CoreV1().Events(v1.NamespaceAll)})
+	p.eventRecorder = p.eventBroadcaster.NewRecorder(scheme.Scheme, v1.EventSource{Component: p.identity, Host: nodeName})
+	p.claimEvents = newEventLimiter()
+
+	if cfg.OTLPEndpoint != "" {
+		p.tracer.exporter = newOTLPExporter(cfg.OTLPEndpoint, nodeName)
+		p.loops.add("otlp-export", func(stopCh <-chan struct{}) {
+			p.tracer.exporter.Run(5*time.Second, stopCh)
+		})
+	}
+	if interval := cfg.NodeOverrideInterval.Duration; interval > 0 {
+		p.overrides = newNodeOverrides(client.Discovery().RESTClient(), nodeName)
+		p.loops.add("node-overrides", func(stopCh <-chan struct{}) {
+			p.overrides.Run(interval, stopCh)
+		})
+	}
+
+	// The mount check interval is how often pools are checked for their
+	// filesystem disappearing, provisioning into a pool is paused while it is
+	// gone. Simulated pools are not mounted anywhere
+	mountCheckInterval := cfg.MountCheckInterval.Duration
+	if !p.simulated {
+		if p.monitor, err = newPoolMonitor(pools, p.mountsPath, nodeName, p.eventRecorder); err != nil {
+			klog.Errorf("Unable to monitor pool mounts: %v", err)
+		} else {
+			p.loops.add("pool-monitor", func(stopCh <-chan struct{}) {
+				p.monitor.Run(p.currentPools, mountCheckInterval, stopCh)
+			})
+		}
+	}
+	metrics := cfg.Controller.MetricsPort > 0
+	// The filesystems of the pools are probed for the capabilities features
+	// of volumes depend on before volumes are placed in them, and those of
+	// pools added later once they are
+	p.capabilities = newCapabilityProber(p.mountsPath, nodeName, p.eventRecorder)
+	if metrics {
+		prometheus.MustRegister(p.capabilities)
+	}
+	if !p.simulated {
+		p.capabilities.probeAll(pools)
+		p.loops.add("pool-capabilities", func(stopCh <-chan struct{}) {
+			p.capabilities.Run(p.currentPools, time.Minute, stopCh)
+		})
+	}
+	if cfg.Commands.Smartctl != "" && !p.simulated {
+		p.deviceHealth = newDeviceHealthMonitor(cfg.Commands.Smartctl, p.mountsPath, nodeName, p.eventRecorder)
+		if metrics {
+			prometheus.MustRegister(p.deviceHealth)
+		}
+		p.loops.add("device-health", func(stopCh <-chan struct{}) {
+			p.deviceHealth.Run(p.currentPools, cfg.DeviceHealthInterval.Duration, stopCh)
+		})
+	}
+	if cfg.DetectTampering && !p.simulated {
+		if p.tamper, err = newTamperWatcher(client, p.pvCache, p.identity, nodeName, p.eventRecorder); err != nil {
+			klog.Errorf("Unable to watch backing directories for tampering: %v", err)
+		} else {
+			if metrics {
+				prometheus.MustRegister(p.tamper)
+			}
+			p.loops.add("tamper", func(stopCh <-chan struct{}) {
+				p.tamper.Run(pools, stopCh)
+			})
+		}
+	}
+	// Logical volumes are not mounted again after a reboot on their own.
+	// The SSD caches of thin pools are attached, or detached when they were
+	// removed from the configuration, first.
+	if hasLVMPool(pools) && !p.dryRun {
+		p.caches = newPoolCacheMonitor(nodeName, p.eventRecorder)
+		if metrics {
+			prometheus.MustRegister(p.caches)
+		}
+		p.loops.add("pool-caches", func(stopCh <-chan struct{}) {
+			p.caches.setup(pools)
+			p.mountLogicalVolumes()
+			p.caches.Run(p.currentPools, cfg.CacheCheckInterval.Duration, stopCh)
+		})
+	}
+	// Read which devices are handed out before handing out more
+	if hasDevicePool(pools) {
+		if err := p.claimedDevices.load(p); err != nil {
+			klog.Warningf("%v, reading them again when a device is handed out", err)
+		}
+	}
+	// Neither are image files attached to loop devices again, nor tmpfs
+	// volumes and overlay clones mounted. Overlays go last, their templates
+	// may be image backed.
+	if !p.dryRun && !p.simulated {
+		go func() {
+			p.attachImageVolumes()
+			p.attachStripedVolumes()
+			p.openEncryptedDevices()
+			p.addFscryptKeys()
+			p.mountTmpfsVolumes()
+			p.mountOverlayVolumes()
+		}()
+		// Encrypted volumes whose key was unavailable are opened once it is
+		p.loops.add("locked-volumes", func(stopCh <-chan struct{}) {
+			wait.Until(p.openLockedVolumes, time.Minute, stopCh)
+		})
+	}
+	// Pools are deduplicated in the background, pools added later included
+	if !p.dryRun && !p.simulated {
+		dedup := newDedupRunner(p.mountsPath, nodeName, cfg.Commands.Duperemove, p.eventRecorder, p.capabilities)
+		if metrics {
+			prometheus.MustRegister(dedup)
+		}
+		p.loops.add("dedup", func(stopCh <-chan struct{}) {
+			dedup.Run(p.currentPools, stopCh)
+		})
+	}
+	if interval := cfg.LoopDeviceCheckInterval.Duration; interval > 0 && !p.simulated {
+		if metrics {
+			prometheus.MustRegister(newLoopCollector(p.loopDevices))
+		}
+		p.loops.add("loop-devices", func(stopCh <-chan struct{}) {
+			wait.Until(p.checkLoopDevices, interval, stopCh)
+		})
+	}
+	// The symlink farm is not kept in a dry run, it would change the pools
+	if cfg.SymlinkFarm && !p.dryRun && !p.simulated {
+		p.symlinks = &symlinkTree{}
+		go p.syncSymlinks()
+	}
+	if interval := cfg.LatencyProbeInterval.Duration; interval > 0 && !p.simulated {
+		p.latency = newLatencyProber(nodeName, p.eventRecorder, cfg.SlowDiskThreshold.Duration)
+		if metrics {
+			prometheus.MustRegister(p.latency)
+		}
+		p.loops.add("pool-latency", func(stopCh <-chan struct{}) {
+			p.latency.Run(p.currentPools, interval, stopCh)
+		})
+	}
+	// The pool usage thresholds are usage percentages, e.g. 80,90,95,
+	// crossing them emits node events. The watcher runs without thresholds
+	// too, so that they can be added by reloading the configuration
+	p.usageWatcher = newPoolUsageWatcher(client, p.host, nodeName, p.eventRecorder, cfg.PoolUsageThresholds, cfg.PoolUsageCondition)
+	p.loops.add("pool-usage", func(stopCh <-chan struct{}) {
+		p.usageWatcher.Run(p.currentPools, cfg.PoolUsageCheckInterval.Duration, stopCh)
+	})
+	usageScanInterval := cfg.UsageScanInterval.Duration
+	p.usage = newUsageScanner(client, p.host, p.pvCache, p.identity, nodeName, usageScanInterval, cfg.UsageScanWorkers)
+	p.loops.add("usage-scanner", p.usage.Run)
+	// The I/O limits of volumes are applied to the cgroups of the pods using
+	// them, which come and go
+	if cfg.EnforceIOLimits && !p.dryRun && !p.simulated {
+		limiter := newIOLimiter(client, p.pvCache, p.identity, nodeName, cfg.CgroupRoot)
+		p.loops.add("io-limits", func(stopCh <-chan struct{}) {
+			limiter.Run(cfg.IOLimitInterval.Duration, stopCh)
+		})
+	}
+	// The health of volumes is judged by looking at their directories on
+	// the node, simulated volumes have none
+	if interval := cfg.VolumeHealthInterval.Duration; interval > 0 && !p.simulated {
+		health := newVolumeHealthMonitor(client, p.host, p.pvCache, p.identity, nodeName, p.eventRecorder, p.usage)
+		health.relabel = p.features.Enabled(featureSELinuxRelabel) && !p.dryRun
+		p.loops.add("volume-health", func(stopCh <-chan struct{}) {
+			health.Run(interval, stopCh)
+		})
+	}
+	if interval := cfg.VolumeStatsInterval.Duration; interval > 0 && !p.dryRun {
+		stats := newVolumeStatsRecorder(client, p.pvCache, p.identity, nodeName, p.usage)
+		p.loops.add("volume-stats", func(stopCh <-chan struct{}) {
+			stats.Run(interval, stopCh)
+		})
+	}
+	// The quota ConfigMap in the provisioner's namespace holds per-namespace
+	// limits, quotas are not enforced when it is unset
+	if quotaConfigMap := cfg.QuotaConfigMap; quotaConfigMap != "" {
+		klog.Infof("enforcing namespace quotas from configmap %s/%s", getPodNamespace(), quotaConfigMap)
+		p.quota = newQuotaManager(client, p.pvCache, p.identity, nodeName, getPodNamespace(), quotaConfigMap)
+	}
+	if metrics {
+		prometheus.MustRegister(newBuildInfoCollector())
+		prometheus.MustRegister(p.usage, newUsageCollector(p.usage))
+		pools := newPoolCollector(p)
+		prometheus.MustRegister(pools)
+		p.loops.add("pool-metrics", func(stopCh <-chan struct{}) {
+			pools.Run(usageScanInterval, stopCh)
+		})
+	}
+	return p, nil
+}
+
+// getPodNamespace returns the namespace the provisioner is running in.
+func getPodNamespace() string {
+	if ns := os.Getenv("POD_NAMESPACE"); ns != "" {
+		return ns
+	}
+	if data, err := ioutil.ReadFile("/var/run/secrets/kubernetes.io/serviceaccount/namespace"); err == nil {
+		if ns := strings.TrimSpace(string(data)); ns != "" {
+			return ns
+		}
+	}
+	return "default"
+}
+
+var _ controller.Provisioner = &HostPathProvisioner{}
+var _ controller.Qualifier = &HostPathProvisioner{}
+var _ controller.BlockProvisioner = &HostPathProvisioner{}
+
+// ownsIdentity returns whether volumes tagged with identity were created by
+// this provisioner, under its current name or one of its aliases.
+func (p *HostPathProvisioner) ownsIdentity(identity string) bool {
+	if identity == p.identity {
+		return true
+	}
+	for _, alias := range p.aliases {
+		if identity == alias {
+			return true
+		}
+	}
+	return false
+}
+
+func isCorrectNodeByBindingMode(annotations map[string]string, nodeName, identity string, bindingMode storage.VolumeBindingMode) bool {
+	klog.Infof("isCorrectNodeByBindingMode mode: %s", string(bindingMode))
+	if _, ok := annotations["kubevirt.io/provisionOnNode"]; ok {
+		if isCorrectNode(annotations, nodeName, "kubevirt.io/provisionOnNode") {
+			annotations[annStorageProvisioner] = identity
+			return true
+		}
+		return false
+	} else if bindingMode == storage.VolumeBindingWaitForFirstConsumer {
+		return isCorrectNode(annotations, nodeName, "volume.kubernetes.io/selected-node")
+	}
+	return false
+}
+
+func isCorrectNode(annotations map[string]string, nodeName string, annotationName string) bool {
+	if val, ok := annotations[annotationName]; ok {
+		klog.Infof("claim included %s annotation: %s\n", annotationName, val)
+		if val == nodeName {
+			klog.Infof("matched %s: %s with this node: %s\n", annotationName, val, nodeName)
+			return true
+		}
+		klog.Infof("no match for %s: %s with this node: %s\n", annotationName, val, nodeName)
+		return false
+	}
+	klog.Infof("missing %s annotation, skipping operations for pvc", annotationName)
+	return false
+}
+
+// ShouldProvision implements the controller's Qualifier interface. The PV
+// controller only hands claims of WaitForFirstConsumer classes over once the
+// scheduler selected a node for them, claims naming this node get it selected
+// here instead, so that they are provisioned without waiting for a consumer.
+func (p *HostPathProvisioner) ShouldProvision(ctx context.Context, pvc *v1.PersistentVolumeClaim) bool {
+	name := util.GetPersistentVolumeClaimClass(pvc)
+	class, err := p.pvCache.storageClass(ctx, p.client, name)
+	if err != nil {
+		klog.Errorf("Unable to get storage class %s of claim %s/%s: %v", name, pvc.Namespace, pvc.Name, err)
+		return false
+	}
+	if !p.ownsIdentity(class.Provisioner) {
+		return false
+	}
+	waitForFirstConsumer := class.VolumeBindingMode != nil && *class.VolumeBindingMode == storage.VolumeBindingWaitForFirstConsumer
+	if waitForFirstConsumer && pvc.Annotations[annProvisionOnNode] == p.nodeName && pvc.Annotations[annSelectedNode] == "" {
+		if err := p.selectNode(ctx, pvc); err != nil {
+			klog.Errorf("Unable to select node %s for claim %s/%s: %v", p.nodeName, pvc.Namespace, pvc.Name, err)
+		}
+		// The claim is processed again once the PV controller handed it over
+		return false
+	}
+	return p.shouldProvision(ctx, pvc, class.VolumeBindingMode)
+}
+
+// selectNode sets this node as the selected node of the claim, as the
+// scheduler would.
+func (p *HostPathProvisioner) selectNode(ctx context.Context, pvc *v1.PersistentVolumeClaim) error {
+	patch, err := json.Marshal(map[string]interface{}{
+		"metadata": map[string]interface{}{
+			"annotations": map[string]string{annSelectedNode: p.nodeName},
+		},
+	})
+	if err != nil {
+		return err
+	}
+	_, err = p.client.CoreV1().PersistentVolumeClaims(pvc.Namespace).Patch(ctx, pvc.Name, types.MergePatchType, patch, metav1.PatchOptions{})
+	return err
+}
+
+// shouldProvision returns whether the claim, of a class with the binding
+// mode, is provisioned on this node.
+func (p *HostPathProvisioner) shouldProvision(ctx context.Context, pvc *v1.PersistentVolumeClaim, bindingMode *storage.VolumeBindingMode) bool {
+	if !p.matchesClaimSelector(pvc) {
+		klog.V(3).Infof("claim %s/%s does not match the claim selector %s, skipping", pvc.Namespace, pvc.Name, p.currentClaimSelector().String())
+		return false
+	}
+	shouldProvision := isCorrectNodeByBindingMode(pvc.GetAnnotations(), p.nodeName, p.identity, *bindingMode)
+	if !shouldProvision && missingNodeAnnotation(pvc.GetAnnotations(), *bindingMode) {
+		p.clusterEvent(pvc, v1.EventTypeWarning, eventReasonNodeAnnotationMissing,
+			"Claim has no kubevirt.io/provisionOnNode annotation and its StorageClass does not use WaitForFirstConsumer, no node will provision it")
+	}
+
+	if shouldProvision {
+		if disabled, reason := p.provisioningDisabled(); disabled {
+			p.decisionEvent(pvc, v1.EventTypeWarning, eventReasonNodeDisabled, "Provisioning on node %s is disabled by %s", p.nodeName, reason)
+			shouldProvision = false
+		}
+	}
+	if shouldProvision {
+		requested := pvc.Spec.Resources.Requests[v1.ResourceName(v1.ResourceStorage)]
+		allocation := ""
+		if isBlockClaim(pvc) {
+			allocation = allocationDevice
+		}
+		candidates, err := p.candidatePools(requested, p.currentRounding(), allocation)
+		if err != nil {
+			klog.Errorf("Unable to determine pvCapacity %v", err)
+			p.decisionEvent(pvc, v1.EventTypeWarning, eventReasonCapacityUnknown, "Unable to determine the capacity of the storage pools on node %s: %v", p.nodeName, err)
+			shouldProvision = false
+		} else if len(candidates) == 0 {
+			if refused := p.rootfsPools(); len(refused) > 0 {
+				p.decisionEvent(pvc, v1.EventTypeWarning, eventReasonPoolOnRootfs, "Storage pools %s on node %s are on the node's root filesystem and are not used unless the provisioner runs with --allow-rootfs", strings.Join(refused, ", "), p.nodeName)
+			} else {
+				klog.Error("PVC request size larger than total possible PV size")
+				p.decisionEvent(pvc, v1.EventTypeWarning, eventReasonInsufficientCapacity, "No storage pool on node %s can hold a volume of %s", p.nodeName, requested.String())
+			}
+			shouldProvision = false
+		}
+	}
+	return shouldProvision
+}
+
+// matchesClaimSelector returns whether the claim's labels match the configured
+// claim selector.
+func (p *HostPathProvisioner) matchesClaimSelector(pvc *v1.PersistentVolumeClaim) bool {
+	selector := p.currentClaimSelector()
+	if selector == nil {
+		return true
+	}
+	return selector.Matches(labels.Set(pvc.Labels))
+}
+
+// Provision creates a storage asset and returns a PV object representing it.
+func (p *HostPathProvisioner) Provision(ctx context.Context, options controller.ProvisionOptions) (*v1.PersistentVolume, controller.ProvisioningState, error) {
+	start := time.Now()
+	id := p.attempts.correlationID(admin.OperationProvision, options.PVC.UID)
+	// Hooks are not called in a dry run, they may act outside of the node
+	var pv *v1.PersistentVolume
+	var err error
+	if !p.dryRun {
+		err = p.beforeProvision(options)
+	}
+	if err == nil {
+		pv, err = p.provision(ctx, options, start, id)
+	}
+	if err == nil {
+		if err = p.afterProvision(options, pv); err != nil {
+			p.removeUnusedVolume(ctx, options, pv, id, err)
+			pv = nil
+		}
+	}
+	op := admin.Operation{Type: admin.OperationProvision, CorrelationID: id, PV: options.PVName, Claim: options.PVC.Namespace + "/" + options.PVC.Name}
+	if pv != nil {
+		op.Pool = pv.Annotations[annStoragePool]
+		if path := backingPath(pv); path != "" {
+			op.Path = path
+		} else {
+			op.Path = pv.Annotations[annDevice]
+		}
+	}
+	p.operations.record(op, start, err)
+	if err == nil {
+		p.attempts.forget(admin.OperationProvision, options.PVC.UID)
+	}
+	// Whatever was made for the claim is removed again on failure, there is
+	// nothing left to wait for
+	return pv, controller.ProvisioningFinished, withCorrelationID(err, id)
+}
+
+// VolumeStored records the volume in the allocation database and clears the
+// intent to provision it once its PV is saved, a volume whose PV can not be
+// saved is deleted again by the controller.
+func (p *HostPathProvisioner) VolumeStored(pv *v1.PersistentVolume) {
+	p.allocations.add(allocationOf(pv))
+	p.intents.done(admin.OperationProvision, pv.Name)
+}
+
+// removeUnusedVolume removes the volume provisioned for the claim again, the
+// PV is not created because a hook failed.
+func (p *HostPathProvisioner) removeUnusedVolume(ctx context.Context, options controller.ProvisionOptions, pv *v1.PersistentVolume, id string, hookErr error) {
+	klog.ErrorS(hookErr, "Removing the volume provisioned for the claim again", "correlationID", id, "pvc", options.PVC.Namespace+"/"+options.PVC.Name, "pv", pv.Name, "node", p.nodeName)
+	if p.quota != nil {
+		p.quota.release(options.PVName)
+	}
+	if err := p.Delete(ctx, pv); err != nil {
+		klog.ErrorS(err, "Failed to remove the volume provisioned for the claim", "correlationID", id, "pv", pv.Name, "node", p.nodeName)
+	}
+}
+
+// provision provisions the volume of the claim. ctx bounds waiting for the
+// rate limit and for the filesystem operations, an operation it abandons is
+// rolled back once it finishes.
+func (p *HostPathProvisioner) provision(ctx context.Context, options controller.ProvisionOptions, start time.Time, id string) (pv *v1.PersistentVolume, err error) {
+	pvc := options.PVC.Namespace + "/" + options.PVC.Name
+	trace := p.tracer.startTrace("Provision", "correlationID", id, "pvc", pvc, "pv", options.PVName, "node", p.nodeName)
+	defer func() { trace.end(err) }()
+	if p.runContext().Err() != nil {
+		return nil, errShuttingDown
+	}
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	span := trace.child("CheckNamespace")
+	err = p.namespaces.check(options.PVC.Namespace)
+	span.end(err)
+	if err != nil {
+		return nil, err
+	}
+	spanPools, err := spanPoolsFor(options)
+	if err != nil {
+		return nil, err
+	}
+	span = trace.child("SelectPool")
+	source, err := p.cloneSource(options.PVC)
+	var pool *storagePool
+	var stripes []*storagePool
+	var stripeSize int64
+	var pvCapacity *resource.Quantity
+	var poolPolicy string
+	if err == nil && source != nil {
+		// Clones are exactly as large as requested, like any logical volume
+		requested := options.PVC.Spec.Resources.Requests[v1.ResourceName(v1.ResourceStorage)]
+		pvCapacity, poolPolicy = &requested, policyClone
+		if isBlockClaim(options.PVC) {
+			err = fmt.Errorf("block claims are given whole devices and can not be cloned")
+		} else {
+			pool, err = p.clonePool(source)
+		}
+	} else if err == nil {
+		pool, pvCapacity, poolPolicy, err = p.selectPool(options)
+		if _, ok := err.(noPoolError); ok && spanPools {
+			if stripes, stripeSize, err = p.selectStripes(options); err == nil {
+				requested := options.PVC.Spec.Resources.Requests[v1.ResourceName(v1.ResourceStorage)]
+				pool, pvCapacity, poolPolicy = stripes[0], &requested, policySpan
+			}
+		}
+	}
+	span.end(err)
+	if err != nil {
+		return nil, err
+	}
+	if pool.devices != nil {
+		return p.provisionDevice(ctx, options, pool, poolPolicy, id, start, trace)
+	}
+	dirName, err := p.volumeDirName(options, pool)
+	if err != nil {
+		return nil, err
+	}
+	vPath, err := volumeDir(pool, dirName, options.PVName)
+	if err != nil {
+		return nil, err
+	}
+	var stripeImages []string
+	if stripes != nil {
+		if stripeImages, err = stripeImagesFor(stripes, vPath, dirName, options.PVName); err != nil {
+			return nil, err
+		}
+	}
+	mode, err := p.directoryModeFor(options)
+	if err != nil {
+		return nil, err
+	}
+	uid, gid, err := p.ownerFor(options.PVC)
+	if err != nil {
+		return nil, err
+	}
+	fsGroup, err := p.fsGroupFor(options)
+	if err != nil {
+		return nil, err
+	}
+	if fsGroup != -1 {
+		gid, mode = fsGroup, fsGroupMode(mode)
+	}
+	selinuxContext, err := p.selinuxContextFor(options)
+	if err != nil {
+		return nil, err
+	}
+	allocation, err := lvmAllocationFor(options)
+	if err != nil {
+		return nil, err
+	}
+	backing, err := backingFor(options)
+	if err != nil {
+		return nil, err
+	}
+	imageFS, err := imageFilesystemFor(options, backing)
+	if err != nil {
+		return nil, err
+	}
+	compression, err := compressionFor(options, backing, imageFS)
+	if err != nil {
+		return nil, err
+	}
+	encryptionSecret, err := encryptionSecretFor(options, backing)
+	if err != nil {
+		return nil, err
+	}
+	var key []byte
+	var luks *luksVolume
+	if encryptionSecret != "" {
+		if key, err = p.encryptionKey(encryptionSecret); err != nil {
+			return nil, err
+		}
+		// Image files are encrypted with LUKS, directories with fscrypt
+		if backing == backingImage {
+			luks = &luksVolume{name: luksName(options.PVName), key: key}
+		}
+	}
+	if compression != "" && backing == backingImage {
+		imageFS.mountOptions = compressionMountOptions(compression)
+	}
+	preallocation, err := preallocationFor(options, backing)
+	if err != nil {
+		return nil, err
+	}
+	clusterSize, err := qcow2ClusterSizeFor(options, backing)
+	if err != nil {
+		return nil, err
+	}
+	export, err := nfsExportFor(options, p.nfsServer)
+	if err != nil {
+		return nil, err
+	}
+	tier, err := p.qosTierFor(options)
+	if err != nil {
+		return nil, err
+	}
+	limits, err := ioLimitsFor(options, backing, tier)
+	if err != nil {
+		return nil, err
+	}
+	if p.dryRun {
+		return nil, p.dryRunProvision(options.PVC, pool, vPath, mode, uid, gid, id)
+	}
+	if p.simulated {
+		if err := simulatedVolume(source != nil, stripes != nil, backing, compression, encryptionSecret, export); err != nil {
+			return nil, err
+		}
+	}
+
+	span = trace.child("WaitForRateLimit")
+	waitCtx, stopWaiting := p.waitContext(ctx)
+	err = p.rateLimiter.wait(waitCtx, pool.name, p.provisionTimeout)
+	stopWaiting()
+	span.end(err)
+	if err != nil {
+		return nil, err
+	}
+
+	planned := &intentBacking{Export: export}
+	switch {
+	case source != nil && source.Annotations[annLVMVolume] == "":
+		planned.Overlay = true
+	case source != nil:
+		planned.LV = pool.lvm.volumeGroup + "/" + options.PVName
+	case stripes != nil:
+		planned.Stripes = stripeImages
+	case pool.lvm != nil:
+		planned.LV = pool.lvm.volumeGroup + "/" + options.PVName
+	case backing == backingImage:
+		planned.Image = vPath + imageSuffix
+		if luks != nil {
+			planned.LUKS = luks.name
+		}
+	case backing == backingTmpfs:
+		planned.Tmpfs = true
+	}
+	if err := p.intents.begin(admin.OperationProvision, options.PVName, vPath, planned); err != nil {
+		return nil, err
+	}
+	// The intent is kept until the PV is stored, see VolumeStored, a restart
+	// before that finds a backing directory no PV refers to. One that timed
+	// out is cleared once its rollback is done.
+	timedOut := false
+	defer func() {
+		if err != nil && !timedOut {
+			p.intents.done(admin.OperationProvision, options.PVName)
+		}
+	}()
+
+	if p.quota != nil {
+		span = trace.child("ReserveQuota")
+		err := p.quota.reserve(options.PVC, options.PVName, pool.name)
+		span.end(err)
+		if err != nil {
+			return nil, err
+		}
+	}
+	klog.InfoS("Creating backing directory", "correlationID", id, "pvc", pvc, "pv", options.PVName, "node", p.nodeName, "pool", pool.name, "path", vPath)
+
+	span = trace.child("CreateDirectory")
+	requestedCapacity := options.PVC.Spec.Resources.Requests[v1.ResourceName(v1.ResourceStorage)]
+	var lv, image, lower, fscryptKey string
+	// Whatever completed is undone when a later step fails, or once the steps
+	// finish after timing out, the claim may be gone before a retry succeeds
+	undo := &rollback{}
+	err = p.fsOps.run(ctx, "creating backing directory", vPath, p.provisionTimeout, func() error {
+		undo.add("intent to provision "+options.PVName, func() error {
+			p.intents.done(admin.OperationProvision, options.PVName)
+			return nil
+		})
+		undo.add("backing directory "+vPath, func() error { return p.host.Destroy(vPath) })
+		return undo.finish(func() error {
+			if source != nil && source.Annotations[annLVMVolume] == "" {
+				lower = backingPath(source)
+				if err := mountOverlay(lower, vPath); err != nil {
+					return err
+				}
+				undo.add("overlay "+vPath, func() error { return removeOverlay(vPath) })
+			} else if source != nil {
+				var err error
+				if lv, err = pool.lvm.cloneVolume(source.Annotations[annLVMVolume], options.PVName, requestedCapacity.Value(), vPath); err != nil {
+					return err
+				}
+				undo.add("logical volume "+lv, func() error { return p.lvm().remove(lv, vPath) })
+			} else if stripes != nil {
+				if err := p.loopDevices.createStripedVolume(vPath, stripeImages, stripeSize); err != nil {
+					return err
+				}
+				undo.add("striped volume "+vPath, func() error { return p.loopDevices.removeStripedVolume(stripeImages, vPath) })
+			} else if pool.lvm != nil {
+				var err error
+				if lv, err = pool.lvm.createVolume(options.PVName, requestedCapacity.Value(), vPath, allocation); err != nil {
+					return err
+				}
+				undo.add("logical volume "+lv, func() error { return p.lvm().remove(lv, vPath) })
+			} else if backing == backingImage {
+				var err error
+				if image, err = p.loopDevices.createImageVolume(vPath, requestedCapacity.Value(), imageFS, preallocation, luks); err != nil {
+					return err
+				}
+				luksName := ""
+				if luks != nil {
+					luksName = luks.name
+				}
+				undo.add("image "+image, func() error { return p.loopDevices.removeImageVolume(image, vPath, luksName) })
+			} else if backing == backingTmpfs {
+				if err := p.tmpfsVolumes.reserve(p, options.PVName, requestedCapacity.Value()); err != nil {
+					return err
+				}
+				undo.add("tmpfs budget of "+options.PVName, func() error {
+					p.tmpfsVolumes.release(options.PVName)
+					return nil
+				})
+				if err := mountTmpfs(vPath, requestedCapacity.Value()); err != nil {
+					return err
+				}
+				undo.add("tmpfs "+vPath, func() error { return unmountTmpfs(vPath) })
+			}
+			if err := p.host.Create(vPath, mode, uid, gid); err != nil {
+				return err
+			}
+			if compression != "" && backing != backingImage {
+				if err := compressDirectory(vPath, compression); err != nil {
+					return err
+				}
+			}
+			if encryptionSecret != "" && luks == nil {
+				var err error
+				if fscryptKey, err = encryptBackingDir(vPath, key); err != nil {
+					return err
+				}
+				undo.add("encryption key "+fscryptKey, func() error {
+					volume := &v1.PersistentVolume{ObjectMeta: metav1.ObjectMeta{Name: options.PVName, Annotations: map[string]string{annFscryptKey: fscryptKey}}}
+					return p.releaseFscryptKey(volume, vPath)
+				})
+			}
+			if backing == backingQcow2 {
+				if err := createQcow2Image(p.commands.QemuImg, vPath, requestedCapacity.Value(), clusterSize, preallocation, mode, uid, gid); err != nil {
+					return err
+				}
+			}
+			if export {
+				if err := p.nfsExports.add(vPath, string(options.PVC.UID)); err != nil {
+					return err
+				}
+				undo.add("NFS export "+vPath, func() error { return p.nfsExports.remove(vPath) })
+			}
+			return nil
+		}())
+	})
+	span.end(err)
+	if _, timedOut = err.(*timeoutError); timedOut {
+		undo.abandon()
+		p.claimEvent(options.PVC, v1.EventTypeWarning, eventReasonOperationTimedOut, "%v in pool %s on node %s (correlation ID %s)", err, pool.name, p.nodeName, id)
+	}
+	if err != nil {
+		klog.ErrorS(err, "Failed to create backing directory", "correlationID", id, "pvc", pvc, "pv", options.PVName, "node", p.nodeName, "pool", pool.name, "path", vPath)
+		if p.quota != nil {
+			p.quota.release(options.PVName)
+		}
+		return nil, fmt.Errorf("unable to create backing directory %s in pool %s on node %s: %v", vPath, pool.name, p.nodeName, err)
+	}
+	if fsGroup != -1 && !p.simulated {
+		if err := setDefaultGroupACL(vPath, fsGroup, mode); err != nil {
+			klog.Warningf("unable to set the default ACL of backing directory %s for fsGroup %d: %v", vPath, fsGroup, err)
+		}
+	}
+	if p.simulated {
+		// Simulated directories are neither labeled nor tagged
+		selinuxContext = ""
+	} else if selinuxContext != "" {
+		if err := setSELinuxContext(vPath, selinuxContext); err != nil {
+			klog.Warningf("unable to label backing directory %s with SELinux context %s: %v", vPath, selinuxContext, err)
+			selinuxContext = ""
+		}
+	}
+	if !p.simulated {
+		if err := writeVolumeIdentity(vPath, newVolumeIdentity(options.PVC, options.PVName, start)); err != nil {
+			klog.Warningf("unable to tag backing directory %s with the identity of claim %s: %v", vPath, pvc, err)
+		}
+	}
+	p.symlinks.link(vPath, options.PVC.Namespace, options.PVC.Name)
+	p.claimEvent(options.PVC, v1.EventTypeNormal, eventReasonDirectoryCreated, "Created backing directory %s in pool %s on node %s (correlation ID %s)", vPath, pool.name, p.nodeName, id)
+
+	pv = &v1.PersistentVolume{
+		ObjectMeta: metav1.ObjectMeta{
+			Name: options.PVName,
+			Annotations: map[string]string{
+				"hostPathProvisionerIdentity": p.identity,
+				"kubevirt.io/provisionOnNode": p.nodeName,
+				annRequestedCapacity:          requestedCapacity.String(),
+				annStoragePool:                pool.name,
+				annPoolSelectionPolicy:        poolPolicy,
+				annCorrelationID:              id,
+			},
+		},
+		Spec: v1.PersistentVolumeSpec{
+			PersistentVolumeReclaimPolicy: p.reclaimPolicyFor(options),
+			AccessModes:                   options.PVC.Spec.AccessModes,
+			Capacity: v1.ResourceList{
+				v1.ResourceName(v1.ResourceStorage): *pvCapacity,
+			},
+			PersistentVolumeSource: v1.PersistentVolumeSource{
+				HostPath: &v1.HostPathVolumeSource{
+					Path: vPath,
+				},
+			},
+			NodeAffinity: nodeAffinity(p.nodeName),
+		},
+	}
+	if selinuxContext != "" {
+		pv.Annotations[annSELinuxContext] = selinuxContext
+	}
+	if pool.deviceClass != "" {
+		pv.Annotations[annDeviceClass] = pool.deviceClass
+	}
+	if limits != (ioLimits{}) {
+		pv.Annotations[annIOLimits] = limits.String()
+	}
+	if tier != nil {
+		pv.Annotations[annQoSTier] = tier.name
+	}
+	if options.PVC.Annotations[annTemplate] == "true" {
+		pv.Annotations[annTemplate] = "true"
+	}
+	if lower != "" {
+		pv.Annotations[annOverlayLower] = lower
+	}
+	if compression != "" {
+		pv.Annotations[annCompression] = compression
+	}
+	if encryptionSecret != "" {
+		pv.Annotations[annEncryptionSecret] = encryptionSecret
+	}
+	if fscryptKey != "" {
+		pv.Annotations[annFscryptKey] = fscryptKey
+	}
+	if lv != "" {
+		// The volume is exactly as large as requested
+		pv.Annotations[annLVMVolume] = lv
+		pv.Spec.Capacity[v1.ResourceStorage] = requestedCapacity
+	}
+	if image != "" {
+		// So is the image file
+		pv.Annotations[annImageFile] = image
+		pv.Annotations[annImageFilesystem] = imageFS.fsType
+		pv.Annotations[annPreallocation] = preallocation
+		pv.Spec.Capacity[v1.ResourceStorage] = requestedCapacity
+	}
+	if stripes != nil {
+		// The stripes together are as large as requested
+		pv.Annotations[annStripes] = strings.Join(stripeImages, ",")
+		pv.Spec.Capacity[v1.ResourceStorage] = requestedCapacity
+	}
+	if backing == backingTmpfs {
+		pv.Annotations[annTmpfsSize] = requestedCapacity.String()
+		pv.Spec.Capacity[v1.ResourceStorage] = requestedCapacity
+	}
+	if backing == backingQcow2 {
+		// The virtual size of the image is the requested size
+		pv.Annotations[annPreallocation] = preallocation
+		pv.Spec.Capacity[v1.ResourceStorage] = requestedCapacity
+	}
+	if export {
+		// Pods on any node mount the volume from the NFS server of this one
+		pv.Annotations[annNFSExport] = p.nfsServer
+		pv.Spec.PersistentVolumeSource = v1.PersistentVolumeSource{
+			NFS: &v1.NFSVolumeSource{
+				Server: p.nfsServer,
+				Path:   vPath,
+			},
+		}
+		pv.Spec.NodeAffinity = nil
+	}
+	klog.InfoS("Provisioned volume", "correlationID", id, "pvc", pvc, "pv", options.PVName, "node", p.nodeName, "pool", pool.name, "duration", time.Since(start))
+	return pv, nil
+}
+
+// Delete removes the storage asset that was created by Provision represented
+// by the given PV.
+func (p *HostPathProvisioner) Delete(ctx context.Context, volume *v1.PersistentVolume) (err error) {
+	ann, ok := volume.Annotations["hostPathProvisionerIdentity"]
+	if !ok {
+		return errors.New("identity annotation not found on PV")
+	}
+	if !p.ownsIdentity(ann) {
+		return &controller.IgnoredError{Reason: "identity annotation on PV does not match ours"}
+	}
+	if !isCorrectNode(volume.Annotations, p.nodeName, "kubevirt.io/provisionOnNode") {
+		return &controller.IgnoredError{Reason: "identity annotation on pvc does not match ours, not deleting PV"}
+	}
+
+	start := time.Now()
+	uid := volume.UID
+	if volume.Spec.ClaimRef != nil {
+		uid = volume.Spec.ClaimRef.UID
+	}
+	id := p.attempts.correlationID(admin.OperationDelete, uid)
+	trace := p.tracer.startTrace("Delete", "correlationID", id, "pv", volume.Name, "node", p.nodeName)
+	defer func() { trace.end(err) }()
+	if !p.dryRun {
+		if err := p.beforeDelete(volume); err != nil {
+			return withCorrelationID(err, id)
+		}
+	}
+	if device := volume.Annotations[annDevice]; device != "" {
+		return p.deleteDevice(ctx, volume, device, id, start, trace)
+	}
+	path := backingPath(volume)
+	klog.InfoS("Removing backing directory", "correlationID", id, "pv", volume.Name, "node", p.nodeName, "pool", volume.Annotations[annStoragePool], "path", path)
+	if p.runContext().Err() != nil {
+		return errShuttingDown
+	}
+	if err := ctx.Err(); err != nil {
+		return withCorrelationID(err, id)
+	}
+	if p.dryRun {
+		return p.dryRunDelete(volume, path, id)
+	}
+	if isTemplate(volume) {
+		if err := p.checkTemplateClones(volume, path); err != nil {
+			return err
+		}
+	}
+	if err := p.intents.begin(admin.OperationDelete, volume.Name, path, backingOf(volume)); err != nil {
+		return withCorrelationID(err, id)
+	}
+	defer p.intents.done(admin.OperationDelete, volume.Name)
+	p.allocations.setState(volume.Name, allocationDeleting)
+	p.tamper.expectRemoval(path)
+	span := trace.child("RemoveDirectory")
+	err = p.fsOps.run(ctx, "removing backing directory", path, p.deleteTimeout, func() error {
+		if volume.Spec.NFS != nil {
+			if err := p.nfsExports.remove(path); err != nil {
+				return err
+			}
+		}
+		if lv := volume.Annotations[annLVMVolume]; lv != "" {
+			if err := p.lvm().remove(lv, path); err != nil {
+				return err
+			}
+		}
+		if images := stripeImagesOf(volume); images != nil {
+			if err := p.loopDevices.removeStripedVolume(images, path); err != nil {
+				return err
+			}
+		}
+		if image := volume.Annotations[annImageFile]; image != "" {
+			if volume.Annotations[annVerityRootHash] != "" {
+				if err := unsealImageVolume(image, path, verityName(volume.Name)); err != nil {
+					return err
+				}
+			}
+			luks := ""
+			if volume.Annotations[annEncryptionSecret] != "" {
+				luks = luksName(volume.Name)
+			}
+			if err := p.loopDevices.removeImageVolume(image, path, luks); err != nil {
+				return err
+			}
+		}
+		if volume.Annotations[annOverlayLower] != "" {
+			if err := removeOverlay(path); err != nil {
+				return err
+			}
+		}
+		if _, ok := tmpfsSize(*volume); ok {
+			if err := unmountTmpfs(path); err != nil {
+				return err
+			}
+			p.tmpfsVolumes.release(volume.Name)
+		}
+		return p.host.Destroy(path)
+	})
+	span.end(err)
+	if _, ok := err.(*timeoutError); ok {
+		p.volumeEvent(volume, v1.EventTypeWarning, eventReasonOperationTimedOut, "%v on node %s (correlation ID %s)", err, p.nodeName, id)
+	}
+	op := admin.Operation{Type: admin.OperationDelete, CorrelationID: id, PV: volume.Name, Pool: volume.Annotations[annStoragePool], Path: path}
+	if volume.Spec.ClaimRef != nil {
+		op.Claim = volume.Spec.ClaimRef.Namespace + "/" + volume.Spec.ClaimRef.Name
+	}
+	p.operations.record(op, start, err)
+	if err != nil {
+		klog.ErrorS(err, "Failed to remove backing directory", "correlationID", id, "pv", volume.Name, "node", p.nodeName, "path", path)
+		return withCorrelationID(err, id)
+	}
+	p.attempts.forget(admin.OperationDelete, uid)
+	p.allocations.remove(volume.Name)
+	// The controller deletes volumes whose PV it failed to store
+	p.intents.done(admin.OperationProvision, volume.Name)
+	if volume.Annotations[annFscryptKey] != "" {
+		if err := p.releaseFscryptKey(volume, path); err != nil {
+			klog.Warningf("unable to remove the encryption key of volume %s: %v", volume.Name, err)
+		}
+	}
+	if !p.simulated {
+		if err := removeVolumeIdentity(path); err != nil {
+			klog.Warningf("unable to remove the identity file of backing directory %s: %v", path, err)
+		}
+	}
+	if volume.Spec.ClaimRef != nil {
+		p.symlinks.unlink(path, volume.Spec.ClaimRef.Namespace, volume.Spec.ClaimRef.Name)
+	}
+
+	klog.InfoS("Deleted volume", "correlationID", id, "pv", volume.Name, "node", p.nodeName, "duration", time.Since(start))
+	return nil
+}
+
+// SupportsBlock returns whether block claims can be provisioned, they are
+// given whole devices of device pools.
+func (p *HostPathProvisioner) SupportsBlock(ctx context.Context) bool {
+	return hasDevicePool(p.currentPools())
+}
+
+// backingPath returns the backing directory of a volume, whether it is used
+// on the node, exported over NFS or a volume of the CSI driver, or an empty
+// string for whole devices.
+func backingPath(pv *v1.PersistentVolume) string {
+	switch {
+	case pv.Spec.HostPath != nil:
+		return pv.Spec.HostPath.Path
+	case pv.Spec.NFS != nil && pv.Annotations[annNFSExport] != "":
+		return pv.Spec.NFS.Path
+	case pv.Spec.CSI != nil:
+		return pv.Spec.CSI.VolumeAttributes[csiPathKey]
+	}
+	return ""
+}
+
+// volumeAnnotations returns the annotations describing the volume of a PV.
+// PVs of the CSI driver are created by the external-provisioner, which does
+// not copy them, their volume context carries them instead.
+func volumeAnnotations(pv *v1.PersistentVolume) map[string]string {
+	if pv.Spec.CSI != nil && pv.Spec.CSI.VolumeAttributes[csiPathKey] != "" {
+		return pv.Spec.CSI.VolumeAttributes
+	}
+	return pv.Annotations
+}
+
+// nodeAffinity restricts a volume to the node.
+func nodeAffinity(nodeName string) *v1.VolumeNodeAffinity {
+	return &v1.VolumeNodeAffinity{
+		Required: &v1.NodeSelector{
+			NodeSelectorTerms: []v1.NodeSelectorTerm{
+				{
+					MatchExpressions: []v1.NodeSelectorRequirement{
+						{
+							Key:      "kubernetes.io/hostname",
+							Operator: v1.NodeSelectorOpIn,
+							Values: []string{
+								nodeName,
+							},
+						},
+					},
+				},
+			},
+		},
+	}
+}
+
+func calculatePvCapacity(host backend, path string) (*resource.Quantity, error) {
+	return calculateRoundedPvCapacity(host, path, defaultCapacityRounding)
+}
+
+// Round down the capacity to an easy to read value. Blatantly stolen from here: https://github.com/kubernetes-incubator/external-storage/blob/master/local-volume/provisioner/pkg/discovery/discovery.go#L339
+func roundDownCapacityPretty(capacityBytes int64) int64 {
+
+	easyToReadUnitsBytes := []int64{GiB, MiB}
+
+	// Round down to the nearest easy to read unit
+	// such that there are at least 10 units at that size.
+	for _, easyToReadUnitBytes := range easyToReadUnitsBytes {
+		// Round down the capacity to the nearest unit.
+		size := capacityBytes / easyToReadUnitBytes
+		if size >= 10 {
+			return size * easyToReadUnitBytes
+		}
+	}
+	return capacityBytes
+}
+
+// Run runs a provisioner with the configuration read from sources until ctx
+// is canceled. It then stops taking on new work and waits up to the shutdown
+// timeout for the claims and volumes being processed, instead of leaving a
+// backing directory half created or removed. The provisioner talks to the
+// API server with config.
+func Run(ctx context.Context, config *rest.Config, sources ConfigSources) error {
+	klog.Info(Version())
+	client, err := kubernetes.NewForConfig(config)
+	if err != nil {
+		return fmt.Errorf("unable to create client: %v", err)
+	}
+	getenv := sources.Getenv
+	if getenv == nil {
+		getenv = os.Getenv
+	}
+	loader := &configLoader{
+		path:      sources.File,
+		client:    client,
+		namespace: getPodNamespace(),
+		configMap: sources.ConfigMap,
+		getenv:    getenv,
+		override:  sources.Override,
+	}
+	cfg, err := loader.load()
+	if err != nil {
+		return fmt.Errorf("invalid configuration: %v", err)
+	}
+
+	setLogFormat(cfg.LogFormat)
+	handleVerbositySignals(verbosity())
+	if cfg.PprofPort > 0 {
+		servePprof(cfg.PprofPort)
+	}
+
+	// Create the provisioner: it implements the Provisioner interface expected by
+	// the controller
+	p, err := NewHostPathProvisioner(client, cfg)
+	if err != nil {
+		return err
+	}
+	defer p.cancel()
+	identity, err := leaderIdentity()
+	if err != nil {
+		return fmt.Errorf("unable to determine the leader election identity: %v", err)
+	}
+	mgr, err := newManager(config, client, cfg, p.nodeName, identity)
+	if err != nil {
+		return fmt.Errorf("unable to create manager: %v", err)
+	}
+	if err := p.loops.setManager(mgr); err != nil {
+		return err
+	}
+	// The manager and the controller outlive ctx, until the work in progress
+	// is done
+	mgrCtx, stopManager := context.WithCancel(context.Background())
+	defer stopManager()
+	if loader.path != "" || loader.configMap != "" {
+		reloader := newConfigReloader(p, loader, cfg)
+		reloader.handleSignals()
+		if sources.ReloadInterval > 0 {
+			p.loops.add("config-reload", func(stopCh <-chan struct{}) {
+				reloader.Run(sources.ReloadInterval, stopCh)
+			})
+		}
+	}
+
+	klog.Infof("creating provisioner controller with name: %s\n", cfg.ProvisionerName)
+	// Start the provision controller which will dynamically provision hostPath
+	// PVs
+	options, err := cfg.Controller.options()
+	if err != nil {
+		return fmt.Errorf("invalid controller configuration: %v", err)
+	}
+	claims, volumes, classes, err := sharedInformers(mgrCtx, mgr.GetCache())
+	if err != nil {
+		return fmt.Errorf("unable to share the informers of the manager: %v", err)
+	}
+	options = append(options, controller.AdditionalProvisionerNames(cfg.ProvisionerAliases))
+	pc := newProvisionController(p, client, cfg.ProvisionerName, identity, cfg.Controller.Workers, cfg.Controller.ExitOnPanic,
+		claims, volumes, classes, options...)
+	if cfg.Controller.MetricsPort > 0 {
+		// Every replica serves the metrics, the leader's show the work
+		pc.serveMetrics(cfg.Controller.MetricsAddress, cfg.Controller.MetricsPort, cfg.Controller.MetricsPath)
+	}
+	// The elected replica takes over the backing directories of the node
+	p.loops.addLeaderOnly("intents", func(stopCh <-chan struct{}) {
+		if err := p.takeOver(stopCh); err != nil {
+			klog.ErrorS(err, "Failed to take over the node", "node", p.nodeName)
+			p.loops.fail("intents")
+		}
+	})
+	// The controller processes claims and volumes once the replica took over
+	// the node
+	p.loops.addLeaderOnly("provision-controller", func(stopCh <-chan struct{}) {
+		select {
+		case <-p.leading:
+			pc.run(wait.ContextForChannel(stopCh))
+		case <-stopCh:
+		}
+	})
+	if err := addHealthChecks(mgr, p, pc, cfg.WorkerDeadline.Duration); err != nil {
+		return err
+	}
+	if cfg.AdminSocket != "" {
+		if err := serveAdmin(cfg.AdminSocket, p, pc.Resync); err != nil {
+			return err
+		}
+	}
+	if cfg.AdminPort > 0 {
+		serveReadOnlyAdmin(cfg.AdminPort, p)
+	}
+	if cfg.AdminGRPCSocket != "" {
+		if err := serveAdminGRPC(cfg.AdminGRPCSocket, p, pc.Resync); err != nil {
+			return err
+		}
+	}
+	if cfg.CSIEndpoint != "" {
+		// Classes naming the driver are provisioned through the CSI sidecars,
+		// the others by the controller
+		driver := newCSIDriver(p, cfg.CSIDriverName)
+		p.loops.add("csi-driver", func(stopCh <-chan struct{}) {
+			if err := serveCSI(cfg.CSIEndpoint, driver, stopCh); err != nil {
+				klog.Errorf("Failed to serve the CSI driver on %s: %v", cfg.CSIEndpoint, err)
+			}
+		})
+	}
+	if interval := cfg.NodeStatusInterval.Duration; interval > 0 {
+		publisher := newNodeStatusPublisher(p, pc)
+		p.loops.addLeaderOnly("node-status", func(stopCh <-chan struct{}) {
+			publisher.Run(interval, stopCh)
+		})
+	}
+	if inventory := cfg.DeviceInventory; inventory.Interval.Duration > 0 {
+		filter, err := newDeviceFilter(inventory.MinSize, inventory.Model, inventory.Serial)
+		if err != nil {
+			return fmt.Errorf("invalid device inventory filter: %v", err)
+		}
+		publisher := newDeviceInventoryPublisher(p, pc, filter, inventory.ApprovedDevicesDir)
+		p.loops.addLeaderOnly("device-inventory", func(stopCh <-chan struct{}) {
+			publisher.Run(inventory.Interval.Duration, stopCh)
+		})
+	}
+	klog.InfoS("Running background loops", "node", p.nodeName, "loops", p.loops.names())
+	// The loops list PVs from the shared cache once it has synced
+	go p.pvCache.useCache(mgrCtx, mgr.GetCache())
+	stopped := make(chan error, 1)
+	go func() {
+		stopped <- mgr.Start(mgrCtx)
+	}()
+
+	select {
+	case err := <-stopped:
+		// The manager did not start, or the leader lost its lock
+		return fmt.Errorf("manager stopped: %v", err)
+	case <-ctx.Done():
+	}
+	timeout := cfg.ShutdownTimeout.Duration
+	klog.InfoS("Shutting down", "timeout", timeout)
+	err = p.shutdown(pc, timeout)
+	// Stopping the manager stops the loops and releases the leader election
+	// lock
+	stopManager()
+	if mgrErr := <-stopped; mgrErr != nil && err == nil {
+		err = mgrErr
+	}
+	return err
+}
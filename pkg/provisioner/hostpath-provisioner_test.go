@@ -14,19 +14,19 @@ See the License for the specific language governing permissions and
 limitations under the License.
 */
 
-package main
+package provisioner
 
 import (
+	"context"
 	"io/ioutil"
 	"os"
 	"testing"
 
-	"golang.org/x/sys/unix"
-
 	v1 "k8s.io/api/core/v1"
 	storage "k8s.io/api/storage/v1"
 	"k8s.io/apimachinery/pkg/api/resource"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
 )
 
 func getKubevirtNodeAnnotation(value string) map[string]string {
@@ -177,21 +177,51 @@ func Test_isCorrectNodeByBindingMode(t *testing.T) {
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			if got := isCorrectNodeByBindingMode(tt.args.annotations, tt.args.nodeName, tt.args.bindingMode); got != tt.want {
+			if got := isCorrectNodeByBindingMode(tt.args.annotations, tt.args.nodeName, defaultProvisionerName, tt.args.bindingMode); got != tt.want {
 				t.Errorf("isCorrectNodeByBindingMode() = %v, want %v", got, tt.want)
 			}
 		})
 	}
 }
 
+func Test_matchesClaimSelector(t *testing.T) {
+	tests := []struct {
+		name     string
+		selector string
+		labels   map[string]string
+		want     bool
+	}{
+		{name: "empty selector matches unlabelled claim", selector: "", want: true},
+		{name: "matching label", selector: "storage=hostpath", labels: map[string]string{"storage": "hostpath"}, want: true},
+		{name: "different label value", selector: "storage=hostpath", labels: map[string]string{"storage": "ceph"}, want: false},
+		{name: "missing label", selector: "storage=hostpath", want: false},
+		{name: "set based selector", selector: "storage in (hostpath,local)", labels: map[string]string{"storage": "local"}, want: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			selector, err := labels.Parse(tt.selector)
+			if err != nil {
+				t.Fatalf("labels.Parse() error = %v", err)
+			}
+			p := &HostPathProvisioner{claimSelector: selector}
+			pvc := &v1.PersistentVolumeClaim{ObjectMeta: metav1.ObjectMeta{Labels: tt.labels}}
+			if got := p.matchesClaimSelector(pvc); got != tt.want {
+				t.Errorf("matchesClaimSelector() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
 func Test_Delete(t *testing.T) {
 	type args struct {
 		identity string
 		nodeName string
 	}
-	testProvisioner := &hostPathProvisioner{
+	testProvisioner := &HostPathProvisioner{
+		host:     hostBackend(),
 		nodeName: "testNode",
 		identity: "testId",
+		aliases:  []string{"formerId"},
 	}
 
 	tests := []struct {
@@ -223,6 +253,14 @@ func Test_Delete(t *testing.T) {
 			},
 			wantErr: false,
 		},
+		{
+			name: "Delete matching alias",
+			args: args{
+				identity: "formerId",
+				nodeName: "testNode",
+			},
+			wantErr: false,
+		},
 	}
 	for _, tt := range tests {
 		file, err := ioutil.TempFile("", "test")
@@ -232,7 +270,7 @@ func Test_Delete(t *testing.T) {
 		defer os.Remove(file.Name())
 		pv := createPv(tt.args.identity, tt.args.nodeName, file.Name())
 		t.Run(tt.name, func(t *testing.T) {
-			err := testProvisioner.Delete(pv)
+			err := testProvisioner.Delete(context.TODO(), pv)
 			if (err != nil) != tt.wantErr || (err == nil) == tt.wantErr {
 				t.Errorf("Delete, error = %v, wantErr %v", err, tt.wantErr)
 				return
@@ -275,7 +313,7 @@ func Test_calculatePvCapacity(t *testing.T) {
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			got, err := calculatePvCapacity(tt.args.path)
+			got, err := calculatePvCapacity(hostBackend(), tt.args.path)
 			if (err != nil) != tt.wantErr {
 				t.Errorf("calculatePvCapacity() error = %v, wantErr %v", err, tt.wantErr)
 				return
@@ -342,14 +380,11 @@ func Test_roundDownCapacityPretty(t *testing.T) {
 }
 
 func getTotalCapacity(path string) (int64, error) {
-	statfs := &unix.Statfs_t{}
-	err := unix.Statfs(path, statfs)
+	stats, err := hostBackend().Stat(path)
 	if err != nil {
 		return int64(-1), err
 	}
-
-	// Capacity is total block count * block size
-	return int64(statfs.Blocks) * statfs.Bsize, nil
+	return stats.capacity, nil
 }
 
 func createPv(identity, nodeName, dirPath string) *v1.PersistentVolume {
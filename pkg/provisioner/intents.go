@@ -0,0 +1,374 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package provisioner
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+
+	v1 "k8s.io/api/core/v1"
+	apierrs "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/klog/v2"
+	"kubevirt.io/hostpath-provisioner/admin"
+)
+
+// journalLockInterval is how often a new leader tries to lock the intent
+// journal.
+const journalLockInterval = time.Second
+
+// intent is a backing directory being created or removed.
+type intent struct {
+	// Operation is admin.OperationProvision or admin.OperationDelete
+	Operation string    `json:"operation"`
+	PV        string    `json:"pv"`
+	Path      string    `json:"path"`
+	Started   time.Time `json:"started"`
+	// Backing is what the directory is backed by, torn down with it
+	Backing *intentBacking `json:"backing,omitempty"`
+}
+
+// intentBacking is what a backing directory is mounted from, recorded so
+// that an interrupted operation can unmount and remove it.
+type intentBacking struct {
+	LV      string   `json:"lv,omitempty"`
+	Image   string   `json:"image,omitempty"`
+	LUKS    string   `json:"luks,omitempty"`
+	Verity  string   `json:"verity,omitempty"`
+	Stripes []string `json:"stripes,omitempty"`
+	Overlay bool     `json:"overlay,omitempty"`
+	Tmpfs   bool     `json:"tmpfs,omitempty"`
+	Export  bool     `json:"export,omitempty"`
+}
+
+// backingOf returns what the backing directory of the volume is backed by.
+func backingOf(volume *v1.PersistentVolume) *intentBacking {
+	b := &intentBacking{
+		LV:      volume.Annotations[annLVMVolume],
+		Image:   volume.Annotations[annImageFile],
+		Stripes: stripeImagesOf(volume),
+		Overlay: volume.Annotations[annOverlayLower] != "",
+		Export:  volume.Spec.NFS != nil,
+	}
+	if b.Image != "" && volume.Annotations[annEncryptionSecret] != "" {
+		b.LUKS = luksName(volume.Name)
+	}
+	if b.Image != "" && volume.Annotations[annVerityRootHash] != "" {
+		b.Verity = verityName(volume.Name)
+	}
+	_, b.Tmpfs = tmpfsSize(*volume)
+	return b
+}
+
+// teardown unexports and unmounts the backing directory of the volume at
+// path and removes what it was mounted from, in the order Delete does. Parts
+// that were never created are skipped.
+func (b *intentBacking) teardown(p *HostPathProvisioner, pv, path string) error {
+	if b == nil {
+		return nil
+	}
+	if b.Export {
+		if err := p.nfsExports.remove(path); err != nil {
+			return err
+		}
+	}
+	if b.LV != "" {
+		if err := p.lvm().remove(b.LV, path); err != nil {
+			return err
+		}
+	}
+	if b.Stripes != nil {
+		if err := p.loopDevices.removeStripedVolume(b.Stripes, path); err != nil {
+			return err
+		}
+	}
+	if b.Image != "" {
+		if b.Verity != "" {
+			if err := unsealImageVolume(b.Image, path, b.Verity); err != nil {
+				return err
+			}
+		}
+		if err := p.loopDevices.removeImageVolume(b.Image, path, b.LUKS); err != nil {
+			return err
+		}
+	}
+	if b.Overlay {
+		if err := removeOverlay(path); err != nil {
+			return err
+		}
+	}
+	if b.Tmpfs {
+		if err := unmountTmpfs(path); err != nil {
+			return err
+		}
+		p.tmpfsVolumes.release(pv)
+	}
+	return nil
+}
+
+// intentJournal keeps the operations in progress in a file, replaced as a
+// whole on every change. The journal is locked while it is open, by one
+// process at a time. A nil journal records nothing.
+type intentJournal struct {
+	path string
+	// lock is the open lock file, closing it releases the lock
+	lock *os.File
+
+	mutex   sync.Mutex
+	intents map[string]intent
+}
+
+// errLocked is returned when opening a journal another process has open.
+var errLocked = errors.New("locked by another process")
+
+// intentKey identifies the operation on the volume.
+func intentKey(operation, pv string) string {
+	return operation + "/" + pv
+}
+
+// openIntentJournal locks the journal at path and reads the operations that
+// were in progress from it, a missing file has none. It fails with errLocked
+// while another process has the journal open.
+func openIntentJournal(path string) (*intentJournal, error) {
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return nil, err
+	}
+	lock, err := os.OpenFile(path+".lock", os.O_CREATE|os.O_RDWR, 0600)
+	if err != nil {
+		return nil, err
+	}
+	if err := lockFile(lock); err != nil {
+		lock.Close()
+		return nil, err
+	}
+	j := &intentJournal{path: path, lock: lock, intents: make(map[string]intent)}
+	data, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return j, nil
+	} else if err != nil {
+		j.close()
+		return nil, err
+	}
+	var intents []intent
+	if err := json.Unmarshal(data, &intents); err != nil {
+		j.close()
+		return nil, fmt.Errorf("unable to parse intent file %s: %v", path, err)
+	}
+	for _, i := range intents {
+		j.intents[intentKey(i.Operation, i.PV)] = i
+	}
+	return j, nil
+}
+
+// close releases the lock of the journal, which records nothing afterwards.
+func (j *intentJournal) close() error {
+	return j.lock.Close()
+}
+
+// begin records that the operation on the volume's backing directory at path,
+// backed by backing when it is mounted from something, is starting.
+func (j *intentJournal) begin(operation, pv, path string, backing *intentBacking) error {
+	if j == nil {
+		return nil
+	}
+	j.mutex.Lock()
+	defer j.mutex.Unlock()
+	key := intentKey(operation, pv)
+	j.intents[key] = intent{Operation: operation, PV: pv, Path: path, Started: time.Now(), Backing: backing}
+	if err := j.save(); err != nil {
+		delete(j.intents, key)
+		return fmt.Errorf("unable to record the intent to %s volume %s: %v", operation, pv, err)
+	}
+	return nil
+}
+
+// done records that the operation on the volume is over. A provisioning
+// that succeeded is over once its PV is stored, see VolumeStored.
+func (j *intentJournal) done(operation, pv string) {
+	if j == nil {
+		return
+	}
+	j.mutex.Lock()
+	defer j.mutex.Unlock()
+	key := intentKey(operation, pv)
+	if _, ok := j.intents[key]; !ok {
+		return
+	}
+	delete(j.intents, key)
+	if err := j.save(); err != nil {
+		klog.Warningf("unable to record that the operation to %s volume %s is over: %v", operation, pv, err)
+	}
+}
+
+// pending returns the operations in progress, oldest first.
+func (j *intentJournal) pending() []intent {
+	j.mutex.Lock()
+	defer j.mutex.Unlock()
+	intents := make([]intent, 0, len(j.intents))
+	for _, i := range j.intents {
+		intents = append(intents, i)
+	}
+	sort.Slice(intents, func(a, b int) bool {
+		return intents[a].Started.Before(intents[b].Started)
+	})
+	return intents
+}
+
+// save writes the intents to the journal.
+func (j *intentJournal) save() error {
+	intents := make([]intent, 0, len(j.intents))
+	for _, i := range j.intents {
+		intents = append(intents, i)
+	}
+	data, err := json.Marshal(intents)
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(j.path, data)
+}
+
+// writeFileAtomic writes data to a temporary file and renames it over the
+// file at path, a crash leaves either the old or the new contents. The
+// directory is created when missing.
+func writeFileAtomic(path string, data []byte) error {
+	dir := filepath.Dir(path)
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return err
+	}
+	tmp, err := ioutil.TempFile(dir, "."+filepath.Base(path))
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+// replayIntents cleans up after the operations a restart interrupted.
+// Backing directories of volumes whose provisioning was interrupted before
+// their PV was stored are removed, no PV refers to them and a retry
+// provisions the claim again. Interrupted removals are finished when their
+// PV is gone, otherwise the controller deletes the volume again. Logical
+// volumes, images, tmpfs and overlays are unmounted and removed with the
+// directory, mount points of unknown origin are left to the administrator.
+func (p *HostPathProvisioner) replayIntents(j *intentJournal, pvExists func(name string) (bool, error)) {
+	for _, i := range j.pending() {
+		exists, err := pvExists(i.PV)
+		if err != nil {
+			klog.ErrorS(err, "Unable to look up the volume of an interrupted operation, trying again on the next start", "operation", i.Operation, "pv", i.PV, "path", i.Path)
+			continue
+		}
+		switch {
+		case exists && i.Operation == admin.OperationDelete:
+			klog.InfoS("Removal of volume was interrupted, leaving it to be deleted again", "pv", i.PV, "path", i.Path)
+		case exists:
+			klog.InfoS("Provisioning of volume was interrupted after its PV was created, keeping it", "pv", i.PV, "path", i.Path)
+		default:
+			klog.InfoS("Removing backing directory of interrupted operation", "operation", i.Operation, "pv", i.PV, "path", i.Path)
+			if err := i.Backing.teardown(p, i.PV, i.Path); err != nil {
+				klog.ErrorS(err, "Failed to tear down backing of interrupted operation, trying again on the next start", "pv", i.PV, "path", i.Path)
+				continue
+			}
+			if isMountPoint(i.Path) {
+				klog.Warningf("%s of volume %s was interrupted, its backing directory %s is still a mount point and is left to be cleaned up by hand", i.Operation, i.PV, i.Path)
+				break
+			}
+			if err := p.host.Destroy(i.Path); err != nil {
+				klog.ErrorS(err, "Failed to remove backing directory of interrupted operation, trying again on the next start", "pv", i.PV, "path", i.Path)
+				continue
+			}
+			if err := removeVolumeIdentity(i.Path); err != nil {
+				klog.Warningf("unable to remove the identity file of backing directory %s: %v", i.Path, err)
+			}
+		}
+		j.done(i.Operation, i.PV)
+	}
+}
+
+// takeOver makes this replica the one working with the backing directories
+// of the node once it is elected, standby replicas never touch the intent
+// journal. It waits for the lock of the journal, held by the previous leader
+// until it exits, cleans up after the operations that were interrupted and
+// then lets the controller and the CSI driver provision and delete volumes.
+// It returns an error when the journal can not be opened.
+func (p *HostPathProvisioner) takeOver(stopCh <-chan struct{}) error {
+	if p.intentFile != "" {
+		waiting := false
+		err := wait.PollUntilContextCancel(wait.ContextForChannel(stopCh), journalLockInterval, true, func(context.Context) (bool, error) {
+			j, err := openIntentJournal(p.intentFile)
+			if err == errLocked {
+				if !waiting {
+					klog.InfoS("Waiting for the previous leader to release the intent journal", "node", p.nodeName, "path", p.intentFile)
+					waiting = true
+				}
+				return false, nil
+			}
+			if err != nil {
+				return false, err
+			}
+			p.intents = j
+			return true, nil
+		})
+		if wait.Interrupted(err) {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("unable to open the intent journal: %v", err)
+		}
+		p.replayIntents(p.intents, p.pvExists)
+	}
+	close(p.leading)
+	return nil
+}
+
+// isLeading returns whether the replica took over the node, see takeOver.
+func (p *HostPathProvisioner) isLeading() bool {
+	select {
+	case <-p.leading:
+		return true
+	default:
+		return false
+	}
+}
+
+// pvExists returns whether the PV exists.
+func (p *HostPathProvisioner) pvExists(name string) (bool, error) {
+	_, err := p.client.CoreV1().PersistentVolumes().Get(context.TODO(), name, metav1.GetOptions{})
+	if apierrs.IsNotFound(err) {
+		return false, nil
+	}
+	return err == nil, err
+}
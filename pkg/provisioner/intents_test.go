@@ -0,0 +1,215 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package provisioner
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	"k8s.io/client-go/kubernetes/fake"
+	"kubevirt.io/hostpath-provisioner/admin"
+)
+
+func Test_intentJournal(t *testing.T) {
+	dir, err := ioutil.TempDir("", "intents")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "state", "intents.json")
+
+	j, err := openIntentJournal(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := j.begin(admin.OperationProvision, "pvc-1", "/pool/pvc-1", nil); err != nil {
+		t.Fatal(err)
+	}
+	if err := j.begin(admin.OperationDelete, "pvc-2", "/pool/pvc-2", &intentBacking{LV: "vg0/pvc-2"}); err != nil {
+		t.Fatal(err)
+	}
+	j.done(admin.OperationProvision, "pvc-1")
+
+	// Another process can not open the journal while it is open
+	if _, err := openIntentJournal(path); err != errLocked {
+		t.Errorf("openIntentJournal() of an open journal, error = %v, want %v", err, errLocked)
+	}
+	if err := j.close(); err != nil {
+		t.Fatal(err)
+	}
+
+	// A restart finds the operation still in progress
+	reopened, err := openIntentJournal(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pending := reopened.pending()
+	if len(pending) != 1 || pending[0].Operation != admin.OperationDelete || pending[0].PV != "pvc-2" || pending[0].Path != "/pool/pvc-2" || pending[0].Backing == nil || pending[0].Backing.LV != "vg0/pvc-2" {
+		t.Errorf("pending() = %+v, want the removal of pvc-2", pending)
+	}
+	files, err := ioutil.ReadDir(filepath.Dir(path))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(files) != 2 {
+		t.Errorf("journal left %d files behind, want only the journal and its lock", len(files))
+	}
+	if err := reopened.close(); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := ioutil.WriteFile(path, []byte("{"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := openIntentJournal(path); err == nil {
+		t.Error("openIntentJournal() of a corrupt file succeeded")
+	}
+
+	// Without a journal nothing is recorded
+	var none *intentJournal
+	if err := none.begin(admin.OperationProvision, "pvc-3", "/pool/pvc-3", nil); err != nil {
+		t.Errorf("begin() without a journal = %v", err)
+	}
+	none.done(admin.OperationProvision, "pvc-3")
+}
+
+func Test_replayIntents(t *testing.T) {
+	dir, err := ioutil.TempDir("", "intents")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	memory := newMemoryBackend(10 * GiB)
+	j, err := openIntentJournal(filepath.Join(dir, "intents.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer j.close()
+	ran, restore := fakeCommands(map[string]string{
+		"lvm lvs vg0/lvm":            "",
+		"lvm lvremove --yes vg0/lvm": "",
+	})
+	defer restore()
+	operations := []struct {
+		operation string
+		pv        string
+		backing   *intentBacking
+	}{
+		{admin.OperationProvision, "provisioning", nil},
+		{admin.OperationProvision, "provisioned", nil},
+		{admin.OperationDelete, "deleting", nil},
+		{admin.OperationDelete, "deleted", nil},
+		{admin.OperationProvision, "unknown", nil},
+		{admin.OperationProvision, "lvm", &intentBacking{LV: "vg0/lvm"}},
+	}
+	for _, o := range operations {
+		path := "/simulated/pool/" + o.pv
+		if err := memory.Create(path, 0750, -1, -1); err != nil {
+			t.Fatal(err)
+		}
+		if err := j.begin(o.operation, o.pv, path, o.backing); err != nil {
+			t.Fatal(err)
+		}
+	}
+	pvs := map[string]bool{"provisioned": true, "deleting": true}
+	p := &HostPathProvisioner{host: memory}
+	p.replayIntents(j, func(name string) (bool, error) {
+		if name == "unknown" {
+			return false, errors.New("API server unavailable")
+		}
+		return pvs[name], nil
+	})
+
+	for pv, want := range map[string]bool{"provisioning": false, "provisioned": true, "deleting": true, "deleted": false, "unknown": true, "lvm": false} {
+		if _, ok := memory.dirs["/simulated/pool/"+pv]; ok != want {
+			t.Errorf("backing directory of %s kept = %v, want %v", pv, ok, want)
+		}
+	}
+	// The logical volume of the interrupted provisioning is removed too
+	if want := []string{"lvm lvs vg0/lvm", "lvm lvremove --yes vg0/lvm"}; !reflect.DeepEqual(*ran, want) {
+		t.Errorf("commands = %v, want %v", *ran, want)
+	}
+	// Only the operation whose volume could not be looked up is replayed
+	// again on the next start
+	pending := j.pending()
+	if len(pending) != 1 || pending[0].PV != "unknown" {
+		t.Errorf("pending() = %+v, want the provisioning of unknown", pending)
+	}
+}
+
+func Test_takeOver(t *testing.T) {
+	dir, err := ioutil.TempDir("", "intents")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	memory := newMemoryBackend(10 * GiB)
+	path := filepath.Join(dir, "intents.json")
+	// The previous leader was provisioning a volume when it lost the lease
+	previous, err := openIntentJournal(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := memory.Create("/simulated/pool/pvc-1", 0750, -1, -1); err != nil {
+		t.Fatal(err)
+	}
+	if err := previous.begin(admin.OperationProvision, "pvc-1", "/simulated/pool/pvc-1", nil); err != nil {
+		t.Fatal(err)
+	}
+	p := &HostPathProvisioner{client: fake.NewSimpleClientset(), host: memory, nodeName: "node01", intentFile: path, leading: make(chan struct{})}
+	stopCh := make(chan struct{})
+	defer close(stopCh)
+	taken := make(chan error, 1)
+	go func() {
+		taken <- p.takeOver(stopCh)
+	}()
+
+	// The new leader waits for the previous one to release the journal
+	select {
+	case err := <-taken:
+		t.Fatalf("takeOver() returned while the journal is locked, error = %v", err)
+	case <-time.After(2 * journalLockInterval):
+	}
+	if p.isLeading() {
+		t.Error("isLeading() = true before the journal was replayed")
+	}
+	if _, ok := memory.dirs["/simulated/pool/pvc-1"]; !ok {
+		t.Error("backing directory of the previous leader removed while it holds the journal")
+	}
+
+	if err := previous.close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := <-taken; err != nil {
+		t.Fatalf("takeOver() error = %v", err)
+	}
+	defer p.intents.close()
+	if !p.isLeading() {
+		t.Error("isLeading() = false after taking over")
+	}
+	if _, ok := memory.dirs["/simulated/pool/pvc-1"]; ok {
+		t.Error("backing directory of the interrupted provisioning kept")
+	}
+	if pending := p.intents.pending(); len(pending) != 0 {
+		t.Errorf("pending() = %+v after taking over, want none", pending)
+	}
+}
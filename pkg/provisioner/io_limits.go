@@ -0,0 +1,441 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package provisioner
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"k8s.io/klog/v2"
+
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/fields"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/client-go/kubernetes"
+	"sigs.k8s.io/sig-storage-lib-external-provisioner/v13/controller"
+)
+
+const (
+	// The StorageClass parameters, and claim annotations, capping the I/O
+	// of the pods using a volume.
+	readIOPSParameter       = "readIOPS"
+	writeIOPSParameter      = "writeIOPS"
+	readBandwidthParameter  = "readBandwidth"
+	writeBandwidthParameter = "writeBandwidth"
+
+	// annIOLimits records the limits of a volume on its PV, in the key=value
+	// syntax of io.max, e.g. "rbps=104857600 riops=1000".
+	annIOLimits = "kubevirt.io/ioLimits"
+)
+
+// ioLimits are the limits of a volume, 0 means unlimited.
+type ioLimits struct {
+	rbps, wbps, riops, wiops int64
+}
+
+// ioLimitKeys are the keys of io.max, in the order the kernel lists them.
+var ioLimitKeys = []string{"rbps", "wbps", "riops", "wiops"}
+
+func (l *ioLimits) field(key string) *int64 {
+	switch key {
+	case "rbps":
+		return &l.rbps
+	case "wbps":
+		return &l.wbps
+	case "riops":
+		return &l.riops
+	case "wiops":
+		return &l.wiops
+	}
+	return nil
+}
+
+// String returns the limits that are set in the key=value syntax of io.max.
+func (l ioLimits) String() string {
+	var parts []string
+	for _, key := range ioLimitKeys {
+		if value := *l.field(key); value > 0 {
+			parts = append(parts, fmt.Sprintf("%s=%d", key, value))
+		}
+	}
+	return strings.Join(parts, " ")
+}
+
+// parseIOLimits parses limits recorded with String.
+func parseIOLimits(value string) (ioLimits, error) {
+	limits := ioLimits{}
+	for _, part := range strings.Fields(value) {
+		kv := strings.SplitN(part, "=", 2)
+		if len(kv) != 2 || limits.field(kv[0]) == nil {
+			return ioLimits{}, fmt.Errorf("invalid I/O limit %q", part)
+		}
+		n, err := strconv.ParseInt(kv[1], 10, 64)
+		if err != nil || n <= 0 {
+			return ioLimits{}, fmt.Errorf("invalid I/O limit %q", part)
+		}
+		*limits.field(kv[0]) = n
+	}
+	return limits, nil
+}
+
+// lower returns the lower of the two limits of every key, a limit that is set
+// being lower than none.
+func (l ioLimits) lower(other ioLimits) ioLimits {
+	result := l
+	for _, key := range ioLimitKeys {
+		if value := *other.field(key); value > 0 && (*result.field(key) == 0 || value < *result.field(key)) {
+			*result.field(key) = value
+		}
+	}
+	return result
+}
+
+// add returns the sum of the two limits of every key, unlimited if either is.
+func (l ioLimits) add(other ioLimits) ioLimits {
+	result := ioLimits{}
+	for _, key := range ioLimitKeys {
+		if a, b := *l.field(key), *other.field(key); a > 0 && b > 0 {
+			*result.field(key) = a + b
+		}
+	}
+	return result
+}
+
+// ioLimitSettings map the parameters and annotations to the keys of io.max,
+// bandwidths are quantities such as 100Mi, IOPS plain numbers.
+var ioLimitSettings = []struct {
+	name      string
+	key       string
+	bandwidth bool
+}{
+	{name: readBandwidthParameter, key: "rbps", bandwidth: true},
+	{name: writeBandwidthParameter, key: "wbps", bandwidth: true},
+	{name: readIOPSParameter, key: "riops"},
+	{name: writeIOPSParameter, key: "wiops"},
+}
+
+// parseIOLimitSettings returns the limits set in values, keyed by parameter
+// name prefixed with prefix.
+func parseIOLimitSettings(values map[string]string, prefix string) (ioLimits, error) {
+	limits := ioLimits{}
+	for _, setting := range ioLimitSettings {
+		value, ok := values[prefix+setting.name]
+		if !ok {
+			continue
+		}
+		var n int64
+		if setting.bandwidth {
+			quantity, err := resource.ParseQuantity(value)
+			if err != nil {
+				return ioLimits{}, fmt.Errorf("invalid %s %q: %v", prefix+setting.name, value, err)
+			}
+			n = quantity.Value()
+		} else {
+			var err error
+			if n, err = strconv.ParseInt(value, 10, 64); err != nil {
+				return ioLimits{}, fmt.Errorf("invalid %s %q: %v", prefix+setting.name, value, err)
+			}
+		}
+		if n <= 0 {
+			return ioLimits{}, fmt.Errorf("invalid %s %q, it must be positive", prefix+setting.name, value)
+		}
+		*limits.field(setting.key) = n
+	}
+	return limits, nil
+}
+
+// ioLimitsFor returns the I/O limits of the claim's volume: those of its QoS
+// tier, if any, which the parameters of its StorageClass and then the claim's
+// kubevirt.io/ annotations can lower but not raise. tmpfs backed volumes do no
+// block I/O and can not be limited.
+func ioLimitsFor(options controller.ProvisionOptions, backing string, tier *qosTier) (ioLimits, error) {
+	limits := ioLimits{}
+	if tier != nil {
+		limits = tier.limits
+	}
+	if options.StorageClass != nil {
+		classLimits, err := parseIOLimitSettings(options.StorageClass.Parameters, "")
+		if err != nil {
+			return ioLimits{}, fmt.Errorf("storage class %s: %v", options.StorageClass.Name, err)
+		}
+		limits = limits.lower(classLimits)
+	}
+	claimLimits, err := parseIOLimitSettings(options.PVC.Annotations, "kubevirt.io/")
+	if err != nil {
+		return ioLimits{}, fmt.Errorf("claim %s/%s: %v", options.PVC.Namespace, options.PVC.Name, err)
+	}
+	limits = limits.lower(claimLimits)
+	if limits != (ioLimits{}) && backing == backingTmpfs {
+		return ioLimits{}, fmt.Errorf("tmpfs backed volumes do no block I/O, their I/O can not be limited")
+	}
+	return limits, nil
+}
+
+// blockDevice is the major and minor number of a block device.
+type blockDevice struct {
+	major, minor uint32
+}
+
+func (d blockDevice) String() string {
+	return fmt.Sprintf("%d:%d", d.major, d.minor)
+}
+
+// ioLimiter applies the I/O limits of the volumes on this node to the pods
+// using them, by writing io.max in the pods' cgroups for the device of each
+// volume: the loop device or logical volume of image backed and LVM volumes,
+// the disk of the pool for directories.
+type ioLimiter struct {
+	client     kubernetes.Interface
+	pvCache    *volumeCache
+	identity   string
+	nodeName   string
+	cgroupRoot string
+	// sysDevBlock is /sys/dev/block, where partitions are resolved to their
+	// disk
+	sysDevBlock string
+	// deviceOf returns the block device a volume's I/O goes to
+	deviceOf func(pv *v1.PersistentVolume) (blockDevice, error)
+
+	mutex sync.Mutex
+	// applied is the last io.max line written, or the error writing it, per
+	// pod cgroup and device, so that changes are only logged once
+	applied map[string]string
+}
+
+func newIOLimiter(client kubernetes.Interface, pvCache *volumeCache, identity, nodeName, cgroupRoot string) *ioLimiter {
+	l := &ioLimiter{
+		client:      client,
+		pvCache:     pvCache,
+		identity:    identity,
+		nodeName:    nodeName,
+		cgroupRoot:  cgroupRoot,
+		sysDevBlock: "/sys/dev/block",
+		applied:     make(map[string]string),
+	}
+	l.deviceOf = l.volumeDevice
+	return l
+}
+
+// Run applies the limits every interval until stopCh is closed, pods come and
+// go and get new cgroups.
+func (l *ioLimiter) Run(interval time.Duration, stopCh <-chan struct{}) {
+	if _, err := os.Stat(filepath.Join(l.cgroupRoot, "cgroup.controllers")); err != nil {
+		klog.Errorf("not enforcing I/O limits, %s is no cgroup v2 hierarchy: %v", l.cgroupRoot, err)
+		return
+	}
+	wait.Until(l.apply, interval, stopCh)
+}
+
+// volumeDevice returns the block device the I/O of the volume goes to, the
+// device of the filesystem of its backing directory or, for block volumes,
+// the device itself, the whole disk for partitions.
+func (l *ioLimiter) volumeDevice(pv *v1.PersistentVolume) (blockDevice, error) {
+	var device blockDevice
+	var err error
+	if pv.Annotations[annDevice] != "" && pv.Spec.Local != nil {
+		device.major, device.minor, err = fileDevice(pv.Spec.Local.Path, true)
+	} else {
+		device.major, device.minor, err = fileDevice(backingPath(pv), false)
+	}
+	if err != nil {
+		return blockDevice{}, err
+	}
+	if device.major == 0 {
+		return blockDevice{}, fmt.Errorf("volume %s is not on a block device", pv.Name)
+	}
+	return l.wholeDisk(device), nil
+}
+
+// wholeDisk returns the disk of a partition, io.max only takes whole disks.
+func (l *ioLimiter) wholeDisk(device blockDevice) blockDevice {
+	dir, err := filepath.EvalSymlinks(filepath.Join(l.sysDevBlock, device.String()))
+	if err != nil {
+		return device
+	}
+	if _, err := os.Stat(filepath.Join(dir, "partition")); err != nil {
+		return device
+	}
+	data, err := ioutil.ReadFile(filepath.Join(dir, "..", "dev"))
+	if err != nil {
+		return device
+	}
+	var disk blockDevice
+	if _, err := fmt.Sscanf(strings.TrimSpace(string(data)), "%d:%d", &disk.major, &disk.minor); err != nil {
+		return device
+	}
+	return disk
+}
+
+// podCgroup returns the cgroup of the pod, named after its UID by both the
+// systemd and the cgroupfs driver of the kubelet.
+func podCgroup(root string, uid string) (string, error) {
+	names := map[string]bool{"pod" + uid: true, "pod" + strings.Replace(uid, "-", "_", -1) + ".slice": true}
+	var found string
+	err := filepath.Walk(root, func(path string, info os.FileInfo, err error) error {
+		if err != nil || found != "" {
+			return filepath.SkipDir
+		}
+		if !info.IsDir() {
+			return nil
+		}
+		name := info.Name()
+		if names[name] || (strings.HasPrefix(name, "kubepods-") && names[name[strings.LastIndex(name, "-")+1:]]) {
+			found = path
+			return filepath.SkipDir
+		}
+		// Pods are below kubepods, in a directory of their QoS class unless
+		// they are guaranteed
+		if path != root && !strings.HasPrefix(name, "kubepods") && name != "burstable" && name != "besteffort" {
+			return filepath.SkipDir
+		}
+		return nil
+	})
+	if err != nil {
+		return "", err
+	}
+	if found == "" {
+		return "", fmt.Errorf("no cgroup found for pod %s", uid)
+	}
+	return found, nil
+}
+
+// ioMaxLine returns the io.max line setting the limits of the device, keys
+// without a limit are reset to max.
+func ioMaxLine(device blockDevice, limits ioLimits) string {
+	parts := []string{device.String()}
+	for _, key := range ioLimitKeys {
+		value := "max"
+		if n := *limits.field(key); n > 0 {
+			value = strconv.FormatInt(n, 10)
+		}
+		parts = append(parts, key+"="+value)
+	}
+	return strings.Join(parts, " ")
+}
+
+// podLimits returns the limits per device of the volumes the pod uses, those
+// of volumes on the same device are added up. Nil is returned when none of
+// its volumes is limited.
+func (l *ioLimiter) podLimits(pod *v1.Pod, volumes map[string]*v1.PersistentVolume) map[blockDevice]ioLimits {
+	var result map[blockDevice]ioLimits
+	limited := false
+	for _, volume := range pod.Spec.Volumes {
+		if volume.PersistentVolumeClaim == nil {
+			continue
+		}
+		pv := volumes[pod.Namespace+"/"+volume.PersistentVolumeClaim.ClaimName]
+		if pv == nil {
+			continue
+		}
+		limits, err := parseIOLimits(pv.Annotations[annIOLimits])
+		if err != nil {
+			klog.Warningf("ignoring the I/O limits of volume %s: %v", pv.Name, err)
+		}
+		device, err := l.deviceOf(pv)
+		if err != nil {
+			klog.V(3).Infof("not limiting the I/O of volume %s: %v", pv.Name, err)
+			continue
+		}
+		if result == nil {
+			result = make(map[blockDevice]ioLimits)
+		}
+		if current, ok := result[device]; ok {
+			limits = current.add(limits)
+		}
+		result[device] = limits
+		limited = limited || limits != (ioLimits{})
+	}
+	if !limited {
+		return nil
+	}
+	return result
+}
+
+// apply writes the limits of the volumes to the cgroups of the pods on the
+// node using them.
+func (l *ioLimiter) apply() {
+	pvs, err := l.pvCache.list(context.TODO(), l.client)
+	if err != nil {
+		klog.Warningf("unable to list persistent volumes, not applying I/O limits: %v", err)
+		return
+	}
+	volumes := make(map[string]*v1.PersistentVolume)
+	limited := false
+	for i := range pvs.Items {
+		pv := &pvs.Items[i]
+		if pv.Spec.ClaimRef == nil || pv.Annotations["hostPathProvisionerIdentity"] != l.identity || pv.Annotations["kubevirt.io/provisionOnNode"] != l.nodeName {
+			continue
+		}
+		volumes[pv.Spec.ClaimRef.Namespace+"/"+pv.Spec.ClaimRef.Name] = pv
+		limited = limited || pv.Annotations[annIOLimits] != ""
+	}
+	if !limited {
+		return
+	}
+	pods, err := l.client.CoreV1().Pods(v1.NamespaceAll).List(context.TODO(), metav1.ListOptions{FieldSelector: fields.OneTermEqualSelector("spec.nodeName", l.nodeName).String()})
+	if err != nil {
+		klog.Warningf("unable to list the pods of node %s, not applying I/O limits: %v", l.nodeName, err)
+		return
+	}
+	applied := make(map[string]string)
+	for i := range pods.Items {
+		pod := &pods.Items[i]
+		if pod.Status.Phase == v1.PodSucceeded || pod.Status.Phase == v1.PodFailed {
+			continue
+		}
+		limits := l.podLimits(pod, volumes)
+		if limits == nil {
+			continue
+		}
+		cgroup, err := podCgroup(l.cgroupRoot, string(pod.UID))
+		if err != nil {
+			klog.V(3).Infof("not limiting the I/O of pod %s/%s yet: %v", pod.Namespace, pod.Name, err)
+			continue
+		}
+		for device, limit := range limits {
+			line := ioMaxLine(device, limit)
+			key := cgroup + " " + device.String()
+			result := line
+			if err := ioutil.WriteFile(filepath.Join(cgroup, "io.max"), []byte(line), 0644); err != nil {
+				result = err.Error()
+			}
+			l.mutex.Lock()
+			previous := l.applied[key]
+			l.mutex.Unlock()
+			if result != previous {
+				if result == line {
+					klog.InfoS("Applied I/O limits", "node", l.nodeName, "pod", pod.Namespace+"/"+pod.Name, "device", device.String(), "limits", limit.String())
+				} else {
+					klog.Warningf("unable to limit the I/O of pod %s/%s on device %s: %s", pod.Namespace, pod.Name, device, result)
+				}
+			}
+			applied[key] = result
+		}
+	}
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	l.applied = applied
+}
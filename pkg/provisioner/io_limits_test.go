@@ -0,0 +1,222 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package provisioner
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	v1 "k8s.io/api/core/v1"
+	storagev1 "k8s.io/api/storage/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/sig-storage-lib-external-provisioner/v13/controller"
+)
+
+func Test_ioLimitsFor(t *testing.T) {
+	class := &storagev1.StorageClass{ObjectMeta: metav1.ObjectMeta{Name: "hostpath"}, Parameters: map[string]string{
+		readIOPSParameter:       "1000",
+		writeBandwidthParameter: "100Mi",
+	}}
+	claim := func(annotations map[string]string) *v1.PersistentVolumeClaim {
+		return &v1.PersistentVolumeClaim{ObjectMeta: metav1.ObjectMeta{Name: "disk", Namespace: "vms", Annotations: annotations}}
+	}
+	tests := []struct {
+		name    string
+		class   *storagev1.StorageClass
+		claim   *v1.PersistentVolumeClaim
+		backing string
+		tier    *qosTier
+		want    ioLimits
+		wantErr bool
+	}{
+		{name: "none", claim: claim(nil), backing: backingDirectory},
+		{name: "class", class: class, claim: claim(nil), backing: backingDirectory, want: ioLimits{riops: 1000, wbps: 100 << 20}},
+		{name: "claim lowers", class: class, claim: claim(map[string]string{"kubevirt.io/readIOPS": "200"}), backing: backingImage, want: ioLimits{riops: 200, wbps: 100 << 20}},
+		{name: "claim can't raise", class: class, claim: claim(map[string]string{"kubevirt.io/readIOPS": "5000"}), backing: backingImage, want: ioLimits{riops: 1000, wbps: 100 << 20}},
+		{name: "claim adds", class: class, claim: claim(map[string]string{"kubevirt.io/readBandwidth": "1G"}), backing: backingDirectory, want: ioLimits{rbps: 1000000000, riops: 1000, wbps: 100 << 20}},
+		{name: "tier", claim: claim(nil), backing: backingImage, tier: &qosTier{name: "gold", limits: ioLimits{riops: 5000, wiops: 2000}}, want: ioLimits{riops: 5000, wiops: 2000}},
+		{name: "class lowers tier", class: class, claim: claim(nil), backing: backingImage, tier: &qosTier{name: "gold", limits: ioLimits{riops: 5000, wiops: 2000}}, want: ioLimits{riops: 1000, wiops: 2000, wbps: 100 << 20}},
+		{name: "claim lowers tier", claim: claim(map[string]string{"kubevirt.io/writeIOPS": "500"}), backing: backingImage, tier: &qosTier{name: "gold", limits: ioLimits{riops: 5000, wiops: 2000}}, want: ioLimits{riops: 5000, wiops: 500}},
+		{name: "tier on tmpfs", claim: claim(nil), backing: backingTmpfs, tier: &qosTier{name: "gold", limits: ioLimits{riops: 5000}}, wantErr: true},
+		{name: "invalid iops", claim: claim(map[string]string{"kubevirt.io/writeIOPS": "fast"}), backing: backingDirectory, wantErr: true},
+		{name: "zero", claim: claim(map[string]string{"kubevirt.io/writeIOPS": "0"}), backing: backingDirectory, wantErr: true},
+		{name: "tmpfs", class: class, claim: claim(nil), backing: backingTmpfs, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ioLimitsFor(controller.ProvisionOptions{StorageClass: tt.class, PVC: tt.claim}, tt.backing, tt.tier)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("ioLimitsFor() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("ioLimitsFor() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_parseIOLimits(t *testing.T) {
+	limits := ioLimits{rbps: 104857600, riops: 1000, wiops: 500}
+	if got := limits.String(); got != "rbps=104857600 riops=1000 wiops=500" {
+		t.Errorf("String() = %q", got)
+	}
+	got, err := parseIOLimits(limits.String())
+	if err != nil || got != limits {
+		t.Errorf("parseIOLimits() = %+v, %v, want %+v", got, err, limits)
+	}
+	for _, invalid := range []string{"rbps", "xbps=1", "riops=-1"} {
+		if _, err := parseIOLimits(invalid); err == nil {
+			t.Errorf("parseIOLimits(%q) succeeded", invalid)
+		}
+	}
+	if got := ioMaxLine(blockDevice{major: 8, minor: 16}, limits); got != "8:16 rbps=104857600 wbps=max riops=1000 wiops=500" {
+		t.Errorf("ioMaxLine() = %q", got)
+	}
+	sum := limits.add(ioLimits{rbps: 1, wbps: 2, riops: 3})
+	if want := (ioLimits{rbps: 104857601, riops: 1003}); sum != want {
+		t.Errorf("add() = %+v, want %+v", sum, want)
+	}
+}
+
+func Test_podCgroup(t *testing.T) {
+	const uid = "0f3c4d6e-1a2b-4c5d-8e9f-0a1b2c3d4e5f"
+	tests := []struct {
+		name string
+		dir  string
+	}{
+		{name: "systemd burstable", dir: "kubepods.slice/kubepods-burstable.slice/kubepods-burstable-pod0f3c4d6e_1a2b_4c5d_8e9f_0a1b2c3d4e5f.slice"},
+		{name: "systemd guaranteed", dir: "kubepods.slice/kubepods-pod0f3c4d6e_1a2b_4c5d_8e9f_0a1b2c3d4e5f.slice"},
+		{name: "cgroupfs besteffort", dir: "kubepods/besteffort/pod0f3c4d6e-1a2b-4c5d-8e9f-0a1b2c3d4e5f"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			root, err := ioutil.TempDir("", "cgroup")
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer os.RemoveAll(root)
+			for _, dir := range []string{tt.dir, "system.slice/pod" + uid, "kubepods.slice/kubepods-besteffort.slice/kubepods-besteffort-podffffffff.slice"} {
+				if err := os.MkdirAll(filepath.Join(root, dir, "container"), 0755); err != nil {
+					t.Fatal(err)
+				}
+			}
+			for _, file := range []string{"cgroup.controllers", filepath.Join(filepath.Dir(tt.dir), "cgroup.procs")} {
+				if err := ioutil.WriteFile(filepath.Join(root, file), nil, 0644); err != nil {
+					t.Fatal(err)
+				}
+			}
+			got, err := podCgroup(root, uid)
+			if err != nil {
+				t.Fatalf("podCgroup() failed: %v", err)
+			}
+			if want := filepath.Join(root, tt.dir); got != want {
+				t.Errorf("podCgroup() = %s, want %s", got, want)
+			}
+			if _, err := podCgroup(root, "11111111-2222-3333-4444-555555555555"); err == nil {
+				t.Errorf("podCgroup() of an unknown pod succeeded")
+			}
+		})
+	}
+}
+
+func Test_wholeDisk(t *testing.T) {
+	dir, err := ioutil.TempDir("", "sysblock")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	// /sys/dev/block links to the device directories, partitions are below
+	// their disk
+	disk := filepath.Join(dir, "devices", "sdb")
+	if err := os.MkdirAll(filepath.Join(disk, "sdb1"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	ioutil.WriteFile(filepath.Join(disk, "dev"), []byte("8:16\n"), 0644)
+	ioutil.WriteFile(filepath.Join(disk, "sdb1", "partition"), []byte("1\n"), 0644)
+	os.MkdirAll(filepath.Join(dir, "block"), 0755)
+	os.Symlink(filepath.Join(disk, "sdb1"), filepath.Join(dir, "block", "8:17"))
+	os.Symlink(disk, filepath.Join(dir, "block", "8:16"))
+
+	l := &ioLimiter{sysDevBlock: filepath.Join(dir, "block")}
+	for _, device := range []blockDevice{{8, 17}, {8, 16}} {
+		if got, want := l.wholeDisk(device), (blockDevice{8, 16}); got != want {
+			t.Errorf("wholeDisk(%s) = %s, want %s", device, got, want)
+		}
+	}
+	if got, want := l.wholeDisk(blockDevice{253, 3}), (blockDevice{253, 3}); got != want {
+		t.Errorf("wholeDisk(%s) = %s, want %s", want, got, want)
+	}
+}
+
+func Test_podLimits(t *testing.T) {
+	pv := func(name, limits string) *v1.PersistentVolume {
+		pv := &v1.PersistentVolume{ObjectMeta: metav1.ObjectMeta{Name: name, Annotations: map[string]string{}}}
+		if limits != "" {
+			pv.Annotations[annIOLimits] = limits
+		}
+		return pv
+	}
+	devices := map[string]blockDevice{
+		"pv-image":   {7, 3},
+		"pv-dir":     {8, 16},
+		"pv-other":   {8, 16},
+		"pv-unbound": {8, 16},
+	}
+	l := &ioLimiter{deviceOf: func(pv *v1.PersistentVolume) (blockDevice, error) {
+		if device, ok := devices[pv.Name]; ok {
+			return device, nil
+		}
+		return blockDevice{}, fmt.Errorf("volume %s is not on a block device", pv.Name)
+	}}
+	volumes := map[string]*v1.PersistentVolume{
+		"vms/image":     pv("pv-image", "riops=1000"),
+		"vms/dir":       pv("pv-dir", "wbps=1048576"),
+		"vms/other":     pv("pv-other", "wbps=2097152 riops=10"),
+		"vms/unlimited": pv("pv-unbound", ""),
+		"vms/tmpfs":     pv("pv-tmpfs", "riops=5"),
+	}
+	pod := func(claims ...string) *v1.Pod {
+		pod := &v1.Pod{ObjectMeta: metav1.ObjectMeta{Name: "vm", Namespace: "vms"}}
+		for _, claim := range claims {
+			pod.Spec.Volumes = append(pod.Spec.Volumes, v1.Volume{Name: claim, VolumeSource: v1.VolumeSource{PersistentVolumeClaim: &v1.PersistentVolumeClaimVolumeSource{ClaimName: claim}}})
+		}
+		return pod
+	}
+	tests := []struct {
+		name string
+		pod  *v1.Pod
+		want map[blockDevice]ioLimits
+	}{
+		{name: "no volumes", pod: pod()},
+		{name: "unlimited", pod: pod("unlimited", "elsewhere")},
+		{name: "one device each", pod: pod("image", "dir"), want: map[blockDevice]ioLimits{{7, 3}: {riops: 1000}, {8, 16}: {wbps: 1048576}}},
+		{name: "shared device", pod: pod("dir", "other"), want: map[blockDevice]ioLimits{{8, 16}: {wbps: 3145728}}},
+		{name: "shared with unlimited", pod: pod("image", "dir", "unlimited"), want: map[blockDevice]ioLimits{{7, 3}: {riops: 1000}, {8, 16}: {}}},
+		{name: "no block device", pod: pod("tmpfs")},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := l.podLimits(tt.pod, volumes); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("podLimits() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
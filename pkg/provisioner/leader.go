@@ -0,0 +1,84 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package provisioner
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"k8s.io/apimachinery/pkg/util/uuid"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/tools/leaderelection"
+	"k8s.io/client-go/tools/leaderelection/resourcelock"
+	"sigs.k8s.io/controller-runtime/pkg/manager"
+)
+
+// leaderElectionOptions makes the replicas running the manager compete for
+// the lease of the given name, in namespace or the provisioner's namespace
+// when empty, with identity. A new leader takes over within lease after the
+// old one stopped renewing its lock, the leader gives up after failing to
+// renew it for renew.
+func leaderElectionOptions(options *manager.Options, client kubernetes.Interface, lockName, namespace, identity string, lease, renew, retry time.Duration) error {
+	if err := checkLeaderElectionTiming(lease, renew, retry); err != nil {
+		return err
+	}
+	if namespace == "" {
+		namespace = getPodNamespace()
+	}
+	lock, err := resourcelock.New(resourcelock.LeasesResourceLock, namespace, lockName, client.CoreV1(), client.CoordinationV1(),
+		resourcelock.ResourceLockConfig{Identity: identity})
+	if err != nil {
+		return err
+	}
+	options.LeaderElection = true
+	options.LeaderElectionResourceLockInterface = lock
+	options.LeaseDuration = &lease
+	options.RenewDeadline = &renew
+	options.RetryPeriod = &retry
+	// Hand the lock over right away when shutting down, instead of leaving
+	// the standby replicas waiting for the lease to expire
+	options.LeaderElectionReleaseOnCancel = true
+	return nil
+}
+
+// checkLeaderElectionTiming checks that the lease outlasts the renew
+// deadline, and that several retries fit in the deadline.
+func checkLeaderElectionTiming(lease, renew, retry time.Duration) error {
+	if retry <= 0 || float64(renew) <= leaderelection.JitterFactor*float64(retry) || lease <= renew {
+		return fmt.Errorf("invalid leader election timing, the lease duration %v must be longer than the renew deadline %v, and that longer than %v times the retry period %v", lease, renew, leaderelection.JitterFactor, retry)
+	}
+	return nil
+}
+
+// leaderIdentity returns the identity a replica holds the lock with, unique
+// among the processes on the host.
+func leaderIdentity() (string, error) {
+	hostname, err := os.Hostname()
+	if err != nil {
+		return "", err
+	}
+	return hostname + "_" + string(uuid.NewUUID()), nil
+}
+
+// leaderElectionLockName returns the name of the object the replicas on a
+// node compete for. Every node has its own lock, the
+// provisioners of different nodes do not exclude each other.
+func leaderElectionLockName(provisionerName, nodeName string) string {
+	return strings.Replace(provisionerName, "/", "-", -1) + "-" + nodeName
+}
@@ -0,0 +1,75 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package provisioner
+
+import (
+	"testing"
+	"time"
+
+	"k8s.io/client-go/kubernetes/fake"
+	"sigs.k8s.io/controller-runtime/pkg/manager"
+)
+
+func Test_leaderElectionLockName(t *testing.T) {
+	tests := []struct {
+		name            string
+		provisionerName string
+		nodeName        string
+		want            string
+	}{
+		{name: "default name", provisionerName: "kubevirt.io/hostpath-provisioner", nodeName: "node01", want: "kubevirt.io-hostpath-provisioner-node01"},
+		{name: "no slash", provisionerName: "hostpath", nodeName: "node02", want: "hostpath-node02"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := leaderElectionLockName(tt.provisionerName, tt.nodeName); got != tt.want {
+				t.Errorf("leaderElectionLockName() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_leaderElectionOptions(t *testing.T) {
+	tests := []struct {
+		name                string
+		namespace           string
+		lease, renew, retry time.Duration
+		wantErr             bool
+	}{
+		{name: "defaults", lease: 15 * time.Second, renew: 10 * time.Second, retry: 2 * time.Second},
+		{name: "fast takeover", lease: 4 * time.Second, renew: 3 * time.Second, retry: time.Second},
+		{name: "other namespace", namespace: "leases", lease: 15 * time.Second, renew: 10 * time.Second, retry: 2 * time.Second},
+		{name: "lease not longer than renew", lease: 10 * time.Second, renew: 10 * time.Second, retry: 2 * time.Second, wantErr: true},
+		{name: "renew too short for retry", lease: 15 * time.Second, renew: 2 * time.Second, retry: 2 * time.Second, wantErr: true},
+		{name: "no retry period", lease: 15 * time.Second, renew: 10 * time.Second, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			options := manager.Options{}
+			err := leaderElectionOptions(&options, fake.NewSimpleClientset(), "lock", tt.namespace, "node01_a", tt.lease, tt.renew, tt.retry)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("leaderElectionOptions() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err != nil {
+				return
+			}
+			if !options.LeaderElection || options.LeaderElectionResourceLockInterface.Identity() != "node01_a" || *options.LeaseDuration != tt.lease {
+				t.Errorf("leaderElectionOptions() set %+v", options)
+			}
+		})
+	}
+}
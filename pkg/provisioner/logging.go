@@ -0,0 +1,152 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package provisioner
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/go-logr/logr"
+	"k8s.io/klog/v2"
+)
+
+const (
+	logFormatText = "text"
+	logFormatJSON = "json"
+)
+
+// All messages of the provisioner, and of the controller and client-go it
+// uses, are logged with klog v2. Structured messages follow its conventions:
+// a constant message and key/value pairs that log pipelines can index. The
+// keys used throughout the provisioner are pvc (namespace/name), pv, node,
+// pool, path and duration.
+
+// jsonOutput is where messages are written in the JSON format.
+var jsonOutput io.Writer = os.Stderr
+
+// setLogFormat selects the format of every message logged with klog: its
+// own text format, or single line JSON objects written to jsonOutput.
+func setLogFormat(format string) {
+	if format == logFormatJSON {
+		klog.SetLogger(logr.New(&jsonSink{mutex: &sync.Mutex{}, out: jsonOutput}))
+		return
+	}
+	klog.ClearLogger()
+}
+
+// jsonSink is the logr.LogSink klog hands its messages to in the JSON
+// format, formatted ones included. klog has already checked the verbosity
+// of a message when it reaches the sink.
+type jsonSink struct {
+	mutex  *sync.Mutex
+	out    io.Writer
+	name   string
+	values []interface{}
+}
+
+func (s *jsonSink) Init(logr.RuntimeInfo) {}
+
+func (s *jsonSink) Enabled(level int) bool {
+	return true
+}
+
+func (s *jsonSink) Info(level int, msg string, keysAndValues ...interface{}) {
+	s.write("info", nil, msg, keysAndValues)
+}
+
+func (s *jsonSink) Error(err error, msg string, keysAndValues ...interface{}) {
+	s.write("error", err, msg, keysAndValues)
+}
+
+func (s *jsonSink) WithValues(keysAndValues ...interface{}) logr.LogSink {
+	sink := *s
+	sink.values = append(append([]interface{}{}, s.values...), pairs(keysAndValues)...)
+	return &sink
+}
+
+func (s *jsonSink) WithName(name string) logr.LogSink {
+	sink := *s
+	if s.name != "" {
+		name = s.name + "/" + name
+	}
+	sink.name = name
+	return &sink
+}
+
+func (s *jsonSink) write(level string, err error, msg string, keysAndValues []interface{}) {
+	values := append([]interface{}{}, s.values...)
+	if s.name != "" {
+		values = append(values, "logger", s.name)
+	}
+	line := formatJSON(time.Now(), level, err, msg, append(values, keysAndValues...))
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.out.Write(line)
+}
+
+// pairs returns the keys and values, with a placeholder value appended if a
+// key has none.
+func pairs(keysAndValues []interface{}) []interface{} {
+	if len(keysAndValues)%2 != 0 {
+		return append(keysAndValues, "(MISSING)")
+	}
+	return keysAndValues
+}
+
+// formatJSON renders a message as a single line JSON object.
+func formatJSON(now time.Time, level string, err error, msg string, keysAndValues []interface{}) []byte {
+	buf := &bytes.Buffer{}
+	field := func(key string, value interface{}) {
+		switch v := value.(type) {
+		case error:
+			value = v.Error()
+		case time.Duration:
+			value = v.String()
+		case fmt.Stringer:
+			value = v.String()
+		}
+		data, marshalErr := json.Marshal(value)
+		if marshalErr != nil {
+			data, _ = json.Marshal(fmt.Sprintf("%+v", value))
+		}
+		keyData, _ := json.Marshal(key)
+		if buf.Len() > 1 {
+			buf.WriteByte(',')
+		}
+		buf.Write(keyData)
+		buf.WriteByte(':')
+		buf.Write(data)
+	}
+	buf.WriteByte('{')
+	field("ts", now.UTC().Format(time.RFC3339Nano))
+	field("level", level)
+	field("msg", msg)
+	if err != nil {
+		field("err", err)
+	}
+	kv := pairs(keysAndValues)
+	for i := 0; i < len(kv); i += 2 {
+		field(fmt.Sprint(kv[i]), kv[i+1])
+	}
+	buf.WriteString("}\n")
+	return buf.Bytes()
+}
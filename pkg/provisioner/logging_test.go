@@ -0,0 +1,92 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package provisioner
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"os"
+	"testing"
+	"time"
+
+	"k8s.io/klog/v2"
+)
+
+func Test_formatJSON(t *testing.T) {
+	now := time.Date(2019, 10, 1, 12, 0, 0, 0, time.UTC)
+	line := formatJSON(now, "error", errors.New("no space"), "Failed to create backing directory",
+		[]interface{}{"pvc", "default/data", "duration", 2 * time.Second, "count", 3})
+
+	var got map[string]interface{}
+	if err := json.Unmarshal(line, &got); err != nil {
+		t.Fatalf("formatJSON() produced invalid JSON %s: %v", line, err)
+	}
+	want := map[string]interface{}{
+		"ts":       "2019-10-01T12:00:00Z",
+		"level":    "error",
+		"msg":      "Failed to create backing directory",
+		"err":      "no space",
+		"pvc":      "default/data",
+		"duration": "2s",
+		"count":    float64(3),
+	}
+	for key, value := range want {
+		if got[key] != value {
+			t.Errorf("formatJSON() %s = %v, want %v", key, got[key], value)
+		}
+	}
+	if line[len(line)-1] != '\n' {
+		t.Error("formatJSON() line does not end with a newline")
+	}
+}
+
+func Test_setLogFormat(t *testing.T) {
+	out := &bytes.Buffer{}
+	jsonOutput = out
+	setLogFormat(logFormatJSON)
+	defer func() {
+		jsonOutput = os.Stderr
+		setLogFormat(logFormatText)
+	}()
+
+	klog.Warningf("Unable to read %s", "/proc/mounts")
+	klog.ErrorS(errors.New("no space"), "Failed to create backing directory", "pv", "pvc-1")
+	klog.V(10).InfoS("Not logged")
+	klog.Background().WithName("controller").WithValues("node", "node01").Info("Started")
+
+	decoder := json.NewDecoder(out)
+	want := []map[string]interface{}{
+		{"level": "info", "msg": "Unable to read /proc/mounts"},
+		{"level": "error", "msg": "Failed to create backing directory", "err": "no space", "pv": "pvc-1"},
+		{"level": "info", "msg": "Started", "logger": "controller", "node": "node01"},
+	}
+	for _, fields := range want {
+		var got map[string]interface{}
+		if err := decoder.Decode(&got); err != nil {
+			t.Fatalf("invalid log line: %v", err)
+		}
+		for key, value := range fields {
+			if got[key] != value {
+				t.Errorf("log line %v has %s = %v, want %v", got, key, got[key], value)
+			}
+		}
+	}
+	if decoder.More() {
+		t.Error("unexpected log lines after the expected ones")
+	}
+}
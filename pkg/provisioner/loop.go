@@ -0,0 +1,410 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package provisioner
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"k8s.io/klog/v2"
+
+	v1 "k8s.io/api/core/v1"
+	"sigs.k8s.io/sig-storage-lib-external-provisioner/v13/controller"
+)
+
+const (
+	// annImageFile records the image file backing an image backed volume.
+	annImageFile = "kubevirt.io/imageFile"
+
+	// backingParameter is the StorageClass parameter choosing what backs the
+	// volumes of the class.
+	backingParameter = "volumeBacking"
+	// backingDirectory volumes are plain directories in the pool.
+	backingDirectory = "directory"
+	// backingImage volumes are sparse image files in the pool, attached to a
+	// loop device and mounted at the backing directory, so that their size
+	// is enforced.
+	backingImage = "image"
+
+	// imageSuffix is appended to the backing directory to name the image
+	// file next to it.
+	imageSuffix = ".img"
+)
+
+// loopDevice is an attached loop device.
+type loopDevice struct {
+	name string
+	file string
+	// deleted is set when the backing file was removed while attached
+	deleted bool
+}
+
+// loopDevices attaches and detaches the loop devices of image backed volumes
+// and keeps the counts found by the last leak check.
+type loopDevices struct {
+	// mutex serializes looking up the device of an image and attaching one,
+	// so that an image is never attached twice
+	mutex sync.Mutex
+
+	countsMutex sync.Mutex
+	attached    int
+	leaked      int
+}
+
+// backingFor returns what the StorageClass asks to back its volumes with.
+func backingFor(options controller.ProvisionOptions) (string, error) {
+	if options.StorageClass == nil {
+		return backingDirectory, nil
+	}
+	backing := options.StorageClass.Parameters[backingParameter]
+	switch backing {
+	case "":
+		return backingDirectory, nil
+	case backingDirectory, backingImage, backingQcow2, backingTmpfs:
+		return backing, nil
+	}
+	return "", fmt.Errorf("invalid %s %q in storage class %s, expected %s, %s, %s or %s", backingParameter, backing, options.StorageClass.Name, backingDirectory, backingImage, backingQcow2, backingTmpfs)
+}
+
+// imageCandidates returns the candidates image files, raw or qcow2, and tmpfs
+// mounts can be placed in, the pools of plain directories.
+func imageCandidates(candidates []poolCandidate) []poolCandidate {
+	var result []poolCandidate
+	for _, candidate := range candidates {
+		if candidate.pool.lvm == nil {
+			result = append(result, candidate)
+		}
+	}
+	return result
+}
+
+// listLoopDevices returns the attached loop devices.
+func listLoopDevices() ([]loopDevice, error) {
+	output, err := runCommand("losetup", "--list", "--noheadings", "--raw", "--output", "NAME,BACK-FILE")
+	if err != nil {
+		return nil, err
+	}
+	return parseLoopDevices(string(output)), nil
+}
+
+// parseLoopDevices parses the raw NAME,BACK-FILE listing of losetup.
+func parseLoopDevices(output string) []loopDevice {
+	var devices []loopDevice
+	for _, line := range strings.Split(output, "\n") {
+		fields := strings.SplitN(strings.TrimSpace(line), " ", 2)
+		if len(fields) != 2 {
+			continue
+		}
+		device := loopDevice{name: fields[0], file: strings.Replace(fields[1], `\x20`, " ", -1)}
+		if strings.HasSuffix(device.file, " (deleted)") {
+			device.file = strings.TrimSuffix(device.file, " (deleted)")
+			device.deleted = true
+		}
+		devices = append(devices, device)
+	}
+	return devices
+}
+
+// attach returns the loop device of the image file, attaching it to a free
+// one when it is not attached yet.
+func (l *loopDevices) attach(image string) (string, error) {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	devices, err := listLoopDevices()
+	if err != nil {
+		return "", err
+	}
+	for _, device := range devices {
+		if device.file == image && !device.deleted {
+			return device.name, nil
+		}
+	}
+	output, err := runCommand("losetup", "--find", "--show", image)
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(string(output)), nil
+}
+
+// detach detaches every loop device the image file is attached to.
+func (l *loopDevices) detach(image string) error {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	devices, err := listLoopDevices()
+	if err != nil {
+		return err
+	}
+	for _, device := range devices {
+		if device.file != image {
+			continue
+		}
+		if _, err := runCommand("losetup", "--detach", device.name); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// createImageVolume creates a sparse image file of size bytes next to the
+// backing directory path, preallocated as given, attaches it to a loop device,
+// encrypts it with luks unless that is nil, formats it with the filesystem and
+// mounts it at path. The image file is returned. An image left behind by an
+// earlier attempt is reused.
+func (l *loopDevices) createImageVolume(path string, size int64, fs imageFilesystem, preallocation string, luks *luksVolume) (string, error) {
+	if preallocation == preallocationFull {
+		fs.mkfsOptions = append(nodiscardOptions(fs.fsType), fs.mkfsOptions...)
+	}
+	if err := mkfsProbes.check(fs); err != nil {
+		return "", err
+	}
+	image := path + imageSuffix
+	if err := createImage(image, size); err != nil {
+		return "", err
+	}
+	var device string
+	var err error
+	if preallocation == preallocationFull {
+		err = preallocateImage(image, size)
+	}
+	if err == nil {
+		device, err = l.attach(image)
+	}
+	mapped := ""
+	if err == nil && luks != nil {
+		mapped = luks.name
+		device, err = luksOpen(device, luks, true)
+	}
+	if err == nil {
+		err = mountDevice(device, fs, path, true)
+	}
+	if err != nil {
+		if removeErr := l.removeImageVolume(image, path, mapped); removeErr != nil {
+			klog.Warningf("unable to remove image %s after failing to mount it: %v", image, removeErr)
+		}
+		return "", err
+	}
+	return image, nil
+}
+
+// createImage creates the sparse image file, unless it exists.
+func createImage(image string, size int64) error {
+	file, err := os.OpenFile(image, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0600)
+	if os.IsExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if err := file.Truncate(size); err != nil {
+		file.Close()
+		os.Remove(image)
+		return err
+	}
+	return file.Close()
+}
+
+// removeImageVolume unmounts the image file from path, closes its LUKS
+// container, if it is encrypted, detaches its loop device and removes it.
+func (l *loopDevices) removeImageVolume(image, path, luks string) error {
+	if isMountPoint(path) {
+		if _, err := runCommand("umount", path); err != nil {
+			return err
+		}
+	}
+	if luks != "" {
+		if err := luksClose(luks); err != nil {
+			return err
+		}
+	}
+	if err := l.detach(image); err != nil {
+		return err
+	}
+	if err := os.Remove(image); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
+
+// attachImageVolumes attaches and mounts the image files of this node's
+// volumes that are not mounted, loop devices do not survive a reboot.
+func (p *HostPathProvisioner) attachImageVolumes() {
+	pvs, err := p.pvCache.list(context.TODO(), p.client)
+	if err != nil {
+		klog.Warningf("unable to list persistent volumes, not attaching image files: %v", err)
+		return
+	}
+	for _, pv := range pvs.Items {
+		if err := p.attachImageVolume(pv); err != nil {
+			klog.ErrorS(err, "Failed to attach image file", "node", p.nodeName, "pv", pv.Name, "image", pv.Annotations[annImageFile])
+		}
+	}
+}
+
+// attachImageVolume attaches and mounts the image file of pv, if it is one of
+// ours.
+func (p *HostPathProvisioner) attachImageVolume(pv v1.PersistentVolume) error {
+	image := pv.Annotations[annImageFile]
+	if image == "" || backingPath(&pv) == "" || !p.ownsIdentity(pv.Annotations["hostPathProvisionerIdentity"]) || pv.Annotations["kubevirt.io/provisionOnNode"] != p.nodeName {
+		return nil
+	}
+	path := backingPath(&pv)
+	if isMountPoint(path) && !isLocked(path) {
+		return nil
+	}
+	device, err := p.loopDevices.attach(image)
+	if err != nil {
+		return err
+	}
+	if secret := pv.Annotations[annEncryptionSecret]; secret != "" {
+		key, err := p.encryptionKey(secret)
+		if err != nil {
+			p.locked.set(pv.Name, true)
+			p.volumeEvent(&pv, v1.EventTypeWarning, eventReasonEncryptionKeyUnavailable, "Backing directory %s on node %s is locked: %v", path, p.nodeName, err)
+			if err := lockVolume(path); err != nil {
+				klog.Warningf("unable to lock backing directory %s: %v", path, err)
+			}
+			return err
+		}
+		if device, err = luksOpen(device, &luksVolume{name: luksName(pv.Name), key: key}, false); err != nil {
+			return err
+		}
+		if err := unlockVolume(path); err != nil {
+			return err
+		}
+		p.locked.set(pv.Name, false)
+	}
+	fs := imageFilesystemOf(&pv)
+	if rootHash := pv.Annotations[annVerityRootHash]; rootHash != "" {
+		if device, err = verityOpen(device, verityName(pv.Name), image+veritySuffix, rootHash); err != nil {
+			p.volumeEvent(&pv, v1.EventTypeWarning, eventReasonVerityFailed, "Image %s of sealed volume %s on node %s does not match its root hash: %v", image, pv.Name, p.nodeName, err)
+			if err := lockVolume(path); err != nil {
+				klog.Warningf("unable to lock backing directory %s: %v", path, err)
+			}
+			return err
+		}
+		if err := unlockVolume(path); err != nil {
+			return err
+		}
+		fs.mountOptions = append(fs.mountOptions, "ro")
+	}
+	klog.InfoS("Mounting image file", "node", p.nodeName, "pv", pv.Name, "image", image, "device", device, "path", path)
+	return mountDevice(device, fs, path, false)
+}
+
+// imageFilesystemOf returns the filesystem the image of pv is formatted with
+// and the options it is mounted with.
+func imageFilesystemOf(pv *v1.PersistentVolume) imageFilesystem {
+	fs := imageFilesystem{fsType: pv.Annotations[annImageFilesystem]}
+	if fs.fsType == "" {
+		fs.fsType = defaultImageFilesystem
+	}
+	if compression := pv.Annotations[annCompression]; compression != "" {
+		fs.mountOptions = compressionMountOptions(compression)
+	}
+	return fs
+}
+
+// checkLoopDevices counts the loop devices attached to image files in the
+// pools and reports the leaked ones: those whose image was removed or does
+// not back a volume of this node.
+func (p *HostPathProvisioner) checkLoopDevices() {
+	devices, err := listLoopDevices()
+	if err != nil {
+		klog.Warningf("unable to list loop devices: %v", err)
+		return
+	}
+	var ours []loopDevice
+	for _, device := range devices {
+		if isPoolImage(p.currentPools(), device.file) {
+			ours = append(ours, device)
+		}
+	}
+	leaked := 0
+	if len(ours) > 0 {
+		pvs, err := p.pvCache.list(context.TODO(), p.client)
+		if err != nil {
+			klog.Warningf("unable to list persistent volumes, not checking loop devices: %v", err)
+			return
+		}
+		images := make(map[string]bool)
+		for _, pv := range pvs.Items {
+			if p.ownsIdentity(pv.Annotations["hostPathProvisionerIdentity"]) && pv.Annotations["kubevirt.io/provisionOnNode"] == p.nodeName {
+				images[pv.Annotations[annImageFile]] = true
+				for _, image := range stripeImagesOf(&pv) {
+					images[image] = true
+				}
+			}
+		}
+		for _, device := range ours {
+			if device.deleted || !images[device.file] {
+				leaked++
+				klog.Warningf("loop device %s of image %s is leaked, it does not back a volume on node %s (deleted: %t)", device.name, device.file, p.nodeName, device.deleted)
+			}
+		}
+	}
+	p.loopDevices.countsMutex.Lock()
+	defer p.loopDevices.countsMutex.Unlock()
+	p.loopDevices.attached, p.loopDevices.leaked = len(ours), leaked
+}
+
+// isPoolImage returns whether file is an image file in one of the pools.
+func isPoolImage(pools []*storagePool, file string) bool {
+	if !strings.HasSuffix(file, imageSuffix) {
+		return false
+	}
+	for _, pool := range pools {
+		if strings.HasPrefix(file, strings.TrimSuffix(pool.path, "/")+"/") {
+			return true
+		}
+	}
+	return false
+}
+
+// loopCollector exports the counts of the last loop device check.
+type loopCollector struct {
+	devices  *loopDevices
+	attached *prometheus.Desc
+	leaked   *prometheus.Desc
+}
+
+var _ prometheus.Collector = &loopCollector{}
+
+func newLoopCollector(devices *loopDevices) *loopCollector {
+	return &loopCollector{
+		devices:  devices,
+		attached: prometheus.NewDesc(prometheus.BuildFQName(metricsNamespace, "loop_devices", "attached"), "Loop devices attached to image files in the pools.", nil, nil),
+		leaked:   prometheus.NewDesc(prometheus.BuildFQName(metricsNamespace, "loop_devices", "leaked"), "Loop devices attached to image files that do not back a volume.", nil, nil),
+	}
+}
+
+// Describe implements prometheus.Collector.
+func (c *loopCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- c.attached
+	ch <- c.leaked
+}
+
+// Collect implements prometheus.Collector.
+func (c *loopCollector) Collect(ch chan<- prometheus.Metric) {
+	c.devices.countsMutex.Lock()
+	defer c.devices.countsMutex.Unlock()
+	ch <- prometheus.MustNewConstMetric(c.attached, prometheus.GaugeValue, float64(c.devices.attached))
+	ch <- prometheus.MustNewConstMetric(c.leaked, prometheus.GaugeValue, float64(c.devices.leaked))
+}
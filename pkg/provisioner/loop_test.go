@@ -0,0 +1,197 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package provisioner
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	storagev1 "k8s.io/api/storage/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/sig-storage-lib-external-provisioner/v13/controller"
+)
+
+func Test_backingFor(t *testing.T) {
+	class := func(backing string) *storagev1.StorageClass {
+		return &storagev1.StorageClass{ObjectMeta: metav1.ObjectMeta{Name: "hostpath"}, Parameters: map[string]string{backingParameter: backing}}
+	}
+	tests := []struct {
+		name    string
+		class   *storagev1.StorageClass
+		want    string
+		wantErr bool
+	}{
+		{name: "no class", want: backingDirectory},
+		{name: "not set", class: class(""), want: backingDirectory},
+		{name: "directory", class: class("directory"), want: backingDirectory},
+		{name: "image", class: class("image"), want: backingImage},
+		{name: "qcow2", class: class("qcow2"), want: backingQcow2},
+		{name: "tmpfs", class: class("tmpfs"), want: backingTmpfs},
+		{name: "invalid", class: class("block"), wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := backingFor(controller.ProvisionOptions{StorageClass: tt.class})
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("backingFor() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("backingFor() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_parseLoopDevices(t *testing.T) {
+	output := "/dev/loop0 /var/hpvolumes/pvc-1.img\n" +
+		"/dev/loop1 /var/hpvolumes/pvc-2.img (deleted)\n" +
+		"/dev/loop2 /var/lib/my\\x20images/disk.img\n"
+	want := []loopDevice{
+		{name: "/dev/loop0", file: "/var/hpvolumes/pvc-1.img"},
+		{name: "/dev/loop1", file: "/var/hpvolumes/pvc-2.img", deleted: true},
+		{name: "/dev/loop2", file: "/var/lib/my images/disk.img"},
+	}
+	if got := parseLoopDevices(output); !reflect.DeepEqual(got, want) {
+		t.Errorf("parseLoopDevices() = %+v, want %+v", got, want)
+	}
+}
+
+func Test_isPoolImage(t *testing.T) {
+	pools := []*storagePool{{name: "default", path: "/var/hpvolumes"}, {name: "fast", path: "/mnt/fast/"}}
+	tests := []struct {
+		file string
+		want bool
+	}{
+		{file: "/var/hpvolumes/pvc-1.img", want: true},
+		{file: "/mnt/fast/ns/pvc-1.img", want: true},
+		{file: "/var/hpvolumes/pvc-1", want: false},
+		{file: "/var/hpvolumes-old/pvc-1.img", want: false},
+		{file: "/var/lib/images/disk.img", want: false},
+	}
+	for _, tt := range tests {
+		if got := isPoolImage(pools, tt.file); got != tt.want {
+			t.Errorf("isPoolImage(%s) = %t, want %t", tt.file, got, tt.want)
+		}
+	}
+}
+
+func Test_loopDevicesAttach(t *testing.T) {
+	list := "losetup --list --noheadings --raw --output NAME,BACK-FILE"
+	commands, restore := fakeCommands(map[string]string{
+		list: "/dev/loop0 /var/hpvolumes/pvc-1.img\n/dev/loop1 /var/hpvolumes/pvc-2.img (deleted)\n",
+		"losetup --find --show /var/hpvolumes/pvc-2.img": "/dev/loop2\n",
+	})
+	defer restore()
+	l := &loopDevices{}
+	if device, err := l.attach("/var/hpvolumes/pvc-1.img"); err != nil || device != "/dev/loop0" {
+		t.Errorf("attach() = %s, %v, want the attached device", device, err)
+	}
+	// The device of a removed image is not reused
+	if device, err := l.attach("/var/hpvolumes/pvc-2.img"); err != nil || device != "/dev/loop2" {
+		t.Errorf("attach() = %s, %v, want a new device", device, err)
+	}
+	want := []string{list, list, "losetup --find --show /var/hpvolumes/pvc-2.img"}
+	if !reflect.DeepEqual(*commands, want) {
+		t.Errorf("commands = %q, want %q", *commands, want)
+	}
+}
+
+func Test_createImageVolume(t *testing.T) {
+	dir, err := ioutil.TempDir("", "loop")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "pvc-1")
+	image := path + imageSuffix
+
+	// The node's mkfs supports the filesystem
+	fs := imageFilesystem{fsType: "xfs", mkfsOptions: []string{"-m", "reflink=1"}}
+	originalProbes := mkfsProbes
+	mkfsProbes = &mkfsProbe{results: map[string]error{fs.String(): nil}}
+	defer func() { mkfsProbes = originalProbes }()
+
+	list := "losetup --list --noheadings --raw --output NAME,BACK-FILE"
+	commands, restore := fakeCommands(map[string]string{
+		list:                                  "",
+		"losetup --find --show " + image:      "/dev/loop3\n",
+		"mkfs -t xfs -m reflink=1 /dev/loop3": "",
+		"mount -t xfs -o nouuid /dev/loop3 " + path: "",
+	})
+	defer restore()
+	l := &loopDevices{}
+	got, err := l.createImageVolume(path, 1073741824, fs, preallocationOff, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got != image {
+		t.Errorf("createImageVolume() = %s, want %s", got, image)
+	}
+	info, err := os.Stat(image)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Size() != 1073741824 {
+		t.Errorf("image size = %d, want 1073741824", info.Size())
+	}
+	want := []string{
+		list,
+		"losetup --find --show " + image,
+		"blkid -o value -s TYPE /dev/loop3",
+		"mkfs -t xfs -m reflink=1 /dev/loop3",
+		"mount -t xfs -o nouuid /dev/loop3 " + path,
+	}
+	if !reflect.DeepEqual(*commands, want) {
+		t.Errorf("commands = %q, want %q", *commands, want)
+	}
+
+	// An image that can not be formatted is detached and removed again
+	path = filepath.Join(dir, "pvc-2")
+	image = path + imageSuffix
+	commands, restore = fakeCommands(map[string]string{
+		list:                             "",
+		"losetup --find --show " + image: "/dev/loop4\n",
+	})
+	defer restore()
+	if _, err := l.createImageVolume(path, 1073741824, fs, preallocationOff, nil); err == nil {
+		t.Fatal("createImageVolume() succeeded with failing commands")
+	}
+	if _, err := os.Stat(image); !os.IsNotExist(err) {
+		t.Errorf("image not removed: %v", err)
+	}
+
+	// mkfs does not discard the blocks of preallocated images
+	path = filepath.Join(dir, "pvc-3")
+	image = path + imageSuffix
+	mkfsProbes.results["xfs -K -m reflink=1"] = nil
+	commands, restore = fakeCommands(map[string]string{
+		list:                                        "",
+		"losetup --find --show " + image:            "/dev/loop5\n",
+		"mkfs -t xfs -K -m reflink=1 /dev/loop5":    "",
+		"mount -t xfs -o nouuid /dev/loop5 " + path: "",
+	})
+	defer restore()
+	if _, err := l.createImageVolume(path, 4*MiB, fs, preallocationFull, nil); err != nil {
+		t.Fatal(err)
+	}
+	if allocated := allocatedBytes(t, image); allocated < 4*MiB {
+		t.Errorf("allocated %d bytes, want %d", allocated, 4*MiB)
+	}
+}
@@ -0,0 +1,288 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package provisioner
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"k8s.io/klog/v2"
+
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	"sigs.k8s.io/sig-storage-lib-external-provisioner/v13/controller"
+)
+
+const (
+	// encryptionSecretParameter names the Secret, namespace/name, holding the
+	// key volumes of the class are encrypted with. ${pvc.namespace} and
+	// ${pvc.name} are replaced by those of the claim, for a key per claim.
+	encryptionSecretParameter = "encryptionSecret"
+	// encryptionSecretKey is the entry of the Secret holding the key
+	encryptionSecretKey = "key"
+
+	// annEncryptionSecret records the Secret holding the key of an encrypted
+	// volume.
+	annEncryptionSecret = "kubevirt.io/encryptionSecret"
+
+	// luksHeaderSize is the space the LUKS2 header takes from a device
+	luksHeaderSize = 16 * MiB
+
+	eventReasonEncryptionKeyUnavailable = "EncryptionKeyUnavailable"
+
+	// lockedSource is the source of the read-only tmpfs mounted at the
+	// backing directories of encrypted volumes whose key is unavailable, so
+	// that pods do not write to the bare directory instead
+	lockedSource = "hpp-locked"
+)
+
+// mapperDir holds the devices of opened LUKS containers.
+var mapperDir = "/dev/mapper"
+
+// runCommandInput runs a command with input on its stdin, keys are never
+// passed on the command line or written to disk.
+var runCommandInput = func(input []byte, name string, args ...string) ([]byte, error) {
+	cmd := exec.Command(name, args...)
+	cmd.Stdin = bytes.NewReader(input)
+	output, err := cmd.CombinedOutput()
+	if err != nil {
+		return output, fmt.Errorf("%s %s: %v: %s", name, strings.Join(args, " "), err, bytes.TrimSpace(output))
+	}
+	return output, nil
+}
+
+// luksVolume is the LUKS container of an encrypted volume.
+type luksVolume struct {
+	// name is the name of the opened container in mapperDir
+	name string
+	key  []byte
+}
+
+// luksName returns the name of the opened container of the volume.
+func luksName(pvName string) string {
+	return "hpp-" + pvName
+}
+
+// encryptionSecretFor returns the Secret, namespace/name, holding the key the
+// claim's volume is encrypted with, empty if it is not encrypted. Image backed
+// volumes and the devices handed out to block claims are encrypted with LUKS,
+// backing directories with fscrypt.
+func encryptionSecretFor(options controller.ProvisionOptions, backing string) (string, error) {
+	if options.StorageClass == nil {
+		return "", nil
+	}
+	secret, ok := options.StorageClass.Parameters[encryptionSecretParameter]
+	if !ok {
+		return "", nil
+	}
+	if backing == backingTmpfs {
+		return "", fmt.Errorf("storage class %s sets %s, tmpfs backed volumes can not be encrypted", options.StorageClass.Name, encryptionSecretParameter)
+	}
+	secret = strings.NewReplacer("${pvc.namespace}", options.PVC.Namespace, "${pvc.name}", options.PVC.Name).Replace(secret)
+	parts := strings.Split(secret, "/")
+	if len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+		return "", fmt.Errorf("invalid %s %q in storage class %s, expected namespace/name", encryptionSecretParameter, secret, options.StorageClass.Name)
+	}
+	return secret, nil
+}
+
+// encryptionKey reads the key from the Secret, namespace/name.
+func (p *HostPathProvisioner) encryptionKey(secret string) ([]byte, error) {
+	parts := strings.SplitN(secret, "/", 2)
+	s, err := p.client.CoreV1().Secrets(parts[0]).Get(context.TODO(), parts[1], metav1.GetOptions{})
+	if err != nil {
+		return nil, fmt.Errorf("unable to read encryption key from secret %s: %v", secret, err)
+	}
+	key := s.Data[encryptionSecretKey]
+	if len(key) == 0 {
+		return nil, fmt.Errorf("secret %s has no %q entry holding the encryption key", secret, encryptionSecretKey)
+	}
+	return key, nil
+}
+
+// luksOpen opens the LUKS container on device and returns its device. With
+// format, a device that is no LUKS container yet is formatted as one first.
+// An opened container is reused.
+func luksOpen(device string, luks *luksVolume, format bool) (string, error) {
+	mapped := filepath.Join(mapperDir, luks.name)
+	if _, err := os.Stat(mapped); err == nil {
+		return mapped, nil
+	}
+	if format {
+		if _, err := runCommand("cryptsetup", "isLuks", device); err != nil {
+			if _, err := runCommandInput(luks.key, "cryptsetup", "luksFormat", "--batch-mode", "--type", "luks2", "--key-file=-", device); err != nil {
+				return "", err
+			}
+		}
+	}
+	if _, err := runCommandInput(luks.key, "cryptsetup", "open", "--type", "luks", "--key-file=-", device, luks.name); err != nil {
+		return "", err
+	}
+	return mapped, nil
+}
+
+// luksClose closes the opened LUKS container name, if it is open.
+func luksClose(name string) error {
+	if _, err := os.Stat(filepath.Join(mapperDir, name)); os.IsNotExist(err) {
+		return nil
+	}
+	_, err := runCommand("cryptsetup", "close", name)
+	return err
+}
+
+// isLocked returns whether the backing directory is locked, its volume's key
+// was unavailable or its sealed image failed verification.
+func isLocked(path string) bool {
+	mounts, err := readMounts(procMountsPath)
+	if err != nil {
+		return false
+	}
+	mount := findMount(mounts, path)
+	return mount != nil && mount.mountPoint == path && mount.device == lockedSource
+}
+
+// lockVolume mounts an empty read-only tmpfs at the backing directory of an
+// encrypted or sealed volume that can not be opened, pods using it fail to
+// write rather than writing unencrypted or unverified data next to the
+// volume.
+func lockVolume(path string) error {
+	if isMountPoint(path) {
+		return nil
+	}
+	if err := os.MkdirAll(path, 0755); err != nil {
+		return err
+	}
+	_, err := runCommand("mount", "-t", "tmpfs", "-o", "ro,size=4k,mode=000", lockedSource, path)
+	return err
+}
+
+// unlockVolume unmounts the read-only tmpfs locking the backing directory.
+func unlockVolume(path string) error {
+	if !isLocked(path) {
+		return nil
+	}
+	_, err := runCommand("umount", path)
+	return err
+}
+
+// lockedVolumes are the encrypted volumes of the node whose key was
+// unavailable, opening them is retried until it succeeds.
+type lockedVolumes struct {
+	mutex   sync.Mutex
+	volumes map[string]bool
+}
+
+func (l *lockedVolumes) set(name string, isLocked bool) {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	if isLocked {
+		l.volumes[name] = true
+	} else {
+		delete(l.volumes, name)
+	}
+}
+
+func (l *lockedVolumes) has(name string) bool {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	return l.volumes[name]
+}
+
+func (l *lockedVolumes) count() int {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	return len(l.volumes)
+}
+
+// openEncryptedDevice opens the LUKS container of an encrypted device handed
+// out to a block claim, e.g. after a reboot. Without its key the container
+// stays closed and pods can not use the volume.
+func (p *HostPathProvisioner) openEncryptedDevice(pv v1.PersistentVolume) error {
+	device, secret := pv.Annotations[annDevice], pv.Annotations[annEncryptionSecret]
+	if device == "" || secret == "" || !p.ownsIdentity(pv.Annotations["hostPathProvisionerIdentity"]) || pv.Annotations["kubevirt.io/provisionOnNode"] != p.nodeName {
+		return nil
+	}
+	luks := &luksVolume{name: luksName(pv.Name)}
+	if _, err := os.Stat(filepath.Join(mapperDir, luks.name)); err == nil {
+		return nil
+	}
+	key, err := p.encryptionKey(secret)
+	if err != nil {
+		p.locked.set(pv.Name, true)
+		p.volumeEvent(&pv, v1.EventTypeWarning, eventReasonEncryptionKeyUnavailable, "Device %s on node %s stays closed: %v", device, p.nodeName, err)
+		return err
+	}
+	luks.key = key
+	if _, err := luksOpen(device, luks, false); err != nil {
+		return err
+	}
+	p.locked.set(pv.Name, false)
+	return nil
+}
+
+// openEncryptedDevices opens the LUKS containers of the encrypted devices of
+// this node, they are closed after a reboot.
+func (p *HostPathProvisioner) openEncryptedDevices() {
+	pvs, err := p.pvCache.list(context.TODO(), p.client)
+	if err != nil {
+		klog.Warningf("unable to list persistent volumes, not opening encrypted devices: %v", err)
+		return
+	}
+	for _, pv := range pvs.Items {
+		if err := p.openEncryptedDevice(pv); err != nil {
+			klog.ErrorS(err, "Failed to open encrypted device", "node", p.nodeName, "pv", pv.Name, "device", pv.Annotations[annDevice])
+		}
+	}
+}
+
+// openLockedVolumes retries opening the encrypted volumes whose key was
+// unavailable.
+func (p *HostPathProvisioner) openLockedVolumes() {
+	if p.locked.count() == 0 {
+		return
+	}
+	pvs, err := p.pvCache.list(context.TODO(), p.client)
+	if err != nil {
+		klog.Warningf("unable to list persistent volumes, not opening locked volumes: %v", err)
+		return
+	}
+	for _, pv := range pvs.Items {
+		if !p.locked.has(pv.Name) {
+			continue
+		}
+		var err error
+		if pv.Annotations[annDevice] != "" {
+			err = p.openEncryptedDevice(pv)
+		} else if pv.Annotations[annFscryptKey] != "" {
+			err = p.addVolumeFscryptKey(pv)
+		} else {
+			err = p.attachImageVolume(pv)
+		}
+		if err != nil {
+			klog.V(3).Infof("encrypted volume %s is still locked: %v", pv.Name, err)
+		} else {
+			klog.InfoS("Opened locked volume", "node", p.nodeName, "pv", pv.Name)
+		}
+	}
+}
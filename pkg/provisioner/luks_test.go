@@ -0,0 +1,165 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package provisioner
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	v1 "k8s.io/api/core/v1"
+	storagev1 "k8s.io/api/storage/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/sig-storage-lib-external-provisioner/v13/controller"
+)
+
+func Test_encryptionSecretFor(t *testing.T) {
+	class := func(secret string) *storagev1.StorageClass {
+		return &storagev1.StorageClass{ObjectMeta: metav1.ObjectMeta{Name: "hostpath"}, Parameters: map[string]string{encryptionSecretParameter: secret}}
+	}
+	block := v1.PersistentVolumeBlock
+	claim := &v1.PersistentVolumeClaim{ObjectMeta: metav1.ObjectMeta{Name: "disk", Namespace: "vms"}}
+	blockClaim := &v1.PersistentVolumeClaim{ObjectMeta: metav1.ObjectMeta{Name: "osd", Namespace: "ceph"}, Spec: v1.PersistentVolumeClaimSpec{VolumeMode: &block}}
+	tests := []struct {
+		name    string
+		class   *storagev1.StorageClass
+		claim   *v1.PersistentVolumeClaim
+		backing string
+		want    string
+		wantErr bool
+	}{
+		{name: "no class", claim: claim, backing: backingImage},
+		{name: "not set", class: &storagev1.StorageClass{}, claim: claim, backing: backingImage},
+		{name: "per class", class: class("kube-system/volume-key"), claim: claim, backing: backingImage, want: "kube-system/volume-key"},
+		{name: "per claim", class: class("${pvc.namespace}/${pvc.name}-key"), claim: claim, backing: backingImage, want: "vms/disk-key"},
+		{name: "block claim", class: class("${pvc.namespace}/osd-key"), claim: blockClaim, want: "ceph/osd-key"},
+		{name: "directory", class: class("kube-system/volume-key"), claim: claim, backing: backingDirectory, want: "kube-system/volume-key"},
+		{name: "tmpfs", class: class("kube-system/volume-key"), claim: claim, backing: backingTmpfs, wantErr: true},
+		{name: "no namespace", class: class("volume-key"), claim: claim, backing: backingImage, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := encryptionSecretFor(controller.ProvisionOptions{StorageClass: tt.class, PVC: tt.claim}, tt.backing)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("encryptionSecretFor() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("encryptionSecretFor() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+// fakeCommandInputs replaces runCommandInput, recording the commands and the
+// input they were given.
+func fakeCommandInputs(fail string) (*[]string, func()) {
+	var commands []string
+	original := runCommandInput
+	runCommandInput = func(input []byte, name string, args ...string) ([]byte, error) {
+		command := strings.Join(append([]string{name}, args...), " ")
+		commands = append(commands, string(input)+" | "+command)
+		if command == fail {
+			return nil, fmt.Errorf("%s failed", command)
+		}
+		return nil, nil
+	}
+	return &commands, func() { runCommandInput = original }
+}
+
+func Test_luksOpen(t *testing.T) {
+	dir, err := ioutil.TempDir("", "luks")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	defer func(dir string) { mapperDir = dir }(mapperDir)
+	mapperDir = dir
+
+	luks := &luksVolume{name: "hpp-pvc-1", key: []byte("secret")}
+	format := "cryptsetup luksFormat --batch-mode --type luks2 --key-file=- /dev/loop3"
+	open := "cryptsetup open --type luks --key-file=- /dev/loop3 hpp-pvc-1"
+	tests := []struct {
+		name         string
+		isLuks       bool
+		format       bool
+		opened       bool
+		wantCommands []string
+	}{
+		{name: "new", format: true, wantCommands: []string{"secret | " + format, "secret | " + open}},
+		{name: "formatted", format: true, isLuks: true, wantCommands: []string{"secret | " + open}},
+		{name: "reopen", wantCommands: []string{"secret | " + open}},
+		{name: "open", format: true, opened: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			outputs := map[string]string{}
+			if tt.isLuks {
+				outputs["cryptsetup isLuks /dev/loop3"] = ""
+			}
+			_, restore := fakeCommands(outputs)
+			defer restore()
+			commands, restoreInput := fakeCommandInputs("")
+			defer restoreInput()
+			mapped := filepath.Join(dir, luks.name)
+			os.Remove(mapped)
+			if tt.opened {
+				if err := ioutil.WriteFile(mapped, nil, 0600); err != nil {
+					t.Fatal(err)
+				}
+			}
+			got, err := luksOpen("/dev/loop3", luks, tt.format)
+			if err != nil {
+				t.Fatalf("luksOpen() failed: %v", err)
+			}
+			if got != mapped {
+				t.Errorf("luksOpen() = %s, want %s", got, mapped)
+			}
+			if !reflect.DeepEqual(*commands, tt.wantCommands) {
+				t.Errorf("commands = %v, want %v", *commands, tt.wantCommands)
+			}
+		})
+	}
+}
+
+func Test_luksClose(t *testing.T) {
+	dir, err := ioutil.TempDir("", "luks")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	defer func(dir string) { mapperDir = dir }(mapperDir)
+	mapperDir = dir
+	commands, restore := fakeCommands(map[string]string{"cryptsetup close hpp-pvc-1": ""})
+	defer restore()
+
+	if err := luksClose("hpp-pvc-1"); err != nil {
+		t.Fatalf("luksClose() of a closed container failed: %v", err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(dir, "hpp-pvc-1"), nil, 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := luksClose("hpp-pvc-1"); err != nil {
+		t.Fatalf("luksClose() failed: %v", err)
+	}
+	if want := []string{"cryptsetup close hpp-pvc-1"}; !reflect.DeepEqual(*commands, want) {
+		t.Errorf("commands = %v, want %v", *commands, want)
+	}
+}
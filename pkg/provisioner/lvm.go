@@ -0,0 +1,414 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package provisioner
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+
+	"k8s.io/klog/v2"
+
+	v1 "k8s.io/api/core/v1"
+	"sigs.k8s.io/sig-storage-lib-external-provisioner/v13/controller"
+)
+
+const (
+	// annLVMVolume records the logical volume, volume group/name, backing a
+	// volume in an LVM pool.
+	annLVMVolume = "kubevirt.io/lvmVolume"
+
+	defaultLVMFilesystem = "ext4"
+
+	// lvmAllocationParameter is the StorageClass parameter choosing how the
+	// logical volumes of LVM pools are allocated.
+	lvmAllocationParameter = "lvmAllocation"
+	// lvmThin volumes take space in the thin pool as data is written to
+	// them, the thin pool can be overcommitted.
+	lvmThin = "thin"
+	// lvmThick volumes are fully allocated from the volume group when they
+	// are created, writing to them never fails for lack of space.
+	lvmThick = "thick"
+)
+
+// lvmName matches the names LVM accepts for volume groups and volumes.
+var lvmName = regexp.MustCompile(`^[a-zA-Z0-9+_.][a-zA-Z0-9+_.-]*$`)
+
+// lvmFilesystems are the filesystems logical volumes can be formatted with.
+var lvmFilesystems = map[string]bool{"ext4": true, "xfs": true}
+
+// runCommand runs a command and returns its output, the output is part of
+// the error when it fails. It is replaced in tests.
+var runCommand = func(name string, args ...string) ([]byte, error) {
+	output, err := exec.Command(name, args...).CombinedOutput()
+	if err != nil {
+		return output, fmt.Errorf("%s %s: %v: %s", name, strings.Join(args, " "), err, bytes.TrimSpace(output))
+	}
+	return output, nil
+}
+
+// lvmPool is the LVM volume group, and optionally the thin pool in it, the
+// volumes of a storage pool are created in. Every volume is a logical volume
+// with a filesystem of its own, mounted at its backing directory, so that its
+// size is enforced and deleting it is instant.
+type lvmPool struct {
+	volumeGroup string
+	thinPool    string
+	fsType      string
+	command     lvmCommand
+}
+
+// newLVMPool returns the LVM pool in the volume group, and its thin pool when
+// set, nil when neither is set.
+func newLVMPool(volumeGroup, thinPool, fsType string) (*lvmPool, error) {
+	if volumeGroup == "" && thinPool == "" {
+		return nil, nil
+	}
+	if !lvmName.MatchString(volumeGroup) {
+		return nil, fmt.Errorf("invalid LVM volume group %q", volumeGroup)
+	}
+	if thinPool != "" && !lvmName.MatchString(thinPool) {
+		return nil, fmt.Errorf("invalid LVM thin pool %q", thinPool)
+	}
+	if fsType == "" {
+		fsType = defaultLVMFilesystem
+	}
+	if !lvmFilesystems[fsType] {
+		return nil, fmt.Errorf("unsupported filesystem %q for logical volumes, expected ext4 or xfs", fsType)
+	}
+	return &lvmPool{volumeGroup: volumeGroup, thinPool: thinPool, fsType: fsType}, nil
+}
+
+// hasLVMPool returns whether one of the pools is backed by LVM.
+func hasLVMPool(pools []*storagePool) bool {
+	for _, pool := range pools {
+		if pool.lvm != nil {
+			return true
+		}
+	}
+	return false
+}
+
+func (l *lvmPool) String() string {
+	if l.thinPool == "" {
+		return l.volumeGroup
+	}
+	return l.volumeGroup + "/" + l.thinPool
+}
+
+// lvmAllocationFor returns how the StorageClass asks for logical volumes to be
+// allocated, empty when it does not.
+func lvmAllocationFor(options controller.ProvisionOptions) (string, error) {
+	if options.StorageClass == nil {
+		return "", nil
+	}
+	allocation := options.StorageClass.Parameters[lvmAllocationParameter]
+	switch allocation {
+	case "", lvmThin, lvmThick:
+		return allocation, nil
+	}
+	return "", fmt.Errorf("invalid %s %q in storage class %s, expected %s or %s", lvmAllocationParameter, allocation, options.StorageClass.Name, lvmThin, lvmThick)
+}
+
+// allocation returns how volumes are allocated in the pool when the storage
+// class does not choose: thin when the pool has a thin pool.
+func (l *lvmPool) allocation(requested string) string {
+	if requested != "" {
+		return requested
+	}
+	if l.thinPool != "" {
+		return lvmThin
+	}
+	return lvmThick
+}
+
+// supportsAllocation returns whether volumes can be allocated in the pool as
+// requested. Pools of plain directories only take volumes that do not ask for
+// an allocation, device pools only take block claims.
+func (pool *storagePool) supportsAllocation(allocation string) bool {
+	if (allocation == allocationDevice) != (pool.devices != nil) {
+		return false
+	}
+	if allocation == "" || allocation == allocationDevice {
+		return true
+	}
+	if pool.lvm == nil {
+		return false
+	}
+	return allocation == lvmThick || pool.lvm.thinPool != ""
+}
+
+// lvmCommand is the lvm binary of the configuration, the default one when
+// empty.
+type lvmCommand string
+
+// run runs lvm with args.
+func (c lvmCommand) run(args ...string) ([]byte, error) {
+	if c == "" {
+		c = lvmCommand(defaultCommands.LVM)
+	}
+	return runCommand(string(c), args...)
+}
+
+// usage returns the size of the thin pool in bytes, the largest volume that
+// can be created in it, and how many of those bytes are free.
+func (l *lvmPool) usage() (int64, int64, error) {
+	output, err := l.command.run("lvs", "--noheadings", "--nosuffix", "--units", "b", "-o", "lv_size,data_percent", l.String())
+	if err != nil {
+		return 0, 0, err
+	}
+	fields := strings.Fields(string(output))
+	if len(fields) != 2 {
+		return 0, 0, fmt.Errorf("unexpected lvs output for %s: %q", l, output)
+	}
+	size, err := strconv.ParseInt(fields[0], 10, 64)
+	if err != nil {
+		return 0, 0, fmt.Errorf("unable to parse the size of %s: %v", l, err)
+	}
+	used, err := strconv.ParseFloat(fields[1], 64)
+	if err != nil {
+		return 0, 0, fmt.Errorf("unable to parse the data usage of %s: %v", l, err)
+	}
+	return size, size - int64(float64(size)*used/100), nil
+}
+
+// volumeGroupFree returns the bytes free in the volume group and the size of
+// its extents, the unit space is allocated in.
+func (l *lvmPool) volumeGroupFree() (int64, int64, error) {
+	output, err := l.command.run("vgs", "--noheadings", "--nosuffix", "--units", "b", "-o", "vg_free_count,vg_extent_size", l.volumeGroup)
+	if err != nil {
+		return 0, 0, err
+	}
+	fields := strings.Fields(string(output))
+	if len(fields) != 2 {
+		return 0, 0, fmt.Errorf("unexpected vgs output for %s: %q", l.volumeGroup, output)
+	}
+	count, err := strconv.ParseInt(fields[0], 10, 64)
+	if err != nil {
+		return 0, 0, fmt.Errorf("unable to parse the free extents of %s: %v", l.volumeGroup, err)
+	}
+	extent, err := strconv.ParseInt(fields[1], 10, 64)
+	if err != nil || extent <= 0 {
+		return 0, 0, fmt.Errorf("unable to parse the extent size of %s: %q", l.volumeGroup, fields[1])
+	}
+	return count * extent, extent, nil
+}
+
+// thickCapacity returns the bytes free for thick volumes, not counting the
+// volumes being created.
+func (l *lvmPool) thickCapacity() (int64, error) {
+	free, _, err := l.volumeGroupFree()
+	if err != nil {
+		return 0, err
+	}
+	return free - thickReservations.get(l.volumeGroup), nil
+}
+
+// extentReservations tracks the space of the thick volumes being created,
+// which the volume groups do not account for yet, so that concurrent claims
+// do not count the same free extents.
+type extentReservations struct {
+	mutex    sync.Mutex
+	reserved map[string]int64
+}
+
+// thickReservations are shared by the provisioners of the process, whose
+// pools may be in the same volume groups.
+var thickReservations = &extentReservations{reserved: map[string]int64{}}
+
+func (r *extentReservations) get(volumeGroup string) int64 {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	return r.reserved[volumeGroup]
+}
+
+// reserve reserves the extents of a volume of size bytes in the pool's volume
+// group, failing when they are not free, and returns the function releasing
+// them.
+func (r *extentReservations) reserve(l *lvmPool, size int64) (func(), error) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	free, extent, err := l.volumeGroupFree()
+	if err != nil {
+		return nil, err
+	}
+	needed := (size + extent - 1) / extent * extent
+	if available := free - r.reserved[l.volumeGroup]; needed > available {
+		return nil, fmt.Errorf("volume group %s has %d bytes free, a thick volume of %d bytes needs %d", l.volumeGroup, available, size, needed)
+	}
+	r.reserved[l.volumeGroup] += needed
+	return func() {
+		r.mutex.Lock()
+		defer r.mutex.Unlock()
+		r.reserved[l.volumeGroup] -= needed
+	}, nil
+}
+
+// createVolume creates a logical volume of size bytes named name, allocated
+// as requested, formats it and mounts it at path, and returns the logical
+// volume. A volume left behind by an earlier attempt is reused.
+func (l *lvmPool) createVolume(name string, size int64, path, allocation string) (string, error) {
+	lv := l.volumeGroup + "/" + name
+	if !l.command.exists(lv) {
+		var err error
+		if l.allocation(allocation) == lvmThick {
+			err = l.createThickVolume(name, size)
+		} else {
+			_, err = l.command.run("lvcreate", "--thin", "--virtualsize", fmt.Sprintf("%db", size), "--name", name, l.String())
+		}
+		if err != nil {
+			return "", err
+		}
+	}
+	if err := mountLogicalVolume(lv, l.fsType, path, true); err != nil {
+		if _, removeErr := l.command.run("lvremove", "--yes", lv); removeErr != nil {
+			klog.Warningf("unable to remove logical volume %s after failing to mount it: %v", lv, removeErr)
+		}
+		return "", err
+	}
+	return lv, nil
+}
+
+// createThickVolume creates a fully allocated logical volume in the volume
+// group.
+func (l *lvmPool) createThickVolume(name string, size int64) error {
+	release, err := thickReservations.reserve(l, size)
+	if err != nil {
+		return err
+	}
+	defer release()
+	_, err = l.command.run("lvcreate", "--size", fmt.Sprintf("%db", size), "--name", name, l.volumeGroup)
+	return err
+}
+
+// exists returns whether lv, volume group/name, exists.
+func (c lvmCommand) exists(lv string) bool {
+	_, err := c.run("lvs", lv)
+	return err == nil
+}
+
+// mountLogicalVolume mounts lv at path. With format, a volume without a
+// filesystem is formatted with fsType first.
+func mountLogicalVolume(lv, fsType, path string, format bool) error {
+	return mountDevice("/dev/"+lv, imageFilesystem{fsType: fsType}, path, format)
+}
+
+// mountDevice mounts the block device at path with the mount options of fs.
+// With format, a device without a filesystem is formatted with fs first.
+func mountDevice(device string, fs imageFilesystem, path string, format bool) error {
+	if format {
+		// blkid fails for devices without a filesystem
+		output, _ := runCommand("blkid", "-o", "value", "-s", "TYPE", device)
+		if strings.TrimSpace(string(output)) != "" {
+			format = false
+		}
+	}
+	if format {
+		args := append(append([]string{"-t", fs.fsType}, fs.mkfsOptions...), device)
+		if _, err := runCommand("mkfs", args...); err != nil {
+			return err
+		}
+	}
+	if err := os.MkdirAll(path, 0755); err != nil {
+		return err
+	}
+	if isMountPoint(path) {
+		return nil
+	}
+	args := []string{"-t", fs.fsType}
+	options := fs.mountOptions
+	// Clones share the UUID of their source's filesystem
+	if fs.fsType == "xfs" {
+		options = append([]string{"nouuid"}, options...)
+	}
+	if len(options) > 0 {
+		args = append(args, "-o", strings.Join(options, ","))
+	}
+	_, err := runCommand("mount", append(args, device, path)...)
+	return err
+}
+
+// remove unmounts lv from path and removes it.
+func (c lvmCommand) remove(lv, path string) error {
+	if isMountPoint(path) {
+		if _, err := runCommand("umount", path); err != nil {
+			return err
+		}
+	}
+	if !c.exists(lv) {
+		return nil
+	}
+	_, err := c.run("lvremove", "--yes", lv)
+	return err
+}
+
+// isMountPoint returns whether something is mounted at path.
+func isMountPoint(path string) bool {
+	mounts, err := readMounts(procMountsPath)
+	if err != nil {
+		return false
+	}
+	mount := findMount(mounts, path)
+	return mount != nil && mount.mountPoint == path
+}
+
+// mountLogicalVolumes mounts the logical volumes of this node's volumes that
+// are not mounted, e.g. after the node rebooted.
+func (p *HostPathProvisioner) mountLogicalVolumes() {
+	pvs, err := p.pvCache.list(context.TODO(), p.client)
+	if err != nil {
+		klog.Warningf("unable to list persistent volumes, not mounting logical volumes: %v", err)
+		return
+	}
+	for _, pv := range pvs.Items {
+		if err := p.mountVolumeLV(pv); err != nil {
+			klog.ErrorS(err, "Failed to mount logical volume", "node", p.nodeName, "pv", pv.Name, "lv", pv.Annotations[annLVMVolume])
+		}
+	}
+}
+
+// mountVolumeLV mounts the logical volume of pv, if it is one of ours.
+func (p *HostPathProvisioner) mountVolumeLV(pv v1.PersistentVolume) error {
+	lv := pv.Annotations[annLVMVolume]
+	if lv == "" || backingPath(&pv) == "" || !p.ownsIdentity(pv.Annotations["hostPathProvisionerIdentity"]) || pv.Annotations["kubevirt.io/provisionOnNode"] != p.nodeName {
+		return nil
+	}
+	path := backingPath(&pv)
+	if isMountPoint(path) {
+		return nil
+	}
+	pool := findPool(p.currentPools(), pv.Annotations[annStoragePool])
+	if pool == nil || pool.lvm == nil {
+		return fmt.Errorf("pool %q of logical volume %s is not an LVM pool", pv.Annotations[annStoragePool], lv)
+	}
+	if _, err := pool.lvm.command.run("lvchange", "--activate", "y", lv); err != nil {
+		return err
+	}
+	klog.InfoS("Mounting logical volume", "node", p.nodeName, "pv", pv.Name, "lv", lv, "path", path)
+	return mountLogicalVolume(lv, pool.lvm.fsType, path, false)
+}
+
+// lvm returns the lvm binary of the configuration.
+func (p *HostPathProvisioner) lvm() lvmCommand {
+	return lvmCommand(p.commands.LVM)
+}
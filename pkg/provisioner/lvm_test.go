@@ -0,0 +1,217 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package provisioner
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// fakeCommands replaces runCommand, recording the commands run and answering
+// them from outputs, keyed by the command line. Unknown commands fail.
+func fakeCommands(outputs map[string]string) (*[]string, func()) {
+	var commands []string
+	original := runCommand
+	runCommand = func(name string, args ...string) ([]byte, error) {
+		command := strings.Join(append([]string{name}, args...), " ")
+		commands = append(commands, command)
+		if output, ok := outputs[command]; ok {
+			return []byte(output), nil
+		}
+		return nil, fmt.Errorf("%s failed", command)
+	}
+	return &commands, func() { runCommand = original }
+}
+
+func Test_newLVMPool(t *testing.T) {
+	tests := []struct {
+		name                      string
+		volumeGroup, thin, fsType string
+		want                      *lvmPool
+		wantErr                   bool
+	}{
+		{name: "none"},
+		{name: "default filesystem", volumeGroup: "vg0", thin: "thin", want: &lvmPool{volumeGroup: "vg0", thinPool: "thin", fsType: "ext4"}},
+		{name: "xfs", volumeGroup: "vg0", thin: "thin", fsType: "xfs", want: &lvmPool{volumeGroup: "vg0", thinPool: "thin", fsType: "xfs"}},
+		{name: "no volume group", thin: "thin", wantErr: true},
+		{name: "volume group only", volumeGroup: "vg0", want: &lvmPool{volumeGroup: "vg0", fsType: "ext4"}},
+		{name: "invalid name", volumeGroup: "vg0/x", thin: "thin", wantErr: true},
+		{name: "unsupported filesystem", volumeGroup: "vg0", thin: "thin", fsType: "vfat", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := newLVMPool(tt.volumeGroup, tt.thin, tt.fsType)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("newLVMPool() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("newLVMPool() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_lvmPoolUsage(t *testing.T) {
+	_, restore := fakeCommands(map[string]string{
+		"lvm lvs --noheadings --nosuffix --units b -o lv_size,data_percent vg0/thin": "  107374182400 25.00\n",
+	})
+	defer restore()
+	size, free, err := (&lvmPool{volumeGroup: "vg0", thinPool: "thin"}).usage()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if size != 107374182400 || free != 80530636800 {
+		t.Errorf("usage() = %d, %d, want 107374182400, 80530636800", size, free)
+	}
+}
+
+func Test_lvmPoolCreateVolume(t *testing.T) {
+	dir, err := ioutil.TempDir("", "lvm")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "pvc-1")
+
+	commands, restore := fakeCommands(map[string]string{
+		"lvm lvcreate --thin --virtualsize 1073741824b --name pvc-1 vg0/thin": "",
+		"mkfs -t ext4 /dev/vg0/pvc-1":                                         "",
+		"mount -t ext4 /dev/vg0/pvc-1 " + path:                                "",
+	})
+	defer restore()
+	pool := &lvmPool{volumeGroup: "vg0", thinPool: "thin", fsType: "ext4"}
+	lv, err := pool.createVolume("pvc-1", 1073741824, path, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if lv != "vg0/pvc-1" {
+		t.Errorf("createVolume() = %s, want vg0/pvc-1", lv)
+	}
+	want := []string{
+		"lvm lvs vg0/pvc-1",
+		"lvm lvcreate --thin --virtualsize 1073741824b --name pvc-1 vg0/thin",
+		"blkid -o value -s TYPE /dev/vg0/pvc-1",
+		"mkfs -t ext4 /dev/vg0/pvc-1",
+		"mount -t ext4 /dev/vg0/pvc-1 " + path,
+	}
+	if !reflect.DeepEqual(*commands, want) {
+		t.Errorf("commands = %q, want %q", *commands, want)
+	}
+	if _, err := os.Stat(path); err != nil {
+		t.Errorf("mount point not created: %v", err)
+	}
+
+	// A volume that can not be formatted is removed again
+	commands, restore = fakeCommands(map[string]string{
+		"lvm lvcreate --thin --virtualsize 1073741824b --name pvc-2 vg0/thin": "",
+	})
+	defer restore()
+	if _, err := pool.createVolume("pvc-2", 1073741824, filepath.Join(dir, "pvc-2"), ""); err == nil {
+		t.Fatal("createVolume() succeeded with failing commands")
+	}
+	if last := (*commands)[len(*commands)-1]; last != "lvm lvremove --yes vg0/pvc-2" {
+		t.Errorf("last command = %q, want the volume removed", last)
+	}
+}
+
+func Test_lvmCommand_remove(t *testing.T) {
+	commands, restore := fakeCommands(map[string]string{
+		"lvm lvs vg0/pvc-1":            "",
+		"lvm lvremove --yes vg0/pvc-1": "",
+	})
+	defer restore()
+	if err := lvmCommand("lvm").remove("vg0/pvc-1", "/nonexistent/pvc-1"); err != nil {
+		t.Fatal(err)
+	}
+	want := []string{"lvm lvs vg0/pvc-1", "lvm lvremove --yes vg0/pvc-1"}
+	if !reflect.DeepEqual(*commands, want) {
+		t.Errorf("commands = %q, want %q", *commands, want)
+	}
+
+	// A volume that is already gone is not an error, so that retries succeed
+	if err := lvmCommand("lvm").remove("vg0/pvc-2", "/nonexistent/pvc-2"); err != nil {
+		t.Errorf("remove() of a removed volume = %v", err)
+	}
+}
+
+func Test_extentReservations(t *testing.T) {
+	// 10 free extents of 4MiB
+	_, restore := fakeCommands(map[string]string{
+		"lvm vgs --noheadings --nosuffix --units b -o vg_free_count,vg_extent_size vg0": "  10 4194304\n",
+	})
+	defer restore()
+	pool := &lvmPool{volumeGroup: "vg0"}
+	reservations := &extentReservations{reserved: map[string]int64{}}
+
+	// Sizes are rounded up to whole extents
+	release, err := reservations.reserve(pool, 5*1024*1024)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := reservations.get("vg0"); got != 8*1024*1024 {
+		t.Errorf("reserved %d bytes, want two extents", got)
+	}
+	if _, err := reservations.reserve(pool, 33*1024*1024); err == nil {
+		t.Error("reserve() counted the extents of the volume being created as free")
+	}
+	if _, err := reservations.reserve(pool, 32*1024*1024); err != nil {
+		t.Errorf("reserve() of the remaining extents = %v", err)
+	}
+	release()
+	if got := reservations.get("vg0"); got != 32*1024*1024 {
+		t.Errorf("reserved %d bytes after the release, want 32MiB", got)
+	}
+}
+
+func Test_supportsAllocation(t *testing.T) {
+	directory := &storagePool{name: "dir"}
+	thin := &storagePool{name: "thin", lvm: &lvmPool{volumeGroup: "vg0", thinPool: "thin"}}
+	thick := &storagePool{name: "thick", lvm: &lvmPool{volumeGroup: "vg0"}}
+	devices := &storagePool{name: "devices", devices: &devicePool{pattern: "*"}}
+	tests := []struct {
+		pool       *storagePool
+		allocation string
+		want       bool
+	}{
+		{directory, "", true},
+		{directory, lvmThin, false},
+		{directory, lvmThick, false},
+		{thin, "", true},
+		{thin, lvmThin, true},
+		{thin, lvmThick, true},
+		{thick, lvmThin, false},
+		{thick, lvmThick, true},
+		{directory, allocationDevice, false},
+		{thin, allocationDevice, false},
+		{devices, "", false},
+		{devices, lvmThick, false},
+		{devices, allocationDevice, true},
+	}
+	for _, tt := range tests {
+		if got := tt.pool.supportsAllocation(tt.allocation); got != tt.want {
+			t.Errorf("%s pool supportsAllocation(%q) = %v, want %v", tt.pool.name, tt.allocation, got, tt.want)
+		}
+	}
+	if got := thick.lvm.allocation(""); got != lvmThick {
+		t.Errorf("default allocation without thin pool = %s, want %s", got, lvmThick)
+	}
+}
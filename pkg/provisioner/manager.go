@@ -0,0 +1,279 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package provisioner
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"runtime/debug"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+
+	"k8s.io/klog/v2"
+
+	v1 "k8s.io/api/core/v1"
+	storage "k8s.io/api/storage/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
+	toolscache "k8s.io/client-go/tools/cache"
+	"sigs.k8s.io/controller-runtime/pkg/cache"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/manager"
+	metricsserver "sigs.k8s.io/controller-runtime/pkg/metrics/server"
+)
+
+// newManager returns the controller-runtime manager the provisioner runs
+// under. It runs the provision controller and the background loops, serves
+// the health checks on the health port and holds the informer cache they
+// share. With leader election the replicas on a node compete for a lock of
+// their own, holding it with identity.
+func newManager(restConfig *rest.Config, kubeClient kubernetes.Interface, cfg *Config, nodeName, identity string) (manager.Manager, error) {
+	shutdownTimeout := cfg.ShutdownTimeout.Duration
+	options := manager.Options{
+		Logger: klog.NewKlogr(),
+		// The provision controller serves the metrics
+		Metrics:                 metricsserver.Options{BindAddress: "0"},
+		HealthProbeBindAddress:  "0",
+		GracefulShutdownTimeout: &shutdownTimeout,
+	}
+	if cfg.HealthPort > 0 {
+		options.HealthProbeBindAddress = net.JoinHostPort("", strconv.Itoa(cfg.HealthPort))
+	}
+	if election := cfg.Controller.LeaderElection; election.Enabled {
+		// Replicas only compete with the other replicas on the same node
+		err := leaderElectionOptions(&options, kubeClient, leaderElectionLockName(cfg.ProvisionerName, nodeName), election.Namespace, identity,
+			election.LeaseDuration.Duration, election.RenewDeadline.Duration, election.RetryPeriod.Duration)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return manager.New(restConfig, options)
+}
+
+// sharedInformers returns the informers of the manager's cache for claims,
+// volumes and storage classes, which the provision controller gets instead
+// of informers of its own.
+func sharedInformers(ctx context.Context, c cache.Cache) (claims toolscache.SharedIndexInformer, volumes, classes toolscache.SharedInformer, err error) {
+	informer := func(obj client.Object) (toolscache.SharedIndexInformer, error) {
+		i, err := c.GetInformer(ctx, obj)
+		if err != nil {
+			return nil, err
+		}
+		shared, ok := i.(toolscache.SharedIndexInformer)
+		if !ok {
+			return nil, fmt.Errorf("the informer of %T is a %T, not a shared index informer", obj, i)
+		}
+		return shared, nil
+	}
+	if claims, err = informer(&v1.PersistentVolumeClaim{}); err != nil {
+		return nil, nil, nil, err
+	}
+	if volumes, err = informer(&v1.PersistentVolume{}); err != nil {
+		return nil, nil, nil, err
+	}
+	if classes, err = informer(&storage.StorageClass{}); err != nil {
+		return nil, nil, nil, err
+	}
+	return claims, volumes, classes, nil
+}
+
+// volumeCache lists the PVs, and gets storage classes, from the manager's
+// cache once it has synced, from the API server before that and when the
+// provisioner runs without a manager. A nil volumeCache always uses the API
+// server.
+type volumeCache struct {
+	// reader holds the synced client.Reader
+	reader atomic.Value
+}
+
+// useCache lists the PVs from c as soon as it has synced, unless ctx is done
+// first.
+func (v *volumeCache) useCache(ctx context.Context, c cache.Cache) {
+	if c.WaitForCacheSync(ctx) {
+		v.reader.Store(client.Reader(c))
+	}
+}
+
+// list returns the PVs, listing them with kubeClient while there is no cache.
+// The PVs are copies, they may be changed.
+func (v *volumeCache) list(ctx context.Context, kubeClient kubernetes.Interface) (*v1.PersistentVolumeList, error) {
+	if v != nil {
+		if reader, ok := v.reader.Load().(client.Reader); ok {
+			pvs := &v1.PersistentVolumeList{}
+			if err := reader.List(ctx, pvs); err != nil {
+				return nil, err
+			}
+			return pvs, nil
+		}
+	}
+	return kubeClient.CoreV1().PersistentVolumes().List(ctx, metav1.ListOptions{})
+}
+
+// storageClass returns the storage class, getting it with kubeClient while
+// there is no cache.
+func (v *volumeCache) storageClass(ctx context.Context, kubeClient kubernetes.Interface, name string) (*storage.StorageClass, error) {
+	if v != nil {
+		if reader, ok := v.reader.Load().(client.Reader); ok {
+			class := &storage.StorageClass{}
+			if err := reader.Get(ctx, client.ObjectKey{Name: name}, class); err != nil {
+				return nil, err
+			}
+			return class, nil
+		}
+	}
+	return kubeClient.StorageV1().StorageClasses().Get(ctx, name, metav1.GetOptions{})
+}
+
+// loop is a background loop of the provisioner, it runs until stopCh is
+// closed.
+type loop func(stopCh <-chan struct{})
+
+// loopManager hands the background loops of the provisioner to the manager.
+// Loops are added by name, also before there is a manager, and are started
+// by the manager once its cache has synced and stopped when it stops. A loop
+// that panics is stopped instead of taking the process down with it, and
+// fails the health check, like a loop giving up with fail.
+type loopManager struct {
+	mutex sync.Mutex
+	mgr   manager.Manager
+	loops map[string]*loopRunnable
+	// failed are the loops that panicked or failed
+	failed map[string]bool
+}
+
+// loopRunnable is a loop as a runnable of the manager.
+type loopRunnable struct {
+	manager    *loopManager
+	name       string
+	run        loop
+	leaderOnly bool
+}
+
+func newLoopManager() *loopManager {
+	return &loopManager{loops: make(map[string]*loopRunnable), failed: make(map[string]bool)}
+}
+
+// add adds a loop every replica runs. Names are unique.
+func (m *loopManager) add(name string, run loop) {
+	m.addLoop(&loopRunnable{manager: m, name: name, run: run})
+}
+
+// addLeaderOnly adds a loop only the replica elected leader runs.
+func (m *loopManager) addLeaderOnly(name string, run loop) {
+	m.addLoop(&loopRunnable{manager: m, name: name, run: run, leaderOnly: true})
+}
+
+func (m *loopManager) addLoop(r *loopRunnable) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	if _, ok := m.loops[r.name]; ok {
+		panic(fmt.Sprintf("loop %s added twice", r.name))
+	}
+	m.loops[r.name] = r
+	if m.mgr != nil {
+		if err := m.mgr.Add(r); err != nil {
+			klog.Errorf("Unable to run loop %s: %v", r.name, err)
+		}
+	}
+}
+
+// setManager adds the loops added so far to mgr, and those added later.
+func (m *loopManager) setManager(mgr manager.Manager) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	if m.mgr != nil {
+		return fmt.Errorf("the loops already run under a manager")
+	}
+	m.mgr = mgr
+	for _, name := range m.namesLocked() {
+		if err := mgr.Add(m.loops[name]); err != nil {
+			return fmt.Errorf("unable to run loop %s: %v", name, err)
+		}
+	}
+	return nil
+}
+
+// Start implements manager.Runnable. It never returns an error, which would
+// stop the manager.
+func (r *loopRunnable) Start(ctx context.Context) error {
+	defer func() {
+		if rec := recover(); rec != nil {
+			klog.Errorf("loop %s panicked: %v\n%s", r.name, rec, debug.Stack())
+			r.manager.mutex.Lock()
+			defer r.manager.mutex.Unlock()
+			r.manager.failed[r.name] = true
+		}
+	}()
+	klog.V(2).InfoS("Starting loop", "loop", r.name)
+	r.run(ctx.Done())
+	// Loops may also give up on their own, e.g. when the node lacks what
+	// they need, having logged why
+	klog.V(2).InfoS("Loop returned", "loop", r.name)
+	return nil
+}
+
+// NeedLeaderElection implements manager.LeaderElectionRunnable.
+func (r *loopRunnable) NeedLeaderElection() bool {
+	return r.leaderOnly
+}
+
+// names returns the names of the loops, sorted.
+func (m *loopManager) names() []string {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	return m.namesLocked()
+}
+
+func (m *loopManager) namesLocked() []string {
+	names := make([]string, 0, len(m.loops))
+	for name := range m.loops {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// fail records that the loop gave up on an error it can not recover from,
+// which it logged, the health check fails until the process is restarted.
+func (m *loopManager) fail(name string) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	m.failed[name] = true
+}
+
+// check returns an error naming the loops that panicked or failed.
+func (m *loopManager) check() error {
+	if m == nil {
+		return nil
+	}
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	if len(m.failed) == 0 {
+		return nil
+	}
+	var failed []string
+	for name := range m.failed {
+		failed = append(failed, name)
+	}
+	sort.Strings(failed)
+	return fmt.Errorf("background loops failed: %s", strings.Join(failed, ", "))
+}
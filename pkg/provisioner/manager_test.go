@@ -0,0 +1,157 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package provisioner
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+	"k8s.io/client-go/rest"
+	"k8s.io/klog/v2"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/manager"
+	metricsserver "sigs.k8s.io/controller-runtime/pkg/metrics/server"
+)
+
+func Test_loopManager(t *testing.T) {
+	m := newLoopManager()
+	started := make(chan string, 3)
+	stopped := make(chan string, 3)
+	waiting := func(name string) loop {
+		return func(stopCh <-chan struct{}) {
+			started <- name
+			<-stopCh
+			stopped <- name
+		}
+	}
+	m.add("before", waiting("before"))
+	select {
+	case name := <-started:
+		t.Fatalf("loop %s started before the manager", name)
+	case <-time.After(10 * time.Millisecond):
+	}
+
+	// Nothing is read from the API server, which does not exist
+	mgr, err := manager.New(&rest.Config{Host: "http://127.0.0.1:1"}, manager.Options{
+		Logger:                 klog.NewKlogr(),
+		Metrics:                metricsserver.Options{BindAddress: "0"},
+		HealthProbeBindAddress: "0",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := m.setManager(mgr); err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error)
+	go func() {
+		done <- mgr.Start(ctx)
+	}()
+	m.add("after", waiting("after"))
+	m.addLeaderOnly("panicking", func(<-chan struct{}) {
+		started <- "panicking"
+		panic("boom")
+	})
+	for i := 0; i < 3; i++ {
+		<-started
+	}
+	if names, want := m.names(), []string{"after", "before", "panicking"}; !reflect.DeepEqual(names, want) {
+		t.Errorf("names() = %v, want %v", names, want)
+	}
+	if m.loops["before"].NeedLeaderElection() || !m.loops["panicking"].NeedLeaderElection() {
+		t.Error("NeedLeaderElection() does not tell the loops only the leader runs")
+	}
+	deadline := time.Now().Add(5 * time.Second)
+	for m.check() == nil && time.Now().Before(deadline) {
+		time.Sleep(time.Millisecond)
+	}
+	if err := m.check(); err == nil || err.Error() != "background loops failed: panicking" {
+		t.Errorf("check() = %v, want the panicking loop", err)
+	}
+
+	cancel()
+	for i := 0; i < 2; i++ {
+		select {
+		case <-stopped:
+		case <-time.After(5 * time.Second):
+			t.Fatal("loops not stopped")
+		}
+	}
+	if err := <-done; err != nil {
+		t.Errorf("manager stopped with %v", err)
+	}
+
+	defer func() {
+		if recover() == nil {
+			t.Error("add() of a loop added before did not panic")
+		}
+	}()
+	m.add("before", waiting("before"))
+}
+
+// listReader is a client.Reader listing the given PVs.
+type listReader struct {
+	client.Reader
+	pvs []v1.PersistentVolume
+	err error
+}
+
+func (r *listReader) List(_ context.Context, list client.ObjectList, _ ...client.ListOption) error {
+	list.(*v1.PersistentVolumeList).Items = r.pvs
+	return r.err
+}
+
+func Test_volumeCache(t *testing.T) {
+	apiPV := v1.PersistentVolume{ObjectMeta: metav1.ObjectMeta{Name: "pvc-api"}}
+	cachedPV := v1.PersistentVolume{ObjectMeta: metav1.ObjectMeta{Name: "pvc-cached"}}
+	kubeClient := fake.NewSimpleClientset(&apiPV)
+	names := func(v *volumeCache) []string {
+		pvs, err := v.list(context.TODO(), kubeClient)
+		if err != nil {
+			return []string{err.Error()}
+		}
+		var names []string
+		for _, pv := range pvs.Items {
+			names = append(names, pv.Name)
+		}
+		return names
+	}
+
+	var none *volumeCache
+	if got := names(none); !reflect.DeepEqual(got, []string{"pvc-api"}) {
+		t.Errorf("list() without a cache = %v, want the PVs of the API server", got)
+	}
+	v := &volumeCache{}
+	if got := names(v); !reflect.DeepEqual(got, []string{"pvc-api"}) {
+		t.Errorf("list() before the cache synced = %v, want the PVs of the API server", got)
+	}
+	v.reader.Store(client.Reader(&listReader{pvs: []v1.PersistentVolume{cachedPV}}))
+	if got := names(v); !reflect.DeepEqual(got, []string{"pvc-cached"}) {
+		t.Errorf("list() = %v, want the cached PVs", got)
+	}
+	v.reader.Store(client.Reader(&listReader{err: errors.New("cache stopped")}))
+	if got := names(v); !reflect.DeepEqual(got, []string{"cache stopped"}) {
+		t.Errorf("list() = %v, want the error of the cache", got)
+	}
+}
@@ -0,0 +1,220 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package provisioner
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"k8s.io/apimachinery/pkg/api/resource"
+)
+
+// Backends the provisioner can work with
+const (
+	backendHost   = "host"
+	backendMemory = "memory"
+)
+
+// memoryPoolInodes is the number of inodes of each simulated pool.
+const memoryPoolInodes = 1 << 20
+
+// newBackend returns the backend named name, simulated pools are poolSize
+// large.
+func newBackend(name, poolSize string) (backend, error) {
+	switch name {
+	case backendHost:
+		return hostBackend(), nil
+	case backendMemory:
+		size, err := resource.ParseQuantity(poolSize)
+		if err != nil || size.Sign() <= 0 {
+			return nil, fmt.Errorf("invalid memory pool size %q, expected a positive quantity such as 100Gi", poolSize)
+		}
+		return newMemoryBackend(size.Value()), nil
+	}
+	return nil, fmt.Errorf("invalid backend %q, expected %s or %s", name, backendHost, backendMemory)
+}
+
+// memoryBackend simulates filesystems and the directories created in them.
+// Every path outside the filesystems seen so far is the root of a new one,
+// so that the pool directories, including those of pools added by reloading
+// the configuration, each are a filesystem of their own.
+type memoryBackend struct {
+	size int64
+
+	mutex sync.Mutex
+	roots []string
+	dirs  map[string]*memoryDir
+}
+
+// memoryDir is a simulated directory, used are the bytes written to it.
+type memoryDir struct {
+	mode     os.FileMode
+	uid, gid int
+	used     int64
+}
+
+var _ backend = &memoryBackend{}
+
+func newMemoryBackend(size int64) *memoryBackend {
+	return &memoryBackend{size: size, dirs: make(map[string]*memoryDir)}
+}
+
+// Stat implements backend.
+func (b *memoryBackend) Stat(path string) (filesystemStats, error) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	root := b.rootOf(filepath.Clean(path))
+	used, inodes := b.usage(root)
+	free := b.size - used
+	if free < 0 {
+		free = 0
+	}
+	return filesystemStats{
+		capacity:   b.size,
+		free:       free,
+		available:  free,
+		inodes:     memoryPoolInodes,
+		inodesFree: memoryPoolInodes - inodes,
+	}, nil
+}
+
+// Create implements backend.
+func (b *memoryBackend) Create(path string, mode os.FileMode, uid, gid int) error {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	path = filepath.Clean(path)
+	dir, ok := b.dirs[path]
+	if !ok {
+		dir = &memoryDir{uid: os.Getuid(), gid: os.Getgid()}
+		b.dirs[path] = dir
+	}
+	dir.mode = mode
+	if uid != -1 {
+		dir.uid = uid
+	}
+	if gid != -1 {
+		dir.gid = gid
+	}
+	return nil
+}
+
+// Destroy implements backend.
+func (b *memoryBackend) Destroy(path string) error {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	path = filepath.Clean(path)
+	for dir := range b.dirs {
+		if within(dir, path) {
+			delete(b.dirs, dir)
+		}
+	}
+	return nil
+}
+
+// Usage implements backend.
+func (b *memoryBackend) Usage(path string) (int64, int64, error) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	path = filepath.Clean(path)
+	if _, ok := b.dirs[path]; !ok {
+		return 0, 0, &os.PathError{Op: "stat", Path: path, Err: os.ErrNotExist}
+	}
+	used, inodes := b.usage(path)
+	return used, inodes, nil
+}
+
+// write simulates bytes being written to the directory at path.
+func (b *memoryBackend) write(path string, bytes int64) error {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	dir, ok := b.dirs[filepath.Clean(path)]
+	if !ok {
+		return &os.PathError{Op: "write", Path: path, Err: os.ErrNotExist}
+	}
+	dir.used += bytes
+	return nil
+}
+
+// rootOf returns the root of the filesystem containing path, which becomes
+// one when there is none.
+func (b *memoryBackend) rootOf(path string) string {
+	root := ""
+	for _, r := range b.roots {
+		if within(path, r) && len(r) > len(root) {
+			root = r
+		}
+	}
+	if root == "" {
+		root = path
+		b.roots = append(b.roots, root)
+	}
+	return root
+}
+
+// usage returns the bytes and inodes used by the directories at or below
+// path.
+func (b *memoryBackend) usage(path string) (int64, int64) {
+	var used, inodes int64
+	for p, dir := range b.dirs {
+		if within(p, path) {
+			used += dir.used
+			inodes++
+		}
+	}
+	return used, inodes
+}
+
+// within returns whether path is dir or below it, both cleaned.
+func within(path, dir string) bool {
+	return path == dir || strings.HasPrefix(path, strings.TrimSuffix(dir, "/")+"/")
+}
+
+// simulatedPool returns an error unless the memory backend can simulate the
+// pool, only pools of plain directories are.
+func simulatedPool(pool *storagePool) error {
+	if pool.lvm != nil || pool.devices != nil {
+		return fmt.Errorf("pool %s is not a pool of plain directories, the memory backend can not simulate it", pool.name)
+	}
+	return nil
+}
+
+// simulatedVolume returns an error unless the memory backend can simulate the
+// volume, only plain directories are. Everything else is made with tools
+// working on the node.
+func simulatedVolume(cloned, striped bool, backing, compression, encryptionSecret string, export bool) error {
+	var feature string
+	switch {
+	case cloned:
+		feature = "cloning"
+	case striped:
+		feature = "spanning pools"
+	case backing != backingDirectory:
+		feature = backing + " backing"
+	case compression != "":
+		feature = "compression"
+	case encryptionSecret != "":
+		feature = "encryption"
+	case export:
+		feature = "NFS exports"
+	default:
+		return nil
+	}
+	return fmt.Errorf("the memory backend simulates plain directories only, %s is not supported", feature)
+}
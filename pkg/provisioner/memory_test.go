@@ -0,0 +1,223 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package provisioner
+
+import (
+	"context"
+	"os"
+	"testing"
+
+	v1 "k8s.io/api/core/v1"
+	storage "k8s.io/api/storage/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/sig-storage-lib-external-provisioner/v13/controller"
+)
+
+func Test_newBackend(t *testing.T) {
+	tests := []struct {
+		name     string
+		backend  string
+		size     string
+		wantSize int64
+		wantErr  bool
+	}{
+		{name: "host", backend: backendHost, size: "bogus"},
+		{name: "memory", backend: backendMemory, size: "10Gi", wantSize: 10 * GiB},
+		{name: "memory invalid size", backend: backendMemory, size: "bogus", wantErr: true},
+		{name: "memory zero size", backend: backendMemory, size: "0", wantErr: true},
+		{name: "unknown", backend: "zfs", size: "10Gi", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := newBackend(tt.backend, tt.size)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("newBackend() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if memory, ok := got.(*memoryBackend); ok && memory.size != tt.wantSize {
+				t.Errorf("newBackend() size = %d, want %d", memory.size, tt.wantSize)
+			}
+		})
+	}
+}
+
+func Test_memoryBackend(t *testing.T) {
+	b := newMemoryBackend(10 * GiB)
+	if stats, err := b.Stat("/pools/a"); err != nil || stats.capacity != 10*GiB || stats.used() != 0 {
+		t.Fatalf("Stat() = %+v, %v, want an empty 10Gi filesystem", stats, err)
+	}
+	if _, _, err := b.Usage("/pools/a/pvc-1"); !os.IsNotExist(err) {
+		t.Errorf("Usage() of a missing directory = %v, want not exist", err)
+	}
+	if err := b.Create("/pools/a/pvc-1", 0750, 107, -1); err != nil {
+		t.Fatal(err)
+	}
+	if err := b.Create("/pools/a/pvc-1/data", 0700, -1, -1); err != nil {
+		t.Fatal(err)
+	}
+	if dir := b.dirs["/pools/a/pvc-1"]; dir.mode != 0750 || dir.uid != 107 || dir.gid != os.Getgid() {
+		t.Errorf("directory = %+v, want mode 0750 owned by 107 and the current group", dir)
+	}
+	if err := b.write("/pools/a/pvc-1/data", 3*GiB); err != nil {
+		t.Fatal(err)
+	}
+	if bytes, inodes, err := b.Usage("/pools/a/pvc-1"); err != nil || bytes != 3*GiB || inodes != 2 {
+		t.Errorf("Usage() = %d, %d, %v, want 3Gi in 2 inodes", bytes, inodes, err)
+	}
+	if stats, _ := b.Stat("/pools/a/pvc-1"); stats.free != 7*GiB || stats.available != 7*GiB || stats.inodesFree != memoryPoolInodes-2 {
+		t.Errorf("Stat() = %+v, want 7Gi and all but 2 inodes free", stats)
+	}
+	// Other pools are filesystems of their own
+	if stats, _ := b.Stat("/pools/b"); stats.free != 10*GiB {
+		t.Errorf("Stat() of another pool = %+v, want 10Gi free", stats)
+	}
+	if stats, _ := b.Stat("/pools/ab"); stats.free != 10*GiB {
+		t.Errorf("Stat() of a pool sharing a prefix = %+v, want 10Gi free", stats)
+	}
+	if err := b.Destroy("/pools/a/pvc-1"); err != nil {
+		t.Fatal(err)
+	}
+	if len(b.dirs) != 0 {
+		t.Errorf("directories left after Destroy() = %v", b.dirs)
+	}
+	if stats, _ := b.Stat("/pools/a"); stats.free != 10*GiB {
+		t.Errorf("Stat() after Destroy() = %+v, want 10Gi free", stats)
+	}
+}
+
+func Test_simulatedPool(t *testing.T) {
+	if err := simulatedPool(&storagePool{name: "plain", path: "/pools/plain"}); err != nil {
+		t.Errorf("simulatedPool() of a pool of directories = %v", err)
+	}
+	if err := simulatedPool(&storagePool{name: "thin", path: "/pools/thin", lvm: &lvmPool{}}); err == nil {
+		t.Error("simulatedPool() of an LVM pool succeeded")
+	}
+	if err := simulatedPool(&storagePool{name: "disks", path: "/pools/disks", devices: &devicePool{}}); err == nil {
+		t.Error("simulatedPool() of a device pool succeeded")
+	}
+}
+
+// simulatedProvisioner returns a provisioner of the pools simulated by the
+// memory backend.
+func simulatedProvisioner(memory *memoryBackend, pools ...*storagePool) *HostPathProvisioner {
+	return &HostPathProvisioner{
+		host:       memory,
+		nodeName:   "node01",
+		identity:   "id",
+		pools:      pools,
+		poolPolicy: policyMostFree,
+		rounding:   defaultCapacityRounding,
+		simulated:  true,
+		operations: newOperationLog(recentOperationsSize),
+		attempts:   newAttemptCounter(),
+		fsOps:      newFSOperations(),
+	}
+}
+
+// simulatedClaim returns the options provisioning a 5Gi claim, of a storage
+// class with the parameters, into the volume pvName.
+func simulatedClaim(pvName string, parameters map[string]string) controller.ProvisionOptions {
+	return controller.ProvisionOptions{
+		PVName: pvName,
+		PVC: &v1.PersistentVolumeClaim{
+			ObjectMeta: metav1.ObjectMeta{Name: "data-" + pvName, Namespace: "default", UID: types.UID("uid-" + pvName)},
+			Spec: v1.PersistentVolumeClaimSpec{
+				AccessModes: []v1.PersistentVolumeAccessMode{v1.ReadWriteOnce},
+				Resources: v1.VolumeResourceRequirements{
+					Requests: v1.ResourceList{v1.ResourceStorage: resource.MustParse("5Gi")},
+				},
+			},
+		},
+		StorageClass: &storage.StorageClass{Parameters: parameters},
+	}
+}
+
+func Test_simulatedProvisioning(t *testing.T) {
+	memory := newMemoryBackend(10 * GiB)
+	p := simulatedProvisioner(memory, &storagePool{name: "a", path: "/simulated/a"}, &storagePool{name: "b", path: "/simulated/b"})
+
+	pv, _, err := p.Provision(context.TODO(), simulatedClaim("pvc-1", nil))
+	if err != nil {
+		t.Fatalf("Provision() error = %v", err)
+	}
+	path := backingPath(pv)
+	if path != "/simulated/a/pvc-1" {
+		t.Errorf("backing directory = %s, want /simulated/a/pvc-1", path)
+	}
+	if _, ok := memory.dirs[path]; !ok {
+		t.Errorf("backing directory %s not created in the memory backend", path)
+	}
+	if _, err := os.Stat(path); !os.IsNotExist(err) {
+		t.Errorf("backing directory %s touched the host: %v", path, err)
+	}
+	capacity := pv.Spec.Capacity[v1.ResourceStorage]
+	if capacity.Value() != 10*GiB {
+		t.Errorf("capacity = %s, want the 10Gi of the simulated pool", capacity.String())
+	}
+
+	// Filling the volume makes the other pool the one with the most space
+	if err := memory.write(path, 3*GiB); err != nil {
+		t.Fatal(err)
+	}
+	pv2, _, err := p.Provision(context.TODO(), simulatedClaim("pvc-2", nil))
+	if err != nil {
+		t.Fatalf("Provision() error = %v", err)
+	}
+	if pool := pv2.Annotations[annStoragePool]; pool != "b" {
+		t.Errorf("second volume placed in pool %s, want b", pool)
+	}
+
+	if _, _, err := p.Provision(context.TODO(), simulatedClaim("pvc-3", map[string]string{backingParameter: backingImage})); err == nil {
+		t.Error("Provision() of an image backed volume succeeded")
+	}
+
+	for _, pv := range []*v1.PersistentVolume{pv, pv2} {
+		if err := p.Delete(context.TODO(), pv); err != nil {
+			t.Fatalf("Delete() error = %v", err)
+		}
+	}
+	if len(memory.dirs) != 0 {
+		t.Errorf("directories left after Delete() = %v", memory.dirs)
+	}
+}
+
+func Test_simulatedVolume(t *testing.T) {
+	tests := []struct {
+		name    string
+		cloned  bool
+		striped bool
+		backing string
+		export  bool
+		wantErr bool
+	}{
+		{name: "directory", backing: backingDirectory},
+		{name: "clone", cloned: true, backing: backingDirectory, wantErr: true},
+		{name: "stripes", striped: true, backing: backingDirectory, wantErr: true},
+		{name: "image", backing: backingImage, wantErr: true},
+		{name: "tmpfs", backing: backingTmpfs, wantErr: true},
+		{name: "nfs", backing: backingDirectory, export: true, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := simulatedVolume(tt.cloned, tt.striped, tt.backing, "", "", tt.export)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("simulatedVolume() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
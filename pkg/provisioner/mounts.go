@@ -0,0 +1,215 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package provisioner
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"k8s.io/klog/v2"
+)
+
+const procMountsPath = "/proc/mounts"
+
+// mountInfo is an entry of /proc/mounts.
+type mountInfo struct {
+	device     string
+	mountPoint string
+	fsType     string
+	options    []string
+}
+
+// hasOption returns whether the filesystem is mounted with the option.
+func (m mountInfo) hasOption(option string) bool {
+	for _, o := range m.options {
+		if o == option {
+			return true
+		}
+	}
+	return false
+}
+
+// readMounts parses a file in the /proc/mounts format.
+func readMounts(path string) ([]mountInfo, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	var mounts []mountInfo
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) < 3 {
+			continue
+		}
+		mount := mountInfo{
+			device:     unescapeMountField(fields[0]),
+			mountPoint: unescapeMountField(fields[1]),
+			fsType:     fields[2],
+		}
+		if len(fields) > 3 {
+			mount.options = strings.Split(fields[3], ",")
+		}
+		mounts = append(mounts, mount)
+	}
+	return mounts, scanner.Err()
+}
+
+// unescapeMountField decodes the octal escapes (e.g. \040 for a space) the
+// kernel uses in /proc/mounts.
+func unescapeMountField(field string) string {
+	if !strings.Contains(field, `\`) {
+		return field
+	}
+	var b strings.Builder
+	for i := 0; i < len(field); i++ {
+		if field[i] == '\\' && i+3 < len(field) {
+			if value, err := strconv.ParseUint(field[i+1:i+4], 8, 8); err == nil {
+				b.WriteByte(byte(value))
+				i += 3
+				continue
+			}
+		}
+		b.WriteByte(field[i])
+	}
+	return b.String()
+}
+
+// findMount returns the mount containing path, i.e. the entry with the
+// longest mount point that is a parent of path. Later entries win over
+// earlier ones for the same mount point, as they are mounted on top.
+func findMount(mounts []mountInfo, path string) *mountInfo {
+	var found *mountInfo
+	for i := range mounts {
+		mountPoint := mounts[i].mountPoint
+		if path != mountPoint && !strings.HasPrefix(path, strings.TrimSuffix(mountPoint, "/")+"/") {
+			continue
+		}
+		if found == nil || len(mountPoint) >= len(found.mountPoint) {
+			found = &mounts[i]
+		}
+	}
+	return found
+}
+
+// parsePoolDevices assigns the expected devices described by spec, a comma
+// separated list of pool=device pairs, to the pools.
+func parsePoolDevices(pools []*storagePool, spec string) error {
+	if strings.TrimSpace(spec) == "" {
+		return nil
+	}
+	for _, entry := range strings.Split(spec, ",") {
+		parts := strings.SplitN(strings.TrimSpace(entry), "=", 2)
+		if len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+			return fmt.Errorf("invalid pool device %q, expected pool=device", entry)
+		}
+		pool := findPool(pools, parts[0])
+		if pool == nil {
+			return fmt.Errorf("device %s configured for unknown pool %q", parts[1], parts[0])
+		}
+		pool.device = parts[1]
+	}
+	return nil
+}
+
+func findPool(pools []*storagePool, name string) *storagePool {
+	for _, pool := range pools {
+		if pool.name == name {
+			return pool
+		}
+	}
+	return nil
+}
+
+// sameDevice compares two device paths, following symlinks such as the ones
+// in /dev/disk/by-uuid.
+func sameDevice(device, otherDevice string) bool {
+	if device == otherDevice {
+		return true
+	}
+	resolved, err := filepath.EvalSymlinks(device)
+	if err != nil {
+		return false
+	}
+	otherResolved, err := filepath.EvalSymlinks(otherDevice)
+	if err != nil {
+		return false
+	}
+	return resolved == otherResolved
+}
+
+// verifyPoolMount returns an error if the pool is not a mount point, or if it
+// is not a mount of the pool's expected device.
+func verifyPoolMount(pool *storagePool, mounts []mountInfo) error {
+	// Device pools are directories of devices, not filesystems
+	if pool.devices != nil {
+		return nil
+	}
+	path := filepath.Clean(pool.path)
+	mount := findMount(mounts, path)
+	if mount == nil || mount.mountPoint != path {
+		return fmt.Errorf("pool %s at %s is not a mount point", pool.name, pool.path)
+	}
+	if pool.device != "" && !sameDevice(pool.device, mount.device) {
+		return fmt.Errorf("pool %s at %s is a mount of %s, expected %s", pool.name, pool.path, mount.device, pool.device)
+	}
+	return nil
+}
+
+// checkMount returns whether the pool may be used. Outside of strict mode any
+// pool may be used.
+func (p *HostPathProvisioner) checkMount(pool *storagePool) bool {
+	if !p.strictMounts || p.simulated {
+		return true
+	}
+	mounts, err := readMounts(p.mountsPath)
+	if err != nil {
+		klog.Errorf("Unable to read mounts from %s: %v", p.mountsPath, err)
+		return false
+	}
+	if err := verifyPoolMount(pool, mounts); err != nil {
+		klog.Errorf("%v, not provisioning into it until it is mounted", err)
+		return false
+	}
+	return true
+}
+
+// warnUnmountedPools logs a warning for pools that are not dedicated mount
+// points, which usually means volumes end up on whatever disk holds the parent
+// directory.
+func (p *HostPathProvisioner) warnUnmountedPools() {
+	mounts, err := readMounts(p.mountsPath)
+	if err != nil {
+		klog.Warningf("Unable to read mounts from %s: %v", p.mountsPath, err)
+		return
+	}
+	for _, pool := range p.currentPools() {
+		if err := verifyPoolMount(pool, mounts); err != nil {
+			if p.strictMounts {
+				klog.Errorf("%v, not provisioning into it until it is mounted", err)
+			} else {
+				klog.Warningf("%v", err)
+			}
+		}
+	}
+}
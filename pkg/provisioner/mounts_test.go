@@ -0,0 +1,137 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package provisioner
+
+import (
+	"io/ioutil"
+	"os"
+	"reflect"
+	"testing"
+)
+
+const testMounts = `/dev/sda1 / ext4 rw,relatime 0 0
+proc /proc proc rw,nosuid,nodev,noexec,relatime 0 0
+/dev/sdb1 /var/hpvolumes xfs rw,relatime,prjquota 0 0
+/dev/sdc1 /var/hp\040volumes xfs rw,relatime 0 0
+`
+
+func writeTestMounts(t *testing.T) string {
+	file, err := ioutil.TempFile("", "mounts")
+	if err != nil {
+		t.Fatalf("Unable to create temporary file, error = %v", err)
+	}
+	defer file.Close()
+	if _, err := file.WriteString(testMounts); err != nil {
+		t.Fatalf("Unable to write temporary file, error = %v", err)
+	}
+	return file.Name()
+}
+
+func Test_readMounts(t *testing.T) {
+	path := writeTestMounts(t)
+	defer os.Remove(path)
+
+	mounts, err := readMounts(path)
+	if err != nil {
+		t.Fatalf("readMounts() error = %v", err)
+	}
+	want := []mountInfo{
+		{device: "/dev/sda1", mountPoint: "/", fsType: "ext4", options: []string{"rw", "relatime"}},
+		{device: "proc", mountPoint: "/proc", fsType: "proc", options: []string{"rw", "nosuid", "nodev", "noexec", "relatime"}},
+		{device: "/dev/sdb1", mountPoint: "/var/hpvolumes", fsType: "xfs", options: []string{"rw", "relatime", "prjquota"}},
+		{device: "/dev/sdc1", mountPoint: "/var/hp volumes", fsType: "xfs", options: []string{"rw", "relatime"}},
+	}
+	if !reflect.DeepEqual(mounts, want) {
+		t.Errorf("readMounts() = %v, want %v", mounts, want)
+	}
+	if !mounts[2].hasOption("prjquota") || mounts[3].hasOption("prjquota") {
+		t.Errorf("hasOption() does not find the options of the mount")
+	}
+}
+
+func Test_verifyPoolMount(t *testing.T) {
+	mounts := []mountInfo{
+		{device: "/dev/sda1", mountPoint: "/"},
+		{device: "/dev/sdb1", mountPoint: "/var/hpvolumes"},
+	}
+	tests := []struct {
+		name    string
+		pool    *storagePool
+		wantErr bool
+	}{
+		{
+			name: "pool is a mount point",
+			pool: &storagePool{name: "default", path: "/var/hpvolumes/"},
+		},
+		{
+			name: "pool is a mount of the expected device",
+			pool: &storagePool{name: "default", path: "/var/hpvolumes", device: "/dev/sdb1"},
+		},
+		{
+			name:    "pool is a mount of another device",
+			pool:    &storagePool{name: "default", path: "/var/hpvolumes", device: "/dev/sdc1"},
+			wantErr: true,
+		},
+		{
+			name:    "pool is a plain directory",
+			pool:    &storagePool{name: "default", path: "/var/hpvolumes/ssd"},
+			wantErr: true,
+		},
+		{
+			name:    "pool is a sibling of a mount point",
+			pool:    &storagePool{name: "default", path: "/var/hpvolumes2"},
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := verifyPoolMount(tt.pool, mounts); (err != nil) != tt.wantErr {
+				t.Errorf("verifyPoolMount() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func Test_parsePoolDevices(t *testing.T) {
+	pools := []*storagePool{{name: "ssd"}, {name: "hdd"}}
+	if err := parsePoolDevices(pools, "ssd=/dev/nvme0n1p1"); err != nil {
+		t.Fatalf("parsePoolDevices() error = %v", err)
+	}
+	if pools[0].device != "/dev/nvme0n1p1" || pools[1].device != "" {
+		t.Errorf("parsePoolDevices() devices = %s, %s", pools[0].device, pools[1].device)
+	}
+	if err := parsePoolDevices(pools, "nvme=/dev/nvme0n1p1"); err == nil {
+		t.Errorf("parsePoolDevices() did not return an error for an unknown pool")
+	}
+}
+
+func Test_checkMount(t *testing.T) {
+	path := writeTestMounts(t)
+	defer os.Remove(path)
+
+	p := &HostPathProvisioner{strictMounts: false, mountsPath: path}
+	if !p.checkMount(&storagePool{name: "default", path: "/var/hpvolumes/ssd"}) {
+		t.Errorf("checkMount() refused pool outside of strict mode")
+	}
+	p.strictMounts = true
+	if p.checkMount(&storagePool{name: "default", path: "/var/hpvolumes/ssd"}) {
+		t.Errorf("checkMount() allowed a pool that is not a mount point in strict mode")
+	}
+	if !p.checkMount(&storagePool{name: "default", path: "/var/hp volumes", device: "/dev/sdc1"}) {
+		t.Errorf("checkMount() refused a mounted pool in strict mode")
+	}
+}
@@ -0,0 +1,81 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package provisioner
+
+import (
+	"fmt"
+	"path"
+	"strings"
+)
+
+// namespaceFilter decides which namespaces volumes may be provisioned for.
+type namespaceFilter struct {
+	allowed []string
+	denied  []string
+}
+
+// parseNamespacePatterns splits a comma separated list of namespace patterns.
+func parseNamespacePatterns(list string) ([]string, error) {
+	var patterns []string
+	for _, pattern := range strings.Split(list, ",") {
+		pattern = strings.TrimSpace(pattern)
+		if pattern == "" {
+			continue
+		}
+		if _, err := path.Match(pattern, ""); err != nil {
+			return nil, fmt.Errorf("invalid namespace pattern %q: %v", pattern, err)
+		}
+		patterns = append(patterns, pattern)
+	}
+	return patterns, nil
+}
+
+func newNamespaceFilter(allowed, denied string) (*namespaceFilter, error) {
+	var err error
+	filter := &namespaceFilter{}
+	if filter.allowed, err = parseNamespacePatterns(allowed); err != nil {
+		return nil, err
+	}
+	if filter.denied, err = parseNamespacePatterns(denied); err != nil {
+		return nil, err
+	}
+	return filter, nil
+}
+
+func matchesNamespace(patterns []string, namespace string) bool {
+	for _, pattern := range patterns {
+		if matched, _ := path.Match(pattern, namespace); matched {
+			return true
+		}
+	}
+	return false
+}
+
+// check returns an error explaining why the namespace may not receive
+// volumes, or nil if it may.
+func (f *namespaceFilter) check(namespace string) error {
+	if f == nil {
+		return nil
+	}
+	if matchesNamespace(f.denied, namespace) {
+		return fmt.Errorf("namespace %q is not allowed to use hostpath volumes: it is on the provisioner's deny list", namespace)
+	}
+	if len(f.allowed) > 0 && !matchesNamespace(f.allowed, namespace) {
+		return fmt.Errorf("namespace %q is not allowed to use hostpath volumes: it is not on the provisioner's allow list", namespace)
+	}
+	return nil
+}
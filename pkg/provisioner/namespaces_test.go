@@ -0,0 +1,56 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package provisioner
+
+import (
+	"testing"
+)
+
+func Test_namespaceFilter(t *testing.T) {
+	tests := []struct {
+		name      string
+		allowed   string
+		denied    string
+		namespace string
+		wantErr   bool
+	}{
+		{name: "no lists", namespace: "anything", wantErr: false},
+		{name: "allowed", allowed: "team-a,team-b", namespace: "team-b", wantErr: false},
+		{name: "not allowed", allowed: "team-a,team-b", namespace: "team-c", wantErr: true},
+		{name: "allowed by pattern", allowed: "team-*", namespace: "team-c", wantErr: false},
+		{name: "denied", denied: "kube-system", namespace: "kube-system", wantErr: true},
+		{name: "not denied", denied: "kube-*", namespace: "default", wantErr: false},
+		{name: "deny wins over allow", allowed: "team-*", denied: "team-untrusted", namespace: "team-untrusted", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			filter, err := newNamespaceFilter(tt.allowed, tt.denied)
+			if err != nil {
+				t.Fatalf("newNamespaceFilter() error = %v", err)
+			}
+			if err := filter.check(tt.namespace); (err != nil) != tt.wantErr {
+				t.Errorf("check() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func Test_newNamespaceFilterInvalidPattern(t *testing.T) {
+	if _, err := newNamespaceFilter("team-[", ""); err == nil {
+		t.Error("newNamespaceFilter() expected error for invalid pattern")
+	}
+}
@@ -0,0 +1,164 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package provisioner
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"text/template"
+
+	"k8s.io/klog/v2"
+	"sigs.k8s.io/sig-storage-lib-external-provisioner/v13/controller"
+)
+
+const (
+	// namingPV names backing directories after the PV
+	namingPV = "pv"
+	// namingClaim prefixes the PV name with the claim name
+	namingClaim = "claim"
+	// namingNamespace names backing directories <namespace>_<claim>. Neither
+	// namespaces nor claim names can contain underscores, so the name is
+	// unambiguous
+	namingNamespace = "namespace"
+
+	// maxCollisionSuffix limits the suffixes tried when the name of a
+	// backing directory is taken
+	maxCollisionSuffix = 100
+)
+
+// validateNamingMode checks that mode is one of the naming modes, empty means
+// the mode follows useNamingPrefix.
+func validateNamingMode(mode string) error {
+	switch mode {
+	case "", namingPV, namingClaim, namingNamespace:
+		return nil
+	}
+	return fmt.Errorf("unknown naming mode %q, expected %s, %s or %s", mode, namingPV, namingClaim, namingNamespace)
+}
+
+// volumeNameFields are the fields a naming template can refer to, e.g.
+// {{.PVCNamespace}}-{{.PVCName}}-{{.PVName}}.
+type volumeNameFields struct {
+	PVName       string
+	PVCName      string
+	PVCNamespace string
+	StorageClass string
+	Pool         string
+	NodeName     string
+}
+
+// parseNamingTemplate parses the template backing directories are named
+// with. It is tried on sample fields so that references to unknown fields are
+// rejected up front rather than when a claim is provisioned.
+func parseNamingTemplate(text string) (*template.Template, error) {
+	tmpl, err := template.New("naming").Option("missingkey=error").Parse(text)
+	if err != nil {
+		return nil, fmt.Errorf("invalid naming template: %v", err)
+	}
+	sample := volumeNameFields{PVName: "pvc-1", PVCName: "data", PVCNamespace: "default", StorageClass: "hostpath", Pool: defaultPoolName, NodeName: "node01"}
+	if _, err := renderDirName(tmpl, sample); err != nil {
+		return nil, fmt.Errorf("invalid naming template: %v", err)
+	}
+	return tmpl, nil
+}
+
+// renderDirName executes the naming template and checks that the result is
+// usable as the name of a directory directly inside a pool.
+func renderDirName(tmpl *template.Template, fields volumeNameFields) (string, error) {
+	var buf bytes.Buffer
+	if err := tmpl.Execute(&buf, fields); err != nil {
+		return "", err
+	}
+	name := strings.TrimSpace(buf.String())
+	switch {
+	case name == "" || name == "." || name == "..":
+		return "", fmt.Errorf("%q is not a valid directory name", name)
+	case strings.ContainsAny(name, "/\x00"):
+		return "", fmt.Errorf("directory name %q must not contain slashes", name)
+	case len(name) > 255:
+		return "", fmt.Errorf("directory name %q is longer than 255 characters", name)
+	}
+	return name, nil
+}
+
+// volumeDirName returns the name of the backing directory of a claim, from
+// the naming template when one is configured.
+func (p *HostPathProvisioner) volumeDirName(options controller.ProvisionOptions, pool *storagePool) (string, error) {
+	tmpl := p.currentNamingTemplate()
+	if tmpl == nil {
+		switch p.currentNamingMode() {
+		case namingClaim:
+			return options.PVC.Name + "-" + options.PVName, nil
+		case namingNamespace:
+			return options.PVC.Namespace + "_" + options.PVC.Name, nil
+		}
+		return options.PVName, nil
+	}
+	fields := volumeNameFields{
+		PVName:       options.PVName,
+		PVCName:      options.PVC.Name,
+		PVCNamespace: options.PVC.Namespace,
+		Pool:         pool.name,
+		NodeName:     p.nodeName,
+	}
+	if options.StorageClass != nil {
+		fields.StorageClass = options.StorageClass.Name
+	}
+	name, err := renderDirName(tmpl, fields)
+	if err != nil {
+		return "", fmt.Errorf("unable to name the backing directory of claim %s/%s: %v", options.PVC.Namespace, options.PVC.Name, err)
+	}
+	return name, nil
+}
+
+// collides returns whether the backing directory at path is in use by another
+// volume. A directory left behind by an earlier attempt to provision the same
+// PV does not collide, it is reused.
+func collides(path, pvName string) (bool, error) {
+	if _, err := os.Lstat(path); os.IsNotExist(err) {
+		return false, nil
+	} else if err != nil {
+		return false, err
+	}
+	id, err := readVolumeIdentity(path)
+	return err != nil || id.PV != pvName, nil
+}
+
+// volumeDir returns the path of the backing directory named name in pool.
+// When the name is taken by another volume a numeric suffix is added, e.g.
+// team-a_data-2, so that volumes never share a directory.
+func volumeDir(pool *storagePool, name, pvName string) (string, error) {
+	for i := 1; i <= maxCollisionSuffix; i++ {
+		candidate := name
+		if i > 1 {
+			candidate = fmt.Sprintf("%s-%d", name, i)
+		}
+		path := filepath.Join(pool.path, candidate)
+		taken, err := collides(path, pvName)
+		if err != nil {
+			return "", err
+		}
+		if !taken {
+			return path, nil
+		}
+		klog.V(2).InfoS("Backing directory name taken", "pv", pvName, "pool", pool.name, "path", path)
+	}
+	return "", fmt.Errorf("backing directory %s and its first %d suffixed names are taken by other volumes in pool %s", name, maxCollisionSuffix, pool.name)
+}
@@ -0,0 +1,143 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package provisioner
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"text/template"
+	"time"
+
+	"sigs.k8s.io/sig-storage-lib-external-provisioner/v13/controller"
+
+	v1 "k8s.io/api/core/v1"
+	storage "k8s.io/api/storage/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func Test_volumeDirName(t *testing.T) {
+	options := controller.ProvisionOptions{
+		PVName:       "pvc-1234",
+		PVC:          &v1.PersistentVolumeClaim{ObjectMeta: metav1.ObjectMeta{Namespace: "team-a", Name: "data"}},
+		StorageClass: &storage.StorageClass{ObjectMeta: metav1.ObjectMeta{Name: "hostpath"}},
+	}
+	pool := &storagePool{name: "ssd", path: "/var/hpvolumes/ssd"}
+	tests := []struct {
+		name     string
+		template string
+		prefix   bool
+		mode     string
+		want     string
+		wantErr  string
+	}{
+		{name: "pv name", want: "pvc-1234"},
+		{name: "naming prefix", prefix: true, want: "data-pvc-1234"},
+		{name: "claim mode", mode: namingClaim, want: "data-pvc-1234"},
+		{name: "namespace mode", mode: namingNamespace, prefix: true, want: "team-a_data"},
+		{name: "pv mode overrides prefix", mode: namingPV, prefix: true, want: "pvc-1234"},
+		{name: "template", template: "{{.PVCNamespace}}-{{.PVCName}}-{{.PVName}}", prefix: true, want: "team-a-data-pvc-1234"},
+		{name: "all fields", template: "{{.NodeName}}_{{.Pool}}_{{.StorageClass}}_{{.PVName}}", want: "node01_ssd_hostpath_pvc-1234"},
+		{name: "functions", template: "{{printf \"%s.%s\" .PVCNamespace .PVCName}}", want: "team-a.data"},
+		{name: "slash", template: "{{.PVCNamespace}}/{{.PVCName}}", wantErr: "must not contain slashes"},
+		{name: "empty", template: "{{if false}}x{{end}}", wantErr: "not a valid directory name"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := &HostPathProvisioner{nodeName: "node01", useNamingPrefix: tt.prefix, namingMode: tt.mode}
+			if tt.template != "" {
+				p.namingTemplate = template.Must(template.New("naming").Parse(tt.template))
+			}
+			got, err := p.volumeDirName(options, pool)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Errorf("volumeDirName() error = %v, want %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("volumeDirName() error = %v", err)
+			}
+			if got != tt.want {
+				t.Errorf("volumeDirName() = %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_parseNamingTemplate(t *testing.T) {
+	tests := []struct {
+		name     string
+		template string
+		wantErr  bool
+	}{
+		{name: "valid", template: "{{.PVCNamespace}}-{{.PVCName}}"},
+		{name: "syntax error", template: "{{.PVCName", wantErr: true},
+		{name: "unknown field", template: "{{.Namespace}}-{{.PVCName}}", wantErr: true},
+		{name: "slash", template: "{{.PVCNamespace}}/{{.PVCName}}", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := parseNamingTemplate(tt.template); (err != nil) != tt.wantErr {
+				t.Errorf("parseNamingTemplate() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func Test_volumeDir(t *testing.T) {
+	dir, err := ioutil.TempDir("", "naming")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	pool := &storagePool{name: "ssd", path: dir}
+	pvc := &v1.PersistentVolumeClaim{ObjectMeta: metav1.ObjectMeta{Namespace: "team-a", Name: "data"}}
+	create := func(name, pvName string) {
+		path := filepath.Join(dir, name)
+		if err := os.Mkdir(path, 0777); err != nil {
+			t.Fatal(err)
+		}
+		if pvName != "" {
+			if err := writeIdentityFile(path, newVolumeIdentity(pvc, pvName, time.Now())); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	check := func(pvName, want string) {
+		t.Helper()
+		got, err := volumeDir(pool, "team-a_data", pvName)
+		if err != nil {
+			t.Fatalf("volumeDir() error = %v", err)
+		}
+		if got != filepath.Join(dir, want) {
+			t.Errorf("volumeDir(%s) = %s, want %s", pvName, got, want)
+		}
+	}
+
+	check("pvc-1", "team-a_data")
+	create("team-a_data", "pvc-1")
+	// An earlier attempt for the same PV is reused, other volumes get a suffix
+	check("pvc-1", "team-a_data")
+	check("pvc-2", "team-a_data-2")
+	create("team-a_data-2", "")
+	check("pvc-2", "team-a_data-3")
+	create("team-a_data-3", "pvc-2")
+	check("pvc-2", "team-a_data-3")
+}
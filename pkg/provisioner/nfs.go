@@ -0,0 +1,201 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package provisioner
+
+import (
+	"bufio"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+
+	v1 "k8s.io/api/core/v1"
+
+	"sigs.k8s.io/sig-storage-lib-external-provisioner/v13/controller"
+)
+
+const (
+	// nfsExportParameter lets a storage class export the backing
+	// directories of ReadWriteMany claims over NFS.
+	nfsExportParameter = "nfsExport"
+
+	// annNFSExport marks volumes whose backing directory is exported over
+	// NFS by the node.
+	annNFSExport = "kubevirt.io/nfsExport"
+)
+
+// NFSConfig configures the NFS exports of backing directories, it is the nfs
+// section of the config file.
+type NFSConfig struct {
+	// --nfs-server, the address pods reach the NFS server of the node at,
+	// backing directories are not exported when empty
+	Server string `json:"server,omitempty"`
+	// --nfs-exports-file, the exports file kept by the provisioner
+	ExportsFile string `json:"exportsFile,omitempty"`
+	// --nfs-export-options, the options of the exports
+	ExportOptions string `json:"exportOptions,omitempty"`
+	// --exportfs, the binary the NFS server is told about changes with
+	Exportfs string `json:"exportfs,omitempty"`
+}
+
+// nfsExportFor returns whether the backing directory of the claim is
+// exported over NFS: the class asks for it and the claim is ReadWriteMany.
+func nfsExportFor(options controller.ProvisionOptions, server string) (bool, error) {
+	if options.StorageClass == nil {
+		return false, nil
+	}
+	value, ok := options.StorageClass.Parameters[nfsExportParameter]
+	if !ok {
+		return false, nil
+	}
+	export, err := strconv.ParseBool(value)
+	if err != nil {
+		return false, fmt.Errorf("invalid %s %q in storage class %s, expected true or false", nfsExportParameter, value, options.StorageClass.Name)
+	}
+	if !export || !hasAccessMode(options.PVC, v1.ReadWriteMany) {
+		return false, nil
+	}
+	if server == "" {
+		return false, fmt.Errorf("storage class %s exports ReadWriteMany claims over NFS, but no NFS server is set on the node", options.StorageClass.Name)
+	}
+	return true, nil
+}
+
+// hasAccessMode returns whether the claim asks for the access mode.
+func hasAccessMode(pvc *v1.PersistentVolumeClaim, mode v1.PersistentVolumeAccessMode) bool {
+	for _, m := range pvc.Spec.AccessModes {
+		if m == mode {
+			return true
+		}
+	}
+	return false
+}
+
+// nfsExportTable keeps the exports file of the node, one export per backing
+// directory, and has the NFS server reread it after every change.
+type nfsExportTable struct {
+	mutex sync.Mutex
+	// file is the exports file, lines are exported with options and
+	// exportfs rereads them
+	file     string
+	options  string
+	exportfs string
+}
+
+// configure sets the exports file, options and exportfs binary of the table.
+func (t *nfsExportTable) configure(config NFSConfig) {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	t.file, t.options, t.exportfs = config.ExportsFile, config.ExportOptions, config.Exportfs
+}
+
+// exportLine returns the line exporting path in the exports file. The fsid,
+// the uid of the claim, identifies the export to clients, also for backing
+// directories on filesystems without a device, e.g. tmpfs.
+func exportLine(path, fsid, options string) string {
+	return fmt.Sprintf("\"%s\" *(%s,fsid=%s)", path, options, fsid)
+}
+
+// exportPath returns the path exported by a line of the exports file.
+func exportPath(line string) string {
+	if strings.HasPrefix(line, "\"") {
+		if end := strings.Index(line[1:], "\""); end != -1 {
+			return line[1 : end+1]
+		}
+	}
+	if fields := strings.Fields(line); len(fields) > 0 {
+		return fields[0]
+	}
+	return ""
+}
+
+// add exports path, replacing an earlier export of it.
+func (t *nfsExportTable) add(path, fsid string) error {
+	t.mutex.Lock()
+	options := t.options
+	t.mutex.Unlock()
+	return t.update(path, exportLine(path, fsid, options))
+}
+
+// remove stops exporting path.
+func (t *nfsExportTable) remove(path string) error {
+	return t.update(path, "")
+}
+
+// update replaces the export of path in the exports file with line, or
+// removes it when line is empty, and reexports everything.
+func (t *nfsExportTable) update(path, line string) error {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	lines, err := readExports(t.file)
+	if err != nil {
+		return err
+	}
+	var result []string
+	for _, l := range lines {
+		if exportPath(l) != path {
+			result = append(result, l)
+		}
+	}
+	if line != "" {
+		result = append(result, line)
+	}
+	if err := writeExports(t.file, result); err != nil {
+		return err
+	}
+	_, err = runCommand(t.exportfs, "-ra")
+	return err
+}
+
+// readExports returns the exports of the file, nothing if it does not exist.
+func readExports(file string) ([]string, error) {
+	f, err := os.Open(file)
+	if os.IsNotExist(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	var lines []string
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		if line := strings.TrimSpace(scanner.Text()); line != "" && !strings.HasPrefix(line, "#") {
+			lines = append(lines, line)
+		}
+	}
+	return lines, scanner.Err()
+}
+
+// writeExports replaces the exports file, so the NFS server never reads a
+// partially written one.
+func writeExports(file string, lines []string) error {
+	if err := os.MkdirAll(filepath.Dir(file), 0755); err != nil {
+		return err
+	}
+	content := "# Managed by the hostpath provisioner, do not edit\n"
+	for _, line := range lines {
+		content += line + "\n"
+	}
+	tmp := file + ".tmp"
+	if err := ioutil.WriteFile(tmp, []byte(content), 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, file)
+}
@@ -0,0 +1,140 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package provisioner
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	v1 "k8s.io/api/core/v1"
+	storagev1 "k8s.io/api/storage/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/sig-storage-lib-external-provisioner/v13/controller"
+)
+
+func Test_nfsExportFor(t *testing.T) {
+	class := func(export string) *storagev1.StorageClass {
+		return &storagev1.StorageClass{ObjectMeta: metav1.ObjectMeta{Name: "hostpath"}, Parameters: map[string]string{nfsExportParameter: export}}
+	}
+	claim := func(modes ...v1.PersistentVolumeAccessMode) *v1.PersistentVolumeClaim {
+		return &v1.PersistentVolumeClaim{Spec: v1.PersistentVolumeClaimSpec{AccessModes: modes}}
+	}
+	tests := []struct {
+		name    string
+		class   *storagev1.StorageClass
+		claim   *v1.PersistentVolumeClaim
+		server  string
+		want    bool
+		wantErr bool
+	}{
+		{name: "no class", claim: claim(v1.ReadWriteMany), server: "10.0.0.1"},
+		{name: "not set", class: &storagev1.StorageClass{}, claim: claim(v1.ReadWriteMany), server: "10.0.0.1"},
+		{name: "read write many", class: class("true"), claim: claim(v1.ReadWriteOnce, v1.ReadWriteMany), server: "10.0.0.1", want: true},
+		{name: "read write once", class: class("true"), claim: claim(v1.ReadWriteOnce), server: "10.0.0.1"},
+		{name: "disabled", class: class("false"), claim: claim(v1.ReadWriteMany), server: "10.0.0.1"},
+		{name: "no server", class: class("true"), claim: claim(v1.ReadWriteMany), wantErr: true},
+		{name: "invalid", class: class("nfs4"), claim: claim(v1.ReadWriteMany), server: "10.0.0.1", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := nfsExportFor(controller.ProvisionOptions{StorageClass: tt.class, PVC: tt.claim}, tt.server)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("nfsExportFor() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("nfsExportFor() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_exportPath(t *testing.T) {
+	tests := []struct {
+		line string
+		want string
+	}{
+		{line: exportLine("/var/hpvolumes/pvc-1", "uid", "rw"), want: "/var/hpvolumes/pvc-1"},
+		{line: exportLine("/var/hpvolumes/my data", "uid", "rw"), want: "/var/hpvolumes/my data"},
+		{line: "/srv/share 10.0.0.0/8(ro)", want: "/srv/share"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.want, func(t *testing.T) {
+			if got := exportPath(tt.line); got != tt.want {
+				t.Errorf("exportPath(%q) = %q, want %q", tt.line, got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_nfsExportTable(t *testing.T) {
+	dir, err := ioutil.TempDir("", "nfs")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	commands, restore := fakeCommands(map[string]string{"exportfs -ra": ""})
+	defer restore()
+
+	table := &nfsExportTable{}
+	table.configure(NFSConfig{ExportsFile: filepath.Join(dir, "exports.d", "hostpath-provisioner.exports"), ExportOptions: "rw,sync", Exportfs: "exportfs"})
+	if err := table.add("/pool/pvc-1", "uid-1"); err != nil {
+		t.Fatalf("add() failed: %v", err)
+	}
+	if err := table.add("/pool/pvc-2", "uid-2"); err != nil {
+		t.Fatalf("add() failed: %v", err)
+	}
+	if err := table.add("/pool/pvc-1", "uid-1"); err != nil {
+		t.Fatalf("add() again failed: %v", err)
+	}
+	if err := table.remove("/pool/pvc-2"); err != nil {
+		t.Fatalf("remove() failed: %v", err)
+	}
+	got, err := readExports(table.file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{`"/pool/pvc-1" *(rw,sync,fsid=uid-1)`}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("exports = %v, want %v", got, want)
+	}
+	if len(*commands) != 4 {
+		t.Errorf("exportfs ran %d times, want 4", len(*commands))
+	}
+}
+
+func Test_backingPath(t *testing.T) {
+	tests := []struct {
+		name string
+		pv   *v1.PersistentVolume
+		want string
+	}{
+		{name: "host path", pv: &v1.PersistentVolume{Spec: v1.PersistentVolumeSpec{PersistentVolumeSource: v1.PersistentVolumeSource{HostPath: &v1.HostPathVolumeSource{Path: "/pool/pvc-1"}}}}, want: "/pool/pvc-1"},
+		{name: "exported", pv: &v1.PersistentVolume{ObjectMeta: metav1.ObjectMeta{Annotations: map[string]string{annNFSExport: "10.0.0.1"}}, Spec: v1.PersistentVolumeSpec{PersistentVolumeSource: v1.PersistentVolumeSource{NFS: &v1.NFSVolumeSource{Server: "10.0.0.1", Path: "/pool/pvc-1"}}}}, want: "/pool/pvc-1"},
+		{name: "other nfs", pv: &v1.PersistentVolume{Spec: v1.PersistentVolumeSpec{PersistentVolumeSource: v1.PersistentVolumeSource{NFS: &v1.NFSVolumeSource{Server: "nas", Path: "/share"}}}}},
+		{name: "device", pv: &v1.PersistentVolume{Spec: v1.PersistentVolumeSpec{PersistentVolumeSource: v1.PersistentVolumeSource{Local: &v1.LocalVolumeSource{Path: "/dev/sdb"}}}}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := backingPath(tt.pv); got != tt.want {
+				t.Errorf("backingPath() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,72 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package provisioner
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+
+	"k8s.io/klog/v2"
+
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// nodeDisabledKey is the node label, or taint key, that takes a node out of
+// provisioning. Existing volumes on the node keep being served and deleted.
+const nodeDisabledKey = "hostpath.kubevirt.io/disabled"
+
+// nodeDisabled returns whether the node has been opted out of provisioning,
+// and why.
+func nodeDisabled(node *v1.Node) (bool, string) {
+	if value, ok := node.Labels[nodeDisabledKey]; ok {
+		if disabled, err := strconv.ParseBool(value); err == nil && disabled {
+			return true, fmt.Sprintf("label %s=%s", nodeDisabledKey, value)
+		}
+	}
+	for _, taint := range node.Spec.Taints {
+		if taint.Key == nodeDisabledKey {
+			return true, fmt.Sprintf("taint %s", taint.ToString())
+		}
+	}
+	return false, ""
+}
+
+// provisioningDisabled checks whether this provisioner's node has been opted
+// out of provisioning new volumes, and why. If the node can't be read
+// provisioning continues, so that an API server hiccup does not stop all
+// provisioning.
+func (p *HostPathProvisioner) provisioningDisabled() (bool, string) {
+	if maintenance, reason := p.overrides.maintenance(); maintenance {
+		klog.Infof("provisioning on node %s is disabled by %s", p.nodeName, reason)
+		return true, reason
+	}
+	if p.client == nil {
+		return false, ""
+	}
+	node, err := p.client.CoreV1().Nodes().Get(context.TODO(), p.nodeName, metav1.GetOptions{})
+	if err != nil {
+		klog.Warningf("unable to get node %s to check whether provisioning is disabled: %v", p.nodeName, err)
+		return false, ""
+	}
+	disabled, reason := nodeDisabled(node)
+	if disabled {
+		klog.Infof("provisioning on node %s is disabled by %s", p.nodeName, reason)
+	}
+	return disabled, reason
+}
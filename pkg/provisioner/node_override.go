@@ -0,0 +1,184 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package provisioner
+
+import (
+	"context"
+	"encoding/json"
+	"reflect"
+	"sync"
+	"time"
+
+	"k8s.io/klog/v2"
+
+	apierrs "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/client-go/rest"
+)
+
+const nodeOverrideResource = "hostpathnodeoverrides"
+
+// hostPathNodeOverride is the HostPathNodeOverride object admins, or an
+// operator, set to change how the provisioner of a node behaves for a while,
+// without restarting it. It is cluster scoped and named after the node.
+type hostPathNodeOverride struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+	Spec              nodeOverrideSpec `json:"spec"`
+}
+
+type nodeOverrideSpec struct {
+	// Maintenance stops new volumes from being provisioned on the node,
+	// like the hostpath.kubevirt.io/disabled label
+	Maintenance bool `json:"maintenance,omitempty"`
+	// Reason is shown in the events of claims that are not provisioned
+	Reason string `json:"reason,omitempty"`
+	// DrainPools are the pools no new volumes are placed in, existing
+	// volumes stay where they are
+	DrainPools []string `json:"drainPools,omitempty"`
+	// Reserve is the space, per pool, that is kept free of new volumes
+	Reserve map[string]resource.Quantity `json:"reserve,omitempty"`
+}
+
+// nodeOverrides keeps the spec of the HostPathNodeOverride object of the
+// node. A nil nodeOverrides overrides nothing.
+type nodeOverrides struct {
+	client   rest.Interface
+	nodeName string
+
+	mutex sync.RWMutex
+	spec  nodeOverrideSpec
+}
+
+func newNodeOverrides(client rest.Interface, nodeName string) *nodeOverrides {
+	return &nodeOverrides{client: client, nodeName: nodeName}
+}
+
+// Run reads the object every interval until stopCh is closed.
+func (o *nodeOverrides) Run(interval time.Duration, stopCh <-chan struct{}) {
+	wait.Until(func() {
+		if err := o.sync(); err != nil {
+			klog.Errorf("unable to read the node override of node %s: %v", o.nodeName, err)
+		}
+	}, interval, stopCh)
+}
+
+func (o *nodeOverrides) path() string {
+	return "/apis/" + nodeStatusGroup + "/" + nodeStatusVersion + "/" + nodeOverrideResource
+}
+
+// sync reads the object, a missing one overrides nothing. When it can not be
+// read the last spec read stays in effect.
+func (o *nodeOverrides) sync() error {
+	override := &hostPathNodeOverride{}
+	raw, err := o.client.Get().AbsPath(o.path(), o.nodeName).Do(context.TODO()).Raw()
+	if apierrs.IsNotFound(err) {
+		o.set(nodeOverrideSpec{})
+		return nil
+	} else if err != nil {
+		return err
+	}
+	if err := json.Unmarshal(raw, override); err != nil {
+		return err
+	}
+	o.set(override.Spec)
+	return nil
+}
+
+func (o *nodeOverrides) set(spec nodeOverrideSpec) {
+	o.mutex.Lock()
+	defer o.mutex.Unlock()
+	if reflect.DeepEqual(spec, o.spec) {
+		return
+	}
+	klog.InfoS("Node override changed", "node", o.nodeName, "maintenance", spec.Maintenance, "reason", spec.Reason, "drainPools", spec.DrainPools, "reserve", spec.Reserve)
+	o.spec = spec
+}
+
+// maintenance returns whether the node is in maintenance mode, and why.
+func (o *nodeOverrides) maintenance() (bool, string) {
+	if o == nil {
+		return false, ""
+	}
+	o.mutex.RLock()
+	defer o.mutex.RUnlock()
+	if !o.spec.Maintenance {
+		return false, ""
+	}
+	reason := "node override maintenance mode"
+	if o.spec.Reason != "" {
+		reason += ": " + o.spec.Reason
+	}
+	return true, reason
+}
+
+// draining returns whether the pool is being drained of new volumes.
+func (o *nodeOverrides) draining(pool string) bool {
+	if o == nil {
+		return false
+	}
+	o.mutex.RLock()
+	defer o.mutex.RUnlock()
+	for _, name := range o.spec.DrainPools {
+		if name == pool {
+			return true
+		}
+	}
+	return false
+}
+
+// reserve returns the space kept free in the pool.
+func (o *nodeOverrides) reserve(pool string) resource.Quantity {
+	if o == nil {
+		return resource.Quantity{}
+	}
+	o.mutex.RLock()
+	defer o.mutex.RUnlock()
+	return o.spec.Reserve[pool]
+}
+
+// fitsWithReserve returns whether a volume of the requested size fits in the
+// space free in the pool once the reserve is set aside. Nothing is reserved
+// in device pools, devices are handed out whole.
+func fitsWithReserve(host backend, pool *storagePool, requested, reserve resource.Quantity) bool {
+	var free int64
+	switch {
+	case pool.devices != nil:
+		return true
+	case pool.lvm != nil && pool.lvm.thinPool != "":
+		_, thinFree, err := pool.lvm.usage()
+		if err != nil {
+			return false
+		}
+		free = thinFree
+	case pool.lvm != nil:
+		groupFree, err := pool.lvm.thickCapacity()
+		if err != nil {
+			return false
+		}
+		free = groupFree
+	default:
+		stats, err := host.Stat(pool.path)
+		if err != nil {
+			return false
+		}
+		free = stats.available
+	}
+	return free-reserve.Value() >= requested.Value()
+}
@@ -0,0 +1,73 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package provisioner
+
+import (
+	"reflect"
+	"testing"
+
+	"k8s.io/apimachinery/pkg/api/resource"
+)
+
+func Test_nodeOverrides(t *testing.T) {
+	memory := newMemoryBackend(10 * GiB)
+	requested := resource.MustParse("5Gi")
+
+	tests := []struct {
+		name            string
+		spec            nodeOverrideSpec
+		wantMaintenance string
+		wantPools       []string
+	}{
+		{name: "none", wantPools: []string{"a", "b"}},
+		{name: "maintenance", spec: nodeOverrideSpec{Maintenance: true}, wantMaintenance: "node override maintenance mode", wantPools: []string{"a", "b"}},
+		{name: "maintenance with reason", spec: nodeOverrideSpec{Maintenance: true, Reason: "disk swap"}, wantMaintenance: "node override maintenance mode: disk swap", wantPools: []string{"a", "b"}},
+		{name: "drain", spec: nodeOverrideSpec{DrainPools: []string{"a"}}, wantPools: []string{"b"}},
+		{name: "reserve", spec: nodeOverrideSpec{Reserve: map[string]resource.Quantity{"b": resource.MustParse("6Gi")}}, wantPools: []string{"a"}},
+		{name: "reserve leaving room", spec: nodeOverrideSpec{Reserve: map[string]resource.Quantity{"b": resource.MustParse("5Gi")}}, wantPools: []string{"a", "b"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := simulatedProvisioner(memory, &storagePool{name: "a", path: "/simulated/a"}, &storagePool{name: "b", path: "/simulated/b"})
+			p.overrides = newNodeOverrides(nil, p.nodeName)
+			p.overrides.set(tt.spec)
+
+			disabled, reason := p.provisioningDisabled()
+			if disabled != (tt.wantMaintenance != "") || reason != tt.wantMaintenance {
+				t.Errorf("provisioningDisabled() = %v, %q, want %q", disabled, reason, tt.wantMaintenance)
+			}
+			candidates, err := p.candidatePools(requested, p.currentRounding(), "")
+			if err != nil {
+				t.Fatal(err)
+			}
+			var pools []string
+			for _, candidate := range candidates {
+				pools = append(pools, candidate.pool.name)
+			}
+			if !reflect.DeepEqual(pools, tt.wantPools) {
+				t.Errorf("candidatePools() = %v, want %v", pools, tt.wantPools)
+			}
+		})
+	}
+
+	// Without overrides nothing is overridden
+	var none *nodeOverrides
+	reserve := none.reserve("a")
+	if disabled, _ := none.maintenance(); disabled || none.draining("a") || !reserve.IsZero() {
+		t.Error("nil node overrides override the node")
+	}
+}
@@ -0,0 +1,206 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package provisioner
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"k8s.io/klog/v2"
+
+	apierrs "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/client-go/rest"
+)
+
+const (
+	nodeStatusGroup    = "hostpathprovisioner.kubevirt.io"
+	nodeStatusVersion  = "v1alpha1"
+	nodeStatusKind     = "HostPathNodeStatus"
+	nodeStatusResource = "hostpathnodestatuses"
+)
+
+// hostPathNodeStatus is the HostPathNodeStatus object each provisioner keeps
+// up to date for its node. It is cluster scoped and named after the node.
+type hostPathNodeStatus struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+	Status            nodeStatus `json:"status"`
+}
+
+type nodeStatus struct {
+	Version        string       `json:"version"`
+	Leader         string       `json:"leader,omitempty"`
+	Pools          []poolStatus `json:"pools"`
+	VolumeCount    int          `json:"volumeCount"`
+	LastError      *lastError   `json:"lastError,omitempty"`
+	LastUpdateTime metav1.Time  `json:"lastUpdateTime"`
+}
+
+type poolStatus struct {
+	Name          string   `json:"name"`
+	Path          string   `json:"path"`
+	DeviceClass   string   `json:"deviceClass,omitempty"`
+	CapacityBytes int64    `json:"capacityBytes"`
+	FreeBytes     int64    `json:"freeBytes"`
+	UsedBytes     int64    `json:"usedBytes"`
+	Capabilities  []string `json:"capabilities,omitempty"`
+	Redundancy    string   `json:"redundancy,omitempty"`
+	Error         string   `json:"error,omitempty"`
+}
+
+type lastError struct {
+	Message string      `json:"message"`
+	Time    metav1.Time `json:"time"`
+}
+
+// nodeStatusPublisher maintains the HostPathNodeStatus object of the node, so
+// that the state of all provisioners can be seen with kubectl get
+// hostpathnodestatuses.
+type nodeStatusPublisher struct {
+	p          *HostPathProvisioner
+	leadership leadership
+	client     rest.Interface
+}
+
+// leadership tells which of the provisioner's replicas on the node is
+// processing claims and volumes.
+type leadership interface {
+	Identity() string
+	Leader() string
+}
+
+func newNodeStatusPublisher(p *HostPathProvisioner, leadership leadership) *nodeStatusPublisher {
+	return &nodeStatusPublisher{p: p, leadership: leadership, client: p.client.Discovery().RESTClient()}
+}
+
+// Run publishes the status every interval until stopCh is closed.
+func (n *nodeStatusPublisher) Run(interval time.Duration, stopCh <-chan struct{}) {
+	wait.Until(func() {
+		// Replicas on standby have nothing to report, the leader's
+		// operations are the ones that matter
+		if leader := n.leadership.Leader(); leader != "" && leader != n.leadership.Identity() {
+			klog.V(3).InfoS("Not publishing node status, another replica is the leader", "node", n.p.nodeName, "leader", leader)
+			return
+		}
+		status, err := n.status(time.Now())
+		if err != nil {
+			klog.Errorf("unable to determine the status of node %s: %v", n.p.nodeName, err)
+			return
+		}
+		if err := n.publish(status); err != nil {
+			klog.Errorf("unable to publish the status of node %s: %v", n.p.nodeName, err)
+		}
+	}, interval, stopCh)
+}
+
+// status collects the current status of the provisioner on this node.
+func (n *nodeStatusPublisher) status(now time.Time) (nodeStatus, error) {
+	pvs, err := n.p.pvCache.list(context.TODO(), n.p.client)
+	if err != nil {
+		return nodeStatus{}, err
+	}
+	status := nodeStatus{
+		Version:        version,
+		Leader:         n.leadership.Leader(),
+		Pools:          []poolStatus{},
+		LastError:      newLastError(n.p.operations),
+		LastUpdateTime: metav1.NewTime(now),
+	}
+	for _, count := range countPoolVolumes(pvs.Items, n.p.identity, n.p.nodeName) {
+		status.VolumeCount += count
+	}
+	for _, pool := range n.p.currentPools() {
+		status.Pools = append(status.Pools, newPoolStatus(n.p.host, pool, n.p.capabilities.supported(pool), poolRedundancy(pool, n.p.mountsPath)))
+	}
+	return status, nil
+}
+
+func newPoolStatus(host backend, pool *storagePool, capabilities []string, redundancy string) poolStatus {
+	info := newPoolInfo(host, pool, 0)
+	return poolStatus{
+		Name:          info.Name,
+		Path:          info.Path,
+		DeviceClass:   info.DeviceClass,
+		CapacityBytes: info.CapacityBytes,
+		FreeBytes:     info.FreeBytes,
+		UsedBytes:     info.UsedBytes,
+		Capabilities:  capabilities,
+		Redundancy:    redundancy,
+		Error:         info.Error,
+	}
+}
+
+// newLastError returns the error of the most recent failed operation.
+func newLastError(operations *operationLog) *lastError {
+	if operations == nil {
+		return nil
+	}
+	for _, op := range operations.recent() {
+		if op.Error != "" {
+			return &lastError{
+				Message: fmt.Sprintf("%s of %s failed: %s", op.Type, op.PV, op.Error),
+				Time:    metav1.NewTime(op.Started),
+			}
+		}
+	}
+	return nil
+}
+
+func (n *nodeStatusPublisher) path() string {
+	return "/apis/" + nodeStatusGroup + "/" + nodeStatusVersion + "/" + nodeStatusResource
+}
+
+// publish creates or updates the HostPathNodeStatus object of the node.
+func (n *nodeStatusPublisher) publish(status nodeStatus) error {
+	existing := &hostPathNodeStatus{}
+	raw, err := n.client.Get().AbsPath(n.path(), n.p.nodeName).Do(context.TODO()).Raw()
+	if apierrs.IsNotFound(err) {
+		obj := newHostPathNodeStatus(n.p.nodeName, status)
+		return n.send(n.client.Post().AbsPath(n.path()), obj)
+	}
+	if err != nil {
+		return err
+	}
+	if err := json.Unmarshal(raw, existing); err != nil {
+		return err
+	}
+	existing.Status = status
+	return n.send(n.client.Put().AbsPath(n.path(), n.p.nodeName), existing)
+}
+
+func (n *nodeStatusPublisher) send(req *rest.Request, obj *hostPathNodeStatus) error {
+	body, err := json.Marshal(obj)
+	if err != nil {
+		return err
+	}
+	return req.SetHeader("Content-Type", "application/json").Body(body).Do(context.TODO()).Error()
+}
+
+func newHostPathNodeStatus(nodeName string, status nodeStatus) *hostPathNodeStatus {
+	return &hostPathNodeStatus{
+		TypeMeta: metav1.TypeMeta{
+			APIVersion: nodeStatusGroup + "/" + nodeStatusVersion,
+			Kind:       nodeStatusKind,
+		},
+		ObjectMeta: metav1.ObjectMeta{Name: nodeName},
+		Status:     status,
+	}
+}
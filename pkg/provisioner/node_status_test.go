@@ -0,0 +1,94 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package provisioner
+
+import (
+	"encoding/json"
+	"errors"
+	"testing"
+	"time"
+
+	"kubevirt.io/hostpath-provisioner/admin"
+)
+
+func Test_newLastError(t *testing.T) {
+	started := time.Date(2019, 5, 1, 12, 0, 0, 0, time.UTC)
+	tests := []struct {
+		name   string
+		record func(log *operationLog)
+		want   string
+	}{
+		{name: "no operations", record: func(*operationLog) {}, want: ""},
+		{
+			name: "only successes",
+			record: func(log *operationLog) {
+				log.record(admin.Operation{Type: admin.OperationProvision, PV: "pv-1"}, started, nil)
+			},
+			want: "",
+		},
+		{
+			name: "newest failure",
+			record: func(log *operationLog) {
+				log.record(admin.Operation{Type: admin.OperationProvision, PV: "pv-1"}, started, errors.New("no space"))
+				log.record(admin.Operation{Type: admin.OperationDelete, PV: "pv-2"}, started, errors.New("busy"))
+				log.record(admin.Operation{Type: admin.OperationProvision, PV: "pv-3"}, started, nil)
+			},
+			want: "delete of pv-2 failed: busy",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			log := newOperationLog(10)
+			tt.record(log)
+			got := newLastError(log)
+			if tt.want == "" {
+				if got != nil {
+					t.Errorf("newLastError() = %v, want nil", got)
+				}
+				return
+			}
+			if got == nil || got.Message != tt.want || !got.Time.Time.Equal(started) {
+				t.Errorf("newLastError() = %v, want %q at %v", got, tt.want, started)
+			}
+		})
+	}
+	if newLastError(nil) != nil {
+		t.Errorf("newLastError(nil) should be nil")
+	}
+}
+
+func Test_newHostPathNodeStatus(t *testing.T) {
+	obj := newHostPathNodeStatus("node-1", nodeStatus{Version: "v1.0.0", Pools: []poolStatus{newPoolStatus(hostBackend(), &storagePool{name: "fast", path: "/does/not/exist"}, nil, "")}})
+	raw, err := json.Marshal(obj)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var decoded map[string]interface{}
+	if err := json.Unmarshal(raw, &decoded); err != nil {
+		t.Fatal(err)
+	}
+	if decoded["apiVersion"] != "hostpathprovisioner.kubevirt.io/v1alpha1" || decoded["kind"] != "HostPathNodeStatus" {
+		t.Errorf("unexpected type %v/%v", decoded["apiVersion"], decoded["kind"])
+	}
+	if name := decoded["metadata"].(map[string]interface{})["name"]; name != "node-1" {
+		t.Errorf("name = %v, want node-1", name)
+	}
+	pools := decoded["status"].(map[string]interface{})["pools"].([]interface{})
+	if len(pools) != 1 || pools[0].(map[string]interface{})["error"] == nil {
+		t.Errorf("expected the missing pool to report an error, got %v", pools)
+	}
+}
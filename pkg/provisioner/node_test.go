@@ -0,0 +1,49 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package provisioner
+
+import (
+	"testing"
+
+	v1 "k8s.io/api/core/v1"
+)
+
+func Test_nodeDisabled(t *testing.T) {
+	tests := []struct {
+		name   string
+		labels map[string]string
+		taints []v1.Taint
+		want   bool
+	}{
+		{name: "plain node", want: false},
+		{name: "label true", labels: map[string]string{nodeDisabledKey: "true"}, want: true},
+		{name: "label false", labels: map[string]string{nodeDisabledKey: "false"}, want: false},
+		{name: "label garbage", labels: map[string]string{nodeDisabledKey: "soon"}, want: false},
+		{name: "taint", taints: []v1.Taint{{Key: nodeDisabledKey, Effect: v1.TaintEffectNoSchedule}}, want: true},
+		{name: "other taint", taints: []v1.Taint{{Key: "node.kubernetes.io/unschedulable", Effect: v1.TaintEffectNoSchedule}}, want: false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			node := &v1.Node{}
+			node.Labels = tt.labels
+			node.Spec.Taints = tt.taints
+			if got, reason := nodeDisabled(node); got != tt.want {
+				t.Errorf("nodeDisabled() = %v (%s), want %v", got, reason, tt.want)
+			}
+		})
+	}
+}
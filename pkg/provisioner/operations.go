@@ -0,0 +1,70 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package provisioner
+
+import (
+	"sync"
+	"time"
+
+	"kubevirt.io/hostpath-provisioner/admin"
+)
+
+// recentOperationsSize is how many operations the admin API can report.
+const recentOperationsSize = 100
+
+// operationLog keeps the most recent operations in a ring buffer.
+type operationLog struct {
+	mutex   sync.Mutex
+	entries []admin.Operation
+	next    int
+}
+
+func newOperationLog(size int) *operationLog {
+	return &operationLog{entries: make([]admin.Operation, 0, size)}
+}
+
+// record adds an operation that started at start and failed with err, if not
+// nil, replacing the oldest one when the log is full.
+func (l *operationLog) record(op admin.Operation, start time.Time, err error) {
+	if l == nil {
+		return
+	}
+	op.Started = start
+	op.Duration = time.Since(start)
+	if err != nil {
+		op.Error = err.Error()
+	}
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	if len(l.entries) < cap(l.entries) {
+		l.entries = append(l.entries, op)
+		return
+	}
+	l.entries[l.next] = op
+	l.next = (l.next + 1) % len(l.entries)
+}
+
+// recent returns the logged operations, newest first.
+func (l *operationLog) recent() []admin.Operation {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	ops := make([]admin.Operation, 0, len(l.entries))
+	for i := len(l.entries) - 1; i >= 0; i-- {
+		ops = append(ops, l.entries[(l.next+i)%len(l.entries)])
+	}
+	return ops
+}
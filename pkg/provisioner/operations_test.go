@@ -0,0 +1,65 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package provisioner
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+
+	"kubevirt.io/hostpath-provisioner/admin"
+)
+
+func Test_operationLog(t *testing.T) {
+	tests := []struct {
+		name     string
+		recorded []string
+		want     []string
+	}{
+		{name: "empty", recorded: nil, want: []string{}},
+		{name: "not full", recorded: []string{"pv-1", "pv-2"}, want: []string{"pv-2", "pv-1"}},
+		{name: "full", recorded: []string{"pv-1", "pv-2", "pv-3"}, want: []string{"pv-3", "pv-2", "pv-1"}},
+		{name: "wrapped", recorded: []string{"pv-1", "pv-2", "pv-3", "pv-4", "pv-5"}, want: []string{"pv-5", "pv-4", "pv-3"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			log := newOperationLog(3)
+			for _, pv := range tt.recorded {
+				log.record(admin.Operation{Type: admin.OperationProvision, PV: pv}, time.Now(), nil)
+			}
+			got := []string{}
+			for _, op := range log.recent() {
+				got = append(got, op.PV)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("recent() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_operationLogError(t *testing.T) {
+	log := newOperationLog(1)
+	log.record(admin.Operation{Type: admin.OperationDelete, PV: "pv"}, time.Now().Add(-time.Second), errors.New("busy"))
+	op := log.recent()[0]
+	if op.Error != "busy" || op.Duration < time.Second {
+		t.Errorf("recorded operation = %+v", op)
+	}
+	var nilLog *operationLog
+	nilLog.record(admin.Operation{}, time.Now(), nil)
+}
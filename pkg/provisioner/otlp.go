@@ -0,0 +1,213 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package provisioner
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"k8s.io/klog/v2"
+)
+
+const (
+	// otlpBatchSize is the most spans sent to the collector in one request,
+	// spans ending while the queue is full are dropped.
+	otlpBatchSize = 512
+	otlpQueueSize = 4 * otlpBatchSize
+)
+
+// otlpExporter sends ended spans in batches to an OpenTelemetry collector,
+// encoded as OTLP/HTTP JSON, so that provisioning latency can be followed in
+// the same tracing backend as the rest of the cluster.
+type otlpExporter struct {
+	url      string
+	resource []otlpAttribute
+	client   *http.Client
+
+	mutex   sync.Mutex
+	queue   []otlpSpan
+	dropped int
+}
+
+func newOTLPExporter(endpoint, nodeName string) *otlpExporter {
+	return &otlpExporter{
+		url: endpoint + "/v1/traces",
+		resource: []otlpAttribute{
+			stringAttribute("service.name", "hostpath-provisioner"),
+			stringAttribute("host.name", nodeName),
+		},
+		client: &http.Client{Timeout: 10 * time.Second},
+	}
+}
+
+// Run exports the queued spans every interval until stopCh is closed, the
+// spans still queued then are exported once more.
+func (e *otlpExporter) Run(interval time.Duration, stopCh <-chan struct{}) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-stopCh:
+			e.flush()
+			return
+		case <-ticker.C:
+			e.flush()
+		}
+	}
+}
+
+// record queues an ended span for export.
+func (e *otlpExporter) record(s *span, end time.Time, err error) {
+	if e == nil {
+		return
+	}
+	exported := otlpSpan{
+		TraceID:      s.traceID,
+		SpanID:       s.spanID,
+		ParentSpanID: s.parentID,
+		Name:         s.name,
+		Kind:         otlpSpanKindInternal,
+		Start:        strconv.FormatInt(s.start.UnixNano(), 10),
+		End:          strconv.FormatInt(end.UnixNano(), 10),
+		Status:       otlpStatus{Code: otlpStatusOK},
+	}
+	for i := 0; i+1 < len(s.keysAndValues); i += 2 {
+		exported.Attributes = append(exported.Attributes, stringAttribute(fmt.Sprint(s.keysAndValues[i]), fmt.Sprint(s.keysAndValues[i+1])))
+	}
+	if err != nil {
+		exported.Status = otlpStatus{Code: otlpStatusError, Message: err.Error()}
+	}
+
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+	if len(e.queue) >= otlpQueueSize {
+		e.dropped++
+		return
+	}
+	e.queue = append(e.queue, exported)
+}
+
+// flush sends the queued spans to the collector.
+func (e *otlpExporter) flush() {
+	e.mutex.Lock()
+	queue, dropped := e.queue, e.dropped
+	e.queue, e.dropped = nil, 0
+	e.mutex.Unlock()
+
+	if dropped > 0 {
+		klog.Warningf("Dropped %d spans, the OpenTelemetry collector at %s is not keeping up", dropped, e.url)
+	}
+	for len(queue) > 0 {
+		n := len(queue)
+		if n > otlpBatchSize {
+			n = otlpBatchSize
+		}
+		if err := e.send(queue[:n]); err != nil {
+			klog.Warningf("Unable to export %d spans: %v", n, err)
+		}
+		queue = queue[n:]
+	}
+}
+
+func (e *otlpExporter) send(spans []otlpSpan) error {
+	body, err := json.Marshal(otlpTraces{ResourceSpans: []otlpResourceSpans{{
+		Resource: otlpResource{Attributes: e.resource},
+		ScopeSpans: []otlpScopeSpans{{
+			Scope: otlpScope{Name: "kubevirt.io/hostpath-provisioner"},
+			Spans: spans,
+		}},
+	}}})
+	if err != nil {
+		return err
+	}
+	resp, err := e.client.Post(e.url, "application/json", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		return fmt.Errorf("collector at %s returned %s", e.url, resp.Status)
+	}
+	return nil
+}
+
+// The types below are the subset of the OTLP/HTTP JSON encoding of
+// ExportTraceServiceRequest the provisioner sends. Trace and span IDs are hex
+// encoded and timestamps are nanoseconds since the epoch as a string, as
+// specified for the JSON encoding.
+type otlpTraces struct {
+	ResourceSpans []otlpResourceSpans `json:"resourceSpans"`
+}
+
+type otlpResourceSpans struct {
+	Resource   otlpResource     `json:"resource"`
+	ScopeSpans []otlpScopeSpans `json:"scopeSpans"`
+}
+
+type otlpResource struct {
+	Attributes []otlpAttribute `json:"attributes"`
+}
+
+type otlpScopeSpans struct {
+	Scope otlpScope  `json:"scope"`
+	Spans []otlpSpan `json:"spans"`
+}
+
+type otlpScope struct {
+	Name string `json:"name"`
+}
+
+const (
+	otlpSpanKindInternal = 1
+	otlpStatusOK         = 1
+	otlpStatusError      = 2
+)
+
+type otlpSpan struct {
+	TraceID      string          `json:"traceId"`
+	SpanID       string          `json:"spanId"`
+	ParentSpanID string          `json:"parentSpanId,omitempty"`
+	Name         string          `json:"name"`
+	Kind         int             `json:"kind"`
+	Start        string          `json:"startTimeUnixNano"`
+	End          string          `json:"endTimeUnixNano"`
+	Attributes   []otlpAttribute `json:"attributes,omitempty"`
+	Status       otlpStatus      `json:"status"`
+}
+
+type otlpStatus struct {
+	Code    int    `json:"code"`
+	Message string `json:"message,omitempty"`
+}
+
+type otlpAttribute struct {
+	Key   string    `json:"key"`
+	Value otlpValue `json:"value"`
+}
+
+type otlpValue struct {
+	StringValue string `json:"stringValue"`
+}
+
+func stringAttribute(key, value string) otlpAttribute {
+	return otlpAttribute{Key: key, Value: otlpValue{StringValue: value}}
+}
@@ -0,0 +1,155 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package provisioner
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"k8s.io/klog/v2"
+
+	v1 "k8s.io/api/core/v1"
+)
+
+const (
+	// annTemplate marks claims, and their volumes, as templates: plain
+	// directories that are cloned into overlays instead of being copied.
+	annTemplate = "kubevirt.io/template"
+
+	// annOverlayLower records the backing directory of the template an
+	// overlay clone is layered on.
+	annOverlayLower = "kubevirt.io/overlayLower"
+
+	// overlaySuffix is appended to the backing directory of an overlay clone
+	// to name the directory holding its upper and work directories.
+	overlaySuffix = ".overlay"
+)
+
+// isTemplate returns whether the volume is a template.
+func isTemplate(pv *v1.PersistentVolume) bool {
+	return pv.Annotations[annTemplate] == "true"
+}
+
+// overlayPool returns the pool of the template source, clones are layered on
+// it in the same pool.
+func (p *HostPathProvisioner) overlayPool(source *v1.PersistentVolume) (*storagePool, error) {
+	if backingPath(source) == "" {
+		return nil, fmt.Errorf("template %s is not a directory", source.Name)
+	}
+	if _, ok := tmpfsSize(*source); ok {
+		return nil, fmt.Errorf("template %s is tmpfs backed, its contents do not survive a reboot", source.Name)
+	}
+	pool := findPool(p.currentPools(), source.Annotations[annStoragePool])
+	if pool == nil || pool.lvm != nil || pool.devices != nil {
+		return nil, fmt.Errorf("pool %q of template %s is not a pool of plain directories, only their templates can be cloned into overlays", source.Annotations[annStoragePool], source.Name)
+	}
+	if err := p.capabilities.require(pool, capabilityXattrs, "overlay clones"); err != nil {
+		return nil, err
+	}
+	return pool, nil
+}
+
+// overlayDirs returns the upper and work directories of the overlay clone at
+// path.
+func overlayDirs(path string) (string, string) {
+	dir := path + overlaySuffix
+	return filepath.Join(dir, "upper"), filepath.Join(dir, "work")
+}
+
+// mountOverlay mounts an overlay of lower at path, keeping the changes made
+// to it in the upper directory next to path. A mounted overlay is left alone.
+func mountOverlay(lower, path string) error {
+	upper, work := overlayDirs(path)
+	for _, dir := range []string{upper, work, path} {
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			return err
+		}
+	}
+	if isMountPoint(path) {
+		return nil
+	}
+	options := fmt.Sprintf("lowerdir=%s,upperdir=%s,workdir=%s", escapeOverlayPath(lower), escapeOverlayPath(upper), escapeOverlayPath(work))
+	_, err := runCommand("mount", "-t", "overlay", "overlay", "-o", options, path)
+	return err
+}
+
+// escapeOverlayPath escapes the characters overlayfs separates its options
+// and lower directories with.
+func escapeOverlayPath(path string) string {
+	return strings.NewReplacer(`\`, `\\`, ",", `\,`, ":", `\:`).Replace(path)
+}
+
+// removeOverlay unmounts the overlay clone at path and removes its changes.
+func removeOverlay(path string) error {
+	if isMountPoint(path) {
+		if _, err := runCommand("umount", path); err != nil {
+			return err
+		}
+	}
+	return os.RemoveAll(path + overlaySuffix)
+}
+
+// overlayClones returns the names of the volumes layered on the template at
+// path, it can not be removed while they exist.
+func overlayClones(pvs []v1.PersistentVolume, path string) []string {
+	var clones []string
+	for _, pv := range pvs {
+		if pv.Annotations[annOverlayLower] == path {
+			clones = append(clones, pv.Name)
+		}
+	}
+	return clones
+}
+
+// checkTemplateClones fails while the template volume has overlay clones.
+func (p *HostPathProvisioner) checkTemplateClones(volume *v1.PersistentVolume, path string) error {
+	pvs, err := p.pvCache.list(context.TODO(), p.client)
+	if err != nil {
+		return fmt.Errorf("unable to list persistent volumes to find the clones of template %s: %v", volume.Name, err)
+	}
+	if clones := overlayClones(pvs.Items, path); len(clones) > 0 {
+		return fmt.Errorf("template %s still has clones, delete them first: %s", volume.Name, strings.Join(clones, ", "))
+	}
+	return nil
+}
+
+// mountOverlayVolumes mounts the overlay clones of this node again after a
+// reboot.
+func (p *HostPathProvisioner) mountOverlayVolumes() {
+	pvs, err := p.pvCache.list(context.TODO(), p.client)
+	if err != nil {
+		klog.Warningf("unable to list persistent volumes, not mounting overlay clones: %v", err)
+		return
+	}
+	for _, pv := range pvs.Items {
+		lower := pv.Annotations[annOverlayLower]
+		if lower == "" || backingPath(&pv) == "" || !p.ownsIdentity(pv.Annotations["hostPathProvisionerIdentity"]) || pv.Annotations["kubevirt.io/provisionOnNode"] != p.nodeName {
+			continue
+		}
+		path := backingPath(&pv)
+		if isMountPoint(path) {
+			continue
+		}
+		klog.InfoS("Mounting overlay clone", "node", p.nodeName, "pv", pv.Name, "path", path, "template", lower)
+		if err := mountOverlay(lower, path); err != nil {
+			klog.ErrorS(err, "Failed to mount overlay clone", "node", p.nodeName, "pv", pv.Name, "path", path)
+		}
+	}
+}
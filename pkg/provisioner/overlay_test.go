@@ -0,0 +1,96 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package provisioner
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func Test_escapeOverlayPath(t *testing.T) {
+	tests := []struct {
+		path string
+		want string
+	}{
+		{path: "/var/hpvolumes/pvc-1", want: "/var/hpvolumes/pvc-1"},
+		{path: "/var/hpvolumes/a,b:c", want: `/var/hpvolumes/a\,b\:c`},
+		{path: `/var/hpvolumes/a\b`, want: `/var/hpvolumes/a\\b`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.path, func(t *testing.T) {
+			if got := escapeOverlayPath(tt.path); got != tt.want {
+				t.Errorf("escapeOverlayPath() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_mountOverlay(t *testing.T) {
+	dir, err := ioutil.TempDir("", "overlay")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	lower, path := filepath.Join(dir, "pvc-template"), filepath.Join(dir, "pvc-clone")
+	upper, work := overlayDirs(path)
+	mount := "mount -t overlay overlay -o lowerdir=" + lower + ",upperdir=" + upper + ",workdir=" + work + " " + path
+	commands, restore := fakeCommands(map[string]string{mount: ""})
+	defer restore()
+
+	if err := mountOverlay(lower, path); err != nil {
+		t.Fatalf("mountOverlay() failed: %v", err)
+	}
+	if !reflect.DeepEqual(*commands, []string{mount}) {
+		t.Errorf("commands = %v, want %v", *commands, []string{mount})
+	}
+	for _, d := range []string{upper, work, path} {
+		if _, err := os.Stat(d); err != nil {
+			t.Errorf("directory %s was not created: %v", d, err)
+		}
+	}
+	if err := removeOverlay(path); err != nil {
+		t.Fatalf("removeOverlay() failed: %v", err)
+	}
+	if _, err := os.Stat(path + overlaySuffix); !os.IsNotExist(err) {
+		t.Errorf("upper and work directories were not removed: %v", err)
+	}
+}
+
+func Test_overlayClones(t *testing.T) {
+	clone := func(name, lower string) v1.PersistentVolume {
+		return v1.PersistentVolume{ObjectMeta: metav1.ObjectMeta{Name: name, Annotations: map[string]string{annOverlayLower: lower}}}
+	}
+	pvs := []v1.PersistentVolume{
+		clone("pvc-1", "/var/hpvolumes/pvc-template"),
+		clone("pvc-2", "/var/hpvolumes/pvc-other"),
+		clone("pvc-3", "/var/hpvolumes/pvc-template"),
+		{ObjectMeta: metav1.ObjectMeta{Name: "pvc-template"}},
+	}
+	want := []string{"pvc-1", "pvc-3"}
+	if got := overlayClones(pvs, "/var/hpvolumes/pvc-template"); !reflect.DeepEqual(got, want) {
+		t.Errorf("overlayClones() = %v, want %v", got, want)
+	}
+	if got := overlayClones(pvs, "/var/hpvolumes/pvc-none"); got != nil {
+		t.Errorf("overlayClones() = %v, want none", got)
+	}
+}
@@ -0,0 +1,176 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package provisioner
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+
+	"sigs.k8s.io/sig-storage-lib-external-provisioner/v13/controller"
+
+	v1 "k8s.io/api/core/v1"
+)
+
+const (
+	// annDirectoryMode on a claim sets the permissions of its backing
+	// directory, in octal, e.g. 0750.
+	annDirectoryMode = "kubevirt.io/directoryMode"
+	// directoryModeParameter is the StorageClass parameter doing the same for
+	// all claims of the class.
+	directoryModeParameter = "directoryMode"
+
+	// annOwnerUID and annOwnerGID on a claim ask for its backing directory
+	// to be owned by that user and group, so that containers running as a
+	// non-root user can write to it.
+	annOwnerUID = "kubevirt.io/ownerUID"
+	annOwnerGID = "kubevirt.io/ownerGID"
+
+	// defaultDirectoryMode lets containers running as any user write to
+	// their volumes.
+	defaultDirectoryMode os.FileMode = 0777
+)
+
+// parseDirectoryMode parses permissions given in octal, including the setuid,
+// setgid and sticky bits, e.g. 0750 or 2770.
+func parseDirectoryMode(mode string) (os.FileMode, error) {
+	value, err := strconv.ParseUint(mode, 8, 32)
+	if err != nil || value > 07777 {
+		return 0, fmt.Errorf("invalid directory mode %q, expected octal permissions such as 0750", mode)
+	}
+	perm := os.FileMode(value & 0777)
+	if value&04000 != 0 {
+		perm |= os.ModeSetuid
+	}
+	if value&02000 != 0 {
+		perm |= os.ModeSetgid
+	}
+	if value&01000 != 0 {
+		perm |= os.ModeSticky
+	}
+	return perm, nil
+}
+
+// directoryModeFor returns the permissions of the backing directory of a
+// claim. The claim's annotation takes precedence over the StorageClass
+// parameter, which takes precedence over the configured mode.
+func (p *HostPathProvisioner) directoryModeFor(options controller.ProvisionOptions) (os.FileMode, error) {
+	mode := ""
+	if options.StorageClass != nil {
+		mode = options.StorageClass.Parameters[directoryModeParameter]
+	}
+	if options.PVC != nil {
+		if value, ok := options.PVC.Annotations[annDirectoryMode]; ok {
+			mode = value
+		}
+	}
+	if mode == "" {
+		return p.currentDirectoryMode(), nil
+	}
+	return parseDirectoryMode(mode)
+}
+
+// idRange is an inclusive range of user or group IDs.
+type idRange struct {
+	min, max int
+}
+
+// idRanges are the user or group IDs claims may ask for, none when empty.
+type idRanges []idRange
+
+// parseIDRanges parses a comma separated list of IDs and ranges of IDs, e.g.
+// 107,1000-1999.
+func parseIDRanges(spec string) (idRanges, error) {
+	var ranges idRanges
+	for _, entry := range strings.Split(spec, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		bounds := strings.SplitN(entry, "-", 2)
+		min, err := strconv.Atoi(bounds[0])
+		max := min
+		if err == nil && len(bounds) == 2 {
+			max, err = strconv.Atoi(bounds[1])
+		}
+		if err != nil || min < 0 || max < min {
+			return nil, fmt.Errorf("invalid ID range %q, expected an ID such as 107 or a range such as 1000-1999", entry)
+		}
+		ranges = append(ranges, idRange{min: min, max: max})
+	}
+	return ranges, nil
+}
+
+func (r idRanges) contains(id int) bool {
+	for _, idr := range r {
+		if id >= idr.min && id <= idr.max {
+			return true
+		}
+	}
+	return false
+}
+
+// ownerFor returns the user and group the backing directory of a claim is
+// owned by, -1 for the ones it does not ask for. Only IDs in the allowed
+// ranges are accepted.
+func (p *HostPathProvisioner) ownerFor(pvc *v1.PersistentVolumeClaim) (int, int, error) {
+	allowedUIDs, allowedGIDs := p.currentAllowedOwners()
+	uid, err := p.requestedID(pvc, annOwnerUID, allowedUIDs)
+	if err != nil {
+		return -1, -1, err
+	}
+	gid, err := p.requestedID(pvc, annOwnerGID, allowedGIDs)
+	if err != nil {
+		return -1, -1, err
+	}
+	return uid, gid, nil
+}
+
+func (p *HostPathProvisioner) requestedID(pvc *v1.PersistentVolumeClaim, annotation string, allowed idRanges) (int, error) {
+	value, ok := pvc.Annotations[annotation]
+	if !ok {
+		return -1, nil
+	}
+	if !p.features.Enabled(featureVolumeOwnership) {
+		return -1, fmt.Errorf("the %s annotation requires the %s feature gate", annotation, featureVolumeOwnership)
+	}
+	id, err := strconv.Atoi(value)
+	if err != nil || id < 0 {
+		return -1, fmt.Errorf("invalid %s annotation %q, expected a numeric ID", annotation, value)
+	}
+	if !allowed.contains(id) {
+		return -1, fmt.Errorf("%s %d is not allowed on this node", annotation, id)
+	}
+	return id, nil
+}
+
+// createBackingDir creates the directory at path with the given permissions
+// and owner, uid and gid are -1 to keep the provisioner's. The permissions are
+// set explicitly, after changing the owner, so that neither the umask of the
+// provisioner nor the chown clearing the setgid bit apply.
+func createBackingDir(path string, mode os.FileMode, uid, gid int) error {
+	if err := os.MkdirAll(path, mode); err != nil {
+		return err
+	}
+	if uid != -1 || gid != -1 {
+		if err := os.Lchown(path, uid, gid); err != nil {
+			return err
+		}
+	}
+	return os.Chmod(path, mode)
+}
@@ -0,0 +1,130 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package provisioner
+
+import (
+	"os"
+	"strings"
+	"testing"
+
+	"sigs.k8s.io/sig-storage-lib-external-provisioner/v13/controller"
+
+	v1 "k8s.io/api/core/v1"
+	storage "k8s.io/api/storage/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func Test_parseDirectoryMode(t *testing.T) {
+	tests := []struct {
+		mode    string
+		want    os.FileMode
+		wantErr bool
+	}{
+		{mode: "0750", want: 0750},
+		{mode: "700", want: 0700},
+		{mode: "2770", want: 0770 | os.ModeSetgid},
+		{mode: "1777", want: 0777 | os.ModeSticky},
+		{mode: "0789", wantErr: true},
+		{mode: "17777", wantErr: true},
+		{mode: "rwx", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.mode, func(t *testing.T) {
+			got, err := parseDirectoryMode(tt.mode)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("parseDirectoryMode() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("parseDirectoryMode() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_directoryModeFor(t *testing.T) {
+	class := &storage.StorageClass{Parameters: map[string]string{directoryModeParameter: "0770"}}
+	annotated := &v1.PersistentVolumeClaim{ObjectMeta: metav1.ObjectMeta{Annotations: map[string]string{annDirectoryMode: "0700"}}}
+	tests := []struct {
+		name    string
+		options controller.ProvisionOptions
+		want    os.FileMode
+		wantErr bool
+	}{
+		{name: "default", options: controller.ProvisionOptions{PVC: &v1.PersistentVolumeClaim{}}, want: 0750},
+		{name: "storage class", options: controller.ProvisionOptions{PVC: &v1.PersistentVolumeClaim{}, StorageClass: class}, want: 0770},
+		{name: "annotation wins", options: controller.ProvisionOptions{PVC: annotated, StorageClass: class}, want: 0700},
+		{name: "invalid", options: controller.ProvisionOptions{PVC: &v1.PersistentVolumeClaim{ObjectMeta: metav1.ObjectMeta{Annotations: map[string]string{annDirectoryMode: "all"}}}}, wantErr: true},
+	}
+	p := &HostPathProvisioner{directoryMode: 0750}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := p.directoryModeFor(tt.options)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("directoryModeFor() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("directoryModeFor() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_ownerFor(t *testing.T) {
+	uids, err := parseIDRanges("107, 1000-1999")
+	if err != nil {
+		t.Fatal(err)
+	}
+	p := &HostPathProvisioner{allowedUIDs: uids, allowedGIDs: idRanges{{min: 2000, max: 2000}}}
+	tests := []struct {
+		name        string
+		annotations map[string]string
+		uid, gid    int
+		wantErr     string
+	}{
+		{name: "no annotations", uid: -1, gid: -1},
+		{name: "uid", annotations: map[string]string{annOwnerUID: "107"}, uid: 107, gid: -1},
+		{name: "uid and gid", annotations: map[string]string{annOwnerUID: "1500", annOwnerGID: "2000"}, uid: 1500, gid: 2000},
+		{name: "uid not allowed", annotations: map[string]string{annOwnerUID: "0"}, wantErr: "not allowed"},
+		{name: "gid not allowed", annotations: map[string]string{annOwnerGID: "1500"}, wantErr: "not allowed"},
+		{name: "not numeric", annotations: map[string]string{annOwnerUID: "qemu"}, wantErr: "numeric"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			uid, gid, err := p.ownerFor(&v1.PersistentVolumeClaim{ObjectMeta: metav1.ObjectMeta{Annotations: tt.annotations}})
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Errorf("ownerFor() error = %v, want %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil || uid != tt.uid || gid != tt.gid {
+				t.Errorf("ownerFor() = %d, %d, %v; want %d, %d", uid, gid, err, tt.uid, tt.gid)
+			}
+		})
+	}
+
+	// Nothing is allowed without ranges
+	p = &HostPathProvisioner{}
+	if _, _, err := p.ownerFor(&v1.PersistentVolumeClaim{ObjectMeta: metav1.ObjectMeta{Annotations: map[string]string{annOwnerUID: "107"}}}); err == nil {
+		t.Error("ownerFor() accepted an owner without allowed UIDs")
+	}
+	for _, spec := range []string{"1999-1000", "x", "-5"} {
+		if _, err := parseIDRanges(spec); err == nil {
+			t.Errorf("parseIDRanges(%q) accepted an invalid range", spec)
+		}
+	}
+}
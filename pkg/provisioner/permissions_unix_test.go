@@ -0,0 +1,66 @@
+//go:build linux || darwin
+// +build linux darwin
+
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package provisioner
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"syscall"
+	"testing"
+)
+
+func Test_createBackingDir(t *testing.T) {
+	pool, err := ioutil.TempDir("", "permissions")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(pool)
+	// The mode is applied in full, whatever the umask
+	defer syscall.Umask(syscall.Umask(077))
+	for _, mode := range []os.FileMode{0777, 0750, 0770 | os.ModeSetgid} {
+		dir := filepath.Join(pool, mode.String())
+		if err := createBackingDir(dir, mode, -1, -1); err != nil {
+			t.Fatal(err)
+		}
+		info, err := os.Stat(dir)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := info.Mode() &^ os.ModeDir; got != mode {
+			t.Errorf("mode of %s = %v, want %v", dir, got, mode)
+		}
+	}
+
+	// Changing the owner, to ourselves so that no privileges are needed, keeps
+	// the setgid bit
+	dir := filepath.Join(pool, "owned")
+	if err := createBackingDir(dir, 0770|os.ModeSetgid, os.Getuid(), os.Getgid()); err != nil {
+		t.Fatal(err)
+	}
+	info, err := os.Stat(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	stat := info.Sys().(*syscall.Stat_t)
+	if int(stat.Uid) != os.Getuid() || int(stat.Gid) != os.Getgid() || info.Mode()&os.ModeSetgid == 0 {
+		t.Errorf("owner %d:%d, mode %v", stat.Uid, stat.Gid, info.Mode())
+	}
+}
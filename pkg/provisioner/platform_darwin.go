@@ -0,0 +1,96 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package provisioner
+
+import (
+	"golang.org/x/sys/unix"
+)
+
+// macOS is only supported for development: the provisioner builds and its
+// tests run, but what only Linux has is missing. inotify, fallocate and peer
+// credentials fail with ENOSYS or EOPNOTSUPP, which turns off tamper
+// detection, preallocates images by writing zeroes and refuses connections
+// to the admin socket.
+
+// statfsReadOnly is the statfs flag of read-only filesystems.
+const statfsReadOnly = unix.MNT_RDONLY
+
+// errNoKey is returned when removing an fscrypt key that is not present,
+// macOS has no fscrypt and never returns it.
+var errNoKey error
+
+// The inotify events and watch flags the tamper watcher uses, with their
+// Linux values
+const (
+	inAttrib    = 0x4
+	inDelete    = 0x200
+	inMovedFrom = 0x40
+	inIgnored   = 0x8000
+	inIsDir     = 0x40000000
+	inOnlyDir   = 0x1000000
+)
+
+// inotifyEventHeader is the fixed size part of an inotify event, followed by
+// Len bytes of name.
+type inotifyEventHeader struct {
+	Wd     int32
+	Mask   uint32
+	Cookie uint32
+	Len    uint32
+}
+
+func inotifyInit() (int, error) {
+	return -1, unix.ENOSYS
+}
+
+func inotifyAddWatch(fd int, path string, mask uint32) (int, error) {
+	return -1, unix.ENOSYS
+}
+
+func inotifyRmWatch(fd int, wd uint32) error {
+	return unix.ENOSYS
+}
+
+// allocate allocates the first size bytes of the file without writing them.
+func allocate(fd int, size int64) error {
+	return unix.EOPNOTSUPP
+}
+
+// punchHole deallocates size bytes of the file at offset, keeping its size.
+func punchHole(fd int, offset, size int64) error {
+	return unix.EOPNOTSUPP
+}
+
+// openDirect creates the file at path and opens it bypassing the page
+// cache, macOS' equivalent of direct I/O.
+func openDirect(path string) (int, error) {
+	fd, err := unix.Open(path, unix.O_CREAT|unix.O_RDWR, 0600)
+	if err != nil {
+		return -1, err
+	}
+	if _, err := unix.FcntlInt(uintptr(fd), unix.F_NOCACHE, 1); err != nil {
+		unix.Close(fd)
+		return -1, err
+	}
+	return fd, nil
+}
+
+// peerCredentials returns the user id of the process on the other end of
+// the unix socket fd.
+func peerCredentials(fd int) (uint32, error) {
+	return 0, unix.ENOSYS
+}
@@ -0,0 +1,84 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package provisioner
+
+import (
+	"golang.org/x/sys/unix"
+)
+
+// The system calls and constants that only Linux has, platform_darwin.go
+// stands in for them so that the provisioner builds and its tests run on
+// macOS.
+
+// statfsReadOnly is the statfs flag of read-only filesystems.
+const statfsReadOnly = unix.ST_RDONLY
+
+// errNoKey is returned when removing an fscrypt key that is not present.
+var errNoKey error = unix.ENOKEY
+
+// The inotify events and watch flags the tamper watcher uses
+const (
+	inAttrib    = unix.IN_ATTRIB
+	inDelete    = unix.IN_DELETE
+	inMovedFrom = unix.IN_MOVED_FROM
+	inIgnored   = unix.IN_IGNORED
+	inIsDir     = unix.IN_ISDIR
+	inOnlyDir   = unix.IN_ONLYDIR
+)
+
+// inotifyEventHeader is the fixed size part of an inotify event, followed by
+// Len bytes of name.
+type inotifyEventHeader = unix.InotifyEvent
+
+func inotifyInit() (int, error) {
+	return unix.InotifyInit1(unix.IN_CLOEXEC)
+}
+
+func inotifyAddWatch(fd int, path string, mask uint32) (int, error) {
+	return unix.InotifyAddWatch(fd, path, mask)
+}
+
+func inotifyRmWatch(fd int, wd uint32) error {
+	_, err := unix.InotifyRmWatch(fd, wd)
+	return err
+}
+
+// allocate allocates the first size bytes of the file without writing them.
+func allocate(fd int, size int64) error {
+	return unix.Fallocate(fd, 0, 0, size)
+}
+
+// punchHole deallocates size bytes of the file at offset, keeping its size.
+func punchHole(fd int, offset, size int64) error {
+	return unix.Fallocate(fd, unix.FALLOC_FL_PUNCH_HOLE|unix.FALLOC_FL_KEEP_SIZE, offset, size)
+}
+
+// openDirect creates the file at path and opens it for direct I/O,
+// bypassing the page cache.
+func openDirect(path string) (int, error) {
+	return unix.Open(path, unix.O_CREAT|unix.O_RDWR|unix.O_DIRECT, 0600)
+}
+
+// peerCredentials returns the user id of the process on the other end of
+// the unix socket fd.
+func peerCredentials(fd int) (uint32, error) {
+	cred, err := unix.GetsockoptUcred(fd, unix.SOL_SOCKET, unix.SO_PEERCRED)
+	if err != nil {
+		return 0, err
+	}
+	return cred.Uid, nil
+}
@@ -0,0 +1,121 @@
+//go:build linux || darwin
+// +build linux darwin
+
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package provisioner
+
+import (
+	"os"
+	"syscall"
+
+	"golang.org/x/sys/unix"
+)
+
+// The system calls Linux and macOS share, platform_windows.go stands in for
+// them so that the provisioner builds for Windows.
+
+// setxattr and getxattr can be replaced by tests.
+var (
+	setxattr = unix.Setxattr
+	getxattr = unix.Getxattr
+)
+
+// lockFile takes an exclusive lock of the open file, held until it is
+// closed, or fails with errLocked.
+func lockFile(file *os.File) error {
+	err := unix.Flock(int(file.Fd()), unix.LOCK_EX|unix.LOCK_NB)
+	if err == unix.EWOULDBLOCK {
+		return errLocked
+	}
+	return err
+}
+
+// ioctl issues the ioctl request on the file descriptor.
+func ioctl(fd, request, arg uintptr) error {
+	_, _, errno := unix.Syscall(unix.SYS_IOCTL, fd, request, arg)
+	if errno != 0 {
+		return errno
+	}
+	return nil
+}
+
+// fileOwner returns the user and group owning the file.
+func fileOwner(info os.FileInfo) (uint32, uint32, bool) {
+	stat, ok := info.Sys().(*syscall.Stat_t)
+	if !ok {
+		return 0, 0, false
+	}
+	return stat.Uid, stat.Gid, true
+}
+
+// fileDevice returns the device number of the filesystem the file is on or,
+// with rdev, of the device the file is.
+func fileDevice(path string, rdev bool) (uint32, uint32, error) {
+	var stat unix.Stat_t
+	if err := unix.Stat(path, &stat); err != nil {
+		return 0, 0, err
+	}
+	dev := uint64(stat.Dev)
+	if rdev {
+		dev = uint64(stat.Rdev)
+	}
+	return unix.Major(dev), unix.Minor(dev), nil
+}
+
+// onSameFilesystem returns whether both paths are on the same filesystem.
+func onSameFilesystem(path, otherPath string) (bool, error) {
+	var stat, otherStat unix.Stat_t
+	if err := unix.Stat(path, &stat); err != nil {
+		return false, err
+	}
+	if err := unix.Stat(otherPath, &otherStat); err != nil {
+		return false, err
+	}
+	return stat.Dev == otherStat.Dev, nil
+}
+
+// statFilesystem stats the filesystem of path, discarding the result.
+func statFilesystem(path string) error {
+	return unix.Statfs(path, &unix.Statfs_t{})
+}
+
+func readInotify(fd int, buf []byte) (int, error) {
+	return unix.Read(fd, buf)
+}
+
+func closeInotify(fd int) error {
+	return unix.Close(fd)
+}
+
+// writeDirect creates the file at path and writes a page to it with direct
+// I/O.
+func writeDirect(path string) error {
+	fd, err := openDirect(path)
+	if err != nil {
+		return err
+	}
+	defer unix.Close(fd)
+	// Direct I/O needs aligned buffers, mapped memory is aligned to pages
+	buf, err := unix.Mmap(-1, 0, os.Getpagesize(), unix.PROT_READ|unix.PROT_WRITE, unix.MAP_ANON|unix.MAP_PRIVATE)
+	if err != nil {
+		return err
+	}
+	defer unix.Munmap(buf)
+	_, err = unix.Write(fd, buf)
+	return err
+}
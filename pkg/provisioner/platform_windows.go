@@ -0,0 +1,167 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package provisioner
+
+import (
+	"fmt"
+	"os"
+	"syscall"
+
+	"golang.org/x/sys/windows"
+)
+
+// Windows only has the backend, hostpath volumes on Windows nodes are plain
+// directories. The system calls below stand in for those of Linux so that
+// the provisioner builds: inotify, fscrypt, xattrs, ioctls and peer
+// credentials are unsupported, the features using them fall back or are
+// turned off.
+
+// errNoKey is returned when removing an fscrypt key that is not present,
+// Windows has no fscrypt and never returns it.
+var errNoKey error
+
+// The inotify events and watch flags the tamper watcher uses, with their
+// Linux values
+const (
+	inAttrib    = 0x4
+	inDelete    = 0x200
+	inMovedFrom = 0x40
+	inIgnored   = 0x8000
+	inIsDir     = 0x40000000
+	inOnlyDir   = 0x1000000
+)
+
+// inotifyEventHeader is the fixed size part of an inotify event, followed by
+// Len bytes of name.
+type inotifyEventHeader struct {
+	Wd     int32
+	Mask   uint32
+	Cookie uint32
+	Len    uint32
+}
+
+func inotifyInit() (int, error) {
+	return -1, syscall.ENOSYS
+}
+
+func inotifyAddWatch(fd int, path string, mask uint32) (int, error) {
+	return -1, syscall.ENOSYS
+}
+
+func inotifyRmWatch(fd int, wd uint32) error {
+	return syscall.ENOSYS
+}
+
+func readInotify(fd int, buf []byte) (int, error) {
+	return 0, syscall.ENOSYS
+}
+
+func closeInotify(fd int) error {
+	return syscall.ENOSYS
+}
+
+// allocate allocates the first size bytes of the file without writing them.
+func allocate(fd int, size int64) error {
+	return syscall.EOPNOTSUPP
+}
+
+// punchHole deallocates size bytes of the file at offset, keeping its size.
+func punchHole(fd int, offset, size int64) error {
+	return syscall.EOPNOTSUPP
+}
+
+// writeDirect creates the file at path and writes a page to it with direct
+// I/O.
+func writeDirect(path string) error {
+	return syscall.EOPNOTSUPP
+}
+
+// peerCredentials returns the user id of the process on the other end of
+// the unix socket fd, unknown on Windows so every peer is rejected.
+func peerCredentials(fd int) (uint32, error) {
+	return 0, syscall.ENOSYS
+}
+
+// lockFile takes an exclusive lock of the open file, held until it is
+// closed, or fails with errLocked.
+func lockFile(file *os.File) error {
+	err := windows.LockFileEx(windows.Handle(file.Fd()), windows.LOCKFILE_EXCLUSIVE_LOCK|windows.LOCKFILE_FAIL_IMMEDIATELY, 0, 1, 0, &windows.Overlapped{})
+	if err == windows.ERROR_LOCK_VIOLATION {
+		return errLocked
+	}
+	return err
+}
+
+// ioctl issues the ioctl request on the file descriptor.
+func ioctl(fd, request, arg uintptr) error {
+	return syscall.EOPNOTSUPP
+}
+
+// setxattr and getxattr can be replaced by tests. Windows has no extended
+// attributes, volume identities use their file instead.
+var (
+	setxattr = func(path, attr string, data []byte, flags int) error {
+		return syscall.ENOTSUP
+	}
+	getxattr = func(path, attr string, dest []byte) (int, error) {
+		return 0, syscall.ENOTSUP
+	}
+)
+
+// fileOwner returns the user and group owning the file, Windows files are
+// owned by SIDs instead.
+func fileOwner(info os.FileInfo) (uint32, uint32, bool) {
+	return 0, 0, false
+}
+
+// fileDevice returns the device number of the filesystem the file is on or,
+// with rdev, of the device the file is.
+func fileDevice(path string, rdev bool) (uint32, uint32, error) {
+	return 0, 0, fmt.Errorf("unable to get the device of %s: %w", path, syscall.EOPNOTSUPP)
+}
+
+// onSameFilesystem returns whether both paths are on the same volume.
+func onSameFilesystem(path, otherPath string) (bool, error) {
+	root, err := volumeRoot(path)
+	if err != nil {
+		return false, err
+	}
+	otherRoot, err := volumeRoot(otherPath)
+	if err != nil {
+		return false, err
+	}
+	return root == otherRoot, nil
+}
+
+// volumeRoot returns the root of the volume containing path.
+func volumeRoot(path string) (string, error) {
+	name, err := windows.UTF16PtrFromString(path)
+	if err != nil {
+		return "", err
+	}
+	root := make([]uint16, windows.MAX_PATH+1)
+	if err := windows.GetVolumePathName(name, &root[0], uint32(len(root))); err != nil {
+		return "", &os.PathError{Op: "GetVolumePathName", Path: path, Err: err}
+	}
+	return windows.UTF16ToString(root), nil
+}
+
+// statFilesystem stats the filesystem of path, discarding the result.
+func statFilesystem(path string) error {
+	_, err := windowsBackend{}.Stat(path)
+	return err
+}
@@ -0,0 +1,314 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package provisioner
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"k8s.io/klog/v2"
+
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/client-go/tools/record"
+)
+
+// The capabilities of the filesystem of a pool that features of volumes
+// depend on.
+const (
+	// capabilityReflink files can share extents, duperemove needs them
+	capabilityReflink = "reflink"
+	// capabilityProjectQuotas directories can be limited with project quotas
+	capabilityProjectQuotas = "projectQuotas"
+	// capabilityDirectIO files can be opened with O_DIRECT
+	capabilityDirectIO = "directIO"
+	// capabilityDiscard files can have holes punched into them, so that
+	// discards in image backed volumes free space in the pool
+	capabilityDiscard = "discard"
+	// capabilityXattrs files can have user and trusted extended attributes,
+	// overlay clones keep theirs in trusted ones
+	capabilityXattrs = "xattrs"
+)
+
+// ficlone is the FICLONE ioctl, making a file share the extents of another.
+const ficlone = 0x40049409
+
+// capabilityProbes test the capabilities in a directory of the pool, whose
+// filesystem is mounted as mount. An error tells that the probe could not
+// run, not that the capability is missing. They can be replaced by tests.
+var capabilityProbes = map[string]func(dir string, mount *mountInfo) (bool, error){
+	capabilityReflink:       probeReflink,
+	capabilityProjectQuotas: probeProjectQuotas,
+	capabilityDirectIO:      probeDirectIO,
+	capabilityDiscard:       probeDiscard,
+	capabilityXattrs:        probeXattrs,
+}
+
+// unsupported returns whether the error of a system call tells that the
+// filesystem does not support it.
+func unsupported(err error) bool {
+	for _, errno := range []syscall.Errno{syscall.EOPNOTSUPP, syscall.EINVAL, syscall.ENOTTY, syscall.EXDEV, syscall.EPERM} {
+		if errors.Is(err, errno) {
+			return true
+		}
+	}
+	return false
+}
+
+// probeResult turns the error of a probe's system call into its result.
+func probeResult(err error) (bool, error) {
+	if err == nil {
+		return true, nil
+	}
+	if unsupported(err) {
+		return false, nil
+	}
+	return false, err
+}
+
+// probeFile creates a scratch file of size bytes in dir, the caller removes
+// it.
+func probeFile(dir string, size int) (*os.File, error) {
+	file, err := ioutil.TempFile(dir, ".capability-probe")
+	if err != nil {
+		return nil, err
+	}
+	if _, err := file.Write(make([]byte, size)); err != nil {
+		file.Close()
+		os.Remove(file.Name())
+		return nil, err
+	}
+	return file, nil
+}
+
+func probeReflink(dir string, _ *mountInfo) (bool, error) {
+	source, err := probeFile(dir, 4096)
+	if err != nil {
+		return false, err
+	}
+	defer os.Remove(source.Name())
+	defer source.Close()
+	clone, err := probeFile(dir, 0)
+	if err != nil {
+		return false, err
+	}
+	defer os.Remove(clone.Name())
+	defer clone.Close()
+	if err := ioctl(clone.Fd(), ficlone, source.Fd()); err != nil {
+		return probeResult(err)
+	}
+	return true, nil
+}
+
+// probeProjectQuotas checks the mount options, project quotas are turned on
+// when the filesystem is mounted.
+func probeProjectQuotas(_ string, mount *mountInfo) (bool, error) {
+	if mount == nil {
+		return false, fmt.Errorf("unable to find the mount of the pool")
+	}
+	return mount.hasOption("prjquota") || mount.hasOption("pquota"), nil
+}
+
+func probeDirectIO(dir string, _ *mountInfo) (bool, error) {
+	path := filepath.Join(dir, fmt.Sprintf(".capability-probe-direct-%d", os.Getpid()))
+	defer os.Remove(path)
+	return probeResult(writeDirect(path))
+}
+
+func probeDiscard(dir string, _ *mountInfo) (bool, error) {
+	file, err := probeFile(dir, 2*4096)
+	if err != nil {
+		return false, err
+	}
+	defer os.Remove(file.Name())
+	defer file.Close()
+	if err := file.Sync(); err != nil {
+		return false, err
+	}
+	return probeResult(punchHole(int(file.Fd()), 0, 4096))
+}
+
+func probeXattrs(dir string, _ *mountInfo) (bool, error) {
+	file, err := probeFile(dir, 0)
+	if err != nil {
+		return false, err
+	}
+	defer os.Remove(file.Name())
+	file.Close()
+	for _, name := range []string{"user.kubevirt.io.probe", "trusted.kubevirt.io.probe"} {
+		if err := setxattr(file.Name(), name, []byte("1"), 0); err != nil {
+			return probeResult(err)
+		}
+	}
+	return true, nil
+}
+
+// capabilityProber probes the filesystem of every pool of plain directories
+// once for the capabilities features of volumes depend on, so that those
+// features fail clearly on pools without them instead of midway. Logical
+// volumes and devices bring their own filesystems and are not probed.
+type capabilityProber struct {
+	mountsPath    string
+	nodeRef       *v1.ObjectReference
+	eventRecorder record.EventRecorder
+
+	capability *prometheus.GaugeVec
+
+	mutex sync.Mutex
+	// capabilities of the pools probed so far, by pool name
+	probed map[string]map[string]bool
+}
+
+var _ prometheus.Collector = &capabilityProber{}
+
+func newCapabilityProber(mountsPath, nodeName string, eventRecorder record.EventRecorder) *capabilityProber {
+	return &capabilityProber{
+		mountsPath: mountsPath,
+		nodeRef: &v1.ObjectReference{
+			Kind: "Node",
+			Name: nodeName,
+			UID:  types.UID(nodeName),
+		},
+		eventRecorder: eventRecorder,
+		capability: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Namespace: metricsNamespace,
+			Name:      "pool_capability",
+			Help:      "Whether the filesystem of a pool supports a capability, such as reflink or xattrs.",
+		}, []string{"pool", "capability"}),
+		probed: make(map[string]map[string]bool),
+	}
+}
+
+// Run probes the pools that were not probed yet, pools added later or whose
+// probes failed, every interval until stopCh is closed.
+func (c *capabilityProber) Run(pools func() []*storagePool, interval time.Duration, stopCh <-chan struct{}) {
+	wait.Until(func() {
+		c.probeAll(pools())
+	}, interval, stopCh)
+}
+
+// probeAll probes the pools that were not probed yet.
+func (c *capabilityProber) probeAll(pools []*storagePool) {
+	for _, pool := range pools {
+		c.mutex.Lock()
+		_, done := c.probed[pool.name]
+		c.mutex.Unlock()
+		if done || pool.lvm != nil || pool.devices != nil {
+			continue
+		}
+		if err := c.probe(pool); err != nil {
+			klog.Warningf("unable to probe the capabilities of pool %s, retrying: %v", pool.name, err)
+		}
+	}
+}
+
+// probe probes the capabilities of the pool and warns about those the
+// features configured for the pool need.
+func (c *capabilityProber) probe(pool *storagePool) error {
+	mounts, err := readMounts(c.mountsPath)
+	if err != nil {
+		return err
+	}
+	mount := findMount(mounts, filepath.Clean(pool.path))
+	capabilities := map[string]bool{}
+	for name, probe := range capabilityProbes {
+		supported, err := probe(pool.path, mount)
+		if err != nil {
+			return fmt.Errorf("%s: %v", name, err)
+		}
+		capabilities[name] = supported
+	}
+	c.mutex.Lock()
+	c.probed[pool.name] = capabilities
+	c.mutex.Unlock()
+	for name, supported := range capabilities {
+		value := 0.0
+		if supported {
+			value = 1
+		}
+		c.capability.WithLabelValues(pool.name, name).Set(value)
+	}
+	klog.InfoS("Probed pool capabilities", "node", c.nodeRef.Name, "pool", pool.name, "path", pool.path, "capabilities", supportedCapabilities(capabilities))
+	if pool.dedup.mode == dedupDuperemove && !capabilities[capabilityReflink] {
+		klog.Warningf("pool %s does not support reflinks, it can not be deduplicated with duperemove", pool.name)
+		c.eventRecorder.Eventf(c.nodeRef, v1.EventTypeWarning, "PoolCapabilityMissing", "Hostpath pool %s does not support reflinks, it can not be deduplicated with duperemove", pool.name)
+	}
+	return nil
+}
+
+// supportedCapabilities returns the names of the supported capabilities,
+// sorted.
+func supportedCapabilities(capabilities map[string]bool) []string {
+	names := []string{}
+	for name, supported := range capabilities {
+		if supported {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	return names
+}
+
+// supported returns the capabilities the pool supports, nil when it was not
+// probed.
+func (c *capabilityProber) supported(pool *storagePool) []string {
+	if c == nil {
+		return nil
+	}
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	capabilities, ok := c.probed[pool.name]
+	if !ok {
+		return nil
+	}
+	return supportedCapabilities(capabilities)
+}
+
+// require returns an error when the pool was probed and lacks the capability
+// the feature needs. Pools that were not probed are given the benefit of the
+// doubt.
+func (c *capabilityProber) require(pool *storagePool, capability, feature string) error {
+	if c == nil {
+		return nil
+	}
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	capabilities, ok := c.probed[pool.name]
+	if !ok || capabilities[capability] {
+		return nil
+	}
+	return fmt.Errorf("pool %s on node %s does not support %s, needed for %s", pool.name, c.nodeRef.Name, capability, feature)
+}
+
+// Describe implements prometheus.Collector.
+func (c *capabilityProber) Describe(ch chan<- *prometheus.Desc) {
+	c.capability.Describe(ch)
+}
+
+// Collect implements prometheus.Collector.
+func (c *capabilityProber) Collect(ch chan<- prometheus.Metric) {
+	c.capability.Collect(ch)
+}
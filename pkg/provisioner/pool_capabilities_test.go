@@ -0,0 +1,163 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package provisioner
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"k8s.io/client-go/tools/record"
+)
+
+func Test_probeProjectQuotas(t *testing.T) {
+	tests := []struct {
+		name    string
+		mount   *mountInfo
+		want    bool
+		wantErr bool
+	}{
+		{name: "xfs", mount: &mountInfo{fsType: "xfs", options: []string{"rw", "relatime", "prjquota"}}, want: true},
+		{name: "xfs pquota", mount: &mountInfo{fsType: "xfs", options: []string{"rw", "pquota"}}, want: true},
+		{name: "no quotas", mount: &mountInfo{fsType: "ext4", options: []string{"rw", "usrquota"}}},
+		{name: "no mount", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := probeProjectQuotas("/var/hpvolumes", tt.mount)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("probeProjectQuotas() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("probeProjectQuotas() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_capabilityProbesCleanUp(t *testing.T) {
+	dir, err := ioutil.TempDir("", "capabilities")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	// Whether the filesystem of the test supports the capabilities varies,
+	// the probes must not fail on it nor leave files behind
+	for _, name := range []string{capabilityReflink, capabilityDirectIO, capabilityDiscard, capabilityXattrs} {
+		if _, err := capabilityProbes[name](dir, nil); err != nil {
+			t.Errorf("%s probe failed: %v", name, err)
+		}
+	}
+	if _, err := capabilityProbes[capabilityReflink](filepath.Join(dir, "missing"), nil); err == nil {
+		t.Errorf("probe of a missing directory succeeded")
+	}
+	files, err := ioutil.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(files) > 0 {
+		t.Errorf("probes left %d files behind", len(files))
+	}
+}
+
+func Test_capabilityProber(t *testing.T) {
+	dir, err := ioutil.TempDir("", "capabilities")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	mountsFile := filepath.Join(dir, "mounts")
+	if err := ioutil.WriteFile(mountsFile, []byte("/dev/sdb1 /var/hpvolumes xfs rw,prjquota 0 0\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	failing := map[string]bool{}
+	probes := capabilityProbes
+	defer func() {
+		capabilityProbes = probes
+	}()
+	capabilityProbes = map[string]func(string, *mountInfo) (bool, error){
+		capabilityReflink: func(dir string, _ *mountInfo) (bool, error) {
+			if failing[dir] {
+				return false, fmt.Errorf("no space left on device")
+			}
+			return !strings.HasSuffix(dir, "ext4"), nil
+		},
+		capabilityProjectQuotas: probeProjectQuotas,
+		capabilityXattrs: func(string, *mountInfo) (bool, error) {
+			return true, nil
+		},
+	}
+
+	recorder := record.NewFakeRecorder(10)
+	c := newCapabilityProber(mountsFile, "node01", recorder)
+	xfs := &storagePool{name: "xfs", path: "/var/hpvolumes/xfs"}
+	ext4 := &storagePool{name: "ext4", path: "/var/hpvolumes/ext4", dedup: poolDedup{mode: dedupDuperemove}}
+	full := &storagePool{name: "full", path: "/var/hpvolumes/full"}
+	lvm := &storagePool{name: "lvm", path: "/var/hpvolumes/lvm", lvm: &lvmPool{volumeGroup: "vg0", thinPool: "thin"}}
+	failing[full.path] = true
+	c.probeAll([]*storagePool{xfs, ext4, full, lvm})
+
+	if got, want := c.supported(xfs), []string{capabilityProjectQuotas, capabilityReflink, capabilityXattrs}; !reflect.DeepEqual(got, want) {
+		t.Errorf("supported(xfs) = %v, want %v", got, want)
+	}
+	if got, want := c.supported(ext4), []string{capabilityProjectQuotas, capabilityXattrs}; !reflect.DeepEqual(got, want) {
+		t.Errorf("supported(ext4) = %v, want %v", got, want)
+	}
+	if got := c.supported(full); got != nil {
+		t.Errorf("supported(full) = %v, want nil after a failed probe", got)
+	}
+	if got := c.supported(lvm); got != nil {
+		t.Errorf("supported(lvm) = %v, logical volumes are not probed", got)
+	}
+	if err := c.require(xfs, capabilityReflink, "deduplication"); err != nil {
+		t.Errorf("require() = %v for a supported capability", err)
+	}
+	if err := c.require(ext4, capabilityReflink, "deduplication"); err == nil || !strings.Contains(err.Error(), "reflink") {
+		t.Errorf("require() = %v, want an error naming the capability", err)
+	}
+	if err := c.require(full, capabilityReflink, "deduplication"); err != nil {
+		t.Errorf("require() = %v for a pool that was not probed", err)
+	}
+	var unprobed *capabilityProber
+	if err := unprobed.require(ext4, capabilityReflink, "deduplication"); err != nil || unprobed.supported(ext4) != nil {
+		t.Errorf("a nil prober must not gate anything")
+	}
+	select {
+	case event := <-recorder.Events:
+		if !strings.Contains(event, "PoolCapabilityMissing") || !strings.Contains(event, "ext4") {
+			t.Errorf("unexpected event %q", event)
+		}
+	default:
+		t.Errorf("no event for the duperemove pool without reflinks")
+	}
+
+	// Failed probes are retried
+	failing[full.path] = false
+	c.probeAll([]*storagePool{xfs, ext4, full, lvm})
+	if got := c.supported(full); len(got) == 0 {
+		t.Errorf("supported(full) = %v, want the probe retried", got)
+	}
+	select {
+	case event := <-recorder.Events:
+		t.Errorf("pools probed twice, unexpected event %q", event)
+	default:
+	}
+}
@@ -0,0 +1,210 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package provisioner
+
+import (
+	"io/ioutil"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"k8s.io/klog/v2"
+
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/client-go/tools/record"
+)
+
+// slowProbesBeforeFlagging is the number of slow probes in a row after which
+// a pool is flagged as slow, so that a single hiccup does not raise alarms.
+const slowProbesBeforeFlagging = 3
+
+// latencyProber periodically times statfs and a small synchronous write in
+// every pool. Pools whose latency stays above the threshold are flagged, a
+// degrading disk usually gets slow before it starts corrupting data.
+type latencyProber struct {
+	nodeRef       *v1.ObjectReference
+	eventRecorder record.EventRecorder
+	threshold     time.Duration
+
+	statfsLatency *prometheus.HistogramVec
+	writeLatency  *prometheus.HistogramVec
+	slowPool      *prometheus.GaugeVec
+
+	mutex sync.Mutex
+	// consecutive slow probes per pool name
+	slowProbes map[string]int
+	slow       map[string]bool
+	// average latency of the recent probes per pool name, for QoS tiers
+	average map[string]time.Duration
+}
+
+var _ prometheus.Collector = &latencyProber{}
+
+func newLatencyProber(nodeName string, eventRecorder record.EventRecorder, threshold time.Duration) *latencyProber {
+	labels := []string{"pool", "path"}
+	buckets := []float64{.0005, .001, .0025, .005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10}
+	return &latencyProber{
+		nodeRef: &v1.ObjectReference{
+			Kind: "Node",
+			Name: nodeName,
+			UID:  types.UID(nodeName),
+		},
+		eventRecorder: eventRecorder,
+		threshold:     threshold,
+		statfsLatency: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: metricsNamespace,
+			Name:      "pool_statfs_latency_seconds",
+			Help:      "Latency of statfs on the filesystem backing a pool.",
+			Buckets:   buckets,
+		}, labels),
+		writeLatency: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: metricsNamespace,
+			Name:      "pool_write_latency_seconds",
+			Help:      "Latency of writing and syncing a small file in a pool.",
+			Buckets:   buckets,
+		}, labels),
+		slowPool: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Namespace: metricsNamespace,
+			Name:      "pool_slow",
+			Help:      "Whether the latency of a pool has been above the slow disk threshold for several probes.",
+		}, labels),
+		slowProbes: make(map[string]int),
+		slow:       make(map[string]bool),
+		average:    make(map[string]time.Duration),
+	}
+}
+
+// Run probes all pools every interval until stopCh is closed.
+func (l *latencyProber) Run(pools func() []*storagePool, interval time.Duration, stopCh <-chan struct{}) {
+	wait.Until(func() {
+		for _, pool := range pools() {
+			// The devices of device pools are not written to
+			if pool.devices == nil {
+				l.probe(pool)
+			}
+		}
+	}, interval, stopCh)
+}
+
+// probe measures the latency of the pool and records the result.
+func (l *latencyProber) probe(pool *storagePool) {
+	start := time.Now()
+	if err := statFilesystem(pool.path); err != nil {
+		klog.V(3).Infof("unable to probe latency of pool %s: %v", pool.name, err)
+		return
+	}
+	statfsLatency := time.Since(start)
+	l.statfsLatency.WithLabelValues(pool.name, pool.path).Observe(statfsLatency.Seconds())
+
+	writeLatency, err := timeWrite(pool.path)
+	if err != nil {
+		klog.V(3).Infof("unable to probe write latency of pool %s: %v", pool.name, err)
+		return
+	}
+	l.writeLatency.WithLabelValues(pool.name, pool.path).Observe(writeLatency.Seconds())
+
+	latency := statfsLatency
+	if writeLatency > latency {
+		latency = writeLatency
+	}
+	l.record(pool, latency)
+}
+
+// record updates the slow state of the pool given the latency of a probe.
+func (l *latencyProber) record(pool *storagePool, latency time.Duration) {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	// A moving average, so that a single slow probe does not take a pool
+	// out of a tier
+	if average, ok := l.average[pool.name]; ok {
+		l.average[pool.name] = (4*average + latency) / 5
+	} else {
+		l.average[pool.name] = latency
+	}
+	if latency <= l.threshold {
+		l.slowProbes[pool.name] = 0
+		if l.slow[pool.name] {
+			l.slow[pool.name] = false
+			klog.Infof("pool %s is no longer slow, probe took %v", pool.name, latency)
+			l.eventRecorder.Eventf(l.nodeRef, v1.EventTypeNormal, "PoolLatencyNormal", "Hostpath pool %s responds normally again, probe took %v", pool.name, latency)
+		}
+		l.slowPool.WithLabelValues(pool.name, pool.path).Set(0)
+		return
+	}
+	l.slowProbes[pool.name]++
+	if l.slowProbes[pool.name] >= slowProbesBeforeFlagging && !l.slow[pool.name] {
+		l.slow[pool.name] = true
+		klog.Warningf("pool %s is slow, the last %d probes took over %v", pool.name, l.slowProbes[pool.name], l.threshold)
+		l.eventRecorder.Eventf(l.nodeRef, v1.EventTypeWarning, "PoolSlow", "Hostpath pool %s is slow, the last %d probes took over %v, the disk may be failing", pool.name, l.slowProbes[pool.name], l.threshold)
+	}
+	if l.slow[pool.name] {
+		l.slowPool.WithLabelValues(pool.name, pool.path).Set(1)
+	}
+}
+
+// meets returns whether the average latency of the pool is at most
+// maxLatency. Pools that were not probed yet, and all pools when latency is
+// not probed, meet any latency.
+func (l *latencyProber) meets(pool *storagePool, maxLatency time.Duration) bool {
+	if l == nil || maxLatency == 0 {
+		return true
+	}
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	average, ok := l.average[pool.name]
+	return !ok || average <= maxLatency
+}
+
+// timeWrite measures how long writing and syncing a small file in dir takes.
+func timeWrite(dir string) (time.Duration, error) {
+	data := make([]byte, 4096)
+	start := time.Now()
+	file, err := ioutil.TempFile(dir, ".latency-probe")
+	if err != nil {
+		return 0, err
+	}
+	defer os.Remove(file.Name())
+	if _, err := file.Write(data); err != nil {
+		file.Close()
+		return 0, err
+	}
+	if err := file.Sync(); err != nil {
+		file.Close()
+		return 0, err
+	}
+	if err := file.Close(); err != nil {
+		return 0, err
+	}
+	return time.Since(start), nil
+}
+
+// Describe implements prometheus.Collector.
+func (l *latencyProber) Describe(ch chan<- *prometheus.Desc) {
+	l.statfsLatency.Describe(ch)
+	l.writeLatency.Describe(ch)
+	l.slowPool.Describe(ch)
+}
+
+// Collect implements prometheus.Collector.
+func (l *latencyProber) Collect(ch chan<- prometheus.Metric) {
+	l.statfsLatency.Collect(ch)
+	l.writeLatency.Collect(ch)
+	l.slowPool.Collect(ch)
+}
@@ -0,0 +1,106 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package provisioner
+
+import (
+	"io/ioutil"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"k8s.io/client-go/tools/record"
+)
+
+func Test_timeWrite(t *testing.T) {
+	dir, err := ioutil.TempDir("", "latency")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	if _, err := timeWrite(dir); err != nil {
+		t.Errorf("timeWrite() error = %v", err)
+	}
+	files, _ := ioutil.ReadDir(dir)
+	if len(files) != 0 {
+		t.Errorf("timeWrite() left %d files behind", len(files))
+	}
+}
+
+func Test_latencyProberRecord(t *testing.T) {
+	recorder := record.NewFakeRecorder(10)
+	l := newLatencyProber("test-node", recorder, 100*time.Millisecond)
+	pool := &storagePool{name: "ssd", path: "/var/hpvolumes/ssd"}
+
+	steps := []struct {
+		latency    time.Duration
+		wantSlow   bool
+		wantReason string
+	}{
+		{latency: time.Millisecond},
+		{latency: time.Second},
+		{latency: time.Second},
+		{latency: time.Second, wantSlow: true, wantReason: "PoolSlow"},
+		{latency: time.Second, wantSlow: true},
+		{latency: time.Millisecond, wantReason: "PoolLatencyNormal"},
+		{latency: time.Second},
+	}
+	for i, step := range steps {
+		l.record(pool, step.latency)
+		if l.slow[pool.name] != step.wantSlow {
+			t.Errorf("step %d: slow = %v, want %v", i, l.slow[pool.name], step.wantSlow)
+		}
+		select {
+		case event := <-recorder.Events:
+			if step.wantReason == "" || !strings.Contains(event, step.wantReason) {
+				t.Errorf("step %d: unexpected event %q, want reason %q", i, event, step.wantReason)
+			}
+		default:
+			if step.wantReason != "" {
+				t.Errorf("step %d: no event, want reason %q", i, step.wantReason)
+			}
+		}
+	}
+}
+
+func Test_latencyProberMeets(t *testing.T) {
+	pool := &storagePool{name: "ssd", path: "/var/hpvolumes/ssd"}
+	var unprobed *latencyProber
+	if !unprobed.meets(pool, time.Millisecond) {
+		t.Errorf("meets() = false without probes")
+	}
+	l := newLatencyProber("test-node", record.NewFakeRecorder(10), time.Second)
+	if !l.meets(pool, time.Millisecond) {
+		t.Errorf("meets() = false before the first probe")
+	}
+	l.record(pool, 5*time.Millisecond)
+	if l.meets(pool, time.Millisecond) || !l.meets(pool, 10*time.Millisecond) || !l.meets(pool, 0) {
+		t.Errorf("meets() does not compare with the probed latency")
+	}
+	// A single slow probe is averaged out
+	l.record(pool, 30*time.Millisecond)
+	if !l.meets(pool, 10*time.Millisecond) {
+		t.Errorf("meets() = false after a single slow probe, average %v", l.average[pool.name])
+	}
+	for i := 0; i < 10; i++ {
+		l.record(pool, 30*time.Millisecond)
+	}
+	if l.meets(pool, 10*time.Millisecond) {
+		t.Errorf("meets() = true after slow probes, average %v", l.average[pool.name])
+	}
+}
@@ -0,0 +1,159 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package provisioner
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"k8s.io/klog/v2"
+
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	"k8s.io/apimachinery/pkg/util/wait"
+)
+
+// poolCollector exports the capacity of every pool, so dashboards can map
+// directly to the disks of a node. Filesystem statistics are read on every
+// scrape, the storage reserved by claims is refreshed periodically.
+type poolCollector struct {
+	p *HostPathProvisioner
+
+	capacityBytes *prometheus.Desc
+	freeBytes     *prometheus.Desc
+	usedBytes     *prometheus.Desc
+	reservedBytes *prometheus.Desc
+	inodes        *prometheus.Desc
+	inodesFree    *prometheus.Desc
+	policy        *prometheus.Desc
+
+	mutex    sync.Mutex
+	reserved map[string]int64
+}
+
+var _ prometheus.Collector = &poolCollector{}
+
+func newPoolCollector(p *HostPathProvisioner) *poolCollector {
+	labels := []string{"pool", "path"}
+	desc := func(name, help string, labels []string) *prometheus.Desc {
+		return prometheus.NewDesc(prometheus.BuildFQName(metricsNamespace, "pool", name), help, labels, nil)
+	}
+	return &poolCollector{
+		p:             p,
+		capacityBytes: desc("capacity_bytes", "Size of the filesystem backing the pool.", labels),
+		freeBytes:     desc("free_bytes", "Space available for new volumes in the pool.", labels),
+		usedBytes:     desc("used_bytes", "Space used in the filesystem backing the pool.", labels),
+		reservedBytes: desc("reserved_bytes", "Storage requested by the claims of the volumes in the pool.", labels),
+		inodes:        desc("inodes", "Number of inodes in the filesystem backing the pool.", labels),
+		inodesFree:    desc("inodes_free", "Number of free inodes in the filesystem backing the pool.", labels),
+		policy:        prometheus.NewDesc(prometheus.BuildFQName(metricsNamespace, "", "pool_selection_policy_info"), "Default pool selection policy of the provisioner.", []string{"policy"}, nil),
+		reserved:      make(map[string]int64),
+	}
+}
+
+// Run refreshes the reserved storage of the pools every interval until stopCh
+// is closed.
+func (c *poolCollector) Run(interval time.Duration, stopCh <-chan struct{}) {
+	wait.Until(c.refreshReserved, interval, stopCh)
+}
+
+func (c *poolCollector) refreshReserved() {
+	if c.p.allocations != nil {
+		reserved, err := c.p.allocations.reserved()
+		if err != nil {
+			klog.Errorf("unable to read the allocation database for pool metrics: %v", err)
+			return
+		}
+		c.mutex.Lock()
+		defer c.mutex.Unlock()
+		c.reserved = reserved
+		return
+	}
+	pvs, err := c.p.pvCache.list(context.TODO(), c.p.client)
+	if err != nil {
+		klog.Errorf("unable to list persistent volumes for pool metrics: %v", err)
+		return
+	}
+	reserved := calculatePoolReserved(pvs.Items, c.p.identity, c.p.nodeName)
+
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.reserved = reserved
+}
+
+// calculatePoolReserved sums up, per pool, the storage requested by the
+// claims of the volumes on this node.
+func calculatePoolReserved(pvs []v1.PersistentVolume, identity, nodeName string) map[string]int64 {
+	reserved := make(map[string]int64)
+	for i := range pvs {
+		annotations := volumeAnnotations(&pvs[i])
+		if annotations["hostPathProvisionerIdentity"] != identity || annotations["kubevirt.io/provisionOnNode"] != nodeName {
+			continue
+		}
+		requested, ok := annotations[annRequestedCapacity]
+		if !ok {
+			continue
+		}
+		size, err := resource.ParseQuantity(requested)
+		if err != nil {
+			continue
+		}
+		pool := annotations[annStoragePool]
+		if pool == "" {
+			pool = defaultPoolName
+		}
+		reserved[pool] += size.Value()
+	}
+	return reserved
+}
+
+// Describe implements prometheus.Collector.
+func (c *poolCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- c.capacityBytes
+	ch <- c.freeBytes
+	ch <- c.usedBytes
+	ch <- c.reservedBytes
+	ch <- c.inodes
+	ch <- c.inodesFree
+	ch <- c.policy
+}
+
+// Collect implements prometheus.Collector.
+func (c *poolCollector) Collect(ch chan<- prometheus.Metric) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	ch <- prometheus.MustNewConstMetric(c.policy, prometheus.GaugeValue, 1, c.p.defaultPoolPolicy())
+	for _, pool := range c.p.currentPools() {
+		gauge := func(desc *prometheus.Desc, value int64) {
+			ch <- prometheus.MustNewConstMetric(desc, prometheus.GaugeValue, float64(value), pool.name, pool.path)
+		}
+		gauge(c.reservedBytes, c.reserved[pool.name])
+
+		stats, err := c.p.host.Stat(pool.path)
+		if err != nil {
+			klog.V(3).Infof("unable to stat pool %s for metrics: %v", pool.name, err)
+			continue
+		}
+		gauge(c.capacityBytes, stats.capacity)
+		gauge(c.freeBytes, stats.available)
+		gauge(c.usedBytes, stats.used())
+		gauge(c.inodes, stats.inodes)
+		gauge(c.inodesFree, stats.inodesFree)
+	}
+}
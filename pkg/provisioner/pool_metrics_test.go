@@ -0,0 +1,56 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package provisioner
+
+import (
+	"reflect"
+	"testing"
+
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func Test_calculatePoolReserved(t *testing.T) {
+	pv := func(name, node, pool, requested string) v1.PersistentVolume {
+		annotations := map[string]string{
+			"hostPathProvisionerIdentity": "identity",
+			"kubevirt.io/provisionOnNode": node,
+		}
+		if pool != "" {
+			annotations[annStoragePool] = pool
+		}
+		if requested != "" {
+			annotations[annRequestedCapacity] = requested
+		}
+		return v1.PersistentVolume{ObjectMeta: metav1.ObjectMeta{Name: name, Annotations: annotations}}
+	}
+	pvs := []v1.PersistentVolume{
+		pv("pv1", "node1", "ssd", "1Gi"),
+		pv("pv2", "node1", "ssd", "2Gi"),
+		pv("pv3", "node1", "", "1Mi"),
+		pv("pv4", "node2", "ssd", "5Gi"),
+		pv("pv5", "node1", "hdd", ""),
+	}
+	// Volumes of the CSI driver keep their annotations in the volume context
+	csi := pv("pv6", "node1", "hdd", "4Gi")
+	csi.Spec.HostPath = &v1.HostPathVolumeSource{Path: "/var/hpvolumes/pv6"}
+	pvs = append(pvs, *csiVolume(defaultCSIDriverName, &csi))
+	want := map[string]int64{"ssd": 3 * GiB, "hdd": 4 * GiB, defaultPoolName: MiB}
+	if got := calculatePoolReserved(pvs, "identity", "node1"); !reflect.DeepEqual(got, want) {
+		t.Errorf("calculatePoolReserved() = %v, want %v", got, want)
+	}
+}
@@ -0,0 +1,156 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package provisioner
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"k8s.io/klog/v2"
+
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/client-go/tools/record"
+)
+
+const defaultMountCheckInterval = 10 * time.Second
+
+// poolMonitor notices when the filesystem backing a pool goes away, e.g.
+// because the disk was unmounted, and pauses provisioning into the pool until
+// the filesystem returns. Without it volumes would silently be created on the
+// filesystem underneath the mount point.
+type poolMonitor struct {
+	mountsPath    string
+	nodeRef       *v1.ObjectReference
+	eventRecorder record.EventRecorder
+
+	mutex sync.Mutex
+	// mount each pool was on when the provisioner started, or when the pool
+	// was added, keyed by pool name
+	expected map[string]mountInfo
+	// reasons pools are paused for, keyed by pool name
+	paused map[string]string
+}
+
+func newPoolMonitor(pools []*storagePool, mountsPath, nodeName string, eventRecorder record.EventRecorder) (*poolMonitor, error) {
+	mounts, err := readMounts(mountsPath)
+	if err != nil {
+		return nil, err
+	}
+	m := &poolMonitor{
+		mountsPath: mountsPath,
+		nodeRef: &v1.ObjectReference{
+			Kind: "Node",
+			Name: nodeName,
+			UID:  types.UID(nodeName),
+		},
+		eventRecorder: eventRecorder,
+		expected:      make(map[string]mountInfo),
+		paused:        make(map[string]string),
+	}
+	for _, pool := range pools {
+		if err := m.expect(pool, mounts); err != nil {
+			return nil, err
+		}
+	}
+	return m, nil
+}
+
+// add starts monitoring a pool added after start up.
+func (m *poolMonitor) add(pool *storagePool) error {
+	mounts, err := readMounts(m.mountsPath)
+	if err != nil {
+		return err
+	}
+	return m.expect(pool, mounts)
+}
+
+// expect records the mount the pool is on as the one it has to stay on.
+func (m *poolMonitor) expect(pool *storagePool, mounts []mountInfo) error {
+	mount := findMount(mounts, filepath.Clean(pool.path))
+	if mount == nil {
+		return fmt.Errorf("unable to find the mount of pool %s at %s", pool.name, pool.path)
+	}
+	klog.Infof("pool %s at %s is on %s mounted at %s", pool.name, pool.path, mount.device, mount.mountPoint)
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	m.expected[pool.name] = *mount
+	return nil
+}
+
+// Run checks all pools every interval until stopCh is closed.
+func (m *poolMonitor) Run(pools func() []*storagePool, interval time.Duration, stopCh <-chan struct{}) {
+	wait.Until(func() {
+		for _, pool := range pools() {
+			m.check(pool)
+		}
+	}, interval, stopCh)
+}
+
+// check verifies that the pool is still on the filesystem it was on at start
+// up, updates the pool's state and returns whether it can be provisioned into.
+func (m *poolMonitor) check(pool *storagePool) bool {
+	reason := m.verify(pool)
+
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	previous, wasPaused := m.paused[pool.name]
+	if reason != "" {
+		m.paused[pool.name] = reason
+		if !wasPaused || previous != reason {
+			klog.Errorf("pausing provisioning into pool %s: %s", pool.name, reason)
+			m.eventRecorder.Eventf(m.nodeRef, v1.EventTypeWarning, "PoolUnavailable", "Provisioning into hostpath pool %s paused: %s", pool.name, reason)
+		}
+		return false
+	}
+	if wasPaused {
+		delete(m.paused, pool.name)
+		klog.Infof("resuming provisioning into pool %s", pool.name)
+		m.eventRecorder.Eventf(m.nodeRef, v1.EventTypeNormal, "PoolAvailable", "Provisioning into hostpath pool %s resumed", pool.name)
+	}
+	return true
+}
+
+// verify returns why the pool is unusable, or an empty string if it is fine.
+func (m *poolMonitor) verify(pool *storagePool) string {
+	if _, err := os.Stat(pool.path); err != nil {
+		return fmt.Sprintf("pool directory %s is not accessible: %v", pool.path, err)
+	}
+	mounts, err := readMounts(m.mountsPath)
+	if err != nil {
+		return fmt.Sprintf("unable to read mounts: %v", err)
+	}
+	m.mutex.Lock()
+	expected := m.expected[pool.name]
+	m.mutex.Unlock()
+	mount := findMount(mounts, filepath.Clean(pool.path))
+	if mount == nil || mount.mountPoint != expected.mountPoint || mount.device != expected.device {
+		return fmt.Sprintf("%s is no longer mounted at %s", expected.device, expected.mountPoint)
+	}
+	return ""
+}
+
+// ready returns whether all pools can be provisioned into.
+func (m *poolMonitor) ready() bool {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	return len(m.paused) == 0
+}
@@ -0,0 +1,82 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package provisioner
+
+import (
+	"io/ioutil"
+	"os"
+	"strings"
+	"testing"
+
+	"k8s.io/client-go/tools/record"
+)
+
+func Test_poolMonitor(t *testing.T) {
+	dir, err := ioutil.TempDir("", "pool")
+	if err != nil {
+		t.Fatalf("Unable to create temporary directory, error = %v", err)
+	}
+	defer os.RemoveAll(dir)
+	mountsFile := dir + "/mounts"
+	poolDir := dir + "/pool"
+	if err := os.Mkdir(poolDir, 0755); err != nil {
+		t.Fatalf("Unable to create pool directory, error = %v", err)
+	}
+	writeMounts := func(content string) {
+		if err := ioutil.WriteFile(mountsFile, []byte(content), 0644); err != nil {
+			t.Fatalf("Unable to write mounts, error = %v", err)
+		}
+	}
+	mounted := "/dev/sda1 / ext4 rw 0 0\n/dev/sdb1 " + poolDir + " xfs rw 0 0\n"
+	writeMounts(mounted)
+
+	pool := &storagePool{name: "default", path: poolDir}
+	recorder := record.NewFakeRecorder(10)
+	m, err := newPoolMonitor([]*storagePool{pool}, mountsFile, "testNode", recorder)
+	if err != nil {
+		t.Fatalf("newPoolMonitor() error = %v", err)
+	}
+	if !m.check(pool) || !m.ready() {
+		t.Errorf("pool not available while mounted")
+	}
+
+	writeMounts("/dev/sda1 / ext4 rw 0 0\n")
+	if m.check(pool) || m.ready() {
+		t.Errorf("pool available after being unmounted")
+	}
+	// A second failed check must not emit another event.
+	m.check(pool)
+	if event := <-recorder.Events; !strings.Contains(event, "PoolUnavailable") {
+		t.Errorf("unexpected event %q", event)
+	}
+
+	writeMounts(mounted)
+	if !m.check(pool) || !m.ready() {
+		t.Errorf("pool not available after being mounted again")
+	}
+	if event := <-recorder.Events; !strings.Contains(event, "PoolAvailable") {
+		t.Errorf("unexpected event %q", event)
+	}
+	if len(recorder.Events) != 0 {
+		t.Errorf("unexpected additional events")
+	}
+
+	os.Remove(poolDir)
+	if m.check(pool) {
+		t.Errorf("pool available after its directory was removed")
+	}
+}
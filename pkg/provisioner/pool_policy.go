@@ -0,0 +1,152 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package provisioner
+
+import (
+	"context"
+	"fmt"
+	"sync"
+
+	v1 "k8s.io/api/core/v1"
+)
+
+const (
+	// poolPolicyParameter is the StorageClass parameter that overrides the
+	// provisioner's default pool selection policy.
+	poolPolicyParameter = "poolSelectionPolicy"
+
+	policyMostFree     = "most-free"
+	policyRoundRobin   = "round-robin"
+	policyLeastVolumes = "least-volumes"
+
+	defaultPoolPolicy = policyMostFree
+)
+
+// poolSelectionPolicy picks the pool a volume is placed in out of the pools
+// that are able to hold it. candidates is never empty.
+type poolSelectionPolicy interface {
+	choose(p *HostPathProvisioner, candidates []poolCandidate) (poolCandidate, error)
+}
+
+var poolSelectionPolicies = map[string]poolSelectionPolicy{
+	policyMostFree:     mostFreePolicy{},
+	policyRoundRobin:   &roundRobinPolicy{},
+	policyLeastVolumes: leastVolumesPolicy{},
+}
+
+// lookupPoolPolicy returns the policy registered under name.
+func lookupPoolPolicy(name string) (poolSelectionPolicy, error) {
+	policy, ok := poolSelectionPolicies[name]
+	if !ok {
+		return nil, fmt.Errorf("unknown pool selection policy %q", name)
+	}
+	return policy, nil
+}
+
+// mostFreePolicy places volumes in the pool with the most available space.
+type mostFreePolicy struct{}
+
+func (mostFreePolicy) choose(p *HostPathProvisioner, candidates []poolCandidate) (poolCandidate, error) {
+	best := candidates[0]
+	var bestFree int64 = -1
+	for _, candidate := range candidates {
+		free, err := poolFree(p.host, candidate.pool)
+		if err != nil {
+			return poolCandidate{}, err
+		}
+		if free > bestFree {
+			best = candidate
+			bestFree = free
+		}
+	}
+	return best, nil
+}
+
+// roundRobinPolicy cycles through the pools in the order they are configured.
+type roundRobinPolicy struct {
+	mutex sync.Mutex
+	next  int
+}
+
+func (r *roundRobinPolicy) choose(p *HostPathProvisioner, candidates []poolCandidate) (poolCandidate, error) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	// Walk the configured pools starting at the next one in line, so that the
+	// rotation is not disturbed by pools that are temporarily too small.
+	pools := p.currentPools()
+	for i := 0; i < len(pools); i++ {
+		pool := pools[(r.next+i)%len(pools)]
+		for _, candidate := range candidates {
+			if candidate.pool == pool {
+				r.next = (r.next + i + 1) % len(pools)
+				return candidate, nil
+			}
+		}
+	}
+	return candidates[0], nil
+}
+
+// leastVolumesPolicy places volumes in the pool holding the fewest volumes
+// created by this provisioner on the node.
+type leastVolumesPolicy struct{}
+
+func (leastVolumesPolicy) choose(p *HostPathProvisioner, candidates []poolCandidate) (poolCandidate, error) {
+	pvs, err := p.pvCache.list(context.TODO(), p.client)
+	if err != nil {
+		return poolCandidate{}, fmt.Errorf("unable to list persistent volumes for pool selection: %v", err)
+	}
+	return leastUsedCandidates(candidates, countPoolVolumes(pvs.Items, p.identity, p.nodeName))[0], nil
+}
+
+// countPoolVolumes counts the volumes on this node per pool.
+func countPoolVolumes(pvs []v1.PersistentVolume, identity, nodeName string) map[string]int {
+	counts := make(map[string]int)
+	for i := range pvs {
+		annotations := volumeAnnotations(&pvs[i])
+		if annotations["hostPathProvisionerIdentity"] != identity || annotations["kubevirt.io/provisionOnNode"] != nodeName {
+			continue
+		}
+		pool := annotations[annStoragePool]
+		if pool == "" {
+			pool = defaultPoolName
+		}
+		counts[pool]++
+	}
+	return counts
+}
+
+// poolFree returns the number of bytes available for volumes in the pool.
+func poolFree(host backend, pool *storagePool) (int64, error) {
+	if pool.lvm != nil && pool.lvm.thinPool == "" {
+		return pool.lvm.thickCapacity()
+	}
+	if pool.lvm != nil {
+		_, free, err := pool.lvm.usage()
+		return free, err
+	}
+	return calculatePoolFree(host, pool.path)
+}
+
+// calculatePoolFree returns the number of bytes available to unprivileged
+// users in the filesystem containing path.
+func calculatePoolFree(host backend, path string) (int64, error) {
+	stats, err := host.Stat(path)
+	if err != nil {
+		return 0, err
+	}
+	return stats.available, nil
+}
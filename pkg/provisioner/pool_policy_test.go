@@ -0,0 +1,89 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package provisioner
+
+import (
+	"reflect"
+	"testing"
+
+	v1 "k8s.io/api/core/v1"
+)
+
+func Test_lookupPoolPolicy(t *testing.T) {
+	for _, name := range []string{policyMostFree, policyRoundRobin, policyLeastVolumes} {
+		if _, err := lookupPoolPolicy(name); err != nil {
+			t.Errorf("lookupPoolPolicy(%s) error = %v", name, err)
+		}
+	}
+	if _, err := lookupPoolPolicy("random"); err == nil {
+		t.Errorf("lookupPoolPolicy(random) did not return an error")
+	}
+}
+
+func Test_roundRobinPolicy(t *testing.T) {
+	a := &storagePool{name: "a"}
+	b := &storagePool{name: "b"}
+	c := &storagePool{name: "c"}
+	p := &HostPathProvisioner{pools: []*storagePool{a, b, c}}
+	all := []poolCandidate{{pool: a}, {pool: b}, {pool: c}}
+	withoutB := []poolCandidate{{pool: a}, {pool: c}}
+
+	policy := &roundRobinPolicy{}
+	var got []string
+	for _, candidates := range [][]poolCandidate{all, all, all, all, withoutB, all} {
+		chosen, err := policy.choose(p, candidates)
+		if err != nil {
+			t.Fatalf("roundRobinPolicy.choose() error = %v", err)
+		}
+		got = append(got, chosen.pool.name)
+	}
+	want := []string{"a", "b", "c", "a", "c", "a"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("roundRobinPolicy order = %v, want %v", got, want)
+	}
+}
+
+func Test_mostFreePolicy(t *testing.T) {
+	p := &HostPathProvisioner{host: hostBackend()}
+	// Both pools live on the same filesystem, so the first one wins the tie.
+	candidates := []poolCandidate{
+		{pool: &storagePool{name: "a", path: "."}},
+		{pool: &storagePool{name: "b", path: "."}},
+	}
+	chosen, err := mostFreePolicy{}.choose(p, candidates)
+	if err != nil || chosen.pool.name != "a" {
+		t.Errorf("mostFreePolicy.choose() = %v, %v, want pool a", chosen.pool, err)
+	}
+
+	candidates = append(candidates, poolCandidate{pool: &storagePool{name: "c", path: "/doesntexist"}})
+	if _, err := (mostFreePolicy{}).choose(p, candidates); err == nil {
+		t.Errorf("mostFreePolicy.choose() did not return an error for a missing pool")
+	}
+}
+
+func Test_countPoolVolumes(t *testing.T) {
+	pvs := []v1.PersistentVolume{
+		createPoolPv("pv1", "testNode", "ns1", "a", "ssd"),
+		createPoolPv("pv2", "testNode", "ns2", "b", "ssd"),
+		createPoolPv("pv3", "testNode", "ns1", "c", ""),
+		createPoolPv("pv4", "otherNode", "ns1", "d", "hdd"),
+	}
+	want := map[string]int{"ssd": 2, defaultPoolName: 1}
+	if got := countPoolVolumes(pvs, "testId", "testNode"); !reflect.DeepEqual(got, want) {
+		t.Errorf("countPoolVolumes() = %v, want %v", got, want)
+	}
+}
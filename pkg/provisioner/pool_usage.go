@@ -0,0 +1,221 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package provisioner
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"k8s.io/klog/v2"
+
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/tools/record"
+	"k8s.io/client-go/util/retry"
+)
+
+// poolUsageCondition is the node condition set while a pool is above one of
+// the usage thresholds.
+const poolUsageCondition v1.NodeConditionType = "HostpathPoolUsageHigh"
+
+// parseUsageThresholds parses a comma separated list of percentages, e.g.
+// 80,90,95, into a sorted list.
+func parseUsageThresholds(spec string) ([]int, error) {
+	var thresholds []int
+	for _, entry := range strings.Split(spec, ",") {
+		entry = strings.TrimSpace(strings.TrimSuffix(strings.TrimSpace(entry), "%"))
+		if entry == "" {
+			continue
+		}
+		threshold, err := strconv.Atoi(entry)
+		if err != nil || threshold <= 0 || threshold > 100 {
+			return nil, fmt.Errorf("invalid usage threshold %q, expected a percentage between 1 and 100", entry)
+		}
+		thresholds = append(thresholds, threshold)
+	}
+	sort.Ints(thresholds)
+	return thresholds, nil
+}
+
+// usagePercent returns how full the filesystem described by statfs is, in
+// percent, computed like df does.
+func usagePercent(stats filesystemStats) int {
+	used := stats.used()
+	total := used + stats.available
+	if total == 0 {
+		return 0
+	}
+	// round up, like df
+	return int((used*100 + total - 1) / total)
+}
+
+// exceededThreshold returns the highest threshold usage is at or above, or 0
+// if it is below all of them.
+func exceededThreshold(thresholds []int, usage int) int {
+	exceeded := 0
+	for _, threshold := range thresholds {
+		if usage >= threshold {
+			exceeded = threshold
+		}
+	}
+	return exceeded
+}
+
+// poolUsageWatcher emits node events when the usage of a pool crosses one of
+// the thresholds, and optionally maintains a node condition, so that alerting
+// catches filling disks before writes start failing.
+type poolUsageWatcher struct {
+	client        kubernetes.Interface
+	host          backend
+	nodeName      string
+	nodeRef       *v1.ObjectReference
+	eventRecorder record.EventRecorder
+	setCondition  bool
+
+	mutex      sync.Mutex
+	thresholds []int
+
+	// highest threshold exceeded per pool name, only accessed from Run
+	exceeded map[string]int
+	// whether the node condition is currently true, nil when unknown
+	conditionSet *bool
+}
+
+func newPoolUsageWatcher(client kubernetes.Interface, host backend, nodeName string, eventRecorder record.EventRecorder, thresholds []int, setCondition bool) *poolUsageWatcher {
+	return &poolUsageWatcher{
+		client:   client,
+		host:     host,
+		nodeName: nodeName,
+		nodeRef: &v1.ObjectReference{
+			Kind: "Node",
+			Name: nodeName,
+			UID:  types.UID(nodeName),
+		},
+		eventRecorder: eventRecorder,
+		thresholds:    thresholds,
+		setCondition:  setCondition,
+		exceeded:      make(map[string]int),
+	}
+}
+
+// Run checks the usage of all pools every interval until stopCh is closed.
+func (w *poolUsageWatcher) Run(pools func() []*storagePool, interval time.Duration, stopCh <-chan struct{}) {
+	wait.Until(func() {
+		var full []string
+		for _, pool := range pools() {
+			stats, err := w.host.Stat(pool.path)
+			if err != nil {
+				klog.V(3).Infof("unable to stat pool %s for usage thresholds: %v", pool.name, err)
+				continue
+			}
+			if threshold := w.update(pool, usagePercent(stats)); threshold > 0 {
+				full = append(full, fmt.Sprintf("%s above %d%%", pool.name, threshold))
+			}
+		}
+		if w.setCondition {
+			w.updateCondition(full)
+		}
+	}, interval, stopCh)
+}
+
+// setThresholds replaces the usage thresholds, they apply from the next
+// check on.
+func (w *poolUsageWatcher) setThresholds(thresholds []int) {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+	w.thresholds = thresholds
+}
+
+// update records the usage of the pool, emitting an event when it crossed a
+// threshold, and returns the highest threshold exceeded.
+func (w *poolUsageWatcher) update(pool *storagePool, usage int) int {
+	w.mutex.Lock()
+	threshold := exceededThreshold(w.thresholds, usage)
+	w.mutex.Unlock()
+	previous := w.exceeded[pool.name]
+	w.exceeded[pool.name] = threshold
+	switch {
+	case threshold > previous:
+		klog.Warningf("pool %s is %d%% full, above the %d%% threshold", pool.name, usage, threshold)
+		w.eventRecorder.Eventf(w.nodeRef, v1.EventTypeWarning, "PoolUsageHigh", "Hostpath pool %s is %d%% full, above the %d%% threshold", pool.name, usage, threshold)
+	case threshold < previous && threshold > 0:
+		w.eventRecorder.Eventf(w.nodeRef, v1.EventTypeNormal, "PoolUsageDecreased", "Hostpath pool %s is %d%% full, below the %d%% threshold", pool.name, usage, previous)
+	case threshold < previous:
+		w.eventRecorder.Eventf(w.nodeRef, v1.EventTypeNormal, "PoolUsageNormal", "Hostpath pool %s is %d%% full, below all usage thresholds", pool.name, usage)
+	}
+	return threshold
+}
+
+// updateCondition sets the node condition to reflect the pools above a
+// threshold, the API is only called when the condition changes.
+func (w *poolUsageWatcher) updateCondition(full []string) {
+	status := len(full) > 0
+	if w.conditionSet != nil && *w.conditionSet == status {
+		return
+	}
+	condition := v1.NodeCondition{
+		Type:    poolUsageCondition,
+		Status:  v1.ConditionFalse,
+		Reason:  "PoolUsageNormal",
+		Message: "All hostpath pools are below their usage thresholds",
+	}
+	if status {
+		condition.Status = v1.ConditionTrue
+		condition.Reason = "PoolUsageHigh"
+		condition.Message = "Hostpath pools " + strings.Join(full, ", ")
+	}
+	err := retry.RetryOnConflict(retry.DefaultRetry, func() error {
+		node, err := w.client.CoreV1().Nodes().Get(context.TODO(), w.nodeName, metav1.GetOptions{})
+		if err != nil {
+			return err
+		}
+		setNodeCondition(node, condition, metav1.Now())
+		_, err = w.client.CoreV1().Nodes().UpdateStatus(context.TODO(), node, metav1.UpdateOptions{})
+		return err
+	})
+	if err != nil {
+		klog.Errorf("unable to set condition %s on node %s: %v", poolUsageCondition, w.nodeName, err)
+		return
+	}
+	w.conditionSet = &status
+}
+
+// setNodeCondition adds or replaces the condition of the same type on node.
+func setNodeCondition(node *v1.Node, condition v1.NodeCondition, now metav1.Time) {
+	condition.LastHeartbeatTime = now
+	condition.LastTransitionTime = now
+	for i := range node.Status.Conditions {
+		existing := &node.Status.Conditions[i]
+		if existing.Type != condition.Type {
+			continue
+		}
+		if existing.Status == condition.Status {
+			condition.LastTransitionTime = existing.LastTransitionTime
+		}
+		*existing = condition
+		return
+	}
+	node.Status.Conditions = append(node.Status.Conditions, condition)
+}
@@ -0,0 +1,125 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package provisioner
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/tools/record"
+)
+
+func Test_parseUsageThresholds(t *testing.T) {
+	tests := []struct {
+		name    string
+		spec    string
+		want    []int
+		wantErr bool
+	}{
+		{name: "empty", spec: "", want: nil},
+		{name: "sorted", spec: "95, 80%,90", want: []int{80, 90, 95}},
+		{name: "not a number", spec: "80,high", wantErr: true},
+		{name: "out of range", spec: "120", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := parseUsageThresholds(tt.spec)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("parseUsageThresholds() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("parseUsageThresholds() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_usagePercent(t *testing.T) {
+	// 100 blocks, 5 reserved for root, 45 free for users: 50 used out of 95
+	stats := filesystemStats{capacity: 100, free: 50, available: 45}
+	if got := usagePercent(stats); got != 53 {
+		t.Errorf("usagePercent() = %d, want 53", got)
+	}
+	if got := usagePercent(filesystemStats{}); got != 0 {
+		t.Errorf("usagePercent() = %d for an empty filesystem, want 0", got)
+	}
+}
+
+func Test_poolUsageWatcherUpdate(t *testing.T) {
+	recorder := record.NewFakeRecorder(10)
+	w := newPoolUsageWatcher(nil, hostBackend(), "test-node", recorder, []int{80, 90, 95}, false)
+	pool := &storagePool{name: "ssd"}
+
+	steps := []struct {
+		usage      int
+		want       int
+		wantReason string
+	}{
+		{usage: 50, want: 0},
+		{usage: 85, want: 80, wantReason: "PoolUsageHigh"},
+		{usage: 87, want: 80},
+		{usage: 96, want: 95, wantReason: "PoolUsageHigh"},
+		{usage: 91, want: 90, wantReason: "PoolUsageDecreased"},
+		{usage: 40, want: 0, wantReason: "PoolUsageNormal"},
+	}
+	for _, step := range steps {
+		if got := w.update(pool, step.usage); got != step.want {
+			t.Errorf("update(%d) = %d, want %d", step.usage, got, step.want)
+		}
+		select {
+		case event := <-recorder.Events:
+			if step.wantReason == "" || !strings.Contains(event, step.wantReason) {
+				t.Errorf("update(%d) emitted %q, want reason %q", step.usage, event, step.wantReason)
+			}
+		default:
+			if step.wantReason != "" {
+				t.Errorf("update(%d) emitted no event, want reason %q", step.usage, step.wantReason)
+			}
+		}
+	}
+}
+
+func Test_setNodeCondition(t *testing.T) {
+	before := metav1.NewTime(time.Date(2019, 1, 1, 0, 0, 0, 0, time.UTC))
+	now := metav1.NewTime(time.Date(2019, 1, 2, 0, 0, 0, 0, time.UTC))
+	node := &v1.Node{}
+	node.Status.Conditions = []v1.NodeCondition{
+		{Type: v1.NodeReady, Status: v1.ConditionTrue},
+		{Type: poolUsageCondition, Status: v1.ConditionTrue, LastTransitionTime: before},
+	}
+
+	setNodeCondition(node, v1.NodeCondition{Type: poolUsageCondition, Status: v1.ConditionTrue, Reason: "PoolUsageHigh"}, now)
+	got := node.Status.Conditions[1]
+	if len(node.Status.Conditions) != 2 || got.Reason != "PoolUsageHigh" || got.LastTransitionTime != before || got.LastHeartbeatTime != now {
+		t.Errorf("setNodeCondition() with unchanged status = %+v", node.Status.Conditions)
+	}
+
+	setNodeCondition(node, v1.NodeCondition{Type: poolUsageCondition, Status: v1.ConditionFalse}, now)
+	if got := node.Status.Conditions[1]; got.Status != v1.ConditionFalse || got.LastTransitionTime != now {
+		t.Errorf("setNodeCondition() with changed status = %+v", got)
+	}
+
+	node.Status.Conditions = node.Status.Conditions[:1]
+	setNodeCondition(node, v1.NodeCondition{Type: poolUsageCondition, Status: v1.ConditionTrue}, now)
+	if len(node.Status.Conditions) != 2 {
+		t.Errorf("setNodeCondition() did not add the condition: %+v", node.Status.Conditions)
+	}
+}
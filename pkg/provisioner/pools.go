@@ -0,0 +1,355 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package provisioner
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"strings"
+
+	"k8s.io/klog/v2"
+
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	"sigs.k8s.io/sig-storage-lib-external-provisioner/v13/controller"
+)
+
+const (
+	// annStoragePool records the name of the pool a volume was placed in.
+	annStoragePool = "kubevirt.io/storagePool"
+	// annPoolSelectionPolicy records the policy that chose the pool.
+	annPoolSelectionPolicy = "kubevirt.io/poolSelectionPolicy"
+
+	defaultPoolName = "default"
+)
+
+// statefulSetClaimName matches the names the StatefulSet controller gives to
+// claims created from volumeClaimTemplates: <template>-<statefulset>-<ordinal>
+var statefulSetClaimName = regexp.MustCompile(`^(.+)-[0-9]+$`)
+
+// storagePool is a directory on the node that volumes can be placed in,
+// normally the mount point of a dedicated disk.
+type storagePool struct {
+	name string
+	path string
+	// device optionally is the device expected to be mounted at path
+	device string
+	// deviceClass optionally is the kind of disk behind the pool, nvme, ssd
+	// or hdd
+	deviceClass string
+	// lvm is the thin pool volumes are created in, nil for pools of plain
+	// directories
+	lvm *lvmPool
+	// devices are the block devices in path handed out whole to block
+	// claims, nil for pools of directories
+	devices *devicePool
+	// dedup is how the pool is deduplicated, if at all
+	dedup poolDedup
+	// cache is the SSD caching the thin pool, if any
+	cache poolCache
+}
+
+// parsePools returns the pools described by spec, a comma separated list of
+// name=path pairs. When spec is empty pvDir is the only pool.
+func parsePools(pvDir, spec string) ([]*storagePool, error) {
+	if strings.TrimSpace(spec) == "" {
+		if pvDir == "" {
+			return nil, fmt.Errorf("no storage pools configured")
+		}
+		return []*storagePool{{name: defaultPoolName, path: pvDir}}, nil
+	}
+	pools := []*storagePool{}
+	names := map[string]bool{}
+	for _, entry := range strings.Split(spec, ",") {
+		parts := strings.SplitN(strings.TrimSpace(entry), "=", 2)
+		if len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+			return nil, fmt.Errorf("invalid storage pool %q, expected name=path", entry)
+		}
+		if names[parts[0]] {
+			return nil, fmt.Errorf("duplicate storage pool name %q", parts[0])
+		}
+		names[parts[0]] = true
+		pools = append(pools, &storagePool{name: parts[0], path: parts[1]})
+	}
+	return pools, nil
+}
+
+// noPoolError is returned when no pool can hold a claim, as opposed to the
+// claim or its class being invalid.
+type noPoolError struct {
+	error
+}
+
+// poolCandidate is a pool that is large enough for a claim.
+type poolCandidate struct {
+	pool     *storagePool
+	capacity *resource.Quantity
+}
+
+// candidatePools returns the usable pools supporting the allocation whose
+// capacity, rounded as given, can hold the requested size.
+func (p *HostPathProvisioner) candidatePools(requested resource.Quantity, rounding capacityRounding, allocation string) ([]poolCandidate, error) {
+	var candidates []poolCandidate
+	var lastErr error
+	// The allocation database knows the storage the volumes in directory
+	// pools were given, which the filesystem does not limit them to
+	var reserved map[string]int64
+	if p.allocations != nil {
+		var err error
+		if reserved, err = p.allocations.reserved(); err != nil {
+			return nil, fmt.Errorf("unable to read the allocation database: %v", err)
+		}
+	}
+	for _, pool := range p.currentPools() {
+		if !pool.supportsAllocation(allocation) {
+			continue
+		}
+		if !p.checkRootfs(pool) || !p.checkMount(pool) {
+			continue
+		}
+		if p.monitor != nil && !p.monitor.check(pool) {
+			continue
+		}
+		if !p.deviceHealth.usable(pool) || !p.caches.usable(pool) {
+			continue
+		}
+		if p.overrides.draining(pool.name) {
+			klog.V(3).InfoS("Pool is being drained", "node", p.nodeName, "pool", pool.name)
+			continue
+		}
+		capacity, err := p.poolCapacity(pool, rounding, allocation)
+		if err != nil {
+			klog.ErrorS(err, "Unable to determine pool capacity", "node", p.nodeName, "pool", pool.name, "path", pool.path)
+			lastErr = err
+			continue
+		}
+		if capacity.Cmp(requested) < 0 {
+			klog.V(3).InfoS("Pool too small for request", "node", p.nodeName, "pool", pool.name, "capacity", capacity, "requested", requested.String())
+			continue
+		}
+		if reserved != nil && pool.devices == nil && pool.lvm == nil {
+			free := capacity.DeepCopy()
+			free.Sub(*resource.NewQuantity(reserved[pool.name], resource.BinarySI))
+			if free.Cmp(requested) < 0 {
+				klog.V(3).InfoS("Pool's capacity is reserved by its volumes", "node", p.nodeName, "pool", pool.name, "capacity", capacity, "reserved", reserved[pool.name], "requested", requested.String())
+				continue
+			}
+		}
+		if reserve := p.overrides.reserve(pool.name); !reserve.IsZero() && !fitsWithReserve(p.host, pool, requested, reserve) {
+			klog.V(3).InfoS("Pool too full for request with the space reserved", "node", p.nodeName, "pool", pool.name, "reserve", reserve.String(), "requested", requested.String())
+			continue
+		}
+		candidates = append(candidates, poolCandidate{pool: pool, capacity: capacity})
+	}
+	if len(candidates) == 0 && lastErr != nil {
+		return nil, lastErr
+	}
+	return candidates, nil
+}
+
+// poolCapacity returns the capacity of the pool for volumes allocated as
+// given, rounded as given. For LVM pools that is the size of the thin pool
+// or, for thick volumes, exactly the space free in the volume group, the size
+// of their filesystem is irrelevant.
+func (p *HostPathProvisioner) poolCapacity(pool *storagePool, rounding capacityRounding, allocation string) (*resource.Quantity, error) {
+	if pool.devices != nil {
+		// Devices are handed out whole, the capacity is the largest free one
+		free, err := p.claimedDevices.largestFree(pool)
+		if err != nil {
+			return nil, err
+		}
+		return resource.NewQuantity(free, resource.BinarySI), nil
+	}
+	if pool.lvm != nil {
+		if pool.lvm.allocation(allocation) == lvmThick {
+			free, err := pool.lvm.thickCapacity()
+			if err != nil {
+				return nil, err
+			}
+			return resource.NewQuantity(free, resource.BinarySI), nil
+		}
+		size, _, err := pool.lvm.usage()
+		if err != nil {
+			return nil, err
+		}
+		return rounding.round(size), nil
+	}
+	return calculateRoundedPvCapacity(p.host, pool.path, rounding)
+}
+
+// statefulSetClaimPrefix returns the name shared by all claims created from
+// the same volumeClaimTemplate of a StatefulSet, i.e. the claim name without
+// the replica ordinal.
+func statefulSetClaimPrefix(pvc *v1.PersistentVolumeClaim) (string, bool) {
+	match := statefulSetClaimName.FindStringSubmatch(pvc.Name)
+	if match == nil {
+		return "", false
+	}
+	return match[1], true
+}
+
+// countSiblingVolumes counts, per pool, the volumes on this node that belong
+// to claims of the same StatefulSet.
+func countSiblingVolumes(pvs []v1.PersistentVolume, identity, nodeName, namespace, prefix string) map[string]int {
+	counts := make(map[string]int)
+	for i := range pvs {
+		pv := &pvs[i]
+		if pv.Spec.ClaimRef == nil || pv.Spec.ClaimRef.Namespace != namespace {
+			continue
+		}
+		annotations := volumeAnnotations(pv)
+		if annotations["hostPathProvisionerIdentity"] != identity || annotations["kubevirt.io/provisionOnNode"] != nodeName {
+			continue
+		}
+		match := statefulSetClaimName.FindStringSubmatch(pv.Spec.ClaimRef.Name)
+		if match == nil || match[1] != prefix {
+			continue
+		}
+		pool := annotations[annStoragePool]
+		if pool == "" {
+			pool = defaultPoolName
+		}
+		counts[pool]++
+	}
+	return counts
+}
+
+// spreadCandidates narrows the candidates down to the pools holding the fewest
+// volumes of the claim's StatefulSet, so replicas end up on different disks.
+func (p *HostPathProvisioner) spreadCandidates(pvc *v1.PersistentVolumeClaim, candidates []poolCandidate) []poolCandidate {
+	if len(candidates) < 2 || p.client == nil {
+		return candidates
+	}
+	prefix, ok := statefulSetClaimPrefix(pvc)
+	if !ok {
+		return candidates
+	}
+	pvs, err := p.pvCache.list(context.TODO(), p.client)
+	if err != nil {
+		klog.Warningf("unable to list persistent volumes, not spreading claim %s/%s: %v", pvc.Namespace, pvc.Name, err)
+		return candidates
+	}
+	counts := countSiblingVolumes(pvs.Items, p.identity, p.nodeName, pvc.Namespace, prefix)
+	return leastUsedCandidates(candidates, counts)
+}
+
+// leastUsedCandidates returns the candidates with the lowest count.
+func leastUsedCandidates(candidates []poolCandidate, counts map[string]int) []poolCandidate {
+	var result []poolCandidate
+	min := -1
+	for _, candidate := range candidates {
+		count := counts[candidate.pool.name]
+		if min == -1 || count < min {
+			min = count
+			result = nil
+		}
+		if count == min {
+			result = append(result, candidate)
+		}
+	}
+	return result
+}
+
+// selectPool picks the pool the claim's volume is placed in, using the policy
+// requested by the StorageClass or the provisioner's default policy. The name
+// of the policy that made the decision is returned along with the pool.
+func (p *HostPathProvisioner) selectPool(options controller.ProvisionOptions) (*storagePool, *resource.Quantity, string, error) {
+	policyName := p.defaultPoolPolicy()
+	if options.StorageClass != nil {
+		if name, ok := options.StorageClass.Parameters[poolPolicyParameter]; ok {
+			policyName = name
+		}
+	}
+	policy, err := lookupPoolPolicy(policyName)
+	if err != nil {
+		return nil, nil, "", err
+	}
+
+	rounding, err := p.roundingFor(options)
+	if err != nil {
+		return nil, nil, "", err
+	}
+	allocation, err := lvmAllocationFor(options)
+	if err != nil {
+		return nil, nil, "", err
+	}
+	backing, err := backingFor(options)
+	if err != nil {
+		return nil, nil, "", err
+	}
+	if isBlockClaim(options.PVC) {
+		if allocation != "" || backing != backingDirectory {
+			return nil, nil, "", fmt.Errorf("block claims are given whole devices, storage class %s can not set %s or %s", options.StorageClass.Name, lvmAllocationParameter, backingParameter)
+		}
+		allocation = allocationDevice
+	}
+	if backing != backingDirectory && allocation != "" {
+		return nil, nil, "", fmt.Errorf("storage class %s asks for %s backed volumes, which are not logical volumes and can not set %s", options.StorageClass.Name, backing, lvmAllocationParameter)
+	}
+
+	requested := options.PVC.Spec.Resources.Requests[v1.ResourceName(v1.ResourceStorage)]
+	needed := requested
+	if backing == backingTmpfs {
+		// tmpfs volumes take memory, not space in the pool
+		needed = resource.Quantity{}
+	}
+	tier, err := p.qosTierFor(options)
+	if err != nil {
+		return nil, nil, "", err
+	}
+	classes, err := deviceClassesFor(options)
+	if err != nil {
+		return nil, nil, "", err
+	}
+	if len(classes) == 0 && tier != nil {
+		classes = tier.deviceClasses
+	}
+	candidates, err := p.candidatePools(needed, rounding, allocation)
+	if err != nil {
+		return nil, nil, "", err
+	}
+	if backing != backingDirectory {
+		candidates = imageCandidates(candidates)
+	}
+	candidates = tierCandidates(classCandidates(candidates, classes), tier, p.latency)
+	if len(candidates) == 0 {
+		if tier != nil {
+			return nil, nil, "", noPoolError{fmt.Errorf("no storage pool on node %s meets QoS tier %s and can hold a volume of %s", p.nodeName, tier.name, requested.String())}
+		}
+		if len(classes) > 0 {
+			return nil, nil, "", noPoolError{fmt.Errorf("no storage pool of device class %s on node %s can hold a volume of %s", strings.Join(classes, " or "), p.nodeName, requested.String())}
+		}
+		if allocation == allocationDevice {
+			return nil, nil, "", noPoolError{fmt.Errorf("no device pool on node %s has a free device of %s", p.nodeName, requested.String())}
+		}
+		if allocation != "" {
+			return nil, nil, "", noPoolError{fmt.Errorf("no LVM pool on node %s can hold a %s volume of %s", p.nodeName, allocation, requested.String())}
+		}
+		return nil, nil, "", noPoolError{fmt.Errorf("no storage pool on node %s can hold a volume of %s", p.nodeName, requested.String())}
+	}
+	candidates = p.spreadCandidates(options.PVC, candidates)
+	if len(candidates) == 1 {
+		return candidates[0].pool, candidates[0].capacity, policyName, nil
+	}
+	chosen, err := policy.choose(p, candidates)
+	if err != nil {
+		return nil, nil, "", err
+	}
+	klog.V(3).InfoS("Selected pool", "pvc", options.PVC.Namespace+"/"+options.PVC.Name, "node", p.nodeName, "pool", chosen.pool.name, "policy", policyName)
+	return chosen.pool, chosen.capacity, policyName, nil
+}
@@ -0,0 +1,167 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package provisioner
+
+import (
+	"reflect"
+	"testing"
+
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func createPoolPv(name, nodeName, namespace, claimName, pool string) v1.PersistentVolume {
+	pv := createQuotaPv(name, "testId", nodeName, namespace, "1Gi")
+	pv.Spec.ClaimRef.Name = claimName
+	if pool != "" {
+		pv.Annotations[annStoragePool] = pool
+	}
+	return pv
+}
+
+func Test_parsePools(t *testing.T) {
+	tests := []struct {
+		name    string
+		pvDir   string
+		spec    string
+		want    []*storagePool
+		wantErr bool
+	}{
+		{
+			name:  "PV_DIR only",
+			pvDir: "/var/hpvolumes",
+			want:  []*storagePool{{name: defaultPoolName, path: "/var/hpvolumes"}},
+		},
+		{
+			name:  "multiple pools",
+			pvDir: "/var/hpvolumes",
+			spec:  "ssd=/mnt/ssd, hdd=/mnt/hdd",
+			want: []*storagePool{
+				{name: "ssd", path: "/mnt/ssd"},
+				{name: "hdd", path: "/mnt/hdd"},
+			},
+		},
+		{
+			name:    "missing path",
+			spec:    "ssd=",
+			wantErr: true,
+		},
+		{
+			name:    "duplicate name",
+			spec:    "ssd=/mnt/ssd,ssd=/mnt/ssd2",
+			wantErr: true,
+		},
+		{
+			name:    "nothing configured",
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := parsePools(tt.pvDir, tt.spec)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("parsePools() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if err == nil && !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("parsePools() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_statefulSetClaimPrefix(t *testing.T) {
+	tests := []struct {
+		name      string
+		claimName string
+		want      string
+		wantOk    bool
+	}{
+		{
+			name:      "statefulset claim",
+			claimName: "data-db-2",
+			want:      "data-db",
+			wantOk:    true,
+		},
+		{
+			name:      "regular claim",
+			claimName: "data",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			pvc := &v1.PersistentVolumeClaim{ObjectMeta: metav1.ObjectMeta{Name: tt.claimName}}
+			got, ok := statefulSetClaimPrefix(pvc)
+			if got != tt.want || ok != tt.wantOk {
+				t.Errorf("statefulSetClaimPrefix() = %v, %v, want %v, %v", got, ok, tt.want, tt.wantOk)
+			}
+		})
+	}
+}
+
+func Test_countSiblingVolumes(t *testing.T) {
+	pvs := []v1.PersistentVolume{
+		createPoolPv("pv1", "testNode", "ns", "data-db-0", "ssd"),
+		createPoolPv("pv2", "testNode", "ns", "data-db-1", "ssd"),
+		createPoolPv("pv3", "testNode", "ns", "data-db-2", "hdd"),
+		createPoolPv("pv4", "testNode", "ns", "data-db-3", ""),
+		createPoolPv("pv5", "testNode", "ns", "data-web-0", "hdd"),
+		createPoolPv("pv6", "testNode", "other", "data-db-0", "hdd"),
+		createPoolPv("pv7", "otherNode", "ns", "data-db-4", "hdd"),
+	}
+	want := map[string]int{"ssd": 2, "hdd": 1, defaultPoolName: 1}
+	got := countSiblingVolumes(pvs, "testId", "testNode", "ns", "data-db")
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("countSiblingVolumes() = %v, want %v", got, want)
+	}
+}
+
+func Test_leastUsedCandidates(t *testing.T) {
+	ssd := poolCandidate{pool: &storagePool{name: "ssd"}}
+	hdd := poolCandidate{pool: &storagePool{name: "hdd"}}
+	nvme := poolCandidate{pool: &storagePool{name: "nvme"}}
+	candidates := []poolCandidate{ssd, hdd, nvme}
+
+	tests := []struct {
+		name   string
+		counts map[string]int
+		want   []poolCandidate
+	}{
+		{
+			name:   "no siblings",
+			counts: map[string]int{},
+			want:   candidates,
+		},
+		{
+			name:   "prefers empty pools",
+			counts: map[string]int{"ssd": 1},
+			want:   []poolCandidate{hdd, nvme},
+		},
+		{
+			name:   "picks least used pool",
+			counts: map[string]int{"ssd": 2, "hdd": 1, "nvme": 3},
+			want:   []poolCandidate{hdd},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := leastUsedCandidates(candidates, tt.counts); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("leastUsedCandidates() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,46 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package provisioner
+
+import (
+	"net"
+	"net/http"
+	"net/http/pprof"
+	"strconv"
+
+	"k8s.io/klog/v2"
+)
+
+// servePprof serves the pprof endpoints, and /debug/verbosity to change the
+// log verbosity, on localhost only. They can be reached with kubectl
+// port-forward.
+func servePprof(port int) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/debug/pprof/", pprof.Index)
+	mux.HandleFunc("/debug/pprof/cmdline", pprof.Cmdline)
+	mux.HandleFunc("/debug/pprof/profile", pprof.Profile)
+	mux.HandleFunc("/debug/pprof/symbol", pprof.Symbol)
+	mux.HandleFunc("/debug/pprof/trace", pprof.Trace)
+	mux.HandleFunc("/debug/verbosity", verbosityHandler)
+	address := net.JoinHostPort("127.0.0.1", strconv.Itoa(port))
+	klog.Infof("Starting pprof server at %s", address)
+	go func() {
+		if err := http.ListenAndServe(address, mux); err != nil {
+			klog.Errorf("Failed to serve pprof on %s: %v", address, err)
+		}
+	}()
+}
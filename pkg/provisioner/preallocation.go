@@ -0,0 +1,113 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package provisioner
+
+import (
+	"fmt"
+	"os"
+	"syscall"
+
+	"sigs.k8s.io/sig-storage-lib-external-provisioner/v13/controller"
+)
+
+const (
+	// annPreallocation records how the image of a volume was preallocated.
+	annPreallocation = "kubevirt.io/preallocation"
+
+	// preallocationParameter is the StorageClass parameter choosing whether
+	// the images of image backed volumes are allocated up front.
+	preallocationParameter = "preallocation"
+	// preallocationOff images are sparse, space is taken as it is written.
+	preallocationOff = "off"
+	// preallocationFull images are fully allocated when they are created, so
+	// that first writes are not slowed down by allocations and writing to
+	// them never fails for lack of space.
+	preallocationFull = "full"
+	// preallocationMetadata qcow2 images have their metadata allocated.
+	preallocationMetadata = "metadata"
+	// preallocationFalloc qcow2 images are fully allocated without writing
+	// their data.
+	preallocationFalloc = "falloc"
+)
+
+// preallocationFor returns how the StorageClass asks for images to be
+// allocated. Only image and qcow2 backed volumes can be preallocated, LVM
+// pools have thick logical volumes instead. qemu-img can preallocate just the
+// metadata of qcow2 images, or allocate them without writing them.
+func preallocationFor(options controller.ProvisionOptions, backing string) (string, error) {
+	if options.StorageClass == nil {
+		return preallocationOff, nil
+	}
+	preallocation, ok := options.StorageClass.Parameters[preallocationParameter]
+	switch {
+	case preallocation == "" || preallocation == preallocationOff:
+		return preallocationOff, nil
+	case backing == backingQcow2 && (preallocation == preallocationMetadata || preallocation == preallocationFalloc):
+	case preallocation != preallocationFull:
+		return "", fmt.Errorf("invalid %s %q in storage class %s, expected %s or %s", preallocationParameter, preallocation, options.StorageClass.Name, preallocationOff, preallocationFull)
+	}
+	if ok && backing != backingImage && backing != backingQcow2 {
+		return "", fmt.Errorf("storage class %s sets %s, which only applies to image and qcow2 backed volumes, use %s: %s for logical volumes", options.StorageClass.Name, preallocationParameter, lvmAllocationParameter, lvmThick)
+	}
+	return preallocation, nil
+}
+
+// nodiscardOptions returns the mkfs options keeping mkfs from discarding the
+// blocks of the device, which would punch holes into a preallocated image.
+func nodiscardOptions(fsType string) []string {
+	switch fsType {
+	case "ext4":
+		return []string{"-E", "nodiscard"}
+	case "xfs", "btrfs":
+		return []string{"-K"}
+	}
+	return nil
+}
+
+// preallocateImage allocates all size bytes of the image file. Filesystems
+// that can not allocate space without writing it get zeroes written instead.
+func preallocateImage(image string, size int64) error {
+	file, err := os.OpenFile(image, os.O_WRONLY, 0)
+	if err != nil {
+		return err
+	}
+	err = allocate(int(file.Fd()), size)
+	if err == syscall.EOPNOTSUPP {
+		err = zeroFile(file, size)
+	}
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
+
+// zeroFile writes size bytes of zeroes to the file.
+func zeroFile(file *os.File, size int64) error {
+	zeroes := make([]byte, MiB)
+	for written := int64(0); written < size; {
+		chunk := zeroes
+		if size-written < int64(len(chunk)) {
+			chunk = chunk[:size-written]
+		}
+		n, err := file.WriteAt(chunk, written)
+		if err != nil {
+			return err
+		}
+		written += int64(n)
+	}
+	return file.Sync()
+}
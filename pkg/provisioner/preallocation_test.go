@@ -0,0 +1,117 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package provisioner
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	storagev1 "k8s.io/api/storage/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/sig-storage-lib-external-provisioner/v13/controller"
+)
+
+func Test_preallocationFor(t *testing.T) {
+	class := func(preallocation string) *storagev1.StorageClass {
+		return &storagev1.StorageClass{ObjectMeta: metav1.ObjectMeta{Name: "hostpath"}, Parameters: map[string]string{preallocationParameter: preallocation}}
+	}
+	tests := []struct {
+		name    string
+		class   *storagev1.StorageClass
+		backing string
+		want    string
+		wantErr bool
+	}{
+		{name: "no class", backing: backingImage, want: preallocationOff},
+		{name: "not set", class: &storagev1.StorageClass{}, backing: backingDirectory, want: preallocationOff},
+		{name: "off", class: class("off"), backing: backingDirectory, want: preallocationOff},
+		{name: "full", class: class("full"), backing: backingImage, want: preallocationFull},
+		{name: "full directory", class: class("full"), backing: backingDirectory, wantErr: true},
+		{name: "full tmpfs", class: class("full"), backing: backingTmpfs, wantErr: true},
+		{name: "metadata image", class: class("metadata"), backing: backingImage, wantErr: true},
+		{name: "metadata qcow2", class: class("metadata"), backing: backingQcow2, want: preallocationMetadata},
+		{name: "falloc qcow2", class: class("falloc"), backing: backingQcow2, want: preallocationFalloc},
+		{name: "full qcow2", class: class("full"), backing: backingQcow2, want: preallocationFull},
+		{name: "invalid", class: class("sparse"), backing: backingQcow2, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := preallocationFor(controller.ProvisionOptions{StorageClass: tt.class}, tt.backing)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("preallocationFor() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("preallocationFor() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+// allocatedBytes returns the space allocated to the file on disk.
+func allocatedBytes(t *testing.T, path string) int64 {
+	bytes, _, err := hostBackend().Usage(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return bytes
+}
+
+func Test_preallocateImage(t *testing.T) {
+	dir, err := ioutil.TempDir("", "preallocation")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	image := filepath.Join(dir, "pvc-1.img")
+	if err := createImage(image, 4*MiB); err != nil {
+		t.Fatal(err)
+	}
+	if err := preallocateImage(image, 4*MiB); err != nil {
+		t.Fatal(err)
+	}
+	if allocated := allocatedBytes(t, image); allocated < 4*MiB {
+		t.Errorf("allocated %d bytes, want %d", allocated, 4*MiB)
+	}
+
+	// Writing zeroes allocates the file too
+	zeroed := filepath.Join(dir, "pvc-2.img")
+	if err := createImage(zeroed, 3*MiB+512); err != nil {
+		t.Fatal(err)
+	}
+	file, err := os.OpenFile(zeroed, os.O_WRONLY, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+	if err := zeroFile(file, 3*MiB+512); err != nil {
+		t.Fatal(err)
+	}
+	if allocated := allocatedBytes(t, zeroed); allocated < 3*MiB+512 {
+		t.Errorf("allocated %d bytes, want %d", allocated, 3*MiB+512)
+	}
+}
+
+func Test_nodiscardOptions(t *testing.T) {
+	for fsType, want := range map[string]string{"ext4": "-E nodiscard", "xfs": "-K", "btrfs": "-K"} {
+		if got := strings.Join(nodiscardOptions(fsType), " "); got != want {
+			t.Errorf("nodiscardOptions(%s) = %q, want %q", fsType, got, want)
+		}
+	}
+}
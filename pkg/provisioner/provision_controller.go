@@ -0,0 +1,566 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package provisioner
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"runtime/debug"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"k8s.io/klog/v2"
+	"kubevirt.io/hostpath-provisioner/admin"
+	"sigs.k8s.io/sig-storage-lib-external-provisioner/v13/controller"
+	"sigs.k8s.io/sig-storage-lib-external-provisioner/v13/controller/metrics"
+
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/tools/cache"
+	"k8s.io/client-go/util/workqueue"
+)
+
+const (
+	// controllerSubsystem is the subsystem of the controller's metrics
+	controllerSubsystem = "controller"
+	// workCheckInterval is how often a controller shutting down, or exiting
+	// after a panic, checks whether the work in progress has finished
+	workCheckInterval = 100 * time.Millisecond
+)
+
+// controllerQueues keeps the depths of the controller's queues. The
+// workqueue metrics provider is process wide and only set once, the queues
+// of every controller created in the process are counted in it.
+var controllerQueues = newQueueDepths()
+
+// errStopping is the reason the claims and volumes the workers take once
+// the controller is stopping are ignored: they are picked up again by the
+// informers' initial sync after the restart.
+var errStopping = &controller.IgnoredError{Reason: "the provisioner is stopping"}
+
+// provisionController runs the provision controller of
+// sig-storage-lib-external-provisioner for the provisioner, as it is. What
+// the provisioner adds to it goes through the controller's options and
+// interfaces: the claim informer the controller gets hands it claims through
+// the claim queue, the provisioner it gets wraps the HostPathProvisioner to
+// recover from panics, to keep track of the work in progress for the health
+// check and the shutdown, and to tell the HostPathProvisioner once the PV of
+// a volume it provisioned is saved, which the volume informer the controller
+// gets sees. The controller only runs on the replica that took over the node,
+// its metrics and those of the queues are served by every replica.
+type provisionController struct {
+	p           *HostPathProvisioner
+	client      kubernetes.Interface
+	name        string
+	identity    string
+	options     []func(*controller.ProvisionController) error
+	exitOnPanic bool
+
+	claims         *claimQueue
+	claimInformer  cache.SharedIndexInformer
+	volumeInformer cache.SharedInformer
+	metrics        metrics.Metrics
+	queues         *queueDepths
+	panics         *prometheus.CounterVec
+
+	mutex sync.Mutex
+	// volumeHandler is the controller's handler of the volume informer, nil
+	// until the controller runs
+	volumeHandler cache.ResourceEventHandler
+	// provisioned are the volumes provisioned whose PV was not seen yet
+	provisioned map[string]bool
+	// work maps the claims and volumes being processed to when that
+	// started
+	work map[string]time.Time
+	// stopping is set once the workers stop taking new work, see Shutdown
+	stopping bool
+}
+
+var _ controller.Provisioner = &provisionController{}
+var _ controller.Qualifier = &provisionController{}
+var _ controller.BlockProvisioner = &provisionController{}
+
+// newProvisionController returns the provision controller of the provisioner
+// with the options, which name and identity it runs under. It gets the
+// claims, volumes and storage classes from the informers. workers is the
+// number of claims provisioned concurrently.
+func newProvisionController(p *HostPathProvisioner, client kubernetes.Interface, name, identity string, workers int, exitOnPanic bool,
+	claims cache.SharedIndexInformer, volumes, classes cache.SharedInformer, options ...func(*controller.ProvisionController) error) *provisionController {
+	c := &provisionController{
+		p:              p,
+		client:         client,
+		name:           name,
+		identity:       identity,
+		exitOnPanic:    exitOnPanic,
+		claims:         newClaimQueue(p.claimOfInterest, workers),
+		claimInformer:  claims,
+		volumeInformer: volumes,
+		metrics:        metrics.New(controllerSubsystem),
+		queues:         controllerQueues,
+		panics: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Subsystem: controllerSubsystem,
+			Name:      "worker_panics_total",
+			Help:      "Total number of panics recovered from while processing claims and volumes. Broken down by queue.",
+		}, []string{"queue"}),
+		provisioned: make(map[string]bool),
+		work:        make(map[string]time.Time),
+	}
+	c.options = append(options,
+		controller.LeaderElection(false),
+		controller.MetricsInstance(c.metrics),
+		controller.ClaimsInformer(c.claims.informer(claims)),
+		controller.VolumesInformer(&volumeInformer{SharedInformer: volumes, controller: c}),
+		controller.ClassesInformer(classes))
+	workqueue.SetProvider(controllerQueues)
+	return c
+}
+
+// run runs the controller until ctx is done.
+func (c *provisionController) run(ctx context.Context) {
+	pc := controller.NewProvisionController(ctx, c.client, c.name, c, c.options...)
+	go c.claims.run(ctx.Done())
+	pc.Run(ctx)
+}
+
+// Identity returns the identity of the replica in the leader election.
+func (c *provisionController) Identity() string {
+	return c.identity
+}
+
+// Leader returns the identity of the replica processing claims and volumes,
+// once it is this one, an empty string while it is not known.
+func (c *provisionController) Leader() string {
+	if c.p.isLeading() {
+		return c.identity
+	}
+	return ""
+}
+
+// ShouldProvision implements the controller's Qualifier interface.
+func (c *provisionController) ShouldProvision(ctx context.Context, claim *v1.PersistentVolumeClaim) bool {
+	c.claims.taken(string(claim.UID))
+	if c.p.ShouldProvision(ctx, claim) {
+		return true
+	}
+	c.claims.done(string(claim.UID))
+	return false
+}
+
+// Provision implements the controller's Provisioner interface.
+func (c *provisionController) Provision(ctx context.Context, options controller.ProvisionOptions) (pv *v1.PersistentVolume, state controller.ProvisioningState, err error) {
+	key := "claim/" + options.PVC.Namespace + "/" + options.PVC.Name
+	state = controller.ProvisioningFinished
+	defer c.recoverPanic("claim", key, &err)
+	if !c.startWork(key) {
+		return nil, state, errStopping
+	}
+	defer c.finishWork(key)
+	pv, state, err = c.p.Provision(ctx, options)
+	if err == nil {
+		c.claims.done(string(options.PVC.UID))
+		c.mutex.Lock()
+		c.provisioned[pv.Name] = true
+		c.mutex.Unlock()
+	}
+	return pv, state, err
+}
+
+// Delete implements the controller's Provisioner interface. It is also
+// called for volumes whose PV could not be saved.
+func (c *provisionController) Delete(ctx context.Context, volume *v1.PersistentVolume) (err error) {
+	key := "volume/" + volume.Name
+	defer c.recoverPanic("volume", key, &err)
+	if !c.startWork(key) {
+		return errStopping
+	}
+	defer c.finishWork(key)
+	c.mutex.Lock()
+	delete(c.provisioned, volume.Name)
+	c.mutex.Unlock()
+	return c.p.Delete(ctx, volume)
+}
+
+// SupportsBlock implements the controller's BlockProvisioner interface.
+func (c *provisionController) SupportsBlock(ctx context.Context) bool {
+	return c.p.SupportsBlock(ctx)
+}
+
+// volumeInformer is the volume informer as the controller sees it, the
+// handler the controller adds also tells the HostPathProvisioner about the
+// PVs of the volumes it provisioned.
+type volumeInformer struct {
+	cache.SharedInformer
+	controller *provisionController
+}
+
+// volumeHandler is the handler the controller adds to the volume informer.
+type volumeHandler struct {
+	cache.ResourceEventHandler
+	controller *provisionController
+}
+
+// AddEventHandler implements cache.SharedInformer.
+func (i *volumeInformer) AddEventHandler(handler cache.ResourceEventHandler) (cache.ResourceEventHandlerRegistration, error) {
+	return i.SharedInformer.AddEventHandler(i.controller.setVolumeHandler(handler))
+}
+
+// AddEventHandlerWithResyncPeriod implements cache.SharedInformer.
+func (i *volumeInformer) AddEventHandlerWithResyncPeriod(handler cache.ResourceEventHandler, resyncPeriod time.Duration) (cache.ResourceEventHandlerRegistration, error) {
+	return i.SharedInformer.AddEventHandlerWithResyncPeriod(i.controller.setVolumeHandler(handler), resyncPeriod)
+}
+
+func (c *provisionController) setVolumeHandler(handler cache.ResourceEventHandler) cache.ResourceEventHandler {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.volumeHandler = handler
+	return &volumeHandler{ResourceEventHandler: handler, controller: c}
+}
+
+// OnAdd implements cache.ResourceEventHandler.
+func (h *volumeHandler) OnAdd(obj interface{}, isInInitialList bool) {
+	h.controller.volumeSeen(obj)
+	h.ResourceEventHandler.OnAdd(obj, isInInitialList)
+}
+
+// OnUpdate implements cache.ResourceEventHandler.
+func (h *volumeHandler) OnUpdate(oldObj, newObj interface{}) {
+	h.controller.volumeSeen(newObj)
+	h.ResourceEventHandler.OnUpdate(oldObj, newObj)
+}
+
+// volumeSeen tells the HostPathProvisioner once the PV of a volume it
+// provisioned is saved.
+func (c *provisionController) volumeSeen(obj interface{}) {
+	pv, ok := obj.(*v1.PersistentVolume)
+	if !ok {
+		return
+	}
+	c.mutex.Lock()
+	provisioned := c.provisioned[pv.Name]
+	delete(c.provisioned, pv.Name)
+	c.mutex.Unlock()
+	if provisioned {
+		c.p.VolumeStored(pv)
+	}
+}
+
+// Resync queues every claim of interest and every volume in the informer
+// caches, as a periodic informer resync would, and returns how many of each
+// were queued. Nothing is queued before the controller runs.
+func (c *provisionController) Resync() (int, int) {
+	c.mutex.Lock()
+	handler := c.volumeHandler
+	c.mutex.Unlock()
+	if handler == nil {
+		return 0, 0
+	}
+	claims := c.claims.resync(c.claimInformer.GetStore().List())
+	volumes := 0
+	for _, obj := range c.volumeInformer.GetStore().List() {
+		handler.OnUpdate(obj, obj)
+		volumes++
+	}
+	return claims, volumes
+}
+
+// startWork records that processing of the claim or volume started, unless
+// the controller is stopping.
+func (c *provisionController) startWork(key string) bool {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	if c.stopping {
+		return false
+	}
+	c.work[key] = time.Now()
+	return true
+}
+
+// finishWork records that processing of the claim or volume is done.
+func (c *provisionController) finishWork(key string) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	delete(c.work, key)
+}
+
+// busy returns whether a claim or volume is being processed.
+func (c *provisionController) busy() bool {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	return len(c.work) > 0
+}
+
+// CheckHealth returns an error if a claim or volume has been processed for
+// longer than timeout. Provisioning and deleting normally take a fraction of
+// that, a worker stuck for that long is assumed to be wedged.
+func (c *provisionController) CheckHealth(timeout time.Duration) error {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	for key, started := range c.work {
+		if time.Since(started) > timeout {
+			return fmt.Errorf("processing of %s has not finished after %v", key, time.Since(started).Round(time.Second))
+		}
+	}
+	return nil
+}
+
+// Shutdown stops the workers from taking new work and waits up to timeout
+// for the claims and volumes being processed. It returns whether the work in
+// progress finished in time.
+func (c *provisionController) Shutdown(timeout time.Duration) bool {
+	c.mutex.Lock()
+	c.stopping = true
+	c.mutex.Unlock()
+	deadline := time.Now().Add(timeout)
+	for c.busy() {
+		if time.Now().After(deadline) {
+			return false
+		}
+		time.Sleep(workCheckInterval)
+	}
+	return true
+}
+
+// recoverPanic turns a panic while processing a claim or volume into an
+// error, so that it is retried and the other workers, which may be halfway
+// through deleting a volume, are not taken down with it. It must be
+// deferred.
+func (c *provisionController) recoverPanic(queue, key string, err *error) {
+	r := recover()
+	if r == nil {
+		return
+	}
+	klog.ErrorS(fmt.Errorf("%v", r), "Recovered from panic", "queue", queue, "key", key, "stack", string(debug.Stack()))
+	c.panics.WithLabelValues(queue).Inc()
+	*err = fmt.Errorf("panic while processing %s %q: %v", queue, key, r)
+	if c.exitOnPanic {
+		c.exitWhenIdle()
+	}
+}
+
+// exitWhenIdle stops the workers from taking new work and exits the process
+// once the claims and volumes being processed are done, so that a restart
+// does not interrupt a deletion.
+func (c *provisionController) exitWhenIdle() {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	if c.stopping {
+		return
+	}
+	c.stopping = true
+	klog.ErrorS(nil, "Exiting after a panic once the work in progress is done")
+	go func() {
+		for c.busy() {
+			time.Sleep(workCheckInterval)
+		}
+		klog.Flush()
+		os.Exit(1)
+	}()
+}
+
+// serveMetrics registers the metrics of the controller and serves them, with
+// the others registered with prometheus, on address and port under path.
+// Anything registered on the default mux, e.g. by net/http/pprof, is not
+// exposed on the metrics port.
+func (c *provisionController) serveMetrics(address string, port int, path string) {
+	prometheus.MustRegister(
+		c.metrics.PersistentVolumeClaimProvisionTotal,
+		c.metrics.PersistentVolumeClaimProvisionFailedTotal,
+		c.metrics.PersistentVolumeClaimProvisionDurationSeconds,
+		c.metrics.PersistentVolumeDeleteTotal,
+		c.metrics.PersistentVolumeDeleteFailedTotal,
+		c.metrics.PersistentVolumeDeleteDurationSeconds,
+		c.panics,
+		newQueueCollector(c),
+		newLeaderCollector(c),
+	)
+	mux := http.NewServeMux()
+	mux.Handle(path, promhttp.Handler())
+	address = net.JoinHostPort(address, strconv.Itoa(port))
+	klog.InfoS("Starting metrics server", "address", address)
+	go wait.Forever(func() {
+		if err := http.ListenAndServe(address, mux); err != nil {
+			klog.ErrorS(err, "Failed to listen metrics server", "address", address)
+		}
+	}, 5*time.Second)
+}
+
+// queueDepths is the workqueue metrics provider keeping the depth of the
+// controller's queues by name, the other workqueue metrics are dropped.
+type queueDepths struct {
+	mutex  sync.Mutex
+	depths map[string]*queueDepth
+}
+
+// queueDepth is the depth of a queue, it implements all the workqueue
+// metrics, only the depth counts.
+type queueDepth struct {
+	value int64
+}
+
+var _ workqueue.MetricsProvider = &queueDepths{}
+
+func newQueueDepths() *queueDepths {
+	return &queueDepths{depths: make(map[string]*queueDepth)}
+}
+
+// depth returns the depth of the queue.
+func (q *queueDepths) depth(name string) int64 {
+	return atomic.LoadInt64(&q.metric(name).value)
+}
+
+func (q *queueDepths) metric(name string) *queueDepth {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+	depth, ok := q.depths[name]
+	if !ok {
+		depth = &queueDepth{}
+		q.depths[name] = depth
+	}
+	return depth
+}
+
+func (q *queueDepths) NewDepthMetric(name string) workqueue.GaugeMetric {
+	return q.metric(name)
+}
+
+func (q *queueDepths) NewAddsMetric(string) workqueue.CounterMetric {
+	return &queueDepth{}
+}
+
+func (q *queueDepths) NewLatencyMetric(string) workqueue.HistogramMetric {
+	return &queueDepth{}
+}
+
+func (q *queueDepths) NewWorkDurationMetric(string) workqueue.HistogramMetric {
+	return &queueDepth{}
+}
+
+func (q *queueDepths) NewUnfinishedWorkSecondsMetric(string) workqueue.SettableGaugeMetric {
+	return &queueDepth{}
+}
+
+func (q *queueDepths) NewLongestRunningProcessorSecondsMetric(string) workqueue.SettableGaugeMetric {
+	return &queueDepth{}
+}
+
+func (q *queueDepths) NewRetriesMetric(string) workqueue.CounterMetric {
+	return &queueDepth{}
+}
+
+func (d *queueDepth) Inc() {
+	atomic.AddInt64(&d.value, 1)
+}
+
+func (d *queueDepth) Dec() {
+	atomic.AddInt64(&d.value, -1)
+}
+
+func (d *queueDepth) Set(float64) {}
+
+func (d *queueDepth) Observe(float64) {}
+
+// queueCollector exports the state of the queues, so that alerts can fire
+// when provisioning is backed up or a claim is stuck retrying.
+type queueCollector struct {
+	controller *provisionController
+
+	claimQueueDepth       *prometheus.Desc
+	volumeQueueDepth      *prometheus.Desc
+	claimRetries          *prometheus.Desc
+	oldestPendingClaimAge *prometheus.Desc
+}
+
+var _ prometheus.Collector = &queueCollector{}
+
+func newQueueCollector(c *provisionController) *queueCollector {
+	return &queueCollector{
+		controller: c,
+		claimQueueDepth: prometheus.NewDesc(prometheus.BuildFQName("", controllerSubsystem, "claim_queue_depth"),
+			"Number of claims waiting to be processed.", nil, nil),
+		volumeQueueDepth: prometheus.NewDesc(prometheus.BuildFQName("", controllerSubsystem, "volume_queue_depth"),
+			"Number of volumes waiting to be processed.", nil, nil),
+		claimRetries: prometheus.NewDesc(prometheus.BuildFQName("", controllerSubsystem, "persistentvolumeclaim_retries"),
+			"Number of times provisioning a pending claim has been retried.", []string{"namespace", "persistentvolumeclaim"}, nil),
+		oldestPendingClaimAge: prometheus.NewDesc(prometheus.BuildFQName("", controllerSubsystem, "oldest_pending_claim_age_seconds"),
+			"Time since the oldest claim that has not been processed successfully was first queued.", nil, nil),
+	}
+}
+
+// Describe implements prometheus.Collector.
+func (c *queueCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- c.claimQueueDepth
+	ch <- c.volumeQueueDepth
+	ch <- c.claimRetries
+	ch <- c.oldestPendingClaimAge
+}
+
+// Collect implements prometheus.Collector.
+func (c *queueCollector) Collect(ch chan<- prometheus.Metric) {
+	claims := int64(c.controller.claims.depth()) + c.controller.queues.depth("claims")
+	ch <- prometheus.MustNewConstMetric(c.claimQueueDepth, prometheus.GaugeValue, float64(claims))
+	ch <- prometheus.MustNewConstMetric(c.volumeQueueDepth, prometheus.GaugeValue, float64(c.controller.queues.depth("volumes")))
+	var oldest time.Duration
+	for uid, claim := range c.controller.claims.pendingClaims() {
+		if age := time.Since(claim.queued); age > oldest {
+			oldest = age
+		}
+		// Every attempt that has not succeeded yet failed
+		if retries := c.controller.p.attempts.count(admin.OperationProvision, types.UID(uid)); retries > 0 {
+			ch <- prometheus.MustNewConstMetric(c.claimRetries, prometheus.GaugeValue, float64(retries), claim.namespace, claim.name)
+		}
+	}
+	ch <- prometheus.MustNewConstMetric(c.oldestPendingClaimAge, prometheus.GaugeValue, oldest.Seconds())
+}
+
+// leaderCollector exports whether the replica is the leader.
+type leaderCollector struct {
+	controller *provisionController
+	leader     *prometheus.Desc
+}
+
+var _ prometheus.Collector = &leaderCollector{}
+
+func newLeaderCollector(c *provisionController) *leaderCollector {
+	return &leaderCollector{
+		controller: c,
+		leader: prometheus.NewDesc(prometheus.BuildFQName("", controllerSubsystem, "leader"),
+			"Whether this controller is the leader and processes claims and volumes.", []string{"identity"}, nil),
+	}
+}
+
+// Describe implements prometheus.Collector.
+func (c *leaderCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- c.leader
+}
+
+// Collect implements prometheus.Collector.
+func (c *leaderCollector) Collect(ch chan<- prometheus.Metric) {
+	value := 0.0
+	if c.controller.p.isLeading() {
+		value = 1
+	}
+	ch <- prometheus.MustNewConstMetric(c.leader, prometheus.GaugeValue, value, c.controller.identity)
+}
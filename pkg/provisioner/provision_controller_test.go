@@ -0,0 +1,275 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package provisioner
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+	"kubevirt.io/hostpath-provisioner/admin"
+
+	v1 "k8s.io/api/core/v1"
+	storage "k8s.io/api/storage/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+	"k8s.io/client-go/tools/cache"
+)
+
+func testProvisionController(p *HostPathProvisioner) *provisionController {
+	claims := cache.NewSharedIndexInformer(&cache.ListWatch{}, &v1.PersistentVolumeClaim{}, 0, cache.Indexers{})
+	volumes := cache.NewSharedInformer(&cache.ListWatch{}, &v1.PersistentVolume{}, 0)
+	return newProvisionController(p, fake.NewSimpleClientset(), "kubevirt.io/hostpath-provisioner", "node01_a", 1, false, claims, volumes, nil)
+}
+
+// collect returns the values of the metrics the collector collects, by the
+// description and labels of the metric.
+func collect(t *testing.T, collector prometheus.Collector) map[string]float64 {
+	ch := make(chan prometheus.Metric, 10)
+	collector.Collect(ch)
+	close(ch)
+	values := map[string]float64{}
+	for metric := range ch {
+		m := &dto.Metric{}
+		if err := metric.Write(m); err != nil {
+			t.Fatal(err)
+		}
+		name := metric.Desc().String()
+		for _, label := range m.Label {
+			name += "," + label.GetName() + "=" + label.GetValue()
+		}
+		values[name] = m.GetGauge().GetValue()
+	}
+	return values
+}
+
+// metricValue returns the value of the metric whose name contains fragment.
+func metricValue(t *testing.T, values map[string]float64, fragment string) float64 {
+	for name, value := range values {
+		if strings.Contains(name, fragment) {
+			return value
+		}
+	}
+	t.Errorf("metric %s not collected, got %v", fragment, values)
+	return 0
+}
+
+func TestProvisionControllerCheckHealth(t *testing.T) {
+	c := testProvisionController(simulatedProvisioner(newMemoryBackend(10 << 30)))
+	if err := c.CheckHealth(time.Minute); err != nil {
+		t.Errorf("CheckHealth() = %v for an idle controller", err)
+	}
+
+	c.startWork("claim/default/claim")
+	if err := c.CheckHealth(time.Minute); err != nil {
+		t.Errorf("CheckHealth() = %v while a claim is processed", err)
+	}
+	c.work["claim/default/claim"] = time.Now().Add(-2 * time.Minute)
+	if err := c.CheckHealth(time.Minute); err == nil {
+		t.Error("CheckHealth() = nil for a claim processed for too long")
+	}
+	c.finishWork("claim/default/claim")
+	if err := c.CheckHealth(time.Minute); err != nil {
+		t.Errorf("CheckHealth() = %v after the claim was processed", err)
+	}
+}
+
+func TestProvisionControllerShutdown(t *testing.T) {
+	c := testProvisionController(simulatedProvisioner(newMemoryBackend(10 << 30)))
+	c.startWork("volume/pv-1")
+	if c.Shutdown(10 * time.Millisecond) {
+		t.Error("Shutdown() = true while a volume is processed")
+	}
+	if c.startWork("volume/pv-2") {
+		t.Error("workers do not stop taking work after Shutdown()")
+	}
+	if err := c.Delete(context.Background(), &v1.PersistentVolume{ObjectMeta: metav1.ObjectMeta{Name: "pv-2"}}); err != errStopping {
+		t.Errorf("Delete() = %v after Shutdown(), want it ignored", err)
+	}
+	c.finishWork("volume/pv-1")
+	if !c.Shutdown(time.Second) {
+		t.Error("Shutdown() = false after the volume was processed")
+	}
+}
+
+func TestProvisionControllerRecoverPanic(t *testing.T) {
+	c := testProvisionController(simulatedProvisioner(newMemoryBackend(10 << 30)))
+	panics := func() float64 {
+		m := &dto.Metric{}
+		if err := c.panics.WithLabelValues("volume").Write(m); err != nil {
+			t.Fatal(err)
+		}
+		return m.GetCounter().GetValue()
+	}
+
+	process := func() (err error) {
+		defer c.recoverPanic("volume", "volume/pv-1", &err)
+		panic("boom")
+	}
+	if err := process(); err == nil || !strings.Contains(err.Error(), "boom") {
+		t.Errorf("recovered error = %v, want the panic", err)
+	}
+	if got := panics(); got != 1 {
+		t.Errorf("worker_panics_total = %v, want 1", got)
+	}
+	if !c.startWork("volume/pv-2") {
+		t.Error("workers stopped after a panic without exitOnPanic")
+	}
+}
+
+func TestProvisionControllerTellsVolumeStored(t *testing.T) {
+	dir, err := ioutil.TempDir("", "provision-controller")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	db, _, err := openAllocationDB(filepath.Join(dir, "allocations.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	p := simulatedProvisioner(newMemoryBackend(10 << 30))
+	p.allocations = db
+	c := testProvisionController(p)
+	handler := &cache.ResourceEventHandlerFuncs{}
+	volumes := c.setVolumeHandler(handler)
+
+	c.provisioned["pv-1"] = true
+	volumes.OnAdd(&v1.PersistentVolume{ObjectMeta: metav1.ObjectMeta{Name: "pv-1"}}, false)
+	volumes.OnAdd(&v1.PersistentVolume{ObjectMeta: metav1.ObjectMeta{Name: "pv-2"}}, false)
+	list, err := db.list()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(list) != 1 || list[0].PV != "pv-1" {
+		t.Errorf("recorded volumes %+v, want only pv-1, which was provisioned", list)
+	}
+	if len(c.provisioned) != 0 {
+		t.Errorf("provisioned volumes %v still waited for", c.provisioned)
+	}
+}
+
+func TestProvisionControllerResync(t *testing.T) {
+	p := simulatedProvisioner(newMemoryBackend(10 << 30))
+	p.identity = "kubevirt.io/hostpath-provisioner"
+	c := testProvisionController(p)
+	if claims, volumes := c.Resync(); claims != 0 || volumes != 0 {
+		t.Errorf("Resync() = %d, %d before the controller runs, want 0, 0", claims, volumes)
+	}
+
+	ours := &v1.PersistentVolumeClaim{ObjectMeta: metav1.ObjectMeta{Name: "ours", Namespace: "default", UID: "uid-1",
+		Annotations: map[string]string{annStorageProvisionerGA: p.identity}}}
+	other := &v1.PersistentVolumeClaim{ObjectMeta: metav1.ObjectMeta{Name: "other", Namespace: "default", UID: "uid-2",
+		Annotations: map[string]string{annStorageProvisionerGA: "example.com/other"}}}
+	c.claimInformer.GetStore().Add(ours)
+	c.claimInformer.GetStore().Add(other)
+	c.volumeInformer.GetStore().Add(&v1.PersistentVolume{ObjectMeta: metav1.ObjectMeta{Name: "pv-1"}})
+	updated := 0
+	c.setVolumeHandler(cache.ResourceEventHandlerFuncs{UpdateFunc: func(oldObj, newObj interface{}) { updated++ }})
+	c.claims.setHandler(&recordingHandler{})
+
+	if claims, volumes := c.Resync(); claims != 1 || volumes != 1 || updated != 1 {
+		t.Errorf("Resync() = %d, %d with %d volumes updated, want 1, 1 and 1", claims, volumes, updated)
+	}
+}
+
+func TestQueueCollector(t *testing.T) {
+	p := simulatedProvisioner(newMemoryBackend(10 << 30))
+	c := testProvisionController(p)
+	c.claims.OnAdd(&v1.PersistentVolumeClaim{ObjectMeta: metav1.ObjectMeta{Name: "claim", Namespace: "default", UID: "uid-1",
+		Annotations: map[string]string{annProvisionOnNode: p.nodeName}}}, false)
+	c.claims.pending["uid-1"] = pendingClaim{namespace: "default", name: "claim", queued: time.Now().Add(-time.Minute)}
+	p.attempts.correlationID(admin.OperationProvision, "uid-1")
+	p.attempts.correlationID(admin.OperationProvision, "uid-1")
+	volumes := c.queues.NewDepthMetric("volumes")
+	volumes.Inc()
+	defer volumes.Dec()
+
+	values := collect(t, newQueueCollector(c))
+	if got := metricValue(t, values, "claim_queue_depth"); got != 1 {
+		t.Errorf("claim_queue_depth = %v, want 1", got)
+	}
+	if got := metricValue(t, values, "volume_queue_depth"); got != 1 {
+		t.Errorf("volume_queue_depth = %v, want 1", got)
+	}
+	if got := metricValue(t, values, "persistentvolumeclaim=claim"); got != 2 {
+		t.Errorf("persistentvolumeclaim_retries = %v, want 2", got)
+	}
+	if got := metricValue(t, values, "oldest_pending_claim_age_seconds"); got < 60 {
+		t.Errorf("oldest_pending_claim_age_seconds = %v, want at least 60", got)
+	}
+}
+
+func TestProvisionControllerLeader(t *testing.T) {
+	p := simulatedProvisioner(newMemoryBackend(10 << 30))
+	p.leading = make(chan struct{})
+	c := testProvisionController(p)
+	if c.Leader() != "" || metricValue(t, collect(t, newLeaderCollector(c)), "leader") != 0 {
+		t.Errorf("leader = %q before taking over the node", c.Leader())
+	}
+	close(p.leading)
+	if c.Leader() != "node01_a" || metricValue(t, collect(t, newLeaderCollector(c)), "leader") != 1 {
+		t.Errorf("leader = %q after taking over the node", c.Leader())
+	}
+}
+
+func TestShouldProvisionSelectsTheNamedNode(t *testing.T) {
+	waitForFirstConsumer := storage.VolumeBindingWaitForFirstConsumer
+	classes := []*storage.StorageClass{
+		{ObjectMeta: metav1.ObjectMeta{Name: "hostpath-wffc"}, Provisioner: "kubevirt.io/hostpath-provisioner", VolumeBindingMode: &waitForFirstConsumer},
+		{ObjectMeta: metav1.ObjectMeta{Name: "other"}, Provisioner: "example.com/other", VolumeBindingMode: &waitForFirstConsumer},
+	}
+	tests := []struct {
+		name     string
+		class    string
+		node     string
+		selected string
+	}{
+		{name: "naming this node", class: "hostpath-wffc", node: "node01", selected: "node01"},
+		{name: "naming another node", class: "hostpath-wffc", node: "node02"},
+		{name: "of another provisioner's class", class: "other", node: "node01"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			claim := &v1.PersistentVolumeClaim{
+				ObjectMeta: metav1.ObjectMeta{Name: "claim", Namespace: "default", Annotations: map[string]string{annProvisionOnNode: tt.node}},
+				Spec:       v1.PersistentVolumeClaimSpec{StorageClassName: &tt.class},
+			}
+			client := fake.NewSimpleClientset(classes[0], classes[1], claim)
+			p := simulatedProvisioner(newMemoryBackend(10 << 30))
+			p.client = client
+			p.identity = "kubevirt.io/hostpath-provisioner"
+
+			// The claim is provisioned once the PV controller hands it over
+			if p.ShouldProvision(context.Background(), claim) {
+				t.Error("ShouldProvision() = true for a claim without a selected node")
+			}
+			got, err := client.CoreV1().PersistentVolumeClaims("default").Get(context.Background(), "claim", metav1.GetOptions{})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if selected := got.Annotations[annSelectedNode]; selected != tt.selected {
+				t.Errorf("selected node = %q, want %q", selected, tt.selected)
+			}
+		})
+	}
+}
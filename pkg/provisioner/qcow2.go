@@ -0,0 +1,98 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package provisioner
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+
+	"k8s.io/apimachinery/pkg/api/resource"
+	"sigs.k8s.io/sig-storage-lib-external-provisioner/v13/controller"
+)
+
+const (
+	// backingQcow2 volumes are backing directories holding a qcow2 image
+	// named qcow2ImageName, the disk of a KubeVirt VM.
+	backingQcow2 = "qcow2"
+
+	// qcow2ImageName is the name KubeVirt looks for the disk image of a
+	// filesystem volume under.
+	qcow2ImageName = "disk.img"
+
+	// qcow2ClusterSizeParameter is the StorageClass parameter setting the
+	// cluster size of qcow2 images, e.g. 2Mi for large sequential disks.
+	qcow2ClusterSizeParameter = "qcow2ClusterSize"
+
+	minQcow2ClusterSize = 512
+	maxQcow2ClusterSize = 2 * MiB
+)
+
+// qcow2ClusterSizeFor returns the cluster size the StorageClass asks qcow2
+// images to be created with, 0 for the default of qemu-img.
+func qcow2ClusterSizeFor(options controller.ProvisionOptions, backing string) (int64, error) {
+	if options.StorageClass == nil {
+		return 0, nil
+	}
+	value, ok := options.StorageClass.Parameters[qcow2ClusterSizeParameter]
+	if !ok {
+		return 0, nil
+	}
+	if backing != backingQcow2 {
+		return 0, fmt.Errorf("storage class %s sets %s, which only applies to qcow2 backed volumes", options.StorageClass.Name, qcow2ClusterSizeParameter)
+	}
+	quantity, err := resource.ParseQuantity(value)
+	if err != nil {
+		return 0, fmt.Errorf("invalid %s %q in storage class %s: %v", qcow2ClusterSizeParameter, value, options.StorageClass.Name, err)
+	}
+	size := quantity.Value()
+	if size < minQcow2ClusterSize || size > maxQcow2ClusterSize || size&(size-1) != 0 {
+		return 0, fmt.Errorf("invalid %s %q in storage class %s, expected a power of two between 512 and 2Mi", qcow2ClusterSizeParameter, value, options.StorageClass.Name)
+	}
+	return size, nil
+}
+
+// createQcow2Image creates the qcow2 image of a virtual size of size bytes in
+// the backing directory with the qemu-img binary qemuImg, owned like the directory and readable and writable
+// by whoever can write to it. The image is created under a temporary name and
+// renamed when complete, an image left behind by an earlier attempt is kept.
+func createQcow2Image(qemuImg, dir string, size, clusterSize int64, preallocation string, mode os.FileMode, uid, gid int) error {
+	image := filepath.Join(dir, qcow2ImageName)
+	if _, err := os.Stat(image); err == nil {
+		return nil
+	}
+	temporary := image + ".tmp"
+	options := "preallocation=" + preallocation
+	if clusterSize > 0 {
+		options += ",cluster_size=" + strconv.FormatInt(clusterSize, 10)
+	}
+	if _, err := runCommand(qemuImg, "create", "-f", "qcow2", "-o", options, temporary, strconv.FormatInt(size, 10)); err != nil {
+		os.Remove(temporary)
+		return err
+	}
+	if uid != -1 || gid != -1 {
+		if err := os.Lchown(temporary, uid, gid); err != nil {
+			return err
+		}
+	}
+	// The execute bits of the directory mode do not apply to the image
+	if err := os.Chmod(temporary, mode&^0111); err != nil {
+		return err
+	}
+	return os.Rename(temporary, image)
+}
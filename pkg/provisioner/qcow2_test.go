@@ -0,0 +1,105 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package provisioner
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	storagev1 "k8s.io/api/storage/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/sig-storage-lib-external-provisioner/v13/controller"
+)
+
+func Test_qcow2ClusterSizeFor(t *testing.T) {
+	class := func(size string) *storagev1.StorageClass {
+		return &storagev1.StorageClass{ObjectMeta: metav1.ObjectMeta{Name: "hostpath"}, Parameters: map[string]string{qcow2ClusterSizeParameter: size}}
+	}
+	tests := []struct {
+		name    string
+		class   *storagev1.StorageClass
+		backing string
+		want    int64
+		wantErr bool
+	}{
+		{name: "no class", backing: backingQcow2},
+		{name: "not set", class: &storagev1.StorageClass{}, backing: backingQcow2},
+		{name: "64Ki", class: class("64Ki"), backing: backingQcow2, want: 65536},
+		{name: "2Mi", class: class("2Mi"), backing: backingQcow2, want: 2097152},
+		{name: "bytes", class: class("512"), backing: backingQcow2, want: 512},
+		{name: "not a power of two", class: class("100Ki"), backing: backingQcow2, wantErr: true},
+		{name: "too large", class: class("4Mi"), backing: backingQcow2, wantErr: true},
+		{name: "invalid", class: class("large"), backing: backingQcow2, wantErr: true},
+		{name: "raw image", class: class("64Ki"), backing: backingImage, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := qcow2ClusterSizeFor(controller.ProvisionOptions{StorageClass: tt.class}, tt.backing)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("qcow2ClusterSizeFor() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("qcow2ClusterSizeFor() = %d, want %d", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_createQcow2Image(t *testing.T) {
+	dir, err := ioutil.TempDir("", "qcow2")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	var commands []string
+	original := runCommand
+	defer func() { runCommand = original }()
+	runCommand = func(name string, args ...string) ([]byte, error) {
+		commands = append(commands, strings.Join(append([]string{name}, args...), " "))
+		// qemu-img create ... <image> <size>
+		return nil, ioutil.WriteFile(args[len(args)-2], nil, 0644)
+	}
+
+	if err := createQcow2Image("qemu-img", dir, 10737418240, 65536, preallocationMetadata, 0770, -1, -1); err != nil {
+		t.Fatal(err)
+	}
+	image := filepath.Join(dir, qcow2ImageName)
+	want := []string{"qemu-img create -f qcow2 -o preallocation=metadata,cluster_size=65536 " + image + ".tmp 10737418240"}
+	if !reflect.DeepEqual(commands, want) {
+		t.Errorf("commands = %q, want %q", commands, want)
+	}
+	info, err := os.Stat(image)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Mode().Perm() != 0660 {
+		t.Errorf("image mode = %v, want 0660", info.Mode().Perm())
+	}
+
+	// An existing image is kept
+	if err := createQcow2Image("qemu-img", dir, 10737418240, 0, preallocationOff, 0770, -1, -1); err != nil {
+		t.Fatal(err)
+	}
+	if len(commands) != 1 {
+		t.Errorf("commands = %q, want the image kept", commands)
+	}
+}
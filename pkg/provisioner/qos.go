@@ -0,0 +1,109 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package provisioner
+
+import (
+	"fmt"
+	"time"
+
+	"sigs.k8s.io/sig-storage-lib-external-provisioner/v13/controller"
+)
+
+const (
+	// qosTierParameter is the StorageClass parameter naming the QoS tier of
+	// the volumes of the class.
+	qosTierParameter = "qosTier"
+	// annQoSTier records the QoS tier of a volume.
+	annQoSTier = "kubevirt.io/qosTier"
+)
+
+// qosTier is a named level of performance, such as gold or bronze: the I/O
+// limits of its volumes and the pools they can be placed in.
+type qosTier struct {
+	name   string
+	limits ioLimits
+	// maxLatency excludes pools whose probed latency is higher, 0 when any
+	// latency will do
+	maxLatency time.Duration
+	// deviceClasses restricts the volumes to pools of these device classes,
+	// unless their StorageClass restricts them itself
+	deviceClasses []string
+}
+
+// newQoSTier validates a tier of the config file.
+func newQoSTier(tier QoSTierConfig) (*qosTier, error) {
+	if tier.Name == "" {
+		return nil, fmt.Errorf("invalid QoS tier, a name is required")
+	}
+	result := &qosTier{
+		name:          tier.Name,
+		limits:        ioLimits{riops: tier.ReadIOPS, wiops: tier.WriteIOPS},
+		deviceClasses: tier.DeviceClasses,
+	}
+	if tier.ReadBandwidth != nil {
+		result.limits.rbps = tier.ReadBandwidth.Value()
+	}
+	if tier.WriteBandwidth != nil {
+		result.limits.wbps = tier.WriteBandwidth.Value()
+	}
+	for _, key := range ioLimitKeys {
+		if *result.limits.field(key) < 0 {
+			return nil, fmt.Errorf("invalid QoS tier %q: I/O limits can not be negative", tier.Name)
+		}
+	}
+	if tier.MaxLatency != nil {
+		if tier.MaxLatency.Duration < 0 {
+			return nil, fmt.Errorf("invalid QoS tier %q: maxLatency can not be negative", tier.Name)
+		}
+		result.maxLatency = tier.MaxLatency.Duration
+	}
+	for _, class := range tier.DeviceClasses {
+		if err := validDeviceClass(class); err != nil {
+			return nil, fmt.Errorf("invalid QoS tier %q: %v", tier.Name, err)
+		}
+	}
+	return result, nil
+}
+
+// qosTierFor returns the QoS tier the claim's StorageClass asks for, nil when
+// it does not ask for one.
+func (p *HostPathProvisioner) qosTierFor(options controller.ProvisionOptions) (*qosTier, error) {
+	if options.StorageClass == nil || options.StorageClass.Parameters[qosTierParameter] == "" {
+		return nil, nil
+	}
+	name := options.StorageClass.Parameters[qosTierParameter]
+	tier := p.currentQoSTier(name)
+	if tier == nil {
+		return nil, fmt.Errorf("storage class %s asks for QoS tier %s, which is not configured on node %s", options.StorageClass.Name, name, p.nodeName)
+	}
+	return tier, nil
+}
+
+// tierCandidates returns the candidates whose pool meets the latency of the
+// tier, all of them when there is no tier.
+func tierCandidates(candidates []poolCandidate, tier *qosTier, latency *latencyProber) []poolCandidate {
+	if tier == nil || tier.maxLatency == 0 {
+		return candidates
+	}
+	var result []poolCandidate
+	for _, candidate := range candidates {
+		if latency.meets(candidate.pool, tier.maxLatency) {
+			result = append(result, candidate)
+		}
+	}
+	return result
+}
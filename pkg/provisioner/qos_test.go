@@ -0,0 +1,88 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package provisioner
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	storagev1 "k8s.io/api/storage/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/tools/record"
+	"sigs.k8s.io/sig-storage-lib-external-provisioner/v13/controller"
+)
+
+func Test_qosTierFor(t *testing.T) {
+	gold := &qosTier{name: "gold", limits: ioLimits{riops: 5000}}
+	p := &HostPathProvisioner{nodeName: "node01", qosTiers: map[string]*qosTier{"gold": gold}}
+	class := func(tier string) *storagev1.StorageClass {
+		return &storagev1.StorageClass{ObjectMeta: metav1.ObjectMeta{Name: "hostpath"}, Parameters: map[string]string{qosTierParameter: tier}}
+	}
+	tests := []struct {
+		name    string
+		class   *storagev1.StorageClass
+		want    *qosTier
+		wantErr bool
+	}{
+		{name: "no class"},
+		{name: "no tier", class: class("")},
+		{name: "tier", class: class("gold"), want: gold},
+		{name: "unknown tier", class: class("platinum"), wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := p.qosTierFor(controller.ProvisionOptions{StorageClass: tt.class})
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("qosTierFor() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("qosTierFor() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_tierCandidates(t *testing.T) {
+	fast := poolCandidate{pool: &storagePool{name: "fast", path: "/var/hpvolumes/fast"}}
+	slow := poolCandidate{pool: &storagePool{name: "slow", path: "/var/hpvolumes/slow"}}
+	unprobed := poolCandidate{pool: &storagePool{name: "new", path: "/var/hpvolumes/new"}}
+	prober := newLatencyProber("node01", record.NewFakeRecorder(10), time.Second)
+	prober.record(fast.pool, time.Millisecond)
+	prober.record(slow.pool, 50*time.Millisecond)
+	candidates := []poolCandidate{fast, slow, unprobed}
+
+	tests := []struct {
+		name    string
+		tier    *qosTier
+		latency *latencyProber
+		want    []poolCandidate
+	}{
+		{name: "no tier", latency: prober, want: candidates},
+		{name: "any latency", tier: &qosTier{name: "bronze"}, latency: prober, want: candidates},
+		{name: "low latency", tier: &qosTier{name: "gold", maxLatency: 10 * time.Millisecond}, latency: prober, want: []poolCandidate{fast, unprobed}},
+		{name: "too low", tier: &qosTier{name: "gold", maxLatency: time.Microsecond}, latency: prober, want: []poolCandidate{unprobed}},
+		{name: "not probed", tier: &qosTier{name: "gold", maxLatency: time.Microsecond}, want: candidates},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tierCandidates(candidates, tt.tier, tt.latency); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("tierCandidates() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,283 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package provisioner
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"k8s.io/klog/v2"
+
+	v1 "k8s.io/api/core/v1"
+	apierrs "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/yaml"
+	"k8s.io/client-go/kubernetes"
+)
+
+const (
+	// annRequestedCapacity records the storage size the claim asked for. The
+	// capacity on the PV reflects the whole filesystem, so quota accounting
+	// relies on this annotation instead.
+	annRequestedCapacity = "kubevirt.io/requestedCapacity"
+
+	// pendingReservationTimeout is how long a reservation for a PV that has
+	// not shown up in the API server yet is kept around.
+	pendingReservationTimeout = 5 * time.Minute
+)
+
+// namespaceQuota describes the limits that apply to a single namespace on
+// this node. It is read from the value of the namespace's key in the quota
+// ConfigMap, for example:
+//
+//	data:
+//	  team-a: |
+//	    storage: 200Gi
+//	    volumes: 20
+//	    pools:
+//	      fast:
+//	        storage: 50Gi
+type namespaceQuota struct {
+	Storage *resource.Quantity `json:"storage,omitempty"`
+	Volumes *int64             `json:"volumes,omitempty"`
+	// Pools limits the namespace further in the storage pools of the node
+	// it names
+	Pools map[string]poolQuota `json:"pools,omitempty"`
+}
+
+// poolQuota describes the limits that apply to a namespace in a single
+// storage pool of this node.
+type poolQuota struct {
+	Storage *resource.Quantity `json:"storage,omitempty"`
+	Volumes *int64             `json:"volumes,omitempty"`
+}
+
+// namespaceUsage is what a namespace has already been given on this node, or
+// in one of its pools.
+type namespaceUsage struct {
+	storage resource.Quantity
+	volumes int64
+}
+
+type pendingReservation struct {
+	namespace string
+	pool      string
+	storage   resource.Quantity
+	created   time.Time
+}
+
+// quotaManager enforces per-namespace limits on the volumes provisioned on
+// this node. Limits are read from a ConfigMap on every check so they can be
+// changed without restarting the provisioner.
+type quotaManager struct {
+	client        kubernetes.Interface
+	pvCache       *volumeCache
+	identity      string
+	nodeName      string
+	namespace     string
+	configMapName string
+
+	mutex sync.Mutex
+	// PVs that have been provisioned but may not be visible through the API
+	// server yet, keyed by PV name.
+	pending map[string]pendingReservation
+}
+
+func newQuotaManager(client kubernetes.Interface, pvCache *volumeCache, identity, nodeName, namespace, configMapName string) *quotaManager {
+	return &quotaManager{
+		client:        client,
+		pvCache:       pvCache,
+		identity:      identity,
+		nodeName:      nodeName,
+		namespace:     namespace,
+		configMapName: configMapName,
+		pending:       make(map[string]pendingReservation),
+	}
+}
+
+// reserve checks whether the claim fits in its namespace's quota on this node
+// and in the pool, and if so records the allocation until the PV becomes
+// visible. An error describing the violation is returned otherwise.
+func (q *quotaManager) reserve(pvc *v1.PersistentVolumeClaim, pvName, pool string) error {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+
+	quotas, err := q.loadQuotas()
+	if err != nil {
+		return err
+	}
+	quota, ok := quotas[pvc.Namespace]
+	if !ok {
+		return nil
+	}
+
+	pvs, err := q.pvCache.list(context.TODO(), q.client)
+	if err != nil {
+		return fmt.Errorf("unable to list persistent volumes for quota accounting: %v", err)
+	}
+	q.prunePending(pvs.Items)
+
+	usage := q.usage(pvs.Items, pvc.Namespace, "", pvName)
+	requested := pvc.Spec.Resources.Requests[v1.ResourceStorage]
+	if err := checkQuota(pvc.Namespace, q.nodeName, quota, usage, requested); err != nil {
+		return err
+	}
+	if limits, ok := quota.Pools[pool]; ok {
+		usage := q.usage(pvs.Items, pvc.Namespace, pool, pvName)
+		if err := checkPoolQuota(pvc.Namespace, pool, q.nodeName, limits, usage, requested); err != nil {
+			return err
+		}
+	}
+
+	q.pending[pvName] = pendingReservation{
+		namespace: pvc.Namespace,
+		pool:      pool,
+		storage:   requested,
+		created:   time.Now(),
+	}
+	return nil
+}
+
+// release drops the reservation for a PV that was never created.
+func (q *quotaManager) release(pvName string) {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+	delete(q.pending, pvName)
+}
+
+func (q *quotaManager) loadQuotas() (map[string]namespaceQuota, error) {
+	cm, err := q.client.CoreV1().ConfigMaps(q.namespace).Get(context.TODO(), q.configMapName, metav1.GetOptions{})
+	if err != nil {
+		if apierrs.IsNotFound(err) {
+			klog.V(3).Infof("quota configmap %s/%s not found, not enforcing quotas", q.namespace, q.configMapName)
+			return nil, nil
+		}
+		return nil, fmt.Errorf("unable to read quota configmap %s/%s: %v", q.namespace, q.configMapName, err)
+	}
+	return parseQuotas(cm.Data)
+}
+
+// prunePending removes reservations whose PV is now known to the API server,
+// or that have been pending for too long.
+func (q *quotaManager) prunePending(pvs []v1.PersistentVolume) {
+	for _, pv := range pvs {
+		delete(q.pending, pv.Name)
+	}
+	for name, reservation := range q.pending {
+		if time.Since(reservation.created) > pendingReservationTimeout {
+			delete(q.pending, name)
+		}
+	}
+}
+
+// usage sums up the storage held by the namespace on this node, in the pool
+// unless it is empty, including pending reservations. The PV being
+// provisioned is excluded so that retries of the same claim are not counted
+// twice.
+func (q *quotaManager) usage(pvs []v1.PersistentVolume, namespace, pool, excludePV string) namespaceUsage {
+	usage := calculateNamespaceUsage(pvs, q.identity, q.nodeName, namespace, pool, excludePV)
+	for name, reservation := range q.pending {
+		if name != excludePV && reservation.namespace == namespace && (pool == "" || reservation.pool == pool) {
+			usage.storage.Add(reservation.storage)
+			usage.volumes++
+		}
+	}
+	return usage
+}
+
+// parseQuotas converts the ConfigMap data, keyed by namespace, into quotas.
+func parseQuotas(data map[string]string) (map[string]namespaceQuota, error) {
+	quotas := make(map[string]namespaceQuota, len(data))
+	for namespace, value := range data {
+		quota := namespaceQuota{}
+		if err := yaml.NewYAMLOrJSONDecoder(strings.NewReader(value), len(value)+1).Decode(&quota); err != nil {
+			return nil, fmt.Errorf("invalid quota for namespace %q: %v", namespace, err)
+		}
+		quotas[namespace] = quota
+	}
+	return quotas, nil
+}
+
+// calculateNamespaceUsage returns the number of PVs that this provisioner
+// created on the node for claims in the given namespace, in the pool unless
+// it is empty, and the storage they requested.
+func calculateNamespaceUsage(pvs []v1.PersistentVolume, identity, nodeName, namespace, pool, excludePV string) namespaceUsage {
+	usage := namespaceUsage{}
+	for i := range pvs {
+		pv := &pvs[i]
+		if pv.Name == excludePV || pv.Spec.ClaimRef == nil || pv.Spec.ClaimRef.Namespace != namespace {
+			continue
+		}
+		annotations := volumeAnnotations(pv)
+		if annotations["hostPathProvisionerIdentity"] != identity || annotations["kubevirt.io/provisionOnNode"] != nodeName {
+			continue
+		}
+		volumePool := annotations[annStoragePool]
+		if volumePool == "" {
+			volumePool = defaultPoolName
+		}
+		if pool != "" && volumePool != pool {
+			continue
+		}
+		usage.volumes++
+		requested, ok := annotations[annRequestedCapacity]
+		if !ok {
+			continue
+		}
+		size, err := resource.ParseQuantity(requested)
+		if err != nil {
+			klog.Warningf("ignoring invalid %s annotation on PV %s: %v", annRequestedCapacity, pv.Name, err)
+			continue
+		}
+		usage.storage.Add(size)
+	}
+	return usage
+}
+
+// checkQuota returns an error if adding a volume of the requested size to the
+// current usage would exceed the quota.
+func checkQuota(namespace, nodeName string, quota namespaceQuota, usage namespaceUsage, requested resource.Quantity) error {
+	return checkLimits(namespace, "on node "+nodeName, quota.Storage, quota.Volumes, usage, requested)
+}
+
+// checkPoolQuota returns an error if adding a volume of the requested size to
+// the current usage of the pool would exceed the quota of the pool.
+func checkPoolQuota(namespace, pool, nodeName string, quota poolQuota, usage namespaceUsage, requested resource.Quantity) error {
+	return checkLimits(namespace, fmt.Sprintf("in pool %s on node %s", pool, nodeName), quota.Storage, quota.Volumes, usage, requested)
+}
+
+// checkLimits returns an error if adding a volume of the requested size to
+// the current usage where the limits apply would exceed them.
+func checkLimits(namespace, where string, storage *resource.Quantity, volumes *int64, usage namespaceUsage, requested resource.Quantity) error {
+	if volumes != nil && usage.volumes+1 > *volumes {
+		return fmt.Errorf("namespace %q exceeds its hostpath volume quota %s: %d volumes in use, limited to %d",
+			namespace, where, usage.volumes, *volumes)
+	}
+	if storage != nil {
+		total := usage.storage.DeepCopy()
+		total.Add(requested)
+		if total.Cmp(*storage) > 0 {
+			return fmt.Errorf("namespace %q exceeds its hostpath storage quota %s: requested %s, used %s, limited to %s",
+				namespace, where, requested.String(), usage.storage.String(), storage.String())
+		}
+	}
+	return nil
+}
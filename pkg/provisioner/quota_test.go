@@ -0,0 +1,330 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package provisioner
+
+import (
+	"testing"
+
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func createQuotaPv(name, identity, nodeName, namespace, requested string) v1.PersistentVolume {
+	pv := createPv(identity, nodeName, "/tmp/"+name)
+	pv.Name = name
+	if requested != "" {
+		pv.Annotations[annRequestedCapacity] = requested
+	}
+	pv.Spec.ClaimRef = &v1.ObjectReference{
+		Namespace: namespace,
+		Name:      "claim-" + name,
+	}
+	return *pv
+}
+
+// csiQuotaPv is createQuotaPv for a volume of the CSI driver, whose
+// annotations are in its volume context.
+func csiQuotaPv(name, identity, nodeName, namespace, requested string) v1.PersistentVolume {
+	pv := createQuotaPv(name, identity, nodeName, namespace, requested)
+	return *csiVolume(defaultCSIDriverName, &pv)
+}
+
+func quantityPtr(value string) *resource.Quantity {
+	q := resource.MustParse(value)
+	return &q
+}
+
+func int64Ptr(value int64) *int64 {
+	return &value
+}
+
+func Test_parseQuotas(t *testing.T) {
+	tests := []struct {
+		name    string
+		data    map[string]string
+		want    map[string]string
+		wantErr bool
+	}{
+		{
+			name: "parses storage quota",
+			data: map[string]string{
+				"team-a": "storage: 10Gi",
+				"team-b": "{\"storage\": \"1Ti\"}",
+			},
+			want: map[string]string{
+				"team-a": "10Gi",
+				"team-b": "1Ti",
+			},
+		},
+		{
+			name: "namespace without storage limit",
+			data: map[string]string{
+				"team-a": "{}",
+			},
+			want: map[string]string{
+				"team-a": "",
+			},
+		},
+		{
+			name: "volume limit only",
+			data: map[string]string{
+				"team-a": "volumes: 3",
+			},
+			want: map[string]string{
+				"team-a": "",
+			},
+		},
+		{
+			name: "pool limits",
+			data: map[string]string{
+				"team-a": "storage: 10Gi\npools:\n  fast:\n    storage: 2Gi",
+			},
+			want: map[string]string{
+				"team-a": "10Gi",
+			},
+		},
+		{
+			name: "invalid quantity",
+			data: map[string]string{
+				"team-a": "storage: lots",
+			},
+			wantErr: true,
+		},
+		{
+			name: "invalid pool quantity",
+			data: map[string]string{
+				"team-a": "pools:\n  fast:\n    storage: lots",
+			},
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := parseQuotas(tt.data)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("parseQuotas() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			for namespace, want := range tt.want {
+				quota, ok := got[namespace]
+				if !ok {
+					t.Errorf("parseQuotas() missing namespace %s", namespace)
+					continue
+				}
+				if want == "" {
+					if quota.Storage != nil {
+						t.Errorf("parseQuotas() storage for %s = %v, want none", namespace, quota.Storage)
+					}
+					continue
+				}
+				if quota.Storage == nil || quota.Storage.Cmp(resource.MustParse(want)) != 0 {
+					t.Errorf("parseQuotas() storage for %s = %v, want %s", namespace, quota.Storage, want)
+				}
+			}
+		})
+	}
+}
+
+func Test_calculateNamespaceUsage(t *testing.T) {
+	pvs := []v1.PersistentVolume{
+		createQuotaPv("pv1", "testId", "testNode", "team-a", "1Gi"),
+		createQuotaPv("pv2", "testId", "testNode", "team-a", "2Gi"),
+		createQuotaPv("pv3", "testId", "testNode", "team-b", "4Gi"),
+		createQuotaPv("pv4", "testId", "otherNode", "team-a", "8Gi"),
+		createQuotaPv("pv5", "otherId", "testNode", "team-a", "16Gi"),
+		createQuotaPv("pv6", "testId", "testNode", "team-a", ""),
+		csiQuotaPv("pv7", "testId", "testNode", "team-c", "32Gi"),
+		csiQuotaPv("pv8", "testId", "otherNode", "team-c", "64Gi"),
+	}
+	pvs[1].Annotations[annStoragePool] = "fast"
+	tests := []struct {
+		name        string
+		namespace   string
+		pool        string
+		exclude     string
+		want        string
+		wantVolumes int64
+	}{
+		{
+			name:        "sums matching volumes",
+			namespace:   "team-a",
+			want:        "3Gi",
+			wantVolumes: 3,
+		},
+		{
+			name:        "excludes volume being provisioned",
+			namespace:   "team-a",
+			exclude:     "pv2",
+			want:        "1Gi",
+			wantVolumes: 2,
+		},
+		{
+			name:        "other namespace",
+			namespace:   "team-b",
+			want:        "4Gi",
+			wantVolumes: 1,
+		},
+		{
+			name:        "volumes of the CSI driver",
+			namespace:   "team-c",
+			want:        "32Gi",
+			wantVolumes: 1,
+		},
+		{
+			name:        "volumes in a pool",
+			namespace:   "team-a",
+			pool:        "fast",
+			want:        "2Gi",
+			wantVolumes: 1,
+		},
+		{
+			name:        "volumes in the default pool",
+			namespace:   "team-a",
+			pool:        defaultPoolName,
+			want:        "1Gi",
+			wantVolumes: 2,
+		},
+		{
+			name:      "namespace without volumes",
+			namespace: "team-d",
+			want:      "0",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := calculateNamespaceUsage(pvs, "testId", "testNode", tt.namespace, tt.pool, tt.exclude)
+			if got.storage.Cmp(resource.MustParse(tt.want)) != 0 {
+				t.Errorf("calculateNamespaceUsage() = %s, want %s", got.storage.String(), tt.want)
+			}
+			if got.volumes != tt.wantVolumes {
+				t.Errorf("calculateNamespaceUsage() volumes = %d, want %d", got.volumes, tt.wantVolumes)
+			}
+		})
+	}
+}
+
+func Test_checkQuota(t *testing.T) {
+	tests := []struct {
+		name      string
+		quota     namespaceQuota
+		used      string
+		volumes   int64
+		requested string
+		wantErr   bool
+	}{
+		{
+			name:      "fits in quota",
+			quota:     namespaceQuota{Storage: quantityPtr("10Gi")},
+			used:      "4Gi",
+			requested: "6Gi",
+		},
+		{
+			name:      "exceeds quota",
+			quota:     namespaceQuota{Storage: quantityPtr("10Gi")},
+			used:      "5Gi",
+			requested: "6Gi",
+			wantErr:   true,
+		},
+		{
+			name:      "fits in volume quota",
+			quota:     namespaceQuota{Volumes: int64Ptr(3)},
+			used:      "4Gi",
+			volumes:   2,
+			requested: "6Gi",
+		},
+		{
+			name:      "exceeds volume quota",
+			quota:     namespaceQuota{Storage: quantityPtr("100Gi"), Volumes: int64Ptr(3)},
+			used:      "4Gi",
+			volumes:   3,
+			requested: "6Gi",
+			wantErr:   true,
+		},
+		{
+			name:      "no storage limit",
+			quota:     namespaceQuota{},
+			used:      "500Gi",
+			requested: "6Gi",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			usage := namespaceUsage{storage: resource.MustParse(tt.used), volumes: tt.volumes}
+			err := checkQuota("team-a", "testNode", tt.quota, usage, resource.MustParse(tt.requested))
+			if (err != nil) != tt.wantErr {
+				t.Errorf("checkQuota() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func Test_checkPoolQuota(t *testing.T) {
+	usage := namespaceUsage{storage: resource.MustParse("4Gi"), volumes: 2}
+	if err := checkPoolQuota("team-a", "fast", "testNode", poolQuota{Storage: quantityPtr("10Gi"), Volumes: int64Ptr(3)}, usage, resource.MustParse("6Gi")); err != nil {
+		t.Errorf("checkPoolQuota() of a claim fitting in the pool = %v", err)
+	}
+	if err := checkPoolQuota("team-a", "fast", "testNode", poolQuota{Storage: quantityPtr("10Gi")}, usage, resource.MustParse("7Gi")); err == nil {
+		t.Error("checkPoolQuota() of a claim exceeding the pool's storage quota succeeded")
+	}
+	if err := checkPoolQuota("team-a", "fast", "testNode", poolQuota{Volumes: int64Ptr(2)}, usage, resource.MustParse("1Gi")); err == nil {
+		t.Error("checkPoolQuota() of a claim exceeding the pool's volume quota succeeded")
+	}
+}
+
+func Test_quotaManagerPoolLimits(t *testing.T) {
+	fast := createQuotaPv("pv1", "testId", "testNode", "team-a", "2Gi")
+	fast.Annotations[annStoragePool] = "fast"
+	other := createQuotaPv("pv2", "testId", "testNode", "team-a", "4Gi")
+	quotas := &v1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{Name: "quotas", Namespace: "hostpath"},
+		Data: map[string]string{
+			"team-a": "storage: 10Gi\npools:\n  fast:\n    storage: 3Gi\n    volumes: 2",
+		},
+	}
+	q := newQuotaManager(fake.NewSimpleClientset(quotas, &fast, &other), nil, "testId", "testNode", "hostpath", "quotas")
+	claim := func(requested string) *v1.PersistentVolumeClaim {
+		return &v1.PersistentVolumeClaim{
+			ObjectMeta: metav1.ObjectMeta{Name: "claim", Namespace: "team-a"},
+			Spec: v1.PersistentVolumeClaimSpec{
+				Resources: v1.VolumeResourceRequirements{
+					Requests: v1.ResourceList{v1.ResourceStorage: resource.MustParse(requested)},
+				},
+			},
+		}
+	}
+
+	steps := []struct {
+		name      string
+		pv        string
+		pool      string
+		requested string
+		wantErr   bool
+	}{
+		{name: "exceeds the pool's storage quota", pv: "pv3", pool: "fast", requested: "2Gi", wantErr: true},
+		{name: "fits in the pool", pv: "pv3", pool: "fast", requested: "1Gi"},
+		{name: "exceeds the pool's volume quota, counting the reservation", pv: "pv4", pool: "fast", requested: "1Gi", wantErr: true},
+		{name: "pool without a limit of its own", pv: "pv4", pool: defaultPoolName, requested: "2Gi"},
+		{name: "exceeds the quota of the node", pv: "pv5", pool: "slow", requested: "2Gi", wantErr: true},
+	}
+	for _, step := range steps {
+		if err := q.reserve(claim(step.requested), step.pv, step.pool); (err != nil) != step.wantErr {
+			t.Errorf("%s: reserve() error = %v, wantErr %v", step.name, err, step.wantErr)
+		}
+	}
+}
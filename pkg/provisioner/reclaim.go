@@ -0,0 +1,51 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package provisioner
+
+import (
+	"fmt"
+
+	v1 "k8s.io/api/core/v1"
+	"sigs.k8s.io/sig-storage-lib-external-provisioner/v13/controller"
+)
+
+// defaultReclaimPolicy is used for storage classes without a reclaim policy
+// unless the provisioner is configured otherwise.
+const defaultReclaimPolicy = v1.PersistentVolumeReclaimDelete
+
+// validateReclaimPolicy checks that policy is one a provisioned volume can
+// have, Recycle is not.
+func validateReclaimPolicy(policy string) error {
+	switch v1.PersistentVolumeReclaimPolicy(policy) {
+	case v1.PersistentVolumeReclaimDelete, v1.PersistentVolumeReclaimRetain:
+		return nil
+	}
+	return fmt.Errorf("invalid reclaim policy %q, expected %s or %s", policy, v1.PersistentVolumeReclaimDelete, v1.PersistentVolumeReclaimRetain)
+}
+
+// reclaimPolicyFor returns the reclaim policy of the volume provisioned for a
+// claim: the one of its StorageClass, or the provisioner's default when the
+// class does not set one.
+func (p *HostPathProvisioner) reclaimPolicyFor(options controller.ProvisionOptions) v1.PersistentVolumeReclaimPolicy {
+	if options.StorageClass != nil && options.StorageClass.ReclaimPolicy != nil {
+		return *options.StorageClass.ReclaimPolicy
+	}
+	if policy := p.currentReclaimPolicy(); policy != "" {
+		return policy
+	}
+	return defaultReclaimPolicy
+}
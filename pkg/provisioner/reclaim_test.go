@@ -0,0 +1,57 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package provisioner
+
+import (
+	"testing"
+
+	v1 "k8s.io/api/core/v1"
+	storage "k8s.io/api/storage/v1"
+	"sigs.k8s.io/sig-storage-lib-external-provisioner/v13/controller"
+)
+
+func Test_reclaimPolicyFor(t *testing.T) {
+	retain := v1.PersistentVolumeReclaimRetain
+	del := v1.PersistentVolumeReclaimDelete
+	tests := []struct {
+		name          string
+		defaultPolicy v1.PersistentVolumeReclaimPolicy
+		class         *storage.StorageClass
+		want          v1.PersistentVolumeReclaimPolicy
+	}{
+		{name: "nothing set", want: v1.PersistentVolumeReclaimDelete},
+		{name: "provisioner default", defaultPolicy: retain, class: &storage.StorageClass{}, want: retain},
+		{name: "storage class wins", defaultPolicy: retain, class: &storage.StorageClass{ReclaimPolicy: &del}, want: del},
+		{name: "storage class retains", class: &storage.StorageClass{ReclaimPolicy: &retain}, want: retain},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := &HostPathProvisioner{reclaimPolicy: tt.defaultPolicy}
+			if got := p.reclaimPolicyFor(controller.ProvisionOptions{StorageClass: tt.class}); got != tt.want {
+				t.Errorf("reclaimPolicyFor() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_validateReclaimPolicy(t *testing.T) {
+	for policy, valid := range map[string]bool{"Delete": true, "Retain": true, "Recycle": false, "retain": false, "": false} {
+		if err := validateReclaimPolicy(policy); (err == nil) != valid {
+			t.Errorf("validateReclaimPolicy(%q) = %v, valid %v", policy, err, valid)
+		}
+	}
+}
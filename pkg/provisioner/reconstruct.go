@@ -0,0 +1,132 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package provisioner
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/klog/v2"
+)
+
+// quarantineDir is the directory in a pool unmatched backing directories are
+// moved into.
+const quarantineDir = ".quarantine"
+
+// rebuildAllocations fills a new allocation database in from the PVs of the
+// node and the pools on disk. Backing directories are matched to PVs by
+// their path, directories that look like backing directories by their name or
+// identity tag but that no PV refers to are logged, and quarantined when
+// asked to. It returns the number of unmatched directories.
+func rebuildAllocations(db *allocationDB, pools []*storagePool, pvs []v1.PersistentVolume, identity, nodeName string, quarantine bool, cutoff time.Time) (int, error) {
+	if _, _, err := db.reconcile(pvs, identity, nodeName); err != nil {
+		return 0, err
+	}
+	referenced := make(map[string]bool)
+	for _, pv := range pvs {
+		if path := backingPath(&pv); path != "" {
+			referenced[filepath.Clean(path)] = true
+		}
+	}
+	found := make(map[string]bool)
+	unmatched := 0
+	for _, pool := range pools {
+		if pool.devices != nil {
+			continue
+		}
+		entries, err := ioutil.ReadDir(pool.path)
+		if err != nil {
+			return unmatched, fmt.Errorf("unable to read pool %s: %v", pool.name, err)
+		}
+		for _, entry := range entries {
+			if !entry.IsDir() || strings.HasPrefix(entry.Name(), ".") {
+				continue
+			}
+			dir := filepath.Join(pool.path, entry.Name())
+			if referenced[dir] {
+				found[dir] = true
+				continue
+			}
+			tag, err := readVolumeIdentity(dir)
+			if err != nil && !backingDirName.MatchString(entry.Name()) {
+				// Not a backing directory, pools may be shared with other data
+				continue
+			}
+			unmatched++
+			if err == nil {
+				klog.Warningf("backing directory %s in pool %s matches no PV, it was created for claim %s/%s as PV %s", dir, pool.name, tag.Namespace, tag.Claim, tag.PV)
+			} else {
+				klog.Warningf("backing directory %s in pool %s matches no PV", dir, pool.name)
+			}
+			switch {
+			case !quarantine:
+			case entry.ModTime().After(cutoff):
+				klog.InfoS("Leaving recently modified backing directory out of quarantine", "pool", pool.name, "path", dir)
+			case isMountPoint(dir):
+				klog.Warningf("backing directory %s is a mount point and is left to be cleaned up by hand", dir)
+			default:
+				if err := quarantineBackingDir(pool, dir); err != nil {
+					klog.ErrorS(err, "Failed to quarantine backing directory", "pool", pool.name, "path", dir)
+				}
+			}
+		}
+	}
+	allocations, err := db.list()
+	if err != nil {
+		return unmatched, err
+	}
+	for _, a := range allocations {
+		if backingDirIn(pools, a.Path) && !found[filepath.Clean(a.Path)] {
+			klog.Warningf("backing directory %s of volume %s is missing", a.Path, a.PV)
+		}
+	}
+	return unmatched, nil
+}
+
+// backingDirIn returns whether path is a backing directory in one of the
+// pools of directories.
+func backingDirIn(pools []*storagePool, path string) bool {
+	for _, pool := range pools {
+		if pool.devices == nil && filepath.Dir(filepath.Clean(path)) == filepath.Clean(pool.path) {
+			return true
+		}
+	}
+	return false
+}
+
+// quarantineBackingDir moves dir, and its identity file if it has one, into
+// the quarantine directory of the pool, where it is left to be looked at by
+// hand.
+func quarantineBackingDir(pool *storagePool, dir string) error {
+	quarantined := filepath.Join(pool.path, quarantineDir)
+	if err := os.MkdirAll(quarantined, 0700); err != nil {
+		return err
+	}
+	klog.InfoS("Quarantining backing directory", "pool", pool.name, "path", dir, "quarantine", quarantined)
+	if err := os.Rename(dir, filepath.Join(quarantined, filepath.Base(dir))); err != nil {
+		return err
+	}
+	if err := os.Rename(identityFile(dir), identityFile(filepath.Join(quarantined, filepath.Base(dir)))); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
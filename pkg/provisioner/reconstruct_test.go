@@ -0,0 +1,115 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package provisioner
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func Test_rebuildAllocations(t *testing.T) {
+	const (
+		bound   = "pvc-11111111-2222-3333-4444-555555555555"
+		orphan  = "pvc-aaaaaaaa-bbbb-cccc-dddd-eeeeeeeeeeee"
+		tagged  = "team-a_data"
+		recent  = "pvc-99999999-bbbb-cccc-dddd-eeeeeeeeeeee"
+		foreign = "not-a-volume"
+	)
+	tests := []struct {
+		name       string
+		quarantine bool
+		wantMoved  []string
+	}{
+		{name: "log only", quarantine: false},
+		{name: "quarantine", quarantine: true, wantMoved: []string{orphan, tagged}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir, err := ioutil.TempDir("", "rebuild")
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer os.RemoveAll(dir)
+			old := time.Now().Add(-time.Hour)
+			for _, name := range []string{bound, orphan, tagged, recent, foreign} {
+				if err := os.Mkdir(filepath.Join(dir, name), 0755); err != nil {
+					t.Fatal(err)
+				}
+				if name != recent {
+					os.Chtimes(filepath.Join(dir, name), old, old)
+				}
+			}
+			if err := writeIdentityFile(filepath.Join(dir, tagged), volumeIdentity{Namespace: "team-a", Claim: "data", PV: "pvc-gone"}); err != nil {
+				t.Fatal(err)
+			}
+			pv := func(name, path string) v1.PersistentVolume {
+				return v1.PersistentVolume{
+					ObjectMeta: metav1.ObjectMeta{Name: name, Annotations: map[string]string{
+						"hostPathProvisionerIdentity": "id",
+						"kubevirt.io/provisionOnNode": "node1",
+						annRequestedCapacity:          "1Gi",
+					}},
+					Spec: v1.PersistentVolumeSpec{PersistentVolumeSource: v1.PersistentVolumeSource{
+						HostPath: &v1.HostPathVolumeSource{Path: path},
+					}},
+				}
+			}
+			pvs := []v1.PersistentVolume{
+				pv(bound, filepath.Join(dir, bound)+"/"),
+				pv("pvc-missing", filepath.Join(dir, "pvc-missing")),
+			}
+
+			db, _, err := openAllocationDB(filepath.Join(dir, ".allocations.db"))
+			if err != nil {
+				t.Fatal(err)
+			}
+			unmatched, err := rebuildAllocations(db, []*storagePool{{name: "default", path: dir}}, pvs, "id", "node1", tt.quarantine, time.Now().Add(-orphanGracePeriod))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if unmatched != 3 {
+				t.Errorf("rebuildAllocations() found %d unmatched directories, want 3", unmatched)
+			}
+			// Volumes whose directory is missing are still recorded
+			if list, err := db.list(); err != nil || len(list) != 2 || list[0].PV != bound || list[1].PV != "pvc-missing" {
+				t.Errorf("rebuildAllocations() recorded %+v, %v, want %s and pvc-missing", list, err, bound)
+			}
+
+			moved := map[string]bool{}
+			for _, name := range tt.wantMoved {
+				moved[name] = true
+			}
+			for _, name := range []string{bound, orphan, tagged, recent, foreign} {
+				_, err := os.Stat(filepath.Join(dir, quarantineDir, name))
+				if quarantined := err == nil; quarantined != moved[name] {
+					t.Errorf("directory %s quarantined = %v, want %v", name, quarantined, moved[name])
+				}
+			}
+			if moved[tagged] {
+				if _, err := readVolumeIdentity(filepath.Join(dir, quarantineDir, tagged)); err != nil {
+					t.Errorf("identity of quarantined directory: %v", err)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,180 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package provisioner
+
+import (
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// btrfsSuperMagic is the f_type statfs reports for btrfs.
+	btrfsSuperMagic = 0x9123683e
+
+	// btrfsUsageTTL is how long the usage btrfs reports is reused, it is
+	// asked for on every provision and metrics scrape.
+	btrfsUsageTTL = 10 * time.Second
+
+	sysClassBlockPath = "/sys/class/block"
+)
+
+// btrfsUsage is the usage of a btrfs filesystem, as estimated by btrfs for
+// the profiles of its data.
+type btrfsUsage struct {
+	// profile of the data, e.g. raid1, dup or single
+	profile string
+	// dataRatio is how many bytes of the devices a byte of data takes
+	dataRatio float64
+	// used is the space of the devices used, free the data that still fits
+	used, free int64
+}
+
+// capacity returns the data the filesystem holds when full: the data it
+// holds, its used space of the devices without the copies, and the data
+// that still fits.
+func (u btrfsUsage) capacity() int64 {
+	return int64(float64(u.used)/u.dataRatio) + u.free
+}
+
+// parseBtrfsUsage parses the output of btrfs filesystem usage -b.
+func parseBtrfsUsage(output string) (btrfsUsage, error) {
+	usage := btrfsUsage{}
+	found := map[string]bool{}
+	for _, line := range strings.Split(output, "\n") {
+		line = strings.TrimSpace(line)
+		if strings.HasPrefix(line, "Data,") && usage.profile == "" {
+			usage.profile = strings.ToLower(strings.SplitN(strings.TrimPrefix(line, "Data,"), ":", 2)[0])
+			continue
+		}
+		parts := strings.SplitN(line, ":", 2)
+		if len(parts) != 2 {
+			continue
+		}
+		fields := strings.Fields(parts[1])
+		if len(fields) == 0 {
+			continue
+		}
+		var err error
+		switch parts[0] {
+		case "Used":
+			usage.used, err = strconv.ParseInt(fields[0], 10, 64)
+		case "Free (estimated)":
+			usage.free, err = strconv.ParseInt(fields[0], 10, 64)
+		case "Data ratio":
+			usage.dataRatio, err = strconv.ParseFloat(fields[0], 64)
+		default:
+			continue
+		}
+		if err != nil {
+			return btrfsUsage{}, fmt.Errorf("invalid btrfs usage %q: %v", line, err)
+		}
+		found[parts[0]] = true
+	}
+	if !found["Used"] || !found["Free (estimated)"] || !found["Data ratio"] || usage.dataRatio <= 0 {
+		return btrfsUsage{}, fmt.Errorf("unexpected btrfs filesystem usage output")
+	}
+	return usage, nil
+}
+
+// btrfsUsageCache remembers the usage of the btrfs filesystems of pools for
+// btrfsUsageTTL, by path.
+type btrfsUsageCache struct {
+	mutex   sync.Mutex
+	entries map[string]btrfsUsageEntry
+}
+
+type btrfsUsageEntry struct {
+	usage btrfsUsage
+	err   error
+	time  time.Time
+}
+
+// btrfsUsages are shared by the provisioners of the process, the usage of a
+// filesystem is the same for all of them.
+var btrfsUsages = &btrfsUsageCache{entries: map[string]btrfsUsageEntry{}}
+
+func (c *btrfsUsageCache) get(path string) (btrfsUsage, error) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	if entry, ok := c.entries[path]; ok && time.Since(entry.time) < btrfsUsageTTL {
+		return entry.usage, entry.err
+	}
+	entry := btrfsUsageEntry{time: time.Now()}
+	output, err := runCommand("btrfs", "filesystem", "usage", "-b", path)
+	if err == nil {
+		entry.usage, err = parseBtrfsUsage(string(output))
+	}
+	entry.err = err
+	c.entries[path] = entry
+	return entry.usage, entry.err
+}
+
+// poolRedundancy returns the redundancy of the filesystem of the pool: the
+// profile of btrfs data, e.g. raid1, or the level of the md array it is on,
+// empty when it has none or it can not be told.
+func poolRedundancy(pool *storagePool, mountsPath string) string {
+	if pool.lvm != nil || pool.devices != nil {
+		return ""
+	}
+	mounts, err := readMounts(mountsPath)
+	if err != nil {
+		return ""
+	}
+	mount := findMount(mounts, filepath.Clean(pool.path))
+	if mount == nil {
+		return ""
+	}
+	if mount.fsType == "btrfs" {
+		usage, err := btrfsUsages.get(pool.path)
+		if err != nil || usage.profile == "single" {
+			return ""
+		}
+		return "btrfs " + usage.profile
+	}
+	if level := mdLevel(sysClassBlockPath, mount.device); level != "" {
+		return "md " + level
+	}
+	return ""
+}
+
+// mdLevel returns the RAID level of the md array device is, or is a
+// partition of, from sysfs.
+func mdLevel(sysClassBlock, device string) string {
+	if resolved, err := filepath.EvalSymlinks(device); err == nil {
+		device = resolved
+	}
+	name := filepath.Base(device)
+	if !strings.HasPrefix(name, "md") {
+		return ""
+	}
+	// Partitions are below their array in sysfs
+	dir, err := filepath.EvalSymlinks(filepath.Join(sysClassBlock, name))
+	if err != nil {
+		return ""
+	}
+	for _, dir := range []string{dir, filepath.Dir(dir)} {
+		if level, err := ioutil.ReadFile(filepath.Join(dir, "md", "level")); err == nil {
+			return strings.TrimSpace(string(level))
+		}
+	}
+	return ""
+}
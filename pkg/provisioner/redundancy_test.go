@@ -0,0 +1,174 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package provisioner
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+const btrfsRAID1Usage = `Overall:
+    Device size:		         21474836480
+    Device allocated:		          6463422464
+    Device unallocated:		         15011414016
+    Device missing:		                   0
+    Used:			           4295229440
+    Free (estimated):		          9640488960	(min: 9640488960)
+    Free (statfs, df):		          9640488960
+    Data ratio:			                2.00
+    Metadata ratio:		                2.00
+    Global reserve:		             3670016	(used: 0)
+    Multiple profiles:		                  no
+
+Data,RAID1: Size:3221225472, Used:2147352576 (66.66%)
+   /dev/vdb	3221225472
+   /dev/vdc	3221225472
+
+Metadata,RAID1: Size:1073741824, Used:262144 (0.02%)
+   /dev/vdb	1073741824
+   /dev/vdc	1073741824
+
+System,RAID1: Size:8388608, Used:16384 (0.20%)
+   /dev/vdb	   8388608
+   /dev/vdc	   8388608
+
+Unallocated:
+   /dev/vdb	7505707008
+   /dev/vdc	7505707008
+`
+
+func Test_parseBtrfsUsage(t *testing.T) {
+	tests := []struct {
+		name    string
+		output  string
+		want    btrfsUsage
+		wantErr bool
+	}{
+		{name: "raid1", output: btrfsRAID1Usage, want: btrfsUsage{profile: "raid1", dataRatio: 2, used: 4295229440, free: 9640488960}},
+		{name: "single", output: "Overall:\n    Used:   1048576\n    Free (estimated):   2097152  (min: 1048576)\n    Data ratio:   1.00\n\nData,single: Size:8388608, Used:1048576\n", want: btrfsUsage{profile: "single", dataRatio: 1, used: 1048576, free: 2097152}},
+		{name: "not bytes", output: "Overall:\n    Used:   1.00GiB\n    Free (estimated):   2.00GiB\n    Data ratio:   1.00\n", wantErr: true},
+		{name: "empty", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := parseBtrfsUsage(tt.output)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("parseBtrfsUsage() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("parseBtrfsUsage() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_btrfsUsageCache(t *testing.T) {
+	command := "btrfs filesystem usage -b /var/hpvolumes"
+	commands, restore := fakeCommands(map[string]string{command: btrfsRAID1Usage})
+	defer restore()
+	c := &btrfsUsageCache{entries: map[string]btrfsUsageEntry{}}
+	for i := 0; i < 3; i++ {
+		if usage, err := c.get("/var/hpvolumes"); err != nil || usage.profile != "raid1" {
+			t.Errorf("get() = %+v, %v", usage, err)
+		}
+	}
+	if !reflect.DeepEqual(*commands, []string{command}) {
+		t.Errorf("commands = %v, want the usage read once", *commands)
+	}
+	if _, err := c.get("/var/other"); err == nil {
+		t.Errorf("get() of a failing command succeeded")
+	}
+}
+
+func Test_mdLevel(t *testing.T) {
+	dir, err := ioutil.TempDir("", "sysblock")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	// /sys/class/block links to the device directories, partitions are below
+	// their array
+	md0 := filepath.Join(dir, "devices", "virtual", "block", "md0")
+	if err := os.MkdirAll(filepath.Join(md0, "md0p1"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	os.MkdirAll(filepath.Join(md0, "md"), 0755)
+	ioutil.WriteFile(filepath.Join(md0, "md", "level"), []byte("raid10\n"), 0644)
+	sda := filepath.Join(dir, "devices", "pci", "block", "sda")
+	os.MkdirAll(sda, 0755)
+	class := filepath.Join(dir, "class")
+	os.MkdirAll(class, 0755)
+	os.Symlink(md0, filepath.Join(class, "md0"))
+	os.Symlink(filepath.Join(md0, "md0p1"), filepath.Join(class, "md0p1"))
+	os.Symlink(sda, filepath.Join(class, "sda"))
+
+	tests := []struct {
+		device string
+		want   string
+	}{
+		{device: "/dev/md0", want: "raid10"},
+		{device: "/dev/md0p1", want: "raid10"},
+		{device: "/dev/sda"},
+		{device: "/dev/md127"},
+	}
+	for _, tt := range tests {
+		if got := mdLevel(class, tt.device); got != tt.want {
+			t.Errorf("mdLevel(%s) = %q, want %q", tt.device, got, tt.want)
+		}
+	}
+}
+
+func Test_poolRedundancy(t *testing.T) {
+	dir, err := ioutil.TempDir("", "mounts")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	mountsFile := filepath.Join(dir, "mounts")
+	mounts := "/dev/vdb /var/hpvolumes/btrfs btrfs rw 0 0\n/dev/vdd /var/hpvolumes/single btrfs rw 0 0\n/dev/sda1 /var/hpvolumes/ext4 ext4 rw 0 0\n"
+	if err := ioutil.WriteFile(mountsFile, []byte(mounts), 0644); err != nil {
+		t.Fatal(err)
+	}
+	_, restore := fakeCommands(map[string]string{
+		"btrfs filesystem usage -b /var/hpvolumes/btrfs":  btrfsRAID1Usage,
+		"btrfs filesystem usage -b /var/hpvolumes/single": "Overall:\n    Used:   1048576\n    Free (estimated):   2097152\n    Data ratio:   1.00\n\nData,single: Size:8388608, Used:1048576\n",
+	})
+	defer restore()
+	usages := btrfsUsages
+	defer func() {
+		btrfsUsages = usages
+	}()
+	btrfsUsages = &btrfsUsageCache{entries: map[string]btrfsUsageEntry{}}
+
+	tests := []struct {
+		pool *storagePool
+		want string
+	}{
+		{pool: &storagePool{name: "btrfs", path: "/var/hpvolumes/btrfs"}, want: "btrfs raid1"},
+		{pool: &storagePool{name: "single", path: "/var/hpvolumes/single"}},
+		{pool: &storagePool{name: "ext4", path: "/var/hpvolumes/ext4"}},
+		{pool: &storagePool{name: "lvm", path: "/var/hpvolumes/btrfs", lvm: &lvmPool{volumeGroup: "vg0"}}},
+	}
+	for _, tt := range tests {
+		if got := poolRedundancy(tt.pool, mountsFile); got != tt.want {
+			t.Errorf("poolRedundancy(%s) = %q, want %q", tt.pool.name, got, tt.want)
+		}
+	}
+}
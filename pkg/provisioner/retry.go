@@ -0,0 +1,108 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package provisioner
+
+import (
+	"fmt"
+	"math"
+	"sync"
+	"time"
+
+	"golang.org/x/time/rate"
+
+	"k8s.io/client-go/util/workqueue"
+	"sigs.k8s.io/sig-storage-lib-external-provisioner/v13/controller"
+)
+
+// The delays between retries, those of the controller's default rate limiter.
+const (
+	defaultRetryInitialDelay = 15 * time.Second
+	defaultRetryMaxDelay     = 1000 * time.Second
+)
+
+// retryOptions returns the controller options applying the retry policy of
+// the controller configuration.
+func retryOptions(retry RetryConfig) ([]func(*controller.ProvisionController) error, error) {
+	limiter, err := newBackoffRateLimiter(retry.InitialDelay.Duration, retry.MaxDelay.Duration, retry.Multiplier)
+	if err != nil {
+		return nil, err
+	}
+	if retry.MaxAttempts < 0 {
+		return nil, fmt.Errorf("invalid maximum number of retries %d, must not be negative", retry.MaxAttempts)
+	}
+	if retry.CreateVolumeAttempts < 1 || retry.CreateVolumeInterval.Duration <= 0 {
+		return nil, fmt.Errorf("invalid retries of storing provisioned volumes, %d attempts every %v, both must be positive", retry.CreateVolumeAttempts, retry.CreateVolumeInterval.Duration)
+	}
+	return []func(*controller.ProvisionController) error{
+		// The bucket limits the overall rate, like the controller's default
+		// rate limiter does
+		controller.RateLimiter(workqueue.NewTypedMaxOfRateLimiter[string](limiter, &workqueue.TypedBucketRateLimiter[string]{Limiter: rate.NewLimiter(rate.Limit(10), 100)})),
+		controller.FailedProvisionThreshold(retry.MaxAttempts),
+		controller.FailedDeleteThreshold(retry.MaxAttempts),
+		controller.CreateProvisionedPVRetryCount(retry.CreateVolumeAttempts),
+		controller.CreateProvisionedPVInterval(retry.CreateVolumeInterval.Duration),
+	}, nil
+}
+
+// backoffRateLimiter delays the retries of an item exponentially, by a
+// configurable multiplier, up to a maximum delay.
+type backoffRateLimiter struct {
+	initial, max time.Duration
+	multiplier   float64
+
+	mutex    sync.Mutex
+	failures map[string]int
+}
+
+var _ workqueue.TypedRateLimiter[string] = &backoffRateLimiter{}
+
+func newBackoffRateLimiter(initial, max time.Duration, multiplier float64) (*backoffRateLimiter, error) {
+	if initial <= 0 || max < initial {
+		return nil, fmt.Errorf("invalid retry delays, the initial delay %v must be positive and not above the maximum delay %v", initial, max)
+	}
+	if multiplier < 1 {
+		return nil, fmt.Errorf("invalid retry multiplier %v, must be at least 1", multiplier)
+	}
+	return &backoffRateLimiter{initial: initial, max: max, multiplier: multiplier, failures: map[string]int{}}, nil
+}
+
+// When returns how long to wait before retrying item, and counts a failure.
+func (r *backoffRateLimiter) When(item string) time.Duration {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	failures := r.failures[item]
+	r.failures[item] = failures + 1
+	delay := float64(r.initial) * math.Pow(r.multiplier, float64(failures))
+	if delay > float64(r.max) {
+		return r.max
+	}
+	return time.Duration(delay)
+}
+
+// NumRequeues returns how often item failed.
+func (r *backoffRateLimiter) NumRequeues(item string) int {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	return r.failures[item]
+}
+
+// Forget resets the failures of item once it succeeded or was given up on.
+func (r *backoffRateLimiter) Forget(item string) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	delete(r.failures, item)
+}
@@ -0,0 +1,61 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package provisioner
+
+import (
+	"testing"
+	"time"
+)
+
+func Test_backoffRateLimiter(t *testing.T) {
+	limiter, err := newBackoffRateLimiter(time.Second, 10*time.Second, 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i, want := range []time.Duration{time.Second, 3 * time.Second, 9 * time.Second, 10 * time.Second, 10 * time.Second} {
+		if got := limiter.When("claim"); got != want {
+			t.Errorf("retry %d: When() = %v, want %v", i, got, want)
+		}
+	}
+	if got := limiter.NumRequeues("claim"); got != 5 {
+		t.Errorf("NumRequeues() = %d, want 5", got)
+	}
+	if got := limiter.When("other"); got != time.Second {
+		t.Errorf("When() of another item = %v, want %v", got, time.Second)
+	}
+	limiter.Forget("claim")
+	if got := limiter.When("claim"); got != time.Second {
+		t.Errorf("When() after Forget() = %v, want %v", got, time.Second)
+	}
+
+	// Many failures do not overflow
+	for i := 0; i < 100; i++ {
+		limiter.When("failing")
+	}
+	if got := limiter.When("failing"); got != 10*time.Second {
+		t.Errorf("When() after many failures = %v, want %v", got, 10*time.Second)
+	}
+
+	for _, invalid := range []struct {
+		initial, max time.Duration
+		multiplier   float64
+	}{{0, time.Second, 2}, {time.Minute, time.Second, 2}, {time.Second, time.Minute, 0.5}} {
+		if _, err := newBackoffRateLimiter(invalid.initial, invalid.max, invalid.multiplier); err == nil {
+			t.Errorf("newBackoffRateLimiter(%v, %v, %v) accepted an invalid policy", invalid.initial, invalid.max, invalid.multiplier)
+		}
+	}
+}
@@ -0,0 +1,86 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package provisioner
+
+import (
+	"sync"
+
+	"k8s.io/klog/v2"
+)
+
+// rollback undoes the steps of creating a volume that completed when a later
+// one fails, so that a failed attempt leaves no mounted logical volume, image,
+// tmpfs or export behind for a claim that may be deleted before a retry
+// succeeds.
+type rollback struct {
+	mutex     sync.Mutex
+	steps     []rollbackStep
+	finished  bool
+	abandoned bool
+}
+
+type rollbackStep struct {
+	description string
+	undo        func() error
+}
+
+// add records a completed step, undo reverts it.
+func (r *rollback) add(description string, undo func() error) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	r.steps = append(r.steps, rollbackStep{description: description, undo: undo})
+}
+
+// finish is called with the result of the steps. The completed steps are
+// undone when they failed, or when the caller stopped waiting for them as they
+// timed out.
+func (r *rollback) finish(err error) error {
+	r.mutex.Lock()
+	r.finished = true
+	abandoned := r.abandoned
+	r.mutex.Unlock()
+	if err != nil || abandoned {
+		r.run()
+	}
+	return err
+}
+
+// abandon is called when the caller stopped waiting for the steps. Steps still
+// running are undone once they finish, see finish.
+func (r *rollback) abandon() {
+	r.mutex.Lock()
+	r.abandoned = true
+	finished := r.finished
+	r.mutex.Unlock()
+	if finished {
+		r.run()
+	}
+}
+
+// run undoes the completed steps in reverse order, each at most once. A step
+// that can not be undone is logged and the earlier ones are still undone.
+func (r *rollback) run() {
+	r.mutex.Lock()
+	steps := r.steps
+	r.steps = nil
+	r.mutex.Unlock()
+	for i := len(steps) - 1; i >= 0; i-- {
+		if err := steps[i].undo(); err != nil {
+			klog.Warningf("unable to roll back %s: %v", steps[i].description, err)
+		}
+	}
+}
@@ -0,0 +1,72 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package provisioner
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+)
+
+func Test_rollback(t *testing.T) {
+	tests := []struct {
+		name string
+		// abandon is when the caller stops waiting: "", "before" or "after"
+		// the steps finish
+		abandon string
+		err     error
+		want    []string
+	}{
+		{name: "success", want: nil},
+		{name: "failure", err: errors.New("export failed"), want: []string{"export", "mount", "directory"}},
+		{name: "timed out while running", abandon: "before", want: []string{"export", "mount", "directory"}},
+		{name: "timed out as it finished", abandon: "after", want: []string{"export", "mount", "directory"}},
+		{name: "failure after timing out", abandon: "before", err: errors.New("export failed"), want: []string{"export", "mount", "directory"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var undone []string
+			r := &rollback{}
+			for _, step := range []string{"directory", "mount", "export"} {
+				step := step
+				r.add(step, func() error {
+					undone = append(undone, step)
+					if step == "mount" {
+						return errors.New("busy")
+					}
+					return nil
+				})
+			}
+			if tt.abandon == "before" {
+				r.abandon()
+				if undone != nil {
+					t.Fatalf("undone %v while still running", undone)
+				}
+			}
+			if err := r.finish(tt.err); err != tt.err {
+				t.Errorf("finish() = %v, want %v", err, tt.err)
+			}
+			if tt.abandon != "" {
+				// Steps are undone at most once
+				r.abandon()
+			}
+			if !reflect.DeepEqual(undone, tt.want) {
+				t.Errorf("undone %v, want %v", undone, tt.want)
+			}
+		})
+	}
+}
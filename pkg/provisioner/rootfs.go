@@ -0,0 +1,57 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package provisioner
+
+import (
+	"k8s.io/klog/v2"
+)
+
+// checkRootfs returns whether the pool may be used given its relation to the
+// node's root filesystem. Pools on the root filesystem are only usable when
+// explicitly allowed, filling them up would take the node down.
+func (p *HostPathProvisioner) checkRootfs(pool *storagePool) bool {
+	// Nothing is written to the directory of a device pool, nor to that of
+	// a simulated one
+	if p.allowRootfs || pool.devices != nil || p.simulated {
+		return true
+	}
+	onRootfs, err := onSameFilesystem(pool.path, p.rootfsPath)
+	if err != nil {
+		klog.Errorf("Unable to determine if pool %s is on the root filesystem: %v", pool.name, err)
+		return false
+	}
+	if onRootfs {
+		klog.Errorf("pool %s at %s is on the root filesystem of the node, refusing to use it. Use --allow-rootfs to override", pool.name, pool.path)
+		return false
+	}
+	return true
+}
+
+// rootfsPools returns the names of the pools refused for being on the root
+// filesystem.
+func (p *HostPathProvisioner) rootfsPools() []string {
+	var refused []string
+	for _, pool := range p.currentPools() {
+		if p.allowRootfs || pool.devices != nil || p.simulated {
+			continue
+		}
+		if onRootfs, err := onSameFilesystem(pool.path, p.rootfsPath); err == nil && onRootfs {
+			refused = append(refused, pool.name)
+		}
+	}
+	return refused
+}
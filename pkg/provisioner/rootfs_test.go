@@ -0,0 +1,65 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package provisioner
+
+import (
+	"testing"
+)
+
+func Test_checkRootfs(t *testing.T) {
+	tests := []struct {
+		name        string
+		pool        string
+		rootfsPath  string
+		allowRootfs bool
+		want        bool
+	}{
+		{
+			name:       "refuses pool on root filesystem",
+			pool:       ".",
+			rootfsPath: ".",
+			want:       false,
+		},
+		{
+			name:        "allows pool on root filesystem when overridden",
+			pool:        ".",
+			rootfsPath:  ".",
+			allowRootfs: true,
+			want:        true,
+		},
+		{
+			name:       "refuses pool that cannot be checked",
+			pool:       "/doesntexist",
+			rootfsPath: ".",
+			want:       false,
+		},
+		{
+			name:       "allows pool on another filesystem",
+			pool:       ".",
+			rootfsPath: "/proc",
+			want:       true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := &HostPathProvisioner{allowRootfs: tt.allowRootfs, rootfsPath: tt.rootfsPath}
+			if got := p.checkRootfs(&storagePool{name: "test", path: tt.pool}); got != tt.want {
+				t.Errorf("checkRootfs() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,110 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package provisioner
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+	"syscall"
+
+	"sigs.k8s.io/sig-storage-lib-external-provisioner/v13/controller"
+)
+
+const (
+	// annSELinuxContext on a claim asks for its backing directory to be
+	// labeled with a context, e.g. system_u:object_r:container_file_t:s0. The
+	// provisioner records the context it applied in the same annotation on
+	// the PV, so that the health checks can restore it.
+	annSELinuxContext = "kubevirt.io/selinuxContext"
+	// selinuxContextParameter is the StorageClass parameter labeling all
+	// volumes of the class.
+	selinuxContextParameter = "selinuxContext"
+
+	selinuxXattr = "security.selinux"
+)
+
+// validateSELinuxContext checks that context has the user:role:type:level
+// form, the level may contain colons itself.
+func validateSELinuxContext(context string) error {
+	parts := strings.SplitN(context, ":", 4)
+	if len(parts) != 4 || parts[0] == "" || parts[1] == "" || parts[2] == "" || parts[3] == "" {
+		return fmt.Errorf("invalid SELinux context %q, expected user:role:type:level", context)
+	}
+	return nil
+}
+
+// selinuxContextFor returns the context the backing directory of a claim is
+// labeled with, empty when it keeps the one it inherits. A claim can only ask
+// for one of the allowed contexts, its annotation takes precedence over the
+// StorageClass parameter, which takes precedence over the configured context.
+func (p *HostPathProvisioner) selinuxContextFor(options controller.ProvisionOptions) (string, error) {
+	if options.PVC != nil {
+		if context, ok := options.PVC.Annotations[annSELinuxContext]; ok {
+			// Contexts are separated by spaces, categories such as c1,c2
+			// contain commas
+			for _, allowed := range p.allowedSELinuxContexts {
+				if allowed == context {
+					return context, nil
+				}
+			}
+			return "", fmt.Errorf("SELinux context %q is not allowed on this node", context)
+		}
+	}
+	if options.StorageClass != nil {
+		if context, ok := options.StorageClass.Parameters[selinuxContextParameter]; ok {
+			return context, validateSELinuxContext(context)
+		}
+	}
+	return p.currentSELinuxContext(), nil
+}
+
+// setSELinuxContext labels path with context, like chcon does.
+func setSELinuxContext(path, context string) error {
+	return setxattr(path, selinuxXattr, append([]byte(context), 0), 0)
+}
+
+// getSELinuxContext returns the context path is labeled with.
+func getSELinuxContext(path string) (string, error) {
+	buf := make([]byte, 256)
+	size, err := getxattr(path, selinuxXattr, buf)
+	if err == syscall.ERANGE {
+		buf = make([]byte, 4096)
+		size, err = getxattr(path, selinuxXattr, buf)
+	}
+	if err != nil {
+		return "", err
+	}
+	return string(bytes.TrimRight(buf[:size], "\x00")), nil
+}
+
+// restoreSELinuxContext relabels path when its context is not the expected
+// one, and returns whether it had to. Without relabel the difference is only
+// reported.
+func restoreSELinuxContext(path, context string, relabel bool) (bool, error) {
+	current, err := getSELinuxContext(path)
+	if err == nil && current == context {
+		return false, nil
+	}
+	if !relabel {
+		return false, fmt.Errorf("SELinux context of %s is %q instead of %s", path, current, context)
+	}
+	if err := setSELinuxContext(path, context); err != nil {
+		return false, fmt.Errorf("unable to restore SELinux context %s of %s: %v", context, path, err)
+	}
+	return true, nil
+}
@@ -0,0 +1,105 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package provisioner
+
+import (
+	"syscall"
+	"testing"
+
+	"sigs.k8s.io/sig-storage-lib-external-provisioner/v13/controller"
+
+	v1 "k8s.io/api/core/v1"
+	storage "k8s.io/api/storage/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+const testSELinuxContext = "system_u:object_r:container_file_t:s0"
+
+func Test_selinuxContextFor(t *testing.T) {
+	class := &storage.StorageClass{Parameters: map[string]string{selinuxContextParameter: testSELinuxContext}}
+	claim := func(context string) *v1.PersistentVolumeClaim {
+		return &v1.PersistentVolumeClaim{ObjectMeta: metav1.ObjectMeta{Annotations: map[string]string{annSELinuxContext: context}}}
+	}
+	tests := []struct {
+		name    string
+		options controller.ProvisionOptions
+		want    string
+		wantErr bool
+	}{
+		{name: "default", options: controller.ProvisionOptions{PVC: &v1.PersistentVolumeClaim{}}, want: "system_u:object_r:default_t:s0"},
+		{name: "storage class", options: controller.ProvisionOptions{PVC: &v1.PersistentVolumeClaim{}, StorageClass: class}, want: testSELinuxContext},
+		{name: "allowed annotation", options: controller.ProvisionOptions{PVC: claim("system_u:object_r:svirt_sandbox_file_t:s0"), StorageClass: class}, want: "system_u:object_r:svirt_sandbox_file_t:s0"},
+		{name: "annotation with categories", options: controller.ProvisionOptions{PVC: claim("system_u:object_r:container_file_t:s0:c1,c2")}, want: "system_u:object_r:container_file_t:s0:c1,c2"},
+		{name: "part of an allowed context", options: controller.ProvisionOptions{PVC: claim("system_u:object_r:container_file_t:s0:c1")}, wantErr: true},
+		{name: "annotation not allowed", options: controller.ProvisionOptions{PVC: claim("system_u:object_r:shadow_t:s0")}, wantErr: true},
+		{name: "invalid parameter", options: controller.ProvisionOptions{PVC: &v1.PersistentVolumeClaim{}, StorageClass: &storage.StorageClass{Parameters: map[string]string{selinuxContextParameter: "container_file_t"}}}, wantErr: true},
+	}
+	p := &HostPathProvisioner{
+		selinuxContext:         "system_u:object_r:default_t:s0",
+		allowedSELinuxContexts: []string{"system_u:object_r:container_file_t:s0:c1,c2", "system_u:object_r:svirt_sandbox_file_t:s0"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := p.selinuxContextFor(tt.options)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("selinuxContextFor() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !tt.wantErr && got != tt.want {
+				t.Errorf("selinuxContextFor() = %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_restoreSELinuxContext(t *testing.T) {
+	originalSet, originalGet := setxattr, getxattr
+	defer func() {
+		setxattr, getxattr = originalSet, originalGet
+	}()
+	xattrs := map[string][]byte{}
+	setxattr = func(path, name string, value []byte, flags int) error {
+		xattrs[path+name] = value
+		return nil
+	}
+	getxattr = func(path, name string, dest []byte) (int, error) {
+		value, ok := xattrs[path+name]
+		if !ok {
+			return 0, syscall.ENODATA
+		}
+		return copy(dest, value), nil
+	}
+
+	if err := setSELinuxContext("/pool/pvc-1", "system_u:object_r:unlabeled_t:s0"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := restoreSELinuxContext("/pool/pvc-1", testSELinuxContext, false); err == nil {
+		t.Error("restoreSELinuxContext() without relabeling did not report the changed context")
+	}
+	if got, err := getSELinuxContext("/pool/pvc-1"); err != nil || got == testSELinuxContext {
+		t.Errorf("context = %q, %v; want it left alone", got, err)
+	}
+	restored, err := restoreSELinuxContext("/pool/pvc-1", testSELinuxContext, true)
+	if err != nil || !restored {
+		t.Fatalf("restoreSELinuxContext() = %v, %v; want the context restored", restored, err)
+	}
+	if got, err := getSELinuxContext("/pool/pvc-1"); err != nil || got != testSELinuxContext {
+		t.Errorf("context = %q, %v; want %s", got, err, testSELinuxContext)
+	}
+	if restored, err := restoreSELinuxContext("/pool/pvc-1", testSELinuxContext, true); err != nil || restored {
+		t.Errorf("restoreSELinuxContext() = %v, %v; want nothing to do", restored, err)
+	}
+}
@@ -0,0 +1,76 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package provisioner
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"k8s.io/klog/v2"
+)
+
+// errShuttingDown is returned for operations started after the provisioner
+// began shutting down, they are retried after the restart.
+var errShuttingDown = errors.New("provisioner is shutting down")
+
+// workController is the part of the provision controller needed to shut down.
+type workController interface {
+	Shutdown(timeout time.Duration) bool
+}
+
+// runContext returns the context of the provisioner's operations, canceled
+// once it starts shutting down.
+func (p *HostPathProvisioner) runContext() context.Context {
+	if p.ctx == nil {
+		return context.Background()
+	}
+	return p.ctx
+}
+
+// waitContext returns a context for waiting on behalf of the operation
+// running with ctx, done once ctx is or the provisioner starts shutting down.
+// The returned function must be called once the wait is over.
+func (p *HostPathProvisioner) waitContext(ctx context.Context) (context.Context, context.CancelFunc) {
+	ctx, cancel := context.WithCancel(ctx)
+	stop := context.AfterFunc(p.runContext(), cancel)
+	return ctx, func() {
+		stop()
+		cancel()
+	}
+}
+
+// shutdown cancels the operations that have not touched the filesystem yet,
+// waits up to timeout for the others to finish and flushes the pending events.
+// It returns an error when work was still in progress after timeout.
+func (p *HostPathProvisioner) shutdown(pc workController, timeout time.Duration) error {
+	if p.cancel != nil {
+		p.cancel()
+	}
+	var err error
+	if !pc.Shutdown(timeout) {
+		err = fmt.Errorf("work in progress did not finish within %v", timeout)
+	}
+	// Shutting the broadcaster down waits for the queued events to be
+	// handed to the sink
+	if p.eventBroadcaster != nil {
+		p.eventBroadcaster.Shutdown()
+	}
+	klog.Flush()
+	return err
+}
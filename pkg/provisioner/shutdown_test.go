@@ -0,0 +1,93 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package provisioner
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/sig-storage-lib-external-provisioner/v13/controller"
+)
+
+type fakeWorkController struct {
+	idle    bool
+	timeout time.Duration
+}
+
+func (f *fakeWorkController) Shutdown(timeout time.Duration) bool {
+	f.timeout = timeout
+	return f.idle
+}
+
+func Test_shutdown(t *testing.T) {
+	for _, tc := range []struct {
+		name    string
+		idle    bool
+		wantErr bool
+	}{
+		{"work finished", true, false},
+		{"work still running", false, true},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			options := controller.ProvisionOptions{
+				PVName: "pvc-1",
+				PVC:    &v1.PersistentVolumeClaim{ObjectMeta: metav1.ObjectMeta{Name: "claim", Namespace: "default"}},
+			}
+			ctx, cancel := context.WithCancel(context.Background())
+			p := &HostPathProvisioner{ctx: ctx, cancel: cancel}
+			pc := &fakeWorkController{idle: tc.idle}
+			if err := p.shutdown(pc, time.Minute); (err != nil) != tc.wantErr {
+				t.Errorf("shutdown() error = %v, wantErr %v", err, tc.wantErr)
+			}
+			if pc.timeout != time.Minute {
+				t.Errorf("controller given %v to shut down, want %v", pc.timeout, time.Minute)
+			}
+			if p.runContext().Err() == nil {
+				t.Errorf("context not canceled by shutdown()")
+			}
+			if _, err := p.provision(context.Background(), options, time.Now(), "id"); err != errShuttingDown {
+				t.Errorf("provision() after shutdown() = %v, want %v", err, errShuttingDown)
+			}
+		})
+	}
+}
+
+func Test_waitContext(t *testing.T) {
+	runCtx, stop := context.WithCancel(context.Background())
+	p := &HostPathProvisioner{ctx: runCtx, cancel: stop}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	waitCtx, stopWaiting := p.waitContext(ctx)
+	cancel()
+	<-waitCtx.Done()
+	stopWaiting()
+
+	waitCtx, stopWaiting = p.waitContext(context.Background())
+	defer stopWaiting()
+	if waitCtx.Err() != nil {
+		t.Fatalf("wait context done before the provisioner shuts down: %v", waitCtx.Err())
+	}
+	p.shutdown(&fakeWorkController{idle: true}, time.Minute)
+	select {
+	case <-waitCtx.Done():
+	case <-time.After(5 * time.Second):
+		t.Error("wait context not done once the provisioner shuts down")
+	}
+}
@@ -0,0 +1,287 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package provisioner
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+
+	"k8s.io/klog/v2"
+
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	"sigs.k8s.io/sig-storage-lib-external-provisioner/v13/controller"
+)
+
+const (
+	// spanPoolsParameter is the StorageClass parameter letting claims too
+	// large for any single pool be striped across several, "true" or
+	// "false".
+	spanPoolsParameter = "spanPools"
+	// annStripes records the image files, one per pool and comma separated,
+	// a volume spanning pools is striped across. The first is in the pool
+	// the volume's backing directory is in.
+	annStripes = "kubevirt.io/stripes"
+
+	// policySpan is recorded as the pool selection policy of volumes spanning
+	// pools.
+	policySpan = "span"
+)
+
+// stripedFilesystem is the filesystem spanning the image files of a volume,
+// btrfs striping its data across them and mirroring its metadata.
+var stripedFilesystem = imageFilesystem{fsType: "btrfs", mkfsOptions: []string{"--data", "raid0", "--metadata", "raid1"}}
+
+// spanPoolsFor returns whether the StorageClass lets claims span pools. Only
+// volumes of plain directories can, other backings and logical volumes are
+// placed in a single pool, as are block claims given whole devices.
+func spanPoolsFor(options controller.ProvisionOptions) (bool, error) {
+	if options.StorageClass == nil {
+		return false, nil
+	}
+	value, ok := options.StorageClass.Parameters[spanPoolsParameter]
+	if !ok {
+		return false, nil
+	}
+	span, err := strconv.ParseBool(value)
+	if err != nil {
+		return false, fmt.Errorf("invalid %s %q in storage class %s, expected true or false", spanPoolsParameter, value, options.StorageClass.Name)
+	}
+	if !span {
+		return false, nil
+	}
+	backing, err := backingFor(options)
+	if err != nil {
+		return false, err
+	}
+	if _, ok := options.StorageClass.Parameters[lvmAllocationParameter]; ok || backing != backingDirectory || isBlockClaim(options.PVC) {
+		return false, fmt.Errorf("storage class %s sets %s, only volumes of plain directories can span pools", options.StorageClass.Name, spanPoolsParameter)
+	}
+	// fscrypt does not support btrfs
+	if _, ok := options.StorageClass.Parameters[encryptionSecretParameter]; ok {
+		return false, fmt.Errorf("storage class %s sets %s and %s, volumes spanning pools can not be encrypted", options.StorageClass.Name, spanPoolsParameter, encryptionSecretParameter)
+	}
+	return true, nil
+}
+
+// stripeCandidate is a pool a stripe can be placed in and the bytes free in
+// it.
+type stripeCandidate struct {
+	pool *storagePool
+	free int64
+}
+
+// chooseStripes returns the fewest candidates, at least two and at most max,
+// that each have room for an equal stripe of a volume of size bytes, and the
+// size of the stripes, whole MiB. The candidates are sorted by the space
+// free in them, most first; nil is returned when no candidates have room.
+func chooseStripes(candidates []stripeCandidate, size int64, max int) ([]*storagePool, int64) {
+	for n := 2; n <= len(candidates) && n <= max; n++ {
+		stripe := (size + int64(n) - 1) / int64(n)
+		stripe = (stripe + MiB - 1) / MiB * MiB
+		if candidates[n-1].free < stripe {
+			continue
+		}
+		pools := make([]*storagePool, n)
+		for i := range pools {
+			pools[i] = candidates[i].pool
+		}
+		return pools, stripe
+	}
+	return nil, 0
+}
+
+// selectStripes returns the pools a claim too large for any single pool is
+// striped across, the one with the most space free first, and the size of
+// the stripes. The pools are the usable pools of plain directories of the
+// claim's device class and QoS tier.
+func (p *HostPathProvisioner) selectStripes(options controller.ProvisionOptions) ([]*storagePool, int64, error) {
+	rounding, err := p.roundingFor(options)
+	if err != nil {
+		return nil, 0, err
+	}
+	tier, err := p.qosTierFor(options)
+	if err != nil {
+		return nil, 0, err
+	}
+	classes, err := deviceClassesFor(options)
+	if err != nil {
+		return nil, 0, err
+	}
+	if len(classes) == 0 && tier != nil {
+		classes = tier.deviceClasses
+	}
+	candidates, err := p.candidatePools(resource.Quantity{}, rounding, "")
+	if err != nil {
+		return nil, 0, err
+	}
+	var stripes []stripeCandidate
+	for _, candidate := range tierCandidates(classCandidates(imageCandidates(candidates), classes), tier, p.latency) {
+		free, err := poolFree(p.host, candidate.pool)
+		if err != nil {
+			klog.ErrorS(err, "Unable to determine free space of pool", "node", p.nodeName, "pool", candidate.pool.name, "path", candidate.pool.path)
+			continue
+		}
+		stripes = append(stripes, stripeCandidate{pool: candidate.pool, free: free})
+	}
+	sort.SliceStable(stripes, func(i, j int) bool {
+		return stripes[i].free > stripes[j].free
+	})
+	requested := options.PVC.Spec.Resources.Requests[v1.ResourceName(v1.ResourceStorage)]
+	pools, size := chooseStripes(stripes, requested.Value(), p.maxStripes)
+	if pools == nil {
+		return nil, 0, fmt.Errorf("no storage pool on node %s can hold a volume of %s, nor can up to %d pools together", p.nodeName, requested.String(), p.maxStripes)
+	}
+	names := make([]string, len(pools))
+	for i, pool := range pools {
+		names[i] = pool.name
+	}
+	klog.V(3).InfoS("Selected pools to span", "pvc", options.PVC.Namespace+"/"+options.PVC.Name, "node", p.nodeName, "pools", strings.Join(names, ","), "stripe", size)
+	return pools, size, nil
+}
+
+// stripeImagesFor returns the image files of a volume striped across the
+// pools, the first next to its backing directory path and the others named
+// like it in their pool.
+func stripeImagesFor(pools []*storagePool, path, dirName, pvName string) ([]string, error) {
+	images := []string{path + imageSuffix}
+	for _, pool := range pools[1:] {
+		dir, err := volumeDir(pool, dirName, pvName)
+		if err != nil {
+			return nil, err
+		}
+		images = append(images, dir+imageSuffix)
+	}
+	return images, nil
+}
+
+// stripeImagesOf returns the image files pv is striped across, nil when it
+// does not span pools.
+func stripeImagesOf(pv *v1.PersistentVolume) []string {
+	value := pv.Annotations[annStripes]
+	if value == "" {
+		return nil
+	}
+	return strings.Split(value, ",")
+}
+
+// stripeMountOptions are the options making the kernel find every device of
+// the striped filesystem, whose first device is mounted.
+func stripeMountOptions(devices []string) []string {
+	var options []string
+	for _, device := range devices[1:] {
+		options = append(options, "device="+device)
+	}
+	return options
+}
+
+// createStripedVolume creates the sparse image files of size bytes each,
+// attaches them to loop devices, formats them with a filesystem striped across
+// all of them and mounts it at path. Images left behind by an earlier attempt
+// are reused.
+func (l *loopDevices) createStripedVolume(path string, images []string, size int64) error {
+	if err := mkfsProbes.check(imageFilesystem{fsType: stripedFilesystem.fsType}); err != nil {
+		return err
+	}
+	var devices []string
+	var err error
+	for _, image := range images {
+		if err = createImage(image, size); err != nil {
+			break
+		}
+		var device string
+		if device, err = l.attach(image); err != nil {
+			break
+		}
+		devices = append(devices, device)
+	}
+	if err == nil {
+		// mkfs takes the other devices after its options
+		fs := stripedFilesystem
+		fs.mkfsOptions = append(append([]string{}, fs.mkfsOptions...), devices[1:]...)
+		fs.mountOptions = stripeMountOptions(devices)
+		err = mountDevice(devices[0], fs, path, true)
+	}
+	if err != nil {
+		if removeErr := l.removeStripedVolume(images, path); removeErr != nil {
+			klog.Warningf("unable to remove the stripes of %s after failing to mount them: %v", path, removeErr)
+		}
+		return err
+	}
+	return nil
+}
+
+// removeStripedVolume unmounts the striped volume from path, detaches the loop
+// devices of its image files and removes them.
+func (l *loopDevices) removeStripedVolume(images []string, path string) error {
+	if isMountPoint(path) {
+		if _, err := runCommand("umount", path); err != nil {
+			return err
+		}
+	}
+	for _, image := range images {
+		if err := l.detach(image); err != nil {
+			return err
+		}
+		if err := os.Remove(image); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+	return nil
+}
+
+// attachStripedVolumes attaches and mounts the image files of this node's
+// volumes spanning pools that are not mounted.
+func (p *HostPathProvisioner) attachStripedVolumes() {
+	pvs, err := p.pvCache.list(context.TODO(), p.client)
+	if err != nil {
+		klog.Warningf("unable to list persistent volumes, not attaching striped volumes: %v", err)
+		return
+	}
+	for _, pv := range pvs.Items {
+		if err := p.attachStripedVolume(pv); err != nil {
+			klog.ErrorS(err, "Failed to attach striped volume", "node", p.nodeName, "pv", pv.Name, "stripes", pv.Annotations[annStripes])
+		}
+	}
+}
+
+// attachStripedVolume attaches and mounts the image files of pv, if it is one
+// of ours spanning pools.
+func (p *HostPathProvisioner) attachStripedVolume(pv v1.PersistentVolume) error {
+	images := stripeImagesOf(&pv)
+	if images == nil || backingPath(&pv) == "" || !p.ownsIdentity(pv.Annotations["hostPathProvisionerIdentity"]) || pv.Annotations["kubevirt.io/provisionOnNode"] != p.nodeName {
+		return nil
+	}
+	path := backingPath(&pv)
+	if isMountPoint(path) {
+		return nil
+	}
+	var devices []string
+	for _, image := range images {
+		device, err := p.loopDevices.attach(image)
+		if err != nil {
+			return err
+		}
+		devices = append(devices, device)
+	}
+	klog.InfoS("Mounting striped volume", "node", p.nodeName, "pv", pv.Name, "devices", strings.Join(devices, ","), "path", path)
+	return mountDevice(devices[0], imageFilesystem{fsType: stripedFilesystem.fsType, mountOptions: stripeMountOptions(devices)}, path, false)
+}
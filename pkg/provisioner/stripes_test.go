@@ -0,0 +1,207 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package provisioner
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	v1 "k8s.io/api/core/v1"
+	storagev1 "k8s.io/api/storage/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/sig-storage-lib-external-provisioner/v13/controller"
+)
+
+func Test_spanPoolsFor(t *testing.T) {
+	class := func(parameters ...string) *storagev1.StorageClass {
+		class := &storagev1.StorageClass{ObjectMeta: metav1.ObjectMeta{Name: "large"}, Parameters: map[string]string{}}
+		for i := 0; i+1 < len(parameters); i += 2 {
+			class.Parameters[parameters[i]] = parameters[i+1]
+		}
+		return class
+	}
+	block := v1.PersistentVolumeBlock
+	tests := []struct {
+		name    string
+		class   *storagev1.StorageClass
+		block   bool
+		want    bool
+		wantErr bool
+	}{
+		{name: "no class"},
+		{name: "not set", class: class()},
+		{name: "span", class: class(spanPoolsParameter, "true"), want: true},
+		{name: "directories", class: class(spanPoolsParameter, "true", backingParameter, backingDirectory), want: true},
+		{name: "disabled", class: class(spanPoolsParameter, "false", backingParameter, backingImage)},
+		{name: "invalid", class: class(spanPoolsParameter, "yes please"), wantErr: true},
+		{name: "image", class: class(spanPoolsParameter, "true", backingParameter, backingImage), wantErr: true},
+		{name: "logical volumes", class: class(spanPoolsParameter, "true", lvmAllocationParameter, lvmThick), wantErr: true},
+		{name: "encrypted", class: class(spanPoolsParameter, "true", encryptionSecretParameter, "default/key"), wantErr: true},
+		{name: "block", class: class(spanPoolsParameter, "true"), block: true, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			claim := &v1.PersistentVolumeClaim{}
+			if tt.block {
+				claim.Spec.VolumeMode = &block
+			}
+			got, err := spanPoolsFor(controller.ProvisionOptions{StorageClass: tt.class, PVC: claim})
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("spanPoolsFor() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("spanPoolsFor() = %t, want %t", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_chooseStripes(t *testing.T) {
+	a, b, c := &storagePool{name: "a"}, &storagePool{name: "b"}, &storagePool{name: "c"}
+	tests := []struct {
+		name       string
+		candidates []stripeCandidate
+		size       int64
+		max        int
+		want       []*storagePool
+		wantStripe int64
+	}{
+		{name: "two halves", candidates: []stripeCandidate{{a, 600 * GiB}, {b, 500 * GiB}, {c, 400 * GiB}}, size: 1000 * GiB, max: 4, want: []*storagePool{a, b}, wantStripe: 500 * GiB},
+		{name: "three thirds", candidates: []stripeCandidate{{a, 600 * GiB}, {b, 450 * GiB}, {c, 400 * GiB}}, size: 1000 * GiB, max: 4, want: []*storagePool{a, b, c}, wantStripe: 341334 * MiB},
+		{name: "rounded to MiB", candidates: []stripeCandidate{{a, GiB}, {b, GiB}}, size: MiB + 1, max: 4, want: []*storagePool{a, b}, wantStripe: MiB},
+		{name: "too large", candidates: []stripeCandidate{{a, 600 * GiB}, {b, 450 * GiB}, {c, 300 * GiB}}, size: 1000 * GiB, max: 4},
+		{name: "limited", candidates: []stripeCandidate{{a, 600 * GiB}, {b, 450 * GiB}, {c, 400 * GiB}}, size: 1000 * GiB, max: 2},
+		{name: "single pool", candidates: []stripeCandidate{{a, 600 * GiB}}, size: 100 * GiB, max: 4},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, stripe := chooseStripes(tt.candidates, tt.size, tt.max)
+			if !reflect.DeepEqual(got, tt.want) || stripe != tt.wantStripe {
+				t.Errorf("chooseStripes() = %v, %d, want %v, %d", got, stripe, tt.want, tt.wantStripe)
+			}
+		})
+	}
+}
+
+func Test_stripedVolume(t *testing.T) {
+	dir, err := ioutil.TempDir("", "stripes")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	pools := []*storagePool{{name: "a", path: filepath.Join(dir, "a")}, {name: "b", path: filepath.Join(dir, "b")}}
+	for _, pool := range pools {
+		if err := os.Mkdir(pool.path, 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	path := filepath.Join(pools[0].path, "pvc-1")
+	images, err := stripeImagesFor(pools, path, "pvc-1", "pvc-1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{path + imageSuffix, filepath.Join(pools[1].path, "pvc-1") + imageSuffix}; !reflect.DeepEqual(images, want) {
+		t.Fatalf("stripeImagesFor() = %v, want %v", images, want)
+	}
+
+	originalProbes := mkfsProbes
+	mkfsProbes = &mkfsProbe{results: map[string]error{"btrfs": nil}}
+	defer func() { mkfsProbes = originalProbes }()
+	list := "losetup --list --noheadings --raw --output NAME,BACK-FILE"
+	mkfs := "mkfs -t btrfs --data raid0 --metadata raid1 /dev/loop4 /dev/loop3"
+	mount := "mount -t btrfs -o device=/dev/loop4 /dev/loop3 " + path
+	commands, restore := fakeCommands(map[string]string{
+		list:                                 "",
+		"losetup --find --show " + images[0]: "/dev/loop3\n",
+		"losetup --find --show " + images[1]: "/dev/loop4\n",
+		mkfs:                                 "",
+		mount:                                "",
+	})
+	defer restore()
+	l := &loopDevices{}
+	if err := l.createStripedVolume(path, images, 512*MiB); err != nil {
+		t.Fatal(err)
+	}
+	for _, image := range images {
+		if info, err := os.Stat(image); err != nil || info.Size() != 512*MiB {
+			t.Errorf("image %s = %v, %v, want %d bytes", image, info, err, 512*MiB)
+		}
+	}
+	want := []string{
+		list,
+		"losetup --find --show " + images[0],
+		list,
+		"losetup --find --show " + images[1],
+		"blkid -o value -s TYPE /dev/loop3",
+		mkfs,
+		mount,
+	}
+	if !reflect.DeepEqual(*commands, want) {
+		t.Errorf("commands = %q, want %q", *commands, want)
+	}
+
+	// Deleting the volume detaches and removes every stripe
+	attached := "/dev/loop3 " + images[0] + "\n/dev/loop4 " + images[1] + "\n"
+	commands, restore = fakeCommands(map[string]string{
+		list:                          attached,
+		"losetup --detach /dev/loop3": "",
+		"losetup --detach /dev/loop4": "",
+	})
+	defer restore()
+	if err := l.removeStripedVolume(images, path); err != nil {
+		t.Fatal(err)
+	}
+	for _, image := range images {
+		if _, err := os.Stat(image); !os.IsNotExist(err) {
+			t.Errorf("image %s not removed: %v", image, err)
+		}
+	}
+	want = []string{list, "losetup --detach /dev/loop3", list, "losetup --detach /dev/loop4"}
+	if !reflect.DeepEqual(*commands, want) {
+		t.Errorf("commands = %q, want %q", *commands, want)
+	}
+
+	// Stripes that can not be formatted are removed again
+	_, restore = fakeCommands(map[string]string{
+		list:                                 "",
+		"losetup --find --show " + images[0]: "/dev/loop3\n",
+		"losetup --find --show " + images[1]: "/dev/loop4\n",
+	})
+	defer restore()
+	if err := l.createStripedVolume(path, images, 512*MiB); err == nil {
+		t.Fatal("createStripedVolume() succeeded with failing commands")
+	}
+	for _, image := range images {
+		if _, err := os.Stat(image); !os.IsNotExist(err) {
+			t.Errorf("image %s not removed: %v", image, err)
+		}
+	}
+}
+
+func Test_stripeImagesOf(t *testing.T) {
+	pv := &v1.PersistentVolume{ObjectMeta: metav1.ObjectMeta{Annotations: map[string]string{}}}
+	if got := stripeImagesOf(pv); got != nil {
+		t.Errorf("stripeImagesOf() = %v, want nil", got)
+	}
+	pv.Annotations[annStripes] = "/var/a/pvc-1.img,/var/b/pvc-1.img"
+	if got, want := stripeImagesOf(pv), []string{"/var/a/pvc-1.img", "/var/b/pvc-1.img"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("stripeImagesOf() = %v, want %v", got, want)
+	}
+}
@@ -0,0 +1,144 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package provisioner
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	"k8s.io/klog/v2"
+
+	v1 "k8s.io/api/core/v1"
+)
+
+// symlinkFarmDir is the directory in every pool holding the symlinks.
+const symlinkFarmDir = "by-namespace"
+
+// symlinkTree maintains a tree of symlinks named after the claims, next to the
+// backing directories they point to. The links are relative, so they work on
+// the host as well as in the provisioner's container. A nil tree does nothing.
+type symlinkTree struct{}
+
+// linkPath returns the path of the symlink for the claim namespace/name of
+// the backing directory dir.
+func linkPath(dir, namespace, name string) string {
+	return filepath.Join(filepath.Dir(dir), symlinkFarmDir, namespace, name)
+}
+
+// linkTarget returns what the symlink of the backing directory dir points to.
+func linkTarget(dir string) string {
+	return filepath.Join("..", "..", filepath.Base(dir))
+}
+
+// link points the symlink of the claim at dir, replacing a link left behind by
+// an earlier claim of the same name.
+func (s *symlinkTree) link(dir, namespace, name string) {
+	if s == nil {
+		return
+	}
+	path := linkPath(dir, namespace, name)
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		klog.Warningf("unable to create symlink directory for claim %s/%s: %v", namespace, name, err)
+		return
+	}
+	if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+		klog.Warningf("unable to replace symlink %s: %v", path, err)
+		return
+	}
+	if err := os.Symlink(linkTarget(dir), path); err != nil {
+		klog.Warningf("unable to create symlink %s to %s: %v", path, dir, err)
+	}
+}
+
+// unlink removes the symlink of the claim if it points at dir, along with the
+// namespace directory once it is empty.
+func (s *symlinkTree) unlink(dir, namespace, name string) {
+	if s == nil {
+		return
+	}
+	path := linkPath(dir, namespace, name)
+	if target, err := os.Readlink(path); err != nil || target != linkTarget(dir) {
+		return
+	}
+	if err := os.Remove(path); err != nil {
+		klog.Warningf("unable to remove symlink %s: %v", path, err)
+		return
+	}
+	// Fails while other claims of the namespace have links
+	os.Remove(filepath.Dir(path))
+}
+
+// sync makes the symlinks in pools match the given volumes, which are the
+// volumes of this provisioner on this node. Links of volumes that are gone are
+// removed and missing links are created, e.g. when the tree is enabled on a
+// node that already has volumes.
+func (s *symlinkTree) sync(pools []*storagePool, pvs []v1.PersistentVolume) {
+	if s == nil {
+		return
+	}
+	wanted := make(map[string]string)
+	for _, pv := range pvs {
+		if backingPath(&pv) == "" || pv.Spec.ClaimRef == nil {
+			continue
+		}
+		dir := filepath.Clean(backingPath(&pv))
+		wanted[linkPath(dir, pv.Spec.ClaimRef.Namespace, pv.Spec.ClaimRef.Name)] = dir
+	}
+	for _, pool := range pools {
+		root := filepath.Join(pool.path, symlinkFarmDir)
+		namespaces, err := ioutil.ReadDir(root)
+		if err != nil && !os.IsNotExist(err) {
+			klog.Warningf("unable to read symlinks in pool %s: %v", pool.name, err)
+		}
+		for _, namespace := range namespaces {
+			links, _ := ioutil.ReadDir(filepath.Join(root, namespace.Name()))
+			for _, link := range links {
+				path := filepath.Join(root, namespace.Name(), link.Name())
+				target, err := os.Readlink(path)
+				if dir, ok := wanted[path]; ok && err == nil && target == linkTarget(dir) {
+					delete(wanted, path)
+					continue
+				}
+				if err := os.Remove(path); err != nil {
+					klog.Warningf("unable to remove stale symlink %s: %v", path, err)
+				}
+			}
+			os.Remove(filepath.Join(root, namespace.Name()))
+		}
+	}
+	for path, dir := range wanted {
+		s.link(dir, filepath.Base(filepath.Dir(path)), filepath.Base(path))
+	}
+}
+
+// syncSymlinks brings the symlinks in line with the volumes of this node.
+func (p *HostPathProvisioner) syncSymlinks() {
+	pvs, err := p.pvCache.list(context.TODO(), p.client)
+	if err != nil {
+		klog.Warningf("unable to list persistent volumes, not synchronizing symlinks: %v", err)
+		return
+	}
+	var ours []v1.PersistentVolume
+	for _, pv := range pvs.Items {
+		if p.ownsIdentity(pv.Annotations["hostPathProvisionerIdentity"]) && pv.Annotations["kubevirt.io/provisionOnNode"] == p.nodeName {
+			ours = append(ours, pv)
+		}
+	}
+	p.symlinks.sync(p.currentPools(), ours)
+}
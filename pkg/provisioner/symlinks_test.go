@@ -0,0 +1,80 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package provisioner
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	v1 "k8s.io/api/core/v1"
+)
+
+func Test_symlinkTree(t *testing.T) {
+	pool, err := ioutil.TempDir("", "symlinks")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(pool)
+	dir := filepath.Join(pool, "pvc-1")
+	if err := os.Mkdir(dir, 0777); err != nil {
+		t.Fatal(err)
+	}
+	link := filepath.Join(pool, symlinkFarmDir, "team-a", "data")
+	resolves := func(path, want string) bool {
+		got, err := filepath.EvalSymlinks(path)
+		return err == nil && got == want
+	}
+
+	s := &symlinkTree{}
+	s.link(dir, "team-a", "data")
+	if !resolves(link, dir) {
+		t.Fatalf("%s does not point to %s", link, dir)
+	}
+	// The link of another volume is left alone
+	s.unlink(filepath.Join(pool, "pvc-2"), "team-a", "data")
+	if !resolves(link, dir) {
+		t.Fatalf("unlink() removed the link of another volume")
+	}
+	s.unlink(dir, "team-a", "data")
+	if _, err := os.Lstat(filepath.Dir(link)); !os.IsNotExist(err) {
+		t.Errorf("unlink() left %s behind: %v", filepath.Dir(link), err)
+	}
+
+	// sync removes stale links and creates missing ones
+	s.link(filepath.Join(pool, "pvc-gone"), "team-b", "old")
+	pvs := []v1.PersistentVolume{{
+		Spec: v1.PersistentVolumeSpec{
+			ClaimRef:               &v1.ObjectReference{Namespace: "team-a", Name: "data"},
+			PersistentVolumeSource: v1.PersistentVolumeSource{HostPath: &v1.HostPathVolumeSource{Path: dir}},
+		},
+	}}
+	s.sync([]*storagePool{{name: "default", path: pool}}, pvs)
+	if !resolves(link, dir) {
+		t.Errorf("sync() did not create %s", link)
+	}
+	if _, err := os.Lstat(filepath.Join(pool, symlinkFarmDir, "team-b")); !os.IsNotExist(err) {
+		t.Errorf("sync() kept the stale link: %v", err)
+	}
+
+	var disabled *symlinkTree
+	disabled.link(dir, "team-c", "data")
+	if _, err := os.Lstat(filepath.Join(pool, symlinkFarmDir, "team-c")); !os.IsNotExist(err) {
+		t.Errorf("a disabled tree created a link: %v", err)
+	}
+}
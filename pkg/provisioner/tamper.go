@@ -0,0 +1,334 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package provisioner
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"syscall"
+	"time"
+	"unsafe"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"k8s.io/klog/v2"
+
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/tools/record"
+)
+
+// tamperRefreshInterval is how often the set of watched volumes is updated.
+const tamperRefreshInterval = time.Minute
+
+const (
+	tamperingRemoved   = "removed"
+	tamperingOwnership = "ownership"
+)
+
+// nameMax is the longest name of a file, NAME_MAX.
+const nameMax = 255
+
+// sizeofInotifyEvent is the size of an inotify event without its name.
+const sizeofInotifyEvent = int(unsafe.Sizeof(inotifyEventHeader{}))
+
+// inotifyEvent is an event read from an inotify file descriptor.
+type inotifyEvent struct {
+	wd   int32
+	mask uint32
+	name string
+}
+
+// parseInotifyEvents decodes the events in buf, as read from an inotify file
+// descriptor.
+func parseInotifyEvents(buf []byte) []inotifyEvent {
+	var events []inotifyEvent
+	for offset := 0; offset+sizeofInotifyEvent <= len(buf); {
+		raw := (*inotifyEventHeader)(unsafe.Pointer(&buf[offset]))
+		event := inotifyEvent{wd: raw.Wd, mask: raw.Mask}
+		start := offset + sizeofInotifyEvent
+		end := start + int(raw.Len)
+		if end > len(buf) {
+			break
+		}
+		// The name is padded with NUL bytes
+		event.name = string(bytes.TrimRight(buf[start:end], "\x00"))
+		events = append(events, event)
+		offset = end
+	}
+	return events
+}
+
+// watchedVolume is a backing directory being watched for tampering.
+type watchedVolume struct {
+	pv       string
+	claimRef *v1.ObjectReference
+	path     string
+	wd       int32
+	uid, gid uint32
+}
+
+// tamperWatcher watches the backing directories of the volumes on this node
+// with inotify, and reports when one is removed or changes owner while its PV
+// still exists. Those are done by hand on the host, and break the workload
+// using the volume.
+type tamperWatcher struct {
+	fd            int
+	client        kubernetes.Interface
+	pvCache       *volumeCache
+	identity      string
+	nodeName      string
+	eventRecorder record.EventRecorder
+	tampering     *prometheus.CounterVec
+
+	mutex sync.Mutex
+	// pools by the descriptor of their watch
+	pools map[int32]*storagePool
+	// watched volumes by path, and by the descriptor of their watch
+	volumes map[string]*watchedVolume
+	watches map[int32]*watchedVolume
+	// paths the provisioner is about to remove itself
+	expected map[string]bool
+}
+
+var _ prometheus.Collector = &tamperWatcher{}
+
+func newTamperWatcher(client kubernetes.Interface, pvCache *volumeCache, identity, nodeName string, eventRecorder record.EventRecorder) (*tamperWatcher, error) {
+	fd, err := inotifyInit()
+	if err != nil {
+		return nil, fmt.Errorf("unable to initialize inotify: %v", err)
+	}
+	return &tamperWatcher{
+		fd:            fd,
+		client:        client,
+		pvCache:       pvCache,
+		identity:      identity,
+		nodeName:      nodeName,
+		eventRecorder: eventRecorder,
+		tampering: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: metricsNamespace,
+			Name:      "backing_directory_tampering_total",
+			Help:      "Number of times a backing directory was removed or changed owner outside of the provisioner.",
+		}, []string{"kind"}),
+		pools:    make(map[int32]*storagePool),
+		volumes:  make(map[string]*watchedVolume),
+		watches:  make(map[int32]*watchedVolume),
+		expected: make(map[string]bool),
+	}, nil
+}
+
+// Run watches the pools, keeps the set of watched volumes up to date and
+// handles the inotify events until stopCh is closed.
+func (w *tamperWatcher) Run(pools []*storagePool, stopCh <-chan struct{}) {
+	w.watchPools(pools)
+	go wait.Until(w.refresh, tamperRefreshInterval, stopCh)
+	go func() {
+		<-stopCh
+		closeInotify(w.fd)
+	}()
+
+	buf := make([]byte, 64*(sizeofInotifyEvent+nameMax+1))
+	for {
+		n, err := readInotify(w.fd, buf)
+		if err == syscall.EINTR {
+			continue
+		}
+		if err != nil || n <= 0 {
+			select {
+			case <-stopCh:
+			default:
+				klog.Errorf("stopped watching backing directories for tampering: %v", err)
+			}
+			return
+		}
+		for _, event := range parseInotifyEvents(buf[:n]) {
+			w.handle(event)
+		}
+	}
+}
+
+// watchPools watches the pools for backing directories being removed.
+func (w *tamperWatcher) watchPools(pools []*storagePool) {
+	for _, pool := range pools {
+		wd, err := inotifyAddWatch(w.fd, pool.path, inDelete|inMovedFrom|inOnlyDir)
+		if err != nil {
+			klog.Errorf("unable to watch pool %s for tampering: %v", pool.name, err)
+			continue
+		}
+		w.mutex.Lock()
+		w.pools[int32(wd)] = pool
+		w.mutex.Unlock()
+	}
+}
+
+// refresh watches the backing directories of new volumes and stops watching
+// the ones of volumes that are gone.
+func (w *tamperWatcher) refresh() {
+	pvs, err := w.pvCache.list(context.TODO(), w.client)
+	if err != nil {
+		klog.Errorf("unable to list persistent volumes for tamper detection: %v", err)
+		return
+	}
+	current := make(map[string]bool)
+	for i := range pvs.Items {
+		pv := &pvs.Items[i]
+		if pv.Annotations["hostPathProvisionerIdentity"] != w.identity || pv.Annotations["kubevirt.io/provisionOnNode"] != w.nodeName || backingPath(pv) == "" {
+			continue
+		}
+		path := filepath.Clean(backingPath(pv))
+		current[path] = true
+		w.watch(pv, path)
+	}
+
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+	for path, volume := range w.volumes {
+		if !current[path] {
+			inotifyRmWatch(w.fd, uint32(volume.wd))
+			w.forget(volume)
+		}
+	}
+	for path := range w.expected {
+		if !current[path] {
+			delete(w.expected, path)
+		}
+	}
+}
+
+// watch starts watching the backing directory of the volume, if it isn't
+// already.
+func (w *tamperWatcher) watch(pv *v1.PersistentVolume, path string) {
+	w.mutex.Lock()
+	_, watched := w.volumes[path]
+	w.mutex.Unlock()
+	if watched {
+		return
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		// Missing directories are reported by the volume health checks
+		klog.V(3).Infof("not watching backing directory %s of volume %s: %v", path, pv.Name, err)
+		return
+	}
+	wd, err := inotifyAddWatch(w.fd, path, inAttrib|inOnlyDir)
+	if err != nil {
+		klog.Warningf("unable to watch backing directory %s of volume %s: %v", path, pv.Name, err)
+		return
+	}
+	volume := &watchedVolume{pv: pv.Name, claimRef: pv.Spec.ClaimRef, path: path, wd: int32(wd)}
+	if uid, gid, ok := fileOwner(info); ok {
+		volume.uid, volume.gid = uid, gid
+	}
+	w.add(volume)
+}
+
+func (w *tamperWatcher) add(volume *watchedVolume) {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+	w.volumes[volume.path] = volume
+	w.watches[volume.wd] = volume
+}
+
+// forget drops the volume, must be called with the lock held.
+func (w *tamperWatcher) forget(volume *watchedVolume) {
+	delete(w.volumes, volume.path)
+	if w.watches[volume.wd] == volume {
+		delete(w.watches, volume.wd)
+	}
+}
+
+// expectRemoval tells the watcher that the provisioner is going to remove the
+// directory at path.
+func (w *tamperWatcher) expectRemoval(path string) {
+	if w == nil {
+		return
+	}
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+	w.expected[filepath.Clean(path)] = true
+}
+
+func (w *tamperWatcher) handle(event inotifyEvent) {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+	if pool, ok := w.pools[event.wd]; ok {
+		if event.mask&(inDelete|inMovedFrom) == 0 || event.mask&inIsDir == 0 {
+			return
+		}
+		path := filepath.Join(pool.path, event.name)
+		volume, ok := w.volumes[path]
+		if !ok {
+			return
+		}
+		w.forget(volume)
+		if w.expected[path] {
+			delete(w.expected, path)
+			return
+		}
+		w.report(volume, tamperingRemoved, "BackingDirectoryRemoved", "Backing directory %s of volume %s was removed on node %s outside of the provisioner, the data is lost", path, volume.pv, w.nodeName)
+		return
+	}
+
+	volume, ok := w.watches[event.wd]
+	if !ok {
+		return
+	}
+	if event.mask&inIgnored != 0 {
+		delete(w.watches, event.wd)
+		return
+	}
+	if event.mask&inAttrib == 0 {
+		return
+	}
+	info, err := os.Stat(volume.path)
+	if err != nil {
+		return
+	}
+	uid, gid, ok := fileOwner(info)
+	if !ok || (uid == volume.uid && gid == volume.gid) {
+		return
+	}
+	w.report(volume, tamperingOwnership, "BackingDirectoryOwnershipChanged", "Owner of backing directory %s of volume %s on node %s changed from %d:%d to %d:%d outside of the provisioner",
+		volume.path, volume.pv, w.nodeName, volume.uid, volume.gid, uid, gid)
+	volume.uid, volume.gid = uid, gid
+}
+
+// report emits a warning event on the volume and its claim, must be called
+// with the lock held.
+func (w *tamperWatcher) report(volume *watchedVolume, kind, reason, messageFmt string, args ...interface{}) {
+	message := fmt.Sprintf(messageFmt, args...)
+	klog.Warning(message)
+	w.tampering.WithLabelValues(kind).Inc()
+	w.eventRecorder.Event(&v1.ObjectReference{Kind: "PersistentVolume", Name: volume.pv}, v1.EventTypeWarning, reason, message)
+	if volume.claimRef != nil {
+		w.eventRecorder.Event(volume.claimRef, v1.EventTypeWarning, reason, message)
+	}
+}
+
+// Describe implements prometheus.Collector.
+func (w *tamperWatcher) Describe(ch chan<- *prometheus.Desc) {
+	w.tampering.Describe(ch)
+}
+
+// Collect implements prometheus.Collector.
+func (w *tamperWatcher) Collect(ch chan<- prometheus.Metric) {
+	w.tampering.Collect(ch)
+}
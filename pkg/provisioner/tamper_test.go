@@ -0,0 +1,150 @@
+//go:build linux || darwin
+// +build linux darwin
+
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package provisioner
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"golang.org/x/sys/unix"
+
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/tools/record"
+)
+
+// readInotifyEvents returns the events queued on fd, waiting up to a second
+// for the first one.
+func readInotifyEvents(t *testing.T, fd int) []inotifyEvent {
+	fds := []unix.PollFd{{Fd: int32(fd), Events: unix.POLLIN}}
+	if n, err := unix.Poll(fds, 1000); err != nil || n == 0 {
+		t.Fatalf("no inotify events: %v", err)
+	}
+	buf := make([]byte, 4096)
+	n, err := unix.Read(fd, buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return parseInotifyEvents(buf[:n])
+}
+
+func newTamperTestVolume(name, path string) *v1.PersistentVolume {
+	return &v1.PersistentVolume{
+		ObjectMeta: metav1.ObjectMeta{Name: name},
+		Spec: v1.PersistentVolumeSpec{
+			PersistentVolumeSource: v1.PersistentVolumeSource{HostPath: &v1.HostPathVolumeSource{Path: path}},
+			ClaimRef:               &v1.ObjectReference{Kind: "PersistentVolumeClaim", Namespace: "default", Name: name + "-claim"},
+		},
+	}
+}
+
+func Test_tamperWatcherRemoval(t *testing.T) {
+	requireLinux(t)
+	dir, err := ioutil.TempDir("", "tamper")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	recorder := record.NewFakeRecorder(10)
+	w, err := newTamperWatcher(nil, nil, "identity", "test-node", recorder)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer unix.Close(w.fd)
+	w.watchPools([]*storagePool{{name: "default", path: dir}})
+
+	tampered, deleted := filepath.Join(dir, "pvc-1"), filepath.Join(dir, "pvc-2")
+	for _, path := range []string{tampered, deleted} {
+		if err := os.Mkdir(path, 0777); err != nil {
+			t.Fatal(err)
+		}
+	}
+	w.watch(newTamperTestVolume("pvc-1", tampered), tampered)
+	w.watch(newTamperTestVolume("pvc-2", deleted), deleted)
+
+	w.expectRemoval(deleted)
+	os.Remove(deleted)
+	os.Remove(tampered)
+	for _, event := range readInotifyEvents(t, w.fd) {
+		w.handle(event)
+	}
+
+	close(recorder.Events)
+	var events []string
+	for event := range recorder.Events {
+		events = append(events, event)
+	}
+	// one event on the volume and one on its claim
+	if len(events) != 2 {
+		t.Fatalf("events = %v, want two BackingDirectoryRemoved events for pvc-1", events)
+	}
+	for _, event := range events {
+		if !strings.HasPrefix(event, "Warning BackingDirectoryRemoved") || !strings.Contains(event, "pvc-1") {
+			t.Errorf("unexpected event %q", event)
+		}
+	}
+	if len(w.volumes) != 0 {
+		t.Errorf("removed volumes still watched: %v", w.volumes)
+	}
+}
+
+func Test_tamperWatcherOwnership(t *testing.T) {
+	requireLinux(t)
+	dir, err := ioutil.TempDir("", "tamper")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	recorder := record.NewFakeRecorder(10)
+	w, err := newTamperWatcher(nil, nil, "identity", "test-node", recorder)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer unix.Close(w.fd)
+	w.watch(newTamperTestVolume("pvc-1", dir), dir)
+	volume := w.volumes[dir]
+	if volume == nil {
+		t.Fatal("volume not watched")
+	}
+
+	// Changing the mode keeps the owner, nothing is reported
+	os.Chmod(dir, 0700)
+	for _, event := range readInotifyEvents(t, w.fd) {
+		w.handle(event)
+	}
+	if len(recorder.Events) != 0 {
+		t.Errorf("unexpected event %q", <-recorder.Events)
+	}
+
+	// Pretend the directory was owned by someone else before
+	volume.uid++
+	w.handle(inotifyEvent{wd: volume.wd, mask: inAttrib})
+	if len(recorder.Events) != 2 {
+		t.Fatalf("got %d events, want two BackingDirectoryOwnershipChanged events", len(recorder.Events))
+	}
+	if event := <-recorder.Events; !strings.HasPrefix(event, "Warning BackingDirectoryOwnershipChanged") {
+		t.Errorf("unexpected event %q", event)
+	}
+}
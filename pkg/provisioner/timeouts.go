@@ -0,0 +1,98 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package provisioner
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// timeoutError is returned when a filesystem operation did not finish in
+// time, or its context was done first. The operation keeps running in the
+// background, system calls on a hung filesystem can not be interrupted.
+type timeoutError struct {
+	operation string
+	path      string
+	timeout   time.Duration
+	// err is the error of the context that was done, nil when the
+	// operation timed out
+	err error
+}
+
+func (e *timeoutError) Error() string {
+	if e.err != nil {
+		return fmt.Sprintf("%s %s was abandoned before it finished: %v", e.operation, e.path, e.err)
+	}
+	return fmt.Sprintf("%s %s did not finish within %v, the filesystem may be hung", e.operation, e.path, e.timeout)
+}
+
+// fsOperations runs filesystem operations with a timeout, so that a hung
+// filesystem or a dying disk fails the operation instead of blocking a
+// controller worker forever. A path with an operation that timed out is
+// refused new operations until it finishes.
+type fsOperations struct {
+	mutex   sync.Mutex
+	running map[string]string
+}
+
+func newFSOperations() *fsOperations {
+	return &fsOperations{running: map[string]string{}}
+}
+
+// run runs fn, an operation on path, and waits for it at most timeout and
+// until ctx is done. fn is not started once ctx is done. Without a timeout
+// and a context that can be done, or without fsOperations, fn is simply run.
+func (f *fsOperations) run(ctx context.Context, operation, path string, timeout time.Duration, fn func() error) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	if f == nil || (timeout <= 0 && ctx.Done() == nil) {
+		return fn()
+	}
+	f.mutex.Lock()
+	if previous, ok := f.running[path]; ok {
+		f.mutex.Unlock()
+		return fmt.Errorf("%s %s is still running after timing out, not starting %s", previous, path, operation)
+	}
+	f.running[path] = operation
+	f.mutex.Unlock()
+
+	done := make(chan error, 1)
+	go func() {
+		err := fn()
+		f.mutex.Lock()
+		delete(f.running, path)
+		f.mutex.Unlock()
+		done <- err
+	}()
+	var expired <-chan time.Time
+	if timeout > 0 {
+		timer := time.NewTimer(timeout)
+		defer timer.Stop()
+		expired = timer.C
+	}
+	select {
+	case err := <-done:
+		return err
+	case <-expired:
+		return &timeoutError{operation: operation, path: path, timeout: timeout}
+	case <-ctx.Done():
+		return &timeoutError{operation: operation, path: path, timeout: timeout, err: ctx.Err()}
+	}
+}
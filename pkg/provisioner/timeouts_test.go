@@ -0,0 +1,93 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package provisioner
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func Test_fsOperations(t *testing.T) {
+	ctx := context.Background()
+	ops := newFSOperations()
+	want := errors.New("failed")
+	if err := ops.run(ctx, "creating", "/pool/a", time.Minute, func() error { return want }); err != want {
+		t.Errorf("run() = %v, want %v", err, want)
+	}
+
+	release := make(chan struct{})
+	err := ops.run(ctx, "removing", "/pool/hung", 10*time.Millisecond, func() error {
+		<-release
+		return nil
+	})
+	if _, ok := err.(*timeoutError); !ok {
+		t.Fatalf("run() of a hung operation = %v, want a timeout", err)
+	}
+	if err := ops.run(ctx, "removing", "/pool/hung", time.Minute, func() error { return nil }); err == nil {
+		t.Errorf("run() started a second operation on a hung path")
+	}
+	if err := ops.run(ctx, "creating", "/pool/b", time.Minute, func() error { return nil }); err != nil {
+		t.Errorf("run() on another path = %v", err)
+	}
+	close(release)
+	for i := 0; ; i++ {
+		if err := ops.run(ctx, "removing", "/pool/hung", time.Minute, func() error { return nil }); err == nil {
+			break
+		}
+		if i == 100 {
+			t.Fatalf("path still refused after the hung operation finished")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	// Without a timeout or fsOperations the operation is run directly
+	var none *fsOperations
+	for _, f := range []*fsOperations{ops, none} {
+		if err := f.run(ctx, "creating", "/pool/c", 0, func() error { return want }); err != want {
+			t.Errorf("run() without timeout = %v, want %v", err, want)
+		}
+	}
+}
+
+func Test_fsOperationsContext(t *testing.T) {
+	ops := newFSOperations()
+	ctx, cancel := context.WithCancel(context.Background())
+	release := make(chan struct{})
+	defer close(release)
+	go func() {
+		time.Sleep(10 * time.Millisecond)
+		cancel()
+	}()
+	// Without a timeout the operation is waited for until ctx is done
+	err := ops.run(ctx, "creating", "/pool/a", 0, func() error {
+		<-release
+		return nil
+	})
+	if timeout, ok := err.(*timeoutError); !ok || timeout.err != context.Canceled {
+		t.Fatalf("run() of an operation whose context was canceled = %v, want it abandoned", err)
+	}
+
+	started := false
+	if err := ops.run(ctx, "creating", "/pool/b", time.Minute, func() error {
+		started = true
+		return nil
+	}); err != context.Canceled || started {
+		t.Errorf("run() with a canceled context = %v, started %v, want it not started", err, started)
+	}
+}
@@ -0,0 +1,179 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package provisioner
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"sync"
+
+	"k8s.io/klog/v2"
+
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+)
+
+const (
+	// backingTmpfs volumes are tmpfs mounts of the requested size at the
+	// backing directory, for scratch data that does not need to survive a
+	// reboot.
+	backingTmpfs = "tmpfs"
+
+	// annTmpfsSize records the size of the tmpfs of a tmpfs backed volume.
+	annTmpfsSize = "kubevirt.io/tmpfsSize"
+)
+
+// tmpfsBudget tracks the memory handed out to the tmpfs backed volumes of the
+// node, so that together they never exceed the budget. The volumes are read
+// from the volumes of the node when the first tmpfs is reserved.
+type tmpfsBudget struct {
+	mutex  sync.Mutex
+	limit  int64
+	loaded bool
+	// volumes maps the names of volumes to their size
+	volumes map[string]int64
+}
+
+// parseTmpfsBudget parses the size of the budget, 0 when unset.
+func parseTmpfsBudget(size string) (int64, error) {
+	if size == "" {
+		return 0, nil
+	}
+	quantity, err := resource.ParseQuantity(size)
+	if err != nil {
+		return 0, fmt.Errorf("invalid tmpfs budget %q: %v", size, err)
+	}
+	return quantity.Value(), nil
+}
+
+// load reads the tmpfs backed volumes of this node, unless they were read
+// already.
+func (b *tmpfsBudget) load(pvs []v1.PersistentVolume, p *HostPathProvisioner) {
+	if b.loaded {
+		return
+	}
+	for _, pv := range pvs {
+		size, ok := tmpfsSize(pv)
+		if ok && p.ownsIdentity(pv.Annotations["hostPathProvisionerIdentity"]) && pv.Annotations["kubevirt.io/provisionOnNode"] == p.nodeName {
+			b.volumes[pv.Name] = size
+		}
+	}
+	b.loaded = true
+}
+
+// reserve hands out size bytes of the budget to the volume. A volume that
+// reserved its memory in an earlier attempt keeps it.
+func (b *tmpfsBudget) reserve(p *HostPathProvisioner, volume string, size int64) error {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	if !b.loaded {
+		pvs, err := p.pvCache.list(context.TODO(), p.client)
+		if err != nil {
+			return fmt.Errorf("unable to list persistent volumes to find the tmpfs volumes: %v", err)
+		}
+		b.load(pvs.Items, p)
+	}
+	if _, ok := b.volumes[volume]; ok {
+		return nil
+	}
+	if b.limit == 0 {
+		return fmt.Errorf("tmpfs backed volumes are disabled on node %s, there is no tmpfs budget", p.nodeName)
+	}
+	if used := b.usedLocked(); used+size > b.limit {
+		return fmt.Errorf("a tmpfs of %d bytes exceeds the tmpfs budget of node %s, %d of %d bytes are in use", size, p.nodeName, used, b.limit)
+	}
+	b.volumes[volume] = size
+	return nil
+}
+
+// release returns the memory of the volume to the budget.
+func (b *tmpfsBudget) release(volume string) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	delete(b.volumes, volume)
+}
+
+func (b *tmpfsBudget) usedLocked() int64 {
+	var used int64
+	for _, size := range b.volumes {
+		used += size
+	}
+	return used
+}
+
+// tmpfsSize returns the size of the tmpfs of pv, if it is tmpfs backed.
+func tmpfsSize(pv v1.PersistentVolume) (int64, bool) {
+	value, ok := pv.Annotations[annTmpfsSize]
+	if !ok {
+		return 0, false
+	}
+	size, err := resource.ParseQuantity(value)
+	if err != nil {
+		return 0, false
+	}
+	return size.Value(), true
+}
+
+// mountTmpfs mounts a tmpfs of size bytes at path, unless one is mounted.
+func mountTmpfs(path string, size int64) error {
+	if err := os.MkdirAll(path, 0755); err != nil {
+		return err
+	}
+	if isMountPoint(path) {
+		return nil
+	}
+	_, err := runCommand("mount", "-t", "tmpfs", "-o", fmt.Sprintf("size=%d", size), "tmpfs", path)
+	return err
+}
+
+// unmountTmpfs unmounts the tmpfs at path, its contents are gone.
+func unmountTmpfs(path string) error {
+	if !isMountPoint(path) {
+		return nil
+	}
+	_, err := runCommand("umount", path)
+	return err
+}
+
+// mountTmpfsVolumes mounts empty tmpfs at the backing directories of this
+// node's tmpfs backed volumes after a reboot, and reads the volumes into the
+// budget.
+func (p *HostPathProvisioner) mountTmpfsVolumes() {
+	pvs, err := p.pvCache.list(context.TODO(), p.client)
+	if err != nil {
+		klog.Warningf("unable to list persistent volumes, not mounting tmpfs volumes: %v", err)
+		return
+	}
+	p.tmpfsVolumes.mutex.Lock()
+	p.tmpfsVolumes.load(pvs.Items, p)
+	p.tmpfsVolumes.mutex.Unlock()
+	for _, pv := range pvs.Items {
+		size, ok := tmpfsSize(pv)
+		if !ok || backingPath(&pv) == "" || !p.ownsIdentity(pv.Annotations["hostPathProvisionerIdentity"]) || pv.Annotations["kubevirt.io/provisionOnNode"] != p.nodeName {
+			continue
+		}
+		path := backingPath(&pv)
+		if isMountPoint(path) {
+			continue
+		}
+		klog.InfoS("Mounting tmpfs, its contents were lost", "node", p.nodeName, "pv", pv.Name, "path", path)
+		if err := mountTmpfs(path, size); err != nil {
+			klog.ErrorS(err, "Failed to mount tmpfs", "node", p.nodeName, "pv", pv.Name, "path", path)
+		}
+	}
+}
@@ -0,0 +1,91 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package provisioner
+
+import (
+	"testing"
+
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func Test_parseTmpfsBudget(t *testing.T) {
+	tests := []struct {
+		size    string
+		want    int64
+		wantErr bool
+	}{
+		{size: "", want: 0},
+		{size: "1Gi", want: 1 << 30},
+		{size: "512M", want: 512000000},
+		{size: "lots", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.size, func(t *testing.T) {
+			got, err := parseTmpfsBudget(tt.size)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("parseTmpfsBudget() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("parseTmpfsBudget() = %d, want %d", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_tmpfsBudgetReserve(t *testing.T) {
+	p := &HostPathProvisioner{nodeName: "node1"}
+	budget := &tmpfsBudget{limit: 3 * GiB, loaded: true, volumes: map[string]int64{"pvc-1": GiB}}
+	if err := budget.reserve(p, "pvc-2", 2*GiB); err != nil {
+		t.Fatalf("reserve() within the budget failed: %v", err)
+	}
+	if err := budget.reserve(p, "pvc-2", 2*GiB); err != nil {
+		t.Fatalf("reserve() again for the same volume failed: %v", err)
+	}
+	if err := budget.reserve(p, "pvc-3", 1); err == nil {
+		t.Fatalf("reserve() beyond the budget succeeded")
+	}
+	budget.release("pvc-1")
+	if err := budget.reserve(p, "pvc-3", GiB); err != nil {
+		t.Fatalf("reserve() after release failed: %v", err)
+	}
+	disabled := &tmpfsBudget{loaded: true, volumes: map[string]int64{}}
+	if err := disabled.reserve(p, "pvc-1", 1); err == nil {
+		t.Fatalf("reserve() without a budget succeeded")
+	}
+}
+
+func Test_tmpfsSize(t *testing.T) {
+	tests := []struct {
+		name        string
+		annotations map[string]string
+		want        int64
+		wantOk      bool
+	}{
+		{name: "directory"},
+		{name: "tmpfs", annotations: map[string]string{annTmpfsSize: "2Gi"}, want: 2 * GiB, wantOk: true},
+		{name: "invalid", annotations: map[string]string{annTmpfsSize: "big"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, ok := tmpfsSize(v1.PersistentVolume{ObjectMeta: metav1.ObjectMeta{Annotations: tt.annotations}})
+			if got != tt.want || ok != tt.wantOk {
+				t.Errorf("tmpfsSize() = %d, %v, want %d, %v", got, ok, tt.want, tt.wantOk)
+			}
+		})
+	}
+}
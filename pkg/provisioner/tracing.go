@@ -0,0 +1,97 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package provisioner
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"time"
+
+	"k8s.io/klog/v2"
+)
+
+// tracer starts the traces of the operations of a provisioner.
+type tracer struct {
+	// log logs every span, set from the configuration
+	log bool
+	// exporter exports the spans when an OTLP endpoint is configured
+	exporter *otlpExporter
+}
+
+// span times one phase of an operation. Spans of the same operation share a
+// trace ID, so that the phases of a slow provision can be found in the logs
+// and lined up with API server and disk latency.
+type span struct {
+	traceID       string
+	spanID        string
+	parentID      string
+	name          string
+	parent        string
+	start         time.Time
+	keysAndValues []interface{}
+	tracer        *tracer
+}
+
+// startTrace starts the root span of an operation, the key/value pairs are
+// logged with every span of the trace.
+func (t *tracer) startTrace(name string, keysAndValues ...interface{}) *span {
+	return &span{
+		tracer:        t,
+		traceID:       randomID(16),
+		spanID:        randomID(8),
+		name:          name,
+		start:         time.Now(),
+		keysAndValues: keysAndValues,
+	}
+}
+
+// child starts a span for a phase of the span's operation.
+func (s *span) child(name string) *span {
+	return &span{
+		tracer:        s.tracer,
+		traceID:       s.traceID,
+		spanID:        randomID(8),
+		parentID:      s.spanID,
+		name:          name,
+		parent:        s.name,
+		start:         time.Now(),
+		keysAndValues: s.keysAndValues,
+	}
+}
+
+// end logs the span when tracing is enabled and queues it for export to an
+// OpenTelemetry collector, err is the outcome of the phase.
+func (s *span) end(err error) {
+	s.tracer.exporter.record(s, time.Now(), err)
+	if !s.tracer.log {
+		return
+	}
+	keysAndValues := append([]interface{}{"trace", s.traceID, "span", s.name, "parent", s.parent, "duration", time.Since(s.start)}, s.keysAndValues...)
+	if err != nil {
+		klog.ErrorS(err, "Span failed", keysAndValues...)
+		return
+	}
+	klog.InfoS("Span finished", keysAndValues...)
+}
+
+// randomID returns n random bytes, hex encoded, the sizes of trace and span
+// IDs are those of W3C trace context.
+func randomID(n int) string {
+	id := make([]byte, n)
+	rand.Read(id)
+	return hex.EncodeToString(id)
+}
@@ -0,0 +1,102 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package provisioner
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+)
+
+func Test_spanEnd(t *testing.T) {
+	out := &bytes.Buffer{}
+	jsonOutput = out
+	setLogFormat(logFormatJSON)
+	defer func() {
+		jsonOutput = os.Stderr
+		setLogFormat(logFormatText)
+	}()
+
+	trace := (&tracer{log: true}).startTrace("Provision", "pvc", "default/data")
+	trace.child("CreateDirectory").end(errors.New("read-only file system"))
+	trace.end(nil)
+
+	decoder := json.NewDecoder(out)
+	var child, root map[string]interface{}
+	if err := decoder.Decode(&child); err != nil {
+		t.Fatalf("invalid span log: %v", err)
+	}
+	if err := decoder.Decode(&root); err != nil {
+		t.Fatalf("invalid span log: %v", err)
+	}
+	if child["trace"] != root["trace"] || child["trace"] == "" {
+		t.Errorf("spans have trace IDs %v and %v, want the same", child["trace"], root["trace"])
+	}
+	if child["span"] != "CreateDirectory" || child["parent"] != "Provision" || child["err"] != "read-only file system" {
+		t.Errorf("unexpected child span %v", child)
+	}
+	if root["span"] != "Provision" || root["pvc"] != "default/data" || root["level"] != "info" {
+		t.Errorf("unexpected root span %v", root)
+	}
+}
+
+func Test_otlpExporter(t *testing.T) {
+	var received otlpTraces
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/v1/traces" {
+			t.Errorf("spans posted to %s, want /v1/traces", r.URL.Path)
+		}
+		if err := json.NewDecoder(r.Body).Decode(&received); err != nil {
+			t.Errorf("invalid export request: %v", err)
+		}
+	}))
+	defer server.Close()
+	exporter := newOTLPExporter(server.URL, "node1")
+
+	trace := (&tracer{exporter: exporter}).startTrace("Provision", "pvc", "default/data")
+	trace.child("CreateDirectory").end(nil)
+	trace.end(errors.New("read-only file system"))
+	exporter.flush()
+
+	if len(received.ResourceSpans) != 1 || len(received.ResourceSpans[0].ScopeSpans) != 1 {
+		t.Fatalf("unexpected export request %+v", received)
+	}
+	spans := received.ResourceSpans[0].ScopeSpans[0].Spans
+	if len(spans) != 2 {
+		t.Fatalf("exported %d spans, want 2", len(spans))
+	}
+	child, root := spans[0], spans[1]
+	if len(root.TraceID) != 32 || child.TraceID != root.TraceID {
+		t.Errorf("spans have trace IDs %q and %q, want the same 16 byte ID", child.TraceID, root.TraceID)
+	}
+	if child.ParentSpanID != root.SpanID || root.ParentSpanID != "" {
+		t.Errorf("child span has parent %q, want %q", child.ParentSpanID, root.SpanID)
+	}
+	if child.Status.Code != otlpStatusOK {
+		t.Errorf("child span has status %+v, want ok", child.Status)
+	}
+	if root.Status.Code != otlpStatusError || root.Status.Message != "read-only file system" {
+		t.Errorf("root span has status %+v, want the error", root.Status)
+	}
+	if len(root.Attributes) != 1 || root.Attributes[0] != stringAttribute("pvc", "default/data") {
+		t.Errorf("root span has attributes %+v", root.Attributes)
+	}
+}
@@ -0,0 +1,137 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package provisioner
+
+import (
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+
+	v1 "k8s.io/api/core/v1"
+)
+
+// metricsNamespace prefixes the metrics exported by the provisioner itself.
+const metricsNamespace = "hostpath_provisioner"
+
+// volumeUsage is the space and inodes consumed by a single volume.
+type volumeUsage struct {
+	pv        string
+	claim     string
+	namespace string
+	pool      string
+	bytes     int64
+	inodes    int64
+	// capacity is that of the PV
+	capacity int64
+	// stats are those of the volume's filesystem, nil when they could not
+	// be read
+	stats *volumeStats
+	// path of the backing directory, and when it was measured
+	path    string
+	scanned time.Time
+}
+
+// usageCollector exports the disk usage of the volumes provisioned on this
+// node, as last measured by the usage scanner.
+type usageCollector struct {
+	scanner *usageScanner
+
+	usedBytes  *prometheus.Desc
+	usedInodes *prometheus.Desc
+
+	// the stats of the volumes, named like the kubelet's volume stats
+	statsCapacity   *prometheus.Desc
+	statsAvailable  *prometheus.Desc
+	statsUsed       *prometheus.Desc
+	statsInodes     *prometheus.Desc
+	statsInodesFree *prometheus.Desc
+	statsInodesUsed *prometheus.Desc
+}
+
+var _ prometheus.Collector = &usageCollector{}
+
+func newUsageCollector(scanner *usageScanner) *usageCollector {
+	labels := []string{"persistentvolume", "persistentvolumeclaim", "namespace", "pool"}
+	return &usageCollector{
+		scanner: scanner,
+		usedBytes: prometheus.NewDesc(prometheus.BuildFQName(metricsNamespace, "", "volume_used_bytes"),
+			"Disk space used by a volume on this node.", labels, nil),
+		usedInodes: prometheus.NewDesc(prometheus.BuildFQName(metricsNamespace, "", "volume_used_inodes"),
+			"Inodes used by a volume on this node.", labels, nil),
+		statsCapacity: prometheus.NewDesc(prometheus.BuildFQName(metricsNamespace, "volume_stats", "capacity_bytes"),
+			"Capacity of a volume on this node in bytes.", labels, nil),
+		statsAvailable: prometheus.NewDesc(prometheus.BuildFQName(metricsNamespace, "volume_stats", "available_bytes"),
+			"Bytes available in a volume on this node.", labels, nil),
+		statsUsed: prometheus.NewDesc(prometheus.BuildFQName(metricsNamespace, "volume_stats", "used_bytes"),
+			"Bytes used in a volume on this node.", labels, nil),
+		statsInodes: prometheus.NewDesc(prometheus.BuildFQName(metricsNamespace, "volume_stats", "inodes"),
+			"Inodes of a volume on this node.", labels, nil),
+		statsInodesFree: prometheus.NewDesc(prometheus.BuildFQName(metricsNamespace, "volume_stats", "inodes_free"),
+			"Free inodes of a volume on this node.", labels, nil),
+		statsInodesUsed: prometheus.NewDesc(prometheus.BuildFQName(metricsNamespace, "volume_stats", "inodes_used"),
+			"Used inodes of a volume on this node.", labels, nil),
+	}
+}
+
+func newVolumeUsage(pv v1.PersistentVolume, bytes, inodes int64) volumeUsage {
+	usage := volumeUsage{
+		pv:     pv.Name,
+		pool:   volumeAnnotations(&pv)[annStoragePool],
+		bytes:  bytes,
+		inodes: inodes,
+	}
+	if usage.pool == "" {
+		usage.pool = defaultPoolName
+	}
+	if capacity, ok := pv.Spec.Capacity[v1.ResourceStorage]; ok {
+		usage.capacity = capacity.Value()
+	}
+	if pv.Spec.ClaimRef != nil {
+		usage.claim = pv.Spec.ClaimRef.Name
+		usage.namespace = pv.Spec.ClaimRef.Namespace
+	}
+	return usage
+}
+
+// Describe implements prometheus.Collector.
+func (c *usageCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- c.usedBytes
+	ch <- c.usedInodes
+	ch <- c.statsCapacity
+	ch <- c.statsAvailable
+	ch <- c.statsUsed
+	ch <- c.statsInodes
+	ch <- c.statsInodesFree
+	ch <- c.statsInodesUsed
+}
+
+// Collect implements prometheus.Collector.
+func (c *usageCollector) Collect(ch chan<- prometheus.Metric) {
+	for _, u := range c.scanner.all() {
+		ch <- prometheus.MustNewConstMetric(c.usedBytes, prometheus.GaugeValue, float64(u.bytes), u.pv, u.claim, u.namespace, u.pool)
+		ch <- prometheus.MustNewConstMetric(c.usedInodes, prometheus.GaugeValue, float64(u.inodes), u.pv, u.claim, u.namespace, u.pool)
+		if u.stats == nil {
+			continue
+		}
+		ch <- prometheus.MustNewConstMetric(c.statsCapacity, prometheus.GaugeValue, float64(u.stats.capacity), u.pv, u.claim, u.namespace, u.pool)
+		ch <- prometheus.MustNewConstMetric(c.statsAvailable, prometheus.GaugeValue, float64(u.stats.available), u.pv, u.claim, u.namespace, u.pool)
+		ch <- prometheus.MustNewConstMetric(c.statsUsed, prometheus.GaugeValue, float64(u.stats.used), u.pv, u.claim, u.namespace, u.pool)
+		ch <- prometheus.MustNewConstMetric(c.statsInodes, prometheus.GaugeValue, float64(u.stats.inodes), u.pv, u.claim, u.namespace, u.pool)
+		ch <- prometheus.MustNewConstMetric(c.statsInodesFree, prometheus.GaugeValue, float64(u.stats.inodesFree), u.pv, u.claim, u.namespace, u.pool)
+		ch <- prometheus.MustNewConstMetric(c.statsInodesUsed, prometheus.GaugeValue, float64(u.stats.inodesUsed), u.pv, u.claim, u.namespace, u.pool)
+	}
+}
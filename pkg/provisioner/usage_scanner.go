@@ -0,0 +1,211 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package provisioner
+
+import (
+	"context"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"k8s.io/klog/v2"
+
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/client-go/kubernetes"
+)
+
+// usageScanTicks is how many times per scan interval the scanner looks for
+// volumes to measure, so that measurements are spread over the interval
+// instead of walking all volumes at once.
+const usageScanTicks = 10
+
+// usageScanner measures the disk usage of the volumes on this node and caches
+// the results. Every volume is measured once per interval, new volumes as soon
+// as they are noticed, and at most workers volumes are walked at the same
+// time. Since volumes are created at different times, their measurements end
+// up spread over the interval, which keeps the load on the disks even.
+type usageScanner struct {
+	client   kubernetes.Interface
+	pvCache  *volumeCache
+	identity string
+	nodeName string
+	interval time.Duration
+	workers  int
+	// measure returns the space and inodes used below path
+	measure func(path string) (int64, int64, error)
+	// stat returns the stats of a measured volume
+	stat func(usage volumeUsage) (*volumeStats, error)
+
+	scanDuration prometheus.Histogram
+
+	mutex sync.Mutex
+	// latest measurement per backing directory
+	usage map[string]volumeUsage
+}
+
+var _ prometheus.Collector = &usageScanner{}
+
+func newUsageScanner(client kubernetes.Interface, host backend, pvCache *volumeCache, identity, nodeName string, interval time.Duration, workers int) *usageScanner {
+	if workers < 1 {
+		workers = 1
+	}
+	return &usageScanner{
+		client:   client,
+		pvCache:  pvCache,
+		identity: identity,
+		nodeName: nodeName,
+		interval: interval,
+		workers:  workers,
+		measure:  host.Usage,
+		stat: func(usage volumeUsage) (*volumeStats, error) {
+			return statVolume(host, usage)
+		},
+		scanDuration: prometheus.NewHistogram(prometheus.HistogramOpts{
+			Namespace: metricsNamespace,
+			Name:      "volume_usage_scan_duration_seconds",
+			Help:      "Time it took to measure the disk usage of a volume.",
+			Buckets:   prometheus.ExponentialBuckets(0.01, 4, 8),
+		}),
+		usage: make(map[string]volumeUsage),
+	}
+}
+
+// Run measures stale volumes until stopCh is closed.
+func (s *usageScanner) Run(stopCh <-chan struct{}) {
+	tick := s.interval / usageScanTicks
+	if tick < time.Second {
+		tick = time.Second
+	}
+	wait.Until(s.scanStale, tick, stopCh)
+}
+
+// scanStale measures the volumes that have not been measured within the
+// interval, the least recently measured first, and forgets volumes that are
+// gone.
+func (s *usageScanner) scanStale() {
+	pvs, err := s.pvCache.list(context.TODO(), s.client)
+	if err != nil {
+		klog.Errorf("unable to list persistent volumes for usage scanning: %v", err)
+		return
+	}
+	current := make(map[string]volumeUsage)
+	for _, pv := range pvs.Items {
+		if pv.Annotations["hostPathProvisionerIdentity"] != s.identity || pv.Annotations["kubevirt.io/provisionOnNode"] != s.nodeName {
+			continue
+		}
+		if backingPath(&pv) == "" {
+			continue
+		}
+		usage := newVolumeUsage(pv, 0, 0)
+		usage.path = filepath.Clean(backingPath(&pv))
+		current[usage.path] = usage
+	}
+	stale := s.staleVolumes(current, time.Now())
+
+	work := make(chan volumeUsage)
+	var wg sync.WaitGroup
+	for i := 0; i < s.workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for usage := range work {
+				s.scan(usage)
+			}
+		}()
+	}
+	for _, usage := range stale {
+		work <- usage
+	}
+	close(work)
+	wg.Wait()
+}
+
+// staleVolumes drops the cached usage of volumes that are no longer current,
+// and returns the current volumes that need to be measured, least recently
+// measured first.
+func (s *usageScanner) staleVolumes(current map[string]volumeUsage, now time.Time) []volumeUsage {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	for path := range s.usage {
+		if _, ok := current[path]; !ok {
+			delete(s.usage, path)
+		}
+	}
+	var stale []volumeUsage
+	for path, usage := range current {
+		if cached, ok := s.usage[path]; ok {
+			if now.Sub(cached.scanned) < s.interval {
+				continue
+			}
+			usage.scanned = cached.scanned
+		}
+		stale = append(stale, usage)
+	}
+	sort.Slice(stale, func(i, j int) bool {
+		return stale[i].scanned.Before(stale[j].scanned)
+	})
+	return stale
+}
+
+func (s *usageScanner) scan(usage volumeUsage) {
+	start := time.Now()
+	bytes, inodes, err := s.measure(usage.path)
+	s.scanDuration.Observe(time.Since(start).Seconds())
+	if err != nil {
+		klog.Warningf("unable to measure usage of volume %s: %v", usage.pv, err)
+		return
+	}
+	usage.bytes, usage.inodes, usage.scanned = bytes, inodes, time.Now()
+	if usage.stats, err = s.stat(usage); err != nil {
+		klog.Warningf("unable to read the stats of volume %s: %v", usage.pv, err)
+	}
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.usage[usage.path] = usage
+}
+
+// lookup returns the latest measurement of the backing directory at path.
+func (s *usageScanner) lookup(path string) (volumeUsage, bool) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	usage, ok := s.usage[filepath.Clean(path)]
+	return usage, ok
+}
+
+// all returns the latest measurements of all volumes.
+func (s *usageScanner) all() []volumeUsage {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	usage := make([]volumeUsage, 0, len(s.usage))
+	for _, u := range s.usage {
+		usage = append(usage, u)
+	}
+	return usage
+}
+
+// Describe implements prometheus.Collector.
+func (s *usageScanner) Describe(ch chan<- *prometheus.Desc) {
+	s.scanDuration.Describe(ch)
+}
+
+// Collect implements prometheus.Collector.
+func (s *usageScanner) Collect(ch chan<- prometheus.Metric) {
+	s.scanDuration.Collect(ch)
+}
@@ -0,0 +1,87 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package provisioner
+
+import (
+	"fmt"
+	"reflect"
+	"sort"
+	"testing"
+	"time"
+)
+
+func Test_usageScannerStaleVolumes(t *testing.T) {
+	now := time.Now()
+	s := newUsageScanner(nil, hostBackend(), nil, "identity", "node", time.Minute, 2)
+	s.usage = map[string]volumeUsage{
+		"/pool/fresh":   {pv: "fresh", path: "/pool/fresh", scanned: now.Add(-10 * time.Second)},
+		"/pool/stale":   {pv: "stale", path: "/pool/stale", scanned: now.Add(-2 * time.Minute)},
+		"/pool/staler":  {pv: "staler", path: "/pool/staler", scanned: now.Add(-5 * time.Minute)},
+		"/pool/deleted": {pv: "deleted", path: "/pool/deleted", scanned: now.Add(-5 * time.Minute)},
+	}
+	current := map[string]volumeUsage{}
+	for _, pv := range []string{"fresh", "stale", "staler", "new"} {
+		current["/pool/"+pv] = volumeUsage{pv: pv, path: "/pool/" + pv}
+	}
+
+	var got []string
+	for _, usage := range s.staleVolumes(current, now) {
+		got = append(got, usage.pv)
+	}
+	// never measured first, then the least recently measured
+	if want := []string{"new", "staler", "stale"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("staleVolumes() = %v, want %v", got, want)
+	}
+	if _, ok := s.lookup("/pool/deleted"); ok {
+		t.Error("usage of a deleted volume is still cached")
+	}
+	if _, ok := s.lookup("/pool/fresh/"); !ok {
+		t.Error("usage of a current volume was dropped")
+	}
+}
+
+func Test_usageScannerScan(t *testing.T) {
+	s := newUsageScanner(nil, hostBackend(), nil, "identity", "node", time.Minute, 1)
+	s.measure = func(path string) (int64, int64, error) {
+		return int64(len(path)), 1, nil
+	}
+	s.stat = func(usage volumeUsage) (*volumeStats, error) {
+		if usage.pv == "pv-2" {
+			return nil, fmt.Errorf("no such volume")
+		}
+		return &volumeStats{used: usage.bytes}, nil
+	}
+	s.scan(volumeUsage{pv: "pv-1", path: "/pool/pv-1"})
+	s.scan(volumeUsage{pv: "pv-2", path: "/pool/pv-22"})
+
+	usage, ok := s.lookup("/pool/pv-1")
+	if !ok || usage.bytes != 10 || usage.inodes != 1 || usage.scanned.IsZero() || usage.stats == nil || usage.stats.used != 10 {
+		t.Errorf("lookup() = %+v, %v", usage, ok)
+	}
+	// Volumes whose stats can not be read are measured still
+	if usage, ok := s.lookup("/pool/pv-22"); !ok || usage.bytes != 11 || usage.stats != nil {
+		t.Errorf("lookup() = %+v, %v", usage, ok)
+	}
+	var pvs []string
+	for _, u := range s.all() {
+		pvs = append(pvs, u.pv)
+	}
+	sort.Strings(pvs)
+	if want := []string{"pv-1", "pv-2"}; !reflect.DeepEqual(pvs, want) {
+		t.Errorf("all() = %v, want %v", pvs, want)
+	}
+}
@@ -0,0 +1,81 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package provisioner
+
+import (
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"k8s.io/klog/v2"
+)
+
+// maxVerbosity caps the level SIGUSR1 raises verbosity to.
+const maxVerbosity = 10
+
+// klogFlags gives access to klog's -v flag whether or not the command
+// registered klog's flags on its own flag set.
+var klogFlags = flag.NewFlagSet("klog", flag.ContinueOnError)
+
+func init() {
+	klog.InitFlags(klogFlags)
+}
+
+// verbosity returns the current klog verbosity.
+func verbosity() int {
+	level, _ := strconv.Atoi(klogFlags.Lookup("v").Value.String())
+	return level
+}
+
+// setVerbosity changes the klog verbosity at runtime.
+func setVerbosity(level int) error {
+	if level < 0 {
+		return fmt.Errorf("invalid verbosity %d", level)
+	}
+	return klogFlags.Set("v", strconv.Itoa(level))
+}
+
+// verbosityHandler reports the verbosity on GET and changes it on PUT, the
+// body of the request being the new level.
+func verbosityHandler(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+	case http.MethodPut:
+		body, err := ioutil.ReadAll(r.Body)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		level, err := strconv.Atoi(strings.TrimSpace(string(body)))
+		if err != nil {
+			http.Error(w, fmt.Sprintf("invalid verbosity %q", body), http.StatusBadRequest)
+			return
+		}
+		if err := setVerbosity(level); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		klog.Infof("log verbosity set to %d", level)
+	default:
+		http.Error(w, "only GET and PUT are supported", http.StatusMethodNotAllowed)
+		return
+	}
+	fmt.Fprintf(w, "%d\n", verbosity())
+}
@@ -0,0 +1,60 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package provisioner
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func Test_verbosityHandler(t *testing.T) {
+	initial := verbosity()
+	defer setVerbosity(initial)
+
+	tests := []struct {
+		name       string
+		method     string
+		body       string
+		wantStatus int
+		wantLevel  int
+	}{
+		{name: "raise", method: http.MethodPut, body: "4\n", wantStatus: http.StatusOK, wantLevel: 4},
+		{name: "get", method: http.MethodGet, wantStatus: http.StatusOK, wantLevel: 4},
+		{name: "not a number", method: http.MethodPut, body: "debug", wantStatus: http.StatusBadRequest, wantLevel: 4},
+		{name: "negative", method: http.MethodPut, body: "-1", wantStatus: http.StatusBadRequest, wantLevel: 4},
+		{name: "lower", method: http.MethodPut, body: "0", wantStatus: http.StatusOK, wantLevel: 0},
+		{name: "unsupported method", method: http.MethodDelete, wantStatus: http.StatusMethodNotAllowed, wantLevel: 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			recorder := httptest.NewRecorder()
+			verbosityHandler(recorder, httptest.NewRequest(tt.method, "/debug/verbosity", strings.NewReader(tt.body)))
+			if recorder.Code != tt.wantStatus {
+				t.Errorf("verbosityHandler() status = %d, want %d", recorder.Code, tt.wantStatus)
+			}
+			if got := verbosity(); got != tt.wantLevel {
+				t.Errorf("verbosity() = %d, want %d", got, tt.wantLevel)
+			}
+			if got := klogFlags.Lookup("v").Value.String(); got != strconv.Itoa(tt.wantLevel) {
+				t.Errorf("klog verbosity = %s, want %d", got, tt.wantLevel)
+			}
+		})
+	}
+}
@@ -0,0 +1,49 @@
+//go:build linux || darwin
+// +build linux darwin
+
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package provisioner
+
+import (
+	"os"
+	"os/signal"
+	"syscall"
+
+	"k8s.io/klog/v2"
+)
+
+// handleVerbositySignals raises the verbosity by one on SIGUSR1 and resets it
+// to the initial verbosity on SIGUSR2, so debug logging can be turned on for
+// a misbehaving provisioner without restarting it.
+func handleVerbositySignals(initial int) {
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGUSR1, syscall.SIGUSR2)
+	go func() {
+		for sig := range signals {
+			level := initial
+			if sig == syscall.SIGUSR1 && verbosity() < maxVerbosity {
+				level = verbosity() + 1
+			}
+			if err := setVerbosity(level); err != nil {
+				klog.Errorf("Unable to change log verbosity: %v", err)
+				continue
+			}
+			klog.Infof("log verbosity set to %d on %v", level, sig)
+		}
+	}()
+}
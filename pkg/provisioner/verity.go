@@ -0,0 +1,239 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package provisioner
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"k8s.io/klog/v2"
+
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/util/retry"
+)
+
+const (
+	// annVerityRootHash records the dm-verity root hash of a sealed volume,
+	// its image is checked against it whenever it is opened.
+	annVerityRootHash = "kubevirt.io/verityRootHash"
+
+	// veritySuffix is appended to the image file of a sealed volume to name
+	// the file holding its hash tree.
+	veritySuffix = ".verity"
+
+	eventReasonVolumeSealed = "VolumeSealed"
+	// eventReasonVerityFailed is added to sealed volumes whose image does
+	// not match their root hash.
+	eventReasonVerityFailed = "VerityCheckFailed"
+)
+
+// verityName returns the name of the dm-verity device of a sealed volume.
+func verityName(pvName string) string {
+	return "hpp-verity-" + pvName
+}
+
+// parseRootHash returns the root hash printed by veritysetup format.
+func parseRootHash(output string) (string, error) {
+	for _, line := range strings.Split(output, "\n") {
+		fields := strings.SplitN(line, ":", 2)
+		if len(fields) == 2 && strings.TrimSpace(fields[0]) == "Root hash" {
+			if hash := strings.TrimSpace(fields[1]); hash != "" {
+				return hash, nil
+			}
+		}
+	}
+	return "", fmt.Errorf("no root hash in veritysetup output %q", output)
+}
+
+// verityFormat computes the hash tree of device into hashFile and returns
+// its root hash.
+func verityFormat(device, hashFile string) (string, error) {
+	output, err := runCommand("veritysetup", "format", device, hashFile)
+	if err != nil {
+		return "", err
+	}
+	return parseRootHash(string(output))
+}
+
+// verityOpen opens device as the read-only dm-verity device name, checked
+// against the hash tree in hashFile and rootHash, and returns the opened
+// device. An opened device is reused.
+func verityOpen(device, name, hashFile, rootHash string) (string, error) {
+	mapped := filepath.Join(mapperDir, name)
+	if _, err := os.Stat(mapped); err == nil {
+		return mapped, nil
+	}
+	if _, err := runCommand("veritysetup", "open", device, name, hashFile, rootHash); err != nil {
+		return "", err
+	}
+	return mapped, nil
+}
+
+// verityClose closes the dm-verity device name, if it is open.
+func verityClose(name string) error {
+	if _, err := os.Stat(filepath.Join(mapperDir, name)); os.IsNotExist(err) {
+		return nil
+	}
+	_, err := runCommand("veritysetup", "close", name)
+	return err
+}
+
+// unsealImageVolume unmounts the sealed image volume from path, closes its
+// dm-verity device and removes its hash tree, leaving the image to
+// removeImageVolume.
+func unsealImageVolume(image, path, name string) error {
+	if isMountPoint(path) {
+		if _, err := runCommand("umount", path); err != nil {
+			return err
+		}
+	}
+	if err := verityClose(name); err != nil {
+		return err
+	}
+	if err := os.Remove(image + veritySuffix); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
+
+// podsUsingClaim returns the pods that use the claim and have not terminated.
+func podsUsingClaim(pods []v1.Pod, claim string) []string {
+	var names []string
+	for _, pod := range pods {
+		if pod.Status.Phase == v1.PodSucceeded || pod.Status.Phase == v1.PodFailed {
+			continue
+		}
+		for _, volume := range pod.Spec.Volumes {
+			if volume.PersistentVolumeClaim != nil && volume.PersistentVolumeClaim.ClaimName == claim {
+				names = append(names, pod.Name)
+				break
+			}
+		}
+	}
+	return names
+}
+
+// checkSealable returns why pv can not be sealed by this provisioner, if it
+// can not.
+func (p *HostPathProvisioner) checkSealable(pv *v1.PersistentVolume) error {
+	switch {
+	case !p.ownsIdentity(pv.Annotations["hostPathProvisionerIdentity"]) || pv.Annotations["kubevirt.io/provisionOnNode"] != p.nodeName:
+		return fmt.Errorf("volume %s was not provisioned on node %s", pv.Name, p.nodeName)
+	case pv.Annotations[annImageFile] == "":
+		return fmt.Errorf("volume %s is not image backed, only image backed volumes can be sealed", pv.Name)
+	case pv.Annotations[annEncryptionSecret] != "":
+		return fmt.Errorf("volume %s is encrypted, encrypted volumes can not be sealed", pv.Name)
+	case p.locked.has(pv.Name) || isLocked(backingPath(pv)):
+		return fmt.Errorf("volume %s is locked", pv.Name)
+	}
+	return nil
+}
+
+// sealVolume seals the populated image backed volume name with dm-verity: the
+// hash tree of its image is computed, the image is mounted read-only through
+// a dm-verity device checking every block read against it, and the root hash
+// is recorded on the PV. The volume must not be used by any pod. The root hash
+// is returned, that of the existing seal if the volume is already sealed.
+func (p *HostPathProvisioner) sealVolume(name string) (string, error) {
+	if p.dryRun {
+		return "", fmt.Errorf("not sealing volume %s in dry run mode", name)
+	}
+	pv, err := p.client.CoreV1().PersistentVolumes().Get(context.TODO(), name, metav1.GetOptions{})
+	if err != nil {
+		return "", err
+	}
+	if err := p.checkSealable(pv); err != nil {
+		return "", err
+	}
+	if rootHash := pv.Annotations[annVerityRootHash]; rootHash != "" {
+		return rootHash, nil
+	}
+	if claim := pv.Spec.ClaimRef; claim != nil {
+		pods, err := p.client.CoreV1().Pods(claim.Namespace).List(context.TODO(), metav1.ListOptions{})
+		if err != nil {
+			return "", fmt.Errorf("unable to list the pods of namespace %s: %v", claim.Namespace, err)
+		}
+		if names := podsUsingClaim(pods.Items, claim.Name); len(names) > 0 {
+			return "", fmt.Errorf("volume %s is used by pods %s", pv.Name, strings.Join(names, ", "))
+		}
+	}
+
+	image, path := pv.Annotations[annImageFile], backingPath(pv)
+	fs := imageFilesystemOf(pv)
+	klog.InfoS("Sealing volume", "node", p.nodeName, "pv", pv.Name, "image", image, "path", path)
+	if isMountPoint(path) {
+		if _, err := runCommand("umount", path); err != nil {
+			return "", err
+		}
+	}
+	device, err := p.loopDevices.attach(image)
+	if err != nil {
+		return "", err
+	}
+	rootHash, err := openSealedImage(pv, device, fs, path)
+	if err != nil {
+		if removeErr := unsealImageVolume(image, path, verityName(pv.Name)); removeErr != nil {
+			klog.Warningf("unable to remove the seal of volume %s after failing to seal it: %v", pv.Name, removeErr)
+		} else if mountErr := mountDevice(device, fs, path, false); mountErr != nil {
+			klog.Warningf("unable to mount image %s again after failing to seal it: %v", image, mountErr)
+		}
+		return "", err
+	}
+	if err := p.setRootHash(pv.Name, rootHash); err != nil {
+		return "", fmt.Errorf("volume %s is sealed with root hash %s, but recording it failed: %v", pv.Name, rootHash, err)
+	}
+	p.volumeEvent(pv, v1.EventTypeNormal, eventReasonVolumeSealed, "Volume %s on node %s is sealed with dm-verity root hash %s", pv.Name, p.nodeName, rootHash)
+	klog.InfoS("Sealed volume", "node", p.nodeName, "pv", pv.Name, "rootHash", rootHash)
+	return rootHash, nil
+}
+
+// openSealedImage computes the hash tree of the image attached to device,
+// mounts it read-only at path through its dm-verity device and returns the
+// root hash.
+func openSealedImage(pv *v1.PersistentVolume, device string, fs imageFilesystem, path string) (string, error) {
+	hashFile := pv.Annotations[annImageFile] + veritySuffix
+	rootHash, err := verityFormat(device, hashFile)
+	if err != nil {
+		return "", err
+	}
+	mapped, err := verityOpen(device, verityName(pv.Name), hashFile, rootHash)
+	if err != nil {
+		return "", err
+	}
+	fs.mountOptions = append(fs.mountOptions, "ro")
+	return rootHash, mountDevice(mapped, fs, path, false)
+}
+
+// setRootHash records the root hash of a sealed volume on its PV.
+func (p *HostPathProvisioner) setRootHash(pvName, rootHash string) error {
+	return retry.RetryOnConflict(retry.DefaultRetry, func() error {
+		pv, err := p.client.CoreV1().PersistentVolumes().Get(context.TODO(), pvName, metav1.GetOptions{})
+		if err != nil {
+			return err
+		}
+		if pv.Annotations == nil {
+			pv.Annotations = make(map[string]string)
+		}
+		pv.Annotations[annVerityRootHash] = rootHash
+		_, err = p.client.CoreV1().PersistentVolumes().Update(context.TODO(), pv, metav1.UpdateOptions{})
+		return err
+	})
+}
@@ -0,0 +1,117 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package provisioner
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	v1 "k8s.io/api/core/v1"
+)
+
+const veritysetupFormatOutput = `VERITY header information for /dev/loop3
+UUID:            	1f2c0a7e-4a43-4be4-9a02-5d2b7c1a8e51
+Hash type:       	1
+Data blocks:     	262144
+Data block size: 	4096
+Hash block size: 	4096
+Hash algorithm:  	sha256
+Salt:            	5c1e0cbd7a3f3f4e2d6a9b1c0d8e7f6a5b4c3d2e1f0a9b8c7d6e5f4a3b2c1d0e
+Root hash:      	4392712ba01368efdf14b05c76f9e4df0d53664630b5d48632ed17a137f39076
+`
+
+func Test_parseRootHash(t *testing.T) {
+	got, err := parseRootHash(veritysetupFormatOutput)
+	if err != nil {
+		t.Fatalf("parseRootHash() failed: %v", err)
+	}
+	if want := "4392712ba01368efdf14b05c76f9e4df0d53664630b5d48632ed17a137f39076"; got != want {
+		t.Errorf("parseRootHash() = %s, want %s", got, want)
+	}
+	if _, err := parseRootHash("VERITY header information for /dev/loop3\n"); err == nil {
+		t.Errorf("parseRootHash() of output without a root hash succeeded")
+	}
+}
+
+func Test_verityOpenClose(t *testing.T) {
+	dir, err := ioutil.TempDir("", "verity")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	defer func(dir string) { mapperDir = dir }(mapperDir)
+	mapperDir = dir
+	commands, restore := fakeCommands(map[string]string{
+		"veritysetup open /dev/loop3 hpp-verity-pvc-1 /pool/pvc-1.img.verity 4392": "",
+		"veritysetup close hpp-verity-pvc-1":                                       "",
+	})
+	defer restore()
+
+	got, err := verityOpen("/dev/loop3", "hpp-verity-pvc-1", "/pool/pvc-1.img.verity", "4392")
+	if err != nil {
+		t.Fatalf("verityOpen() failed: %v", err)
+	}
+	if want := filepath.Join(dir, "hpp-verity-pvc-1"); got != want {
+		t.Errorf("verityOpen() = %s, want %s", got, want)
+	}
+	if err := verityClose("hpp-verity-pvc-1"); err != nil {
+		t.Fatalf("verityClose() of a closed device failed: %v", err)
+	}
+	if err := ioutil.WriteFile(got, nil, 0600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := verityOpen("/dev/loop3", "hpp-verity-pvc-1", "/pool/pvc-1.img.verity", "4392"); err != nil {
+		t.Fatalf("verityOpen() of an open device failed: %v", err)
+	}
+	if err := verityClose("hpp-verity-pvc-1"); err != nil {
+		t.Fatalf("verityClose() failed: %v", err)
+	}
+	want := []string{
+		"veritysetup open /dev/loop3 hpp-verity-pvc-1 /pool/pvc-1.img.verity 4392",
+		"veritysetup close hpp-verity-pvc-1",
+	}
+	if !reflect.DeepEqual(*commands, want) {
+		t.Errorf("commands = %v, want %v", *commands, want)
+	}
+}
+
+func Test_podsUsingClaim(t *testing.T) {
+	pod := func(name, claim string, phase v1.PodPhase) v1.Pod {
+		pod := v1.Pod{Status: v1.PodStatus{Phase: phase}}
+		pod.Name = name
+		pod.Spec.Volumes = []v1.Volume{
+			{Name: "config", VolumeSource: v1.VolumeSource{ConfigMap: &v1.ConfigMapVolumeSource{}}},
+			{Name: "disk", VolumeSource: v1.VolumeSource{PersistentVolumeClaim: &v1.PersistentVolumeClaimVolumeSource{ClaimName: claim}}},
+		}
+		return pod
+	}
+	pods := []v1.Pod{
+		pod("running", "golden", v1.PodRunning),
+		pod("pending", "golden", v1.PodPending),
+		pod("completed", "golden", v1.PodSucceeded),
+		pod("other", "scratch", v1.PodRunning),
+	}
+	if got, want := podsUsingClaim(pods, "golden"), []string{"running", "pending"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("podsUsingClaim() = %v, want %v", got, want)
+	}
+	if got := podsUsingClaim(pods, "unused"); len(got) != 0 {
+		t.Errorf("podsUsingClaim() of an unused claim = %v", got)
+	}
+}
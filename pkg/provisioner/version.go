@@ -0,0 +1,50 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package provisioner
+
+import (
+	"fmt"
+	"runtime"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// Build information, set at compile time with
+// -ldflags "-X kubevirt.io/hostpath-provisioner/pkg/provisioner.version=...", likewise
+// commit and buildDate
+var (
+	version   = "unknown"
+	commit    = "unknown"
+	buildDate = "unknown"
+)
+
+// Version describes the build of the provisioner.
+func Version() string {
+	return fmt.Sprintf("hostpath-provisioner version %s, commit %s, built %s with %s", version, commit, buildDate, runtime.Version())
+}
+
+// newBuildInfoCollector returns a metric that is always 1 and carries the
+// build information as labels.
+func newBuildInfoCollector() prometheus.Collector {
+	buildInfo := prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: metricsNamespace,
+		Name:      "build_info",
+		Help:      "Build information of the provisioner, the value is always 1.",
+	}, []string{"version", "commit", "build_date", "go_version"})
+	buildInfo.WithLabelValues(version, commit, buildDate, runtime.Version()).Set(1)
+	return buildInfo
+}
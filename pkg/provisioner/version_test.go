@@ -0,0 +1,44 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package provisioner
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+)
+
+func Test_buildInfoCollector(t *testing.T) {
+	ch := make(chan prometheus.Metric, 1)
+	newBuildInfoCollector().Collect(ch)
+	m := &dto.Metric{}
+	if err := (<-ch).Write(m); err != nil {
+		t.Fatal(err)
+	}
+	if m.GetGauge().GetValue() != 1 {
+		t.Errorf("build_info = %v, want 1", m.GetGauge().GetValue())
+	}
+	labels := map[string]string{}
+	for _, label := range m.Label {
+		labels[label.GetName()] = label.GetValue()
+	}
+	if labels["version"] != version || labels["commit"] != commit || labels["build_date"] != buildDate || !strings.HasPrefix(labels["go_version"], "go") {
+		t.Errorf("build_info labels = %v", labels)
+	}
+}
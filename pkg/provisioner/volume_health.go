@@ -0,0 +1,197 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package provisioner
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"time"
+
+	"k8s.io/klog/v2"
+
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/tools/record"
+	"k8s.io/client-go/util/retry"
+)
+
+const (
+	// annVolumeHealth holds the result of the last health check of a volume,
+	// volumeHealthy or a description of the problem.
+	annVolumeHealth = "kubevirt.io/volumeHealth"
+	volumeHealthy   = "Healthy"
+)
+
+// volumeHealthMonitor periodically checks the backing directories of the
+// volumes on this node and reports problems on the PV and its claim, so that
+// data path problems are noticed before a workload trips over them.
+type volumeHealthMonitor struct {
+	client        kubernetes.Interface
+	host          backend
+	pvCache       *volumeCache
+	identity      string
+	nodeName      string
+	eventRecorder record.EventRecorder
+	// relabel restores changed SELinux contexts, otherwise they are only
+	// reported. It is off without the SELinuxRelabel feature and in a dry
+	// run.
+	relabel bool
+	// usedBytes returns the space used by a backing directory
+	usedBytes func(path string) (int64, error)
+}
+
+// newVolumeHealthMonitor returns a monitor that compares the usage measured
+// by scanner with the requested capacity of the volumes.
+func newVolumeHealthMonitor(client kubernetes.Interface, host backend, pvCache *volumeCache, identity, nodeName string, eventRecorder record.EventRecorder, scanner *usageScanner) *volumeHealthMonitor {
+	return &volumeHealthMonitor{
+		client:        client,
+		host:          host,
+		pvCache:       pvCache,
+		identity:      identity,
+		nodeName:      nodeName,
+		eventRecorder: eventRecorder,
+		usedBytes: func(path string) (int64, error) {
+			usage, ok := scanner.lookup(path)
+			if !ok {
+				return 0, fmt.Errorf("usage of %s has not been measured yet", path)
+			}
+			return usage.bytes, nil
+		},
+	}
+}
+
+// Run checks all volumes every interval until stopCh is closed.
+func (m *volumeHealthMonitor) Run(interval time.Duration, stopCh <-chan struct{}) {
+	wait.Until(m.checkAll, interval, stopCh)
+}
+
+func (m *volumeHealthMonitor) checkAll() {
+	pvs, err := m.pvCache.list(context.TODO(), m.client)
+	if err != nil {
+		klog.Errorf("unable to list persistent volumes for health checks: %v", err)
+		return
+	}
+	for i := range pvs.Items {
+		pv := &pvs.Items[i]
+		if pv.Annotations["hostPathProvisionerIdentity"] != m.identity || pv.Annotations["kubevirt.io/provisionOnNode"] != m.nodeName {
+			continue
+		}
+		if backingPath(pv) == "" || pv.DeletionTimestamp != nil || pv.Status.Phase == v1.VolumeReleased {
+			continue
+		}
+		status := volumeHealthy
+		if problem := m.volumeProblem(pv); problem != "" {
+			status = problem
+		}
+		previous, known := pv.Annotations[annVolumeHealth]
+		if previous == status {
+			continue
+		}
+		if err := m.setHealth(pv.Name, status); err != nil {
+			klog.Errorf("unable to record health of volume %s: %v", pv.Name, err)
+			continue
+		}
+		m.healthChanged(pv, previous, known, status)
+	}
+}
+
+// volumeProblem returns what is wrong with the backing directory of the
+// volume, or an empty string if nothing is.
+func (m *volumeHealthMonitor) volumeProblem(pv *v1.PersistentVolume) string {
+	path := backingPath(pv)
+	info, err := os.Stat(path)
+	if os.IsNotExist(err) {
+		return fmt.Sprintf("backing directory %s is missing", path)
+	} else if err != nil {
+		return fmt.Sprintf("unable to access backing directory %s: %v", path, err)
+	} else if !info.IsDir() {
+		return fmt.Sprintf("backing path %s is not a directory", path)
+	}
+
+	if context := pv.Annotations[annSELinuxContext]; context != "" {
+		restored, err := restoreSELinuxContext(path, context, m.relabel)
+		if err != nil {
+			return err.Error()
+		}
+		if restored {
+			klog.Warningf("restored SELinux context %s of backing directory %s of volume %s", context, path, pv.Name)
+			m.eventRecorder.Eventf(pv, v1.EventTypeWarning, "SELinuxContextRestored", "SELinux context of backing directory %s on node %s was changed, restored %s", path, m.nodeName, context)
+		}
+	}
+
+	stats, err := m.host.Stat(path)
+	if err != nil {
+		return fmt.Sprintf("unable to stat the filesystem of backing directory %s: %v", path, err)
+	}
+	if stats.readOnly {
+		return fmt.Sprintf("the filesystem of backing directory %s is mounted read-only", path)
+	}
+
+	requested, err := resource.ParseQuantity(pv.Annotations[annRequestedCapacity])
+	if err != nil || requested.IsZero() {
+		return ""
+	}
+	used, err := m.usedBytes(path)
+	if err != nil {
+		klog.V(3).Infof("unable to measure usage of volume %s for health checks: %v", pv.Name, err)
+		return ""
+	}
+	if used > requested.Value() {
+		return fmt.Sprintf("uses %s, more than the %s requested by its claim", resource.NewQuantity(used, resource.BinarySI).String(), requested.String())
+	}
+	return ""
+}
+
+// setHealth records the health of the volume in its annotation.
+func (m *volumeHealthMonitor) setHealth(pvName, status string) error {
+	return retry.RetryOnConflict(retry.DefaultRetry, func() error {
+		pv, err := m.client.CoreV1().PersistentVolumes().Get(context.TODO(), pvName, metav1.GetOptions{})
+		if err != nil {
+			return err
+		}
+		if pv.Annotations == nil {
+			pv.Annotations = make(map[string]string)
+		}
+		pv.Annotations[annVolumeHealth] = status
+		_, err = m.client.CoreV1().PersistentVolumes().Update(context.TODO(), pv, metav1.UpdateOptions{})
+		return err
+	})
+}
+
+// healthChanged emits events on the volume and its claim when the volume
+// became unhealthy, or recovered. Volumes found healthy the first time they
+// are checked are not reported.
+func (m *volumeHealthMonitor) healthChanged(pv *v1.PersistentVolume, previous string, known bool, status string) {
+	eventtype, reason, message := v1.EventTypeWarning, "VolumeUnhealthy", "Volume "+pv.Name+" on node "+m.nodeName+" is unhealthy: "+status
+	if status == volumeHealthy {
+		if !known || previous == volumeHealthy {
+			return
+		}
+		eventtype, reason, message = v1.EventTypeNormal, "VolumeHealthy", "Volume "+pv.Name+" on node "+m.nodeName+" recovered"
+		klog.Infof("volume %s recovered", pv.Name)
+	} else {
+		klog.Warningf("volume %s is unhealthy: %s", pv.Name, status)
+	}
+	m.eventRecorder.Event(pv, eventtype, reason, message)
+	if pv.Spec.ClaimRef != nil {
+		m.eventRecorder.Event(pv.Spec.ClaimRef, eventtype, reason, message)
+	}
+}
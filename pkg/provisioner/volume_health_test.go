@@ -0,0 +1,111 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package provisioner
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/tools/record"
+)
+
+func newHealthTestVolume(path, requested string) *v1.PersistentVolume {
+	return &v1.PersistentVolume{
+		ObjectMeta: metav1.ObjectMeta{Name: "pv", Annotations: map[string]string{annRequestedCapacity: requested}},
+		Spec: v1.PersistentVolumeSpec{
+			PersistentVolumeSource: v1.PersistentVolumeSource{HostPath: &v1.HostPathVolumeSource{Path: path}},
+			ClaimRef:               &v1.ObjectReference{Kind: "PersistentVolumeClaim", Namespace: "default", Name: "claim"},
+		},
+	}
+}
+
+func Test_volumeProblem(t *testing.T) {
+	dir, err := ioutil.TempDir("", "health")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	file := filepath.Join(dir, "file")
+	if err := ioutil.WriteFile(file, nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name      string
+		path      string
+		requested string
+		used      int64
+		want      string
+	}{
+		{name: "healthy", path: dir, requested: "1Gi", used: 1024, want: ""},
+		{name: "missing", path: filepath.Join(dir, "missing"), requested: "1Gi", want: "is missing"},
+		{name: "not a directory", path: file, requested: "1Gi", want: "is not a directory"},
+		{name: "over requested capacity", path: dir, requested: "1Mi", used: 2 << 20, want: "uses 2Mi, more than the 1Mi requested"},
+		{name: "no requested capacity", path: dir, requested: "", used: 2 << 20, want: ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m := &volumeHealthMonitor{host: hostBackend(), usedBytes: func(string) (int64, error) { return tt.used, nil }}
+			got := m.volumeProblem(newHealthTestVolume(tt.path, tt.requested))
+			if (tt.want == "") != (got == "") || !strings.Contains(got, tt.want) {
+				t.Errorf("volumeProblem() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_volumeHealthChanged(t *testing.T) {
+	tests := []struct {
+		name     string
+		previous string
+		known    bool
+		status   string
+		want     string
+	}{
+		{name: "first check healthy", status: volumeHealthy, want: ""},
+		{name: "first check unhealthy", status: "broken", want: "Warning VolumeUnhealthy"},
+		{name: "became unhealthy", previous: volumeHealthy, known: true, status: "broken", want: "Warning VolumeUnhealthy"},
+		{name: "recovered", previous: "broken", known: true, status: volumeHealthy, want: "Normal VolumeHealthy"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			recorder := record.NewFakeRecorder(10)
+			m := newVolumeHealthMonitor(nil, hostBackend(), nil, "identity", "node", recorder, nil)
+			m.healthChanged(newHealthTestVolume("/pool/pv", "1Gi"), tt.previous, tt.known, tt.status)
+			close(recorder.Events)
+			var events []string
+			for event := range recorder.Events {
+				events = append(events, event)
+			}
+			if tt.want == "" {
+				if len(events) != 0 {
+					t.Errorf("events = %v, want none", events)
+				}
+				return
+			}
+			// one event on the volume and one on its claim
+			if len(events) != 2 || !strings.HasPrefix(events[0], tt.want) || !strings.HasPrefix(events[1], tt.want) {
+				t.Errorf("events = %v, want two %s events", events, tt.want)
+			}
+		})
+	}
+}
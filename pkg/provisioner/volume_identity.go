@@ -0,0 +1,126 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package provisioner
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"syscall"
+	"time"
+
+	v1 "k8s.io/api/core/v1"
+)
+
+// xattrPrefix prefixes the extended attributes recording which claim a
+// backing directory was created for.
+const xattrPrefix = "user.kubevirt.io."
+
+// volumeIdentity records the claim a backing directory was created for, so
+// that data on disk can still be mapped to cluster objects after the PV is
+// gone, e.g. after an etcd restore.
+type volumeIdentity struct {
+	Namespace string    `json:"namespace"`
+	Claim     string    `json:"claim"`
+	UID       string    `json:"uid"`
+	PV        string    `json:"pv"`
+	Created   time.Time `json:"created"`
+}
+
+func newVolumeIdentity(pvc *v1.PersistentVolumeClaim, pvName string, created time.Time) volumeIdentity {
+	return volumeIdentity{
+		Namespace: pvc.Namespace,
+		Claim:     pvc.Name,
+		UID:       string(pvc.UID),
+		PV:        pvName,
+		Created:   created.UTC(),
+	}
+}
+
+func (id volumeIdentity) xattrs() map[string]string {
+	return map[string]string{
+		"namespace": id.Namespace,
+		"claim":     id.Claim,
+		"uid":       id.UID,
+		"pv":        id.PV,
+		"created":   id.Created.Format(time.RFC3339),
+	}
+}
+
+// identityFile is where the identity of dir is stored when the filesystem
+// does not support user extended attributes. It is kept next to the
+// directory so that it does not show up in the volume.
+func identityFile(dir string) string {
+	return filepath.Join(filepath.Dir(dir), "."+filepath.Base(dir)+".identity.json")
+}
+
+// writeVolumeIdentity tags dir with the identity, as extended attributes or,
+// when the filesystem doesn't support them, in a metadata file.
+func writeVolumeIdentity(dir string, id volumeIdentity) error {
+	for name, value := range id.xattrs() {
+		err := setxattr(dir, xattrPrefix+name, []byte(value), 0)
+		if err == syscall.ENOTSUP || err == syscall.EOPNOTSUPP {
+			return writeIdentityFile(dir, id)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func writeIdentityFile(dir string, id volumeIdentity) error {
+	data, err := json.Marshal(id)
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(identityFile(dir), data, 0644)
+}
+
+// readVolumeIdentity returns the identity dir was tagged with.
+func readVolumeIdentity(dir string) (volumeIdentity, error) {
+	id := volumeIdentity{}
+	if data, err := ioutil.ReadFile(identityFile(dir)); err == nil {
+		return id, json.Unmarshal(data, &id)
+	}
+	values := make(map[string]string)
+	buf := make([]byte, 256)
+	for name := range id.xattrs() {
+		size, err := getxattr(dir, xattrPrefix+name, buf)
+		if err != nil {
+			return id, err
+		}
+		values[name] = string(buf[:size])
+	}
+	id.Namespace = values["namespace"]
+	id.Claim = values["claim"]
+	id.UID = values["uid"]
+	id.PV = values["pv"]
+	created, err := time.Parse(time.RFC3339, values["created"])
+	id.Created = created
+	return id, err
+}
+
+// removeVolumeIdentity removes the metadata file of dir, if there is one.
+// Extended attributes go away with the directory.
+func removeVolumeIdentity(dir string) error {
+	if err := os.Remove(identityFile(dir)); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,98 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package provisioner
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"syscall"
+	"testing"
+	"time"
+
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func Test_volumeIdentity(t *testing.T) {
+	pvc := &v1.PersistentVolumeClaim{ObjectMeta: metav1.ObjectMeta{Namespace: "ns", Name: "data", UID: "1234"}}
+	want := newVolumeIdentity(pvc, "pvc-1234", time.Date(2019, 5, 1, 10, 0, 0, 0, time.UTC))
+
+	tests := []struct {
+		name     string
+		noXattrs bool
+		wantFile bool
+	}{
+		{name: "xattrs", noXattrs: false},
+		{name: "no xattr support", noXattrs: true, wantFile: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			pool, err := ioutil.TempDir("", "identity")
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer os.RemoveAll(pool)
+			dir := filepath.Join(pool, "pvc-1234")
+			if err := os.Mkdir(dir, 0777); err != nil {
+				t.Fatal(err)
+			}
+
+			// Not every filesystem used for temporary directories supports
+			// user extended attributes, fake them to get the same results
+			originalSet, originalGet := setxattr, getxattr
+			defer func() {
+				setxattr, getxattr = originalSet, originalGet
+			}()
+			xattrs := map[string][]byte{}
+			setxattr = func(path, name string, value []byte, flags int) error {
+				if tt.noXattrs {
+					return syscall.ENOTSUP
+				}
+				xattrs[path+name] = value
+				return nil
+			}
+			getxattr = func(path, name string, dest []byte) (int, error) {
+				value, ok := xattrs[path+name]
+				if !ok {
+					return 0, syscall.ENODATA
+				}
+				return copy(dest, value), nil
+			}
+
+			if err := writeVolumeIdentity(dir, want); err != nil {
+				t.Fatalf("writeVolumeIdentity() error = %v", err)
+			}
+			if _, err := os.Stat(identityFile(dir)); (err == nil) != tt.wantFile {
+				t.Errorf("identity file exists = %v, want %v", err == nil, tt.wantFile)
+			}
+			got, err := readVolumeIdentity(dir)
+			if err != nil {
+				t.Fatalf("readVolumeIdentity() error = %v", err)
+			}
+			if got != want {
+				t.Errorf("readVolumeIdentity() = %+v, want %+v", got, want)
+			}
+			if err := removeVolumeIdentity(dir); err != nil {
+				t.Errorf("removeVolumeIdentity() error = %v", err)
+			}
+			if _, err := os.Stat(identityFile(dir)); !os.IsNotExist(err) {
+				t.Errorf("identity file left behind: %v", err)
+			}
+		})
+	}
+}
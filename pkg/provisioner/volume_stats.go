@@ -0,0 +1,164 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package provisioner
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"k8s.io/klog/v2"
+
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/util/retry"
+)
+
+// annVolumeStats holds the last stats of a volume recorded on its PV, as
+// written by volumeStats.String.
+const annVolumeStats = "kubevirt.io/volumeStats"
+
+// volumeStats are the capacity and usage of a volume, what CSI drivers report
+// with NodeGetVolumeStats and the kubelet exports as kubelet_volume_stats.
+type volumeStats struct {
+	capacity   int64
+	available  int64
+	used       int64
+	inodes     int64
+	inodesFree int64
+	inodesUsed int64
+}
+
+func (s volumeStats) String() string {
+	return fmt.Sprintf("capacity=%d available=%d used=%d inodes=%d inodesFree=%d inodesUsed=%d", s.capacity, s.available, s.used, s.inodes, s.inodesFree, s.inodesUsed)
+}
+
+// statVolume returns the stats of the volume measured as usage.
+func statVolume(host backend, usage volumeUsage) (*volumeStats, error) {
+	stats, err := host.Stat(usage.path)
+	if err != nil {
+		return nil, err
+	}
+	return volumeStatsOf(usage, stats, isMountPoint(usage.path)), nil
+}
+
+// volumeStatsOf returns the stats of the volume measured as usage whose
+// backing directory is in the filesystem of fs. Volumes mounted at their
+// backing directory, e.g. logical volumes and image files, have a filesystem
+// of their own, its stats are theirs. Directories share the pool's: they are
+// as large as their PV, and the space and inodes left are bounded by those
+// left in the pool.
+func volumeStatsOf(usage volumeUsage, fs filesystemStats, mounted bool) *volumeStats {
+	if mounted {
+		return &volumeStats{
+			capacity:   fs.capacity,
+			available:  fs.available,
+			used:       fs.used(),
+			inodes:     fs.inodes,
+			inodesFree: fs.inodesFree,
+			inodesUsed: fs.inodes - fs.inodesFree,
+		}
+	}
+	stats := &volumeStats{
+		capacity:   usage.capacity,
+		available:  usage.capacity - usage.bytes,
+		used:       usage.bytes,
+		inodesFree: fs.inodesFree,
+		inodesUsed: usage.inodes,
+	}
+	if stats.available > fs.available {
+		stats.available = fs.available
+	}
+	if stats.available < 0 {
+		stats.available = 0
+	}
+	stats.inodes = stats.inodesUsed + stats.inodesFree
+	return stats
+}
+
+// volumeStatsRecorder periodically records the stats measured by the usage
+// scanner in the annotations of the PVs of this node, for tools reading them
+// from the API rather than from the metrics.
+type volumeStatsRecorder struct {
+	client   kubernetes.Interface
+	pvCache  *volumeCache
+	identity string
+	nodeName string
+	// lookup returns the latest measurement of a backing directory
+	lookup func(path string) (volumeUsage, bool)
+}
+
+func newVolumeStatsRecorder(client kubernetes.Interface, pvCache *volumeCache, identity, nodeName string, scanner *usageScanner) *volumeStatsRecorder {
+	return &volumeStatsRecorder{
+		client:   client,
+		pvCache:  pvCache,
+		identity: identity,
+		nodeName: nodeName,
+		lookup:   scanner.lookup,
+	}
+}
+
+// Run records the stats of all volumes every interval until stopCh is closed.
+func (r *volumeStatsRecorder) Run(interval time.Duration, stopCh <-chan struct{}) {
+	wait.Until(r.recordAll, interval, stopCh)
+}
+
+func (r *volumeStatsRecorder) recordAll() {
+	pvs, err := r.pvCache.list(context.TODO(), r.client)
+	if err != nil {
+		klog.Errorf("unable to list persistent volumes for recording their stats: %v", err)
+		return
+	}
+	for i := range pvs.Items {
+		pv := &pvs.Items[i]
+		if pv.Annotations["hostPathProvisionerIdentity"] != r.identity || pv.Annotations["kubevirt.io/provisionOnNode"] != r.nodeName {
+			continue
+		}
+		if backingPath(pv) == "" || pv.DeletionTimestamp != nil || pv.Status.Phase == v1.VolumeReleased {
+			continue
+		}
+		usage, ok := r.lookup(backingPath(pv))
+		if !ok || usage.stats == nil {
+			continue
+		}
+		stats := usage.stats.String()
+		if pv.Annotations[annVolumeStats] == stats {
+			continue
+		}
+		if err := r.setStats(pv.Name, stats); err != nil {
+			klog.Errorf("unable to record stats of volume %s: %v", pv.Name, err)
+		}
+	}
+}
+
+// setStats records the stats of the volume in its annotation.
+func (r *volumeStatsRecorder) setStats(pvName, stats string) error {
+	return retry.RetryOnConflict(retry.DefaultRetry, func() error {
+		pv, err := r.client.CoreV1().PersistentVolumes().Get(context.TODO(), pvName, metav1.GetOptions{})
+		if err != nil {
+			return err
+		}
+		if pv.Annotations == nil {
+			pv.Annotations = make(map[string]string)
+		}
+		pv.Annotations[annVolumeStats] = stats
+		_, err = r.client.CoreV1().PersistentVolumes().Update(context.TODO(), pv, metav1.UpdateOptions{})
+		return err
+	})
+}
@@ -0,0 +1,69 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package provisioner
+
+import (
+	"reflect"
+	"testing"
+)
+
+func Test_volumeStatsOf(t *testing.T) {
+	// a pool of 100GiB with 40GiB and a million inodes free
+	fs := filesystemStats{capacity: 100 * GiB, free: 41 * GiB, available: 40 * GiB, inodes: 4000000, inodesFree: 1000000}
+	tests := []struct {
+		name    string
+		usage   volumeUsage
+		mounted bool
+		want    volumeStats
+	}{
+		{
+			name:    "own filesystem",
+			usage:   volumeUsage{capacity: 10 * GiB, bytes: 5 * GiB, inodes: 10},
+			mounted: true,
+			want:    volumeStats{capacity: 100 * GiB, available: 40 * GiB, used: 59 * GiB, inodes: 4000000, inodesFree: 1000000, inodesUsed: 3000000},
+		},
+		{
+			name:  "directory",
+			usage: volumeUsage{capacity: 10 * GiB, bytes: 4 * GiB, inodes: 10},
+			want:  volumeStats{capacity: 10 * GiB, available: 6 * GiB, used: 4 * GiB, inodes: 1000010, inodesFree: 1000000, inodesUsed: 10},
+		},
+		{
+			name:  "pool fuller than the directory",
+			usage: volumeUsage{capacity: 50 * GiB, bytes: 4 * GiB, inodes: 10},
+			want:  volumeStats{capacity: 50 * GiB, available: 40 * GiB, used: 4 * GiB, inodes: 1000010, inodesFree: 1000000, inodesUsed: 10},
+		},
+		{
+			name:  "over capacity",
+			usage: volumeUsage{capacity: 10 * GiB, bytes: 12 * GiB, inodes: 10},
+			want:  volumeStats{capacity: 10 * GiB, used: 12 * GiB, inodes: 1000010, inodesFree: 1000000, inodesUsed: 10},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := volumeStatsOf(tt.usage, fs, tt.mounted); !reflect.DeepEqual(*got, tt.want) {
+				t.Errorf("volumeStatsOf() = %+v, want %+v", *got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_volumeStatsString(t *testing.T) {
+	stats := volumeStats{capacity: 10, available: 6, used: 4, inodes: 12, inodesFree: 10, inodesUsed: 2}
+	if got, want := stats.String(), "capacity=10 available=6 used=4 inodes=12 inodesFree=10 inodesUsed=2"; got != want {
+		t.Errorf("String() = %q, want %q", got, want)
+	}
+}
@@ -1,6 +1,7 @@
 package tests
 
 import (
+	"context"
 	"flag"
 	"testing"
 
@@ -35,10 +36,10 @@ func setupTestCaseNs(t *testing.T) (func(*testing.T), *corev1.Namespace, *kubern
 	if err != nil {
 		t.Errorf("ERROR, unable to create K8SClient: %v", err)
 	}
-	ns, err := k8sClient.CoreV1().Namespaces().Create(createNamespace())
+	ns, err := k8sClient.CoreV1().Namespaces().Create(context.TODO(), createNamespace(), metav1.CreateOptions{})
 	return func(t *testing.T) {
 		t.Logf("Removing namespace: %s", ns.Name)
-		err := k8sClient.CoreV1().Namespaces().Delete(ns.Name, &metav1.DeleteOptions{})
+		err := k8sClient.CoreV1().Namespaces().Delete(context.TODO(), ns.Name, metav1.DeleteOptions{})
 		if err != nil {
 			t.Errorf("ERROR, unable to remove namespace: %s, %v", ns.Name, err)
 		}
@@ -57,7 +58,7 @@ func createNamespace() *corev1.Namespace {
 }
 
 func getAllNodes(k8sClient *kubernetes.Clientset) (*corev1.NodeList, error) {
-	return k8sClient.CoreV1().Nodes().List(metav1.ListOptions{})
+	return k8sClient.CoreV1().Nodes().List(context.TODO(), metav1.ListOptions{})
 }
 
 // getKubeClient returns a Kubernetes rest client
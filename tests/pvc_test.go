@@ -1,6 +1,7 @@
 package tests
 
 import (
+	"context"
 	"testing"
 	"time"
 
@@ -25,22 +26,22 @@ func TestCreatePVCOnNode1(t *testing.T) {
 		// Cleanup
 		if pvc != nil {
 			t.Logf("Removing PVC: %s", pvc.Name)
-			err := k8sClient.CoreV1().PersistentVolumeClaims(ns.Name).Delete(pvc.Name, &metav1.DeleteOptions{})
+			err := k8sClient.CoreV1().PersistentVolumeClaims(ns.Name).Delete(context.TODO(), pvc.Name, metav1.DeleteOptions{})
 			Expect(err).ToNot(HaveOccurred())
 		}
 	}()
 
 	t.Logf("Creating PVC: %s", pvc.Name)
-	pvc, err = k8sClient.CoreV1().PersistentVolumeClaims(ns.Name).Create(pvc)
+	pvc, err = k8sClient.CoreV1().PersistentVolumeClaims(ns.Name).Create(context.TODO(), pvc, metav1.CreateOptions{})
 	Expect(err).ToNot(HaveOccurred())
 
 	Eventually(func() corev1.PersistentVolumeClaimPhase {
-		pvc, err = k8sClient.CoreV1().PersistentVolumeClaims(ns.Name).Get(pvc.Name, metav1.GetOptions{})
+		pvc, err = k8sClient.CoreV1().PersistentVolumeClaims(ns.Name).Get(context.TODO(), pvc.Name, metav1.GetOptions{})
 		Expect(err).ToNot(HaveOccurred())
 		return pvc.Status.Phase
 	}, 90*time.Second, 1*time.Second).Should(BeEquivalentTo(corev1.ClaimBound))
 
-	pvs, err := k8sClient.CoreV1().PersistentVolumes().List(metav1.ListOptions{})
+	pvs, err := k8sClient.CoreV1().PersistentVolumes().List(context.TODO(), metav1.ListOptions{})
 	Expect(err).ToNot(HaveOccurred())
 	hostpathPVs := getHostpathPVs(pvs.Items)
 	Expect(pvc.Spec.VolumeName).To(Equal(hostpathPVs[0].Name))
@@ -60,17 +61,17 @@ func TestCreatePVCWaitForConsumer(t *testing.T) {
 		// Cleanup
 		if pvc != nil {
 			t.Logf("Removing PVC: %s", pvc.Name)
-			err := k8sClient.CoreV1().PersistentVolumeClaims(ns.Name).Delete(pvc.Name, &metav1.DeleteOptions{})
+			err := k8sClient.CoreV1().PersistentVolumeClaims(ns.Name).Delete(context.TODO(), pvc.Name, metav1.DeleteOptions{})
 			Expect(err).ToNot(HaveOccurred())
 		}
 	}()
 
 	t.Logf("Creating PVC: %s", pvc.Name)
-	pvc, err := k8sClient.CoreV1().PersistentVolumeClaims(ns.Name).Create(pvc)
+	pvc, err := k8sClient.CoreV1().PersistentVolumeClaims(ns.Name).Create(context.TODO(), pvc, metav1.CreateOptions{})
 	Expect(err).ToNot(HaveOccurred())
 
 	Eventually(func() corev1.PersistentVolumeClaimPhase {
-		pvc, err = k8sClient.CoreV1().PersistentVolumeClaims(ns.Name).Get(pvc.Name, metav1.GetOptions{})
+		pvc, err = k8sClient.CoreV1().PersistentVolumeClaims(ns.Name).Get(context.TODO(), pvc.Name, metav1.GetOptions{})
 		Expect(err).ToNot(HaveOccurred())
 		return pvc.Status.Phase
 	}, 90*time.Second, 1*time.Second).Should(BeEquivalentTo(corev1.ClaimPending))
@@ -78,23 +79,23 @@ func TestCreatePVCWaitForConsumer(t *testing.T) {
 	Expect(pvc.Spec.VolumeName).To(BeEmpty())
 
 	pod := createPodUsingPVC(ns.Name, pvc, annotations)
-	pod, err = k8sClient.CoreV1().Pods(ns.Name).Create(pod)
+	pod, err = k8sClient.CoreV1().Pods(ns.Name).Create(context.TODO(), pod, metav1.CreateOptions{})
 	Expect(err).ToNot(HaveOccurred())
 	Eventually(func() corev1.PodPhase {
-		pod, err = k8sClient.CoreV1().Pods(ns.Name).Get(pod.Name, metav1.GetOptions{})
+		pod, err = k8sClient.CoreV1().Pods(ns.Name).Get(context.TODO(), pod.Name, metav1.GetOptions{})
 		Expect(err).ToNot(HaveOccurred())
 		return pod.Status.Phase
 	}, 90*time.Second, 1*time.Second).Should(BeEquivalentTo(corev1.PodRunning))
 
 	// Verify that the PVC is now Bound
 	t.Logf("Creating POD %s that uses PVC %s", pod.Name, pvc.Name)
-	pvc, err = k8sClient.CoreV1().PersistentVolumeClaims(ns.Name).Get(pvc.Name, metav1.GetOptions{})
+	pvc, err = k8sClient.CoreV1().PersistentVolumeClaims(ns.Name).Get(context.TODO(), pvc.Name, metav1.GetOptions{})
 	Expect(err).ToNot(HaveOccurred())
 	defer func() {
 		// Cleanup
 		if pod != nil {
 			t.Logf("Removing Pod: %s", pod.Name)
-			err := k8sClient.CoreV1().Pods(ns.Name).Delete(pod.Name, &metav1.DeleteOptions{})
+			err := k8sClient.CoreV1().Pods(ns.Name).Delete(context.TODO(), pod.Name, metav1.DeleteOptions{})
 			Expect(err).ToNot(HaveOccurred())
 		}
 	}()
@@ -112,7 +113,7 @@ func createPVCDef(namespace, storageClassName string, annotations map[string]str
 			AccessModes: []corev1.PersistentVolumeAccessMode{
 				corev1.ReadWriteOnce,
 			},
-			Resources: corev1.ResourceRequirements{
+			Resources: corev1.VolumeResourceRequirements{
 				Requests: corev1.ResourceList{
 					corev1.ResourceName(corev1.ResourceStorage): resource.MustParse("200Mi"),
 				},
@@ -1,6 +1,7 @@
 package tests
 
 import (
+	"context"
 	"testing"
 
 	. "github.com/onsi/gomega"
@@ -13,7 +14,7 @@ func TestSCExists(t *testing.T) {
 	tearDown, k8sClient := setupTestCase(t)
 	defer tearDown(t)
 
-	sc, err := k8sClient.StorageV1().StorageClasses().Get("hostpath-provisioner", metav1.GetOptions{})
+	sc, err := k8sClient.StorageV1().StorageClasses().Get(context.TODO(), "hostpath-provisioner", metav1.GetOptions{})
 	Expect(err).ToNot(HaveOccurred())
 	Expect(sc.Name).To(Equal("hostpath-provisioner"))
 }